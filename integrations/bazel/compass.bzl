@@ -0,0 +1,108 @@
+"""An aspect that runs compass over rules_go targets.
+
+Each go_library, go_binary and go_test gets its own action, which lists
+the target's sources, import path, build tags and platform for
+`compass check --package-list-from-file` and writes a JSON report to the
+`compass` output group. The action only reads the target's sources and
+the config, so it is cached and can run remotely like any other.
+
+Define the aspect in a .bzl file of your own, with the compass binary
+your workspace provides, such as an http_file of a release:
+
+    load("@compass//integrations/bazel:compass.bzl", "compass_aspect_with")
+
+    compass_aspect = compass_aspect_with(
+        compass = "@compass_linux_amd64//file",
+        config = "//tools:compass.toml",
+    )
+
+and apply it to the targets to analyze:
+
+    bazel build //... --aspects=//tools:compass.bzl%compass_aspect --output_groups=compass
+"""
+
+load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
+
+_GO_RULES = ["go_library", "go_binary", "go_test"]
+
+_GO_TOOLCHAIN = "@io_bazel_rules_go//go:toolchain"
+
+def _package(ctx, srcs):
+    package = {"srcs": [src.path for src in srcs]}
+    importpath = getattr(ctx.rule.attr, "importpath", "")
+    if importpath:
+        package["importpath"] = importpath
+    tags = ctx.attr._go_tags[BuildSettingInfo].value
+    if tags:
+        package["tags"] = tags
+    go = ctx.toolchains[_GO_TOOLCHAIN]
+    if go:
+        package["goos"] = go.default_goos
+        package["goarch"] = go.default_goarch
+        version = getattr(go.sdk, "version", "")
+        if version:
+            # `go1.22.3` as a `go` directive: `1.22`.
+            package["go_version"] = ".".join(version.removeprefix("go").split(".")[:2])
+    return package
+
+def _compass_aspect_impl(target, ctx):
+    if ctx.rule.kind not in _GO_RULES:
+        return []
+    srcs = [src for src in ctx.rule.files.srcs if src.extension == "go"]
+    if not srcs:
+        return []
+
+    name = "{}.compass".format(target.label.name)
+    package_list = ctx.actions.declare_file(name + ".packages.json")
+    ctx.actions.write(package_list, json.encode({"packages": [_package(ctx, srcs)]}))
+
+    report = ctx.actions.declare_file(name + ".json")
+    compass = ctx.executable._compass
+    inputs = srcs + [package_list]
+    config = ""
+    if ctx.file._config:
+        inputs.append(ctx.file._config)
+        config = ctx.file._config.path
+
+    # With no --fail-on compass exits 0 whatever it finds, so findings
+    # don't fail the build; run with --fail-on in a test for that.
+    ctx.actions.run_shell(
+        inputs = inputs,
+        outputs = [report],
+        tools = [compass],
+        command = '"$1" check --package-list-from-file "$2" --format json --jobs 1 $3 > "$4"',
+        arguments = [compass.path, package_list.path, config, report.path],
+        mnemonic = "Compass",
+        progress_message = "Analyzing %{label} with compass",
+    )
+    return [OutputGroupInfo(compass = depset([report]))]
+
+def compass_aspect_with(compass, config = None):
+    """An aspect that runs the given compass binary, with an optional config.
+
+    Args:
+      compass: the label of the compass executable.
+      config: the label of a rule config, or None for compass's own rules.
+
+    Returns:
+      The aspect.
+    """
+    return aspect(
+        implementation = _compass_aspect_impl,
+        attrs = {
+            "_compass": attr.label(
+                default = compass,
+                executable = True,
+                cfg = "exec",
+            ),
+            "_config": attr.label(
+                default = config,
+                allow_single_file = True,
+            ),
+            "_go_tags": attr.label(
+                default = "@io_bazel_rules_go//go/config:tags",
+                providers = [BuildSettingInfo],
+            ),
+        },
+        toolchains = [config_common.toolchain_type(_GO_TOOLCHAIN, mandatory = False)],
+    )
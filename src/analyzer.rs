@@ -1,27 +1,131 @@
+use crate::checks::{Granularity, RuleOptions};
+use crate::compare::Status;
+use crate::fingerprint::content_hash;
+use crate::fix::{Fix, FixTemplate};
+use crate::incremental::{Declaration, Snapshot, Start};
+use crate::messages;
+use crate::package::Package;
+use crate::plugin::Registry;
+use crate::suppression;
+use serde::{Deserialize, Serialize};
 use serde_json::{json, Value};
-use tree_sitter::{Language, Parser, Query, QueryCursor, StreamingIterator};
+use std::time::{Duration, Instant};
+use tree_sitter::{Language, Node, Parser, Query, QueryCursor, StreamingIterator};
 
-#[derive(Debug, Clone)]
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
 pub struct AnalysisResult {
     pub rule_name: String,
     pub severity: Severity,
     pub message: String,
     pub line: usize,
     pub column: usize,
+    pub end_line: usize,
+    pub end_column: usize,
+    pub start_byte: usize,
+    pub end_byte: usize,
     pub text: String,
+    pub symbol: Option<String>,
     pub suggestion: Option<String>,
     pub score_impact: f64,
+    pub fix: Option<Fix>,
+    pub related: Vec<RelatedLocation>,
+    #[serde(default)]
+    pub confidence: Confidence,
+    /// The `GOOS/GOARCH` builds the finding occurs in, with `--platforms`
+    /// or `--build-tags`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub platforms: Vec<String>,
+    /// Where to read more about fixing the finding.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub url: Option<String>,
+    /// Whether an earlier run had the finding, with `--compare-to`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub status: Option<Status>,
+    /// The rule's stage, so findings of experimental and deprecated rules
+    /// can be told apart.
+    #[serde(default, skip_serializing_if = "Stage::is_stable")]
+    pub stage: Stage,
 }
 
-#[derive(Debug, Clone)]
+/// A secondary location that helps explain a finding, such as the
+/// assignment that overwrote an unchecked error.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct RelatedLocation {
+    /// The file, when it isn't the finding's own, such as another file of
+    /// the package.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub file: Option<String>,
+    pub message: String,
+    pub line: usize,
+    pub column: usize,
+    pub end_line: usize,
+    pub end_column: usize,
+    pub start_byte: usize,
+    pub end_byte: usize,
+}
+
+impl RelatedLocation {
+    pub fn new(node: Node, message: String) -> Self {
+        let start = node.start_position();
+        let end = node.end_position();
+        RelatedLocation {
+            file: None,
+            message,
+            line: start.row + 1,
+            column: start.column + 1,
+            end_line: end.row + 1,
+            end_column: end.column + 1,
+            start_byte: node.start_byte(),
+            end_byte: node.end_byte(),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
 pub enum Severity {
     Error,
     Warning,
+    #[default]
     Info,
     Style,
 }
 
 impl Severity {
+    pub const NAMES: &'static str = "error, warning, info, style";
+
+    pub fn from_name(name: &str) -> Option<Self> {
+        match name.to_lowercase().as_str() {
+            "error" => Some(Severity::Error),
+            "warning" => Some(Severity::Warning),
+            "info" => Some(Severity::Info),
+            "style" => Some(Severity::Style),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Severity::Error => "error",
+            Severity::Warning => "warning",
+            Severity::Info => "info",
+            Severity::Style => "style",
+        }
+    }
+
+    /// Whether this severity is `threshold` or more serious.
+    pub fn is_at_least(&self, threshold: Severity) -> bool {
+        self.rank() >= threshold.rank()
+    }
+
+    pub(crate) fn rank(&self) -> u8 {
+        match self {
+            Severity::Error => 3,
+            Severity::Warning => 2,
+            Severity::Info => 1,
+            Severity::Style => 0,
+        }
+    }
+
     pub fn base_score_impact(&self) -> f64 {
         match self {
             Severity::Error => -3.0,
@@ -32,6 +136,102 @@ impl Severity {
     }
 }
 
+/// How sure a rule is of a finding. Rules that pattern-match the source are
+/// sure; ones that guess at types, names or what another file does are less
+/// so, and can be left out of strict gates with `--min-confidence`.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Confidence {
+    #[default]
+    High,
+    Medium,
+    Low,
+}
+
+impl Confidence {
+    pub const NAMES: &'static str = "high, medium, low";
+
+    pub fn from_name(name: &str) -> Option<Self> {
+        match name.to_lowercase().as_str() {
+            "high" => Some(Confidence::High),
+            "medium" => Some(Confidence::Medium),
+            "low" => Some(Confidence::Low),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Confidence::High => "high",
+            Confidence::Medium => "medium",
+            Confidence::Low => "low",
+        }
+    }
+
+    /// Whether this confidence is `threshold` or higher.
+    pub fn is_at_least(&self, threshold: Confidence) -> bool {
+        self.rank() >= threshold.rank()
+    }
+
+    /// The less confident of the two.
+    pub fn lowest(self, other: Confidence) -> Confidence {
+        if self.is_at_least(other) {
+            other
+        } else {
+            self
+        }
+    }
+
+    pub(crate) fn rank(&self) -> u8 {
+        match self {
+            Confidence::High => 2,
+            Confidence::Medium => 1,
+            Confidence::Low => 0,
+        }
+    }
+}
+
+/// Where a rule is in its lifecycle. Experimental rules are off unless
+/// `--enable-experimental` or `experimental = true` in `.compass.toml` turns
+/// them on, or a project config enables one by name; deprecated rules still
+/// run, with a warning pointing to their replacement.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Stage {
+    Experimental,
+    #[default]
+    Stable,
+    Deprecated,
+}
+
+impl Stage {
+    pub const NAMES: &'static str = "experimental, stable, deprecated";
+
+    pub fn from_name(name: &str) -> Option<Self> {
+        match name.to_lowercase().as_str() {
+            "experimental" => Some(Stage::Experimental),
+            "stable" => Some(Stage::Stable),
+            "deprecated" => Some(Stage::Deprecated),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Stage::Experimental => "experimental",
+            Stage::Stable => "stable",
+            Stage::Deprecated => "deprecated",
+        }
+    }
+
+    pub fn is_stable(&self) -> bool {
+        *self == Stage::Stable
+    }
+}
+
+/// Each rule's name with the time it took on one file.
+pub type RuleTimings = Vec<(String, Duration)>;
+
 #[derive(Debug, Clone)]
 pub struct AnalysisRule {
     pub name: String,
@@ -40,6 +240,16 @@ pub struct AnalysisRule {
     pub message_template: String,
     pub suggestion: Option<String>,
     pub weight_multiplier: f64,
+    pub check: Option<String>,
+    pub options: RuleOptions,
+    pub fix: Option<FixTemplate>,
+    /// The most confidence any finding of the rule has; a check can report
+    /// less for a finding.
+    pub confidence: Confidence,
+    /// Rewords each finding; see [`crate::messages`].
+    pub template: Option<String>,
+    pub url: Option<String>,
+    pub stage: Stage,
 }
 
 impl AnalysisRule {
@@ -57,6 +267,13 @@ impl AnalysisRule {
             message_template: message,
             suggestion,
             weight_multiplier: 1.0,
+            check: None,
+            options: RuleOptions::default(),
+            fix: None,
+            confidence: Confidence::High,
+            template: None,
+            url: None,
+            stage: Stage::Stable,
         }
     }
 
@@ -64,6 +281,148 @@ impl AnalysisRule {
         self.weight_multiplier = weight;
         self
     }
+
+    pub fn with_check(mut self, check: Option<String>) -> Self {
+        self.check = check;
+        self
+    }
+
+    pub fn with_options(mut self, options: RuleOptions) -> Self {
+        self.options = options;
+        self
+    }
+
+    pub fn with_fix(mut self, fix: Option<FixTemplate>) -> Self {
+        self.fix = fix;
+        self
+    }
+
+    pub fn with_confidence(mut self, confidence: Confidence) -> Self {
+        self.confidence = confidence;
+        self
+    }
+
+    pub fn with_stage(mut self, stage: Stage) -> Self {
+        self.stage = stage;
+        self
+    }
+
+    pub fn with_messages(mut self, template: Option<String>, url: Option<String>) -> Self {
+        self.template = template;
+        self.url = url;
+        self
+    }
+
+    /// The rule's link with its name filled in.
+    pub fn rendered_url(&self) -> Option<String> {
+        self.url
+            .as_deref()
+            .map(|url| messages::render(url, &[("rule", &self.name)]))
+    }
+
+    /// Applies the rule's template and link to a finding once its own
+    /// message is final.
+    fn reword(&self, result: &mut AnalysisResult) {
+        result.url = self.rendered_url();
+        if self.template.is_none() && !result.suggestion.as_ref().is_some_and(|s| s.contains('{')) {
+            return;
+        }
+        let url = result.url.clone().unwrap_or_default();
+        let symbol = result.symbol.clone().unwrap_or_default();
+        let values = [
+            ("message", result.message.as_str()),
+            ("rule", self.name.as_str()),
+            ("symbol", symbol.as_str()),
+            ("text", result.text.as_str()),
+            ("url", url.as_str()),
+        ];
+        let message = self
+            .template
+            .as_deref()
+            .map(|template| messages::render(template, &values));
+        let suggestion = result
+            .suggestion
+            .as_deref()
+            .map(|suggestion| messages::render(suggestion, &values));
+        if let Some(message) = message {
+            result.message = message;
+        }
+        result.suggestion = suggestion;
+    }
+
+    /// A finding at `start_byte..end_byte` of a file compass doesn't parse,
+    /// such as a `go.mod`, with a message and confidence of its own when
+    /// given, reworded like any other.
+    pub fn result_in_text(
+        &self,
+        source_code: &str,
+        (start_byte, end_byte): (usize, usize),
+        message: Option<String>,
+        confidence: Option<Confidence>,
+    ) -> AnalysisResult {
+        let position = |byte: usize| {
+            let before = &source_code[..byte];
+            let line_start = before.rfind('\n').map_or(0, |newline| newline + 1);
+            (before.matches('\n').count() + 1, byte - line_start + 1)
+        };
+        let (line, column) = position(start_byte);
+        let (end_line, end_column) = position(end_byte);
+        let mut result = AnalysisResult {
+            rule_name: self.name.clone(),
+            severity: self.severity.clone(),
+            message: message.unwrap_or_else(|| self.message_template.clone()),
+            line,
+            column,
+            end_line,
+            end_column,
+            start_byte,
+            end_byte,
+            text: source_code[start_byte..end_byte].to_string(),
+            symbol: None,
+            suggestion: self.suggestion.clone(),
+            score_impact: self.severity.base_score_impact() * self.weight_multiplier,
+            fix: None,
+            related: Vec::new(),
+            confidence: confidence.map_or(self.confidence, |confidence| {
+                self.confidence.lowest(confidence)
+            }),
+            platforms: Vec::new(),
+            url: None,
+            status: None,
+            stage: self.stage,
+        };
+        self.reword(&mut result);
+        result
+    }
+
+    fn result_for(&self, node: Node, source_code: &str, fix: Option<Fix>) -> AnalysisResult {
+        let start = node.start_position();
+        let end = node.end_position();
+        let text = node.utf8_text(source_code.as_bytes()).unwrap_or("");
+
+        AnalysisResult {
+            rule_name: self.name.clone(),
+            severity: self.severity.clone(),
+            message: self.message_template.clone(),
+            line: start.row + 1,
+            column: start.column + 1,
+            end_line: end.row + 1,
+            end_column: end.column + 1,
+            start_byte: node.start_byte(),
+            end_byte: node.end_byte(),
+            text: text.to_string(),
+            symbol: enclosing_symbol(node, source_code),
+            suggestion: self.suggestion.clone(),
+            score_impact: self.severity.base_score_impact() * self.weight_multiplier,
+            fix,
+            related: Vec::new(),
+            confidence: self.confidence,
+            platforms: Vec::new(),
+            url: None,
+            status: None,
+            stage: self.stage,
+        }
+    }
 }
 
 #[derive(Debug, Clone)]
@@ -91,11 +450,33 @@ pub struct ScoreBreakdown {
 
 pub struct CodeAnalyzer {
     rules: Vec<AnalysisRule>,
+    registry: Registry,
+    report_unused_suppressions: bool,
+    min_confidence: Confidence,
 }
 
 impl CodeAnalyzer {
     pub fn new() -> Self {
-        CodeAnalyzer { rules: Vec::new() }
+        CodeAnalyzer {
+            rules: Vec::new(),
+            registry: Registry::new(),
+            report_unused_suppressions: true,
+            min_confidence: Confidence::Low,
+        }
+    }
+
+    pub fn set_registry(&mut self, registry: Registry) {
+        self.registry = registry;
+    }
+
+    pub fn set_report_unused_suppressions(&mut self, enabled: bool) {
+        self.report_unused_suppressions = enabled;
+    }
+
+    /// Leaves out findings less confident than `confidence`. Suppressions
+    /// still match them, so they aren't reported as unused.
+    pub fn set_min_confidence(&mut self, confidence: Confidence) {
+        self.min_confidence = confidence;
     }
 
     pub fn add_rule(&mut self, rule: AnalysisRule) {
@@ -106,47 +487,305 @@ impl CodeAnalyzer {
         !self.rules.is_empty()
     }
 
+    pub fn rules(&self) -> &[AnalysisRule] {
+        &self.rules
+    }
+
+    fn rule_names(&self) -> Vec<&str> {
+        self.rules.iter().map(|rule| rule.name.as_str()).collect()
+    }
+
     pub fn analyze(
         &self,
         source_code: &str,
         language: &Language,
     ) -> Result<Vec<AnalysisResult>, Box<dyn std::error::Error>> {
+        self.analyze_in_package(source_code, language, None)
+    }
+
+    /// Whether any rule's check reads the rest of the package, so callers
+    /// know to load one for [`CodeAnalyzer::analyze_in_package`].
+    pub fn reads_package(&self) -> bool {
+        self.rules.iter().any(|rule| {
+            rule.check
+                .as_deref()
+                .and_then(|name| self.registry.get(name))
+                .is_some_and(|check| check.reads_package())
+        })
+    }
+
+    /// Whether any rule's check reads the module's call graph, whose results
+    /// depend on more than the package.
+    pub fn reads_call_graph(&self) -> bool {
+        self.rules.iter().any(|rule| {
+            rule.check
+                .as_deref()
+                .and_then(|name| self.registry.get(name))
+                .is_some_and(|check| check.reads_call_graph())
+        })
+    }
+
+    /// Analyzes `source_code` with its package's other files available to
+    /// the checks that read them. Without a package those checks report
+    /// nothing they can't tell from the file alone.
+    pub fn analyze_in_package(
+        &self,
+        source_code: &str,
+        language: &Language,
+        package: Option<&Package>,
+    ) -> Result<Vec<AnalysisResult>, Box<dyn std::error::Error>> {
+        self.analyze_timed(source_code, language, package)
+            .map(|(results, _)| results)
+    }
+
+    /// Like [`CodeAnalyzer::analyze_in_package`], also returning how long
+    /// each rule took, in rule order. A rule that builds the call graph is
+    /// charged for it.
+    pub fn analyze_timed(
+        &self,
+        source_code: &str,
+        language: &Language,
+        package: Option<&Package>,
+    ) -> Result<(Vec<AnalysisResult>, RuleTimings), Box<dyn std::error::Error>> {
         let mut parser = Parser::new();
         parser.set_language(language)?;
 
         let tree = parser.parse(source_code, None).unwrap();
+        let root = tree.root_node();
+        let suppressions = suppression::from_tree(root, source_code)?;
         let mut results = Vec::new();
+        let mut timings = Vec::with_capacity(self.rules.len());
 
         for rule in &self.rules {
-            let query = Query::new(language, &rule.query)?;
-            let mut cursor = QueryCursor::new();
-
-            let mut matches = cursor.matches(&query, tree.root_node(), source_code.as_bytes());
-            while let Some(match_) = matches.next() {
-                for capture in match_.captures {
-                    let node = capture.node;
-                    let start = node.start_position();
-                    let text = node.utf8_text(source_code.as_bytes()).unwrap_or("");
-
-                    let score_impact = rule.severity.base_score_impact() * rule.weight_multiplier;
-
-                    results.push(AnalysisResult {
-                        rule_name: rule.name.clone(),
-                        severity: rule.severity.clone(),
-                        message: rule.message_template.clone(),
-                        line: start.row + 1,
-                        column: start.column + 1,
-                        text: text.to_string(),
-                        suggestion: rule.suggestion.clone(),
-                        score_impact,
-                    });
+            let started = Instant::now();
+            self.run_rule(rule, root, source_code, language, package, &mut results)?;
+            timings.push((rule.name.clone(), started.elapsed()));
+        }
+
+        let mut results = suppression::apply(
+            results,
+            &suppressions,
+            &self.rule_names(),
+            self.report_unused_suppressions,
+        );
+        results.retain(|result| result.confidence.is_at_least(self.min_confidence));
+        Ok((results, timings))
+    }
+
+    /// Like [`CodeAnalyzer::analyze_in_package`], reusing what `previous`,
+    /// the snapshot of an earlier analysis of the file, found in the
+    /// declarations that haven't changed since. Returns the snapshot to pass
+    /// next time; see [`crate::incremental`].
+    pub fn analyze_incremental(
+        &self,
+        source_code: &str,
+        language: &Language,
+        package: Option<&Package>,
+        previous: Option<Snapshot>,
+    ) -> Result<(Vec<AnalysisResult>, Snapshot), Box<dyn std::error::Error>> {
+        let mut parser = Parser::new();
+        parser.set_language(language)?;
+
+        let tree = parser.parse(source_code, None).unwrap();
+        let root = tree.root_node();
+        let suppressions = suppression::from_tree(root, source_code)?;
+        let granularities: Vec<Granularity> = self
+            .rules
+            .iter()
+            .map(|rule| self.granularity(rule))
+            .collect();
+        let context = self.snapshot_context(&granularities, package);
+        let mut previous = previous.filter(|snapshot| snapshot.context == context);
+
+        let mut declarations = Vec::new();
+        let mut cursor = root.walk();
+        for node in root.named_children(&mut cursor) {
+            let hash = content_hash(&[node.utf8_text(source_code.as_bytes()).unwrap_or("")]);
+            let start = Start::of(node);
+            let reused = previous
+                .as_mut()
+                .and_then(|snapshot| snapshot.take(&hash, start));
+            let results = match reused {
+                Some(results) => results,
+                None => {
+                    let mut found = Vec::with_capacity(self.rules.len());
+                    for (rule, granularity) in self.rules.iter().zip(&granularities) {
+                        let mut results = Vec::new();
+                        if *granularity == Granularity::Declaration {
+                            self.run_rule(
+                                rule,
+                                node,
+                                source_code,
+                                language,
+                                package,
+                                &mut results,
+                            )?;
+                        }
+                        found.push(results);
+                    }
+                    found
+                }
+            };
+            declarations.push(Declaration {
+                hash,
+                start,
+                results,
+            });
+        }
+
+        let mut results = Vec::new();
+        for (index, rule) in self.rules.iter().enumerate() {
+            match granularities[index] {
+                Granularity::Declaration => results.extend(
+                    declarations
+                        .iter()
+                        .flat_map(|declaration| declaration.results[index].iter().cloned()),
+                ),
+                Granularity::File => {
+                    self.run_rule(rule, root, source_code, language, package, &mut results)?
                 }
             }
         }
 
+        let mut results = suppression::apply(
+            results,
+            &suppressions,
+            &self.rule_names(),
+            self.report_unused_suppressions,
+        );
+        results.retain(|result| result.confidence.is_at_least(self.min_confidence));
+        Ok((
+            results,
+            Snapshot {
+                context,
+                declarations,
+            },
+        ))
+    }
+
+    /// Query rules and unknown checks read the whole file.
+    fn granularity(&self, rule: &AnalysisRule) -> Granularity {
+        rule.check
+            .as_deref()
+            .and_then(|name| self.registry.get(name))
+            .map_or(Granularity::File, |check| check.granularity())
+    }
+
+    /// What the findings of a snapshot's declarations depend on besides
+    /// their text: the rules, and the package if a reused check reads it.
+    fn snapshot_context(&self, granularities: &[Granularity], package: Option<&Package>) -> String {
+        let rules = format!("{:?}", self.rules);
+        let reads_package = self
+            .rules
+            .iter()
+            .zip(granularities)
+            .any(|(rule, granularity)| {
+                *granularity == Granularity::Declaration
+                    && rule
+                        .check
+                        .as_deref()
+                        .and_then(|name| self.registry.get(name))
+                        .is_some_and(|check| check.reads_package())
+            });
+        let mut parts = vec![rules];
+        if reads_package {
+            parts.extend(package.map(Package::contents).unwrap_or_default());
+        }
+        content_hash(&parts.iter().map(String::as_str).collect::<Vec<_>>())
+    }
+
+    /// Applies the `//compass:disable` comments of a file compass doesn't
+    /// parse, and the minimum confidence, to results made with
+    /// [`AnalysisRule::result_in_text`].
+    pub fn finish_text_results(
+        &self,
+        source_code: &str,
+        results: Vec<AnalysisResult>,
+    ) -> Result<Vec<AnalysisResult>, Box<dyn std::error::Error>> {
+        let suppressions = suppression::parse(source_code)?;
+        let mut results = suppression::apply(
+            results,
+            &suppressions,
+            &self.rule_names(),
+            self.report_unused_suppressions,
+        );
+        results.retain(|result| result.confidence.is_at_least(self.min_confidence));
         Ok(results)
     }
 
+    fn run_rule(
+        &self,
+        rule: &AnalysisRule,
+        root: Node,
+        source_code: &str,
+        language: &Language,
+        package: Option<&Package>,
+        results: &mut Vec<AnalysisResult>,
+    ) -> Result<(), Box<dyn std::error::Error>> {
+        if let Some(check_name) = &rule.check {
+            let check = self.registry.get(check_name).ok_or_else(|| {
+                format!(
+                    "rule '{}' references unknown check '{}'",
+                    rule.name, check_name
+                )
+            })?;
+            for hit in check.run_in_package(root, source_code, &rule.options, package) {
+                let mut result = rule.result_for(hit.node, source_code, hit.fix);
+                if let Some(message) = hit.message {
+                    result.message = message;
+                }
+                if let Some(confidence) = hit.confidence {
+                    result.confidence = result.confidence.lowest(confidence);
+                }
+                if let Some(suggestion) = hit.suggestion {
+                    result.suggestion = Some(suggestion);
+                }
+                result.related = hit
+                    .related
+                    .into_iter()
+                    .map(|(node, message)| RelatedLocation::new(node, message))
+                    .chain(hit.locations)
+                    .collect();
+                rule.reword(&mut result);
+                results.push(result);
+            }
+            return Ok(());
+        }
+
+        let query = Query::new(language, &rule.query)?;
+        let capture_names = query.capture_names();
+        let fix_capture = rule.fix.as_ref().and_then(|fix| {
+            fix.capture
+                .as_deref()
+                .or_else(|| capture_names.last().copied())
+        });
+        let mut cursor = QueryCursor::new();
+
+        let mut matches = cursor.matches(&query, root, source_code.as_bytes());
+        while let Some(match_) = matches.next() {
+            for capture in match_.captures {
+                let capture_name = capture_names[capture.index as usize];
+                // Underscore captures only feed predicates and are never reported.
+                if capture_name.starts_with('_') {
+                    continue;
+                }
+
+                let node = capture.node;
+                let fix = match (&rule.fix, fix_capture) {
+                    (Some(template), Some(name)) if name == capture_name => {
+                        let text = node.utf8_text(source_code.as_bytes()).unwrap_or("");
+                        Some(template.instantiate(node.start_byte(), node.end_byte(), text))
+                    }
+                    _ => None,
+                };
+                let mut result = rule.result_for(node, source_code, fix);
+                rule.reword(&mut result);
+                results.push(result);
+            }
+        }
+        Ok(())
+    }
+
     pub fn analyze_with_score(
         &self,
         source_code: &str,
@@ -157,7 +796,7 @@ impl CodeAnalyzer {
         Ok((results, score))
     }
 
-    fn calculate_score(&self, results: &[AnalysisResult], source_code: &str) -> CodeScore {
+    pub fn calculate_score(&self, results: &[AnalysisResult], source_code: &str) -> CodeScore {
         let base_score = 10.0;
         let line_count = source_code.lines().count();
 
@@ -276,16 +915,72 @@ impl CodeAnalyzer {
                 },
                 "size_bonus": score.breakdown.size_bonus
             },
-            "issues": results.iter().map(|r| json!({
-                "rule": r.rule_name,
-                "severity": format!("{:?}", r.severity),
-                "message": r.message,
-                "line": r.line,
-                "column": r.column,
-                "text": r.text,
-                "suggestion": r.suggestion,
-                "score_impact": r.score_impact
-            })).collect::<Vec<_>>()
+            "issues": results.iter().map(|r| {
+                let mut issue = json!({
+                    "rule": r.rule_name,
+                    "severity": format!("{:?}", r.severity),
+                    "confidence": r.confidence.as_str(),
+                    "message": r.message,
+                    "line": r.line,
+                    "column": r.column,
+                    "text": r.text,
+                    "suggestion": r.suggestion,
+                    "score_impact": r.score_impact,
+                    "fix": r.fix.as_ref().map(|f| f.description.clone())
+                });
+                if !r.platforms.is_empty() {
+                    issue["platforms"] = json!(r.platforms);
+                }
+                if let Some(url) = &r.url {
+                    issue["url"] = json!(url);
+                }
+                if let Some(status) = r.status {
+                    issue["status"] = json!(status.as_str());
+                }
+                if !r.stage.is_stable() {
+                    issue["stage"] = json!(r.stage.as_str());
+                }
+                issue
+            }).collect::<Vec<_>>()
         })
     }
 }
+
+/// Names the closest enclosing function, method or type declaration of `node`.
+fn enclosing_symbol(node: Node, source_code: &str) -> Option<String> {
+    let mut current = node.parent();
+    while let Some(candidate) = current {
+        let kind = candidate.kind();
+        let is_declaration = kind.ends_with("_declaration")
+            || kind.ends_with("_definition")
+            || kind.ends_with("_item");
+        if is_declaration {
+            if let Some(name) = declaration_name(candidate, source_code) {
+                return Some(name);
+            }
+        }
+        current = candidate.parent();
+    }
+    None
+}
+
+fn declaration_name(node: Node, source_code: &str) -> Option<String> {
+    declaration_name_node(node)?
+        .utf8_text(source_code.as_bytes())
+        .ok()
+        .map(str::to_string)
+}
+
+/// The node naming a declaration, if it has one.
+pub(crate) fn declaration_name_node(node: Node) -> Option<Node> {
+    if let Some(name) = node.child_by_field_name("name") {
+        return Some(name);
+    }
+
+    // C and C++ nest the identifier inside one or more declarators.
+    let mut declarator = node.child_by_field_name("declarator")?;
+    while let Some(inner) = declarator.child_by_field_name("declarator") {
+        declarator = inner;
+    }
+    Some(declarator)
+}
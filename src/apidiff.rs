@@ -0,0 +1,707 @@
+//! `compass apidiff`: the exported API of a Go module, and the changes
+//! between two versions of it that break importers.
+//!
+//! The API is read from the syntax alone, one package per directory:
+//! exported functions, types, methods of exported types, struct fields,
+//! interface methods, constants and variables. Signatures are compared
+//! without parameter names, so renaming a parameter isn't a change. Tests,
+//! `main` packages, nested modules and anything under `internal/`,
+//! `testdata/` or `vendor/` are not part of the API.
+//!
+//! Removing a symbol or changing its signature breaks importers. So does a
+//! new method on an interface, because types outside the package that
+//! implemented it no longer do, unless an unexported method already keeps
+//! them from implementing it. Everything else new is an addition.
+//!
+//! The base is a git revision, read with `git show` without touching the
+//! working tree, or a published version of the module in the module cache.
+//! Nothing is downloaded: run `go mod download <module>@<version>` first.
+
+use crate::checks::{is_exported, node_text, receiver_type};
+use crate::diff::git_in;
+use crate::language::SupportedLanguage;
+use crate::module::{self, Module, GO_MOD_FILE};
+use serde::Serialize;
+use std::collections::{BTreeMap, BTreeSet};
+use std::fs;
+use std::path::{Path, PathBuf};
+use tree_sitter::{Node, Parser};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Kind {
+    Func,
+    Method,
+    Type,
+    Field,
+    InterfaceMethod,
+    Const,
+    Var,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Symbol {
+    pub kind: Kind,
+    /// The declaration without names, such as `func(string, ...Option)
+    /// (*DB, error)` or `struct`.
+    pub signature: String,
+}
+
+/// The exported declarations of one package, keyed by name, with
+/// `Type.Method` and `Type.Field` for members.
+#[derive(Debug, Clone, Default)]
+pub struct Package {
+    pub import_path: String,
+    pub symbols: BTreeMap<String, Symbol>,
+    /// Interfaces with an unexported method, which only the package can
+    /// implement.
+    pub sealed: BTreeSet<String>,
+}
+
+/// A module's API, by package directory relative to the module root.
+#[derive(Debug, Default)]
+pub struct Api {
+    pub packages: BTreeMap<String, Package>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ChangeKind {
+    Removed,
+    Changed,
+    Added,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Change {
+    pub package: String,
+    /// `None` when the whole package was added or removed.
+    pub symbol: Option<String>,
+    pub kind: ChangeKind,
+    pub breaking: bool,
+    pub message: String,
+}
+
+impl Api {
+    /// The API of the module at `root` as it is on disk.
+    pub fn read_dir(root: &Path) -> Result<Api, String> {
+        let mut files = Vec::new();
+        let mut pending = vec![PathBuf::new()];
+        while let Some(relative) = pending.pop() {
+            let dir = root.join(&relative);
+            let entries = fs::read_dir(&dir)
+                .map_err(|e| format!("failed to read '{}': {}", dir.display(), e))?;
+            for entry in entries.flatten() {
+                let name = entry.file_name().to_string_lossy().into_owned();
+                let path = relative.join(&name);
+                if entry.path().is_dir() {
+                    if !skipped_dir(&name) {
+                        pending.push(path);
+                    }
+                } else if name.ends_with(".go") || name == GO_MOD_FILE {
+                    let source_code = fs::read_to_string(entry.path()).map_err(|e| {
+                        format!("failed to read '{}': {}", entry.path().display(), e)
+                    })?;
+                    files.push((slashed(&path), source_code));
+                }
+            }
+        }
+        files.sort();
+        Ok(Api::from_files(&files))
+    }
+
+    /// The API of the module at `root` as of git revision `rev`.
+    pub fn read_git(root: &Path, rev: &str) -> Result<Api, String> {
+        let listing = git_in(root, &["ls-tree", "-r", "--name-only", rev, "--", "."])?;
+        let mut files = Vec::new();
+        for path in listing.lines() {
+            let name = path.rsplit('/').next().unwrap_or(path);
+            let wanted = name.ends_with(".go") || name == GO_MOD_FILE;
+            if !wanted || path.split('/').any(skipped_dir) {
+                continue;
+            }
+            let source_code = git_in(root, &["show", &format!("{}:./{}", rev, path)])?;
+            files.push((path.to_string(), source_code));
+        }
+        Ok(Api::from_files(&files))
+    }
+
+    /// The API of `module` as of `base`: a git revision if git knows one by
+    /// that name, otherwise that version of the module in the module cache.
+    pub fn read_base(module: &Module, base: &str) -> Result<Api, String> {
+        let commit = format!("{}^{{commit}}", base);
+        if git_in(&module.root, &["rev-parse", "--verify", "--quiet", &commit]).is_ok() {
+            return Api::read_git(&module.root, base);
+        }
+        match module::published_dir(&module.path, base).filter(|dir| dir.is_dir()) {
+            Some(dir) => Api::read_dir(&dir),
+            None => Err(format!(
+                "`{}` is neither a git revision nor in the module cache; run `go mod download {}@{}` first",
+                base, module.path, base
+            )),
+        }
+    }
+
+    /// The API declared by `files`, given as paths relative to the module
+    /// root with their contents. A `go.mod` at the root names the packages.
+    pub fn from_files(files: &[(String, String)]) -> Api {
+        let module_path = files
+            .iter()
+            .find(|(path, _)| path == GO_MOD_FILE)
+            .map(|(_, go_mod)| Module::parse(PathBuf::new(), go_mod.clone()).path)
+            .unwrap_or_default();
+        let nested: Vec<&str> = files
+            .iter()
+            .filter_map(|(path, _)| path.strip_suffix(&format!("/{}", GO_MOD_FILE)))
+            .collect();
+
+        let mut parser = Parser::new();
+        let mut api = Api::default();
+        if parser
+            .set_language(&SupportedLanguage::Go.tree_sitter_language())
+            .is_err()
+        {
+            return api;
+        }
+        let mut commands = BTreeSet::new();
+        for (path, source_code) in files {
+            let (dir, name) = path.rsplit_once('/').unwrap_or(("", path));
+            let outside = nested
+                .iter()
+                .any(|root| dir == *root || dir.starts_with(&format!("{}/", root)));
+            if !name.ends_with(".go")
+                || name.ends_with("_test.go")
+                || outside
+                || dir.split('/').any(|part| part == "internal")
+            {
+                continue;
+            }
+            let Some(tree) = parser.parse(source_code, None) else {
+                continue;
+            };
+            let package = api
+                .packages
+                .entry(dir.to_string())
+                .or_insert_with(|| Package {
+                    import_path: match dir {
+                        "" => module_path.clone(),
+                        _ if module_path.is_empty() => dir.to_string(),
+                        _ => format!("{}/{}", module_path, dir),
+                    },
+                    ..Package::default()
+                });
+            if collect(tree.root_node(), source_code, package) == Some("main") {
+                commands.insert(dir.to_string());
+            }
+        }
+        for dir in commands {
+            api.packages.remove(&dir);
+        }
+        api
+    }
+}
+
+/// The changes from `base` to `head`, package by package: removals and
+/// changes in `base`'s order, then additions.
+pub fn compare(base: &Api, head: &Api) -> Vec<Change> {
+    let mut changes = Vec::new();
+    for (dir, old) in &base.packages {
+        let Some(new) = head.packages.get(dir) else {
+            changes.push(Change {
+                package: old.import_path.clone(),
+                symbol: None,
+                kind: ChangeKind::Removed,
+                breaking: true,
+                message: format!("package `{}` was removed", old.import_path),
+            });
+            continue;
+        };
+        let change = |name: &str, kind, breaking, message| Change {
+            package: new.import_path.clone(),
+            symbol: Some(name.to_string()),
+            kind,
+            breaking,
+            message,
+        };
+        for (name, symbol) in &old.symbols {
+            // Members go with their type.
+            let owner = name.split_once('.').map(|(owner, _)| owner);
+            if owner.is_some_and(|owner| !new.symbols.contains_key(owner)) {
+                continue;
+            }
+            match new.symbols.get(name) {
+                None => changes.push(change(
+                    name,
+                    ChangeKind::Removed,
+                    true,
+                    format!("`{}` was removed", name),
+                )),
+                Some(now) if now.signature != symbol.signature && !unknown(symbol, now) => changes
+                    .push(change(
+                        name,
+                        ChangeKind::Changed,
+                        true,
+                        format!(
+                            "`{}` changed from `{}` to `{}`",
+                            name, symbol.signature, now.signature
+                        ),
+                    )),
+                Some(_) => {}
+            }
+        }
+        for (name, symbol) in &new.symbols {
+            if old.symbols.contains_key(name) {
+                continue;
+            }
+            let owner = name.split_once('.').map(|(owner, _)| owner);
+            if owner.is_some_and(|owner| !old.symbols.contains_key(owner)) {
+                continue;
+            }
+            let narrowed = symbol.kind == Kind::InterfaceMethod
+                && owner.is_some_and(|owner| !new.sealed.contains(owner));
+            let message = match (narrowed, name.split_once('.')) {
+                (true, Some((owner, method))) => format!(
+                    "`{}` has a new method `{}`, so types outside the package that implement it no longer do",
+                    owner, method
+                ),
+                _ => format!("`{}` was added", name),
+            };
+            changes.push(change(name, ChangeKind::Added, narrowed, message));
+        }
+    }
+    for (dir, new) in &head.packages {
+        if !base.packages.contains_key(dir) {
+            changes.push(Change {
+                package: new.import_path.clone(),
+                symbol: None,
+                kind: ChangeKind::Added,
+                breaking: false,
+                message: format!("package `{}` was added", new.import_path),
+            });
+        }
+    }
+    changes
+}
+
+/// A plain-text report, breaking changes first.
+pub fn report(base: &str, changes: &[Change]) -> String {
+    let (breaking, additions): (Vec<&Change>, Vec<&Change>) =
+        changes.iter().partition(|change| change.breaking);
+    let mut out = String::new();
+    if breaking.is_empty() {
+        out.push_str(&format!("No breaking changes since {}\n", base));
+    } else {
+        out.push_str(&format!(
+            "{} breaking change(s) since {}:\n",
+            breaking.len(),
+            base
+        ));
+        for change in &breaking {
+            out.push_str(&format!("  {}: {}\n", change.package, change.message));
+        }
+    }
+    if !additions.is_empty() {
+        out.push_str(&format!("\n{} addition(s):\n", additions.len()));
+        for change in &additions {
+            out.push_str(&format!("  {}: {}\n", change.package, change.message));
+        }
+    }
+    out
+}
+
+fn skipped_dir(name: &str) -> bool {
+    name.starts_with('.') || name.starts_with('_') || name == "testdata" || name == "vendor"
+}
+
+fn slashed(path: &Path) -> String {
+    path.components()
+        .map(|component| component.as_os_str().to_string_lossy())
+        .collect::<Vec<_>>()
+        .join("/")
+}
+
+/// A variable declared without a type has a signature of `var`, which
+/// can't be compared.
+fn unknown(old: &Symbol, new: &Symbol) -> bool {
+    old.kind == Kind::Var && (old.signature == "var" || new.signature == "var")
+}
+
+/// Adds the file's exported declarations to `package` and returns the
+/// package name.
+fn collect<'s>(root: Node, source_code: &'s str, package: &mut Package) -> Option<&'s str> {
+    let mut name = None;
+    let mut cursor = root.walk();
+    for node in root.named_children(&mut cursor) {
+        match node.kind() {
+            "package_clause" => name = node.named_child(0).map(|n| node_text(n, source_code)),
+            "function_declaration" => {
+                let Some(function) = node.child_by_field_name("name") else {
+                    continue;
+                };
+                let function = node_text(function, source_code);
+                if is_exported(function) {
+                    package.symbols.insert(
+                        function.to_string(),
+                        Symbol {
+                            kind: Kind::Func,
+                            signature: signature(node, source_code),
+                        },
+                    );
+                }
+            }
+            "method_declaration" => {
+                let (Some(method), Some(receiver)) = (
+                    node.child_by_field_name("name"),
+                    receiver_type(node, source_code),
+                ) else {
+                    continue;
+                };
+                let method = node_text(method, source_code);
+                if is_exported(method) && is_exported(receiver) {
+                    package.symbols.insert(
+                        format!("{}.{}", receiver, method),
+                        Symbol {
+                            kind: Kind::Method,
+                            signature: signature(node, source_code),
+                        },
+                    );
+                }
+            }
+            "type_declaration" => {
+                let mut specs = node.walk();
+                for spec in node.named_children(&mut specs) {
+                    collect_type(spec, source_code, package);
+                }
+            }
+            "const_declaration" | "var_declaration" => collect_values(node, source_code, package),
+            _ => {}
+        }
+    }
+    name
+}
+
+fn collect_type(spec: Node, source_code: &str, package: &mut Package) {
+    let (Some(name), Some(ty)) = (
+        spec.child_by_field_name("name"),
+        spec.child_by_field_name("type"),
+    ) else {
+        return;
+    };
+    let name = node_text(name, source_code);
+    if !is_exported(name) {
+        return;
+    }
+    let parameters = spec
+        .child_by_field_name("type_parameters")
+        .map_or(String::new(), |parameters| {
+            normalize(node_text(parameters, source_code))
+        });
+    let body = match (spec.kind(), ty.kind()) {
+        ("type_alias", _) => format!("= {}", normalize(node_text(ty, source_code))),
+        (_, "struct_type") => {
+            collect_fields(name, ty, source_code, package);
+            "struct".to_string()
+        }
+        (_, "interface_type") => {
+            collect_interface(name, ty, source_code, package);
+            "interface".to_string()
+        }
+        _ => normalize(node_text(ty, source_code)),
+    };
+    package.symbols.insert(
+        name.to_string(),
+        Symbol {
+            kind: Kind::Type,
+            signature: format!("{}{}", parameters, body).trim_start().to_string(),
+        },
+    );
+}
+
+fn collect_fields(owner: &str, ty: Node, source_code: &str, package: &mut Package) {
+    let Some(list) = ty.named_child(0) else {
+        return;
+    };
+    let mut cursor = list.walk();
+    for field in list.named_children(&mut cursor) {
+        if field.kind() != "field_declaration" {
+            continue;
+        }
+        let Some(field_type) = field.child_by_field_name("type") else {
+            continue;
+        };
+        let field_type = normalize(node_text(field_type, source_code));
+        let mut names = field.walk();
+        let named: Vec<&str> = field
+            .children_by_field_name("name", &mut names)
+            .map(|name| node_text(name, source_code))
+            .collect();
+        if named.is_empty() {
+            // An embedded field is named after its type.
+            let embedded = field_type.trim_start_matches('*');
+            let embedded = embedded.split('[').next().unwrap_or(embedded);
+            let embedded = embedded.rsplit('.').next().unwrap_or(embedded);
+            if is_exported(embedded) {
+                package.symbols.insert(
+                    format!("{}.{}", owner, embedded),
+                    Symbol {
+                        kind: Kind::Field,
+                        signature: format!("embedded {}", field_type),
+                    },
+                );
+            }
+            continue;
+        }
+        for name in named.into_iter().filter(|name| is_exported(name)) {
+            package.symbols.insert(
+                format!("{}.{}", owner, name),
+                Symbol {
+                    kind: Kind::Field,
+                    signature: field_type.clone(),
+                },
+            );
+        }
+    }
+}
+
+fn collect_interface(owner: &str, ty: Node, source_code: &str, package: &mut Package) {
+    let mut cursor = ty.walk();
+    for element in ty.named_children(&mut cursor) {
+        match element.kind() {
+            "method_elem" | "method_spec" => {
+                let Some(name) = element.child_by_field_name("name") else {
+                    continue;
+                };
+                let name = node_text(name, source_code);
+                if !is_exported(name) {
+                    package.sealed.insert(owner.to_string());
+                    continue;
+                }
+                package.symbols.insert(
+                    format!("{}.{}", owner, name),
+                    Symbol {
+                        kind: Kind::InterfaceMethod,
+                        signature: signature(element, source_code),
+                    },
+                );
+            }
+            "type_elem"
+            | "constraint_elem"
+            | "interface_type_name"
+            | "qualified_type"
+            | "type_identifier" => {
+                let embedded = normalize(node_text(element, source_code));
+                package.symbols.insert(
+                    format!("{}.{}", owner, embedded),
+                    Symbol {
+                        kind: Kind::InterfaceMethod,
+                        signature: "embedded".to_string(),
+                    },
+                );
+            }
+            _ => {}
+        }
+    }
+}
+
+/// Constants and variables. A constant spec without a type or value repeats
+/// the one before it, as with `iota`.
+fn collect_values(declaration: Node, source_code: &str, package: &mut Package) {
+    let (kind, keyword) = match declaration.kind() {
+        "const_declaration" => (Kind::Const, "const"),
+        _ => (Kind::Var, "var"),
+    };
+    let mut current: Option<String> = None;
+    let mut cursor = declaration.walk();
+    for spec in declaration.named_children(&mut cursor) {
+        if !matches!(spec.kind(), "const_spec" | "var_spec") {
+            continue;
+        }
+        let ty = spec
+            .child_by_field_name("type")
+            .map(|ty| normalize(node_text(ty, source_code)));
+        match (&ty, spec.child_by_field_name("value"), kind) {
+            (Some(ty), _, _) => current = Some(ty.clone()),
+            (None, Some(_), _) | (None, None, Kind::Var) => current = None,
+            (None, None, _) => {}
+        }
+        let signature = match &current {
+            Some(ty) => format!("{} {}", keyword, ty),
+            None => keyword.to_string(),
+        };
+        let mut names = spec.walk();
+        for name in spec.children_by_field_name("name", &mut names) {
+            let name = node_text(name, source_code);
+            if is_exported(name) {
+                package.symbols.insert(
+                    name.to_string(),
+                    Symbol {
+                        kind,
+                        signature: signature.clone(),
+                    },
+                );
+            }
+        }
+    }
+}
+
+/// `func[T any](string, ...Option) (*DB, error)` for a function, method or
+/// interface method, leaving out parameter and result names.
+fn signature(node: Node, source_code: &str) -> String {
+    let type_parameters = node
+        .child_by_field_name("type_parameters")
+        .map_or(String::new(), |parameters| {
+            normalize(node_text(parameters, source_code))
+        });
+    let parameters = node
+        .child_by_field_name("parameters")
+        .map_or_else(Vec::new, |list| parameter_types(list, source_code));
+    let result = match node.child_by_field_name("result") {
+        Some(list) if list.kind() == "parameter_list" => {
+            let types = parameter_types(list, source_code);
+            match types.len() {
+                1 => format!(" {}", types[0]),
+                _ => format!(" ({})", types.join(", ")),
+            }
+        }
+        Some(ty) => format!(" {}", normalize(node_text(ty, source_code))),
+        None => String::new(),
+    };
+    format!(
+        "func{}({}){}",
+        type_parameters,
+        parameters.join(", "),
+        result
+    )
+}
+
+/// One type per parameter, so `a, b int` gives `int, int`.
+pub(crate) fn parameter_types(list: Node, source_code: &str) -> Vec<String> {
+    let mut types = Vec::new();
+    let mut cursor = list.walk();
+    for parameter in list.named_children(&mut cursor) {
+        let Some(ty) = parameter.child_by_field_name("type") else {
+            continue;
+        };
+        let ty = normalize(node_text(ty, source_code));
+        let ty = match parameter.kind() {
+            "variadic_parameter_declaration" => format!("...{}", ty),
+            _ => ty,
+        };
+        let mut names = parameter.walk();
+        let count = parameter
+            .children_by_field_name("name", &mut names)
+            .count()
+            .max(1);
+        types.extend(std::iter::repeat_n(ty, count));
+    }
+    types
+}
+
+/// Collapses whitespace, so formatting doesn't count as a change.
+fn normalize(text: &str) -> String {
+    text.split_whitespace().collect::<Vec<_>>().join(" ")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn package(import_path: &str, symbols: &[(&str, Kind, &str)]) -> Package {
+        Package {
+            import_path: import_path.to_string(),
+            symbols: symbols
+                .iter()
+                .map(|(name, kind, signature)| {
+                    (
+                        name.to_string(),
+                        Symbol {
+                            kind: *kind,
+                            signature: signature.to_string(),
+                        },
+                    )
+                })
+                .collect(),
+            sealed: BTreeSet::new(),
+        }
+    }
+
+    fn api(packages: Vec<(&str, Package)>) -> Api {
+        Api {
+            packages: packages
+                .into_iter()
+                .map(|(dir, package)| (dir.to_string(), package))
+                .collect(),
+        }
+    }
+
+    #[test]
+    fn test_members_of_removed_types_are_not_listed() {
+        let base = api(vec![(
+            "",
+            package(
+                "example.com/lib",
+                &[
+                    ("Client", Kind::Type, "struct"),
+                    ("Client.Do", Kind::Method, "func() error"),
+                    ("Client.Timeout", Kind::Field, "time.Duration"),
+                    ("Version", Kind::Var, "var"),
+                ],
+            ),
+        )]);
+        let head = api(vec![(
+            "",
+            package("example.com/lib", &[("Version", Kind::Var, "var string")]),
+        )]);
+        let changes = compare(&base, &head);
+        assert_eq!(changes.len(), 1);
+        assert_eq!(changes[0].message, "`Client` was removed");
+        assert!(changes[0].breaking);
+    }
+
+    #[test]
+    fn test_sealed_interfaces_can_grow() {
+        let base = api(vec![(
+            "",
+            package(
+                "example.com/lib",
+                &[
+                    ("Event", Kind::Type, "interface"),
+                    ("Store", Kind::Type, "interface"),
+                ],
+            ),
+        )]);
+        let mut head = api(vec![(
+            "",
+            package(
+                "example.com/lib",
+                &[
+                    ("Event", Kind::Type, "interface"),
+                    ("Event.Name", Kind::InterfaceMethod, "func() string"),
+                    ("Store", Kind::Type, "interface"),
+                    ("Store.Close", Kind::InterfaceMethod, "func() error"),
+                ],
+            ),
+        )]);
+        head.packages
+            .get_mut("")
+            .unwrap()
+            .sealed
+            .insert("Event".to_string());
+
+        let changes = compare(&base, &head);
+        let summary: Vec<_> = changes
+            .iter()
+            .map(|change| (change.message.as_str(), change.breaking))
+            .collect();
+        assert_eq!(
+            summary,
+            [
+                ("`Event.Name` was added", false),
+                ("`Store` has a new method `Close`, so types outside the package that implement it no longer do", true),
+            ]
+        );
+        assert!(report("v1.0.0", &changes).starts_with(
+            "1 breaking change(s) since v1.0.0:\n  example.com/lib: `Store` has a new method"
+        ));
+    }
+}
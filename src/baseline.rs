@@ -0,0 +1,126 @@
+use crate::analyzer::AnalysisResult;
+use crate::fingerprint::fingerprints;
+use serde::{Deserialize, Serialize};
+use std::collections::HashSet;
+use std::fs;
+use std::path::Path;
+
+pub const DEFAULT_BASELINE_PATH: &str = "compass-baseline.json";
+
+const BASELINE_VERSION: u32 = 1;
+
+#[derive(Debug, Deserialize, Serialize)]
+pub struct BaselineEntry {
+    pub fingerprint: String,
+    pub rule: String,
+    pub file: String,
+    pub line: usize,
+    pub message: String,
+}
+
+/// A snapshot of known findings. Entries are matched by fingerprint only;
+/// the rule, file and line are kept so the file stays reviewable.
+#[derive(Debug, Deserialize, Serialize)]
+pub struct Baseline {
+    pub version: u32,
+    pub findings: Vec<BaselineEntry>,
+}
+
+impl Baseline {
+    pub fn new() -> Self {
+        Baseline {
+            version: BASELINE_VERSION,
+            findings: Vec::new(),
+        }
+    }
+
+    pub fn from_file<P: AsRef<Path>>(path: P) -> Result<Self, Box<dyn std::error::Error>> {
+        let content = fs::read_to_string(path)?;
+        let baseline: Baseline = serde_json::from_str(&content)?;
+        if baseline.version != BASELINE_VERSION {
+            return Err(format!(
+                "unsupported baseline version {} (expected {})",
+                baseline.version, BASELINE_VERSION
+            )
+            .into());
+        }
+        Ok(baseline)
+    }
+
+    pub fn save_to_file<P: AsRef<Path>>(&self, path: P) -> Result<(), Box<dyn std::error::Error>> {
+        let content = serde_json::to_string_pretty(self)?;
+        fs::write(path, content + "\n")?;
+        Ok(())
+    }
+
+    /// Replaces any existing entries for `file` with the given results.
+    pub fn record(&mut self, file: &str, results: &[AnalysisResult]) {
+        self.findings.retain(|entry| entry.file != file);
+
+        for (result, fingerprint) in results.iter().zip(fingerprints(file, results)) {
+            self.findings.push(BaselineEntry {
+                fingerprint,
+                rule: result.rule_name.clone(),
+                file: file.to_string(),
+                line: result.line,
+                message: result.message.clone(),
+            });
+        }
+    }
+
+    /// Returns the results of `file` that are not part of the baseline.
+    pub fn filter(&self, file: &str, results: Vec<AnalysisResult>) -> Vec<AnalysisResult> {
+        let known: HashSet<&str> = self
+            .findings
+            .iter()
+            .map(|entry| entry.fingerprint.as_str())
+            .collect();
+        let prints = fingerprints(file, &results);
+
+        results
+            .into_iter()
+            .zip(prints)
+            .filter(|(_, fingerprint)| !known.contains(fingerprint.as_str()))
+            .map(|(result, _)| result)
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn result(rule: &str, line: usize, text: &str) -> AnalysisResult {
+        AnalysisResult {
+            rule_name: rule.to_string(),
+            line,
+            text: text.to_string(),
+            symbol: Some("main".to_string()),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_baseline_filters_known_findings_after_line_shift() {
+        let mut baseline = Baseline::new();
+        baseline.record("main.go", &[result("panic_usage", 4, "panic(\"boom\")")]);
+
+        let later = vec![
+            result("panic_usage", 12, "panic(\"boom\")"),
+            result("panic_usage", 20, "panic(\"new\")"),
+        ];
+        let remaining = baseline.filter("main.go", later);
+        assert_eq!(remaining.len(), 1);
+        assert_eq!(remaining[0].text, "panic(\"new\")");
+    }
+
+    #[test]
+    fn test_record_replaces_previous_entries_for_file() {
+        let mut baseline = Baseline::new();
+        baseline.record("a.go", &[result("panic_usage", 1, "panic(1)")]);
+        baseline.record("b.go", &[result("panic_usage", 1, "panic(1)")]);
+        baseline.record("a.go", &[]);
+        assert_eq!(baseline.findings.len(), 1);
+        assert_eq!(baseline.findings[0].file, "b.go");
+    }
+}
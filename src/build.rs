@@ -0,0 +1,348 @@
+//! Go build constraints: which files a build for a platform and a set of
+//! tags compiles.
+//!
+//! A file is left out of a build by a `_GOOS`, `_GOARCH` or `_GOOS_GOARCH`
+//! suffix on its name, or by a `//go:build` line (or the older `// +build`
+//! lines) before its `package` clause. `--build-tags` and `--platforms`
+//! analyze each file under every [`BuildContext`] that compiles it, with the
+//! package's other files as that build sees them.
+
+use std::fmt;
+use std::path::Path;
+
+const GOOS: &[&str] = &[
+    "aix",
+    "android",
+    "darwin",
+    "dragonfly",
+    "freebsd",
+    "hurd",
+    "illumos",
+    "ios",
+    "js",
+    "linux",
+    "nacl",
+    "netbsd",
+    "openbsd",
+    "plan9",
+    "solaris",
+    "wasip1",
+    "windows",
+    "zos",
+];
+
+const GOARCH: &[&str] = &[
+    "386",
+    "amd64",
+    "amd64p32",
+    "arm",
+    "armbe",
+    "arm64",
+    "arm64be",
+    "loong64",
+    "mips",
+    "mipsle",
+    "mips64",
+    "mips64le",
+    "mips64p32",
+    "mips64p32le",
+    "ppc",
+    "ppc64",
+    "ppc64le",
+    "riscv",
+    "riscv64",
+    "s390",
+    "s390x",
+    "sparc",
+    "sparc64",
+    "wasm",
+];
+
+/// The systems the `unix` tag stands for.
+const UNIX: &[&str] = &[
+    "aix",
+    "android",
+    "darwin",
+    "dragonfly",
+    "freebsd",
+    "hurd",
+    "illumos",
+    "ios",
+    "linux",
+    "netbsd",
+    "openbsd",
+    "solaris",
+];
+
+/// A `GOOS/GOARCH` pair, as `--platforms` takes it.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Platform {
+    pub goos: String,
+    pub goarch: String,
+}
+
+impl Platform {
+    pub fn parse(text: &str) -> Result<Platform, String> {
+        let Some((goos, goarch)) = text.trim().split_once('/') else {
+            return Err(format!(
+                "'{}' is not a platform; expected GOOS/GOARCH, e.g. linux/amd64",
+                text
+            ));
+        };
+        if !GOOS.contains(&goos) {
+            return Err(format!("unknown GOOS '{}' in '{}'", goos, text));
+        }
+        if !GOARCH.contains(&goarch) {
+            return Err(format!("unknown GOARCH '{}' in '{}'", goarch, text));
+        }
+        Ok(Platform {
+            goos: goos.to_string(),
+            goarch: goarch.to_string(),
+        })
+    }
+
+    /// The platform compass runs on, as Go names it.
+    pub fn host() -> Platform {
+        let goos = match std::env::consts::OS {
+            "macos" => "darwin",
+            os => os,
+        };
+        let goarch = match std::env::consts::ARCH {
+            "x86_64" => "amd64",
+            "x86" => "386",
+            "aarch64" => "arm64",
+            "powerpc64" => "ppc64",
+            "loongarch64" => "loong64",
+            arch => arch,
+        };
+        Platform {
+            goos: goos.to_string(),
+            goarch: goarch.to_string(),
+        }
+    }
+}
+
+impl fmt::Display for Platform {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}/{}", self.goos, self.goarch)
+    }
+}
+
+/// A build to analyze for: a platform and the tags passed with `-tags`.
+#[derive(Debug, Clone)]
+pub struct BuildContext {
+    pub platform: Platform,
+    pub tags: Vec<String>,
+}
+
+impl BuildContext {
+    /// Whether the build satisfies `tag`. Besides the platform and the
+    /// given tags, that is `gc`, `unix` on Unix systems, the systems that
+    /// `android`, `ios` and `illumos` build on, and every `go1.N` release
+    /// tag, as with a current toolchain. `cgo` has to be passed as a tag.
+    pub fn satisfies(&self, tag: &str) -> bool {
+        let goos = self.platform.goos.as_str();
+        tag == goos
+            || tag == self.platform.goarch
+            || tag == "gc"
+            || (tag == "unix" && UNIX.contains(&goos))
+            || (tag == "linux" && goos == "android")
+            || (tag == "darwin" && goos == "ios")
+            || (tag == "solaris" && goos == "illumos")
+            || tag
+                .strip_prefix("go1.")
+                .is_some_and(|minor| minor.parse::<u32>().is_ok())
+            || self.tags.iter().any(|given| given == tag)
+    }
+
+    /// Whether the build compiles the Go file at `path`. Other files, and
+    /// constraints that don't parse, are always included.
+    pub fn includes(&self, path: &Path, source_code: &str) -> bool {
+        if path.extension().is_none_or(|extension| extension != "go") {
+            return true;
+        }
+        self.includes_name(path) && self.includes_source(source_code)
+    }
+
+    fn includes_name(&self, path: &Path) -> bool {
+        let Some(stem) = path.file_stem().map(|stem| stem.to_string_lossy()) else {
+            return true;
+        };
+        let stem = stem.strip_suffix("_test").unwrap_or(&stem);
+        // The first element is the name itself, which is never a constraint.
+        let parts: Vec<&str> = stem.split('_').skip(1).collect();
+        match parts[..] {
+            [.., goos, goarch] if GOOS.contains(&goos) && GOARCH.contains(&goarch) => {
+                self.satisfies(goos) && self.satisfies(goarch)
+            }
+            [.., last] if GOOS.contains(&last) => self.satisfies(last),
+            [.., last] if GOARCH.contains(&last) => self.satisfies(last),
+            _ => true,
+        }
+    }
+
+    fn includes_source(&self, source_code: &str) -> bool {
+        let mut plus_build = Vec::new();
+        for line in source_code.lines() {
+            let line = line.trim();
+            if line.is_empty() {
+                continue;
+            }
+            let Some(comment) = line.strip_prefix("//") else {
+                // Constraints only come before the package clause.
+                break;
+            };
+            if let Some(expression) = comment.strip_prefix("go:build") {
+                return evaluate(expression, &|tag| self.satisfies(tag)).unwrap_or(true);
+            }
+            if let Some(options) = comment.trim_start().strip_prefix("+build") {
+                plus_build.push(options.to_string());
+            }
+        }
+        // Lines of `// +build` all hold; on one line a space is "or" and a
+        // comma "and".
+        plus_build.iter().all(|options| {
+            options.split_whitespace().any(|option| {
+                option.split(',').all(|term| match term.strip_prefix('!') {
+                    Some(tag) => !self.satisfies(tag),
+                    None => self.satisfies(term),
+                })
+            })
+        })
+    }
+}
+
+/// Evaluates a `//go:build` expression, or `None` when it doesn't parse.
+fn evaluate(expression: &str, satisfies: &dyn Fn(&str) -> bool) -> Option<bool> {
+    let tokens = tokenize(expression)?;
+    let mut parser = Expression {
+        tokens: &tokens,
+        next: 0,
+        satisfies,
+    };
+    let value = parser.or()?;
+    (parser.next == tokens.len()).then_some(value)
+}
+
+fn tokenize(expression: &str) -> Option<Vec<&str>> {
+    let mut tokens = Vec::new();
+    let mut rest = expression.trim();
+    while !rest.is_empty() {
+        let length = if rest.starts_with("&&") || rest.starts_with("||") {
+            2
+        } else if rest.starts_with(['(', ')', '!']) {
+            1
+        } else {
+            let length = rest
+                .find(|c: char| !(c.is_alphanumeric() || c == '_' || c == '.'))
+                .unwrap_or(rest.len());
+            if length == 0 {
+                return None;
+            }
+            length
+        };
+        tokens.push(&rest[..length]);
+        rest = rest[length..].trim_start();
+    }
+    Some(tokens)
+}
+
+/// A recursive descent over `||`, `&&`, `!` and parentheses, in that order
+/// of precedence from loosest.
+struct Expression<'a> {
+    tokens: &'a [&'a str],
+    next: usize,
+    satisfies: &'a dyn Fn(&str) -> bool,
+}
+
+impl Expression<'_> {
+    fn or(&mut self) -> Option<bool> {
+        let mut value = self.and()?;
+        while self.eat("||") {
+            // Both sides are parsed, so a bad right side isn't skipped.
+            value |= self.and()?;
+        }
+        Some(value)
+    }
+
+    fn and(&mut self) -> Option<bool> {
+        let mut value = self.not()?;
+        while self.eat("&&") {
+            value &= self.not()?;
+        }
+        Some(value)
+    }
+
+    fn not(&mut self) -> Option<bool> {
+        if self.eat("!") {
+            return self.not().map(|value| !value);
+        }
+        if self.eat("(") {
+            let value = self.or()?;
+            return self.eat(")").then_some(value);
+        }
+        let tag = self.tokens.get(self.next)?;
+        if matches!(*tag, "&&" | "||" | ")") {
+            return None;
+        }
+        self.next += 1;
+        Some((self.satisfies)(tag))
+    }
+
+    fn eat(&mut self, token: &str) -> bool {
+        let matched = self.tokens.get(self.next) == Some(&token);
+        if matched {
+            self.next += 1;
+        }
+        matched
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn context(platform: &str, tags: &[&str]) -> BuildContext {
+        BuildContext {
+            platform: Platform::parse(platform).unwrap(),
+            tags: tags.iter().map(|tag| tag.to_string()).collect(),
+        }
+    }
+
+    #[test]
+    fn test_file_names_constrain_the_platform() {
+        let linux = context("linux/amd64", &[]);
+        let darwin = context("darwin/arm64", &[]);
+        let included = |context: &BuildContext, name: &str| {
+            context.includes(Path::new(name), "package store\n")
+        };
+        assert!(included(&linux, "store_linux.go"));
+        assert!(!included(&darwin, "store_linux.go"));
+        assert!(included(&linux, "store_linux_amd64_test.go"));
+        assert!(!included(&linux, "store_linux_arm64.go"));
+        assert!(included(&darwin, "store_arm64.go"));
+        // A name that is only a system isn't a constraint
+        assert!(included(&darwin, "linux.go"));
+        assert!(included(&darwin, "notes_linux.txt"));
+    }
+
+    #[test]
+    fn test_build_lines_are_evaluated() {
+        let linux = context("linux/amd64", &["integration"]);
+        let included = |source: &str| linux.includes(Path::new("store.go"), source);
+        assert!(included("//go:build integration\n\npackage store\n"));
+        assert!(!included("//go:build !integration\n\npackage store\n"));
+        assert!(included(
+            "// Copyright\n\n//go:build (darwin || unix) && !windows && go1.21\n\npackage store\n"
+        ));
+        assert!(!included("//go:build linux && cgo\n\npackage store\n"));
+        assert!(included("// +build linux,amd64 windows\n\npackage store\n"));
+        assert!(!included(
+            "// +build windows\n// +build amd64\n\npackage store\n"
+        ));
+        // After the package clause it is just a comment
+        assert!(included("package store\n\n//go:build windows\n"));
+        // As Go would refuse it, nothing is left out for it
+        assert!(included("//go:build linux &&\n\npackage store\n"));
+    }
+}
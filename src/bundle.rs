@@ -0,0 +1,460 @@
+//! Shared config bundles: a `.compass.toml` can extend one published
+//! elsewhere, so a policy maintained in one place reaches every repository
+//! that points at it.
+//!
+//! ```toml
+//! extends = "ghcr.io/mycorp/compass-policy:v3"
+//! # or, pinned to exact contents:
+//! extends = { source = "https://policy.mycorp.com/compass/v3.toml", sha256 = "9f2c..." }
+//! ```
+//!
+//! A bundle is a `.compass.toml` itself, without `root` or `extends`. It is
+//! merged just before the file that extends it, so that file and the ones
+//! below it still override the bundle, and its exclusions are relative to
+//! the extending file's directory.
+//!
+//! A source is an `https://` URL or an OCI reference, `registry/repository`
+//! with a `:tag` or `@sha256:` digest, whose manifest's first layer (or the
+//! first with [`BUNDLE_MEDIA_TYPE`]) is the bundle:
+//!
+//! ```text
+//! oras push ghcr.io/mycorp/compass-policy:v3 compass.toml:application/vnd.compass.bundle.v1+toml
+//! ```
+//!
+//! A private registry is reached with `$COMPASS_REGISTRY_TOKEN`, which is
+//! only sent to the hosts listed in `$COMPASS_REGISTRY_HOSTS`; others are
+//! asked for an anonymous pull token from the realm of their
+//! `WWW-Authenticate` challenge. Downloads go through `curl` and
+//! are cached in the `bundles` directory of [`Cache::default_dir`]. A pinned
+//! bundle is only downloaded when the cached copy doesn't match its
+//! checksum. Others are downloaded again after an hour, and the cached copy
+//! is used when that fails. With `$COMPASS_OFFLINE` set, only cached copies
+//! are used.
+
+use crate::cache::Cache;
+use crate::fingerprint::content_hash;
+use crate::history::curl;
+use crate::project::ProjectConfig;
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::time::{Duration, SystemTime};
+
+/// The layer media type that marks the bundle in an OCI manifest.
+pub const BUNDLE_MEDIA_TYPE: &str = "application/vnd.compass.bundle.v1+toml";
+
+/// How long an unpinned bundle is used before it is downloaded again.
+const REFRESH_AFTER: Duration = Duration::from_secs(60 * 60);
+
+const MANIFEST_TYPES: &str = "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json";
+
+/// The `extends` key of a `.compass.toml`.
+#[derive(Debug, Clone, PartialEq, Deserialize, Serialize)]
+#[serde(untagged)]
+pub enum Extends {
+    Source(String),
+    Pinned(Pinned),
+}
+
+#[derive(Debug, Clone, PartialEq, Deserialize, Serialize)]
+#[serde(deny_unknown_fields)]
+pub struct Pinned {
+    pub source: String,
+    /// The hex SHA-256 of the bundle's contents.
+    pub sha256: String,
+}
+
+impl Extends {
+    pub fn source(&self) -> &str {
+        match self {
+            Extends::Source(source) => source,
+            Extends::Pinned(pinned) => &pinned.source,
+        }
+    }
+
+    pub fn sha256(&self) -> Option<&str> {
+        match self {
+            Extends::Source(_) => None,
+            Extends::Pinned(pinned) => Some(&pinned.sha256),
+        }
+    }
+}
+
+/// Where a bundle is downloaded from.
+#[derive(Debug, PartialEq)]
+enum Source {
+    Https(String),
+    Oci {
+        registry: String,
+        repository: String,
+        /// A tag, or a digest such as `sha256:...`.
+        reference: String,
+    },
+}
+
+impl Source {
+    fn parse(source: &str) -> Result<Source, String> {
+        if source.starts_with("https://") {
+            return Ok(Source::Https(source.to_string()));
+        }
+        if source.starts_with("http://") {
+            return Err(format!("'{}' must be fetched over https", source));
+        }
+        let reference = source.strip_prefix("oci://").unwrap_or(source);
+        let (registry, rest) = reference
+            .split_once('/')
+            .filter(|(registry, rest)| registry.contains(['.', ':']) && !rest.is_empty())
+            .ok_or_else(|| {
+                format!(
+                    "'{}' is neither an https:// URL nor a registry reference like ghcr.io/org/policy:v1",
+                    source
+                )
+            })?;
+        let (repository, reference) = match rest.split_once('@') {
+            Some((repository, digest)) => (repository, digest),
+            None => match rest.rsplit_once(':') {
+                Some((repository, tag)) if !tag.contains('/') => (repository, tag),
+                _ => (rest, "latest"),
+            },
+        };
+        Ok(Source::Oci {
+            registry: registry.to_string(),
+            repository: repository.to_string(),
+            reference: reference.to_string(),
+        })
+    }
+}
+
+/// Loads the bundle `extends` names, from the cache under
+/// [`Cache::default_dir`] when it can.
+pub fn load(extends: &Extends) -> Result<ProjectConfig, String> {
+    load_cached(extends, &Cache::default_dir().join("bundles"))
+}
+
+pub(crate) fn load_cached(extends: &Extends, cache_dir: &Path) -> Result<ProjectConfig, String> {
+    let content = contents(extends, cache_dir)?;
+    let config = ProjectConfig::from_toml(&content).map_err(|e| e.to_string())?;
+    if config.root {
+        return Err("a bundle can't set `root`".to_string());
+    }
+    if config.extends.is_some() {
+        return Err("a bundle can't extend another bundle".to_string());
+    }
+    Ok(config)
+}
+
+fn contents(extends: &Extends, cache_dir: &Path) -> Result<String, String> {
+    let offline = std::env::var_os("COMPASS_OFFLINE").is_some();
+    contents_from(extends, cache_dir, offline, fetch)
+}
+
+/// The bundle's contents, from the cache or else downloaded with `fetch`,
+/// unless `offline`.
+fn contents_from(
+    extends: &Extends,
+    cache_dir: &Path,
+    offline: bool,
+    fetch: impl Fn(&Source) -> Result<String, String>,
+) -> Result<String, String> {
+    let source = extends.source();
+    let cached_path = cached_path(source, cache_dir);
+    let cached = fs::read_to_string(&cached_path)
+        .ok()
+        .filter(|content| extends.sha256().is_none_or(|pin| sha256(content) == pin));
+    match &cached {
+        Some(content) if extends.sha256().is_some() || offline || is_fresh(&cached_path) => {
+            return Ok(content.clone())
+        }
+        None if offline => {
+            return Err(format!(
+                "'{}' isn't cached and COMPASS_OFFLINE is set",
+                source
+            ))
+        }
+        _ => {}
+    }
+
+    let content = match fetch(&Source::parse(source)?) {
+        Ok(content) => content,
+        Err(e) => return cached.ok_or(e),
+    };
+    if let Some(pin) = extends.sha256() {
+        let actual = sha256(&content);
+        if actual != pin {
+            return Err(format!(
+                "'{}' has sha256 {}, but {} is pinned",
+                source, actual, pin
+            ));
+        }
+    }
+    // A bundle that can't be cached still applies; it is downloaded again
+    // next time.
+    let _ = fs::create_dir_all(cache_dir).and_then(|_| fs::write(&cached_path, &content));
+    Ok(content)
+}
+
+fn cached_path(source: &str, cache_dir: &Path) -> PathBuf {
+    cache_dir.join(format!("{}.toml", content_hash(&[source])))
+}
+
+fn is_fresh(path: &Path) -> bool {
+    fs::metadata(path)
+        .and_then(|metadata| metadata.modified())
+        .ok()
+        .and_then(|modified| SystemTime::now().duration_since(modified).ok())
+        .is_some_and(|age| age < REFRESH_AFTER)
+}
+
+fn sha256(content: &str) -> String {
+    Sha256::digest(content.as_bytes())
+        .iter()
+        .map(|byte| format!("{:02x}", byte))
+        .collect()
+}
+
+fn fetch(source: &Source) -> Result<String, String> {
+    let (registry, repository, reference) = match source {
+        Source::Https(url) => return curl(&["-fsSL", url], None),
+        Source::Oci {
+            registry,
+            repository,
+            reference,
+        } => (registry, repository, reference),
+    };
+    let manifest_url = format!(
+        "https://{}/v2/{}/manifests/{}",
+        registry, repository, reference
+    );
+    // The header goes through stdin so a token never shows up in `ps`.
+    let authorization = match registry_token(registry) {
+        Some(token) => Some(format!("Authorization: Bearer {}", token)),
+        None => anonymous_token(&manifest_url, repository)
+            .map(|token| format!("Authorization: Bearer {}", token)),
+    };
+    let get = |url: &str, accept: &str| {
+        let accept = format!("Accept: {}", accept);
+        match &authorization {
+            Some(header) => curl(&["-fsSL", "-H", &accept, "-H", "@-", url], Some(header)),
+            None => curl(&["-fsSL", "-H", &accept, url], None),
+        }
+    };
+
+    let manifest: serde_json::Value = serde_json::from_str(&get(&manifest_url, MANIFEST_TYPES)?)
+        .map_err(|e| format!("unexpected manifest from '{}': {}", manifest_url, e))?;
+    let layers = manifest["layers"].as_array().cloned().unwrap_or_default();
+    let layer = layers
+        .iter()
+        .find(|layer| layer["mediaType"] == BUNDLE_MEDIA_TYPE)
+        .or_else(|| layers.first())
+        .ok_or_else(|| format!("the manifest at '{}' has no layers", manifest_url))?;
+    let digest = layer["digest"]
+        .as_str()
+        .ok_or_else(|| format!("a layer at '{}' has no digest", manifest_url))?;
+
+    let blob_url = format!("https://{}/v2/{}/blobs/{}", registry, repository, digest);
+    let content = get(&blob_url, "*/*")?;
+    if let Some(expected) = digest.strip_prefix("sha256:") {
+        if sha256(&content) != expected {
+            return Err(format!("'{}' doesn't match its digest", blob_url));
+        }
+    }
+    Ok(content)
+}
+
+/// `$COMPASS_REGISTRY_TOKEN`, if `registry` is one of the comma-separated
+/// hosts in `$COMPASS_REGISTRY_HOSTS`, so a bundle on some other registry
+/// never sees it.
+fn registry_token(registry: &str) -> Option<String> {
+    let token = std::env::var("COMPASS_REGISTRY_TOKEN").ok()?;
+    let hosts = std::env::var("COMPASS_REGISTRY_HOSTS").unwrap_or_default();
+    hosts
+        .split(',')
+        .any(|host| host.trim().eq_ignore_ascii_case(registry))
+        .then_some(token)
+}
+
+/// The token registries like ghcr.io hand out for anonymous pulls, if the
+/// registry has one, from the realm its `WWW-Authenticate` challenge for
+/// `manifest_url` names.
+fn anonymous_token(manifest_url: &str, repository: &str) -> Option<String> {
+    let headers = curl(
+        &[
+            "-sSI",
+            "-H",
+            &format!("Accept: {}", MANIFEST_TYPES),
+            manifest_url,
+        ],
+        None,
+    )
+    .ok()?;
+    let url = token_url(&headers, repository)?;
+    let body = curl(&["-fsSL", &url], None).ok()?;
+    let response: serde_json::Value = serde_json::from_str(&body).ok()?;
+    response["token"]
+        .as_str()
+        .or_else(|| response["access_token"].as_str())
+        .map(str::to_string)
+}
+
+/// Where to ask for a pull token, from the `WWW-Authenticate: Bearer
+/// realm="...",service="..."` challenge in `headers`. Without a `scope`
+/// in the challenge, the token is asked to pull `repository`.
+fn token_url(headers: &str, repository: &str) -> Option<String> {
+    let challenge = headers.lines().find_map(|line| {
+        let (name, value) = line.split_once(':')?;
+        name.trim()
+            .eq_ignore_ascii_case("www-authenticate")
+            .then(|| value.trim())
+    })?;
+    let (scheme, params) = challenge.split_once(' ')?;
+    if !scheme.eq_ignore_ascii_case("bearer") {
+        return None;
+    }
+    let mut realm = None;
+    let mut query = Vec::new();
+    let mut quoted = false;
+    let params = params.split(|c| {
+        quoted ^= c == '"';
+        c == ',' && !quoted
+    });
+    for param in params {
+        let Some((key, value)) = param.split_once('=') else {
+            continue;
+        };
+        let value = value.trim().trim_matches('"');
+        match key.trim() {
+            "realm" => realm = Some(value),
+            key => query.push(format!("{}={}", key, value)),
+        }
+    }
+    let realm = realm.filter(|realm| realm.starts_with("https://"))?;
+    if !query.iter().any(|param| param.starts_with("scope=")) {
+        query.push(format!("scope=repository:{}:pull", repository));
+    }
+    let separator = if realm.contains('?') { '&' } else { '?' };
+    Some(format!("{}{}{}", realm, separator, query.join("&")))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn scratch_dir(name: &str) -> PathBuf {
+        let dir =
+            std::env::temp_dir().join(format!("compass-bundle-{}-{}", name, std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        fs::create_dir_all(&dir).unwrap();
+        dir
+    }
+
+    #[test]
+    fn test_sources_are_parsed() {
+        assert_eq!(
+            Source::parse("ghcr.io/mycorp/compass-policy:v3").unwrap(),
+            Source::Oci {
+                registry: "ghcr.io".to_string(),
+                repository: "mycorp/compass-policy".to_string(),
+                reference: "v3".to_string(),
+            }
+        );
+        assert_eq!(
+            Source::parse("oci://localhost:5000/policy@sha256:abc").unwrap(),
+            Source::Oci {
+                registry: "localhost:5000".to_string(),
+                repository: "policy".to_string(),
+                reference: "sha256:abc".to_string(),
+            }
+        );
+        assert_eq!(
+            Source::parse("registry.example.com/team/policy").unwrap(),
+            Source::Oci {
+                registry: "registry.example.com".to_string(),
+                repository: "team/policy".to_string(),
+                reference: "latest".to_string(),
+            }
+        );
+        assert_eq!(
+            Source::parse("https://example.com/policy.toml").unwrap(),
+            Source::Https("https://example.com/policy.toml".to_string())
+        );
+        assert!(Source::parse("http://example.com/policy.toml").is_err());
+        assert!(Source::parse("mycorp/policy:v1").is_err());
+    }
+
+    #[test]
+    fn test_pinned_bundles_come_from_the_cache() {
+        let dir = scratch_dir("pinned");
+        let source = "https://policy.invalid/compass.toml";
+        let content = "exclude = [\"gen\"]\n[rules.panic_usage]\nseverity = \"error\"\n";
+        fs::write(cached_path(source, &dir), content).unwrap();
+
+        let pinned = Extends::Pinned(Pinned {
+            source: source.to_string(),
+            sha256: sha256(content),
+        });
+        let config = load_cached(&pinned, &dir).unwrap();
+        assert_eq!(config.exclude, vec!["gen"]);
+        assert_eq!(
+            config.rules["panic_usage"].severity.as_deref(),
+            Some("error")
+        );
+
+        // A cached copy that doesn't match the pin is downloaded again, and
+        // the download has to match it too.
+        let stale = Extends::Pinned(Pinned {
+            source: source.to_string(),
+            sha256: sha256("exclude = []\n"),
+        });
+        let error = contents_from(&stale, &dir, false, |_| Ok(content.to_string())).unwrap_err();
+        assert!(error.contains("is pinned"), "{}", error);
+        let error =
+            contents_from(&stale, &dir, true, |_| panic!("downloaded while offline")).unwrap_err();
+        assert!(error.contains("COMPASS_OFFLINE"), "{}", error);
+
+        let fetched = contents_from(&stale, &dir, false, |_| Ok("exclude = []\n".to_string()));
+        assert_eq!(fetched.unwrap(), "exclude = []\n");
+        assert_eq!(
+            fs::read_to_string(cached_path(source, &dir)).unwrap(),
+            "exclude = []\n"
+        );
+        fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_token_url_comes_from_the_challenge() {
+        let headers = "HTTP/2 401\r\ncontent-type: application/json\r\nWWW-Authenticate: Bearer realm=\"https://auth.example.com/token\",service=\"registry.example.com\",scope=\"repository:team/policy:pull,push\"\r\n\r\n";
+        assert_eq!(
+            token_url(headers, "team/policy").unwrap(),
+            "https://auth.example.com/token?service=registry.example.com&scope=repository:team/policy:pull,push"
+        );
+        let unscoped =
+            "www-authenticate: Bearer realm=\"https://ghcr.io/token\",service=\"ghcr.io\"\n";
+        assert_eq!(
+            token_url(unscoped, "mycorp/policy").unwrap(),
+            "https://ghcr.io/token?service=ghcr.io&scope=repository:mycorp/policy:pull"
+        );
+        assert!(token_url("WWW-Authenticate: Basic realm=\"x\"\n", "a").is_none());
+        let downgrade = "WWW-Authenticate: Bearer realm=\"http://auth.example.com/token\"\n";
+        assert!(token_url(downgrade, "a").is_none());
+        assert!(token_url("HTTP/2 200\n", "a").is_none());
+    }
+
+    #[test]
+    fn test_bundles_cant_set_root_or_extend() {
+        let dir = scratch_dir("nested");
+        for (i, content) in ["root = true\n", "extends = \"ghcr.io/a/b:v1\"\n"]
+            .iter()
+            .enumerate()
+        {
+            let source = format!("https://policy.invalid/{}.toml", i);
+            fs::write(cached_path(&source, &dir), content).unwrap();
+            let extends = Extends::Pinned(Pinned {
+                source,
+                sha256: sha256(content),
+            });
+            assert!(load_cached(&extends, &dir).is_err());
+        }
+        fs::remove_dir_all(&dir).unwrap();
+    }
+}
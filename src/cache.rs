@@ -0,0 +1,170 @@
+//! The on-disk analysis cache.
+//!
+//! Findings are stored per file under a key that hashes everything they
+//! depend on: the compass version, the language, the effective rule set
+//! (built-in or custom config plus any `.compass.toml` overrides) and the
+//! file's contents. Changing any of them misses the cache, so entries never
+//! need invalidating; `compass cache clean` only reclaims space.
+//!
+//! Checks registered through [`crate::plugin::Registry`] are identified by
+//! name only, so rebuild custom binaries with a new version or run with
+//! `--no-cache` while developing them.
+
+use crate::analyzer::AnalysisResult;
+use crate::config::AnalyzerConfig;
+use crate::fingerprint::content_hash;
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+
+/// Bumped when the entry layout changes.
+const CACHE_FORMAT: &str = "1";
+
+pub struct Cache {
+    dir: PathBuf,
+}
+
+impl Cache {
+    pub fn new<P: AsRef<Path>>(dir: P) -> Self {
+        Cache {
+            dir: dir.as_ref().to_path_buf(),
+        }
+    }
+
+    /// `$COMPASS_CACHE_DIR`, else `$XDG_CACHE_HOME/compass`, else
+    /// `~/.cache/compass`.
+    pub fn default_dir() -> PathBuf {
+        if let Some(dir) = std::env::var_os("COMPASS_CACHE_DIR") {
+            return PathBuf::from(dir);
+        }
+        if let Some(dir) = std::env::var_os("XDG_CACHE_HOME") {
+            return PathBuf::from(dir).join("compass");
+        }
+        let home = std::env::var_os("HOME")
+            .map(PathBuf::from)
+            .unwrap_or_default();
+        home.join(".cache").join("compass")
+    }
+
+    pub fn dir(&self) -> &Path {
+        &self.dir
+    }
+
+    /// `package` is the one the analysis was given, if any; its files are
+    /// part of the key because checks that read it depend on them.
+    pub fn key(
+        config: &AnalyzerConfig,
+        language: SupportedLanguage,
+        source_code: &str,
+        package: Option<&Package>,
+    ) -> Result<String, toml::ser::Error> {
+        let rules = toml::to_string(config)?;
+        let siblings = package.map(Package::contents).unwrap_or_default();
+        let mut parts = vec![
+            CACHE_FORMAT,
+            env!("CARGO_PKG_VERSION"),
+            language.config_key(),
+            &rules,
+            source_code,
+        ];
+        parts.extend(siblings.iter().map(String::as_str));
+        Ok(content_hash(&parts))
+    }
+
+    /// The cached findings for `key`. Unreadable or corrupt entries count as
+    /// misses.
+    pub fn get(&self, key: &str) -> Option<Vec<AnalysisResult>> {
+        let content = fs::read_to_string(self.entry(key)).ok()?;
+        serde_json::from_str(&content).ok()
+    }
+
+    /// Stores `results`, writing to a temporary file first so concurrent
+    /// runs never read a partial entry.
+    pub fn put(&self, key: &str, results: &[AnalysisResult]) -> io::Result<()> {
+        fs::create_dir_all(&self.dir)?;
+        let json = serde_json::to_string(results)?;
+        let temporary = self.dir.join(format!("{}.{}.tmp", key, std::process::id()));
+        fs::write(&temporary, json)?;
+        fs::rename(&temporary, self.entry(key))
+    }
+
+    /// Removes every entry and returns how many there were.
+    pub fn clean(&self) -> io::Result<usize> {
+        let entries = match fs::read_dir(&self.dir) {
+            Ok(entries) => entries,
+            Err(e) if e.kind() == io::ErrorKind::NotFound => return Ok(0),
+            Err(e) => return Err(e),
+        };
+
+        let mut removed = 0;
+        for entry in entries {
+            let path = entry?.path();
+            let is_entry = path
+                .extension()
+                .is_some_and(|ext| ext == "json" || ext == "tmp");
+            if is_entry {
+                fs::remove_file(&path)?;
+                removed += 1;
+            }
+        }
+        Ok(removed)
+    }
+
+    fn entry(&self, key: &str) -> PathBuf {
+        self.dir.join(format!("{}.json", key))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analyzer::Severity;
+
+    #[test]
+    fn test_round_trip_and_clean() {
+        let dir = std::env::temp_dir().join(format!("compass-cache-{}", std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        let cache = Cache::new(&dir);
+
+        let results = vec![AnalysisResult {
+            rule_name: "panic_usage".to_string(),
+            severity: Severity::Warning,
+            line: 4,
+            ..Default::default()
+        }];
+        assert!(cache.get("abc").is_none());
+        cache.put("abc", &results).unwrap();
+
+        let cached = cache.get("abc").unwrap();
+        assert_eq!(cached.len(), 1);
+        assert_eq!(cached[0].rule_name, "panic_usage");
+        assert_eq!(cached[0].severity, Severity::Warning);
+
+        assert_eq!(cache.clean().unwrap(), 1);
+        assert!(cache.get("abc").is_none());
+        fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_key_changes_with_rules_and_source() {
+        let config = AnalyzerConfig::load(None, SupportedLanguage::Go).unwrap().0;
+        let key = Cache::key(&config, SupportedLanguage::Go, "package main\n", None).unwrap();
+        assert_eq!(
+            key,
+            Cache::key(&config, SupportedLanguage::Go, "package main\n", None).unwrap()
+        );
+        assert_ne!(
+            key,
+            Cache::key(&config, SupportedLanguage::Go, "package lib\n", None).unwrap()
+        );
+
+        let mut changed = AnalyzerConfig::load(None, SupportedLanguage::Go).unwrap().0;
+        changed.rules[0].enabled = !changed.rules[0].enabled;
+        assert_ne!(
+            key,
+            Cache::key(&changed, SupportedLanguage::Go, "package main\n", None).unwrap()
+        );
+    }
+}
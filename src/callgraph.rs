@@ -0,0 +1,606 @@
+//! A call graph of the Go functions in a module.
+//!
+//! Compass has no type information, so calls are resolved from syntax in
+//! the spirit of class hierarchy analysis (CHA): a call to a function of the
+//! same package or of an imported package in the graph goes to that function,
+//! and a method call on the method's own receiver goes to the receiver
+//! type's method. Any other method call goes to every method with that name,
+//! marked [`Call::dynamic`] because it may never happen at run time. Calls
+//! inside function literals belong to the enclosing function, and calls to
+//! functions outside the graph, such as the standard library, aren't
+//! recorded.
+//!
+//! The graph also records which packages each package imports, for checks
+//! that follow import chains rather than calls.
+//!
+//! Checks reach the graph of the file's module through
+//! [`crate::package::Package::call_graph`]; `compass callgraph` prints it.
+
+use crate::apidiff::parameter_types;
+use crate::checks::{
+    callee, import_path, imports, is_unreachable_default, node_text, visit, Declarations,
+};
+use crate::language::SupportedLanguage;
+use crate::module::{Module, GO_MOD_FILE};
+use crate::walk;
+use serde::Serialize;
+use std::collections::{BTreeMap, BTreeSet, HashMap, HashSet, VecDeque};
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex, OnceLock};
+use std::time::SystemTime;
+use tree_sitter::{Node, Parser};
+
+/// A Go source file and the import path of its package.
+pub struct GoFile {
+    pub package: String,
+    pub path: PathBuf,
+    pub source_code: String,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct Function {
+    /// `pkg.Func` for functions and `(pkg.Type).Method` or
+    /// `(*pkg.Type).Method` for methods, as Go tools print them.
+    pub id: String,
+    pub package: String,
+    pub name: String,
+    /// The receiver's type name, without `*` or type parameters.
+    pub receiver: Option<String>,
+    pub path: PathBuf,
+    pub line: usize,
+    /// The type of each parameter as written, such as `*http.Request`,
+    /// with `...T` for a variadic one.
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub parameters: Vec<String>,
+    /// Lines of the `panic` calls in its body, leaving out a lone
+    /// `default: panic(...)` that asserts a switch is exhaustive.
+    pub panics: Vec<usize>,
+    /// Whether it defers a function that calls `recover`, so panics below
+    /// it don't reach its callers.
+    pub recovers: bool,
+}
+
+impl Function {
+    /// Whether other packages can call it: the name, and for methods the
+    /// receiver type, are exported.
+    pub fn is_exported(&self) -> bool {
+        let exported = |name: &str| name.starts_with(char::is_uppercase);
+        exported(&self.name) && self.receiver.as_deref().is_none_or(exported)
+    }
+}
+
+/// One call site. `caller` and `callee` index [`CallGraph::functions`].
+#[derive(Debug, Clone, Serialize)]
+pub struct Call {
+    pub caller: usize,
+    pub callee: usize,
+    pub line: usize,
+    /// Resolved by the method name alone.
+    pub dynamic: bool,
+    /// Made by a `defer` statement.
+    pub deferred: bool,
+}
+
+#[derive(Debug, Default, Serialize)]
+pub struct CallGraph {
+    pub functions: Vec<Function>,
+    pub calls: Vec<Call>,
+    /// The import paths each package imports, blank imports included.
+    #[serde(skip)]
+    pub imports: BTreeMap<String, BTreeSet<String>>,
+    #[serde(skip)]
+    index: HashMap<String, usize>,
+}
+
+/// Each file of a module with its modification time, to tell when a cached
+/// graph is out of date.
+type Stamp = Vec<(PathBuf, Option<SystemTime>)>;
+
+/// A function declaration waiting for its calls to be resolved.
+struct Declaration<'t> {
+    function: usize,
+    body: Node<'t>,
+    receiver_name: Option<String>,
+}
+
+impl CallGraph {
+    pub fn build(files: &[GoFile]) -> CallGraph {
+        let mut graph = CallGraph::default();
+        let mut parser = Parser::new();
+        if parser
+            .set_language(&SupportedLanguage::Go.tree_sitter_language())
+            .is_err()
+        {
+            return graph;
+        }
+        let trees: Vec<_> = files
+            .iter()
+            .map(|file| parser.parse(&file.source_code, None))
+            .collect();
+
+        let mut declarations = Vec::new();
+        for (file, tree) in files.iter().zip(&trees) {
+            let Some(tree) = tree else {
+                declarations.push(Vec::new());
+                continue;
+            };
+            let imported = graph.imports.entry(file.package.clone()).or_default();
+            visit(tree.root_node(), &mut |node| {
+                if node.kind() == "import_spec" {
+                    imported.extend(import_path(node, &file.source_code).map(str::to_string));
+                }
+            });
+            declarations.push(graph.declare(file, tree.root_node()));
+        }
+
+        let mut methods: HashMap<&str, Vec<usize>> = HashMap::new();
+        for (i, function) in graph.functions.iter().enumerate() {
+            if function.receiver.is_some() {
+                methods.entry(function.name.as_str()).or_default().push(i);
+            }
+        }
+        // The enums and sealed interfaces of each package, which tell a
+        // `default: panic(...)` that can't be reached.
+        let mut roots: HashMap<&str, Vec<(Node, &str)>> = HashMap::new();
+        for (file, tree) in files.iter().zip(&trees) {
+            if let Some(tree) = tree {
+                roots
+                    .entry(file.package.as_str())
+                    .or_default()
+                    .push((tree.root_node(), file.source_code.as_str()));
+            }
+        }
+        let closed: HashMap<&str, Declarations> = roots
+            .iter()
+            .map(|(package, roots)| (*package, Declarations::in_files(roots)))
+            .collect();
+        let mut calls = Vec::new();
+        let mut facts = Vec::new();
+        for ((file, tree), declarations) in files.iter().zip(&trees).zip(&declarations) {
+            let Some(tree) = tree else {
+                continue;
+            };
+            let imports = imports(tree.root_node(), &file.source_code);
+            let closed = &closed[file.package.as_str()];
+            for declaration in declarations {
+                let body = graph.resolve(declaration, file, &imports, &methods, closed, &mut calls);
+                facts.push((declaration.function, body));
+            }
+        }
+        let mut calls_recover = vec![false; graph.functions.len()];
+        for (function, body) in facts {
+            calls_recover[function] = body.calls_recover;
+            graph.functions[function].panics = body.panics;
+            graph.functions[function].recovers = body.defers_recover;
+        }
+        // `defer handlePanic()` recovers too.
+        for call in &calls {
+            if call.deferred && calls_recover[call.callee] {
+                graph.functions[call.caller].recovers = true;
+            }
+        }
+        graph.calls = calls;
+        graph
+    }
+
+    /// The graph of `module`'s own packages, without tests. It is rebuilt
+    /// only when a file is added, removed or modified.
+    pub fn for_module(module: &Module) -> Arc<CallGraph> {
+        let paths = module_files(&module.root);
+        let stamp: Stamp = paths
+            .iter()
+            .map(|path| {
+                let modified = fs::metadata(path).and_then(|meta| meta.modified()).ok();
+                (path.clone(), modified)
+            })
+            .collect();
+
+        static CACHED: OnceLock<Mutex<HashMap<PathBuf, (Stamp, Arc<CallGraph>)>>> = OnceLock::new();
+        let cached = CACHED.get_or_init(Default::default);
+        if let Some((cached_stamp, graph)) = cached
+            .lock()
+            .ok()
+            .and_then(|cached| cached.get(&module.root).cloned())
+        {
+            if cached_stamp == stamp {
+                return graph;
+            }
+        }
+
+        let files: Vec<GoFile> = paths
+            .into_iter()
+            .filter_map(|path| {
+                let source_code = fs::read_to_string(&path).ok()?;
+                let package = module.package_path(path.parent()?)?;
+                Some(GoFile {
+                    package,
+                    path,
+                    source_code,
+                })
+            })
+            .collect();
+        let graph = Arc::new(CallGraph::build(&files));
+        if let Ok(mut cached) = cached.lock() {
+            cached.insert(module.root.clone(), (stamp, graph.clone()));
+        }
+        graph
+    }
+
+    /// The function with the given [`Function::id`].
+    pub fn find(&self, id: &str) -> Option<usize> {
+        self.index.get(id).copied()
+    }
+
+    /// The calls `function` makes.
+    pub fn callees(&self, function: usize) -> impl Iterator<Item = &Call> {
+        self.calls
+            .iter()
+            .filter(move |call| call.caller == function)
+    }
+
+    /// The calls made to `function`.
+    pub fn callers(&self, function: usize) -> impl Iterator<Item = &Call> {
+        self.calls
+            .iter()
+            .filter(move |call| call.callee == function)
+    }
+
+    /// The shortest chain of calls from `from` to a function matching
+    /// `target`, at most `max_depth` calls long and only through calls
+    /// matching `follow`. The chain starts with `from`.
+    pub fn path(
+        &self,
+        from: usize,
+        max_depth: Option<usize>,
+        follow: impl Fn(&Call) -> bool,
+        target: impl Fn(usize) -> bool,
+    ) -> Option<Vec<usize>> {
+        let mut previous: HashMap<usize, usize> = HashMap::new();
+        let mut seen = HashSet::from([from]);
+        let mut queue = VecDeque::from([(from, 0)]);
+        while let Some((function, depth)) = queue.pop_front() {
+            if target(function) {
+                let mut path = vec![function];
+                let mut current = function;
+                while let Some(&before) = previous.get(&current) {
+                    path.push(before);
+                    current = before;
+                }
+                path.reverse();
+                return Some(path);
+            }
+            if max_depth.is_some_and(|max| depth >= max) {
+                continue;
+            }
+            for call in self.callees(function).filter(|call| follow(call)) {
+                if seen.insert(call.callee) {
+                    previous.insert(call.callee, function);
+                    queue.push_back((call.callee, depth + 1));
+                }
+            }
+        }
+        None
+    }
+
+    /// The shortest chain of calls to `to` from a function matching
+    /// `source`, only through calls matching `follow`. The chain ends with
+    /// `to`.
+    pub fn path_to(
+        &self,
+        to: usize,
+        follow: impl Fn(&Call) -> bool,
+        source: impl Fn(usize) -> bool,
+    ) -> Option<Vec<usize>> {
+        let mut next: HashMap<usize, usize> = HashMap::new();
+        let mut seen = HashSet::from([to]);
+        let mut queue = VecDeque::from([to]);
+        while let Some(function) = queue.pop_front() {
+            if source(function) {
+                let mut path = vec![function];
+                let mut current = function;
+                while let Some(&after) = next.get(&current) {
+                    path.push(after);
+                    current = after;
+                }
+                return Some(path);
+            }
+            for call in self.callers(function).filter(|call| follow(call)) {
+                if seen.insert(call.caller) {
+                    next.insert(call.caller, function);
+                    queue.push_back(call.caller);
+                }
+            }
+        }
+        None
+    }
+
+    /// The graph in Graphviz DOT, with a cluster per package and dynamic
+    /// calls dashed.
+    pub fn to_dot(&self) -> String {
+        let mut packages: Vec<&str> = self
+            .functions
+            .iter()
+            .map(|function| function.package.as_str())
+            .collect();
+        packages.sort_unstable();
+        packages.dedup();
+
+        let mut dot = String::from("digraph callgraph {\n  rankdir=LR;\n  node [shape=box];\n");
+        for (i, package) in packages.iter().enumerate() {
+            dot.push_str(&format!(
+                "  subgraph cluster_{} {{\n    label=\"{}\";\n",
+                i,
+                escape(package)
+            ));
+            for (id, function) in self
+                .functions
+                .iter()
+                .enumerate()
+                .filter(|(_, function)| function.package == *package)
+            {
+                let label = match &function.receiver {
+                    Some(receiver) => format!("{}.{}", receiver, function.name),
+                    None => function.name.clone(),
+                };
+                dot.push_str(&format!("    f{} [label=\"{}\"];\n", id, escape(&label)));
+            }
+            dot.push_str("  }\n");
+        }
+
+        let mut edges = HashSet::new();
+        for call in &self.calls {
+            if !edges.insert((call.caller, call.callee)) {
+                continue;
+            }
+            let style = if call.dynamic { " [style=dashed]" } else { "" };
+            dot.push_str(&format!(
+                "  f{} -> f{}{};\n",
+                call.caller, call.callee, style
+            ));
+        }
+        dot.push_str("}\n");
+        dot
+    }
+
+    /// Adds the functions and methods declared in `root`.
+    fn declare<'t>(&mut self, file: &GoFile, root: Node<'t>) -> Vec<Declaration<'t>> {
+        let source_code = &file.source_code;
+        let mut declarations = Vec::new();
+        let mut cursor = root.walk();
+        for node in root.named_children(&mut cursor) {
+            let (Some(name), Some(body)) = (
+                node.child_by_field_name("name"),
+                node.child_by_field_name("body"),
+            ) else {
+                continue;
+            };
+            let Some(signature) = signature(&file.package, node, source_code) else {
+                continue;
+            };
+            let Signature {
+                id,
+                receiver,
+                receiver_name,
+            } = signature;
+            let name = node_text(name, source_code).to_string();
+            if self.index.contains_key(&id) {
+                continue;
+            }
+            self.index.insert(id.clone(), self.functions.len());
+            declarations.push(Declaration {
+                function: self.functions.len(),
+                body,
+                receiver_name,
+            });
+            self.functions.push(Function {
+                id,
+                package: file.package.clone(),
+                name,
+                receiver,
+                path: file.path.clone(),
+                line: node.start_position().row + 1,
+                parameters: node
+                    .child_by_field_name("parameters")
+                    .map(|list| parameter_types(list, source_code))
+                    .unwrap_or_default(),
+                panics: Vec::new(),
+                recovers: false,
+            });
+        }
+        declarations
+    }
+
+    fn resolve(
+        &self,
+        declaration: &Declaration,
+        file: &GoFile,
+        imports: &HashMap<String, String>,
+        methods: &HashMap<&str, Vec<usize>>,
+        closed: &Declarations,
+        calls: &mut Vec<Call>,
+    ) -> Body {
+        let source_code = &file.source_code;
+        let caller = &self.functions[declaration.function];
+        let mut body = Body::default();
+        visit(declaration.body, &mut |node| {
+            if node.kind() != "call_expression" {
+                return;
+            }
+            let Some(function) = callee(node) else {
+                return;
+            };
+            let line = node.start_position().row + 1;
+            let deferred = node
+                .parent()
+                .is_some_and(|parent| parent.kind() == "defer_statement");
+            let mut call = |callee: usize, dynamic: bool| {
+                calls.push(Call {
+                    caller: declaration.function,
+                    callee,
+                    line,
+                    dynamic,
+                    deferred,
+                });
+            };
+            match function.kind() {
+                "identifier" => {
+                    let name = node_text(function, source_code);
+                    match name {
+                        "panic" if !is_unreachable_default(node, closed, source_code) => {
+                            body.panics.push(line)
+                        }
+                        "recover" => {
+                            body.calls_recover = true;
+                            body.defers_recover |= in_deferred_literal(node);
+                        }
+                        _ => {}
+                    }
+                    let id = format!("{}.{}", file.package, name);
+                    if let Some(callee) = self.find(&id) {
+                        call(callee, false);
+                    }
+                }
+                "selector_expression" => {
+                    let (Some(operand), Some(field)) = (
+                        function.child_by_field_name("operand"),
+                        function.child_by_field_name("field"),
+                    ) else {
+                        return;
+                    };
+                    let field = node_text(field, source_code);
+                    let operand_name =
+                        (operand.kind() == "identifier").then(|| node_text(operand, source_code));
+                    if let Some(path) = operand_name.and_then(|name| imports.get(name)) {
+                        if let Some(callee) = self.find(&format!("{}.{}", path, field)) {
+                            call(callee, false);
+                        }
+                        return;
+                    }
+                    let candidates = methods.get(field).map(Vec::as_slice).unwrap_or_default();
+                    let own = operand_name
+                        .filter(|name| declaration.receiver_name.as_deref() == Some(*name))
+                        .and_then(|_| {
+                            candidates.iter().copied().find(|&candidate| {
+                                let method = &self.functions[candidate];
+                                method.package == caller.package
+                                    && method.receiver == caller.receiver
+                            })
+                        });
+                    match own {
+                        Some(callee) => call(callee, false),
+                        None => candidates.iter().for_each(|&callee| call(callee, true)),
+                    }
+                }
+                _ => {}
+            }
+        });
+        body
+    }
+}
+
+/// What [`CallGraph::build`] learns from a function's body besides its calls.
+#[derive(Default)]
+struct Body {
+    panics: Vec<usize>,
+    /// It defers a function literal that calls `recover`.
+    defers_recover: bool,
+    calls_recover: bool,
+}
+
+struct Signature {
+    id: String,
+    receiver: Option<String>,
+    receiver_name: Option<String>,
+}
+
+fn signature(package: &str, declaration: Node, source_code: &str) -> Option<Signature> {
+    let name = node_text(declaration.child_by_field_name("name")?, source_code);
+    match declaration.kind() {
+        "function_declaration" => Some(Signature {
+            id: format!("{}.{}", package, name),
+            receiver: None,
+            receiver_name: None,
+        }),
+        "method_declaration" => {
+            let (receiver, pointer, receiver_name) = receiver(declaration, source_code)?;
+            let star = if pointer { "*" } else { "" };
+            Some(Signature {
+                id: format!("({}{}.{}).{}", star, package, receiver, name),
+                receiver: Some(receiver),
+                receiver_name,
+            })
+        }
+        _ => None,
+    }
+}
+
+/// The [`Function::id`] of a function or method declaration in `package`.
+pub fn declaration_id(package: &str, declaration: Node, source_code: &str) -> Option<String> {
+    signature(package, declaration, source_code).map(|signature| signature.id)
+}
+
+/// Whether `call` is in a function literal that is deferred, as in
+/// `defer func() { recover() }()`.
+fn in_deferred_literal(call: Node) -> bool {
+    let mut current = call.parent();
+    while let Some(node) = current {
+        if node.kind() == "func_literal" {
+            return node
+                .parent()
+                .filter(|parent| parent.kind() == "call_expression")
+                .and_then(|parent| parent.parent())
+                .is_some_and(|statement| statement.kind() == "defer_statement");
+        }
+        current = node.parent();
+    }
+    false
+}
+
+/// The receiver type's name, whether it is a pointer, and the receiver's
+/// variable name, of a method declaration.
+fn receiver(method: Node, source_code: &str) -> Option<(String, bool, Option<String>)> {
+    let list = method.child_by_field_name("receiver")?;
+    let mut cursor = list.walk();
+    let parameter = list
+        .named_children(&mut cursor)
+        .find(|child| child.kind() == "parameter_declaration")?;
+    let name = parameter
+        .child_by_field_name("name")
+        .map(|name| node_text(name, source_code).to_string());
+    let mut ty = parameter.child_by_field_name("type")?;
+    let pointer = ty.kind() == "pointer_type";
+    if pointer {
+        ty = ty.named_child(0)?;
+    }
+    if ty.kind() == "generic_type" {
+        ty = ty.child_by_field_name("type")?;
+    }
+    Some((node_text(ty, source_code).to_string(), pointer, name))
+}
+
+/// Whether the graph covers `path`: a Go file that isn't a test.
+pub fn is_graphed(path: &Path) -> bool {
+    path.extension().is_some_and(|ext| ext == "go") && !path.to_string_lossy().ends_with("_test.go")
+}
+
+/// The Go files of the module at `root`, leaving out tests and nested modules.
+fn module_files(root: &Path) -> Vec<PathBuf> {
+    let Ok(files) = walk::source_files(root) else {
+        return Vec::new();
+    };
+    files
+        .into_iter()
+        .filter(|path| is_graphed(path))
+        .filter(|path| {
+            path.ancestors()
+                .skip(1)
+                .take_while(|dir| *dir != root)
+                .all(|dir| !dir.join(GO_MOD_FILE).is_file())
+        })
+        .collect()
+}
+
+fn escape(text: &str) -> String {
+    text.replace('\\', "\\\\").replace('"', "\\\"")
+}
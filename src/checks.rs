@@ -0,0 +1,727 @@
+mod api_misuse;
+mod assertion;
+mod channel;
+mod complexity;
+mod constant;
+mod context;
+mod contract;
+mod defer;
+mod dependency;
+mod deprecated;
+mod doc_comment;
+mod error_design;
+mod error_wrapping;
+mod exhaustive;
+mod exit;
+mod flow;
+mod generics;
+mod global_state;
+mod goroutine_leak;
+mod grpc;
+mod import_policy;
+mod interface;
+mod layout;
+mod literal;
+mod logging;
+mod loop_capture;
+mod mutex;
+mod nil_dereference;
+mod panic;
+mod panic_reachable;
+mod performance;
+mod resource_leak;
+mod rows_err;
+mod secret;
+mod serialization;
+mod sql;
+mod taint;
+mod test_coverage;
+mod testing;
+mod time;
+mod timeout;
+mod transaction;
+mod unchecked_error;
+mod unreachable;
+mod unsafe_usage;
+mod unused;
+mod unused_import;
+mod unused_result;
+mod vulnerable;
+
+use crate::analyzer::{Confidence, RelatedLocation};
+use crate::fix::Fix;
+use crate::package::Package;
+use channel::{ChannelIssue, GoChannel};
+use complexity::{Complexity, Metric};
+use constant::{ConstantIssue, GoConstant};
+use defer::{DeferIssue, GoDefer};
+use error_design::{ErrorDesignIssue, GoErrorDesign};
+use error_wrapping::{ErrorIssue, GoErrorWrapping};
+pub(crate) use exhaustive::Declarations;
+use exit::{ExitIssue, GoExit};
+pub(crate) use generics::callee;
+use generics::{GenericIssue, GoGenerics};
+use global_state::{GlobalStateIssue, GoGlobalState};
+pub(crate) use import_policy::Pattern;
+use interface::{GoInterface, InterfaceIssue};
+use literal::{GoStringLiteral, LiteralIssue};
+use logging::{GoLogging, LogIssue};
+pub(crate) use panic::{is_unreachable_default, matches_name};
+use performance::{GoPerformance, PerformanceIssue};
+use secret::{GoSecret, SecretIssue};
+use serialization::{GoSerialization, SerializationIssue};
+use sql::{GoSqlQuery, SqlIssue};
+use std::sync::Arc;
+use taint::{GoTaint, TaintKind};
+use testing::{GoTesting, TestIssue};
+use time::{GoTime, TimeIssue};
+use timeout::{GoTimeout, TimeoutIssue};
+use transaction::{GoTransaction, TransactionIssue};
+use tree_sitter::Node;
+use unsafe_usage::{GoUnsafe, UnsafeIssue};
+pub(crate) use unused_import::{import_path, imports, local_name};
+
+/// A finding produced by a built-in check, anchored at a syntax node.
+pub struct Hit<'t> {
+    pub node: Node<'t>,
+    pub fix: Option<Fix>,
+    /// Replaces the rule's message for this finding.
+    pub message: Option<String>,
+    /// Other places that explain the finding, each with a short note.
+    pub related: Vec<(Node<'t>, String)>,
+    /// More of them, already resolved, such as places in other files of
+    /// the package. They follow `related`.
+    pub locations: Vec<RelatedLocation>,
+    /// Lowers the rule's confidence for this finding.
+    pub confidence: Option<Confidence>,
+    /// Replaces the rule's suggestion for this finding.
+    pub suggestion: Option<String>,
+}
+
+impl<'t> Hit<'t> {
+    pub fn new(node: Node<'t>) -> Self {
+        Hit {
+            node,
+            fix: None,
+            message: None,
+            related: Vec::new(),
+            locations: Vec::new(),
+            confidence: None,
+            suggestion: None,
+        }
+    }
+
+    pub fn with_message(mut self, message: String) -> Self {
+        self.message = Some(message);
+        self
+    }
+
+    pub fn with_related(mut self, node: Node<'t>, message: &str) -> Self {
+        self.related.push((node, message.to_string()));
+        self
+    }
+
+    pub fn with_location(mut self, location: RelatedLocation) -> Self {
+        self.locations.push(location);
+        self
+    }
+
+    pub fn with_fix(mut self, fix: Fix) -> Self {
+        self.fix = Some(fix);
+        self
+    }
+
+    pub fn with_confidence(mut self, confidence: Confidence) -> Self {
+        self.confidence = Some(confidence);
+        self
+    }
+
+    pub fn with_suggestion(mut self, suggestion: String) -> Self {
+        self.suggestion = Some(suggestion);
+        self
+    }
+}
+
+/// Analysis that can't be expressed as a single tree-sitter query. Rules opt
+/// into a check with `check = "<name>"` instead of a `query`; the rule still
+/// supplies the severity, message and weight, plus any `[rules.options]`.
+pub trait Check: Send + Sync {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>>;
+
+    /// Whether the check looks at the rest of the package. Only then is a
+    /// [`Package`] loaded and passed to [`Check::run_in_package`].
+    fn reads_package(&self) -> bool {
+        false
+    }
+
+    /// Whether the check uses [`Package::call_graph`], which depends on
+    /// every package of the module, so its results can't be cached per
+    /// package.
+    fn reads_call_graph(&self) -> bool {
+        false
+    }
+
+    /// Whether the check can't be trusted on the file alone: it reports
+    /// nothing without the package, or reports what the rest of it would
+    /// rule out. `--mode syntax`, which loads no package, leaves such checks
+    /// out; those that only sharpen their findings with one still run.
+    fn needs_package(&self) -> bool {
+        false
+    }
+
+    /// What the check's findings depend on. Checks of
+    /// [`Granularity::Declaration`] are only re-run on the declarations an
+    /// edit touched; see [`crate::incremental`].
+    fn granularity(&self) -> Granularity {
+        Granularity::File
+    }
+
+    /// Like [`Check::run`], with the file's package when it is known.
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let _ = package;
+        self.run(root, source_code, options)
+    }
+}
+
+/// How much of the file a check reads to report a finding.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum Granularity {
+    /// The top-level declaration the finding is in, and the package when
+    /// the check reads it. Run with a declaration as its root, the check
+    /// reports exactly what it reports inside it when run on the file.
+    Declaration,
+    /// Anything in the file, such as its imports or other functions.
+    #[default]
+    File,
+}
+
+/// The `[rules.options]` table of a rule, with typed accessors.
+#[derive(Debug, Clone, Default)]
+pub struct RuleOptions {
+    table: toml::Table,
+}
+
+impl RuleOptions {
+    /// Keys spelled with `-`, as golangci-lint spells them, are read as
+    /// their `_` form unless that is set too; see [`deprecated_spelling`].
+    pub fn new(table: toml::Table) -> Self {
+        let mut normalized = toml::Table::new();
+        for (key, value) in &table {
+            let key = match deprecated_spelling(key) {
+                Some(name) if !table.contains_key(&name) => name,
+                _ => key.clone(),
+            };
+            normalized.insert(key, value.clone());
+        }
+        RuleOptions { table: normalized }
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.table.is_empty()
+    }
+
+    pub fn keys(&self) -> impl Iterator<Item = &str> {
+        self.table.keys().map(String::as_str)
+    }
+
+    pub fn get(&self, key: &str) -> Option<&toml::Value> {
+        self.table.get(key)
+    }
+
+    pub fn bool(&self, key: &str) -> Option<bool> {
+        self.table.get(key)?.as_bool()
+    }
+
+    pub fn usize(&self, key: &str) -> Option<usize> {
+        let value = self.table.get(key)?.as_integer()?;
+        usize::try_from(value).ok()
+    }
+
+    /// A number, written either as a float or an integer.
+    pub fn float(&self, key: &str) -> Option<f64> {
+        let value = self.table.get(key)?;
+        value
+            .as_float()
+            .or_else(|| value.as_integer().map(|value| value as f64))
+    }
+
+    pub fn string(&self, key: &str) -> Option<&str> {
+        self.table.get(key)?.as_str()
+    }
+
+    pub fn string_list(&self, key: &str) -> Option<Vec<String>> {
+        let values = self.table.get(key)?.as_array()?;
+        Some(
+            values
+                .iter()
+                .filter_map(|value| value.as_str().map(str::to_string))
+                .collect(),
+        )
+    }
+}
+
+/// What an option of a built-in check holds.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum OptionKind {
+    Bool,
+    /// A non-negative integer.
+    Count,
+    /// A float or an integer.
+    Number,
+    String,
+    Strings,
+    /// A list of non-negative integers.
+    Counts,
+}
+
+impl OptionKind {
+    fn name(self) -> &'static str {
+        match self {
+            OptionKind::Bool => "a bool",
+            OptionKind::Count => "a non-negative integer",
+            OptionKind::Number => "a number",
+            OptionKind::String => "a string",
+            OptionKind::Strings => "a list of strings",
+            OptionKind::Counts => "a list of non-negative integers",
+        }
+    }
+
+    fn accepts(self, value: &toml::Value) -> bool {
+        let count = |value: &toml::Value| value.as_integer().is_some_and(|n| n >= 0);
+        match self {
+            OptionKind::Bool => value.is_bool(),
+            OptionKind::Count => count(value),
+            OptionKind::Number => value.is_float() || value.is_integer(),
+            OptionKind::String => value.is_str(),
+            OptionKind::Strings => value
+                .as_array()
+                .is_some_and(|values| values.iter().all(toml::Value::is_str)),
+            OptionKind::Counts => value
+                .as_array()
+                .is_some_and(|values| values.iter().all(count)),
+        }
+    }
+}
+
+/// How a value is described in errors: its type, and for lists the type of
+/// the first element that doesn't fit.
+fn describe(value: &toml::Value, expected: OptionKind) -> String {
+    let article = |value: &toml::Value| match value.type_str() {
+        kind @ ("integer" | "array") => format!("an {}", kind),
+        kind => format!("a {}", kind),
+    };
+    match (value, expected) {
+        (toml::Value::Integer(n), OptionKind::Count) if *n < 0 => format!("{}", n),
+        (toml::Value::Array(values), OptionKind::Strings | OptionKind::Counts) => {
+            let element = match expected {
+                OptionKind::Strings => OptionKind::String,
+                _ => OptionKind::Count,
+            };
+            match values.iter().find(|value| !element.accepts(value)) {
+                Some(value) => format!("a list containing {}", describe(value, element)),
+                None => article(value),
+            }
+        }
+        _ => article(value),
+    }
+}
+
+/// The `_` spelling of an option name written with `-`, which is read but
+/// deprecated.
+pub fn deprecated_spelling(key: &str) -> Option<String> {
+    key.contains('-').then(|| key.replace('-', "_"))
+}
+
+/// The options the built-in check `name` reads, or `None` when there is no
+/// such built-in check.
+pub fn option_schema(name: &str) -> Option<&'static [(&'static str, OptionKind)]> {
+    let schema = match name {
+        "cognitive_complexity" | "cyclomatic_complexity" => complexity::OPTIONS,
+        "go_any_parameter" => interface::PARAMETER_OPTIONS,
+        "go_api_misuse" => api_misuse::OPTIONS,
+        "go_context_propagation" => context::OPTIONS,
+        "go_defer_error" => defer::OPTIONS,
+        "go_doc_comment" => doc_comment::OPTIONS,
+        "go_error_string" | "go_error_var_name" => error_design::OPTIONS,
+        "go_magic_number" => constant::NUMBER_OPTIONS,
+        "go_deprecated_call" => deprecated::OPTIONS,
+        "go_exhaustive" => exhaustive::OPTIONS,
+        "go_exit_defers" => exit::OPTIONS,
+        "go_exit_outside_main" => exit::MAIN_OPTIONS,
+        "go_import_policy" => import_policy::OPTIONS,
+        "go_init_side_effect" => global_state::INIT_OPTIONS,
+        "go_interface_assertion" => interface::ASSERTION_OPTIONS,
+        "go_json_untagged" => serialization::UNTAGGED_OPTIONS,
+        "go_log_format" | "go_log_key_values" => logging::OPTIONS,
+        "go_log_in_loop" => logging::LOOP_OPTIONS,
+        "go_log_secret" => logging::SECRET_OPTIONS,
+        "go_mod_archived" => dependency::ARCHIVED_OPTIONS,
+        "go_mutable_global" => global_state::VARIABLE_OPTIONS,
+        "go_mod_unmaintained" => dependency::UNMAINTAINED_OPTIONS,
+        "go_mod_vulnerable" => dependency::VULNERABLE_OPTIONS,
+        "go_mod_local_replace" | "go_mod_major_version" | "go_mod_retracted" => dependency::OPTIONS,
+        "go_nil_dereference" => nil_dereference::OPTIONS,
+        "go_repeated_string" => constant::STRING_OPTIONS,
+        "go_omitempty_zero" => serialization::OMITEMPTY_OPTIONS,
+        "go_panic" => panic::OPTIONS,
+        "go_panic_reachable" => panic_reachable::OPTIONS,
+        "go_policy" => assertion::OPTIONS,
+        "go_resource_leak" => resource_leak::OPTIONS,
+        "go_secret_assignment"
+        | "go_secret_cloud_key"
+        | "go_secret_private_key"
+        | "go_secret_url" => secret::OPTIONS,
+        "go_sql_concatenation" | "go_sql_syntax" | "go_sql_select_star" => sql::OPTIONS,
+        "go_tag_mismatch" | "go_unexported_tag" => serialization::KEY_OPTIONS,
+        "go_struct_layout" => layout::OPTIONS,
+        "go_sql_injection"
+        | "go_command_injection"
+        | "go_path_traversal"
+        | "go_template_injection" => taint::OPTIONS,
+        "go_test_coverage" => test_coverage::OPTIONS,
+        "go_tx_unfinished" => transaction::OPTIONS,
+        "go_grpc_deadline"
+        | "go_http_client_timeout"
+        | "go_http_server_timeout"
+        | "go_net_dial_timeout" => timeout::OPTIONS,
+        "go_unreachable" => unreachable::OPTIONS,
+        "go_unused" => unused::OPTIONS,
+        "go_unused_result" => unused_result::OPTIONS,
+        "go_vulnerable_call" => vulnerable::OPTIONS,
+        "go_cgo" | "go_linkname" | "go_reflect_header" | "go_unsafe_pointer" => {
+            unsafe_usage::OPTIONS
+        }
+        _ if builtin(name).is_some() => &[],
+        _ => return None,
+    };
+    Some(schema)
+}
+
+/// Every option of a rule using the built-in check `name` that the check
+/// doesn't read, or whose value has the wrong type. Checks that aren't
+/// built in, such as plugins, aren't checked.
+pub fn option_errors(name: &str, options: &RuleOptions) -> Vec<String> {
+    option_schema(name).map_or_else(Vec::new, |schema| schema_errors(schema, options))
+}
+
+fn schema_errors(schema: &[(&str, OptionKind)], options: &RuleOptions) -> Vec<String> {
+    let mut errors = Vec::new();
+    for (key, value) in &options.table {
+        match schema.iter().find(|(option, _)| option == key) {
+            Some((_, kind)) if !kind.accepts(value) => errors.push(format!(
+                "option '{}' expects {}, got {}",
+                key,
+                kind.name(),
+                describe(value, *kind)
+            )),
+            Some(_) => {}
+            None => errors.extend(unknown_option(schema, key)),
+        }
+    }
+    errors
+}
+
+/// The error for `key` when `schema` doesn't have it.
+pub(super) fn unknown_option(schema: &[(&str, OptionKind)], key: &str) -> Option<String> {
+    if schema.iter().any(|(option, _)| *option == key) {
+        return None;
+    }
+    if schema.is_empty() {
+        return Some(format!(
+            "unknown option '{}' (the check takes no options)",
+            key
+        ));
+    }
+    Some(format!(
+        "unknown option '{}' (expected one of: {})",
+        key,
+        schema
+            .iter()
+            .map(|(option, _)| *option)
+            .collect::<Vec<_>>()
+            .join(", ")
+    ))
+}
+
+/// The checks `compass audit` lists every use of, including the uses their
+/// rules allow.
+pub const AUDIT_CHECKS: &[&str] = &[
+    "go_cgo",
+    "go_init_side_effect",
+    "go_linkname",
+    "go_mutable_global",
+    "go_reflect_header",
+    "go_unsafe_pointer",
+];
+
+/// The checks `--vuln` turns on, which report known vulnerabilities.
+pub const VULN_CHECKS: &[&str] = &["go_mod_vulnerable", "go_vulnerable_call"];
+
+pub fn builtin(name: &str) -> Option<Arc<dyn Check>> {
+    match name {
+        "cognitive_complexity" => Some(Arc::new(Complexity::new(Metric::Cognitive))),
+        "cyclomatic_complexity" => Some(Arc::new(Complexity::new(Metric::Cyclomatic))),
+        "go_any_parameter" => Some(Arc::new(GoInterface::new(InterfaceIssue::AnyParameter))),
+        "go_api_misuse" => Some(Arc::new(api_misuse::GoApiMisuse)),
+        "go_cgo" => Some(Arc::new(GoUnsafe::new(UnsafeIssue::Cgo))),
+        "go_channel_nil" => Some(Arc::new(GoChannel::new(ChannelIssue::NilChannel))),
+        "go_channel_receiver_close" => Some(Arc::new(GoChannel::new(ChannelIssue::ReceiverClose))),
+        "go_channel_send_after_close" => {
+            Some(Arc::new(GoChannel::new(ChannelIssue::SendAfterClose)))
+        }
+        "go_channel_unbuffered_signal" => {
+            Some(Arc::new(GoChannel::new(ChannelIssue::UnbufferedSignal)))
+        }
+        "go_context_propagation" => Some(Arc::new(context::GoContextPropagation)),
+        "go_defer_arguments" => Some(Arc::new(GoDefer::new(DeferIssue::EarlyArguments))),
+        "go_defer_error" => Some(Arc::new(GoDefer::new(DeferIssue::DroppedError))),
+        "go_defer_in_loop" => Some(Arc::new(GoDefer::new(DeferIssue::InLoop))),
+        "go_deprecated_call" => Some(Arc::new(deprecated::GoDeprecatedCall)),
+        "go_doc_comment" => Some(Arc::new(doc_comment::GoDocComment)),
+        "go_duration_unit" => Some(Arc::new(GoConstant::new(ConstantIssue::DurationUnit))),
+        "go_error_as" => Some(Arc::new(GoErrorWrapping::new(ErrorIssue::TypeAssertion))),
+        "go_error_compared_by_text" => Some(Arc::new(GoErrorDesign::new(
+            ErrorDesignIssue::ComparedByText,
+        ))),
+        "go_error_is" => Some(Arc::new(GoErrorWrapping::new(
+            ErrorIssue::SentinelComparison,
+        ))),
+        "go_error_string" => Some(Arc::new(GoErrorDesign::new(ErrorDesignIssue::StringStyle))),
+        "go_error_var_name" => Some(Arc::new(GoErrorDesign::new(ErrorDesignIssue::VariableName))),
+        "go_error_wrap" => Some(Arc::new(GoErrorWrapping::new(ErrorIssue::WrapVerb))),
+        "go_errorf_no_context" => Some(Arc::new(GoErrorWrapping::new(ErrorIssue::NoContext))),
+        "go_exhaustive" => Some(Arc::new(exhaustive::GoExhaustive)),
+        "go_exit_defers" => Some(Arc::new(GoExit::new(ExitIssue::SkipsDefers))),
+        "go_exit_outside_main" => Some(Arc::new(GoExit::new(ExitIssue::OutsideMain))),
+        "go_generic_method" => Some(Arc::new(GoGenerics::new(GenericIssue::IndependentMethod))),
+        "go_generic_single_type" => {
+            Some(Arc::new(GoGenerics::new(GenericIssue::SingleInstantiation)))
+        }
+        "go_goroutine_leak" => Some(Arc::new(goroutine_leak::GoGoroutineLeak)),
+        "go_grpc_deadline" => Some(Arc::new(GoTimeout::new(TimeoutIssue::GrpcDeadline))),
+        "go_grpc_dial" => Some(Arc::new(grpc::GoGrpcDial)),
+        "go_http_client_timeout" => Some(Arc::new(GoTimeout::new(TimeoutIssue::HttpClient))),
+        "go_http_server_timeout" => Some(Arc::new(GoTimeout::new(TimeoutIssue::HttpServer))),
+        "go_import_policy" => Some(Arc::new(import_policy::GoImportPolicy)),
+        "go_init_side_effect" => Some(Arc::new(GoGlobalState::new(
+            GlobalStateIssue::InitSideEffect,
+        ))),
+        "go_interface_assertion" => {
+            Some(Arc::new(GoInterface::new(InterfaceIssue::MissingAssertion)))
+        }
+        "go_interface_near_miss" => Some(Arc::new(GoInterface::new(InterfaceIssue::NearMiss))),
+        "go_json_untagged" => Some(Arc::new(GoSerialization::new(SerializationIssue::Untagged))),
+        "go_linkname" => Some(Arc::new(GoUnsafe::new(UnsafeIssue::Linkname))),
+        "go_log_format" => Some(Arc::new(GoLogging::new(LogIssue::FormatString))),
+        "go_log_key_values" => Some(Arc::new(GoLogging::new(LogIssue::KeyValues))),
+        "go_log_in_loop" => Some(Arc::new(GoLogging::new(LogIssue::HotLoop))),
+        "go_log_secret" => Some(Arc::new(GoLogging::new(LogIssue::Secret))),
+        "go_loop_capture" => Some(Arc::new(loop_capture::GoLoopCapture)),
+        "go_magic_number" => Some(Arc::new(GoConstant::new(ConstantIssue::MagicNumber))),
+        "go_mod_archived"
+        | "go_mod_local_replace"
+        | "go_mod_major_version"
+        | "go_mod_retracted"
+        | "go_mod_unmaintained"
+        | "go_mod_vulnerable" => Some(Arc::new(dependency::GoModule)),
+        "go_mutable_global" => Some(Arc::new(GoGlobalState::new(
+            GlobalStateIssue::MutableGlobal,
+        ))),
+        "go_mutex" => Some(Arc::new(mutex::GoMutex)),
+        "go_net_dial_timeout" => Some(Arc::new(GoTimeout::new(TimeoutIssue::NetDial))),
+        "go_nil_dereference" => Some(Arc::new(nil_dereference::GoNilDereference)),
+        "go_package_scope_config" => Some(Arc::new(GoGlobalState::new(
+            GlobalStateIssue::PackageScopeConfig,
+        ))),
+        "go_omitempty_zero" => Some(Arc::new(GoSerialization::new(
+            SerializationIssue::OmitEmptyZero,
+        ))),
+        "go_panic" => Some(Arc::new(panic::GoPanic)),
+        "go_panic_reachable" => Some(Arc::new(panic_reachable::GoPanicReachable)),
+        "go_policy" => Some(Arc::new(assertion::GoPolicy)),
+        "go_prealloc" => Some(Arc::new(GoPerformance::new(PerformanceIssue::Prealloc))),
+        "go_reflect_header" => Some(Arc::new(GoUnsafe::new(UnsafeIssue::SliceHeader))),
+        "go_repeated_string" => Some(Arc::new(GoConstant::new(ConstantIssue::RepeatedString))),
+        "go_regexp_compile" => Some(Arc::new(GoPerformance::new(
+            PerformanceIssue::RegexpCompile,
+        ))),
+        "go_regexp_nested_repetition" => {
+            Some(Arc::new(GoStringLiteral::new(LiteralIssue::RegexpNested)))
+        }
+        "go_regexp_syntax" => Some(Arc::new(GoStringLiteral::new(LiteralIssue::RegexpSyntax))),
+        "go_resource_leak" => Some(Arc::new(resource_leak::GoResourceLeak)),
+        "go_rows_err" => Some(Arc::new(rows_err::GoRowsErr)),
+        "go_secret_assignment" => Some(Arc::new(GoSecret::new(SecretIssue::Assignment))),
+        "go_secret_cloud_key" => Some(Arc::new(GoSecret::new(SecretIssue::CloudKey))),
+        "go_secret_private_key" => Some(Arc::new(GoSecret::new(SecretIssue::PrivateKey))),
+        "go_secret_url" => Some(Arc::new(GoSecret::new(SecretIssue::UrlCredentials))),
+        "go_select_single_case" => Some(Arc::new(GoChannel::new(ChannelIssue::SingleCaseSelect))),
+        "go_size_literal" => Some(Arc::new(GoConstant::new(ConstantIssue::SizeLiteral))),
+        "go_string_concat" => Some(Arc::new(GoPerformance::new(PerformanceIssue::StringConcat))),
+        "go_sql_concatenation" => Some(Arc::new(GoSqlQuery::new(SqlIssue::Concatenation))),
+        "go_sql_syntax" => Some(Arc::new(GoSqlQuery::new(SqlIssue::Syntax))),
+        "go_sql_select_star" => Some(Arc::new(GoSqlQuery::new(SqlIssue::SelectStar))),
+        "go_sql_injection" => Some(Arc::new(GoTaint::new(TaintKind::Sql))),
+        "go_struct_layout" => Some(Arc::new(layout::GoStructLayout)),
+        "go_struct_tag" => Some(Arc::new(GoStringLiteral::new(LiteralIssue::StructTag))),
+        "go_command_injection" => Some(Arc::new(GoTaint::new(TaintKind::Command))),
+        "go_path_traversal" => Some(Arc::new(GoTaint::new(TaintKind::Path))),
+        "go_tag_mismatch" => Some(Arc::new(GoSerialization::new(
+            SerializationIssue::NameMismatch,
+        ))),
+        "go_template_injection" => Some(Arc::new(GoTaint::new(TaintKind::Template))),
+        "go_template_escaper" => Some(Arc::new(GoStringLiteral::new(
+            LiteralIssue::TemplateEscaper,
+        ))),
+        "go_template_syntax" => Some(Arc::new(GoStringLiteral::new(LiteralIssue::TemplateSyntax))),
+        "go_time_after" => Some(Arc::new(GoTime::new(TimeIssue::AfterInLoop))),
+        "go_time_equal" => Some(Arc::new(GoTime::new(TimeIssue::Equality))),
+        "go_time_since" => Some(Arc::new(GoTime::new(TimeIssue::NowSub))),
+        "go_time_tick" => Some(Arc::new(GoTime::new(TimeIssue::Tick))),
+        "go_test_coverage" => Some(Arc::new(test_coverage::GoTestCoverage)),
+        "go_test_error_string" => Some(Arc::new(GoTesting::new(TestIssue::ErrorString))),
+        "go_test_goroutine_fatal" => Some(Arc::new(GoTesting::new(TestIssue::FatalInGoroutine))),
+        "go_test_helper" => Some(Arc::new(GoTesting::new(TestIssue::MissingHelper))),
+        "go_test_parallel" => Some(Arc::new(GoTesting::new(TestIssue::ParallelSubtest))),
+        "go_test_sleep" => Some(Arc::new(GoTesting::new(TestIssue::Sleep))),
+        "go_tx_outside_query" => Some(Arc::new(GoTransaction::new(TransactionIssue::OutsideQuery))),
+        "go_tx_rollback_after_commit" => Some(Arc::new(GoTransaction::new(
+            TransactionIssue::RollbackAfterCommit,
+        ))),
+        "go_tx_unfinished" => Some(Arc::new(GoTransaction::new(TransactionIssue::Unfinished))),
+        "go_unchecked_error" => Some(Arc::new(unchecked_error::GoUncheckedError)),
+        "go_unreachable" => Some(Arc::new(unreachable::GoUnreachable)),
+        "go_unsafe_pointer" => Some(Arc::new(GoUnsafe::new(UnsafeIssue::Pointer))),
+        "go_unexported_tag" => Some(Arc::new(GoSerialization::new(
+            SerializationIssue::UnexportedTag,
+        ))),
+        "go_unused" => Some(Arc::new(unused::GoUnused)),
+        "go_unused_import" => Some(Arc::new(unused_import::GoUnusedImport)),
+        "go_unused_result" => Some(Arc::new(unused_result::GoUnusedResult)),
+        "go_vulnerable_call" => Some(Arc::new(vulnerable::GoVulnerableCall)),
+        _ => None,
+    }
+}
+
+/// Checks the options of a rule using the built-in check `name`: their
+/// names and types, and for checks whose options are declarations that can
+/// be wrong, what they declare.
+pub fn validate_options(name: &str, options: &RuleOptions) -> Result<(), String> {
+    if let Some(error) = option_errors(name, options).into_iter().next() {
+        return Err(error);
+    }
+    match name {
+        "go_api_misuse" => api_misuse::Signature::compile(options).map(drop),
+        "go_doc_comment" => doc_comment::checked_packages(options).map(drop),
+        "go_import_policy" => import_policy::Policy::compile(options).map(drop),
+        "go_json_untagged" => serialization::api_packages(options).map(drop),
+        "go_policy" => assertion::assertions(options).map(drop),
+        "go_secret_assignment"
+        | "go_secret_cloud_key"
+        | "go_secret_private_key"
+        | "go_secret_url" => secret::allowlist(options).map(drop),
+        "go_cgo" | "go_linkname" | "go_reflect_header" | "go_unsafe_pointer" => {
+            unsafe_usage::allowed_packages(options).map(drop)
+        }
+        "go_grpc_deadline"
+        | "go_http_client_timeout"
+        | "go_http_server_timeout"
+        | "go_net_dial_timeout" => timeout::max_timeout(options).map(drop),
+        "go_mod_unmaintained" => dependency::max_years(options),
+        _ => Ok(()),
+    }
+}
+
+/// Calls `visitor` on `root` and every node below it in document order.
+pub fn visit<'t>(root: Node<'t>, visitor: &mut dyn FnMut(Node<'t>)) {
+    let mut cursor = root.walk();
+    'outer: loop {
+        visitor(cursor.node());
+        if cursor.goto_first_child() || cursor.goto_next_sibling() {
+            continue;
+        }
+        loop {
+            if !cursor.goto_parent() {
+                break 'outer;
+            }
+            if cursor.goto_next_sibling() {
+                continue 'outer;
+            }
+        }
+    }
+}
+
+pub fn node_text<'s>(node: Node, source_code: &'s str) -> &'s str {
+    node.utf8_text(source_code.as_bytes()).unwrap_or("")
+}
+
+/// Whether a Go identifier is exported: it starts with an upper-case letter.
+pub fn is_exported(name: &str) -> bool {
+    name.chars().next().is_some_and(char::is_uppercase)
+}
+
+/// The name of a method's receiver type, without a `*` or type parameters.
+pub fn receiver_type<'s>(method: Node, source_code: &'s str) -> Option<&'s str> {
+    let receiver = method.child_by_field_name("receiver")?;
+    let mut cursor = receiver.walk();
+    let parameter = receiver
+        .named_children(&mut cursor)
+        .find(|child| child.kind() == "parameter_declaration")?;
+    let mut ty = parameter.child_by_field_name("type")?;
+    loop {
+        ty = match ty.kind() {
+            "pointer_type" | "parenthesized_type" => ty.named_child(0)?,
+            "generic_type" => ty.child_by_field_name("type")?,
+            _ => break,
+        };
+    }
+    Some(node_text(ty, source_code))
+}
+
+/// The function declaration, method or function literal `node` is in.
+pub fn enclosing_function(node: Node) -> Option<Node> {
+    let mut current = node.parent();
+    while let Some(ancestor) = current {
+        if matches!(
+            ancestor.kind(),
+            "function_declaration" | "method_declaration" | "func_literal"
+        ) {
+            return Some(ancestor);
+        }
+        current = ancestor.parent();
+    }
+    None
+}
+
+/// The expressions of an `expression_list`, or `node` on its own.
+pub fn list_items(node: Node) -> Vec<Node> {
+    if node.kind() != "expression_list" {
+        return vec![node];
+    }
+    let mut cursor = node.walk();
+    node.named_children(&mut cursor).collect()
+}
+
+/// Extends `node`'s byte range to whole lines when nothing else shares them,
+/// so deleting it doesn't leave a blank line behind.
+pub fn line_extent(node: Node, source_code: &str) -> (usize, usize) {
+    let (start, end) = (node.start_byte(), node.end_byte());
+    let line_start = source_code[..start].rfind('\n').map_or(0, |i| i + 1);
+    let line_end = source_code[end..]
+        .find('\n')
+        .map_or(source_code.len(), |i| end + i + 1);
+
+    let alone = source_code[line_start..start].trim().is_empty()
+        && source_code[end..line_end].trim().is_empty();
+    if alone {
+        (line_start, line_end)
+    } else {
+        (start, end)
+    }
+}
@@ -0,0 +1,424 @@
+use super::unused_import::{import_path, local_name};
+use super::{node_text, unknown_option, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::fix::TextEdit;
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use std::collections::HashSet;
+use tree_sitter::{Node, Parser};
+
+/// Misuse of one function, declared in config rather than written as a
+/// check. Each rule names the function and says what must hold at its
+/// calls; [`Signature::compile`] turns the options into a signature when
+/// the config is loaded, so a bad declaration fails at startup.
+///
+/// Options:
+/// - `function` (required): the import path and name, e.g.
+///   `"crypto/md5.New"`. Only package-level functions can be named.
+/// - `result_used` (default `false`): the result must not be discarded.
+/// - `constant_arguments` (default `[]`): zero-based positions of arguments
+///   that must be constants.
+/// - `forbidden_in` (default `[]`): import paths of packages that must not
+///   refer to the function; a trailing `/...` also covers the packages below.
+pub struct GoApiMisuse;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("function", OptionKind::String),
+    ("result_used", OptionKind::Bool),
+    ("constant_arguments", OptionKind::Counts),
+    ("forbidden_in", OptionKind::Strings),
+];
+
+/// A `go_api_misuse` declaration, checked for mistakes.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Signature {
+    pub import_path: String,
+    pub name: String,
+    pub result_used: bool,
+    pub constant_arguments: Vec<usize>,
+    pub forbidden_in: Vec<String>,
+}
+
+impl Signature {
+    pub fn compile(options: &RuleOptions) -> Result<Self, String> {
+        if let Some(error) = options.keys().find_map(|key| unknown_option(OPTIONS, key)) {
+            return Err(error);
+        }
+        let function = options
+            .string("function")
+            .ok_or("`function` is required, e.g. \"crypto/md5.New\"")?;
+        let (import_path, name) = function
+            .rsplit_once('.')
+            .filter(|(path, name)| {
+                !path.is_empty()
+                    && !path.ends_with('/')
+                    && !name.is_empty()
+                    && name.chars().all(|c| c.is_alphanumeric() || c == '_')
+            })
+            .ok_or_else(|| {
+                format!(
+                    "`function` must be an import path and a name, e.g. \"crypto/md5.New\", not \"{}\"",
+                    function
+                )
+            })?;
+
+        let constant_arguments = match options.get("constant_arguments") {
+            None => Vec::new(),
+            Some(value) => value
+                .as_array()
+                .and_then(|positions| {
+                    positions
+                        .iter()
+                        .map(|position| usize::try_from(position.as_integer()?).ok())
+                        .collect::<Option<Vec<_>>>()
+                })
+                .ok_or("`constant_arguments` must list zero-based argument positions")?,
+        };
+        let forbidden_in = match options.get("forbidden_in") {
+            None => Vec::new(),
+            Some(value) => value
+                .as_array()
+                .and_then(|paths| {
+                    paths
+                        .iter()
+                        .map(|path| path.as_str().map(str::to_string))
+                        .collect::<Option<Vec<_>>>()
+                })
+                .ok_or("`forbidden_in` must list import paths")?,
+        };
+        let result_used = match options.get("result_used") {
+            None => false,
+            Some(value) => value
+                .as_bool()
+                .ok_or("`result_used` must be true or false")?,
+        };
+
+        if !result_used && constant_arguments.is_empty() && forbidden_in.is_empty() {
+            return Err(format!(
+                "declares nothing to check for `{}`; set `result_used`, `constant_arguments` or `forbidden_in`",
+                function
+            ));
+        }
+        Ok(Signature {
+            import_path: import_path.to_string(),
+            name: name.to_string(),
+            result_used,
+            constant_arguments,
+            forbidden_in,
+        })
+    }
+
+    fn is_forbidden_in(&self, package: &str) -> bool {
+        self.forbidden_in
+            .iter()
+            .any(|pattern| match pattern.strip_suffix("/...") {
+                Some(prefix) => {
+                    package == prefix
+                        || package
+                            .strip_prefix(prefix)
+                            .is_some_and(|rest| rest.starts_with('/'))
+                }
+                None => package == pattern,
+            })
+    }
+}
+
+impl Check for GoApiMisuse {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let Ok(signature) = Signature::compile(options) else {
+            return Vec::new();
+        };
+        let Some(local) = imported_as(root, source_code, &signature.import_path) else {
+            return Vec::new();
+        };
+        let qualified = format!("{}.{}", local, signature.name);
+        let forbidden = package
+            .and_then(Package::import_path)
+            .filter(|path| signature.is_forbidden_in(path));
+
+        let mut references = Vec::new();
+        visit(root, &mut |node| {
+            if node.kind() != "selector_expression" {
+                return;
+            }
+            let (Some(operand), Some(field)) = (
+                node.child_by_field_name("operand"),
+                node.child_by_field_name("field"),
+            ) else {
+                return;
+            };
+            if operand.kind() == "identifier"
+                && node_text(operand, source_code) == local
+                && node_text(field, source_code) == signature.name
+            {
+                references.push(node);
+            }
+        });
+
+        let mut constants = None;
+        let mut hits = Vec::new();
+        for reference in references {
+            if let Some(path) = &forbidden {
+                let message = format!("`{}` must not be used in `{}`", qualified, path);
+                hits.push(Hit::new(reference).with_message(message));
+            }
+            let Some(call) = reference
+                .parent()
+                .filter(|parent| parent.kind() == "call_expression")
+                .filter(|call| call.child_by_field_name("function") == Some(reference))
+            else {
+                continue;
+            };
+
+            if signature.result_used && is_discarded(call, source_code) {
+                let message = format!("the result of `{}` is not used", qualified);
+                hits.push(Hit::new(call).with_message(message));
+            }
+            let arguments = call
+                .child_by_field_name("arguments")
+                .map(|arguments| {
+                    let mut cursor = arguments.walk();
+                    arguments
+                        .named_children(&mut cursor)
+                        .filter(|argument| argument.kind() != "comment")
+                        .collect::<Vec<_>>()
+                })
+                .unwrap_or_default();
+            for &position in &signature.constant_arguments {
+                let Some(&argument) = arguments.get(position) else {
+                    continue;
+                };
+                let constants =
+                    constants.get_or_insert_with(|| Constants::collect(root, source_code, package));
+                if !constants.is_constant(argument, source_code) {
+                    let message = format!(
+                        "`{}` must be a constant: it is argument {} of `{}`",
+                        node_text(argument, source_code),
+                        position,
+                        qualified
+                    );
+                    hits.push(Hit::new(argument).with_message(message));
+                }
+            }
+        }
+        hits
+    }
+}
+
+/// The name the file imports `path` under, if it does.
+pub(super) fn imported_as(root: Node, source_code: &str, path: &str) -> Option<String> {
+    let mut name = None;
+    visit(root, &mut |node| {
+        if node.kind() == "import_spec" && import_path(node, source_code) == Some(path) {
+            name = name.take().or_else(|| local_name(node, source_code));
+        }
+    });
+    name
+}
+
+/// The edit that adds an import of `path` to the file: into its first
+/// import declaration, or after the package clause when it has none.
+pub(super) fn import_edit(root: Node, path: &str) -> Option<TextEdit> {
+    let mut cursor = root.walk();
+    let children: Vec<Node> = root.named_children(&mut cursor).collect();
+    match children
+        .iter()
+        .find(|child| child.kind() == "import_declaration")
+    {
+        Some(declaration) => {
+            let mut cursor = declaration.walk();
+            let list = declaration
+                .named_children(&mut cursor)
+                .find(|child| child.kind() == "import_spec_list");
+            match list {
+                // Ahead of the group's first spec, at its indentation.
+                Some(list) => list.named_child(0).map(|first| TextEdit {
+                    start_byte: first.start_byte(),
+                    end_byte: first.start_byte(),
+                    replacement: format!("\"{}\"\n\t", path),
+                }),
+                None => Some(TextEdit {
+                    start_byte: declaration.start_byte(),
+                    end_byte: declaration.start_byte(),
+                    replacement: format!("import \"{}\"\n", path),
+                }),
+            }
+        }
+        None => children
+            .iter()
+            .find(|child| child.kind() == "package_clause")
+            .map(|package| TextEdit {
+                start_byte: package.end_byte(),
+                end_byte: package.end_byte(),
+                replacement: format!("\n\nimport \"{}\"", path),
+            }),
+    }
+}
+
+/// Whether nothing reads the result of `call`: it stands alone as a
+/// statement, is deferred or started as a goroutine, or is assigned only
+/// to `_`.
+pub(super) fn is_discarded(call: Node, source_code: &str) -> bool {
+    let Some(parent) = call.parent() else {
+        return false;
+    };
+    match parent.kind() {
+        "expression_statement" | "go_statement" | "defer_statement" => true,
+        "expression_list" => {
+            let Some(statement) = parent
+                .parent()
+                .filter(|statement| {
+                    matches!(
+                        statement.kind(),
+                        "assignment_statement" | "short_var_declaration"
+                    )
+                })
+                .filter(|statement| statement.child_by_field_name("right") == Some(parent))
+            else {
+                return false;
+            };
+            let Some(left) = statement.child_by_field_name("left") else {
+                return false;
+            };
+            let mut cursor = left.walk();
+            let blank = left
+                .named_children(&mut cursor)
+                .all(|target| node_text(target, source_code) == "_");
+            blank
+        }
+        _ => false,
+    }
+}
+
+/// Constant names declared in the file and, when it is known, the rest of
+/// the package, plus the names the file imports packages under.
+struct Constants {
+    names: HashSet<String>,
+    packages: HashSet<String>,
+}
+
+impl Constants {
+    fn collect(root: Node, source_code: &str, package: Option<&Package>) -> Self {
+        let mut constants = Constants {
+            names: HashSet::new(),
+            packages: HashSet::new(),
+        };
+        constants.declared(root, source_code);
+        visit(root, &mut |node| {
+            if node.kind() == "import_spec" {
+                constants.packages.extend(local_name(node, source_code));
+            }
+        });
+
+        let Some(package) = package else {
+            return constants;
+        };
+        let mut parser = Parser::new();
+        if parser
+            .set_language(&SupportedLanguage::Go.tree_sitter_language())
+            .is_err()
+        {
+            return constants;
+        }
+        for file in &package.files {
+            if let Some(tree) = parser.parse(&file.source_code, None) {
+                constants.declared(tree.root_node(), &file.source_code);
+            }
+        }
+        constants
+    }
+
+    fn declared(&mut self, root: Node, source_code: &str) {
+        visit(root, &mut |node| {
+            if node.kind() == "const_spec" {
+                let mut cursor = node.walk();
+                for name in node.children_by_field_name("name", &mut cursor) {
+                    self.names.insert(node_text(name, source_code).to_string());
+                }
+            }
+        });
+    }
+
+    fn is_constant(&self, node: Node, source_code: &str) -> bool {
+        match node.kind() {
+            "interpreted_string_literal"
+            | "raw_string_literal"
+            | "int_literal"
+            | "float_literal"
+            | "imaginary_literal"
+            | "rune_literal"
+            | "true"
+            | "false"
+            | "iota" => true,
+            "identifier" => self.names.contains(node_text(node, source_code)),
+            "parenthesized_expression" => node
+                .named_child(0)
+                .is_some_and(|inner| self.is_constant(inner, source_code)),
+            "unary_expression" => node
+                .child_by_field_name("operand")
+                .is_some_and(|operand| self.is_constant(operand, source_code)),
+            "binary_expression" => {
+                let (Some(left), Some(right)) = (
+                    node.child_by_field_name("left"),
+                    node.child_by_field_name("right"),
+                ) else {
+                    return false;
+                };
+                self.is_constant(left, source_code) && self.is_constant(right, source_code)
+            }
+            // An exported constant of another package, such as
+            // `time.Second`, can't be told from a variable without its source.
+            "selector_expression" => node.child_by_field_name("operand").is_some_and(|operand| {
+                operand.kind() == "identifier"
+                    && self.packages.contains(node_text(operand, source_code))
+            }),
+            _ => false,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn options(toml: &str) -> RuleOptions {
+        RuleOptions::new(toml::from_str(toml).unwrap())
+    }
+
+    #[test]
+    fn test_declarations_compile() {
+        let signature = Signature::compile(&options(
+            "function = \"gopkg.in/yaml.v3.Unmarshal\"\nresult_used = true\nconstant_arguments = [1]\nforbidden_in = [\"example.com/app/...\"]\n",
+        ))
+        .unwrap();
+        assert_eq!(signature.import_path, "gopkg.in/yaml.v3");
+        assert_eq!(signature.name, "Unmarshal");
+        assert_eq!(signature.constant_arguments, [1]);
+        assert!(signature.is_forbidden_in("example.com/app"));
+        assert!(signature.is_forbidden_in("example.com/app/api"));
+        assert!(!signature.is_forbidden_in("example.com/application"));
+
+        let error = |toml: &str| Signature::compile(&options(toml)).unwrap_err();
+        assert!(error("result_used = true\n").contains("`function` is required"));
+        assert!(
+            error("function = \"New\"\nresult_used = true\n").contains("import path and a name")
+        );
+        assert!(error("function = \"os.Exit\"\n").contains("declares nothing to check"));
+        assert!(error("function = \"os.Exit\"\nconstant_arguments = [-1]\n").contains("zero-based"));
+        assert!(error("function = \"os.Exit\"\nresult_use = true\n")
+            .contains("unknown option 'result_use'"));
+    }
+}
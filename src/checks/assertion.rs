@@ -0,0 +1,100 @@
+use super::panic_reachable::label;
+use super::{unknown_option, Check, Hit, OptionKind, RuleOptions};
+use crate::callgraph::{declaration_id, is_graphed};
+use crate::package::Package;
+use crate::policy::Assertion;
+use std::collections::HashMap;
+use tree_sitter::Node;
+
+/// Organization policies declared in config as assertions over the
+/// module's call graph, such as `every handler in ./api/... calls
+/// ./internal/middleware.Authn`; see [`crate::policy`] for the language.
+/// A function that breaks one is reported at its declaration, with the
+/// chain of calls for a call it mustn't make.
+///
+/// Options:
+/// - `assert` (required): the assertions, each checked on its own.
+pub struct GoPolicy;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("assert", OptionKind::Strings)];
+
+/// The `assert` option, parsed when the config is loaded so a wrong
+/// assertion fails at startup.
+pub(super) fn assertions(options: &RuleOptions) -> Result<Vec<Assertion>, String> {
+    if let Some(error) = options.keys().find_map(|key| unknown_option(OPTIONS, key)) {
+        return Err(error);
+    }
+    let assertions = options
+        .string_list("assert")
+        .unwrap_or_default()
+        .iter()
+        .map(|text| Assertion::parse(text).map_err(|e| format!("`assert`: {} in \"{}\"", e, text)))
+        .collect::<Result<Vec<_>, _>>()?;
+    if assertions.is_empty() {
+        return Err("declares no policy; set `assert`".to_string());
+    }
+    Ok(assertions)
+}
+
+impl Check for GoPolicy {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn reads_call_graph(&self) -> bool {
+        true
+    }
+
+    fn needs_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let Some(package) = package.filter(|package| is_graphed(&package.path)) else {
+            return Vec::new();
+        };
+        let (Ok(assertions), Some(import_path), Some(graph), Some(module)) = (
+            assertions(options),
+            package.import_path(),
+            package.call_graph(),
+            package.module.as_ref(),
+        ) else {
+            return Vec::new();
+        };
+
+        let mut names = HashMap::new();
+        let mut cursor = root.walk();
+        for declaration in root.named_children(&mut cursor) {
+            if let (Some(name), Some(id)) = (
+                declaration.child_by_field_name("name"),
+                declaration_id(&import_path, declaration, source_code),
+            ) {
+                names.insert(id, name);
+            }
+        }
+
+        let mut hits = Vec::new();
+        for assertion in &assertions {
+            for violation in assertion.violations(&graph, &module.path) {
+                // Only the functions declared in this file have names here.
+                let Some(&name) = names.get(&graph.functions[violation.function].id) else {
+                    continue;
+                };
+                let message = assertion.describe(&violation, |f| label(&graph, f, &import_path));
+                hits.push(Hit::new(name).with_message(message));
+            }
+        }
+        hits.sort_by_key(|hit| hit.node.start_byte());
+        hits
+    }
+}
@@ -0,0 +1,666 @@
+use super::{enclosing_function, node_text, visit, Check, Hit, RuleOptions};
+use crate::fix::{Fix, TextEdit};
+use tree_sitter::Node;
+
+/// Misused Go channels, within each function:
+///
+/// - A send that may come after the channel was closed: later in the same
+///   function on a path that didn't return, or earlier in a loop that closes
+///   it without leaving. A `close` after starting a goroutine that sends on
+///   the channel, with no `Wait()` or receive in between to let it finish,
+///   is reported too.
+/// - `close` in a function that receives from the channel, never sends on
+///   it and didn't make it: the senders don't know it's closed.
+/// - Sends, receives, `range` and `close` on a local declared with
+///   `var ch chan T` and never assigned. In a `select` case a nil channel
+///   is the usual way to turn the case off, so those aren't reported.
+/// - An unbuffered channel that a goroutine sends on once, fire and forget,
+///   when the function receives from it only in a `select` that can take
+///   another case, or not at all. The fix gives it a buffer of 1.
+/// - A `select` with one case and no `default`, which does what the plain
+///   channel operation does.
+///
+/// Channels are matched by the text of their expression, such as `ch` or
+/// `s.results`.
+pub struct GoChannel {
+    issue: ChannelIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum ChannelIssue {
+    /// Sends on a channel that may be closed.
+    SendAfterClose,
+    /// `close` on the receiving side.
+    ReceiverClose,
+    /// Operations on a channel that's always nil.
+    NilChannel,
+    /// One-shot sends on unbuffered channels the receiver may abandon.
+    UnbufferedSignal,
+    /// `select` with a single case.
+    SingleCaseSelect,
+}
+
+impl GoChannel {
+    pub fn new(issue: ChannelIssue) -> Self {
+        GoChannel { issue }
+    }
+}
+
+impl Check for GoChannel {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+        if self.issue == ChannelIssue::SingleCaseSelect {
+            return single_case_selects(root, source_code);
+        }
+        let ops = Ops::collect(root, source_code);
+        match self.issue {
+            ChannelIssue::SendAfterClose => sends_after_close(&ops, source_code),
+            ChannelIssue::ReceiverClose => receiver_closes(&ops),
+            ChannelIssue::NilChannel => nil_channel_ops(&ops),
+            ChannelIssue::UnbufferedSignal => unbuffered_signals(&ops, source_code),
+            ChannelIssue::SingleCaseSelect => Vec::new(),
+        }
+    }
+}
+
+#[derive(Clone, Copy, PartialEq)]
+enum OpKind {
+    Send,
+    Receive,
+    Range,
+    Close,
+    DeferredClose,
+    /// The channel is assigned, or its address taken.
+    Assign,
+    /// `ch := make(chan T)`, with a buffer or not.
+    Make {
+        buffered: bool,
+    },
+    /// `var ch chan T`, with no value.
+    NilDeclaration,
+    /// A `Wait()` call, which lets goroutines finish. It has no channel.
+    Wait,
+}
+
+struct Op<'t> {
+    kind: OpKind,
+    channel: String,
+    node: Node<'t>,
+    /// The function, method or function literal the operation is in.
+    function: Option<Node<'t>>,
+}
+
+struct Ops<'t> {
+    ops: Vec<Op<'t>>,
+}
+
+impl<'t> Ops<'t> {
+    fn collect(root: Node<'t>, source_code: &str) -> Self {
+        let mut ops = Vec::new();
+        let mut push = |kind, channel: &str, node: Node<'t>| {
+            ops.push(Op {
+                kind,
+                channel: channel.to_string(),
+                node,
+                function: enclosing_function(node),
+            })
+        };
+        let text = |node: Node| node_text(node, source_code);
+        visit(root, &mut |node| match node.kind() {
+            "send_statement" => {
+                if let Some(channel) = node.child_by_field_name("channel") {
+                    push(OpKind::Send, text(channel), node);
+                }
+            }
+            "unary_expression" => {
+                let (Some(operator), Some(operand)) = (
+                    node.child_by_field_name("operator"),
+                    node.child_by_field_name("operand"),
+                ) else {
+                    return;
+                };
+                match text(operator) {
+                    "<-" => push(OpKind::Receive, text(operand), node),
+                    "&" => push(OpKind::Assign, text(operand), node),
+                    _ => {}
+                }
+            }
+            "range_clause" => {
+                if let Some(right) = node.child_by_field_name("right") {
+                    push(OpKind::Range, text(right), node);
+                }
+            }
+            "call_expression" => {
+                let Some(function) = node.child_by_field_name("function") else {
+                    return;
+                };
+                let method = function
+                    .child_by_field_name("field")
+                    .filter(|_| function.kind() == "selector_expression");
+                if method.is_some_and(|method| text(method) == "Wait") {
+                    push(OpKind::Wait, "", node);
+                }
+                if function.kind() != "identifier" || text(function) != "close" {
+                    return;
+                }
+                let Some(channel) = node
+                    .child_by_field_name("arguments")
+                    .and_then(|arguments| arguments.named_child(0))
+                else {
+                    return;
+                };
+                let deferred = node
+                    .parent()
+                    .is_some_and(|parent| parent.kind() == "defer_statement");
+                let kind = match deferred {
+                    true => OpKind::DeferredClose,
+                    false => OpKind::Close,
+                };
+                push(kind, text(channel), node);
+            }
+            "assignment_statement" | "short_var_declaration" => {
+                let (Some(left), Some(right)) = (
+                    node.child_by_field_name("left"),
+                    node.child_by_field_name("right"),
+                ) else {
+                    return;
+                };
+                let mut cursor = left.walk();
+                let targets: Vec<Node<'t>> = left.named_children(&mut cursor).collect();
+                let made = match (targets.len(), right.named_child_count()) {
+                    (1, 1) => right
+                        .named_child(0)
+                        .and_then(|value| make_buffered(value, source_code)),
+                    _ => None,
+                };
+                for target in targets {
+                    match made {
+                        Some(buffered) => push(OpKind::Make { buffered }, text(target), node),
+                        None => push(OpKind::Assign, text(target), node),
+                    }
+                }
+            }
+            "var_spec" => {
+                let mut cursor = node.walk();
+                let names: Vec<Node<'t>> =
+                    node.children_by_field_name("name", &mut cursor).collect();
+                let is_channel = node
+                    .child_by_field_name("type")
+                    .is_some_and(|ty| ty.kind() == "channel_type");
+                // `None` without a value, `Some(None)` with one other than `make`.
+                let made = node.child_by_field_name("value").map(|value| {
+                    match value.named_child_count() {
+                        1 => value
+                            .named_child(0)
+                            .and_then(|value| make_buffered(value, source_code)),
+                        _ => None,
+                    }
+                });
+                for name in names {
+                    match made {
+                        None if is_channel => push(OpKind::NilDeclaration, text(name), node),
+                        Some(Some(buffered)) => push(OpKind::Make { buffered }, text(name), node),
+                        _ => {}
+                    }
+                }
+            }
+            _ => {}
+        });
+        Ops { ops }
+    }
+
+    fn of_kind(&self, kind: OpKind) -> impl Iterator<Item = &Op<'t>> {
+        self.ops.iter().filter(move |op| op.kind == kind)
+    }
+
+    /// The operations of `kind` on `channel` in `function` itself, not in
+    /// the function literals inside it.
+    fn own<'a>(
+        &'a self,
+        function: Option<Node<'t>>,
+        channel: &'a str,
+        kind: OpKind,
+    ) -> impl Iterator<Item = &'a Op<'t>> + 'a {
+        self.ops
+            .iter()
+            .filter(move |op| op.kind == kind && op.channel == channel && op.function == function)
+    }
+
+    /// The operations on `channel` anywhere inside `function`.
+    fn within<'a>(
+        &'a self,
+        function: Node<'t>,
+        channel: &'a str,
+    ) -> impl Iterator<Item = &'a Op<'t>> + 'a {
+        self.ops
+            .iter()
+            .filter(move |op| op.channel == channel && contains(function, op.node))
+    }
+}
+
+/// For a `make(chan T)` or `make(chan T, n)` call, whether it has a buffer.
+fn make_buffered(call: Node, source_code: &str) -> Option<bool> {
+    if call.kind() != "call_expression" {
+        return None;
+    }
+    let function = call.child_by_field_name("function")?;
+    if node_text(function, source_code) != "make" {
+        return None;
+    }
+    let arguments = call.child_by_field_name("arguments")?;
+    if arguments.named_child(0)?.kind() != "channel_type" {
+        return None;
+    }
+    Some(arguments.named_child_count() > 1)
+}
+
+fn line(node: Node) -> usize {
+    node.start_position().row + 1
+}
+
+fn contains(outer: Node, inner: Node) -> bool {
+    outer.start_byte() <= inner.start_byte() && inner.end_byte() <= outer.end_byte()
+}
+
+/// Where the code after `node` first leaves `scope` for good: the start of
+/// the first `return`, `goto` or `panic`, or with `in_loop` also `break`,
+/// that follows it in its block or an enclosing block inside `scope`.
+fn exit_after(node: Node, scope: Node, in_loop: bool, source_code: &str) -> Option<usize> {
+    let mut current = node;
+    while let Some(parent) = current.parent() {
+        if parent == scope {
+            break;
+        }
+        if matches!(parent.kind(), "block" | "statement_list") {
+            let mut sibling = current.next_named_sibling();
+            while let Some(statement) = sibling {
+                if exits(statement, in_loop, source_code) {
+                    return Some(statement.start_byte());
+                }
+                sibling = statement.next_named_sibling();
+            }
+        }
+        current = parent;
+    }
+    None
+}
+
+fn exits(statement: Node, in_loop: bool, source_code: &str) -> bool {
+    match statement.kind() {
+        "return_statement" | "goto_statement" => true,
+        "break_statement" => in_loop,
+        "expression_statement" => statement
+            .named_child(0)
+            .filter(|call| call.kind() == "call_expression")
+            .and_then(|call| call.child_by_field_name("function"))
+            .is_some_and(|function| node_text(function, source_code) == "panic"),
+        _ => false,
+    }
+}
+
+/// The innermost `for` loop around `node` inside `function`.
+fn enclosing_loop<'t>(node: Node<'t>, function: Option<Node<'t>>) -> Option<Node<'t>> {
+    let mut current = node.parent();
+    while let Some(ancestor) = current {
+        if Some(ancestor) == function {
+            return None;
+        }
+        if ancestor.kind() == "for_statement" {
+            return Some(ancestor);
+        }
+        current = ancestor.parent();
+    }
+    None
+}
+
+/// The `go` statement that runs `function`, when it's a literal started
+/// with `go func() { ... }()`.
+fn started_by<'t>(function: Option<Node<'t>>) -> Option<Node<'t>> {
+    let literal = function.filter(|function| function.kind() == "func_literal")?;
+    let call = literal
+        .parent()
+        .filter(|call| call.kind() == "call_expression")?;
+    call.parent()
+        .filter(|statement| statement.kind() == "go_statement")
+}
+
+/// The `select` whose case is the channel operation `node`.
+fn select_case(node: Node) -> Option<Node> {
+    let mut current = node.parent();
+    for _ in 0..3 {
+        let ancestor = current?;
+        if ancestor.kind() == "communication_case" {
+            return ancestor.parent();
+        }
+        current = ancestor.parent();
+    }
+    None
+}
+
+fn case_count(select: Node) -> usize {
+    let mut cursor = select.walk();
+    select
+        .named_children(&mut cursor)
+        .filter(|case| matches!(case.kind(), "communication_case" | "default_case"))
+        .count()
+}
+
+fn sends_after_close<'t>(ops: &Ops<'t>, source_code: &str) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    for close in ops.of_kind(OpKind::Close) {
+        let channel = close.channel.as_str();
+        let scope = close.function.unwrap_or(close.node);
+        let reassigned = |from: usize, to: usize| {
+            ops.ops.iter().any(|op| {
+                matches!(op.kind, OpKind::Assign | OpKind::Make { .. })
+                    && op.channel == channel
+                    && op.function == close.function
+                    && op.node.start_byte() > from
+                    && op.node.start_byte() < to
+            })
+        };
+
+        // Later in the function, before it returns.
+        let exit = exit_after(close.node, scope, false, source_code);
+        for send in ops.own(close.function, channel, OpKind::Send) {
+            let start = send.node.start_byte();
+            if start > close.node.end_byte()
+                && exit.is_none_or(|exit| start < exit)
+                && !reassigned(close.node.end_byte(), start)
+            {
+                hits.push(
+                    Hit::new(send.node)
+                        .with_message(format!(
+                            "`{}` may already be closed here: it's closed at line {}, and a send on a closed channel panics",
+                            channel,
+                            line(close.node)
+                        ))
+                        .with_related(close.node, "closed here"),
+                );
+            }
+        }
+
+        // Earlier in a loop that closes it and goes round again.
+        if let Some(for_loop) = enclosing_loop(close.node, close.function) {
+            if exit_after(close.node, for_loop, true, source_code).is_none()
+                && !reassigned(for_loop.start_byte(), for_loop.end_byte())
+            {
+                for send in ops.own(close.function, channel, OpKind::Send) {
+                    if contains(for_loop, send.node)
+                        && send.node.end_byte() < close.node.start_byte()
+                    {
+                        hits.push(
+                            Hit::new(send.node)
+                                .with_message(format!(
+                                    "`{}` is closed later in this loop, at line {}, so the next iteration sends on a closed channel and panics",
+                                    channel,
+                                    line(close.node)
+                                ))
+                                .with_related(close.node, "closed here"),
+                        );
+                    }
+                }
+            }
+        }
+    }
+
+    // A close that doesn't wait for the goroutines sending on the channel.
+    for close in ops
+        .ops
+        .iter()
+        .filter(|op| matches!(op.kind, OpKind::Close | OpKind::DeferredClose))
+    {
+        let channel = close.channel.as_str();
+        let sender = ops.of_kind(OpKind::Send).find_map(|send| {
+            let statement = started_by(send.function)?;
+            let before = close.kind == OpKind::DeferredClose
+                || statement.end_byte() < close.node.start_byte();
+            (send.channel == channel && enclosing_function(statement) == close.function && before)
+                .then_some((statement, send))
+        });
+        let Some((statement, send)) = sender else {
+            continue;
+        };
+        // A deferred close runs at the end, after everything.
+        let until = match close.kind {
+            OpKind::DeferredClose => usize::MAX,
+            _ => close.node.start_byte(),
+        };
+        let waits = ops.ops.iter().any(|op| {
+            matches!(op.kind, OpKind::Wait | OpKind::Receive)
+                && op.function == close.function
+                && op.node.start_byte() > statement.end_byte()
+                && op.node.start_byte() < until
+        });
+        if !waits {
+            hits.push(
+                Hit::new(close.node)
+                    .with_message(format!(
+                        "`{}` is closed while the goroutine started at line {} may still send on it, which panics; wait for the goroutine first",
+                        channel,
+                        line(statement)
+                    ))
+                    .with_related(send.node, "sends here"),
+            );
+        }
+    }
+    hits
+}
+
+fn receiver_closes<'t>(ops: &Ops<'t>) -> Vec<Hit<'t>> {
+    ops.ops
+        .iter()
+        .filter(|op| matches!(op.kind, OpKind::Close | OpKind::DeferredClose))
+        .filter_map(|close| {
+            let channel = close.channel.as_str();
+            let of = |kind| ops.own(close.function, channel, kind).next();
+            let receive = of(OpKind::Receive).or_else(|| of(OpKind::Range))?;
+            let made = ops
+                .ops
+                .iter()
+                .any(|op| matches!(op.kind, OpKind::Make { .. }) && op.channel == channel
+                    && close.function.is_some_and(|function| contains(function, op.node)));
+            if of(OpKind::Send).is_some() || made {
+                return None;
+            }
+            Some(
+                Hit::new(close.node)
+                    .with_message(format!(
+                        "`{}` is closed by a function that only receives from it, at line {}; its senders don't know and panic if they send again, so close it where it's sent on",
+                        channel,
+                        line(receive.node)
+                    ))
+                    .with_related(receive.node, "received here"),
+            )
+        })
+        .collect()
+}
+
+fn nil_channel_ops<'t>(ops: &Ops<'t>) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    for declaration in ops.of_kind(OpKind::NilDeclaration) {
+        let Some(function) = declaration.function else {
+            continue;
+        };
+        let channel = declaration.channel.as_str();
+        let uses: Vec<&Op> = ops
+            .within(function, channel)
+            .filter(|op| op.node.start_byte() > declaration.node.end_byte())
+            .collect();
+        let assigned = uses
+            .iter()
+            .any(|op| matches!(op.kind, OpKind::Assign | OpKind::Make { .. }));
+        if assigned {
+            continue;
+        }
+        for op in uses {
+            let what = match op.kind {
+                OpKind::Send => "sending on it blocks forever",
+                OpKind::Receive => "receiving from it blocks forever",
+                OpKind::Range => "ranging over it blocks forever",
+                OpKind::Close | OpKind::DeferredClose => "closing it panics",
+                _ => continue,
+            };
+            if select_case(op.node).is_some() {
+                continue;
+            }
+            hits.push(
+                Hit::new(op.node)
+                    .with_message(format!(
+                        "`{}` is nil: it's declared at line {} without `make` and never assigned, so {}",
+                        channel,
+                        line(declaration.node),
+                        what
+                    ))
+                    .with_related(declaration.node, "declared here"),
+            );
+        }
+    }
+    hits
+}
+
+fn unbuffered_signals<'t>(ops: &Ops<'t>, source_code: &str) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    for made in ops
+        .ops
+        .iter()
+        .filter(|op| op.kind == OpKind::Make { buffered: false })
+    {
+        let Some(function) = made.function else {
+            continue;
+        };
+        let channel = made.channel.as_str();
+        // Only local variables, whose every use is in sight.
+        if !channel.chars().all(|c| c.is_alphanumeric() || c == '_') {
+            continue;
+        }
+        let sends: Vec<&Op> = ops
+            .within(function, channel)
+            .filter(|op| op.kind == OpKind::Send)
+            .collect();
+        let one_shot = |send: &&Op| {
+            started_by(send.function).is_some_and(|statement| {
+                enclosing_function(statement) == Some(function)
+                    && send.function.is_some_and(|literal| {
+                        enclosing_loop(send.node, Some(literal)).is_none()
+                            && select_case(send.node).is_none()
+                    })
+            })
+        };
+        if sends.is_empty() || !sends.iter().all(one_shot) {
+            continue;
+        }
+        let receives: Vec<&Op> = ops.own(Some(function), channel, OpKind::Receive).collect();
+        // A receive nothing can skip takes the send.
+        let waited = receives
+            .iter()
+            .any(|receive| select_case(receive.node).is_none_or(|select| case_count(select) == 1));
+        if waited || escapes(function, made, &sends, &receives, source_code) {
+            continue;
+        }
+
+        let Some(call) = made
+            .node
+            .child_by_field_name("right")
+            .or_else(|| made.node.child_by_field_name("value"))
+            .and_then(|value| value.named_child(0))
+        else {
+            continue;
+        };
+        let Some(arguments) = call.child_by_field_name("arguments") else {
+            continue;
+        };
+        let goroutine = started_by(sends[0].function).unwrap_or(sends[0].node);
+        let message = match receives.first().and_then(|receive| select_case(receive.node)) {
+            Some(select) => format!(
+                "the goroutine started at line {} sends on unbuffered `{}` once, but the `select` at line {} can take another case and never receive, leaving the goroutine blocked for good; give the channel a buffer of 1",
+                line(goroutine),
+                channel,
+                line(select)
+            ),
+            None => format!(
+                "the goroutine started at line {} sends on unbuffered `{}`, but nothing receives from it, leaving the goroutine blocked for good; give the channel a buffer of 1",
+                line(goroutine),
+                channel
+            ),
+        };
+        let close = arguments.end_byte() - 1;
+        hits.push(
+            Hit::new(call)
+                .with_message(message)
+                .with_related(sends[0].node, "sends here")
+                .with_fix(Fix {
+                    description: "Give the channel a buffer of 1".to_string(),
+                    edits: vec![TextEdit {
+                        start_byte: close,
+                        end_byte: close,
+                        replacement: ", 1".to_string(),
+                    }],
+                }),
+        );
+    }
+    hits
+}
+
+/// Whether `channel` is used in `function` other than by its `make`, the
+/// goroutines' sends and the function's receives, such as passed to a call,
+/// returned or stored, so that something else may receive from it.
+fn escapes(function: Node, made: &Op, sends: &[&Op], receives: &[&Op], source_code: &str) -> bool {
+    let accounted = |node: Node| {
+        contains(made.node, node)
+            || sends.iter().any(|send| {
+                send.node
+                    .child_by_field_name("channel")
+                    .is_some_and(|channel| channel == node)
+            })
+            || receives.iter().any(|receive| {
+                receive
+                    .node
+                    .child_by_field_name("operand")
+                    .is_some_and(|operand| operand == node)
+            })
+    };
+    let mut escapes = false;
+    visit(function, &mut |node| {
+        if node.kind() == "identifier"
+            && node_text(node, source_code) == made.channel
+            && node.start_byte() > made.node.start_byte()
+            && !accounted(node)
+        {
+            escapes = true;
+        }
+    });
+    escapes
+}
+
+fn single_case_selects<'t>(root: Node<'t>, source_code: &str) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "select_statement" || case_count(node) != 1 {
+            return;
+        }
+        let mut cursor = node.walk();
+        let Some(case) = node
+            .named_children(&mut cursor)
+            .find(|case| case.kind() == "communication_case")
+        else {
+            return;
+        };
+        let Some(communication) = case.child_by_field_name("communication") else {
+            return;
+        };
+        let mut hit = Hit::new(node);
+        // With nothing to run after it, the case is just its operation.
+        if case.named_child_count() == 1 {
+            hit = hit.with_fix(Fix {
+                description: "Replace the `select` with its channel operation".to_string(),
+                edits: vec![TextEdit {
+                    start_byte: node.start_byte(),
+                    end_byte: node.end_byte(),
+                    replacement: node_text(communication, source_code).to_string(),
+                }],
+            });
+        }
+        hits.push(hit);
+    });
+    hits
+}
@@ -0,0 +1,52 @@
+use super::{Check, Granularity, Hit, OptionKind, RuleOptions};
+use crate::complexity::functions;
+use tree_sitter::Node;
+
+/// Reports functions whose complexity exceeds the rule's `max` option.
+pub struct Complexity {
+    metric: Metric,
+}
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("max", OptionKind::Count)];
+
+#[derive(Clone, Copy)]
+pub enum Metric {
+    Cyclomatic,
+    Cognitive,
+}
+
+impl Complexity {
+    pub fn new(metric: Metric) -> Self {
+        Complexity { metric }
+    }
+}
+
+impl Check for Complexity {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        let (label, default_max) = match self.metric {
+            Metric::Cyclomatic => ("cyclomatic", 10),
+            Metric::Cognitive => ("cognitive", 15),
+        };
+        let max = options.usize("max").unwrap_or(default_max);
+
+        functions(root, source_code)
+            .into_iter()
+            .filter_map(|function| {
+                let value = match self.metric {
+                    Metric::Cyclomatic => function.cyclomatic,
+                    Metric::Cognitive => function.cognitive,
+                };
+                (value > max).then(|| {
+                    Hit::new(function.anchor()).with_message(format!(
+                        "{} has a {} complexity of {} (max {})",
+                        function.name, label, value, max
+                    ))
+                })
+            })
+            .collect()
+    }
+
+    fn granularity(&self) -> Granularity {
+        Granularity::Declaration
+    }
+}
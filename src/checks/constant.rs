@@ -0,0 +1,729 @@
+use super::api_misuse::imported_as;
+use super::{node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::analyzer::RelatedLocation;
+use crate::fix::{Fix, TextEdit};
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use crate::project::is_generated;
+use std::collections::{BTreeSet, HashMap};
+use tree_sitter::{Node, Parser};
+
+/// Literals that want a name or a unit:
+///
+/// - Numbers written in more than one file of the package, which drift
+///   apart when one copy changes. With the package, every other file's
+///   literals are counted; the first in the file is reported, with the
+///   rest as related places.
+/// - Strings written `min_occurrences` times or more in the package.
+/// - Numbers used as a `time.Duration`, which counts nanoseconds: passed
+///   to `time.Sleep`, `time.After`, `time.Tick`, `time.NewTimer`,
+///   `time.NewTicker`, `time.AfterFunc`, `context.WithTimeout` and
+///   `time.Now().Add`, converted with `time.Duration(500)`, or scaled with
+///   `* 1000000`. When the value is a whole number of microseconds or
+///   more, the fix writes it with the unit, as in `5 * time.Second`.
+/// - Sizes written out as products with `1024`, such as `10 * 1024 * 1024`.
+///
+/// Literals in `const` declarations, import paths, struct tags and array
+/// lengths are skipped, and so are numbers multiplied by a `time` unit,
+/// which already say what they are. Package-level `var`s name their size.
+/// Test and generated files are neither checked nor counted.
+///
+/// Options:
+/// - `min_files` (numbers, default `2`): the files of the package a number
+///   has to be in.
+/// - `ignore` (numbers, default `["0", "1", "2"]`; strings, default
+///   `[]`): values never reported, as written, such as `"-1"` or `"%s"`,
+///   without quotes for strings.
+/// - `min_occurrences` (strings, default `3`): the times a string has to
+///   appear in the package.
+/// - `min_length` (strings, default `3`): shorter strings aren't reported.
+pub struct GoConstant {
+    issue: ConstantIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum ConstantIssue {
+    /// Numbers repeated across the package's files.
+    MagicNumber,
+    /// Strings repeated in the package.
+    RepeatedString,
+    /// Durations without a unit.
+    DurationUnit,
+    /// Sizes spelled out with `1024`.
+    SizeLiteral,
+}
+
+impl GoConstant {
+    pub fn new(issue: ConstantIssue) -> Self {
+        GoConstant { issue }
+    }
+}
+
+pub(super) const NUMBER_OPTIONS: &[(&str, OptionKind)] = &[
+    ("min_files", OptionKind::Count),
+    ("ignore", OptionKind::Strings),
+];
+
+pub(super) const STRING_OPTIONS: &[(&str, OptionKind)] = &[
+    ("min_occurrences", OptionKind::Count),
+    ("min_length", OptionKind::Count),
+    ("ignore", OptionKind::Strings),
+];
+
+const IGNORED_NUMBERS: &[&str] = &["0", "1", "2"];
+
+/// The `time` units, largest first, in nanoseconds.
+const UNITS: &[(&str, u64)] = &[
+    ("Hour", 3_600_000_000_000),
+    ("Minute", 60_000_000_000),
+    ("Second", 1_000_000_000),
+    ("Millisecond", 1_000_000),
+    ("Microsecond", 1_000),
+    ("Nanosecond", 1),
+];
+
+/// Functions of `time` whose first argument is a duration.
+const DURATION_FUNCTIONS: &[&str] = &[
+    "Sleep",
+    "After",
+    "Tick",
+    "NewTimer",
+    "NewTicker",
+    "AfterFunc",
+];
+
+/// Functions of `context` whose second argument is a duration.
+const CONTEXT_FUNCTIONS: &[&str] = &["WithTimeout", "WithTimeoutCause"];
+
+impl Check for GoConstant {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        matches!(
+            self.issue,
+            ConstantIssue::MagicNumber | ConstantIssue::RepeatedString
+        )
+    }
+
+    fn needs_package(&self) -> bool {
+        self.issue == ConstantIssue::MagicNumber
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let test_file = package
+            .and_then(|package| package.path.file_name())
+            .is_some_and(|name| name.to_string_lossy().ends_with("_test.go"));
+        if test_file || is_generated(source_code) {
+            return Vec::new();
+        }
+        match self.issue {
+            ConstantIssue::MagicNumber => {
+                let ignore = options
+                    .string_list("ignore")
+                    .unwrap_or_else(|| IGNORED_NUMBERS.iter().map(|n| n.to_string()).collect());
+                let literals = Literals {
+                    kind: Kind::Number,
+                    min_length: 0,
+                    ignore,
+                };
+                let min_files = options.usize("min_files").unwrap_or(2);
+                repeated(root, source_code, package, &literals, |found| {
+                    found.files.len() >= min_files
+                })
+            }
+            ConstantIssue::RepeatedString => {
+                let literals = Literals {
+                    kind: Kind::String,
+                    min_length: options.usize("min_length").unwrap_or(3),
+                    ignore: options.string_list("ignore").unwrap_or_default(),
+                };
+                let min_occurrences = options.usize("min_occurrences").unwrap_or(3);
+                repeated(root, source_code, package, &literals, |found| {
+                    found.count >= min_occurrences
+                })
+            }
+            ConstantIssue::DurationUnit => durations(root, source_code),
+            ConstantIssue::SizeLiteral => sizes(root, source_code),
+        }
+    }
+}
+
+#[derive(Clone, Copy, PartialEq)]
+enum Kind {
+    Number,
+    String,
+}
+
+/// Which literals count towards a repetition.
+struct Literals {
+    kind: Kind,
+    min_length: usize,
+    ignore: Vec<String>,
+}
+
+impl Literals {
+    /// The literals of a file that could be constants, with their values.
+    fn collect<'t>(&self, root: Node<'t>, source_code: &str) -> Vec<(String, Node<'t>)> {
+        let mut found = Vec::new();
+        visit(root, &mut |node| {
+            let (value, node) = match (self.kind, node.kind()) {
+                (Kind::Number, "int_literal" | "float_literal") => {
+                    let text = node_text(node, source_code).replace('_', "").to_lowercase();
+                    match node.parent() {
+                        Some(parent) if is_negation(parent, source_code) => {
+                            (format!("-{}", text), parent)
+                        }
+                        _ => (text, node),
+                    }
+                }
+                (Kind::String, "interpreted_string_literal" | "raw_string_literal") => {
+                    let value = string_value(node_text(node, source_code));
+                    if value.chars().count() < self.min_length.max(1) {
+                        return;
+                    }
+                    (value, node)
+                }
+                _ => return,
+            };
+            if self.ignore.contains(&value) || is_named(node) {
+                return;
+            }
+            if self.kind == Kind::Number && is_scaled_by_unit(node, source_code) {
+                return;
+            }
+            found.push((value, node));
+        });
+        found
+    }
+}
+
+/// How often a value appears in the package.
+#[derive(Default)]
+struct Occurrences {
+    count: usize,
+    /// The files it appears in; the analyzed file is `""`.
+    files: BTreeSet<String>,
+    /// Its first place in another file.
+    elsewhere: Option<RelatedLocation>,
+}
+
+/// The first literal of each value in the file that `reported` picks, by
+/// what the whole package holds, with its other places.
+fn repeated<'t>(
+    root: Node<'t>,
+    source_code: &str,
+    package: Option<&Package>,
+    literals: &Literals,
+    reported: impl Fn(&Occurrences) -> bool,
+) -> Vec<Hit<'t>> {
+    let here = literals.collect(root, source_code);
+    let mut found: HashMap<String, Occurrences> = HashMap::new();
+    for (value, _) in &here {
+        let occurrences = found.entry(value.clone()).or_default();
+        occurrences.count += 1;
+        occurrences.files.insert(String::new());
+    }
+    if let Some(package) = package {
+        let mut parser = Parser::new();
+        if parser
+            .set_language(&SupportedLanguage::Go.tree_sitter_language())
+            .is_ok()
+        {
+            for file in &package.files {
+                let path = file.path.to_string_lossy();
+                if path.ends_with("_test.go") || is_generated(&file.source_code) {
+                    continue;
+                }
+                let Some(tree) = parser.parse(&file.source_code, None) else {
+                    continue;
+                };
+                for (value, node) in literals.collect(tree.root_node(), &file.source_code) {
+                    // Only values the file has matter.
+                    let Some(occurrences) = found.get_mut(&value) else {
+                        continue;
+                    };
+                    occurrences.count += 1;
+                    occurrences.files.insert(path.to_string());
+                    occurrences
+                        .elsewhere
+                        .get_or_insert_with(|| RelatedLocation {
+                            file: Some(path.to_string()),
+                            ..RelatedLocation::new(node, "also written here".to_string())
+                        });
+                }
+            }
+        }
+    }
+
+    let mut hits = Vec::new();
+    let mut seen = BTreeSet::new();
+    for (value, node) in &here {
+        let occurrences = &found[value];
+        if !reported(occurrences) || !seen.insert(value.clone()) {
+            continue;
+        }
+        let shown = excerpt(node_text(*node, source_code));
+        let message = match occurrences.files.len() {
+            1 => format!(
+                "`{}` is written {} times in this file; give it a name with a `const`",
+                shown, occurrences.count
+            ),
+            files => format!(
+                "`{}` is written {} times in {} files of the package; give it a name with a `const`",
+                shown, occurrences.count, files
+            ),
+        };
+        let mut hit = Hit::new(*node).with_message(message);
+        for (_, other) in here.iter().filter(|(other, _)| other == value).skip(1) {
+            hit = hit.with_related(*other, "also written here");
+        }
+        if let Some(elsewhere) = &occurrences.elsewhere {
+            hit = hit.with_location(elsewhere.clone());
+        }
+        hits.push(hit);
+    }
+    hits
+}
+
+/// Whether `node` is a `-` applied to a literal.
+fn is_negation(node: Node, source_code: &str) -> bool {
+    node.kind() == "unary_expression"
+        && node
+            .child_by_field_name("operator")
+            .is_some_and(|operator| node_text(operator, source_code) == "-")
+}
+
+/// Whether the literal is already named or can't be: in a `const`
+/// declaration, an import path, a struct tag or an array length.
+fn is_named(node: Node) -> bool {
+    let mut child = node;
+    while let Some(parent) = child.parent() {
+        let field = |name: &str| {
+            parent
+                .child_by_field_name(name)
+                .is_some_and(|field| field.id() == child.id())
+        };
+        match parent.kind() {
+            "const_declaration" | "import_spec" => return true,
+            "field_declaration" if field("tag") => return true,
+            "array_type" if field("length") => return true,
+            "function_declaration" | "method_declaration" | "func_literal" => return false,
+            _ => {}
+        }
+        child = parent;
+    }
+    false
+}
+
+/// Whether the literal is multiplied by a `time` unit, as in `5 * time.Second`.
+fn is_scaled_by_unit(node: Node, source_code: &str) -> bool {
+    let Some(parent) = node
+        .parent()
+        .filter(|parent| is_product(*parent, source_code))
+    else {
+        return false;
+    };
+    [
+        parent.child_by_field_name("left"),
+        parent.child_by_field_name("right"),
+    ]
+    .into_iter()
+    .flatten()
+    .filter(|operand| operand.id() != node.id())
+    .any(|operand| {
+        operand.kind() == "selector_expression"
+            && operand.child_by_field_name("field").is_some_and(|field| {
+                UNITS
+                    .iter()
+                    .any(|(unit, _)| *unit == node_text(field, source_code))
+            })
+    })
+}
+
+fn is_product(node: Node, source_code: &str) -> bool {
+    node.kind() == "binary_expression"
+        && node
+            .child_by_field_name("operator")
+            .is_some_and(|operator| node_text(operator, source_code) == "*")
+}
+
+/// The value of a string literal.
+fn string_value(literal: &str) -> String {
+    match literal.strip_prefix('`').and_then(|s| s.strip_suffix('`')) {
+        Some(raw) => raw.to_string(),
+        None => super::sql::unescape(literal),
+    }
+}
+
+/// A literal as messages show it.
+pub(super) fn excerpt(text: &str) -> String {
+    match text.char_indices().nth(40) {
+        Some((end, _)) => format!("{}...", &text[..end]),
+        None => text.to_string(),
+    }
+}
+
+/// The value of a numeric literal, or of one in parentheses.
+fn number(node: Node, source_code: &str) -> Option<f64> {
+    match node.kind() {
+        "parenthesized_expression" => number(node.named_child(0)?, source_code),
+        "int_literal" => {
+            let text = node_text(node, source_code).replace('_', "").to_lowercase();
+            let (digits, radix) = match text.get(..2) {
+                Some("0x") => (&text[2..], 16),
+                Some("0b") => (&text[2..], 2),
+                Some("0o") => (&text[2..], 8),
+                _ if text.len() > 1 && text.starts_with('0') => (&text[1..], 8),
+                _ => (text.as_str(), 10),
+            };
+            u64::from_str_radix(digits, radix).ok().map(|n| n as f64)
+        }
+        "float_literal" => node_text(node, source_code).replace('_', "").parse().ok(),
+        _ => None,
+    }
+}
+
+/// The value of a constant expression of numbers, such as `30 * 1000`.
+fn constant(node: Node, source_code: &str) -> Option<f64> {
+    if is_product(node, source_code) {
+        let left = constant(node.child_by_field_name("left")?, source_code)?;
+        let right = constant(node.child_by_field_name("right")?, source_code)?;
+        return Some(left * right);
+    }
+    number(node, source_code)
+}
+
+/// Whether `node` is a call of `package.name` for one of `names`.
+fn calls<'n>(node: Node, source_code: &str, package: &str, names: &[&'n str]) -> Option<&'n str> {
+    let function = node.child_by_field_name("function")?;
+    if function.kind() != "selector_expression" {
+        return None;
+    }
+    let operand = function.child_by_field_name("operand")?;
+    let field = node_text(function.child_by_field_name("field")?, source_code);
+    if node_text(operand, source_code) != package {
+        return None;
+    }
+    names.iter().find(|name| **name == field).copied()
+}
+
+fn argument(call: Node, index: usize) -> Option<Node> {
+    let arguments = call.child_by_field_name("arguments")?;
+    let mut cursor = arguments.walk();
+    let argument = arguments.named_children(&mut cursor).nth(index);
+    argument
+}
+
+fn durations<'t>(root: Node<'t>, source_code: &str) -> Vec<Hit<'t>> {
+    let Some(time) = imported_as(root, source_code, "time") else {
+        return Vec::new();
+    };
+    let context = imported_as(root, source_code, "context");
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() == "binary_expression" {
+            hits.extend(scaled_conversion(node, source_code, &time));
+            return;
+        }
+        if node.kind() != "call_expression" {
+            return;
+        }
+        if calls(node, source_code, &time, &["Duration"]).is_some() {
+            hits.extend(conversion(node, source_code, &time));
+            return;
+        }
+        let duration = if calls(node, source_code, &time, DURATION_FUNCTIONS).is_some() {
+            argument(node, 0)
+        } else if context
+            .as_deref()
+            .is_some_and(|context| calls(node, source_code, context, CONTEXT_FUNCTIONS).is_some())
+        {
+            argument(node, 1)
+        } else if is_now_add(node, source_code, &time) {
+            argument(node, 0)
+        } else {
+            None
+        };
+        if let Some(duration) = duration {
+            hits.extend(bare_duration(duration, source_code, &time));
+        }
+    });
+    hits
+}
+
+/// Whether `call` is `time.Now().Add(...)`.
+fn is_now_add(call: Node, source_code: &str, time: &str) -> bool {
+    let Some(function) = call
+        .child_by_field_name("function")
+        .filter(|function| function.kind() == "selector_expression")
+    else {
+        return false;
+    };
+    let is_add = function
+        .child_by_field_name("field")
+        .is_some_and(|field| node_text(field, source_code) == "Add");
+    is_add
+        && function
+            .child_by_field_name("operand")
+            .filter(|operand| operand.kind() == "call_expression")
+            .is_some_and(|now| calls(now, source_code, time, &["Now"]).is_some())
+}
+
+/// A finding for a duration written as a plain number, such as the
+/// `500` of `time.Sleep(500)`.
+fn bare_duration<'t>(node: Node<'t>, source_code: &str, time: &str) -> Option<Hit<'t>> {
+    let value = constant(node, source_code)?;
+    if value == 0.0 {
+        return None;
+    }
+    let text = node_text(node, source_code);
+    let hit = match in_units(value) {
+        Some((count, unit)) if unit != "Nanosecond" => {
+            let replacement = format!("{} * {}.{}", count, time, unit);
+            Hit::new(node)
+                .with_message(format!(
+                    "`{}` is a `time.Duration` in nanoseconds; write it as `{}`",
+                    text, replacement
+                ))
+                .with_fix(Fix {
+                    description: format!("Write `{}`", replacement),
+                    edits: vec![TextEdit {
+                        start_byte: node.start_byte(),
+                        end_byte: node.end_byte(),
+                        replacement,
+                    }],
+                })
+        }
+        _ => Hit::new(node).with_message(format!(
+            "`{}` is a `time.Duration` of {}ns; write the unit, as in `{} * {}.Millisecond`, if that isn't what it means",
+            text, text, text, time
+        )),
+    };
+    Some(hit)
+}
+
+/// `time.Duration(500)`, and `time.Duration(n * 1000000)`, which multiplies
+/// by a unit written in nanoseconds.
+fn conversion<'t>(call: Node<'t>, source_code: &str, time: &str) -> Option<Hit<'t>> {
+    let argument = argument(call, 0)?;
+    // `time.Duration(5) * time.Second` is what it should be.
+    if call
+        .parent()
+        .is_some_and(|parent| is_product(parent, source_code))
+    {
+        return None;
+    }
+    if constant(argument, source_code).is_some() {
+        return bare_duration(argument, source_code, time);
+    }
+    if !is_product(argument, source_code) {
+        return None;
+    }
+    let left = argument.child_by_field_name("left")?;
+    let right = argument.child_by_field_name("right")?;
+    let (scaled, unit) = match (
+        unit_factor(right, source_code),
+        unit_factor(left, source_code),
+    ) {
+        (Some(unit), _) => (left, unit),
+        (None, Some(unit)) => (right, unit),
+        _ => return None,
+    };
+    let mut replacement = format!(
+        "{}.Duration({}) * {}.{}",
+        time,
+        node_text(scaled, source_code),
+        time,
+        unit
+    );
+    if call
+        .parent()
+        .is_some_and(|parent| matches!(parent.kind(), "binary_expression" | "unary_expression"))
+    {
+        replacement = format!("({})", replacement);
+    }
+    Some(
+        Hit::new(call)
+            .with_message(format!(
+                "`{}` spells out `{}.{}` in nanoseconds; write `{}`",
+                node_text(call, source_code),
+                time,
+                unit,
+                replacement
+            ))
+            .with_fix(Fix {
+                description: format!("Write `{}`", replacement),
+                edits: vec![TextEdit {
+                    start_byte: call.start_byte(),
+                    end_byte: call.end_byte(),
+                    replacement,
+                }],
+            }),
+    )
+}
+
+/// `time.Duration(n) * 1000000`: the unit written in nanoseconds.
+fn scaled_conversion<'t>(node: Node<'t>, source_code: &str, time: &str) -> Option<Hit<'t>> {
+    if !is_product(node, source_code) {
+        return None;
+    }
+    let left = node.child_by_field_name("left")?;
+    let right = node.child_by_field_name("right")?;
+    let is_conversion = |operand: Node| {
+        operand.kind() == "call_expression"
+            && calls(operand, source_code, time, &["Duration"]).is_some()
+    };
+    let (literal, unit) = match (
+        unit_factor(right, source_code),
+        unit_factor(left, source_code),
+    ) {
+        (Some(unit), _) if is_conversion(left) => (right, unit),
+        (_, Some(unit)) if is_conversion(right) => (left, unit),
+        _ => return None,
+    };
+    let replacement = format!("{}.{}", time, unit);
+    Some(
+        Hit::new(literal)
+            .with_message(format!(
+                "`{}` is `{}` in nanoseconds; multiply by `{}`",
+                node_text(literal, source_code),
+                replacement,
+                replacement
+            ))
+            .with_fix(Fix {
+                description: format!("Write `{}`", replacement),
+                edits: vec![TextEdit {
+                    start_byte: literal.start_byte(),
+                    end_byte: literal.end_byte(),
+                    replacement,
+                }],
+            }),
+    )
+}
+
+/// The unit a factor of `1e3`, `1e6` or `1e9` stands for.
+fn unit_factor(node: Node, source_code: &str) -> Option<&'static str> {
+    match number(node, source_code)? {
+        n if n == 1e3 => Some("Microsecond"),
+        n if n == 1e6 => Some("Millisecond"),
+        n if n == 1e9 => Some("Second"),
+        _ => None,
+    }
+}
+
+/// A whole number of nanoseconds as a count of the largest unit it is a
+/// multiple of.
+fn in_units(value: f64) -> Option<(u64, &'static str)> {
+    if value < 1.0 || value.fract() != 0.0 || value > u64::MAX as f64 {
+        return None;
+    }
+    let value = value as u64;
+    UNITS
+        .iter()
+        .find(|(_, size)| value % size == 0)
+        .map(|(unit, size)| (value / size, *unit))
+}
+
+fn sizes<'t>(root: Node<'t>, source_code: &str) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if !is_product(node, source_code)
+            || node
+                .parent()
+                .is_some_and(|parent| is_product(parent, source_code))
+            || is_named(node)
+            || is_package_var(node)
+        {
+            return;
+        }
+        let mut factors = Vec::new();
+        if !integer_factors(node, source_code, &mut factors) || !factors.contains(&1024) {
+            return;
+        }
+        let bytes: u64 = factors.iter().product();
+        let size = ["KiB", "MiB", "GiB", "TiB"]
+            .iter()
+            .enumerate()
+            .rev()
+            .find_map(|(power, unit)| {
+                let scale = 1u64 << (10 * (power + 1));
+                (bytes % scale == 0).then(|| format!("{} {}", bytes / scale, unit))
+            })
+            .unwrap_or_else(|| format!("{} bytes", bytes));
+        hits.push(Hit::new(node).with_message(format!(
+            "`{}` is {} spelled out; give it a name with a `const`, or write it with size constants such as `MiB = 1 << 20`",
+            node_text(node, source_code),
+            size
+        )));
+    });
+    hits
+}
+
+/// The integer literals of a product, when it has nothing else.
+fn integer_factors(node: Node, source_code: &str, factors: &mut Vec<u64>) -> bool {
+    if is_product(node, source_code) {
+        return match (
+            node.child_by_field_name("left"),
+            node.child_by_field_name("right"),
+        ) {
+            (Some(left), Some(right)) => {
+                integer_factors(left, source_code, factors)
+                    && integer_factors(right, source_code, factors)
+            }
+            _ => false,
+        };
+    }
+    match node.kind() {
+        "int_literal" | "parenthesized_expression" => match number(node, source_code) {
+            Some(value) => {
+                factors.push(value as u64);
+                true
+            }
+            None => false,
+        },
+        _ => false,
+    }
+}
+
+/// Whether `node` is in a package-level `var`, which names it.
+fn is_package_var(node: Node) -> bool {
+    let mut current = node;
+    while let Some(parent) = current.parent() {
+        if parent.kind() == "var_declaration" {
+            return parent
+                .parent()
+                .is_some_and(|parent| parent.kind() == "source_file");
+        }
+        current = parent;
+    }
+    false
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_durations_are_written_in_their_largest_unit() {
+        assert_eq!(in_units(5e9), Some((5, "Second")));
+        assert_eq!(in_units(1_500_000.0), Some((1500, "Microsecond")));
+        assert_eq!(in_units(90e9), Some((90, "Second")));
+        assert_eq!(in_units(120e9), Some((2, "Minute")));
+        assert_eq!(in_units(500.0), Some((500, "Nanosecond")));
+        assert_eq!(in_units(0.5), None);
+    }
+
+    #[test]
+    fn test_string_values_ignore_quoting() {
+        assert_eq!(string_value("\"a\\tb\""), "a\tb");
+        assert_eq!(string_value("`a\\tb`"), "a\\tb");
+    }
+}
@@ -0,0 +1,228 @@
+use super::{node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::fix::{Fix, TextEdit};
+use crate::taint::matches_pattern;
+use std::collections::HashSet;
+use tree_sitter::Node;
+
+/// Flags functions that have a context in hand but don't pass it on.
+///
+/// A function has a context when it takes a `context.Context` parameter, or
+/// failing that an `*http.Request` (whose context is `r.Context()`);
+/// closures see the context of the function they're in. Inside such a
+/// function this reports:
+///
+/// - `context.Background()` and `context.TODO()`, which start a new context
+///   that ignores the caller's deadline and cancellation;
+/// - calls with a context-aware variant, such as `db.Query` instead of
+///   `db.QueryContext`. Only calls with arguments count, so `r.URL.Query()`
+///   isn't mistaken for a database query;
+/// - `nil` passed to a function in the same file whose first parameter is a
+///   `context.Context`.
+///
+/// Each finding carries a fix that threads the available context through.
+/// The `variants` option adds `pattern:Replacement` pairs to the built-in
+/// list, e.g. `".Fetch:FetchContext"`; patterns match like taint sources.
+pub struct GoContextPropagation;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("variants", OptionKind::Strings)];
+
+const VARIANTS: &[(&str, &str)] = &[
+    (".Query", "QueryContext"),
+    (".QueryRow", "QueryRowContext"),
+    (".Exec", "ExecContext"),
+    (".Prepare", "PrepareContext"),
+    ("http.NewRequest", "NewRequestWithContext"),
+    ("exec.Command", "CommandContext"),
+];
+
+const FUNCTION_KINDS: &[&str] = &["function_declaration", "method_declaration", "func_literal"];
+
+impl Check for GoContextPropagation {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        let mut variants: Vec<(String, String)> = VARIANTS
+            .iter()
+            .map(|(pattern, replacement)| (pattern.to_string(), replacement.to_string()))
+            .collect();
+        for spec in options.string_list("variants").unwrap_or_default() {
+            if let Some((pattern, replacement)) = spec.rsplit_once(':') {
+                variants.push((pattern.to_string(), replacement.to_string()));
+            }
+        }
+        let takes_context = context_functions(root, source_code);
+
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if node.kind() != "call_expression" {
+                return;
+            }
+            let Some(function) = node.child_by_field_name("function") else {
+                return;
+            };
+            let Some(arguments) = node.child_by_field_name("arguments") else {
+                return;
+            };
+            let Some(context) = available_context(node, source_code) else {
+                return;
+            };
+            let callee = node_text(function, source_code);
+
+            if matches!(callee, "context.Background" | "context.TODO") {
+                let message = format!("`{}()` discards the {} context", callee, context.owner());
+                let fix = replace(node, &context.expression, "Use the available context");
+                hits.push(Hit::new(node).with_message(message).with_fix(fix));
+                return;
+            }
+
+            let variant = variants
+                .iter()
+                .find(|(pattern, _)| matches_pattern(callee, pattern));
+            let field = function.child_by_field_name("field");
+            if let (Some((_, replacement)), Some(name)) = (variant, field) {
+                if arguments.named_child_count() == 0 {
+                    return;
+                }
+                let message = format!(
+                    "`{}` ignores the {} context; use `{}`",
+                    callee,
+                    context.owner(),
+                    replacement
+                );
+                let fix = Fix {
+                    description: format!("Call {} with the available context", replacement),
+                    edits: vec![
+                        TextEdit {
+                            start_byte: name.start_byte(),
+                            end_byte: name.end_byte(),
+                            replacement: replacement.clone(),
+                        },
+                        TextEdit {
+                            start_byte: arguments.start_byte() + 1,
+                            end_byte: arguments.start_byte() + 1,
+                            replacement: format!("{}, ", context.expression),
+                        },
+                    ],
+                };
+                hits.push(Hit::new(node).with_message(message).with_fix(fix));
+                return;
+            }
+
+            let key = match function.kind() {
+                "identifier" => callee.to_string(),
+                "selector_expression" => function
+                    .child_by_field_name("field")
+                    .map(|field| format!(".{}", node_text(field, source_code)))
+                    .unwrap_or_default(),
+                _ => return,
+            };
+            let first = arguments
+                .named_child(0)
+                .filter(|arg| arg.kind() == "nil" && takes_context.contains(&key));
+            if let Some(first) = first {
+                let message = format!(
+                    "`{}` is passed `nil` instead of the {} context",
+                    callee,
+                    context.owner()
+                );
+                let fix = replace(first, &context.expression, "Pass the available context");
+                hits.push(Hit::new(first).with_message(message).with_fix(fix));
+            }
+        });
+        hits
+    }
+}
+
+struct Context {
+    expression: String,
+    from_request: bool,
+}
+
+impl Context {
+    fn owner(&self) -> &'static str {
+        if self.from_request {
+            "request's"
+        } else {
+            "caller's"
+        }
+    }
+}
+
+/// The context visible at `node`, from the nearest enclosing function that
+/// has one.
+fn available_context(node: Node, source_code: &str) -> Option<Context> {
+    let mut current = node.parent();
+    while let Some(ancestor) = current {
+        if FUNCTION_KINDS.contains(&ancestor.kind()) {
+            if let Some(context) = function_context(ancestor, source_code) {
+                return Some(context);
+            }
+        }
+        current = ancestor.parent();
+    }
+    None
+}
+
+fn function_context(function: Node, source_code: &str) -> Option<Context> {
+    let parameters = function.child_by_field_name("parameters")?;
+    let mut request = None;
+    let mut cursor = parameters.walk();
+    for parameter in parameters.named_children(&mut cursor) {
+        let Some(ty) = parameter.child_by_field_name("type") else {
+            continue;
+        };
+        let Some(name) = parameter.child_by_field_name("name") else {
+            continue;
+        };
+        let name = node_text(name, source_code);
+        if name == "_" {
+            continue;
+        }
+        match node_text(ty, source_code) {
+            "context.Context" => {
+                return Some(Context {
+                    expression: name.to_string(),
+                    from_request: false,
+                })
+            }
+            "*http.Request" if request.is_none() => request = Some(name.to_string()),
+            _ => {}
+        }
+    }
+    request.map(|name| Context {
+        expression: format!("{}.Context()", name),
+        from_request: true,
+    })
+}
+
+/// Functions, and methods as `.name`, whose first parameter is a context.
+fn context_functions(root: Node, source_code: &str) -> HashSet<String> {
+    let mut found = HashSet::new();
+    visit(root, &mut |node| {
+        let prefix = match node.kind() {
+            "function_declaration" => "",
+            "method_declaration" => ".",
+            _ => return,
+        };
+        let first_type = node
+            .child_by_field_name("parameters")
+            .and_then(|parameters| parameters.named_child(0))
+            .and_then(|parameter| parameter.child_by_field_name("type"));
+        let name = node.child_by_field_name("name");
+        if let (Some(ty), Some(name)) = (first_type, name) {
+            if node_text(ty, source_code) == "context.Context" {
+                found.insert(format!("{}{}", prefix, node_text(name, source_code)));
+            }
+        }
+    });
+    found
+}
+
+fn replace(node: Node, replacement: &str, description: &str) -> Fix {
+    Fix {
+        description: description.to_string(),
+        edits: vec![TextEdit {
+            start_byte: node.start_byte(),
+            end_byte: node.end_byte(),
+            replacement: replacement.to_string(),
+        }],
+    }
+}
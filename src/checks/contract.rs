@@ -0,0 +1,86 @@
+use super::generics::callee;
+use super::node_text;
+use super::unused_result::{imports, own_facts};
+use crate::analyzer::Confidence;
+use crate::module::{Contract, PackageFacts};
+use crate::package::Package;
+use std::collections::HashMap;
+use std::sync::Arc;
+use tree_sitter::Node;
+
+/// The `//compass:` contracts of the functions a file can call: those of its
+/// own package, and through its `go.mod`, of the packages it imports. See
+/// [`Contract`] for what they declare.
+#[derive(Default)]
+pub(super) struct Contracts {
+    own: HashMap<String, Contract>,
+    /// By local package name.
+    imported: HashMap<String, Arc<PackageFacts>>,
+}
+
+impl Contracts {
+    pub(super) fn collect(root: Node, source_code: &str, package: Option<&Package>) -> Self {
+        let module = package.and_then(|package| package.module.as_ref());
+        let imported = imports(root, source_code, module)
+            .into_iter()
+            .filter_map(|(name, (_, facts))| Some((name, facts?)))
+            .filter(|(_, facts)| !facts.contracts.is_empty())
+            .collect();
+        Contracts {
+            own: own_facts(root, source_code, package).contracts,
+            imported,
+        }
+    }
+
+    /// The contract of the function `call` calls. A method of the package is
+    /// matched by name alone, since the receiver's type isn't known, so it
+    /// comes with medium confidence.
+    pub(super) fn of_call(&self, call: Node, source_code: &str) -> Option<(&Contract, Confidence)> {
+        if self.own.is_empty() && self.imported.is_empty() {
+            return None;
+        }
+        let function = callee(call)?;
+        match function.kind() {
+            "identifier" => self
+                .own
+                .get(node_text(function, source_code))
+                .map(|contract| (contract, Confidence::High)),
+            "selector_expression" => {
+                let operand = function.child_by_field_name("operand")?;
+                let field = node_text(function.child_by_field_name("field")?, source_code);
+                let package = (operand.kind() == "identifier")
+                    .then(|| self.imported.get(node_text(operand, source_code)))
+                    .flatten();
+                if let Some(facts) = package {
+                    return facts
+                        .contracts
+                        .get(field)
+                        .map(|contract| (contract, Confidence::High));
+                }
+                self.own
+                    .iter()
+                    .filter(|(name, _)| {
+                        name.split_once('.')
+                            .is_some_and(|(_, method)| method == field)
+                    })
+                    .min_by_key(|(name, _)| *name)
+                    .map(|(_, contract)| (contract, Confidence::Medium))
+            }
+            _ => None,
+        }
+    }
+}
+
+/// The parameter of `contract` that `argument`, an argument of a call,
+/// is passed as: `""` past the last, as for variadic arguments.
+pub(super) fn parameter_of<'c>(contract: &'c Contract, argument: Node) -> &'c str {
+    let position = argument.parent().map_or(0, |arguments| {
+        let mut cursor = arguments.walk();
+        let position = arguments
+            .named_children(&mut cursor)
+            .filter(|other| other.kind() != "comment")
+            .position(|other| other == argument);
+        position.unwrap_or(usize::MAX)
+    });
+    contract.parameters.get(position).map_or("", String::as_str)
+}
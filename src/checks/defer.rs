@@ -0,0 +1,405 @@
+use super::api_misuse::imported_as;
+use super::loop_capture::enclosing_loops;
+use super::{
+    enclosing_function, list_items, node_text, visit, Check, Hit, OptionKind, RuleOptions,
+};
+use crate::fix::{Fix, TextEdit};
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use crate::taint::matches_pattern;
+use tree_sitter::{Node, Parser};
+
+/// Mistakes with `defer`, which runs a call when the function returns but
+/// evaluates the call's function and arguments right away.
+///
+/// Whether a deferred call returns an error is known for the package's own
+/// functions and methods, matched by name, and for `Close`, `Flush` and
+/// `Sync` on files and writers the function creates with `os.Create`,
+/// `os.OpenFile` or a `NewWriter` of `bufio` or the compression and archive
+/// packages.
+///
+/// Options:
+/// - `functions` (`go_defer_error`, default `[]`): more calls that return
+///   an error, such as `.Commit` or `store.Flush`.
+pub struct GoDefer {
+    issue: DeferIssue,
+}
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("functions", OptionKind::Strings)];
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum DeferIssue {
+    /// `defer` in a loop, which piles up until the function returns.
+    InLoop,
+    /// An argument evaluated at the `defer` and changed before the call.
+    EarlyArguments,
+    /// A deferred call whose error result nobody sees.
+    DroppedError,
+}
+
+impl GoDefer {
+    pub fn new(issue: DeferIssue) -> Self {
+        GoDefer { issue }
+    }
+}
+
+/// Calls returning a file or writer whose `Close`, `Flush` or `Sync`
+/// reports write failures.
+const WRITERS: &[&str] = &[
+    "os.Create",
+    "os.OpenFile",
+    "os.CreateTemp",
+    "bufio.NewWriter",
+    "bufio.NewWriterSize",
+    "gzip.NewWriter",
+    "gzip.NewWriterLevel",
+    "zlib.NewWriter",
+    "flate.NewWriter",
+    "zip.NewWriter",
+    "tar.NewWriter",
+];
+
+const WRITER_METHODS: &[&str] = &["Close", "Flush", "Sync"];
+
+impl Check for GoDefer {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        self.issue == DeferIssue::DroppedError
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let time = imported_as(root, source_code, "time");
+        let returning_error = match self.issue {
+            DeferIssue::DroppedError => {
+                let mut names = error_functions(root, source_code);
+                for file in package
+                    .map(|package| package.files.as_slice())
+                    .unwrap_or_default()
+                {
+                    let mut parser = Parser::new();
+                    if parser
+                        .set_language(&SupportedLanguage::Go.tree_sitter_language())
+                        .is_err()
+                    {
+                        break;
+                    }
+                    if let Some(tree) = parser.parse(&file.source_code, None) {
+                        names.extend(error_functions(tree.root_node(), &file.source_code));
+                    }
+                }
+                names
+            }
+            _ => Vec::new(),
+        };
+        let functions = options.string_list("functions").unwrap_or_default();
+
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if node.kind() != "defer_statement" {
+                return;
+            }
+            let Some(call) = node
+                .named_child(0)
+                .filter(|call| call.kind() == "call_expression")
+            else {
+                return;
+            };
+            let function = enclosing_function(node);
+            match self.issue {
+                DeferIssue::InLoop => {
+                    if enclosing_loops(node).is_empty() {
+                        return;
+                    }
+                    let returns = match function.and_then(|f| f.child_by_field_name("name")) {
+                        Some(name) => format!("`{}` returns", node_text(name, source_code)),
+                        None => "the function returns".to_string(),
+                    };
+                    let message = format!(
+                        "`defer` in a loop runs when {}, not at the end of each iteration, so the deferred calls pile up; move the loop body into a function, or make the call directly",
+                        returns
+                    );
+                    hits.push(Hit::new(node).with_message(message));
+                }
+                DeferIssue::EarlyArguments => {
+                    if let Some(function) = function {
+                        if let Some(hit) =
+                            early_argument(call, function, source_code, time.as_deref())
+                        {
+                            hits.push(hit);
+                        }
+                    }
+                }
+                DeferIssue::DroppedError => {
+                    let Some(callee) = call.child_by_field_name("function") else {
+                        return;
+                    };
+                    let text = node_text(callee, source_code);
+                    let name = match callee.kind() {
+                        "identifier" => text,
+                        "selector_expression" => callee
+                            .child_by_field_name("field")
+                            .map_or("", |field| node_text(field, source_code)),
+                        _ => return,
+                    };
+                    let writer = function
+                        .is_some_and(|function| is_writer_method(callee, function, source_code));
+                    let declared = returning_error.iter().any(|known| known == name);
+                    let listed = functions
+                        .iter()
+                        .any(|pattern| matches_pattern(text, pattern));
+                    if !(writer || declared || listed) {
+                        return;
+                    }
+                    let why = if writer {
+                        ", and for a file or writer that is where a failed write shows up"
+                    } else {
+                        ""
+                    };
+                    let message = format!(
+                        "`defer {}` drops the error `{}` returns{}; check it in a deferred closure, e.g. by joining it into a named error result",
+                        node_text(call, source_code),
+                        name,
+                        why
+                    );
+                    hits.push(Hit::new(call).with_message(message));
+                }
+            }
+        });
+        hits
+    }
+}
+
+/// An argument of the deferred `call` that changes before the function
+/// returns: a variable assigned again after the `defer`, a named result set
+/// by a later `return`, or a `time.Since` or `time.Now` call. The fix defers
+/// a closure instead, so the arguments are evaluated when it runs.
+fn early_argument<'t>(
+    call: Node<'t>,
+    function: Node<'t>,
+    source_code: &str,
+    time: Option<&str>,
+) -> Option<Hit<'t>> {
+    if call
+        .child_by_field_name("function")
+        .is_some_and(|callee| callee.kind() == "func_literal")
+    {
+        return None;
+    }
+    let arguments = call.child_by_field_name("arguments")?;
+    let callee = node_text(call.child_by_field_name("function")?, source_code);
+    let body = function.child_by_field_name("body")?;
+    let results = named_results(function, source_code);
+
+    let mut found: Option<(Node, String, Option<(Node, &str)>)> = None;
+    visit(arguments, &mut |node| {
+        if found.is_some() {
+            return;
+        }
+        match node.kind() {
+            "call_expression" => {
+                let timing = node.child_by_field_name("function").and_then(|f| {
+                    let package = f.child_by_field_name("operand")?;
+                    let name = node_text(f.child_by_field_name("field")?, source_code);
+                    (Some(node_text(package, source_code)) == time
+                        && matches!(name, "Since" | "Now"))
+                    .then_some(name)
+                });
+                if timing.is_some() {
+                    let message = format!(
+                        "`{}` is computed when the `defer` statement runs, not when the function returns, so `{}` doesn't see the time that passed in between",
+                        node_text(node, source_code),
+                        callee
+                    );
+                    found = Some((node, message, None));
+                }
+            }
+            "identifier" if enclosing_function(node) == Some(function) => {
+                let name = node_text(node, source_code);
+                let later = later_assignment(body, call, name, source_code)
+                    .map(|n| (n, "assigned again here"))
+                    .or_else(|| {
+                        results
+                            .contains(&name)
+                            .then(|| later_return(body, call))
+                            .flatten()
+                            .map(|n| (n, "set by this return"))
+                    });
+                if let Some(later) = later {
+                    let message = format!(
+                        "`{}` is evaluated when the `defer` statement runs, so `{}` gets its value from then, not the one it has when the function returns",
+                        name, callee
+                    );
+                    found = Some((node, message, Some(later)));
+                }
+            }
+            _ => {}
+        }
+    });
+    let (node, message, later) = found?;
+    let call_text = node_text(call, source_code);
+    let fix = Fix {
+        description: "Defer a closure".to_string(),
+        edits: vec![TextEdit {
+            start_byte: call.start_byte(),
+            end_byte: call.end_byte(),
+            replacement: format!("func() {{ {} }}()", call_text),
+        }],
+    };
+    let mut hit = Hit::new(node).with_message(message).with_fix(fix);
+    if let Some((later, note)) = later {
+        hit = hit.with_related(later, note);
+    }
+    Some(hit)
+}
+
+/// The first statement after `after` that assigns `name`: `=`, `+=`, `++`,
+/// or a `:=` in the same block, which reuses the variable rather than
+/// declaring a new one.
+fn later_assignment<'t>(
+    body: Node<'t>,
+    after: Node,
+    name: &str,
+    source_code: &str,
+) -> Option<Node<'t>> {
+    let block = after.parent().and_then(|statement| statement.parent());
+    let mut found = None;
+    visit(body, &mut |node| {
+        if found.is_some() || node.start_byte() < after.end_byte() {
+            return;
+        }
+        let targets = match node.kind() {
+            "assignment_statement" => node.child_by_field_name("left"),
+            "short_var_declaration" if node.parent() == block => node.child_by_field_name("left"),
+            "inc_statement" | "dec_statement" => node.named_child(0),
+            _ => None,
+        };
+        let assigns = targets.is_some_and(|targets| {
+            list_items(targets).iter().any(|target| {
+                target.kind() == "identifier" && node_text(*target, source_code) == name
+            })
+        });
+        if assigns {
+            found = Some(node);
+        }
+    });
+    found
+}
+
+/// The first `return` with values after `after`, outside nested closures.
+fn later_return<'t>(body: Node<'t>, after: Node) -> Option<Node<'t>> {
+    let mut found = None;
+    visit(body, &mut |node| {
+        if found.is_none()
+            && node.kind() == "return_statement"
+            && node.start_byte() >= after.end_byte()
+            && node.named_child_count() > 0
+            && enclosing_function(node) == body.parent()
+        {
+            found = Some(node);
+        }
+    });
+    found
+}
+
+/// The names of `function`'s named results.
+fn named_results<'s>(function: Node, source_code: &'s str) -> Vec<&'s str> {
+    let Some(result) = function
+        .child_by_field_name("result")
+        .filter(|result| result.kind() == "parameter_list")
+    else {
+        return Vec::new();
+    };
+    let mut names = Vec::new();
+    let mut cursor = result.walk();
+    for declaration in result.named_children(&mut cursor) {
+        let mut inner = declaration.walk();
+        names.extend(
+            declaration
+                .children_by_field_name("name", &mut inner)
+                .map(|name| node_text(name, source_code)),
+        );
+    }
+    names
+}
+
+/// The functions and methods declared in the file whose last result is an
+/// `error`.
+fn error_functions(root: Node, source_code: &str) -> Vec<String> {
+    let mut names = Vec::new();
+    visit(root, &mut |node| {
+        if !matches!(node.kind(), "function_declaration" | "method_declaration") {
+            return;
+        }
+        let (Some(name), Some(result)) = (
+            node.child_by_field_name("name"),
+            node.child_by_field_name("result"),
+        ) else {
+            return;
+        };
+        let last = match result.kind() {
+            "parameter_list" => {
+                let mut cursor = result.walk();
+                let last = result
+                    .named_children(&mut cursor)
+                    .filter(|declaration| declaration.kind() == "parameter_declaration")
+                    .last()
+                    .and_then(|declaration| declaration.child_by_field_name("type"));
+                last
+            }
+            _ => Some(result),
+        };
+        if last.is_some_and(|ty| node_text(ty, source_code) == "error") {
+            names.push(node_text(name, source_code).to_string());
+        }
+    });
+    names
+}
+
+/// Whether `callee` is `x.Close`, `x.Flush` or `x.Sync` on a variable
+/// `function` assigns from one of the [`WRITERS`].
+fn is_writer_method(callee: Node, function: Node, source_code: &str) -> bool {
+    let (Some(operand), Some(field)) = (
+        callee.child_by_field_name("operand"),
+        callee.child_by_field_name("field"),
+    ) else {
+        return false;
+    };
+    if operand.kind() != "identifier" || !WRITER_METHODS.contains(&node_text(field, source_code)) {
+        return false;
+    }
+    let receiver = node_text(operand, source_code);
+    let mut found = false;
+    visit(function, &mut |node| {
+        if !matches!(
+            node.kind(),
+            "short_var_declaration" | "assignment_statement"
+        ) {
+            return;
+        }
+        let (Some(left), Some(right)) = (
+            node.child_by_field_name("left"),
+            node.child_by_field_name("right"),
+        ) else {
+            return;
+        };
+        let assigns = list_items(left)
+            .first()
+            .is_some_and(|target| node_text(*target, source_code) == receiver);
+        let from_writer = list_items(right)
+            .first()
+            .filter(|value| value.kind() == "call_expression")
+            .and_then(|value| value.child_by_field_name("function"))
+            .is_some_and(|f| WRITERS.contains(&node_text(f, source_code)));
+        found |= assigns && from_writer;
+    });
+    found
+}
@@ -0,0 +1,53 @@
+use super::{Check, Hit, OptionKind, RuleOptions};
+use tree_sitter::Node;
+
+/// The rules of `compass deps`, which read a module's `go.mod` and `go.sum`
+/// rather than its source; see [`crate::deps`]. Source files have nothing
+/// for them to report, so they are configured like any other rule but only
+/// `compass deps` runs them.
+///
+/// Options:
+/// - `allow` (default `[]`): module paths, with everything below them,
+///   that shouldn't be reported.
+/// - `archived` (default `[]`), for `go_mod_archived`: more module paths
+///   to treat as archived, such as internal ones being retired.
+/// - `max_years` (default `3`), for `go_mod_unmaintained`: how long a
+///   module can go without a release.
+/// - `db` (default `$GOVULNDB`) and `ignore` (default `[]`), for
+///   `go_mod_vulnerable`: where the vulnerability database is, and the
+///   vulnerability IDs or aliases not to report.
+pub struct GoModule;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("allow", OptionKind::Strings)];
+
+pub(super) const ARCHIVED_OPTIONS: &[(&str, OptionKind)] = &[
+    ("allow", OptionKind::Strings),
+    ("archived", OptionKind::Strings),
+];
+
+pub(super) const UNMAINTAINED_OPTIONS: &[(&str, OptionKind)] = &[
+    ("allow", OptionKind::Strings),
+    ("max_years", OptionKind::Number),
+];
+
+pub(super) const VULNERABLE_OPTIONS: &[(&str, OptionKind)] = &[
+    ("allow", OptionKind::Strings),
+    ("db", OptionKind::String),
+    ("ignore", OptionKind::Strings),
+];
+
+pub(super) fn max_years(options: &RuleOptions) -> Result<(), String> {
+    match options.float("max_years") {
+        Some(years) if years <= 0.0 => Err(format!(
+            "option 'max_years' must be positive, got {}",
+            years
+        )),
+        _ => Ok(()),
+    }
+}
+
+impl Check for GoModule {
+    fn run<'t>(&self, _root: Node<'t>, _source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+        Vec::new()
+    }
+}
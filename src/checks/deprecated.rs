@@ -0,0 +1,321 @@
+use super::api_misuse::{import_edit, imported_as};
+use super::unused_import::{import_path, local_name};
+use super::{node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::fix::{Fix, TextEdit};
+use crate::module::PackageFacts;
+use crate::package::Package;
+use regex::Regex;
+use std::collections::{HashMap, HashSet};
+use std::sync::{Arc, OnceLock};
+use tree_sitter::Node;
+
+/// Flags uses of declarations that an imported package marks `Deprecated:`,
+/// and imports of packages or modules that are deprecated as a whole.
+///
+/// The notes come from the dependency's source, found through the file's
+/// `go.mod` (see [`crate::module`]), so the version the build actually uses
+/// decides what's deprecated, and from the standard library's. Only
+/// package-qualified names such as `pkg.Func` and `pkg.Type` are checked: a
+/// deprecated method would need the receiver's type. A few widely used
+/// deprecated modules and standard library functions are flagged even
+/// without their source at hand.
+///
+/// The replacement a note names becomes the finding's suggestion. When the
+/// note says the declaration simply calls it, as `ioutil.ReadFile` does
+/// `os.ReadFile`, the fix renames the use and imports the package if the
+/// file doesn't yet; the import left unused is `go_unused_import`'s to
+/// remove. A package deprecated as a whole is reported at its import only
+/// when none of its uses are reported.
+///
+/// Options:
+/// - `allow` (default `[]`): import paths, with everything below them, and
+///   `pkg.Name` uses that shouldn't be reported.
+pub struct GoDeprecatedCall;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("allow", OptionKind::Strings)];
+
+/// Deprecated modules and packages reported whether or not their source is
+/// in the module cache.
+const KNOWN_DEPRECATED: &[(&str, &str)] = &[
+    (
+        "github.com/aws/aws-sdk-go",
+        "the AWS SDK for Go v1 reached end of support on July 31, 2025; use github.com/aws/aws-sdk-go-v2",
+    ),
+    (
+        "github.com/golang/protobuf",
+        "use the google.golang.org/protobuf module instead",
+    ),
+    (
+        "io/ioutil",
+        "as of Go 1.16, the same functionality is provided by package io or package os",
+    ),
+];
+
+/// Deprecated members of packages read from the standard library when its
+/// source isn't at hand, with their notes as the source words them.
+const KNOWN_DEPRECATED_MEMBERS: &[(&str, &str, &str)] = &[
+    ("io/ioutil", "Discard", "As of Go 1.16, this value is simply [io.Discard]."),
+    ("io/ioutil", "NopCloser", "As of Go 1.16, this function simply calls [io.NopCloser]."),
+    ("io/ioutil", "ReadAll", "As of Go 1.16, this function simply calls [io.ReadAll]."),
+    (
+        "io/ioutil",
+        "ReadDir",
+        "As of Go 1.16, [os.ReadDir] is a more efficient and correct choice: it returns a list of [fs.DirEntry] instead of [fs.FileInfo], and it returns partial results in the case of an error midway through reading a directory.",
+    ),
+    ("io/ioutil", "ReadFile", "As of Go 1.16, this function simply calls [os.ReadFile]."),
+    ("io/ioutil", "TempDir", "As of Go 1.17, this function simply calls [os.MkdirTemp]."),
+    ("io/ioutil", "TempFile", "As of Go 1.17, this function simply calls [os.CreateTemp]."),
+    ("io/ioutil", "WriteFile", "As of Go 1.16, this function simply calls [os.WriteFile]."),
+    (
+        "strings",
+        "Title",
+        "The rule Title uses for word boundaries does not handle Unicode punctuation properly. Use golang.org/x/text/cases instead.",
+    ),
+];
+
+struct Import<'t> {
+    spec: Node<'t>,
+    path: String,
+    facts: Option<Arc<PackageFacts>>,
+    /// The note of a package deprecated as a whole. It is reported at the
+    /// import unless the file's uses of it are reported instead.
+    note: Option<String>,
+}
+
+impl Import<'_> {
+    fn member_note(&self, name: &str) -> Option<String> {
+        match &self.facts {
+            Some(facts) => facts.deprecated.get(name).cloned(),
+            None => known_member_deprecation(&self.path, name),
+        }
+    }
+}
+
+/// What a deprecation note says to use instead.
+struct Replacement {
+    /// As the note names it: `os.ReadFile`, `NewClientV2` in the same
+    /// package, or a package such as `golang.org/x/text/cases`.
+    name: String,
+    /// Whether the note says the deprecated declaration only forwards to
+    /// it, so renaming the use changes nothing.
+    drop_in: bool,
+}
+
+impl Check for GoDeprecatedCall {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let allowed = options.string_list("allow").unwrap_or_default();
+        let module = package.and_then(|package| package.module.as_ref());
+        let mut hits = Vec::new();
+        // Local package name to its import.
+        let mut imports: HashMap<String, Import> = HashMap::new();
+
+        let mut specs = Vec::new();
+        visit(root, &mut |node| {
+            if node.kind() == "import_spec" {
+                specs.push(node);
+            }
+        });
+        for spec in specs {
+            let Some(path) = import_path(spec, source_code) else {
+                continue;
+            };
+            if is_allowed(&allowed, path) {
+                continue;
+            }
+            let facts = module.and_then(|module| module.facts(path));
+            let note = facts
+                .as_ref()
+                .and_then(|facts| facts.package_deprecated.clone())
+                .or_else(|| known_deprecation(path));
+            let has_members = match &facts {
+                Some(facts) => !facts.deprecated.is_empty(),
+                None => KNOWN_DEPRECATED_MEMBERS
+                    .iter()
+                    .any(|(known, _, _)| *known == path),
+            };
+            if !has_members {
+                if let Some(note) = note {
+                    let message = format!("`{}` is deprecated: {}", path, note);
+                    hits.push(Hit::new(spec).with_message(message));
+                }
+                continue;
+            }
+            let name = match (spec.child_by_field_name("name"), &facts) {
+                (None, Some(facts)) if !facts.name.is_empty() => Some(facts.name.clone()),
+                _ => local_name(spec, source_code),
+            };
+            if let Some(name) = name {
+                imports.insert(
+                    name,
+                    Import {
+                        spec,
+                        path: path.to_string(),
+                        facts,
+                        note,
+                    },
+                );
+            }
+        }
+        if imports.is_empty() {
+            return hits;
+        }
+
+        let mut used = HashSet::new();
+        visit(root, &mut |node| {
+            let (qualifier, name) = match node.kind() {
+                "selector_expression" => (
+                    node.child_by_field_name("operand"),
+                    node.child_by_field_name("field"),
+                ),
+                "qualified_type" => (
+                    node.child_by_field_name("package"),
+                    node.child_by_field_name("name"),
+                ),
+                _ => return,
+            };
+            let (Some(qualifier), Some(name)) = (qualifier, name) else {
+                return;
+            };
+            if !matches!(qualifier.kind(), "identifier" | "package_identifier") {
+                return;
+            }
+            let qualifier = node_text(qualifier, source_code);
+            let name = node_text(name, source_code);
+            let Some(import) = imports.get(qualifier) else {
+                return;
+            };
+            let Some(note) = import.member_note(name) else {
+                return;
+            };
+            let qualified = format!("{}.{}", qualifier, name);
+            let full = format!("{}.{}", import.path, name);
+            if is_allowed(&allowed, &qualified) || is_allowed(&allowed, &full) {
+                return;
+            }
+            used.insert(qualifier.to_string());
+            let message = format!("`{}` is deprecated: {}", qualified, note);
+            let mut hit = Hit::new(node).with_message(message);
+            if let Some(replacement) = replacement(&note) {
+                let (path, local, member) = resolve(&replacement.name, qualifier, &import.path);
+                let local = usable_name(root, source_code, &path).unwrap_or(local);
+                let suggested = match &member {
+                    Some(member) => format!("{}.{}", local, member),
+                    None => path.clone(),
+                };
+                hit = hit.with_suggestion(format!("Use `{}` instead.", suggested));
+                if let (true, Some(_)) = (replacement.drop_in, &member) {
+                    let mut edits = vec![TextEdit {
+                        start_byte: node.start_byte(),
+                        end_byte: node.end_byte(),
+                        replacement: suggested.clone(),
+                    }];
+                    if usable_name(root, source_code, &path).is_none() {
+                        edits.extend(import_edit(root, &path));
+                    }
+                    hit = hit.with_fix(Fix {
+                        description: format!("Replace `{}` with `{}`", qualified, suggested),
+                        edits,
+                    });
+                }
+            }
+            hits.push(hit);
+        });
+
+        let mut whole: Vec<(&String, &Import)> = imports
+            .iter()
+            .filter(|(name, import)| import.note.is_some() && !used.contains(*name))
+            .collect();
+        whole.sort_by_key(|(_, import)| import.spec.start_byte());
+        for (_, import) in whole {
+            if let Some(note) = &import.note {
+                let message = format!("`{}` is deprecated: {}", import.path, note);
+                hits.push(Hit::new(import.spec).with_message(message));
+            }
+        }
+        hits.sort_by_key(|hit| hit.node.start_byte());
+        hits
+    }
+}
+
+/// The replacement a note names, from phrases such as "simply calls
+/// [os.ReadFile]", which make it a drop-in, or "use NewClientV2".
+fn replacement(note: &str) -> Option<Replacement> {
+    static PHRASE: OnceLock<Regex> = OnceLock::new();
+    let phrase = PHRASE.get_or_init(|| {
+        Regex::new(r"(?i)\b(simply calls|is simply|is an alias for|use)\s+\[?([A-Za-z_][\w./-]*)")
+            .expect("valid regex")
+    });
+    phrase.captures_iter(note).find_map(|captures| {
+        let name = captures[2].trim_end_matches(['.', '-', '/']);
+        // "use the ... module" names nothing to use.
+        let named = name.contains(['.', '/']) || name.starts_with(|c: char| c.is_ascii_uppercase());
+        named.then(|| Replacement {
+            name: name.to_string(),
+            drop_in: !captures[1].eq_ignore_ascii_case("use"),
+        })
+    })
+}
+
+/// Splits a replacement into an import path, the name the path is usually
+/// imported as, and the member, if it names one. A bare name is a member of
+/// the deprecated declaration's own package, imported as `qualifier`.
+fn resolve(name: &str, qualifier: &str, path: &str) -> (String, String, Option<String>) {
+    let member = |name: &str| name.starts_with(|c: char| c.is_ascii_uppercase());
+    if !name.contains(['.', '/']) && member(name) {
+        return (
+            path.to_string(),
+            qualifier.to_string(),
+            Some(name.to_string()),
+        );
+    }
+    let (path, member) = match name.rsplit_once('.') {
+        Some((path, last)) if member(last) && !path.is_empty() => (path, Some(last.to_string())),
+        _ => (name, None),
+    };
+    let local = path.rsplit('/').next().unwrap_or(path).to_string();
+    (path.to_string(), local, member)
+}
+
+/// The name the file imports `path` under, if it does and can use it.
+fn usable_name(root: Node, source_code: &str, path: &str) -> Option<String> {
+    imported_as(root, source_code, path).filter(|name| name != "_" && name != ".")
+}
+
+fn known_member_deprecation(path: &str, name: &str) -> Option<String> {
+    KNOWN_DEPRECATED_MEMBERS
+        .iter()
+        .find(|(known, member, _)| *known == path && *member == name)
+        .map(|(_, _, note)| note.to_string())
+}
+
+fn known_deprecation(path: &str) -> Option<String> {
+    KNOWN_DEPRECATED
+        .iter()
+        .find(|(deprecated, _)| is_within(path, deprecated))
+        .map(|(_, note)| note.to_string())
+}
+
+fn is_allowed(allowed: &[String], name: &str) -> bool {
+    allowed.iter().any(|allowed| is_within(name, allowed))
+}
+
+fn is_within(path: &str, prefix: &str) -> bool {
+    path == prefix
+        || path
+            .strip_prefix(prefix)
+            .is_some_and(|rest| rest.starts_with('/'))
+}
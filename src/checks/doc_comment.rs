@@ -0,0 +1,314 @@
+use super::constant::excerpt;
+use super::import_policy::Pattern;
+use super::panic_reachable::package_name;
+use super::{
+    is_exported, node_text, receiver_type, unknown_option, Check, Hit, OptionKind, RuleOptions,
+};
+use crate::module::doc_comment;
+use crate::package::Package;
+use std::path::Component;
+use tree_sitter::Node;
+
+/// Flags exported functions, methods of exported types, types, constants
+/// and variables declared at the top level without a doc comment, and
+/// doc comments that are stubs, a `TODO`, `FIXME` or `XXX` or fewer than
+/// `min_words` words, or that don't start with the name they document.
+///
+/// Values in a `const (...)` or `var (...)` group may share the group's
+/// comment, and their own comments needn't start with their name. A type's
+/// comment may put "A", "An" or "The" before the name. Directives such as
+/// `//go:generate` and `//nolint` aren't part of the comment.
+///
+/// Options:
+/// - `packages` (default `[]`): package patterns, as `go_import_policy`
+///   takes them, to check, such as `"./pkg/..."`. Without them, every
+///   package other modules can import is checked: not `main`, and not
+///   under an `internal` directory. With them, files outside a module
+///   aren't checked.
+/// - `min_words` (default `2`): comments with fewer words are stubs.
+/// - `require_name` (default `true`): report comments that don't start
+///   with the name.
+///
+/// Test files are skipped.
+pub struct GoDocComment;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("packages", OptionKind::Strings),
+    ("min_words", OptionKind::Count),
+    ("require_name", OptionKind::Bool),
+];
+
+/// Comment lines that are directives to tools rather than documentation.
+const DIRECTIVES: &[&str] = &[
+    "//go:",
+    "//nolint",
+    "//lint:",
+    "//compass:",
+    "//line ",
+    "//export ",
+];
+
+const STUB_WORDS: &[&str] = &["TODO", "FIXME", "XXX"];
+
+/// Compiles `packages`, so that a bad pattern fails when the config loads.
+pub(super) fn checked_packages(options: &RuleOptions) -> Result<Vec<Pattern>, String> {
+    if let Some(error) = options.keys().find_map(|key| unknown_option(OPTIONS, key)) {
+        return Err(error);
+    }
+    options
+        .string_list("packages")
+        .unwrap_or_default()
+        .iter()
+        .map(|pattern| Pattern::compile(pattern).map_err(|e| format!("`packages`: {}", e)))
+        .collect()
+}
+
+impl Check for GoDocComment {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn needs_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let Ok(patterns) = checked_packages(options) else {
+            return Vec::new();
+        };
+        if !is_checked(root, source_code, package, &patterns) {
+            return Vec::new();
+        }
+        let style = Style {
+            min_words: options.usize("min_words").unwrap_or(2),
+            require_name: options.bool("require_name").unwrap_or(true),
+        };
+
+        let mut hits = Vec::new();
+        let mut cursor = root.walk();
+        for declaration in root.named_children(&mut cursor) {
+            match declaration.kind() {
+                "function_declaration" | "method_declaration" => {
+                    let Some(name) = declaration.child_by_field_name("name") else {
+                        continue;
+                    };
+                    let name_text = node_text(name, source_code);
+                    let (kind, display) = match declaration.kind() {
+                        "function_declaration" => ("function", name_text.to_string()),
+                        // Methods of unexported types aren't part of the API.
+                        _ => match receiver_type(declaration, source_code) {
+                            Some(receiver) if is_exported(receiver) => {
+                                ("method", format!("{}.{}", receiver, name_text))
+                            }
+                            _ => continue,
+                        },
+                    };
+                    let symbol = Symbol {
+                        kind,
+                        name: name_text,
+                        display,
+                        node: name,
+                    };
+                    let doc = doc_comment(declaration, source_code);
+                    hits.extend(style.check(&symbol, &doc, true));
+                }
+                "type_declaration" | "const_declaration" | "var_declaration" => {
+                    let kind = match declaration.kind() {
+                        "type_declaration" => "type",
+                        "const_declaration" => "constant",
+                        _ => "variable",
+                    };
+                    let mut specs = Vec::new();
+                    collect_specs(declaration, &mut specs);
+                    let group_doc = doc_comment(declaration, source_code);
+                    for spec in &specs {
+                        let mut names = spec.walk();
+                        let names: Vec<Node> =
+                            spec.children_by_field_name("name", &mut names).collect();
+                        // A lone spec's comment is the declaration's; in a
+                        // group, a spec without one shares the group's.
+                        let (doc, own) = match specs.len() {
+                            1 => (group_doc.clone(), true),
+                            _ => match doc_comment(*spec, source_code) {
+                                doc if comment_text(&doc).is_empty() => (group_doc.clone(), false),
+                                doc => (doc, kind == "type"),
+                            },
+                        };
+                        for name in &names {
+                            let name_text = node_text(*name, source_code);
+                            let symbol = Symbol {
+                                kind,
+                                name: name_text,
+                                display: name_text.to_string(),
+                                node: *name,
+                            };
+                            hits.extend(style.check(&symbol, &doc, own && names.len() == 1));
+                        }
+                    }
+                }
+                _ => {}
+            }
+        }
+        hits
+    }
+}
+
+/// Whether the file is in a package the rule checks.
+pub(super) fn is_checked(
+    root: Node,
+    source_code: &str,
+    package: Option<&Package>,
+    patterns: &[Pattern],
+) -> bool {
+    let clause = package_name(root, source_code).unwrap_or("");
+    let test_file = package
+        .and_then(|package| package.path.file_name())
+        .is_some_and(|name| name.to_string_lossy().ends_with("_test.go"));
+    if test_file || clause.ends_with("_test") {
+        return false;
+    }
+    if patterns.is_empty() {
+        // By import path, so that a checkout under an `internal` directory
+        // isn't all internal; outside a module, by the file's path.
+        let internal = package.is_some_and(|package| match package.import_path() {
+            Some(path) => path.split('/').any(|segment| segment == "internal"),
+            None => package
+                .path
+                .components()
+                .any(|component| component == Component::Normal("internal".as_ref())),
+        });
+        return clause != "main" && !internal;
+    }
+    // Without a module there is no import path to match the patterns to.
+    package.is_some_and(|package| {
+        let (Some(path), Some(module)) = (package.import_path(), package.module.as_ref()) else {
+            return false;
+        };
+        patterns
+            .iter()
+            .any(|pattern| pattern.matches(&path, &module.path))
+    })
+}
+
+/// Type, const and var specs, looking through the `var_spec_list` newer
+/// grammars wrap groups in.
+pub(super) fn collect_specs<'t>(declaration: Node<'t>, specs: &mut Vec<Node<'t>>) {
+    let mut cursor = declaration.walk();
+    for child in declaration.named_children(&mut cursor) {
+        if child.kind().ends_with("_list") {
+            collect_specs(child, specs);
+        } else if child.kind().ends_with("_spec") || child.kind() == "type_alias" {
+            specs.push(child);
+        }
+    }
+}
+
+/// A doc comment's text, without the comment markers and directives.
+fn comment_text(doc: &str) -> String {
+    doc.lines()
+        .filter(|line| {
+            !DIRECTIVES
+                .iter()
+                .any(|directive| line.starts_with(directive))
+        })
+        .flat_map(|line| {
+            line.trim()
+                .trim_start_matches("//")
+                .trim_start_matches("/*")
+                .trim_end_matches("*/")
+                .split_whitespace()
+        })
+        .collect::<Vec<_>>()
+        .join(" ")
+}
+
+struct Style {
+    min_words: usize,
+    require_name: bool,
+}
+
+struct Symbol<'s, 't> {
+    /// "function", "method", "type", "constant" or "variable".
+    kind: &'static str,
+    /// The name the comment should start with.
+    name: &'s str,
+    /// The name in messages: `Type.Method` for methods.
+    display: String,
+    node: Node<'t>,
+}
+
+impl Style {
+    /// A finding for `symbol` with the doc comment `doc`, when it's
+    /// exported and the comment is missing or poor. `own` says whether
+    /// the comment should start with the name.
+    fn check<'t>(&self, symbol: &Symbol<'_, 't>, doc: &str, own: bool) -> Option<Hit<'t>> {
+        if !is_exported(symbol.name) {
+            return None;
+        }
+        let message = self.problem(symbol, &comment_text(doc), own)?;
+        Some(Hit::new(symbol.node).with_message(message))
+    }
+
+    fn problem(&self, symbol: &Symbol, text: &str, own: bool) -> Option<String> {
+        if text.is_empty() {
+            return Some(format!(
+                "exported {} `{}` has no doc comment",
+                symbol.kind, symbol.display
+            ));
+        }
+        let words: Vec<&str> = text.split_whitespace().collect();
+        // `TODO`, `TODO:` and `TODO(name):`.
+        let first = words[0].split([':', '(']).next().unwrap_or("");
+        if STUB_WORDS.contains(&first) || words.len() < self.min_words {
+            return Some(format!(
+                "the doc comment of `{}` is a stub: \"{}\"",
+                symbol.display,
+                excerpt(text)
+            ));
+        }
+        if !self.require_name || !own || words[0].starts_with("Deprecated:") {
+            return None;
+        }
+        let mut start = words[0];
+        if symbol.kind == "type" && matches!(start, "A" | "An" | "The") && words.len() > 1 {
+            start = words[1];
+        }
+        // `Parse`, `Parse's` and `Parse,` all start with the name.
+        let starts_with_name = start
+            .strip_prefix(symbol.name)
+            .is_some_and(|rest| !rest.starts_with(|c: char| c.is_alphanumeric() || c == '_'));
+        (!starts_with_name).then(|| {
+            format!(
+                "the doc comment of `{}` should start with its name, as \"{} ...\", not \"{}\"",
+                symbol.display,
+                symbol.name,
+                excerpt(text)
+            )
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_comment_text_drops_markers_and_directives() {
+        assert_eq!(
+            comment_text("// Parse reads a config.\n//\n//go:noinline\n// It never fails."),
+            "Parse reads a config. It never fails."
+        );
+        assert_eq!(comment_text("/* Open opens. */"), "Open opens.");
+        assert_eq!(comment_text("//nolint:revive"), "");
+    }
+}
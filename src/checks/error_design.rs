@@ -0,0 +1,528 @@
+use super::api_misuse::imported_as;
+use super::constant::excerpt;
+use super::global_state::variables;
+use super::panic::matches_name;
+use super::testing::is_error_call;
+use super::{node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::analyzer::{Confidence, RelatedLocation};
+use crate::fix::{Fix, TextEdit};
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use tree_sitter::{Node, Parser};
+
+/// How a package's errors are written and declared:
+///
+/// - Error strings passed to `errors.New` and `fmt.Errorf` that start with
+///   a capital letter or end with punctuation or a newline. Errors are
+///   wrapped into other messages (`load config: open file: ...`), where
+///   either reads wrong. A first word with more capitals, such as `HTTP`
+///   or `NewReader`, or followed by `(` or `.Field`, is a name and is left
+///   alone. The fix lowercases the letter and drops the punctuation.
+/// - Errors made inside a function that the package's code compares by
+///   text: `err.Error() == "..."`, a `switch` on `err.Error()`, or
+///   `strings.Contains`, `HasPrefix` or `HasSuffix` on it. The text is then
+///   part of the API; a sentinel or an error type says so and lets callers
+///   use `errors.Is` and `errors.As`. Only the files of the package are
+///   seen, and errors made in `_test.go` files are left out.
+/// - Exported package-level variables holding an `errors.New` or
+///   `fmt.Errorf` error whose name doesn't start with `Err`, the prefix
+///   callers look for.
+///
+/// Options:
+/// - `allow` (default `[]`): for strings, first words that may be
+///   capitalized, such as `"Postgres"`; for names, variable names, or
+///   prefixes ending in `*`, such as `"EOF"`.
+pub struct GoErrorDesign {
+    issue: ErrorDesignIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum ErrorDesignIssue {
+    /// Capitalized error strings and trailing punctuation.
+    StringStyle,
+    /// Dynamic errors compared by their text.
+    ComparedByText,
+    /// Exported error variables without the `Err` prefix.
+    VariableName,
+}
+
+impl GoErrorDesign {
+    pub fn new(issue: ErrorDesignIssue) -> Self {
+        GoErrorDesign { issue }
+    }
+}
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("allow", OptionKind::Strings)];
+
+/// How a comparison matches an error's text.
+#[derive(Clone, Copy, PartialEq)]
+enum Match {
+    Equal,
+    Contains,
+    Prefix,
+    Suffix,
+}
+
+impl Check for GoErrorDesign {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        self.issue == ErrorDesignIssue::ComparedByText
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let allowed = options.string_list("allow").unwrap_or_default();
+        let constructors = constructors(root, source_code);
+        match self.issue {
+            ErrorDesignIssue::StringStyle => constructors
+                .into_iter()
+                .filter_map(|(_, literal)| string_style(literal, source_code, &allowed))
+                .collect(),
+            ErrorDesignIssue::ComparedByText => {
+                let test_file = package
+                    .and_then(|package| package.path.file_name())
+                    .is_some_and(|name| name.to_string_lossy().ends_with("_test.go"));
+                if test_file {
+                    return Vec::new();
+                }
+                compared_by_text(root, source_code, package, constructors)
+            }
+            ErrorDesignIssue::VariableName => {
+                let calls: Vec<usize> = constructors.iter().map(|(call, _)| call.id()).collect();
+                let mut hits = Vec::new();
+                for (name, value, _) in variables(root, source_code) {
+                    let text = node_text(name, source_code);
+                    let is_error = value.is_some_and(|value| calls.contains(&value.id()));
+                    if !is_error
+                        || !text.starts_with(|c: char| c.is_uppercase())
+                        || has_prefix(text, "Err")
+                        || allowed.iter().any(|pattern| matches_name(text, pattern))
+                    {
+                        continue;
+                    }
+                    let message = format!(
+                        "exported error `{}` should be named `{}`: callers look for the `Err` prefix to know a sentinel they can match with `errors.Is`",
+                        text,
+                        sentinel_name(text)
+                    );
+                    hits.push(Hit::new(name).with_message(message));
+                }
+                hits
+            }
+        }
+    }
+}
+
+/// The `errors.New` and `fmt.Errorf` calls of the file with their message
+/// literal.
+fn constructors<'t>(root: Node<'t>, source_code: &str) -> Vec<(Node<'t>, Node<'t>)> {
+    let errors = imported_as(root, source_code, "errors");
+    let fmt = imported_as(root, source_code, "fmt");
+    let names: Vec<String> = errors
+        .map(|errors| format!("{}.New", errors))
+        .into_iter()
+        .chain(fmt.map(|fmt| format!("{}.Errorf", fmt)))
+        .collect();
+    if names.is_empty() {
+        return Vec::new();
+    }
+    let mut found = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "call_expression" {
+            return;
+        }
+        let is_constructor = node
+            .child_by_field_name("function")
+            .is_some_and(|function| {
+                names
+                    .iter()
+                    .any(|name| name == node_text(function, source_code))
+            });
+        if !is_constructor {
+            return;
+        }
+        let literal = node
+            .child_by_field_name("arguments")
+            .and_then(|arguments| arguments.named_child(0))
+            .filter(|argument| {
+                matches!(
+                    argument.kind(),
+                    "interpreted_string_literal" | "raw_string_literal"
+                )
+            });
+        if let Some(literal) = literal {
+            found.push((node, literal));
+        }
+    });
+    found
+}
+
+fn string_style<'t>(literal: Node<'t>, source_code: &str, allowed: &[String]) -> Option<Hit<'t>> {
+    let text = node_text(literal, source_code);
+    // Both kinds of literal have a one-byte quote at each end.
+    let content = text.get(1..text.len().checked_sub(1)?)?;
+    let start = literal.start_byte() + 1;
+    let mut problems = Vec::new();
+    let mut edits = Vec::new();
+    let mut fixed = content.to_string();
+
+    let word_end = content
+        .find(|c: char| !c.is_alphanumeric() && c != '\'')
+        .unwrap_or(content.len());
+    let word = &content[..word_end];
+    let mut letters = word.chars();
+    let rest = &content[word_end..];
+    let is_name = rest.starts_with('(')
+        || rest
+            .strip_prefix('.')
+            .is_some_and(|rest| rest.starts_with(char::is_alphabetic));
+    if let Some(first) = letters.next() {
+        let capitalized = first.is_uppercase()
+            && letters.all(|c| c.is_lowercase() || c == '\'')
+            && !is_name
+            && !allowed.iter().any(|allowed| allowed == word);
+        if capitalized {
+            let lowered: String = first.to_lowercase().collect();
+            problems.push("starts with a capital letter".to_string());
+            fixed.replace_range(..first.len_utf8(), &lowered);
+            edits.push(TextEdit {
+                start_byte: start,
+                end_byte: start + first.len_utf8(),
+                replacement: lowered,
+            });
+        }
+    }
+
+    let mut kept = content;
+    loop {
+        let trimmed = kept
+            .strip_suffix("\\n")
+            .or_else(|| kept.strip_suffix(['.', '!', '?', ':', '\n']));
+        match trimmed {
+            Some(trimmed) if !trimmed.trim().is_empty() => kept = trimmed,
+            _ => break,
+        }
+    }
+    if kept.len() < content.len() {
+        let dropped = &content[kept.len()..];
+        problems.push(format!("ends with `{}`", dropped));
+        fixed.truncate(fixed.len() - dropped.len());
+        edits.push(TextEdit {
+            start_byte: start + kept.len(),
+            end_byte: start + content.len(),
+            replacement: String::new(),
+        });
+    }
+
+    if problems.is_empty() {
+        return None;
+    }
+    let message = format!(
+        "the error string {}; errors are wrapped into other messages, as in `load config: {}`, so they start in lowercase and end without punctuation",
+        problems.join(" and "),
+        excerpt(&fixed)
+    );
+    let fix = Fix {
+        description: "Lowercase the error string and drop its punctuation".to_string(),
+        edits,
+    };
+    Some(Hit::new(literal).with_message(message).with_fix(fix))
+}
+
+fn compared_by_text<'t>(
+    root: Node<'t>,
+    source_code: &str,
+    package: Option<&Package>,
+    constructors: Vec<(Node<'t>, Node<'t>)>,
+) -> Vec<Hit<'t>> {
+    let dynamic: Vec<(Node, Vec<&str>)> = constructors
+        .into_iter()
+        .filter(|(call, _)| in_function(*call))
+        .map(|(call, literal)| {
+            let text = node_text(literal, source_code);
+            (call, constant_parts(&text[1..text.len().saturating_sub(1)]))
+        })
+        .collect();
+    if dynamic.is_empty() {
+        return Vec::new();
+    }
+
+    let here = comparisons(root, source_code);
+    let mut elsewhere: Vec<(String, Match, RelatedLocation)> = Vec::new();
+    let mut parser = Parser::new();
+    if parser
+        .set_language(&SupportedLanguage::Go.tree_sitter_language())
+        .is_ok()
+    {
+        for file in package
+            .map(|package| package.files.as_slice())
+            .unwrap_or_default()
+        {
+            let Some(tree) = parser.parse(&file.source_code, None) else {
+                continue;
+            };
+            for (text, how, node) in comparisons(tree.root_node(), &file.source_code) {
+                let location = RelatedLocation {
+                    file: Some(file.path.to_string_lossy().into_owned()),
+                    ..RelatedLocation::new(node, "compared by its text here".to_string())
+                };
+                elsewhere.push((text, how, location));
+            }
+        }
+    }
+
+    let mut hits = Vec::new();
+    for (call, parts) in dynamic {
+        let local: Vec<&(String, Match, Node)> = here
+            .iter()
+            .filter(|(text, how, _)| matches(&parts, text, *how))
+            .collect();
+        let remote: Vec<&(String, Match, RelatedLocation)> = elsewhere
+            .iter()
+            .filter(|(text, how, _)| matches(&parts, text, *how))
+            .collect();
+        let Some(first) = local
+            .first()
+            .map(|(text, _, _)| text)
+            .or_else(|| remote.first().map(|(text, _, _)| text))
+        else {
+            continue;
+        };
+        let message = format!(
+            "the package compares this error by its text (\"{}\"), which makes the wording part of its API; return a sentinel such as `var {} = errors.New(...)` or an error type, and match it with `errors.Is` or `errors.As`",
+            first,
+            sentinel_name(&words(first))
+        );
+        let mut hit = Hit::new(call)
+            .with_message(message)
+            .with_confidence(Confidence::Medium);
+        for (_, _, node) in local {
+            hit = hit.with_related(*node, "compared by its text here");
+        }
+        for (_, _, location) in remote {
+            hit = hit.with_location(location.clone());
+        }
+        hits.push(hit);
+    }
+    hits
+}
+
+/// Where the file compares an error's text with a literal: the literal's
+/// text, how it's compared and the comparison.
+fn comparisons<'t>(root: Node<'t>, source_code: &str) -> Vec<(String, Match, Node<'t>)> {
+    let strings = imported_as(root, source_code, "strings");
+    let literal = |node: Node| {
+        matches!(
+            node.kind(),
+            "interpreted_string_literal" | "raw_string_literal"
+        )
+        .then(|| {
+            let text = node_text(node, source_code);
+            text[1..text.len().saturating_sub(1)].to_string()
+        })
+    };
+    let mut found = Vec::new();
+    visit(root, &mut |node| match node.kind() {
+        "binary_expression" => {
+            let (Some(left), Some(right), Some(operator)) = (
+                node.child_by_field_name("left"),
+                node.child_by_field_name("right"),
+                node.child_by_field_name("operator"),
+            ) else {
+                return;
+            };
+            if !matches!(node_text(operator, source_code), "==" | "!=") {
+                return;
+            }
+            let text = match (
+                is_error_call(left, source_code),
+                is_error_call(right, source_code),
+            ) {
+                (true, false) => literal(right),
+                (false, true) => literal(left),
+                _ => None,
+            };
+            if let Some(text) = text {
+                found.push((text, Match::Equal, node));
+            }
+        }
+        "call_expression" => {
+            let Some(strings) = &strings else {
+                return;
+            };
+            let function = node
+                .child_by_field_name("function")
+                .map(|function| node_text(function, source_code))
+                .unwrap_or("");
+            let how = match function.strip_prefix(strings.as_str()) {
+                Some(".Contains") => Match::Contains,
+                Some(".HasPrefix") => Match::Prefix,
+                Some(".HasSuffix") => Match::Suffix,
+                _ => return,
+            };
+            let Some(arguments) = node.child_by_field_name("arguments") else {
+                return;
+            };
+            let (Some(subject), Some(text)) = (
+                arguments.named_child(0),
+                arguments.named_child(1).and_then(literal),
+            ) else {
+                return;
+            };
+            if is_error_call(subject, source_code) {
+                found.push((text, how, node));
+            }
+        }
+        "expression_switch_statement" => {
+            let is_text = node
+                .child_by_field_name("value")
+                .is_some_and(|value| is_error_call(value, source_code));
+            if !is_text {
+                return;
+            }
+            let mut cursor = node.walk();
+            for case in node.named_children(&mut cursor) {
+                if case.kind() != "expression_case" {
+                    continue;
+                }
+                let Some(values) = case.child_by_field_name("value") else {
+                    continue;
+                };
+                let mut cursor = values.walk();
+                for value in values.named_children(&mut cursor) {
+                    if let Some(text) = literal(value) {
+                        found.push((text, Match::Equal, value));
+                    }
+                }
+            }
+        }
+        _ => {}
+    });
+    found
+}
+
+/// Whether an error whose format has the constant `parts` can have a text
+/// that `text` matches as `how`.
+fn matches(parts: &[&str], text: &str, how: Match) -> bool {
+    if !text.contains(char::is_alphabetic) {
+        return false;
+    }
+    match how {
+        Match::Equal => parts == [text],
+        Match::Contains => parts.iter().any(|part| part.contains(text)),
+        Match::Prefix => parts.first().is_some_and(|part| part.starts_with(text)),
+        Match::Suffix => parts.last().is_some_and(|part| part.ends_with(text)),
+    }
+}
+
+/// The text of a format string between its verbs: `"load %s: %w"` is
+/// `["load ", ": ", ""]`.
+fn constant_parts(format: &str) -> Vec<&str> {
+    let mut parts = Vec::new();
+    let mut start = 0;
+    let mut chars = format.char_indices().peekable();
+    while let Some((at, c)) = chars.next() {
+        if c != '%' {
+            continue;
+        }
+        if chars.next_if(|(_, next)| *next == '%').is_some() {
+            continue;
+        }
+        parts.push(&format[start..at]);
+        start = format.len();
+        for (at, c) in chars.by_ref() {
+            if c.is_ascii_alphabetic() {
+                start = at + 1;
+                break;
+            }
+        }
+    }
+    parts.push(&format[start.min(format.len())..]);
+    parts
+}
+
+fn in_function(node: Node) -> bool {
+    let mut current = node.parent();
+    while let Some(ancestor) = current {
+        if matches!(
+            ancestor.kind(),
+            "function_declaration" | "method_declaration" | "func_literal"
+        ) {
+            return true;
+        }
+        current = ancestor.parent();
+    }
+    false
+}
+
+/// `ErrNotFound` and `ErrX`, but not `Errors` or `ErrorCount`.
+fn has_prefix(name: &str, prefix: &str) -> bool {
+    name.strip_prefix(prefix)
+        .is_some_and(|rest| rest.is_empty() || rest.starts_with(|c: char| c.is_uppercase()))
+}
+
+/// `ErrNotFound` for `NotFound`, `NotFoundError` and `ErrorNotFound`.
+fn sentinel_name(name: &str) -> String {
+    let stem = name
+        .strip_suffix("Error")
+        .filter(|stem| !stem.is_empty())
+        .or_else(|| name.strip_prefix("Error").filter(|stem| !stem.is_empty()))
+        .unwrap_or(name);
+    format!("Err{}", stem)
+}
+
+/// The first few words of a message in `CamelCase`: `UserNotFound` for
+/// `"user not found"`.
+fn words(message: &str) -> String {
+    message
+        .split(|c: char| !c.is_alphanumeric())
+        .filter(|word| !word.is_empty())
+        .take(3)
+        .map(|word| {
+            let mut chars = word.chars();
+            chars
+                .next()
+                .map(|first| first.to_uppercase().chain(chars).collect())
+                .unwrap_or_default()
+        })
+        .collect::<Vec<String>>()
+        .concat()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_format_parts_and_names() {
+        assert_eq!(constant_parts("load %s: %w"), ["load ", ": ", ""]);
+        assert_eq!(constant_parts("100%% done"), ["100%% done"]);
+        assert_eq!(constant_parts("id %-5d"), ["id ", ""]);
+        assert!(matches(&["user not found"], "user not found", Match::Equal));
+        assert!(!matches(
+            &["user ", " not found"],
+            "user 7 not found",
+            Match::Equal
+        ));
+        assert!(matches(
+            &["user ", " not found"],
+            "not found",
+            Match::Contains
+        ));
+        assert!(!matches(&["load ", ""], " ", Match::Contains));
+
+        assert_eq!(sentinel_name("NotFound"), "ErrNotFound");
+        assert_eq!(sentinel_name("NotFoundError"), "ErrNotFound");
+        assert_eq!(sentinel_name("ErrorNotFound"), "ErrNotFound");
+        assert_eq!(words("user not found: id"), "UserNotFound");
+        assert!(has_prefix("ErrClosed", "Err") && !has_prefix("Errors", "Err"));
+    }
+}
@@ -0,0 +1,444 @@
+use super::api_misuse::{import_edit, imported_as};
+use super::logging::unquote;
+use super::unchecked_error::is_error_name;
+use super::{list_items, node_text, visit, Check, Hit, RuleOptions};
+use crate::fix::{Fix, TextEdit};
+use tree_sitter::Node;
+
+/// Errors handled in ways that break the chain `errors.Is` and `errors.As`
+/// walk.
+///
+/// Without type information an error is recognized by its name, as
+/// `go_unchecked_error` recognizes one: `err`, `errClose`, `closeErr`. A
+/// sentinel is a name starting with `Err`, such as `sql.ErrNoRows` or a
+/// package's own `ErrNotFound`, or `io.EOF`. Fixes that call `errors.Is`
+/// or `errors.As` add the `errors` import when the file lacks it.
+pub struct GoErrorWrapping {
+    issue: ErrorIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum ErrorIssue {
+    /// `fmt.Errorf` formatting an error with `%v` or `%s` instead of `%w`.
+    WrapVerb,
+    /// `err == io.EOF` and a `switch err` over sentinels.
+    SentinelComparison,
+    /// `err.(*T)` and `switch err.(type)`.
+    TypeAssertion,
+    /// `fmt.Errorf("%w", err)`, which only wraps.
+    NoContext,
+}
+
+impl GoErrorWrapping {
+    pub fn new(issue: ErrorIssue) -> Self {
+        GoErrorWrapping { issue }
+    }
+}
+
+impl Check for GoErrorWrapping {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+        let fmt = imported_as(root, source_code, "fmt");
+        let errors = ErrorsImport::of(root, source_code);
+        let mut hits = Vec::new();
+        visit(root, &mut |node| match (self.issue, node.kind()) {
+            (ErrorIssue::WrapVerb, "call_expression") => {
+                if let Some(fmt) = &fmt {
+                    hits.extend(wrap_verb(node, source_code, fmt));
+                }
+            }
+            (ErrorIssue::NoContext, "call_expression") => {
+                if let Some(fmt) = &fmt {
+                    hits.extend(no_context(node, source_code, fmt));
+                }
+            }
+            (ErrorIssue::SentinelComparison, "binary_expression") => {
+                hits.extend(comparison(node, source_code, &errors));
+            }
+            (ErrorIssue::SentinelComparison, "expression_switch_statement") => {
+                hits.extend(sentinel_switch(node, source_code));
+            }
+            (ErrorIssue::TypeAssertion, "type_assertion_expression") => {
+                hits.extend(assertion(node, source_code, &errors));
+            }
+            (ErrorIssue::TypeAssertion, "type_switch_statement") => {
+                let value = node
+                    .child_by_field_name("value")
+                    .filter(|value| is_error(*value, source_code));
+                if let Some(value) = value {
+                    let message = format!(
+                        "a type switch on `{}` misses errors wrapped around the types it lists; use `errors.As` for each",
+                        node_text(value, source_code)
+                    );
+                    hits.push(Hit::new(value).with_message(message));
+                }
+            }
+            _ => {}
+        });
+        hits
+    }
+}
+
+/// Whether `node` names an error by the usual conventions.
+fn is_error(node: Node, source_code: &str) -> bool {
+    node.kind() == "identifier" && is_error_name(node_text(node, source_code))
+}
+
+/// `ErrNotFound`, `sql.ErrNoRows` and `io.EOF`.
+fn is_sentinel(node: Node, source_code: &str) -> bool {
+    let name = match node.kind() {
+        "identifier" => node,
+        "selector_expression" => match node.child_by_field_name("field") {
+            Some(field) => field,
+            None => return false,
+        },
+        _ => return false,
+    };
+    let name = node_text(name, source_code);
+    name == "EOF"
+        || name
+            .strip_prefix("Err")
+            .and_then(|rest| rest.chars().next())
+            .is_some_and(char::is_uppercase)
+}
+
+/// The `fmt.Errorf` call `call` is, with its format literal and the
+/// arguments after it.
+fn errorf<'t>(call: Node<'t>, source_code: &str, fmt: &str) -> Option<(Node<'t>, Vec<Node<'t>>)> {
+    let function = call.child_by_field_name("function")?;
+    if node_text(function, source_code) != format!("{}.Errorf", fmt) {
+        return None;
+    }
+    let arguments = call.child_by_field_name("arguments")?;
+    let mut cursor = arguments.walk();
+    let mut arguments: Vec<Node> = arguments
+        .named_children(&mut cursor)
+        .filter(|argument| argument.kind() != "comment")
+        .collect();
+    if arguments.is_empty() {
+        return None;
+    }
+    let format = arguments.remove(0);
+    matches!(
+        format.kind(),
+        "interpreted_string_literal" | "raw_string_literal"
+    )
+    .then_some((format, arguments))
+}
+
+/// The verbs of a format string as byte ranges into `format`, with the
+/// verb letter. `None` for formats whose verbs don't map one to one onto
+/// arguments, such as `%[2]v` or `%*d`.
+fn verbs(format: &str) -> Option<Vec<(usize, usize, char)>> {
+    let bytes = format.as_bytes();
+    let mut found = Vec::new();
+    let mut i = 0;
+    while i < bytes.len() {
+        if bytes[i] != b'%' {
+            i += 1;
+            continue;
+        }
+        let start = i;
+        i += 1;
+        if bytes.get(i) == Some(&b'%') {
+            i += 1;
+            continue;
+        }
+        while i < bytes.len() && b"+-# 0".contains(&bytes[i]) {
+            i += 1;
+        }
+        while i < bytes.len() && (bytes[i].is_ascii_digit() || bytes[i] == b'.') {
+            i += 1;
+        }
+        match bytes.get(i) {
+            Some(b'[') | Some(b'*') | None => return None,
+            Some(&verb) => {
+                found.push((start, i + 1, verb as char));
+                i += 1;
+            }
+        }
+    }
+    Some(found)
+}
+
+fn wrap_verb<'t>(call: Node<'t>, source_code: &str, fmt: &str) -> Vec<Hit<'t>> {
+    let Some((format, arguments)) = errorf(call, source_code, fmt) else {
+        return Vec::new();
+    };
+    let Some(verbs) = verbs(node_text(format, source_code)) else {
+        return Vec::new();
+    };
+
+    let mut hits = Vec::new();
+    for (&(start, end, verb), &argument) in verbs.iter().zip(&arguments) {
+        if verb != 'v' && verb != 's' {
+            continue;
+        }
+        // `err.Error()` is the error's text, so `%w` needs the error itself.
+        let error = if is_error(argument, source_code) {
+            argument
+        } else {
+            match error_text_of(argument, source_code) {
+                Some(error) => error,
+                None => continue,
+            }
+        };
+        let verb_start = format.start_byte() + start;
+        let mut edits = vec![TextEdit {
+            start_byte: verb_start,
+            end_byte: format.start_byte() + end,
+            replacement: "%w".to_string(),
+        }];
+        if error != argument {
+            edits.push(TextEdit {
+                start_byte: argument.start_byte(),
+                end_byte: argument.end_byte(),
+                replacement: node_text(error, source_code).to_string(),
+            });
+        }
+        let message = format!(
+            "`{}` is formatted with `%{}`, so callers can't unwrap it with `errors.Is` or `errors.As`; use `%w`",
+            node_text(error, source_code),
+            verb
+        );
+        let fix = Fix {
+            description: "Wrap the error with %w".to_string(),
+            edits,
+        };
+        hits.push(Hit::new(argument).with_message(message).with_fix(fix));
+    }
+    hits
+}
+
+/// The error of an `err.Error()` call.
+fn error_text_of<'t>(node: Node<'t>, source_code: &str) -> Option<Node<'t>> {
+    if node.kind() != "call_expression"
+        || node
+            .child_by_field_name("arguments")
+            .is_none_or(|arguments| arguments.named_child_count() != 0)
+    {
+        return None;
+    }
+    let function = node.child_by_field_name("function")?;
+    if function.kind() != "selector_expression"
+        || node_text(function.child_by_field_name("field")?, source_code) != "Error"
+    {
+        return None;
+    }
+    function
+        .child_by_field_name("operand")
+        .filter(|operand| is_error(*operand, source_code))
+}
+
+fn no_context<'t>(call: Node<'t>, source_code: &str, fmt: &str) -> Option<Hit<'t>> {
+    let (format, arguments) = errorf(call, source_code, fmt)?;
+    if unquote(node_text(format, source_code)) != "%w" || arguments.len() != 1 {
+        return None;
+    }
+    let error = node_text(arguments[0], source_code);
+    let message = format!(
+        "`{}` wraps `{}` without saying what failed; add context or return `{}` as it is",
+        node_text(call, source_code),
+        error,
+        error
+    );
+    let fix = Fix {
+        description: format!("Return {} unwrapped", error),
+        edits: vec![TextEdit {
+            start_byte: call.start_byte(),
+            end_byte: call.end_byte(),
+            replacement: error.to_string(),
+        }],
+    };
+    Some(Hit::new(call).with_message(message).with_fix(fix))
+}
+
+fn comparison<'t>(node: Node<'t>, source_code: &str, errors: &ErrorsImport) -> Option<Hit<'t>> {
+    let operator = node_text(node.child_by_field_name("operator")?, source_code);
+    if operator != "==" && operator != "!=" {
+        return None;
+    }
+    let (left, right) = (
+        node.child_by_field_name("left")?,
+        node.child_by_field_name("right")?,
+    );
+    let (error, sentinel) = if is_error(left, source_code) && is_sentinel(right, source_code) {
+        (left, right)
+    } else if is_error(right, source_code) && is_sentinel(left, source_code) {
+        (right, left)
+    } else {
+        return None;
+    };
+
+    let not = if operator == "!=" { "!" } else { "" };
+    let replacement = format!(
+        "{}{}.Is({}, {})",
+        not,
+        errors.name,
+        node_text(error, source_code),
+        node_text(sentinel, source_code)
+    );
+    let message = format!(
+        "`{}` misses errors that wrap `{}`; use `{}`",
+        node_text(node, source_code),
+        node_text(sentinel, source_code),
+        replacement
+    );
+    let mut edits = vec![TextEdit {
+        start_byte: node.start_byte(),
+        end_byte: node.end_byte(),
+        replacement,
+    }];
+    edits.extend(errors.edit.clone());
+    let fix = Fix {
+        description: "Compare with errors.Is".to_string(),
+        edits,
+    };
+    Some(Hit::new(node).with_message(message).with_fix(fix))
+}
+
+/// `switch err { case io.EOF: ... }`, reported once at the switch value.
+fn sentinel_switch<'t>(node: Node<'t>, source_code: &str) -> Option<Hit<'t>> {
+    let value = node
+        .child_by_field_name("value")
+        .filter(|value| is_error(*value, source_code))?;
+    let mut cursor = node.walk();
+    let sentinel = node
+        .named_children(&mut cursor)
+        .filter(|case| case.kind() == "expression_case")
+        .filter_map(|case| case.child_by_field_name("value"))
+        .flat_map(list_items)
+        .find(|value| is_sentinel(*value, source_code))?;
+    let message = format!(
+        "`switch {}` compares with `==`, which misses errors that wrap `{}`; use `switch {{ case errors.Is({}, {}): }}`",
+        node_text(value, source_code),
+        node_text(sentinel, source_code),
+        node_text(value, source_code),
+        node_text(sentinel, source_code)
+    );
+    Some(Hit::new(value).with_message(message))
+}
+
+fn assertion<'t>(node: Node<'t>, source_code: &str, errors: &ErrorsImport) -> Option<Hit<'t>> {
+    let operand = node
+        .child_by_field_name("operand")
+        .filter(|operand| is_error(*operand, source_code))?;
+    let ty = node.child_by_field_name("type")?;
+    let message = format!(
+        "`{}` misses a `{}` wrapped inside `{}`; use `errors.As`",
+        node_text(node, source_code),
+        node_text(ty, source_code),
+        node_text(operand, source_code)
+    );
+    let hit = Hit::new(node).with_message(message);
+    match as_fix(node, operand, ty, source_code, errors) {
+        Some(fix) => Some(hit.with_fix(fix)),
+        None => Some(hit),
+    }
+}
+
+/// Rewrites `if target, ok := err.(T); ok {` as `var target T` followed by
+/// `if errors.As(err, &target) {`, the one form that converts mechanically.
+fn as_fix(
+    assertion: Node,
+    operand: Node,
+    ty: Node,
+    source_code: &str,
+    errors: &ErrorsImport,
+) -> Option<Fix> {
+    let right = assertion.parent()?;
+    let declaration = right.parent()?;
+    let statement = declaration.parent()?;
+    if right.kind() != "expression_list"
+        || right.named_child_count() != 1
+        || declaration.kind() != "short_var_declaration"
+        || statement.kind() != "if_statement"
+        || statement.child_by_field_name("initializer") != Some(declaration)
+        // `else if` has nowhere to declare the target.
+        || statement.parent()?.kind() == "if_statement"
+    {
+        return None;
+    }
+    let left = declaration.child_by_field_name("left")?;
+    let (target, ok) = match (
+        left.named_child_count(),
+        left.named_child(0),
+        left.named_child(1),
+    ) {
+        (2, Some(target), Some(ok)) => (target, ok),
+        _ => return None,
+    };
+    let condition = statement.child_by_field_name("condition")?;
+    let ok = node_text(ok, source_code);
+    let target = node_text(target, source_code);
+    if node_text(condition, source_code) != ok || target == "_" {
+        return None;
+    }
+
+    let line_start = source_code[..statement.start_byte()]
+        .rfind('\n')
+        .map_or(0, |i| i + 1);
+    let indent = &source_code[line_start..statement.start_byte()];
+    if !indent.trim().is_empty() {
+        return None;
+    }
+    let mut edits = vec![
+        TextEdit {
+            start_byte: statement.start_byte(),
+            end_byte: statement.start_byte(),
+            replacement: format!("var {} {}\n{}", target, node_text(ty, source_code), indent),
+        },
+        TextEdit {
+            start_byte: declaration.start_byte(),
+            end_byte: condition.end_byte(),
+            replacement: format!(
+                "{}.As({}, &{})",
+                errors.name,
+                node_text(operand, source_code),
+                target
+            ),
+        },
+    ];
+    edits.extend(errors.edit.clone());
+    Some(Fix {
+        description: "Match the error with errors.As".to_string(),
+        edits,
+    })
+}
+
+/// The name `errors` is imported under, and the edit that imports it when
+/// the file doesn't yet.
+struct ErrorsImport {
+    name: String,
+    edit: Option<TextEdit>,
+}
+
+impl ErrorsImport {
+    fn of(root: Node, source_code: &str) -> Self {
+        if let Some(name) = imported_as(root, source_code, "errors").filter(|name| name != "_") {
+            return ErrorsImport { name, edit: None };
+        }
+        ErrorsImport {
+            name: "errors".to_string(),
+            edit: import_edit(root, "errors"),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_verbs_map_onto_arguments() {
+        assert_eq!(
+            verbs("\"open %s: %v (100%%)\""),
+            Some(vec![(6, 8, 's'), (10, 12, 'v')])
+        );
+        assert_eq!(
+            verbs("\"%-8.3f|%+v\""),
+            Some(vec![(1, 7, 'f'), (8, 11, 'v')])
+        );
+        assert_eq!(verbs("\"%[1]v\""), None);
+        assert_eq!(verbs("\"%*d\""), None);
+    }
+}
@@ -0,0 +1,368 @@
+use super::{list_items, node_text, receiver_type, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use tree_sitter::{Node, Parser};
+
+/// Flags switches that leave out some values of an enum, and type switches
+/// that leave out some implementations of a sealed interface.
+///
+/// Without type information, both are recognized from declarations in the
+/// package. An enum is a named type with constants of that type, such as
+/// `type Color int` and `const ( Red Color = iota; Green; Blue )`. A sealed
+/// interface has an unexported method, and its implementations are the
+/// types in the package with that method. A switch is taken to be over the
+/// enum or interface its cases use.
+///
+/// A `default` case only stands in for the missing cases when a comment in
+/// it says so.
+///
+/// Options:
+/// - `types` (default `[]`, meaning every enum and sealed interface): the
+///   names of the types whose switches are checked.
+pub struct GoExhaustive;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("types", OptionKind::Strings)];
+
+/// An enum or sealed interface and the values a switch over it must list.
+struct Closed {
+    name: String,
+    /// Constant names for enums, type names for interfaces.
+    members: Vec<String>,
+}
+
+/// The enums and sealed interfaces of a package.
+#[derive(Default)]
+pub(crate) struct Declarations {
+    enums: Vec<Closed>,
+    sealed: Vec<Closed>,
+}
+
+impl Declarations {
+    /// Those declared in `root` and the package's other files.
+    pub(crate) fn in_package(root: Node, source_code: &str, package: Option<&Package>) -> Self {
+        let mut found = Found::default();
+        found.collect(root, source_code);
+        if let Some(package) = package {
+            let mut parser = Parser::new();
+            if parser
+                .set_language(&SupportedLanguage::Go.tree_sitter_language())
+                .is_ok()
+            {
+                for file in &package.files {
+                    if let Some(tree) = parser.parse(&file.source_code, None) {
+                        found.collect(tree.root_node(), &file.source_code);
+                    }
+                }
+            }
+        }
+        found.declarations()
+    }
+
+    /// Those declared in the roots of a package's files.
+    pub(crate) fn in_files(files: &[(Node, &str)]) -> Self {
+        let mut found = Found::default();
+        for (root, source_code) in files {
+            found.collect(*root, source_code);
+        }
+        found.declarations()
+    }
+
+    /// Whether `switch` lists every value of its enum or every
+    /// implementation of its sealed interface.
+    pub(crate) fn is_complete(&self, switch: Node, source_code: &str) -> bool {
+        let (closures, listed) = self.listed(switch, source_code);
+        coverage(closures, &listed, |_| true).is_some_and(|(_, missing)| missing.is_empty())
+    }
+
+    /// What a switch could be over, and what its cases list.
+    fn listed(&self, switch: Node, source_code: &str) -> (&[Closed], Vec<String>) {
+        match switch.kind() {
+            "type_switch_statement" => (&self.sealed, case_types(switch, source_code)),
+            _ => (&self.enums, case_values(switch, source_code)),
+        }
+    }
+}
+
+impl Check for GoExhaustive {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let declarations = Declarations::in_package(root, source_code, package);
+        let types = options.string_list("types").unwrap_or_default();
+        let checked = |closed: &&Closed| types.is_empty() || types.contains(&closed.name);
+
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            let what = match node.kind() {
+                "expression_switch_statement" => "switch",
+                "type_switch_statement" => "type switch",
+                _ => return,
+            };
+            let (closures, listed) = declarations.listed(node, source_code);
+            let Some((closed, missing)) = coverage(closures, &listed, checked) else {
+                return;
+            };
+            if missing.is_empty() {
+                return;
+            }
+            let mut message = format!(
+                "{} on `{}` is missing {}",
+                what,
+                closed.name,
+                missing
+                    .iter()
+                    .map(|member| format!("`{}`", member))
+                    .collect::<Vec<_>>()
+                    .join(", ")
+            );
+            match default_case(node) {
+                Some(default) if has_comment(node, default) => return,
+                Some(_) => message.push_str(
+                    "; if the `default` is meant to handle them, say so in a comment there",
+                ),
+                None => {}
+            }
+            let anchor = node.child_by_field_name("value").unwrap_or(node);
+            hits.push(Hit::new(anchor).with_message(message));
+        });
+        hits
+    }
+}
+
+/// The enum or interface most of the `listed` cases use, ties going to
+/// the first declared, and the members they leave out.
+fn coverage<'a>(
+    closures: &'a [Closed],
+    listed: &[String],
+    checked: impl Fn(&&Closed) -> bool,
+) -> Option<(&'a Closed, Vec<&'a str>)> {
+    let mut best: Option<(&Closed, usize)> = None;
+    for closed in closures.iter().filter(checked) {
+        let used = listed
+            .iter()
+            .filter(|name| closed.members.contains(name))
+            .count();
+        if used > 0 && best.is_none_or(|(_, most)| used > most) {
+            best = Some((closed, used));
+        }
+    }
+    let (closed, _) = best?;
+    let missing = closed
+        .members
+        .iter()
+        .filter(|member| !listed.contains(member))
+        .map(String::as_str)
+        .collect();
+    Some((closed, missing))
+}
+
+/// What the package's files declare, before it is sorted into enums and
+/// sealed interfaces.
+#[derive(Default)]
+struct Found {
+    /// Types defined as another named type, as in `type Color int`.
+    named: Vec<String>,
+    /// `(interface, sealing method)`.
+    interfaces: Vec<(String, String)>,
+    /// `(type, constant)`.
+    constants: Vec<(String, String)>,
+    /// `(method, receiver type)`.
+    methods: Vec<(String, String)>,
+}
+
+impl Found {
+    fn collect(&mut self, root: Node, source_code: &str) {
+        visit(root, &mut |node| match node.kind() {
+            "type_spec" => {
+                let (Some(name), Some(ty)) = (
+                    node.child_by_field_name("name"),
+                    node.child_by_field_name("type"),
+                ) else {
+                    return;
+                };
+                let name = node_text(name, source_code).to_string();
+                match ty.kind() {
+                    "type_identifier" => self.named.push(name),
+                    "interface_type" => {
+                        if let Some(method) = sealing_method(ty, source_code) {
+                            self.interfaces.push((name, method));
+                        }
+                    }
+                    _ => {}
+                }
+            }
+            "const_declaration" => self.constants.extend(typed_constants(node, source_code)),
+            "method_declaration" => {
+                let (Some(name), Some(receiver)) = (
+                    node.child_by_field_name("name"),
+                    receiver_type(node, source_code),
+                ) else {
+                    return;
+                };
+                self.methods.push((
+                    node_text(name, source_code).to_string(),
+                    receiver.to_string(),
+                ));
+            }
+            _ => {}
+        });
+    }
+
+    fn declarations(self) -> Declarations {
+        let members = |pairs: &[(String, String)], key: &str| {
+            let mut members: Vec<String> = Vec::new();
+            for (matched, member) in pairs {
+                if matched == key && !members.contains(member) {
+                    members.push(member.clone());
+                }
+            }
+            members
+        };
+        let mut declarations = Declarations::default();
+        for name in &self.named {
+            let constants = members(&self.constants, name);
+            if !constants.is_empty() && !declarations.enums.iter().any(|e| e.name == *name) {
+                declarations.enums.push(Closed {
+                    name: name.clone(),
+                    members: constants,
+                });
+            }
+        }
+        for (name, method) in &self.interfaces {
+            let implementations = members(&self.methods, method);
+            if !implementations.is_empty() && !declarations.sealed.iter().any(|i| i.name == *name) {
+                declarations.sealed.push(Closed {
+                    name: name.clone(),
+                    members: implementations,
+                });
+            }
+        }
+        declarations
+    }
+}
+
+/// The unexported method that keeps other packages from implementing an
+/// interface, such as `isShape()`.
+fn sealing_method(interface: Node, source_code: &str) -> Option<String> {
+    let mut cursor = interface.walk();
+    let found = interface
+        .named_children(&mut cursor)
+        .filter(|element| matches!(element.kind(), "method_elem" | "method_spec"))
+        .filter_map(|element| element.child_by_field_name("name"))
+        .map(|name| node_text(name, source_code))
+        .find(|name| name.starts_with(|c: char| c.is_lowercase()))
+        .map(str::to_string);
+    found
+}
+
+/// `(type, name)` for the constants of a declaration with a named type,
+/// following Go's implicit repetition: a spec without a type or value
+/// repeats the one before it.
+fn typed_constants(declaration: Node, source_code: &str) -> Vec<(String, String)> {
+    let mut constants = Vec::new();
+    let mut current: Option<String> = None;
+    let mut cursor = declaration.walk();
+    for spec in declaration.named_children(&mut cursor) {
+        if spec.kind() != "const_spec" {
+            continue;
+        }
+        match (
+            spec.child_by_field_name("type"),
+            spec.child_by_field_name("value"),
+        ) {
+            (Some(ty), _) => current = Some(node_text(ty, source_code).to_string()),
+            (None, Some(_)) => current = None,
+            (None, None) => {}
+        }
+        let Some(ty) = &current else {
+            continue;
+        };
+        let mut names = spec.walk();
+        for name in spec.children_by_field_name("name", &mut names) {
+            let name = node_text(name, source_code);
+            if name != "_" {
+                constants.push((ty.clone(), name.to_string()));
+            }
+        }
+    }
+    constants
+}
+
+/// The identifiers the cases of an expression switch compare against.
+fn case_values(switch: Node, source_code: &str) -> Vec<String> {
+    let mut values = Vec::new();
+    let mut cursor = switch.walk();
+    for case in switch.named_children(&mut cursor) {
+        if case.kind() != "expression_case" {
+            continue;
+        }
+        if let Some(list) = case.child_by_field_name("value") {
+            values.extend(
+                list_items(list)
+                    .into_iter()
+                    .filter(|value| value.kind() == "identifier")
+                    .map(|value| node_text(value, source_code).to_string()),
+            );
+        }
+    }
+    values
+}
+
+/// The type names the cases of a type switch list, without `*`.
+fn case_types(switch: Node, source_code: &str) -> Vec<String> {
+    let mut types = Vec::new();
+    let mut cursor = switch.walk();
+    for case in switch.named_children(&mut cursor) {
+        if case.kind() != "type_case" {
+            continue;
+        }
+        let mut inner = case.walk();
+        for ty in case.children_by_field_name("type", &mut inner) {
+            let ty = match ty.kind() {
+                "pointer_type" => ty.named_child(0).unwrap_or(ty),
+                _ => ty,
+            };
+            if ty.kind() == "type_identifier" {
+                types.push(node_text(ty, source_code).to_string());
+            }
+        }
+    }
+    types
+}
+
+fn default_case(switch: Node) -> Option<Node> {
+    let mut cursor = switch.walk();
+    let found = switch
+        .named_children(&mut cursor)
+        .find(|case| case.kind() == "default_case");
+    found
+}
+
+/// Whether a comment sits in the `default` case, up to the next case or
+/// the end of the switch.
+fn has_comment(switch: Node, default: Node) -> bool {
+    let mut next = default.next_named_sibling();
+    while next.is_some_and(|node| node.kind() == "comment") {
+        next = next.and_then(|node| node.next_named_sibling());
+    }
+    let end = next.map_or(switch.end_byte(), |node| node.start_byte());
+    let mut found = false;
+    visit(switch, &mut |node| {
+        if node.kind() == "comment" && (default.start_byte()..end).contains(&node.start_byte()) {
+            found = true;
+        }
+    });
+    found
+}
@@ -0,0 +1,269 @@
+use super::panic::{enclosing_declaration, matches_name};
+use super::panic_reachable::package_name;
+use super::{enclosing_function, imports, node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::callgraph::{declaration_id, is_graphed, CallGraph};
+use crate::package::Package;
+use crate::taint::matches_pattern;
+use std::collections::{HashSet, VecDeque};
+use tree_sitter::Node;
+
+/// Flags calls that end the program: `os.Exit` and `log.Fatal`,
+/// `log.Fatalf` and `log.Fatalln`.
+///
+/// - Outside `main` and `init` of package `main`, they take the decision to
+///   stop away from the program, and a library that exits can't be reused
+///   or tested. `TestMain` may call `os.Exit`. With `allow_main_helpers`,
+///   a function of package `main` is allowed when the module's call graph
+///   shows it is only ever called, through other functions of `main`, from
+///   `main` or `init`. A function only used as a value has no callers, so
+///   it is still reported.
+/// - In a function with a `defer` before them, they end the program
+///   without running it, so files aren't flushed and locks aren't released.
+///
+/// `log` and `os` are followed through their import names. Options:
+/// - `functions`: more calls that end the program, matched like taint
+///   sources (`"logrus.Fatal"`, `"klog.Exit"`).
+/// - `allow` (outside `main` only): functions, or prefixes ending in `*`,
+///   that may exit.
+/// - `allow_main_helpers` (outside `main` only, default `false`): allow
+///   functions only `main` and `init` call.
+pub struct GoExit {
+    issue: ExitIssue,
+}
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("functions", OptionKind::Strings)];
+
+pub(super) const MAIN_OPTIONS: &[(&str, OptionKind)] = &[
+    ("functions", OptionKind::Strings),
+    ("allow", OptionKind::Strings),
+    ("allow_main_helpers", OptionKind::Bool),
+];
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum ExitIssue {
+    /// Exits outside `main` and `init` of package `main`.
+    OutsideMain,
+    /// Exits that skip the function's deferred calls.
+    SkipsDefers,
+}
+
+impl GoExit {
+    pub fn new(issue: ExitIssue) -> Self {
+        GoExit { issue }
+    }
+}
+
+/// Calls that never return, by import path.
+const EXITS: &[(&str, &[&str])] = &[("os", &["Exit"]), ("log", &["Fatal", "Fatalf", "Fatalln"])];
+
+const ENTRY_POINTS: &[&str] = &["main", "init"];
+
+impl Check for GoExit {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        self.issue == ExitIssue::OutsideMain
+    }
+
+    fn reads_call_graph(&self) -> bool {
+        self.issue == ExitIssue::OutsideMain
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let exits = exit_calls(root, source_code, options);
+        match self.issue {
+            ExitIssue::OutsideMain => outside_main(root, source_code, options, package, exits),
+            ExitIssue::SkipsDefers => skipped_defers(source_code, exits),
+        }
+    }
+}
+
+/// The calls under `root` that end the program, with their callee.
+fn exit_calls<'t>(
+    root: Node<'t>,
+    source_code: &str,
+    options: &RuleOptions,
+) -> Vec<(Node<'t>, String)> {
+    let mut names = HashSet::new();
+    for (local, path) in imports(root, source_code) {
+        for (package, functions) in EXITS {
+            if path == *package {
+                names.extend(
+                    functions
+                        .iter()
+                        .map(|function| format!("{}.{}", local, function)),
+                );
+            }
+        }
+    }
+    let extra = options.string_list("functions").unwrap_or_default();
+
+    let mut calls = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "call_expression" {
+            return;
+        }
+        let Some(function) = node.child_by_field_name("function") else {
+            return;
+        };
+        let callee = node_text(function, source_code);
+        if names.contains(callee) || extra.iter().any(|pattern| matches_pattern(callee, pattern)) {
+            calls.push((node, callee.to_string()));
+        }
+    });
+    calls
+}
+
+fn outside_main<'t>(
+    root: Node<'t>,
+    source_code: &str,
+    options: &RuleOptions,
+    package: Option<&Package>,
+    exits: Vec<(Node<'t>, String)>,
+) -> Vec<Hit<'t>> {
+    let Some(package_name) = package_name(root, source_code) else {
+        return Vec::new();
+    };
+    let is_main = package_name == "main";
+    let allowed = options.string_list("allow").unwrap_or_default();
+    let helpers = if is_main && options.bool("allow_main_helpers").unwrap_or(false) {
+        package.and_then(|package| {
+            let graph = package.call_graph()?;
+            let import_path = package.import_path()?;
+            is_graphed(&package.path).then_some((graph, import_path))
+        })
+    } else {
+        None
+    };
+
+    let mut hits = Vec::new();
+    for (call, callee) in exits {
+        let declaration = enclosing_declaration(call);
+        let name = declaration
+            .and_then(|declaration| {
+                (declaration.kind() == "function_declaration")
+                    .then(|| declaration.child_by_field_name("name"))
+                    .flatten()
+            })
+            .map(|name| node_text(name, source_code));
+        if is_main && name.is_some_and(|name| ENTRY_POINTS.contains(&name)) {
+            continue;
+        }
+        if name == Some("TestMain") {
+            continue;
+        }
+        let declared = declaration
+            .and_then(|declaration| declaration.child_by_field_name("name"))
+            .map(|name| node_text(name, source_code));
+        if declared.is_some_and(|name| allowed.iter().any(|pattern| matches_name(name, pattern))) {
+            continue;
+        }
+        if let (Some((graph, import_path)), Some(declaration)) = (&helpers, declaration) {
+            let function = declaration_id(import_path, declaration, source_code)
+                .and_then(|id| graph.find(&id));
+            if function.is_some_and(|function| only_called_from_main(graph, function, import_path))
+            {
+                continue;
+            }
+        }
+
+        let message = match (is_main, declared) {
+            (true, Some(function)) => format!(
+                "`{}` in `{}` ends the program outside `main` and `init`; return an error and let `main` exit",
+                callee, function
+            ),
+            (true, None) => format!(
+                "`{}` ends the program outside `main` and `init`; return an error and let `main` exit",
+                callee
+            ),
+            (false, _) => format!(
+                "`{}` in package `{}` ends the whole program from library code; return an error and let the caller decide",
+                callee, package_name
+            ),
+        };
+        hits.push(Hit::new(call).with_message(message));
+    }
+    hits
+}
+
+/// Whether every chain of calls reaching `function` comes, through
+/// functions of package `main`, from `main` or `init`, and some chain does.
+fn only_called_from_main(graph: &CallGraph, function: usize, package: &str) -> bool {
+    let is_entry = |function: usize| {
+        let function = &graph.functions[function];
+        function.receiver.is_none() && ENTRY_POINTS.contains(&function.name.as_str())
+    };
+    let mut seen = HashSet::from([function]);
+    let mut queue = VecDeque::from([function]);
+    while let Some(current) = queue.pop_front() {
+        if is_entry(current) {
+            continue;
+        }
+        let mut callers = graph.callers(current).peekable();
+        if callers.peek().is_none() {
+            return false;
+        }
+        for call in callers {
+            if graph.functions[call.caller].package != package {
+                return false;
+            }
+            if seen.insert(call.caller) {
+                queue.push_back(call.caller);
+            }
+        }
+    }
+    true
+}
+
+fn skipped_defers<'t>(source_code: &str, exits: Vec<(Node<'t>, String)>) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    for (call, callee) in exits {
+        let Some(function) = enclosing_function(call) else {
+            continue;
+        };
+        // The defers of this function, not of closures inside it.
+        let mut defers = Vec::new();
+        visit(function, &mut |node| {
+            if node.kind() == "defer_statement"
+                && node.start_byte() < call.start_byte()
+                && enclosing_function(node) == Some(function)
+            {
+                defers.push(node);
+            }
+        });
+        let Some(defer) = defers.first() else {
+            continue;
+        };
+        let deferred = defer
+            .named_child(0)
+            .map(|deferred| node_text(deferred, source_code))
+            .unwrap_or("");
+        let message = if defers.len() == 1 {
+            format!(
+                "`{}` ends the program without running the deferred `{}`",
+                callee, deferred
+            )
+        } else {
+            format!(
+                "`{}` ends the program without running the deferred `{}` and {} other deferred calls",
+                callee,
+                deferred,
+                defers.len() - 1
+            )
+        };
+        hits.push(
+            Hit::new(call)
+                .with_message(message)
+                .with_related(*defer, "deferred here"),
+        );
+    }
+    hits
+}
@@ -0,0 +1,450 @@
+//! The pieces shared by the rules that walk a function body path by path:
+//! the mutex, resource leak, transaction and nil dereference rules.
+//!
+//! Branches of `if`, `switch` and `select` are followed separately and
+//! merged afterwards, and a loop body may or may not run. A path that
+//! returns, panics or jumps away stops being followed.
+//!
+//! [`unreleased`] is the whole walk for the resource leak and transaction
+//! rules, which follow values the function has to get rid of.
+
+use super::{node_text, visit, Hit};
+use std::collections::HashSet;
+use tree_sitter::Node;
+
+/// What a walk tracks along one path.
+pub(super) trait Path: Clone + Default {
+    /// Whether the path has left the statements being walked.
+    fn terminated(&self) -> bool;
+
+    fn terminate(&mut self);
+
+    /// Adds what `other` tracks, for the state after either of them ran.
+    fn join(&mut self, other: Self);
+}
+
+/// The state after any one of `branches` ran. Branches that terminated
+/// don't reach it, and when all of them did, neither does it.
+pub(super) fn merge<S: Path>(branches: Vec<S>) -> S {
+    let mut live = branches.into_iter().filter(|branch| !branch.terminated());
+    let Some(mut merged) = live.next() else {
+        let mut merged = S::default();
+        merged.terminate();
+        return merged;
+    };
+    for branch in live {
+        merged.join(branch);
+    }
+    merged
+}
+
+/// Adds the items of `other` that `tracked` doesn't have yet.
+pub(super) fn union<T>(tracked: &mut Vec<T>, other: Vec<T>, same: impl Fn(&T, &T) -> bool) {
+    for item in other {
+        if !tracked.iter().any(|known| same(known, &item)) {
+            tracked.push(item);
+        }
+    }
+}
+
+/// A path-by-path walk. Each rule says what a statement does; statement
+/// lists and the cases of a `switch` or `select` are followed the same way
+/// by all of them.
+pub(super) trait Walk<'t> {
+    type State: Path;
+
+    fn statement(&mut self, node: Node<'t>, state: &mut Self::State);
+
+    fn statements(&mut self, node: Node<'t>, state: &mut Self::State) {
+        let mut cursor = node.walk();
+        let children: Vec<Node<'t>> = node.named_children(&mut cursor).collect();
+        for child in children {
+            if state.terminated() {
+                return;
+            }
+            self.statement(child, state);
+        }
+    }
+
+    /// Runs the initializer and value of a `switch` or `select`, then each
+    /// case on its own path. Without a `default`, none of them may run.
+    fn switch(&mut self, node: Node<'t>, state: &mut Self::State) {
+        let mut branches = Vec::new();
+        let mut has_default = false;
+        let mut cursor = node.walk();
+        let children: Vec<Node<'t>> = node.named_children(&mut cursor).collect();
+        for child in children {
+            match child.kind() {
+                "expression_case" | "type_case" | "communication_case" | "default_case" => {
+                    has_default |= child.kind() == "default_case";
+                    let mut branch = state.clone();
+                    self.statements(child, &mut branch);
+                    branches.push(branch);
+                }
+                _ => self.statement(child, state),
+            }
+        }
+        if !has_default {
+            branches.push(state.clone());
+        }
+        *state = merge(branches);
+    }
+}
+
+/// Call expressions under `node` in document order, not entering closures.
+pub(super) fn collect_calls<'t>(node: Node<'t>, calls: &mut Vec<Node<'t>>) {
+    if node.kind() == "func_literal" {
+        return;
+    }
+    let mut cursor = node.walk();
+    for child in node.named_children(&mut cursor) {
+        collect_calls(child, calls);
+    }
+    // Arguments are evaluated before the call itself.
+    if node.kind() == "call_expression" {
+        calls.push(node);
+    }
+}
+
+/// `err != nil` gives `(err, true)` and `err == nil` gives `(err, false)`.
+pub(super) fn nil_check(condition: Node, source_code: &str) -> Option<(String, bool)> {
+    let condition = if condition.kind() == "parenthesized_expression" {
+        condition.named_child(0)?
+    } else {
+        condition
+    };
+    if condition.kind() != "binary_expression" {
+        return None;
+    }
+    let left = condition.child_by_field_name("left")?;
+    let right = condition.child_by_field_name("right")?;
+    let operator = node_text(condition.child_by_field_name("operator")?, source_code);
+    if left.kind() != "identifier" || right.kind() != "nil" {
+        return None;
+    }
+    let err = node_text(left, source_code).to_string();
+    match operator {
+        "!=" => Some((err, true)),
+        "==" => Some((err, false)),
+        _ => None,
+    }
+}
+
+/// A value the function has to get rid of, such as a file to close.
+#[derive(Clone)]
+pub(super) struct Open<'t, K> {
+    /// The variable holding it.
+    pub name: String,
+    pub callee: String,
+    pub node: Node<'t>,
+    /// The error returned alongside it, while that variable is unchanged.
+    pub err: Option<String>,
+    /// What the rule knows about it besides.
+    pub kind: K,
+}
+
+/// What a rule walked by [`unreleased`] tracks: which calls acquire a
+/// value and which get rid of it.
+pub(super) trait Obligation {
+    type Kind: Clone;
+
+    /// The note on a `return` that leaves a value behind.
+    const RETURNS: &'static str;
+
+    /// Whether `call` acquires a value, when its results are assigned.
+    fn acquires(&self, call: Node, source_code: &str) -> Option<Self::Kind>;
+
+    /// The expression that gets rid of `open`.
+    fn handle(&self, open: &Open<Self::Kind>) -> String {
+        open.name.clone()
+    }
+
+    /// The expression `call` gets rid of, as `x` for `x.Close()`.
+    fn releases(&self, call: Node, source_code: &str) -> Option<String>;
+
+    /// Whether `call` becomes responsible for the value passed as
+    /// `argument`.
+    fn takes(&self, call: Node, argument: Node, source_code: &str) -> bool;
+
+    /// The message for a value from `callee` assigned to `_`.
+    fn discarded(&self, callee: &str) -> String;
+
+    /// The message for a value some path keeps.
+    fn unreleased(&self, open: &Open<Self::Kind>) -> String;
+}
+
+/// Walks `body` path by path and reports the values of `rule` that some
+/// path neither gets rid of nor hands on: those in `open` to begin with,
+/// and those acquired on the way. A value stops being tracked when it is
+/// released, directly, in a `defer` or in a closure; when it is known to be
+/// nil, on the `err != nil` branch after it was acquired; and when it is
+/// returned, stored in a variable, field or composite literal, sent on a
+/// channel, or passed to a function that takes it.
+pub(super) fn unreleased<'t, O: Obligation>(
+    rule: &O,
+    body: Node<'t>,
+    open: Vec<Open<'t, O::Kind>>,
+    source_code: &str,
+) -> Vec<Hit<'t>> {
+    let mut walker = Releases {
+        source_code,
+        rule,
+        reported: HashSet::new(),
+        hits: Vec::new(),
+    };
+    let mut state = Obligations {
+        open,
+        terminated: false,
+    };
+    walker.statement(body, &mut state);
+    if !state.terminated {
+        walker.report(&state, None);
+    }
+    walker.hits
+}
+
+#[derive(Clone)]
+struct Obligations<'t, K> {
+    open: Vec<Open<'t, K>>,
+    terminated: bool,
+}
+
+impl<K> Default for Obligations<'_, K> {
+    fn default() -> Self {
+        Obligations {
+            open: Vec::new(),
+            terminated: false,
+        }
+    }
+}
+
+impl<K: Clone> Path for Obligations<'_, K> {
+    fn terminated(&self) -> bool {
+        self.terminated
+    }
+
+    fn terminate(&mut self) {
+        self.terminated = true;
+    }
+
+    fn join(&mut self, other: Self) {
+        union(&mut self.open, other.open, |known, open| {
+            known.node == open.node
+        });
+    }
+}
+
+struct Releases<'a, 't, O: Obligation> {
+    source_code: &'a str,
+    rule: &'a O,
+    /// Acquisitions already reported.
+    reported: HashSet<usize>,
+    hits: Vec<Hit<'t>>,
+}
+
+impl<'t, O: Obligation> Walk<'t> for Releases<'_, 't, O> {
+    type State = Obligations<'t, O::Kind>;
+
+    fn statement(&mut self, node: Node<'t>, state: &mut Self::State) {
+        match node.kind() {
+            "block" | "statement_list" => self.statements(node, state),
+            "labeled_statement" => {
+                if let Some(inner) = node.named_child(1) {
+                    self.statement(inner, state);
+                }
+            }
+            "comment" => {}
+            "return_statement" => {
+                self.effects(node, state);
+                if !state.terminated {
+                    self.report(state, Some(node));
+                }
+                state.terminated = true;
+            }
+            "break_statement" | "continue_statement" | "goto_statement" => {
+                state.terminated = true;
+            }
+            "short_var_declaration" | "assignment_statement" => self.assignment(node, state),
+            "if_statement" => {
+                if let Some(initializer) = node.child_by_field_name("initializer") {
+                    self.statement(initializer, state);
+                }
+                let condition = node.child_by_field_name("condition");
+                if let Some(condition) = condition {
+                    self.effects(condition, state);
+                }
+                let mut then = state.clone();
+                let mut otherwise = state.clone();
+                // The value is nil wherever its error isn't.
+                match condition.and_then(|c| nil_check(c, self.source_code)) {
+                    Some((err, true)) => then.open.retain(|open| open.err.as_ref() != Some(&err)),
+                    Some((err, false)) => otherwise
+                        .open
+                        .retain(|open| open.err.as_ref() != Some(&err)),
+                    None => {}
+                }
+                if let Some(consequence) = node.child_by_field_name("consequence") {
+                    self.statement(consequence, &mut then);
+                }
+                if let Some(alternative) = node.child_by_field_name("alternative") {
+                    self.statement(alternative, &mut otherwise);
+                }
+                *state = merge(vec![then, otherwise]);
+            }
+            "for_statement" => {
+                let mut body = state.clone();
+                if let Some(inner) = node.child_by_field_name("body") {
+                    self.statement(inner, &mut body);
+                }
+                *state = merge(vec![state.clone(), body]);
+            }
+            "expression_switch_statement" | "type_switch_statement" | "select_statement" => {
+                self.switch(node, state);
+            }
+            _ => self.effects(node, state),
+        }
+    }
+}
+
+impl<'t, O: Obligation> Releases<'_, 't, O> {
+    fn assignment(&mut self, node: Node<'t>, state: &mut Obligations<'t, O::Kind>) {
+        self.effects(node, state);
+
+        let (Some(left), Some(right)) = (
+            node.child_by_field_name("left"),
+            node.child_by_field_name("right"),
+        ) else {
+            return;
+        };
+        let mut cursor = left.walk();
+        let names: Vec<&str> = left
+            .named_children(&mut cursor)
+            .map(|name| node_text(name, self.source_code))
+            .collect();
+        for open in &mut state.open {
+            if open.err.as_deref().is_some_and(|err| names.contains(&err)) {
+                open.err = None;
+            }
+        }
+
+        let call = right
+            .named_child(0)
+            .filter(|call| right.named_child_count() == 1 && call.kind() == "call_expression");
+        let Some(call) = call else {
+            return;
+        };
+        let Some(kind) = self.rule.acquires(call, self.source_code) else {
+            return;
+        };
+        let callee = call
+            .child_by_field_name("function")
+            .map(|function| node_text(function, self.source_code))
+            .unwrap_or("")
+            .to_string();
+        match names.first() {
+            Some(&"_") => {
+                let message = self.rule.discarded(&callee);
+                self.hits.push(Hit::new(call).with_message(message));
+            }
+            Some(name) => {
+                let err = names
+                    .last()
+                    .filter(|err| names.len() > 1 && **err != "_")
+                    .map(|err| err.to_string());
+                state.open.push(Open {
+                    name: name.to_string(),
+                    callee,
+                    node: call,
+                    err,
+                    kind,
+                });
+            }
+            None => {}
+        }
+    }
+
+    /// Applies what `node` does to the open values: releasing them, handing
+    /// them on, or panicking.
+    fn effects(&mut self, node: Node<'t>, state: &mut Obligations<'t, O::Kind>) {
+        let (rule, source_code) = (self.rule, self.source_code);
+        let mut calls = Vec::new();
+        collect_calls(node, &mut calls);
+        for call in calls {
+            let function = call.child_by_field_name("function");
+            if function.is_some_and(|f| node_text(f, source_code) == "panic") {
+                state.terminated = true;
+                return;
+            }
+            if let Some(released) = rule.releases(call, source_code) {
+                state.open.retain(|open| rule.handle(open) != released);
+            }
+        }
+
+        // Closures, deferred or not, that release the value.
+        let mut released = Vec::new();
+        visit(node, &mut |inner| {
+            if inner.kind() != "func_literal" {
+                return;
+            }
+            visit(inner, &mut |call| {
+                released.extend(rule.releases(call, source_code));
+            });
+        });
+        state
+            .open
+            .retain(|open| !released.contains(&rule.handle(open)));
+
+        state
+            .open
+            .retain(|open| !hands_on(rule, node, open, source_code));
+    }
+
+    fn report(&mut self, state: &Obligations<'t, O::Kind>, exit: Option<Node<'t>>) {
+        for open in &state.open {
+            if !self.reported.insert(open.node.id()) {
+                continue;
+            }
+            let mut hit = Hit::new(open.node).with_message(self.rule.unreleased(open));
+            if let Some(exit) = exit {
+                hit = hit.with_related(exit, O::RETURNS);
+            }
+            self.hits.push(hit);
+        }
+    }
+}
+
+/// Whether `node` passes the value somewhere that becomes responsible for
+/// it.
+fn hands_on<O: Obligation>(rule: &O, node: Node, open: &Open<O::Kind>, source_code: &str) -> bool {
+    if node.kind() == "func_literal" {
+        return false;
+    }
+    let text = node_text(node, source_code);
+    let names_value = match node.kind() {
+        "identifier" => text == open.name,
+        "selector_expression" => text == rule.handle(open),
+        _ => false,
+    };
+    if names_value {
+        let Some(parent) = node.parent() else {
+            return false;
+        };
+        return match parent.kind() {
+            "literal_element" | "keyed_element" | "send_statement" => true,
+            "expression_list" => parent.parent().is_some_and(|statement| {
+                statement.kind() == "return_statement"
+                    || statement.child_by_field_name("right") == Some(parent)
+            }),
+            "argument_list" => parent
+                .parent()
+                .is_some_and(|call| rule.takes(call, node, source_code)),
+            _ => false,
+        };
+    }
+
+    let mut cursor = node.walk();
+    let children: Vec<Node> = node.named_children(&mut cursor).collect();
+    children
+        .into_iter()
+        .any(|child| hands_on(rule, child, open, source_code))
+}
@@ -0,0 +1,686 @@
+use super::interface::{normalize, types};
+use super::{
+    enclosing_function, list_items, node_text, receiver_type, visit, Check, Hit, RuleOptions,
+};
+use crate::analyzer::Confidence;
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use std::collections::{HashMap, HashSet};
+use tree_sitter::{Node, Parser};
+
+/// Generic code that doesn't need to be generic.
+///
+/// - A type parameter constrained by `any` is reported when every call of
+///   its function in the package instantiates it with the same type, given
+///   explicitly or inferred from the arguments. Exported functions may be
+///   called from other packages, so they aren't reported, and neither is a
+///   function that is also used as a value, or called with arguments whose
+///   types aren't evident.
+/// - A method of a generic type is reported when nothing in it depends on
+///   the type's parameters: its signature doesn't mention them, and its
+///   body only reads fields whose types don't either. Calling it still
+///   takes a value of some instantiation of the type.
+pub struct GoGenerics {
+    issue: GenericIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum GenericIssue {
+    /// An `any` type parameter only ever instantiated with one type.
+    SingleInstantiation,
+    /// A method of a generic type that doesn't use its type parameters.
+    IndependentMethod,
+}
+
+impl GoGenerics {
+    pub fn new(issue: GenericIssue) -> Self {
+        GoGenerics { issue }
+    }
+}
+
+impl Check for GoGenerics {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn needs_package(&self) -> bool {
+        self.issue == GenericIssue::SingleInstantiation
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        _options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let mut parser = Parser::new();
+        let language_set = parser
+            .set_language(&SupportedLanguage::Go.tree_sitter_language())
+            .is_ok();
+        let siblings: Vec<(tree_sitter::Tree, &str)> = package
+            .filter(|_| language_set)
+            .map(|package| {
+                package
+                    .files
+                    .iter()
+                    .filter_map(|file| {
+                        let tree = parser.parse(&file.source_code, None)?;
+                        Some((tree, file.source_code.as_str()))
+                    })
+                    .collect()
+            })
+            .unwrap_or_default();
+        let mut files: Vec<(Node, &str)> = vec![(root, source_code)];
+        files.extend(
+            siblings
+                .iter()
+                .map(|(tree, source)| (tree.root_node(), *source)),
+        );
+        match self.issue {
+            GenericIssue::SingleInstantiation => single_instantiations(root, source_code, &files),
+            GenericIssue::IndependentMethod => independent_methods(root, source_code, &files),
+        }
+    }
+}
+
+/// A generic function declared in the file.
+pub(super) struct Generic {
+    /// `(name, constraint)` of each type parameter.
+    type_parameters: Vec<(String, String)>,
+    /// The type of each parameter, as in [`types`].
+    parameters: Vec<String>,
+    results: Vec<String>,
+}
+
+impl Generic {
+    fn declared(function: Node, source_code: &str) -> Option<Generic> {
+        let list = function.child_by_field_name("type_parameters")?;
+        let mut type_parameters = Vec::new();
+        let mut cursor = list.walk();
+        for declaration in list.named_children(&mut cursor) {
+            let constraint = declaration
+                .child_by_field_name("type")
+                .map(|ty| normalize(node_text(ty, source_code)))
+                .unwrap_or_default();
+            let mut names = declaration.walk();
+            for name in declaration.children_by_field_name("name", &mut names) {
+                type_parameters
+                    .push((node_text(name, source_code).to_string(), constraint.clone()));
+            }
+        }
+        let parameters = function
+            .child_by_field_name("parameters")
+            .map(|parameters| types(parameters, source_code))
+            .unwrap_or_default();
+        let results = match function.child_by_field_name("result") {
+            Some(result) if result.kind() == "parameter_list" => types(result, source_code),
+            Some(result) => vec![normalize(node_text(result, source_code))],
+            None => Vec::new(),
+        };
+        Some(Generic {
+            type_parameters,
+            parameters,
+            results,
+        })
+    }
+
+    fn is_type_parameter(&self, name: &str) -> bool {
+        self.type_parameters.iter().any(|(own, _)| own == name)
+    }
+}
+
+/// The generic functions declared in a file, by name, so calls can be
+/// instantiated with the types their type parameters take.
+#[derive(Default)]
+pub(super) struct Generics {
+    functions: HashMap<String, Generic>,
+}
+
+impl Generics {
+    pub(super) fn collect(root: Node, source_code: &str) -> Self {
+        let mut functions = HashMap::new();
+        let mut cursor = root.walk();
+        for node in root.named_children(&mut cursor) {
+            if node.kind() != "function_declaration" {
+                continue;
+            }
+            let (Some(name), Some(generic)) = (
+                node.child_by_field_name("name"),
+                Generic::declared(node, source_code),
+            ) else {
+                continue;
+            };
+            functions.insert(node_text(name, source_code).to_string(), generic);
+        }
+        Generics { functions }
+    }
+
+    /// The generic function `call` calls, with what its type parameters are
+    /// at the call: the explicit type arguments, or failing those, the
+    /// types of the arguments passed where the parameters use them.
+    pub(super) fn of_call(&self, call: Node, source_code: &str) -> Option<Instance<'_>> {
+        let function = callee(call)?;
+        if function.kind() != "identifier" {
+            return None;
+        }
+        let generic = self.functions.get(node_text(function, source_code))?;
+        let mut bindings = HashMap::new();
+        for ((name, _), argument) in generic
+            .type_parameters
+            .iter()
+            .zip(type_arguments(call, source_code))
+        {
+            bindings.insert(name.clone(), argument);
+        }
+        if bindings.len() < generic.type_parameters.len() {
+            let arguments: Vec<Node> = match call.child_by_field_name("arguments") {
+                Some(list) => {
+                    let mut cursor = list.walk();
+                    list.named_children(&mut cursor)
+                        .filter(|argument| argument.kind() != "comment")
+                        .collect()
+                }
+                None => Vec::new(),
+            };
+            let locals = enclosing_function(call)
+                .map(|function| local_types(function, source_code))
+                .unwrap_or_default();
+            for (position, argument) in arguments.iter().enumerate() {
+                let parameter = generic.parameters.get(position).or_else(|| {
+                    generic
+                        .parameters
+                        .last()
+                        .filter(|last| last.starts_with("..."))
+                });
+                let (Some(parameter), Some(actual)) =
+                    (parameter, argument_type(*argument, source_code, &locals))
+                else {
+                    continue;
+                };
+                let parameter = parameter.trim_start_matches("...");
+                unify(parameter, &actual, generic, &mut bindings);
+            }
+        }
+        Some(Instance { generic, bindings })
+    }
+}
+
+/// A call of a generic function.
+pub(super) struct Instance<'g> {
+    generic: &'g Generic,
+    bindings: HashMap<String, String>,
+}
+
+impl Instance<'_> {
+    /// The type of result `position` at the call, with the type parameters
+    /// that are known replaced.
+    pub(super) fn result(&self, position: usize) -> Option<String> {
+        let ty = self.generic.results.get(position)?;
+        Some(substitute(ty, &self.bindings))
+    }
+
+    /// The constraint of type parameter `name`.
+    pub(super) fn constraint(&self, name: &str) -> Option<&str> {
+        self.generic
+            .type_parameters
+            .iter()
+            .find(|(own, _)| own == name)
+            .map(|(_, constraint)| constraint.as_str())
+    }
+}
+
+/// The function a call calls, without its type arguments: `Map` of both
+/// `Map[int, string](xs, f)` and `Find[User](id)`, which parses as an
+/// index expression.
+pub(crate) fn callee(call: Node) -> Option<Node> {
+    let function = call.child_by_field_name("function")?;
+    match function.kind() {
+        "index_expression" => function.child_by_field_name("operand"),
+        _ => Some(function),
+    }
+}
+
+/// The explicit type arguments of a call.
+fn type_arguments(call: Node, source_code: &str) -> Vec<String> {
+    if let Some(arguments) = call.child_by_field_name("type_arguments") {
+        let mut cursor = arguments.walk();
+        return arguments
+            .named_children(&mut cursor)
+            .map(|argument| normalize(node_text(argument, source_code)))
+            .collect();
+    }
+    let index = call
+        .child_by_field_name("function")
+        .filter(|function| function.kind() == "index_expression")
+        .and_then(|function| function.child_by_field_name("index"));
+    match index {
+        Some(index) => list_items(index)
+            .into_iter()
+            .map(|argument| normalize(node_text(argument, source_code)))
+            .collect(),
+        None => Vec::new(),
+    }
+}
+
+/// The declared types of a function's parameters and of the variables it
+/// declares with a type or an evident value.
+fn local_types(function: Node, source_code: &str) -> HashMap<String, String> {
+    let mut locals = HashMap::new();
+    visit(function, &mut |node| match node.kind() {
+        "parameter_declaration" | "var_spec" => {
+            let Some(ty) = node.child_by_field_name("type") else {
+                return;
+            };
+            let ty = normalize(node_text(ty, source_code));
+            let mut cursor = node.walk();
+            for name in node.children_by_field_name("name", &mut cursor) {
+                locals.insert(node_text(name, source_code).to_string(), ty.clone());
+            }
+        }
+        "short_var_declaration" => {
+            let (Some(left), Some(right)) = (
+                node.child_by_field_name("left"),
+                node.child_by_field_name("right"),
+            ) else {
+                return;
+            };
+            for (name, value) in list_items(left).into_iter().zip(list_items(right)) {
+                if let Some(ty) = argument_type(value, source_code, &HashMap::new()) {
+                    locals.insert(node_text(name, source_code).to_string(), ty);
+                }
+            }
+        }
+        _ => {}
+    });
+    locals
+}
+
+/// The type of an expression when it is evident: a literal, a composite
+/// literal or its address, `new(T)`, or a variable in `locals`.
+fn argument_type(
+    argument: Node,
+    source_code: &str,
+    locals: &HashMap<String, String>,
+) -> Option<String> {
+    match argument.kind() {
+        "interpreted_string_literal" | "raw_string_literal" => Some("string".to_string()),
+        "int_literal" => Some("int".to_string()),
+        "float_literal" => Some("float64".to_string()),
+        "rune_literal" => Some("rune".to_string()),
+        "true" | "false" => Some("bool".to_string()),
+        "composite_literal" => argument
+            .child_by_field_name("type")
+            .map(|ty| normalize(node_text(ty, source_code))),
+        "unary_expression" => {
+            let operand = argument.child_by_field_name("operand")?;
+            let operator = argument.child_by_field_name("operator")?;
+            if node_text(operator, source_code) != "&" || operand.kind() != "composite_literal" {
+                return None;
+            }
+            Some(format!("*{}", argument_type(operand, source_code, locals)?))
+        }
+        "call_expression" => {
+            let function = argument.child_by_field_name("function")?;
+            let ty = argument
+                .child_by_field_name("arguments")
+                .and_then(|arguments| arguments.named_child(0))?;
+            (node_text(function, source_code) == "new")
+                .then(|| format!("*{}", normalize(node_text(ty, source_code))))
+        }
+        "identifier" => locals.get(node_text(argument, source_code)).cloned(),
+        _ => None,
+    }
+}
+
+/// Binds the type parameters of `generic` in `pattern`, a parameter's
+/// type, to the matching parts of `actual`, an argument's.
+fn unify(pattern: &str, actual: &str, generic: &Generic, bindings: &mut HashMap<String, String>) {
+    if generic.is_type_parameter(pattern) {
+        bindings
+            .entry(pattern.to_string())
+            .or_insert_with(|| actual.to_string());
+        return;
+    }
+    for prefix in ["*", "[]", "chan "] {
+        if let (Some(pattern), Some(actual)) =
+            (pattern.strip_prefix(prefix), actual.strip_prefix(prefix))
+        {
+            return unify(pattern, actual, generic, bindings);
+        }
+    }
+    if let (Some((pattern_key, pattern_value)), Some((key, value))) =
+        (map_parts(pattern), map_parts(actual))
+    {
+        unify(pattern_key, key, generic, bindings);
+        unify(pattern_value, value, generic, bindings);
+    }
+}
+
+/// The key and value types of `map[K]V`.
+fn map_parts(ty: &str) -> Option<(&str, &str)> {
+    let rest = ty.strip_prefix("map[")?;
+    let mut depth = 1;
+    for (at, c) in rest.char_indices() {
+        match c {
+            '[' => depth += 1,
+            ']' => {
+                depth -= 1;
+                if depth == 0 {
+                    return Some((&rest[..at], &rest[at + 1..]));
+                }
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+/// `ty` with each type parameter in `bindings` replaced by its type.
+fn substitute(ty: &str, bindings: &HashMap<String, String>) -> String {
+    let mut substituted = String::new();
+    let mut word = String::new();
+    let mut qualified = false;
+    let flush = |word: &mut String, qualified: bool, out: &mut String| {
+        match bindings.get(word.as_str()).filter(|_| !qualified) {
+            Some(binding) => out.push_str(binding),
+            None => out.push_str(word),
+        }
+        word.clear();
+    };
+    for c in ty.chars() {
+        if c.is_alphanumeric() || c == '_' {
+            word.push(c);
+            continue;
+        }
+        flush(&mut word, qualified, &mut substituted);
+        qualified = c == '.';
+        substituted.push(c);
+    }
+    flush(&mut word, qualified, &mut substituted);
+    substituted
+}
+
+fn single_instantiations<'t>(
+    root: Node<'t>,
+    source_code: &str,
+    files: &[(Node, &str)],
+) -> Vec<Hit<'t>> {
+    let generics = Generics::collect(root, source_code);
+    let mut hits = Vec::new();
+    let mut cursor = root.walk();
+    for function in root.named_children(&mut cursor) {
+        if function.kind() != "function_declaration" {
+            continue;
+        }
+        let (Some(name), Some(list)) = (
+            function.child_by_field_name("name"),
+            function.child_by_field_name("type_parameters"),
+        ) else {
+            continue;
+        };
+        let name_text = node_text(name, source_code);
+        let Some(generic) = generics.functions.get(name_text) else {
+            continue;
+        };
+        if name_text.starts_with(char::is_uppercase) {
+            continue;
+        }
+
+        // Every use of the name must be a call whose instantiation is
+        // known, or the function serves some other type too.
+        let mut calls: Vec<HashMap<String, String>> = Vec::new();
+        let mut opaque = false;
+        for (file, file_source) in files {
+            visit(*file, &mut |node| {
+                if node.kind() != "identifier"
+                    || node == name
+                    || node_text(node, file_source) != name_text
+                {
+                    return;
+                }
+                let call = node.parent().and_then(|parent| match parent.kind() {
+                    "call_expression" => Some(parent),
+                    "index_expression" => parent
+                        .parent()
+                        .filter(|call| call.kind() == "call_expression"),
+                    _ => None,
+                });
+                let instance = call
+                    .filter(|call| callee(*call) == Some(node))
+                    .and_then(|call| generics.of_call(call, file_source));
+                match instance {
+                    Some(instance) => calls.push(instance.bindings),
+                    None => opaque = true,
+                }
+            });
+        }
+        if opaque || calls.is_empty() {
+            continue;
+        }
+
+        let mut declarations = list.walk();
+        for declaration in list.named_children(&mut declarations) {
+            let constraint = declaration
+                .child_by_field_name("type")
+                .map(|ty| normalize(node_text(ty, source_code)));
+            if constraint.as_deref() != Some("any") {
+                continue;
+            }
+            let mut names = declaration.walk();
+            for parameter in declaration.children_by_field_name("name", &mut names) {
+                let parameter_name = node_text(parameter, source_code);
+                let types: HashSet<Option<&String>> =
+                    calls.iter().map(|call| call.get(parameter_name)).collect();
+                let only = match types.into_iter().collect::<Vec<_>>().as_slice() {
+                    [Some(only)] => *only,
+                    _ => continue,
+                };
+                // The type parameter may stand for something only another
+                // parameter's instantiation decides.
+                if generic.is_type_parameter(only) {
+                    continue;
+                }
+                let mut hit = Hit::new(parameter).with_message(format!(
+                    "`{}` of `{}` is only ever `{}`; use `{}` instead of a type parameter until another type needs it",
+                    parameter_name, name_text, only, only
+                ));
+                if calls.len() == 1 {
+                    hit = hit.with_confidence(Confidence::Medium);
+                }
+                hits.push(hit);
+            }
+        }
+    }
+    hits
+}
+
+/// A generic type declared in the package: its type parameters, and for a
+/// struct, the fields whose types mention them.
+struct GenericType {
+    parameters: Vec<String>,
+    dependent_fields: HashSet<String>,
+    /// Fields embedded without a name, which make promoted fields and
+    /// methods impossible to follow.
+    embeds: bool,
+}
+
+fn generic_types(files: &[(Node, &str)]) -> HashMap<String, GenericType> {
+    let mut found = HashMap::new();
+    for (root, source_code) in files {
+        visit(*root, &mut |node| {
+            if node.kind() != "type_spec" {
+                return;
+            }
+            let (Some(name), Some(list), Some(ty)) = (
+                node.child_by_field_name("name"),
+                node.child_by_field_name("type_parameters"),
+                node.child_by_field_name("type"),
+            ) else {
+                return;
+            };
+            let mut parameters = Vec::new();
+            let mut cursor = list.walk();
+            for declaration in list.named_children(&mut cursor) {
+                let mut names = declaration.walk();
+                for name in declaration.children_by_field_name("name", &mut names) {
+                    parameters.push(node_text(name, source_code).to_string());
+                }
+            }
+            let mut dependent_fields = HashSet::new();
+            let mut embeds = false;
+            if ty.kind() == "struct_type" {
+                visit(ty, &mut |field| {
+                    if field.kind() != "field_declaration" {
+                        return;
+                    }
+                    let Some(field_type) = field.child_by_field_name("type") else {
+                        return;
+                    };
+                    let dependent = mentions(node_text(field_type, source_code), &parameters);
+                    let mut names = field.walk();
+                    let names: Vec<Node> =
+                        field.children_by_field_name("name", &mut names).collect();
+                    embeds |= names.is_empty();
+                    for field_name in names {
+                        if dependent {
+                            dependent_fields.insert(node_text(field_name, source_code).to_string());
+                        }
+                    }
+                });
+            } else {
+                // Slices, maps and functions of a type parameter are
+                // dependent all through.
+                embeds = true;
+            }
+            found.insert(
+                node_text(name, source_code).to_string(),
+                GenericType {
+                    parameters,
+                    dependent_fields,
+                    embeds,
+                },
+            );
+        });
+    }
+    found
+}
+
+/// Whether the type `ty` names any of `parameters`.
+fn mentions(ty: &str, parameters: &[String]) -> bool {
+    ty.split(|c: char| !(c.is_alphanumeric() || c == '_'))
+        .any(|word| parameters.iter().any(|parameter| parameter == word))
+}
+
+fn independent_methods<'t>(
+    root: Node<'t>,
+    source_code: &str,
+    files: &[(Node, &str)],
+) -> Vec<Hit<'t>> {
+    let generic_types = generic_types(files);
+    let mut hits = Vec::new();
+    let mut cursor = root.walk();
+    for method in root.named_children(&mut cursor) {
+        if method.kind() != "method_declaration" {
+            continue;
+        }
+        let (Some(name), Some(body), Some(ty)) = (
+            method.child_by_field_name("name"),
+            method.child_by_field_name("body"),
+            receiver_type(method, source_code),
+        ) else {
+            continue;
+        };
+        let Some(generic) = generic_types.get(ty).filter(|generic| !generic.embeds) else {
+            continue;
+        };
+        let Some(receiver) = method
+            .child_by_field_name("receiver")
+            .and_then(|receiver| receiver.named_child(0))
+        else {
+            continue;
+        };
+        // The receiver's own names for the type parameters, as in
+        // `func (s *Set[E]) ...`.
+        let receiver_type_text = receiver
+            .child_by_field_name("type")
+            .map(|ty| node_text(ty, source_code))
+            .unwrap_or_default();
+        let parameters: Vec<String> = receiver_type_text
+            .split_once('[')
+            .map(|(_, arguments)| {
+                arguments
+                    .trim_end_matches(']')
+                    .split(',')
+                    .map(|argument| argument.trim().to_string())
+                    .collect()
+            })
+            .unwrap_or_default();
+        if parameters.len() != generic.parameters.len() {
+            continue;
+        }
+        // `_` can't be mentioned.
+        let parameters: Vec<String> = parameters
+            .into_iter()
+            .filter(|parameter| parameter != "_")
+            .collect();
+        let signature_uses = ["parameters", "result"].iter().any(|field| {
+            method
+                .child_by_field_name(field)
+                .is_some_and(|node| mentions(node_text(node, source_code), &parameters))
+        });
+        if signature_uses {
+            continue;
+        }
+        let receiver_name = receiver
+            .child_by_field_name("name")
+            .map(|name| node_text(name, source_code));
+        // The receiver's fields are dependent by the type's declaration,
+        // which may name the parameters differently.
+        let mut uses = mentions(node_text(body, source_code), &parameters);
+        visit(body, &mut |node| {
+            if uses
+                || node.kind() != "identifier"
+                || Some(node_text(node, source_code)) != receiver_name
+            {
+                return;
+            }
+            let field = node
+                .parent()
+                .filter(|parent| {
+                    parent.kind() == "selector_expression"
+                        && parent.child_by_field_name("operand") == Some(node)
+                })
+                .and_then(|selector| {
+                    let field = selector.child_by_field_name("field")?;
+                    // A method call on the receiver may use anything.
+                    let called = selector
+                        .parent()
+                        .is_some_and(|parent| parent.kind() == "call_expression");
+                    (!called).then_some(field)
+                });
+            match field {
+                Some(field) => {
+                    uses |= generic
+                        .dependent_fields
+                        .contains(node_text(field, source_code))
+                }
+                None => uses = true,
+            }
+        });
+        if uses {
+            continue;
+        }
+        hits.push(Hit::new(name).with_message(format!(
+            "`{}` uses nothing that depends on the type parameters of `{}`, yet calling it takes an instantiated `{}`; move it, and the fields it reads, to a non-generic type",
+            node_text(name, source_code),
+            ty,
+            ty
+        )));
+    }
+    hits
+}
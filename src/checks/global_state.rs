@@ -0,0 +1,471 @@
+use super::doc_comment::collect_specs;
+use super::panic::matches_name;
+use super::panic_reachable::package_name;
+use super::{imports, node_text, Check, Hit, OptionKind, RuleOptions};
+use crate::package::Package;
+use crate::taint::matches_pattern;
+use std::collections::HashMap;
+use tree_sitter::Node;
+
+/// State a package sets up or reads as a side effect of being imported:
+///
+/// - Calls in `init` that do I/O (opening files, dialing, `sql.Open`),
+///   register global state (`http.HandleFunc`, `sql.Register`, flag
+///   definitions, `prometheus.MustRegister`) or can panic (`panic`,
+///   `log.Fatal`, `Must*` helpers). Importing the package then does them,
+///   where a failure can't be returned and a test can't avoid them.
+/// - Package-level variables in packages other than `main`, which every
+///   importer shares. Blank variables, sentinel errors such as
+///   `ErrNotFound`, regexps from `regexp.MustCompile` and `//go:embed`
+///   files are left out.
+/// - `flag.Parse`, `os.Getenv`, `os.LookupEnv`, `os.Environ` and `os.Args`
+///   in package-level initializers and `init`, which read the command line
+///   and environment before `main` can set them up.
+///
+/// Calls inside function literals run later and aren't counted, and
+/// `_test.go` files are skipped. Packages are followed through their import
+/// names. Options:
+/// - `functions` (`init`, default `[]`): more calls to report, matched like
+///   taint sources (`"cache.Warm"`, `".Connect"`).
+/// - `allow` (variables, default `[]`): variable names, or prefixes ending
+///   in `*`, that may be global.
+/// - `audit` (default `false`): list all global state, every `init` and
+///   every package-level variable, saying which are allowed.
+///   `compass audit` turns it on.
+pub struct GoGlobalState {
+    issue: GlobalStateIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum GlobalStateIssue {
+    /// I/O, registration and panics in `init`.
+    InitSideEffect,
+    /// Package-level variables of library packages.
+    MutableGlobal,
+    /// Flags and environment read at import.
+    PackageScopeConfig,
+}
+
+impl GoGlobalState {
+    pub fn new(issue: GlobalStateIssue) -> Self {
+        GoGlobalState { issue }
+    }
+}
+
+pub(super) const INIT_OPTIONS: &[(&str, OptionKind)] = &[
+    ("functions", OptionKind::Strings),
+    ("audit", OptionKind::Bool),
+];
+
+pub(super) const VARIABLE_OPTIONS: &[(&str, OptionKind)] =
+    &[("allow", OptionKind::Strings), ("audit", OptionKind::Bool)];
+
+/// Calls that reach outside the process, by import path.
+const IO: &[(&str, &[&str])] = &[
+    (
+        "os",
+        &[
+            "Open",
+            "OpenFile",
+            "Create",
+            "ReadFile",
+            "WriteFile",
+            "ReadDir",
+            "Mkdir",
+            "MkdirAll",
+            "Remove",
+            "RemoveAll",
+            "Stat",
+            "Chdir",
+        ],
+    ),
+    (
+        "io/ioutil",
+        &["ReadFile", "WriteFile", "ReadDir", "ReadAll"],
+    ),
+    (
+        "net/http",
+        &["Get", "Post", "PostForm", "Head", "ListenAndServe"],
+    ),
+    ("net", &["Dial", "DialTimeout", "Listen", "LookupHost"]),
+    ("database/sql", &["Open"]),
+    ("os/exec", &["Command", "LookPath"]),
+];
+
+/// Calls that add to state other packages read, by import path.
+const REGISTRATIONS: &[(&str, &[&str])] = &[
+    ("net/http", &["Handle", "HandleFunc"]),
+    ("database/sql", &["Register"]),
+    (
+        "expvar",
+        &["Publish", "NewInt", "NewFloat", "NewMap", "NewString"],
+    ),
+    (
+        "flag",
+        &[
+            "Bool",
+            "BoolVar",
+            "Duration",
+            "DurationVar",
+            "Float64",
+            "Float64Var",
+            "Func",
+            "Int",
+            "IntVar",
+            "Int64",
+            "Int64Var",
+            "String",
+            "StringVar",
+            "Uint",
+            "UintVar",
+            "Var",
+        ],
+    ),
+    ("encoding/gob", &["Register", "RegisterName"]),
+    ("image", &["RegisterFormat"]),
+    ("mime", &["AddExtensionType"]),
+    (
+        "github.com/prometheus/client_golang/prometheus",
+        &["MustRegister", "Register"],
+    ),
+];
+
+/// Calls that end the program, by import path, besides `panic` and `Must*`.
+const PANICS: &[(&str, &[&str])] = &[
+    (
+        "log",
+        &["Fatal", "Fatalf", "Fatalln", "Panic", "Panicf", "Panicln"],
+    ),
+    ("os", &["Exit"]),
+];
+
+/// Reads of the command line and environment, by import path.
+const CONFIG: &[(&str, &[&str])] = &[
+    ("flag", &["Parse"]),
+    ("os", &["Getenv", "LookupEnv", "Environ", "Args"]),
+];
+
+/// What a package-level initializer or `init` does at import.
+#[derive(Clone, Copy, PartialEq)]
+enum Effect {
+    Io,
+    Registration,
+    Panic,
+    Config,
+}
+
+impl Check for GoGlobalState {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let test_file = package
+            .and_then(|package| package.path.file_name())
+            .is_some_and(|name| name.to_string_lossy().ends_with("_test.go"));
+        let Some(package_name) = package_name(root, source_code).filter(|_| !test_file) else {
+            return Vec::new();
+        };
+        let audit = options.bool("audit").unwrap_or(false);
+        let imports = imports(root, source_code);
+        match self.issue {
+            GlobalStateIssue::InitSideEffect => {
+                let extra = options.string_list("functions").unwrap_or_default();
+                let mut hits = Vec::new();
+                for init in init_functions(root, source_code) {
+                    let before = hits.len();
+                    for (node, callee, effect) in effects(init, source_code, &imports, &extra) {
+                        let message = match effect {
+                            Effect::Io => format!(
+                                "`{}` in `init` does I/O when the package is imported, where a failure can't be returned to the caller",
+                                callee
+                            ),
+                            Effect::Registration => format!(
+                                "`{}` in `init` registers global state as a side effect of importing the package",
+                                callee
+                            ),
+                            Effect::Panic => format!(
+                                "`{}` in `init` can end the program when the package is imported, before `main` runs",
+                                callee
+                            ),
+                            Effect::Config => continue,
+                        };
+                        hits.push(Hit::new(node).with_message(message));
+                    }
+                    if audit && hits.len() == before {
+                        if let Some(name) = init.child_by_field_name("name") {
+                            let message =
+                                format!("`init` runs when package `{}` is imported", package_name);
+                            hits.push(Hit::new(name).with_message(message));
+                        }
+                    }
+                }
+                hits
+            }
+            GlobalStateIssue::MutableGlobal => {
+                let allowed = options.string_list("allow").unwrap_or_default();
+                let mut hits = Vec::new();
+                for (name, value, embedded) in variables(root, source_code) {
+                    let text = node_text(name, source_code);
+                    if text == "_" {
+                        continue;
+                    }
+                    let allowance = if package_name == "main" {
+                        Some("in package `main`")
+                    } else if embedded {
+                        Some("as a `//go:embed` file")
+                    } else if is_sentinel_name(text) {
+                        Some("as a sentinel error")
+                    } else if value
+                        .is_some_and(|value| is_compiled_regexp(value, source_code, &imports))
+                    {
+                        Some("as a compiled regexp")
+                    } else if allowed.iter().any(|pattern| matches_name(text, pattern)) {
+                        Some("by `allow`")
+                    } else {
+                        None
+                    };
+                    if allowance.is_some() && !audit {
+                        continue;
+                    }
+                    let mut message = format!(
+                        "`{}` is a package-level variable, mutable state shared by every user of package `{}`",
+                        text, package_name
+                    );
+                    if let Some(allowance) = allowance {
+                        message = format!("{} (allowed {})", message, allowance);
+                    }
+                    hits.push(Hit::new(name).with_message(message));
+                }
+                hits
+            }
+            GlobalStateIssue::PackageScopeConfig => {
+                let mut hits = Vec::new();
+                let scopes = variables(root, source_code)
+                    .into_iter()
+                    .filter_map(|(_, value, _)| Some((value?, "at package scope")))
+                    .chain(
+                        init_functions(root, source_code)
+                            .into_iter()
+                            .map(|init| (init, "in `init`")),
+                    );
+                for (scope, place) in scopes {
+                    for (node, callee, effect) in effects(scope, source_code, &imports, &[]) {
+                        if effect != Effect::Config {
+                            continue;
+                        }
+                        let message = if callee.ends_with(".Parse") || callee.ends_with(".Args") {
+                            format!(
+                                "`{}` {} reads the command line when the package is imported, before `main` has defined every flag",
+                                callee, place
+                            )
+                        } else {
+                            format!(
+                                "`{}` {} reads the environment when the package is imported, before `main` can set it up",
+                                callee, place
+                            )
+                        };
+                        hits.push(Hit::new(node).with_message(message));
+                    }
+                }
+                // `var a, b = f()` gives both names the one call.
+                hits.sort_by_key(|hit| hit.node.start_byte());
+                hits.dedup_by_key(|hit| hit.node.id());
+                hits
+            }
+        }
+    }
+}
+
+/// The file's `func init()` declarations.
+fn init_functions<'t>(root: Node<'t>, source_code: &str) -> Vec<Node<'t>> {
+    let mut cursor = root.walk();
+    root.named_children(&mut cursor)
+        .filter(|declaration| {
+            declaration.kind() == "function_declaration"
+                && declaration
+                    .child_by_field_name("name")
+                    .is_some_and(|name| node_text(name, source_code) == "init")
+        })
+        .collect()
+}
+
+/// The package-level variables of the file: each name, the value it is
+/// initialized with, if any, and whether a `//go:embed` directive fills it.
+pub(super) fn variables<'t>(
+    root: Node<'t>,
+    source_code: &str,
+) -> Vec<(Node<'t>, Option<Node<'t>>, bool)> {
+    let directive = |node: Node| {
+        node.prev_named_sibling().is_some_and(|comment| {
+            comment.kind() == "comment" && node_text(comment, source_code).starts_with("//go:embed")
+        })
+    };
+    let mut variables = Vec::new();
+    let mut cursor = root.walk();
+    for declaration in root.named_children(&mut cursor) {
+        if declaration.kind() != "var_declaration" {
+            continue;
+        }
+        let mut specs = Vec::new();
+        collect_specs(declaration, &mut specs);
+        for spec in specs {
+            let embedded = directive(spec) || directive(declaration);
+            let values: Vec<Node> = spec
+                .child_by_field_name("value")
+                .map(|list| {
+                    let mut cursor = list.walk();
+                    list.named_children(&mut cursor).collect()
+                })
+                .unwrap_or_default();
+            let mut names = spec.walk();
+            let names: Vec<Node> = spec.children_by_field_name("name", &mut names).collect();
+            for (i, &name) in names.iter().enumerate() {
+                // `var a, b = f()` initializes both from one call.
+                let value = match values.len() {
+                    1 => values.first(),
+                    _ => values.get(i),
+                };
+                variables.push((name, value.copied(), embedded));
+            }
+        }
+    }
+    variables
+}
+
+/// The calls and `os.Args` reads under `node` that have an effect at
+/// import, with the callee as written. Function literals run later and
+/// aren't looked into.
+fn effects<'t>(
+    node: Node<'t>,
+    source_code: &str,
+    imports: &HashMap<String, String>,
+    extra: &[String],
+) -> Vec<(Node<'t>, String, Effect)> {
+    let mut found = Vec::new();
+    let mut cursor = node.walk();
+    let mut stack = vec![node];
+    while let Some(node) = stack.pop() {
+        match node.kind() {
+            "func_literal" => continue,
+            "call_expression" => {
+                if let Some(function) = node.child_by_field_name("function") {
+                    if let Some(effect) = call_effect(function, source_code, imports, extra) {
+                        found.push((node, node_text(function, source_code).to_string(), effect));
+                    }
+                }
+            }
+            "selector_expression" if is_call_function(node) => {}
+            "selector_expression" => {
+                if qualified(node, source_code, imports)
+                    .is_some_and(|(path, name)| path == "os" && name == "Args")
+                {
+                    found.push((
+                        node,
+                        node_text(node, source_code).to_string(),
+                        Effect::Config,
+                    ));
+                }
+            }
+            _ => {}
+        }
+        let children: Vec<Node> = node.named_children(&mut cursor).collect();
+        stack.extend(children.into_iter().rev());
+    }
+    found.sort_by_key(|(node, _, _)| node.start_byte());
+    found
+}
+
+fn call_effect(
+    function: Node,
+    source_code: &str,
+    imports: &HashMap<String, String>,
+    extra: &[String],
+) -> Option<Effect> {
+    let callee = node_text(function, source_code);
+    if extra.iter().any(|pattern| matches_pattern(callee, pattern)) {
+        return Some(Effect::Io);
+    }
+    let name = match function.kind() {
+        "identifier" => callee,
+        "selector_expression" => node_text(function.child_by_field_name("field")?, source_code),
+        _ => return None,
+    };
+    if let Some((path, name)) = qualified(function, source_code, imports) {
+        let listed = |table: &[(&str, &[&str])]| {
+            table
+                .iter()
+                .any(|(package, names)| *package == path && names.contains(&name))
+        };
+        for (table, effect) in [
+            (CONFIG, Effect::Config),
+            (IO, Effect::Io),
+            (REGISTRATIONS, Effect::Registration),
+            (PANICS, Effect::Panic),
+        ] {
+            if listed(table) {
+                return Some(effect);
+            }
+        }
+    }
+    (callee == "panic" || name.starts_with("Must")).then_some(Effect::Panic)
+}
+
+/// A `pkg.Name` reference to an imported package, as its import path and
+/// the name.
+fn qualified<'s>(
+    node: Node,
+    source_code: &'s str,
+    imports: &'s HashMap<String, String>,
+) -> Option<(&'s str, &'s str)> {
+    if node.kind() != "selector_expression" {
+        return None;
+    }
+    let operand = node.child_by_field_name("operand")?;
+    let field = node.child_by_field_name("field")?;
+    if operand.kind() != "identifier" {
+        return None;
+    }
+    let path = imports.get(node_text(operand, source_code))?;
+    Some((path.as_str(), node_text(field, source_code)))
+}
+
+/// Whether `node` is the function a call calls, which the call reports.
+fn is_call_function(node: Node) -> bool {
+    node.parent().is_some_and(|parent| {
+        parent.kind() == "call_expression"
+            && parent
+                .child_by_field_name("function")
+                .is_some_and(|function| function.id() == node.id())
+    })
+}
+
+/// `ErrNotFound` or `errClosed`, Go's names for sentinel errors, but not
+/// `errorCount`.
+fn is_sentinel_name(name: &str) -> bool {
+    ["Err", "err"].iter().any(|prefix| {
+        name.strip_prefix(prefix)
+            .is_some_and(|rest| rest.is_empty() || rest.starts_with(|c: char| c.is_uppercase()))
+    })
+}
+
+/// `regexp.MustCompile(...)` or `regexp.MustCompilePOSIX(...)`.
+fn is_compiled_regexp(value: Node, source_code: &str, imports: &HashMap<String, String>) -> bool {
+    value.kind() == "call_expression"
+        && value
+            .child_by_field_name("function")
+            .and_then(|function| qualified(function, source_code, imports))
+            .is_some_and(|(path, name)| {
+                path == "regexp" && matches!(name, "MustCompile" | "MustCompilePOSIX")
+            })
+}
@@ -0,0 +1,194 @@
+use super::{enclosing_function, node_text, visit, Check, Hit, RuleOptions};
+use tree_sitter::Node;
+
+/// Flags `go` statements whose goroutine has no way to be told to stop.
+///
+/// A goroutine is considered to have a termination path when it calls a
+/// `Done()` method (context or WaitGroup), receives from a channel whose
+/// name reads like a stop signal, or waits on a timeout. Without one, it is
+/// reported if it loops forever or blocks on a channel operation that may
+/// never complete: a receive, or a send on a channel not created buffered in
+/// the launching function. Goroutines that launch a function declared in
+/// another file are skipped.
+pub struct GoGoroutineLeak;
+
+const STOP_SIGNALS: &[&str] = &[
+    "done", "quit", "stop", "exit", "cancel", "shutdown", "closing",
+];
+
+impl Check for GoGoroutineLeak {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+        let mut statements = Vec::new();
+        let mut functions = Vec::new();
+        visit(root, &mut |node| match node.kind() {
+            "go_statement" => statements.push(node),
+            "function_declaration" | "method_declaration" => functions.push(node),
+            _ => {}
+        });
+
+        statements
+            .into_iter()
+            .filter(|statement| {
+                let Some(body) = goroutine_body(*statement, &functions, source_code) else {
+                    return false;
+                };
+                let facts = Facts::collect(body, source_code);
+                if facts.has_stop_signal {
+                    return false;
+                }
+
+                let launcher = enclosing_function(*statement);
+                let blocking_send = facts
+                    .sends
+                    .iter()
+                    .any(|channel| !launcher.is_some_and(|f| is_buffered(f, channel, source_code)));
+                facts.loops_forever || facts.blocking_receive || blocking_send
+            })
+            .map(Hit::new)
+            .collect()
+    }
+}
+
+#[derive(Default)]
+struct Facts {
+    has_stop_signal: bool,
+    loops_forever: bool,
+    blocking_receive: bool,
+    sends: Vec<String>,
+}
+
+impl Facts {
+    fn collect(body: Node, source_code: &str) -> Self {
+        let mut facts = Facts::default();
+        visit(body, &mut |node| match node.kind() {
+            "call_expression" => {
+                let method = node
+                    .child_by_field_name("function")
+                    .and_then(|f| f.child_by_field_name("field"))
+                    .map(|field| node_text(field, source_code));
+                if method == Some("Done") {
+                    facts.has_stop_signal = true;
+                }
+            }
+            "for_statement" => {
+                // `for { ... }` has the body as its only named child.
+                if node.named_child_count() == 1 {
+                    facts.loops_forever = true;
+                }
+            }
+            "unary_expression" => {
+                let is_receive = node
+                    .child_by_field_name("operator")
+                    .is_some_and(|op| node_text(op, source_code) == "<-");
+                let Some(operand) = node.child_by_field_name("operand") else {
+                    return;
+                };
+                if !is_receive {
+                    return;
+                }
+                if is_stop_signal(operand, source_code) {
+                    facts.has_stop_signal = true;
+                } else if !in_select_with_default(node) {
+                    facts.blocking_receive = true;
+                }
+            }
+            "send_statement" => {
+                if in_select_with_default(node) {
+                    return;
+                }
+                if let Some(channel) = node.child_by_field_name("channel") {
+                    facts
+                        .sends
+                        .push(node_text(channel, source_code).to_string());
+                }
+            }
+            _ => {}
+        });
+        facts
+    }
+}
+
+fn goroutine_body<'t>(
+    statement: Node<'t>,
+    functions: &[Node<'t>],
+    source_code: &str,
+) -> Option<Node<'t>> {
+    let call = statement.named_child(0)?;
+    let function = call.child_by_field_name("function")?;
+
+    if function.kind() == "func_literal" {
+        return function.child_by_field_name("body");
+    }
+
+    let name = match function.kind() {
+        "identifier" => node_text(function, source_code),
+        "selector_expression" => node_text(function.child_by_field_name("field")?, source_code),
+        _ => return None,
+    };
+    functions
+        .iter()
+        .find(|f| {
+            f.child_by_field_name("name")
+                .is_some_and(|n| node_text(n, source_code) == name)
+        })
+        .and_then(|f| f.child_by_field_name("body"))
+}
+
+fn is_stop_signal(operand: Node, source_code: &str) -> bool {
+    if operand.kind() == "call_expression" {
+        let method = operand
+            .child_by_field_name("function")
+            .map(|f| node_text(f, source_code))
+            .unwrap_or("");
+        return method.ends_with("Done") || method.ends_with("After");
+    }
+
+    let name = node_text(operand, source_code).to_ascii_lowercase();
+    STOP_SIGNALS.iter().any(|signal| name.contains(signal))
+}
+
+fn in_select_with_default(node: Node) -> bool {
+    let mut current = node.parent();
+    while let Some(ancestor) = current {
+        match ancestor.kind() {
+            "select_statement" => {
+                let mut cursor = ancestor.walk();
+                let has_default = ancestor
+                    .named_children(&mut cursor)
+                    .any(|case| case.kind() == "default_case");
+                return has_default;
+            }
+            "func_literal" | "function_declaration" | "method_declaration" => return false,
+            _ => current = ancestor.parent(),
+        }
+    }
+    false
+}
+
+/// Whether `function` creates `channel` with `make(chan T, n)`.
+fn is_buffered(function: Node, channel: &str, source_code: &str) -> bool {
+    let mut buffered = false;
+    visit(function, &mut |node| {
+        if !matches!(
+            node.kind(),
+            "short_var_declaration" | "assignment_statement"
+        ) {
+            return;
+        }
+        let (Some(left), Some(right)) = (
+            node.child_by_field_name("left"),
+            node.child_by_field_name("right"),
+        ) else {
+            return;
+        };
+        if node_text(left, source_code).trim() != channel {
+            return;
+        }
+
+        let value = node_text(right, source_code).replace(' ', "");
+        if value.starts_with("make(chan") && value.contains(',') {
+            buffered = true;
+        }
+    });
+    buffered
+}
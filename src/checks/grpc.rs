@@ -0,0 +1,128 @@
+use super::unused_import::{import_path, local_name};
+use super::{node_text, visit, Check, Hit, RuleOptions};
+use crate::module::version_at_least;
+use crate::package::Package;
+use tree_sitter::Node;
+
+/// Flags `grpc.Dial` options that don't do what they seem to.
+///
+/// `grpc.WithBlock()` makes `Dial` wait for the first connection, which
+/// reads like a health check but isn't one: the connection can drop right
+/// after, so RPCs have to handle `Unavailable` regardless, and a slow
+/// backend now stalls or fails startup. `WithTimeout`,
+/// `FailOnNonTempDialError` and `WithReturnConnectionError` only apply to a
+/// blocking dial, so without `WithBlock` they do nothing. When the file's
+/// module requires grpc 1.63 or later, the message points to
+/// `grpc.NewClient`, which replaced `Dial` there and never blocks.
+pub struct GoGrpcDial;
+
+const GRPC: &str = "google.golang.org/grpc";
+
+/// Dial options that are ignored unless the dial blocks.
+const BLOCKING_ONLY: &[&str] = &[
+    "WithTimeout",
+    "FailOnNonTempDialError",
+    "WithReturnConnectionError",
+];
+
+impl Check for GoGrpcDial {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        _options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let Some(grpc) = grpc_name(root, source_code) else {
+            return Vec::new();
+        };
+        let new_client = package
+            .and_then(|package| package.module.as_ref())
+            .and_then(|module| module.requirement(GRPC))
+            .filter(|require| version_at_least(&require.version, 1, 63))
+            .map(|require| {
+                format!(
+                    "; grpc {} replaces `Dial` with `{}.NewClient`, which never blocks",
+                    require.version, grpc
+                )
+            })
+            .unwrap_or_default();
+
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if node.kind() != "call_expression" {
+                return;
+            }
+            let is_dial = option_name(node, &grpc, source_code)
+                .is_some_and(|name| name == "Dial" || name == "DialContext");
+            let Some(arguments) = node.child_by_field_name("arguments").filter(|_| is_dial) else {
+                return;
+            };
+
+            let mut options = Vec::new();
+            let mut cursor = arguments.walk();
+            for argument in arguments.named_children(&mut cursor) {
+                if let Some(name) = option_name(argument, &grpc, source_code) {
+                    options.push((name, argument));
+                }
+            }
+            let block = options.iter().find(|(name, _)| *name == "WithBlock");
+            if let Some((_, option)) = block {
+                let message = format!(
+                    "`{0}.WithBlock` only waits for the first connection, which can still fail afterwards, so RPCs must handle `Unavailable` anyway{1}",
+                    grpc, new_client
+                );
+                hits.push(Hit::new(*option).with_message(message));
+                return;
+            }
+            for (name, option) in &options {
+                if BLOCKING_ONLY.contains(name) {
+                    let message = format!(
+                        "`{0}.{1}` has no effect without `{0}.WithBlock`; bound each RPC with a context deadline instead{2}",
+                        grpc, name, new_client
+                    );
+                    hits.push(Hit::new(*option).with_message(message));
+                }
+            }
+        });
+        hits
+    }
+}
+
+/// The name the file imports grpc under.
+fn grpc_name(root: Node, source_code: &str) -> Option<String> {
+    let mut name = None;
+    visit(root, &mut |node| {
+        if node.kind() == "import_spec" && import_path(node, source_code) == Some(GRPC) {
+            name = local_name(node, source_code);
+        }
+    });
+    name
+}
+
+/// `Name` for a call of `grpc.Name(...)`.
+fn option_name<'s>(call: Node, grpc: &str, source_code: &'s str) -> Option<&'s str> {
+    if call.kind() != "call_expression" {
+        return None;
+    }
+    let function = call.child_by_field_name("function")?;
+    if function.kind() != "selector_expression" {
+        return None;
+    }
+    let operand = function.child_by_field_name("operand")?;
+    if node_text(operand, source_code) != grpc {
+        return None;
+    }
+    Some(node_text(
+        function.child_by_field_name("field")?,
+        source_code,
+    ))
+}
@@ -0,0 +1,380 @@
+use super::unused_import::import_path;
+use super::{unknown_option, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::callgraph::CallGraph;
+use crate::package::Package;
+use regex::Regex;
+use std::collections::{HashMap, VecDeque};
+use tree_sitter::Node;
+
+/// Architectural rules about which packages may import which, declared in
+/// config. [`Policy::compile`] reads the options when the config is loaded,
+/// so a bad pattern fails at startup.
+///
+/// An import is reported when it breaks a rule directly, or when it pulls
+/// in a forbidden package through other packages of the module, with the
+/// chain of imports that does. A chain isn't followed through packages the
+/// same rule covers, since their own imports are reported where they are.
+///
+/// Package patterns are import paths as Go tools take them: `...` matches
+/// any string, so `example.com/app/internal/...` covers `internal` and
+/// everything below it, and a leading `./` is relative to the module root.
+///
+/// Options (at least one is required):
+/// - `layers`: patterns from the top layer down. A package belongs to the
+///   first layer it matches and must not import one from a layer above.
+/// - `deny`: `"from -> to"` entries, or a bare `"to"` that no package may
+///   import, e.g. `"./internal/... -> ./cmd/..."`.
+pub struct GoImportPolicy;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("layers", OptionKind::Strings),
+    ("deny", OptionKind::Strings),
+];
+
+/// The `go_import_policy` options, checked for mistakes.
+#[derive(Debug, Clone)]
+pub struct Policy {
+    pub layers: Vec<Pattern>,
+    pub deny: Vec<Denial>,
+}
+
+#[derive(Debug, Clone)]
+pub struct Denial {
+    /// `None` when the entry applies to every package.
+    pub from: Option<Pattern>,
+    pub to: Pattern,
+    /// The entry as written.
+    pub text: String,
+}
+
+#[derive(Debug, Clone)]
+pub struct Pattern {
+    pub text: String,
+    regex: Regex,
+}
+
+impl Pattern {
+    pub(crate) fn compile(text: &str) -> Result<Pattern, String> {
+        let text = text.trim();
+        let valid = !text.is_empty()
+            && !text.contains(char::is_whitespace)
+            && (!text.starts_with('.') || text == "./..." || text.starts_with("./"));
+        if !valid {
+            return Err(format!(
+                "\"{}\" is not a package pattern, e.g. \"example.com/app/internal/...\" or \"./cmd/...\"",
+                text
+            ));
+        }
+        // As `go list` matches: `...` is a wildcard, and a trailing `/...`
+        // also matches the package it follows.
+        let mut regex = regex::escape(text).replace(r"\.\.\.", ".*");
+        if let Some(prefix) = regex.strip_suffix("/.*") {
+            regex = format!("{}(/.*)?", prefix);
+        }
+        Ok(Pattern {
+            text: text.to_string(),
+            regex: Regex::new(&format!("^{}$", regex)).map_err(|e| e.to_string())?,
+        })
+    }
+
+    /// Whether the pattern covers `package`, in the module at `module` when
+    /// the pattern is relative.
+    pub(crate) fn matches(&self, package: &str, module: &str) -> bool {
+        if !self.text.starts_with("./") {
+            return self.regex.is_match(package);
+        }
+        match package.strip_prefix(module) {
+            Some("") => self.regex.is_match("."),
+            Some(rest) if rest.starts_with('/') && !module.is_empty() => {
+                self.regex.is_match(&format!(".{}", rest))
+            }
+            _ => false,
+        }
+    }
+}
+
+impl Policy {
+    pub fn compile(options: &RuleOptions) -> Result<Self, String> {
+        if let Some(error) = options.keys().find_map(|key| unknown_option(OPTIONS, key)) {
+            return Err(error);
+        }
+        let strings = |key: &str| match options.get(key) {
+            None => Ok(Vec::new()),
+            Some(value) => value
+                .as_array()
+                .and_then(|entries| {
+                    entries
+                        .iter()
+                        .map(|entry| entry.as_str().map(str::to_string))
+                        .collect::<Option<Vec<_>>>()
+                })
+                .ok_or(format!("`{}` must be a list of strings", key)),
+        };
+
+        let layers = strings("layers")?
+            .iter()
+            .map(|layer| Pattern::compile(layer))
+            .collect::<Result<Vec<_>, _>>()
+            .map_err(|e| format!("`layers`: {}", e))?;
+        if layers.len() == 1 {
+            return Err("`layers` needs at least two layers, from the top down".to_string());
+        }
+        let mut deny = Vec::new();
+        for entry in strings("deny")? {
+            let parts: Vec<&str> = entry.split("->").collect();
+            let compile = |text| Pattern::compile(text).map_err(|e| format!("`deny`: {}", e));
+            let denial = match parts[..] {
+                [to] => Denial {
+                    from: None,
+                    to: compile(to)?,
+                    text: entry.trim().to_string(),
+                },
+                [from, to] => Denial {
+                    from: Some(compile(from)?),
+                    to: compile(to)?,
+                    text: entry.trim().to_string(),
+                },
+                _ => {
+                    return Err(format!(
+                        "`deny` entries are \"from -> to\" or a bare \"to\", not \"{}\"",
+                        entry
+                    ))
+                }
+            };
+            deny.push(denial);
+        }
+
+        if layers.is_empty() && deny.is_empty() {
+            return Err("declares no policy; set `layers` or `deny`".to_string());
+        }
+        Ok(Policy { layers, deny })
+    }
+
+    fn layer(&self, package: &str, module: &str) -> Option<usize> {
+        self.layers
+            .iter()
+            .position(|layer| layer.matches(package, module))
+    }
+}
+
+/// A rule an importing package is subject to.
+enum Rule<'p> {
+    /// Nothing from a layer above this one.
+    Layer(usize),
+    Deny(&'p Denial),
+}
+
+impl Rule<'_> {
+    fn forbids(&self, policy: &Policy, package: &str, module: &str) -> bool {
+        match self {
+            Rule::Layer(layer) => policy
+                .layer(package, module)
+                .is_some_and(|other| other < *layer),
+            Rule::Deny(denial) => denial.to.matches(package, module),
+        }
+    }
+
+    /// Whether the rule applies to `package` too, so its imports are
+    /// reported there rather than followed.
+    fn covers(&self, policy: &Policy, package: &str, module: &str) -> bool {
+        match self {
+            Rule::Layer(_) => policy.layer(package, module).is_some(),
+            Rule::Deny(denial) => denial
+                .from
+                .as_ref()
+                .is_none_or(|from| from.matches(package, module)),
+        }
+    }
+}
+
+impl Check for GoImportPolicy {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn reads_call_graph(&self) -> bool {
+        true
+    }
+
+    fn needs_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let Ok(policy) = Policy::compile(options) else {
+            return Vec::new();
+        };
+        let Some(package) = package else {
+            return Vec::new();
+        };
+        let (Some(importer), Some(module)) = (package.import_path(), package.module.as_ref())
+        else {
+            return Vec::new();
+        };
+        let module = module.path.as_str();
+        let graph = package.call_graph();
+
+        let mut rules = Vec::new();
+        if let Some(layer) = policy.layer(&importer, module) {
+            rules.push(Rule::Layer(layer));
+        }
+        for denial in &policy.deny {
+            if denial
+                .from
+                .as_ref()
+                .is_none_or(|from| from.matches(&importer, module))
+            {
+                rules.push(Rule::Deny(denial));
+            }
+        }
+        if rules.is_empty() {
+            return Vec::new();
+        }
+
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if node.kind() != "import_spec" {
+                return;
+            }
+            let Some(imported) = import_path(node, source_code) else {
+                return;
+            };
+            for rule in &rules {
+                let Some(chain) = chain(graph.as_deref(), &importer, imported, |package| {
+                    (
+                        rule.forbids(&policy, package, module),
+                        rule.covers(&policy, package, module),
+                    )
+                }) else {
+                    continue;
+                };
+                let target = chain.last().map_or(imported, String::as_str);
+                let why = match rule {
+                    Rule::Layer(layer) => {
+                        let above = policy.layer(target, module).unwrap_or_default();
+                        format!(
+                            "layer `{}` is below layer `{}`",
+                            policy.layers[*layer].text, policy.layers[above].text
+                        )
+                    }
+                    Rule::Deny(denial) => format!("`{}` is denied", denial.text),
+                };
+                let message = match chain.len() {
+                    1 => format!("`{}` must not import `{}`: {}", importer, target, why),
+                    _ => format!(
+                        "`{}` must not depend on `{}`: {}; it comes in through `{}` → {}",
+                        importer,
+                        target,
+                        why,
+                        importer,
+                        chain
+                            .iter()
+                            .map(|package| format!("`{}`", package))
+                            .collect::<Vec<_>>()
+                            .join(" → ")
+                    ),
+                };
+                hits.push(Hit::new(node).with_message(message));
+                break;
+            }
+        });
+        hits
+    }
+}
+
+/// The shortest chain of imports from `imported` to a forbidden package,
+/// starting with `imported` itself, following the module's packages that
+/// the rule doesn't cover. `rule` says whether a package is forbidden and
+/// whether it is covered.
+fn chain(
+    graph: Option<&CallGraph>,
+    importer: &str,
+    imported: &str,
+    rule: impl Fn(&str) -> (bool, bool),
+) -> Option<Vec<String>> {
+    let mut previous: HashMap<&str, &str> = HashMap::new();
+    let mut pending = VecDeque::from([imported]);
+    previous.insert(importer, "");
+    previous.insert(imported, importer);
+    while let Some(package) = pending.pop_front() {
+        let (forbidden, covered) = rule(package);
+        if forbidden {
+            let mut chain = vec![package.to_string()];
+            let mut current = package;
+            while let Some(&before) = previous.get(current).filter(|&&p| p != importer) {
+                chain.push(before.to_string());
+                current = before;
+            }
+            chain.reverse();
+            return Some(chain);
+        }
+        if covered {
+            continue;
+        }
+        let imports = graph.and_then(|graph| graph.imports.get(package));
+        for next in imports.into_iter().flatten() {
+            if !previous.contains_key(next.as_str()) {
+                previous.insert(next, package);
+                pending.push_back(next);
+            }
+        }
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn options(toml: &str) -> RuleOptions {
+        RuleOptions::new(toml::from_str(toml).unwrap())
+    }
+
+    #[test]
+    fn test_patterns_match_like_go_list() {
+        let pattern = |text| Pattern::compile(text).unwrap();
+        let module = "example.com/app";
+        assert!(pattern("./internal/...").matches("example.com/app/internal", module));
+        assert!(pattern("./internal/...").matches("example.com/app/internal/db", module));
+        assert!(!pattern("./internal/...").matches("example.com/app/internals", module));
+        assert!(!pattern("./internal/...").matches("example.com/other/internal", module));
+        assert!(pattern("./...").matches("example.com/app", module));
+        assert!(
+            pattern("example.com/app/.../handlers").matches("example.com/app/api/handlers", module)
+        );
+        assert!(
+            pattern("github.com/legacy/orm/...").matches("github.com/legacy/orm/dialects", module)
+        );
+        assert!(!pattern("github.com/legacy/orm").matches("github.com/legacy/orm/dialects", module));
+    }
+
+    #[test]
+    fn test_policies_are_validated() {
+        let error = |toml| Policy::compile(&options(toml)).err().unwrap();
+        assert_eq!(error(""), "declares no policy; set `layers` or `deny`");
+        assert_eq!(
+            error(r#"layers = ["./handlers/..."]"#),
+            "`layers` needs at least two layers, from the top down"
+        );
+        assert_eq!(
+            error(r#"deny = ["./a -> ./b -> ./c"]"#),
+            "`deny` entries are \"from -> to\" or a bare \"to\", not \"./a -> ./b -> ./c\""
+        );
+        assert_eq!(
+            error(r#"deny = ["../cmd/... -> ./internal"]"#),
+            "`deny`: \"../cmd/...\" is not a package pattern, e.g. \"example.com/app/internal/...\" or \"./cmd/...\""
+        );
+        let policy = Policy::compile(&options(r#"deny = ["github.com/legacy/orm/..."]"#)).unwrap();
+        assert!(policy.deny[0].from.is_none());
+    }
+}
@@ -0,0 +1,699 @@
+use super::panic::matches_name;
+use super::test_coverage::exported_functions;
+use super::{local_name, node_text, receiver_type, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::analyzer::{Confidence, RelatedLocation};
+use crate::fix::{Fix, TextEdit};
+use crate::language::SupportedLanguage;
+use crate::module::doc_comment;
+use crate::package::Package;
+use crate::project::is_generated;
+use tree_sitter::{Node, Parser};
+
+/// Interfaces a type is meant to implement and doesn't, or doesn't check.
+///
+/// Without type information, implementations are recognized by method
+/// names and signatures written out in the package, with parameter names
+/// left out. Types in signatures are compared as written, so `[]byte` and
+/// a named `Bytes` are different.
+///
+/// - A type is meant to implement an interface when its doc comment says
+///   so, as in `// memoryStore implements Store.`, or when the
+///   `implementations` option pairs them. Without a `var _ Store =
+///   (*memoryStore)(nil)` in the package, nothing notices when a method is
+///   renamed or its signature changes until a caller breaks.
+/// - A type almost implements an interface when it has every method the
+///   interface names but the signature of one differs. Interfaces of the
+///   package count, and the ones other packages look for at run time, such
+///   as `fmt.Stringer` and `json.Marshaler`, where a near miss is silently
+///   ignored. For those, a pointer receiver on a type whose other methods
+///   take a value is reported too, since a value of the type then isn't an
+///   implementation, and so is a value receiver on `UnmarshalJSON` and
+///   `UnmarshalText`, which then decode into a copy.
+/// - Exported functions and methods with an `any` or `interface{}`
+///   parameter leave the compiler unable to check what callers pass.
+///
+/// Options:
+/// - `implementations` (assertions, default `[]`): `Type:Interface` pairs,
+///   such as `"*memoryStore:Store"` or `"Handler:http.Handler"`; a `*`
+///   checks the pointer type.
+/// - `allow` (parameters, default `[]`): function and method names, or
+///   prefixes ending in `*`, that may take `any`, added to the built-in
+///   encoders and decoders.
+/// - `allow_variadic` (parameters, default `true`): whether `args ...any`
+///   is allowed, as printf-style and logging wrappers take it.
+pub struct GoInterface {
+    issue: InterfaceIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum InterfaceIssue {
+    /// A type meant to implement an interface without a compile-time
+    /// assertion that it does.
+    MissingAssertion,
+    /// A method that nearly implements an interface.
+    NearMiss,
+    /// An exported function or method taking `any`.
+    AnyParameter,
+}
+
+impl GoInterface {
+    pub fn new(issue: InterfaceIssue) -> Self {
+        GoInterface { issue }
+    }
+}
+
+pub(super) const ASSERTION_OPTIONS: &[(&str, OptionKind)] =
+    &[("implementations", OptionKind::Strings)];
+
+pub(super) const PARAMETER_OPTIONS: &[(&str, OptionKind)] = &[
+    ("allow", OptionKind::Strings),
+    ("allow_variadic", OptionKind::Bool),
+];
+
+/// Functions that take `any` because they handle every type, such as
+/// `sql.Scanner`'s `Scan` and `errors.As` lookalikes.
+const ALLOW_ANY: &[&str] = &["As", "Scan", "Decode*", "Encode*", "Marshal*", "Unmarshal*"];
+
+/// An interface another package checks for at run time, with a type
+/// assertion, rather than by requiring it in a signature.
+struct Known {
+    interface: &'static str,
+    method: &'static str,
+    signature: &'static str,
+    /// What ignores a value that doesn't implement it.
+    ignored_by: &'static str,
+}
+
+const KNOWN: &[Known] = &[
+    Known {
+        interface: "error",
+        method: "Error",
+        signature: "() string",
+        ignored_by: "it isn't an `error`",
+    },
+    Known {
+        interface: "fmt.Stringer",
+        method: "String",
+        signature: "() string",
+        ignored_by: "`fmt` prints it without calling `String`",
+    },
+    Known {
+        interface: "fmt.GoStringer",
+        method: "GoString",
+        signature: "() string",
+        ignored_by: "`%#v` prints it without calling `GoString`",
+    },
+    Known {
+        interface: "json.Marshaler",
+        method: "MarshalJSON",
+        signature: "() ([]byte, error)",
+        ignored_by: "`encoding/json` encodes it without calling `MarshalJSON`",
+    },
+    Known {
+        interface: "json.Unmarshaler",
+        method: "UnmarshalJSON",
+        signature: "([]byte) error",
+        ignored_by: "`encoding/json` decodes it without calling `UnmarshalJSON`",
+    },
+    Known {
+        interface: "encoding.TextMarshaler",
+        method: "MarshalText",
+        signature: "() ([]byte, error)",
+        ignored_by: "encoders such as `encoding/json` don't call `MarshalText`",
+    },
+    Known {
+        interface: "encoding.TextUnmarshaler",
+        method: "UnmarshalText",
+        signature: "([]byte) error",
+        ignored_by: "decoders such as `encoding/json` don't call `UnmarshalText`",
+    },
+];
+
+impl Check for GoInterface {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        self.issue != InterfaceIssue::AnyParameter
+    }
+
+    fn needs_package(&self) -> bool {
+        self.issue == InterfaceIssue::MissingAssertion
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        if self.issue == InterfaceIssue::AnyParameter {
+            return any_parameters(root, source_code, options);
+        }
+        let mut found = Declarations::default();
+        found.collect(root, source_code, None);
+        if let Some(package) = package {
+            let mut parser = Parser::new();
+            if parser
+                .set_language(&SupportedLanguage::Go.tree_sitter_language())
+                .is_ok()
+            {
+                for file in &package.files {
+                    if let Some(tree) = parser.parse(&file.source_code, None) {
+                        let path = file.path.to_string_lossy();
+                        found.collect(tree.root_node(), &file.source_code, Some(&path));
+                    }
+                }
+            }
+        }
+        match self.issue {
+            InterfaceIssue::MissingAssertion => {
+                missing_assertions(root, source_code, options, &found)
+            }
+            _ => near_misses(root, source_code, &found),
+        }
+    }
+}
+
+/// A method as declared in an interface or on a type.
+struct Method {
+    name: String,
+    /// Parameter and result types without names, as in
+    /// `([]byte) (int, error)`.
+    signature: String,
+    /// Its name, in the file it is declared in.
+    location: RelatedLocation,
+}
+
+struct Interface {
+    name: String,
+    methods: Vec<Method>,
+}
+
+/// What the package's files declare.
+#[derive(Default)]
+struct Declarations {
+    interfaces: Vec<Interface>,
+    /// `(receiver type, pointer receiver, method)`.
+    methods: Vec<(String, bool, Method)>,
+    /// `(interface, type)` for each `var _ Interface = ...` assertion.
+    assertions: Vec<(String, String)>,
+}
+
+impl Declarations {
+    /// Adds the declarations of a file: the analyzed one, or `file` of the
+    /// package.
+    fn collect(&mut self, root: Node, source_code: &str, file: Option<&str>) {
+        visit(root, &mut |node| match node.kind() {
+            "type_spec" => {
+                let (Some(name), Some(ty)) = (
+                    node.child_by_field_name("name"),
+                    node.child_by_field_name("type"),
+                ) else {
+                    return;
+                };
+                // A generic interface's signatures name its type
+                // parameters, which implementations instantiate.
+                if ty.kind() != "interface_type"
+                    || node.child_by_field_name("type_parameters").is_some()
+                {
+                    return;
+                }
+                let mut cursor = ty.walk();
+                let methods: Vec<Method> = ty
+                    .named_children(&mut cursor)
+                    .filter(|element| matches!(element.kind(), "method_elem" | "method_spec"))
+                    .filter_map(|element| method(element, source_code))
+                    .map(|mut method| {
+                        method.location.file = file.map(str::to_string);
+                        method
+                    })
+                    .collect();
+                if !methods.is_empty() {
+                    self.interfaces.push(Interface {
+                        name: node_text(name, source_code).to_string(),
+                        methods,
+                    });
+                }
+            }
+            "method_declaration" => {
+                let (Some(receiver), Some(method)) =
+                    (receiver_type(node, source_code), method(node, source_code))
+                else {
+                    return;
+                };
+                self.methods.push((
+                    receiver.to_string(),
+                    is_pointer_receiver(node, source_code),
+                    method,
+                ));
+            }
+            "var_spec" => {
+                if let Some(assertion) = assertion(node, source_code) {
+                    self.assertions.push(assertion);
+                }
+            }
+            _ => {}
+        });
+    }
+
+    fn methods_of<'a>(&'a self, ty: &'a str) -> impl Iterator<Item = (bool, &'a Method)> + 'a {
+        self.methods
+            .iter()
+            .filter(move |(receiver, _, _)| receiver == ty)
+            .map(|(_, pointer, method)| (*pointer, method))
+    }
+
+    fn is_interface(&self, name: &str) -> bool {
+        self.interfaces
+            .iter()
+            .any(|interface| interface.name == name)
+    }
+}
+
+fn method(node: Node, source_code: &str) -> Option<Method> {
+    let name = node.child_by_field_name("name")?;
+    let parameters = node.child_by_field_name("parameters")?;
+    let mut signature = format!("({})", types(parameters, source_code).join(", "));
+    if let Some(result) = node.child_by_field_name("result") {
+        let results = match result.kind() {
+            "parameter_list" => types(result, source_code),
+            _ => vec![normalize(node_text(result, source_code))],
+        };
+        match results.as_slice() {
+            [] => {}
+            [single] => signature.push_str(&format!(" {}", single)),
+            _ => signature.push_str(&format!(" ({})", results.join(", "))),
+        }
+    }
+    Some(Method {
+        name: node_text(name, source_code).to_string(),
+        signature,
+        location: RelatedLocation::new(name, String::new()),
+    })
+}
+
+/// The types of a parameter list, one per parameter, so `(a, b int)` and
+/// `(int, int)` are the same.
+pub(super) fn types(list: Node, source_code: &str) -> Vec<String> {
+    let mut types = Vec::new();
+    let mut cursor = list.walk();
+    for parameter in list.named_children(&mut cursor) {
+        let Some(ty) = parameter.child_by_field_name("type") else {
+            continue;
+        };
+        let ty = normalize(node_text(ty, source_code));
+        match parameter.kind() {
+            "parameter_declaration" => {
+                let mut names = parameter.walk();
+                let count = parameter
+                    .children_by_field_name("name", &mut names)
+                    .count()
+                    .max(1);
+                types.extend(std::iter::repeat_n(ty, count));
+            }
+            "variadic_parameter_declaration" => types.push(format!("...{}", ty)),
+            _ => {}
+        }
+    }
+    types
+}
+
+/// A type as written, with `interface{}` spelled `any` and the spacing
+/// gofmt would give it.
+pub(super) fn normalize(ty: &str) -> String {
+    let ty = ty.split_whitespace().collect::<Vec<_>>().join(" ");
+    if ty == "interface{}" || ty == "interface {}" {
+        return "any".to_string();
+    }
+    ty
+}
+
+fn is_pointer_receiver(method: Node, source_code: &str) -> bool {
+    method
+        .child_by_field_name("receiver")
+        .and_then(|receiver| receiver.named_child(0))
+        .and_then(|parameter| parameter.child_by_field_name("type"))
+        .is_some_and(|ty| {
+            ty.kind() == "pointer_type" || node_text(ty, source_code).starts_with('*')
+        })
+}
+
+/// `(interface, type)` for `var _ Interface = (*T)(nil)`, `T{}`, `&T{}`,
+/// `new(T)` or `T(0)`.
+fn assertion(spec: Node, source_code: &str) -> Option<(String, String)> {
+    let mut cursor = spec.walk();
+    let names: Vec<&str> = spec
+        .children_by_field_name("name", &mut cursor)
+        .map(|name| node_text(name, source_code))
+        .collect();
+    if names != ["_"] {
+        return None;
+    }
+    let interface = normalize(node_text(spec.child_by_field_name("type")?, source_code));
+    let value = node_text(spec.child_by_field_name("value")?, source_code);
+    let value = value.trim_start_matches(['(', '*', '&']);
+    let value = value.strip_prefix("new(").unwrap_or(value);
+    let ty: String = value
+        .trim_start_matches(['(', '*'])
+        .chars()
+        .take_while(|c| c.is_alphanumeric() || *c == '_')
+        .collect();
+    (!ty.is_empty()).then_some((interface, ty))
+}
+
+/// A type that should implement an interface.
+struct Intended {
+    interface: String,
+    /// Whether the pointer type is the implementation.
+    pointer: bool,
+    /// Whether the doc comment says so, rather than the config.
+    documented: bool,
+}
+
+fn missing_assertions<'t>(
+    root: Node<'t>,
+    source_code: &str,
+    options: &RuleOptions,
+    found: &Declarations,
+) -> Vec<Hit<'t>> {
+    let configured: Vec<(String, String)> = options
+        .string_list("implementations")
+        .unwrap_or_default()
+        .iter()
+        .filter_map(|pair| {
+            let (ty, interface) = pair.split_once(':')?;
+            Some((ty.trim().to_string(), interface.trim().to_string()))
+        })
+        .collect();
+
+    let mut hits = Vec::new();
+    let mut cursor = root.walk();
+    for declaration in root.named_children(&mut cursor) {
+        if declaration.kind() != "type_declaration" {
+            continue;
+        }
+        let mut specs = declaration.walk();
+        let specs: Vec<Node> = declaration
+            .named_children(&mut specs)
+            .filter(|spec| spec.kind() == "type_spec")
+            .collect();
+        for spec in &specs {
+            let (Some(name), Some(ty)) = (
+                spec.child_by_field_name("name"),
+                spec.child_by_field_name("type"),
+            ) else {
+                continue;
+            };
+            if ty.kind() == "interface_type"
+                || spec.child_by_field_name("type_parameters").is_some()
+            {
+                continue;
+            }
+            let type_name = node_text(name, source_code);
+            let has_pointer_methods = found.methods_of(type_name).any(|(pointer, _)| pointer);
+            let mut intended: Vec<Intended> = Vec::new();
+            let doc = match specs.len() {
+                1 => doc_comment(declaration, source_code),
+                _ => doc_comment(*spec, source_code),
+            };
+            for interface in documented_interfaces(&doc, found) {
+                intended.push(Intended {
+                    interface,
+                    pointer: has_pointer_methods,
+                    documented: true,
+                });
+            }
+            for (configured_type, interface) in &configured {
+                if configured_type.trim_start_matches('*') == type_name {
+                    intended.push(Intended {
+                        interface: interface.clone(),
+                        pointer: configured_type.starts_with('*'),
+                        documented: false,
+                    });
+                }
+            }
+
+            let mut seen: Vec<&str> = Vec::new();
+            for intent in &intended {
+                let asserted = found
+                    .assertions
+                    .iter()
+                    .any(|(interface, ty)| *interface == intent.interface && ty == type_name);
+                if asserted || seen.contains(&intent.interface.as_str()) {
+                    continue;
+                }
+                seen.push(&intent.interface);
+                let value = match intent.pointer {
+                    true => Some(format!("(*{})(nil)", type_name)),
+                    false => zero_value(type_name, ty, source_code),
+                };
+                let shown = match intent.pointer {
+                    true => format!("*{}", type_name),
+                    false => type_name.to_string(),
+                };
+                let why = match intent.documented {
+                    true => format!("says it implements `{}`", intent.interface),
+                    false => format!("is meant to implement `{}`", intent.interface),
+                };
+                let mut message = format!("`{}` {}, but nothing checks that it does", shown, why);
+                let mut hit = Hit::new(name);
+                // The fix can't add the import a qualified interface needs.
+                let imported = match intent.interface.split_once('.') {
+                    Some((package, _)) => imports(root, source_code, package),
+                    None => true,
+                };
+                let line = value.map(|value| format!("var _ {} = {}", intent.interface, value));
+                if let Some(line) = &line {
+                    message.push_str(&format!("; add `{}`", line));
+                }
+                if let (Some(line), true) = (line, imported) {
+                    hit = hit.with_fix(Fix {
+                        description: format!("Add `{}`", line),
+                        edits: vec![TextEdit {
+                            start_byte: declaration.end_byte(),
+                            end_byte: declaration.end_byte(),
+                            replacement: format!("\n\n{}", line),
+                        }],
+                    });
+                }
+                hits.push(hit.with_message(message));
+            }
+        }
+    }
+    hits
+}
+
+/// Whether the file imports a package under the name `package`.
+fn imports(root: Node, source_code: &str, package: &str) -> bool {
+    let mut found = false;
+    visit(root, &mut |node| {
+        if node.kind() == "import_spec" {
+            found |= local_name(node, source_code).as_deref() == Some(package);
+        }
+    });
+    found
+}
+
+/// The interfaces a doc comment says its type implements, as in "Cache
+/// implements the [Store] interface". Only interfaces of the package and
+/// qualified names count, so "implements retries" isn't taken for one.
+fn documented_interfaces(doc: &str, found: &Declarations) -> Vec<String> {
+    let mut interfaces = Vec::new();
+    let mut rest = doc;
+    while let Some(at) = rest.find("implements ") {
+        rest = &rest[at + "implements ".len()..];
+        let word = rest.trim_start();
+        let word = word.strip_prefix("the ").unwrap_or(word);
+        let name: String = word
+            .trim_start_matches(['[', '`'])
+            .chars()
+            .take_while(|c| c.is_alphanumeric() || *c == '_' || *c == '.')
+            .collect();
+        let name = name.trim_end_matches('.');
+        let qualified = name
+            .split_once('.')
+            .is_some_and(|(package, ty)| !package.is_empty() && ty.starts_with(char::is_uppercase));
+        if (qualified || name == "error" || found.is_interface(name))
+            && !interfaces.iter().any(|known| known == name)
+        {
+            interfaces.push(name.to_string());
+        }
+    }
+    interfaces
+}
+
+/// A value of the named type for an assertion that the value type
+/// implements an interface.
+fn zero_value(name: &str, ty: Node, source_code: &str) -> Option<String> {
+    let value = match ty.kind() {
+        "struct_type" => "{}",
+        "slice_type" | "map_type" | "pointer_type" | "function_type" | "channel_type" => "(nil)",
+        "type_identifier" => match node_text(ty, source_code) {
+            "string" => "(\"\")",
+            "bool" => "(false)",
+            "int" | "int8" | "int16" | "int32" | "int64" | "uint" | "uint8" | "uint16"
+            | "uint32" | "uint64" | "uintptr" | "byte" | "rune" | "float32" | "float64" => "(0)",
+            _ => return None,
+        },
+        _ => return None,
+    };
+    Some(format!("{}{}", name, value))
+}
+
+fn near_misses<'t>(root: Node<'t>, source_code: &str, found: &Declarations) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    let mut cursor = root.walk();
+    for node in root.named_children(&mut cursor) {
+        if node.kind() != "method_declaration" {
+            continue;
+        }
+        let (Some(ty), Some(declared), Some(name)) = (
+            receiver_type(node, source_code),
+            method(node, source_code),
+            node.child_by_field_name("name"),
+        ) else {
+            continue;
+        };
+        let pointer = is_pointer_receiver(node, source_code);
+
+        for interface in &found.interfaces {
+            let Some(wanted) = interface.methods.iter().find(|m| m.name == declared.name) else {
+                continue;
+            };
+            let has_all = interface
+                .methods
+                .iter()
+                .all(|m| found.methods_of(ty).any(|(_, own)| own.name == m.name));
+            if !has_all || wanted.signature == declared.signature || interface.name == ty {
+                continue;
+            }
+            let mut hit = Hit::new(name).with_message(format!(
+                "`{}` has `{}{}`, but `{}` wants `{}{}`, so `{}` doesn't implement it",
+                ty,
+                declared.name,
+                declared.signature,
+                interface.name,
+                wanted.name,
+                wanted.signature,
+                ty
+            ));
+            // One method by the same name is weak evidence the type was
+            // meant to implement the interface.
+            if interface.methods.len() == 1 {
+                hit = hit.with_confidence(Confidence::Medium);
+            }
+            let mut location = wanted.location.clone();
+            location.message = format!("`{}.{}` is declared here", interface.name, wanted.name);
+            hits.push(hit.with_location(location));
+        }
+
+        let Some(known) = KNOWN.iter().find(|known| known.method == declared.name) else {
+            continue;
+        };
+        if known.signature != declared.signature {
+            hits.push(Hit::new(name).with_message(format!(
+                "`{}` has `{}{}`, but `{}` wants `{}{}`, so {}",
+                ty,
+                declared.name,
+                declared.signature,
+                known.interface,
+                known.method,
+                known.signature,
+                known.ignored_by
+            )));
+        } else if known.method.starts_with("Unmarshal") {
+            if pointer {
+                continue;
+            }
+            let receiver = node
+                .child_by_field_name("receiver")
+                .and_then(|receiver| receiver.named_child(0))
+                .and_then(|parameter| parameter.child_by_field_name("type"));
+            let mut hit = Hit::new(name).with_message(format!(
+                "`{}` has a value receiver, so it decodes into a copy of the `{}` and the result is lost; give it a pointer receiver",
+                known.method, ty
+            ));
+            if let Some(receiver) = receiver {
+                hit = hit.with_fix(Fix {
+                    description: "Use a pointer receiver".to_string(),
+                    edits: vec![TextEdit {
+                        start_byte: receiver.start_byte(),
+                        end_byte: receiver.start_byte(),
+                        replacement: "*".to_string(),
+                    }],
+                });
+            }
+            hits.push(hit);
+        } else if pointer {
+            // A type whose methods take values is used as a value, so a
+            // lone pointer receiver is easy to miss.
+            let mut others = found
+                .methods_of(ty)
+                .filter(|(_, own)| own.name != declared.name)
+                .peekable();
+            if others.peek().is_none() || others.any(|(pointer, _)| pointer) {
+                continue;
+            }
+            hits.push(
+                Hit::new(name)
+                    .with_message(format!(
+                        "`{}` has a pointer receiver while the other methods of `{}` take a value, so a `{}` value isn't a `{}` and {}",
+                        known.method, ty, ty, known.interface, known.ignored_by
+                    ))
+                    .with_confidence(Confidence::Medium),
+            );
+        }
+    }
+    hits
+}
+
+fn any_parameters<'t>(root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+    if is_generated(source_code) {
+        return Vec::new();
+    }
+    let mut allowed: Vec<String> = ALLOW_ANY.iter().map(|name| name.to_string()).collect();
+    allowed.extend(options.string_list("allow").unwrap_or_default());
+    let allow_variadic = options.bool("allow_variadic").unwrap_or(true);
+
+    let mut hits = Vec::new();
+    for (function, name) in exported_functions(root, source_code) {
+        if allowed.iter().any(|pattern| matches_name(name, pattern)) {
+            continue;
+        }
+        let Some(parameters) = function.child_by_field_name("parameters") else {
+            continue;
+        };
+        let shown = match receiver_type(function, source_code) {
+            Some(receiver) => format!("{}.{}", receiver, name),
+            None => name.to_string(),
+        };
+        let mut cursor = parameters.walk();
+        for parameter in parameters.named_children(&mut cursor) {
+            let variadic = match parameter.kind() {
+                "parameter_declaration" => false,
+                "variadic_parameter_declaration" => true,
+                _ => continue,
+            };
+            let Some(ty) = parameter.child_by_field_name("type") else {
+                continue;
+            };
+            if normalize(node_text(ty, source_code)) != "any" || (variadic && allow_variadic) {
+                continue;
+            }
+            let named = parameter
+                .child_by_field_name("name")
+                .map(|name| format!("`{}`", node_text(name, source_code)))
+                .unwrap_or_else(|| "a parameter".to_string());
+            hits.push(Hit::new(ty).with_message(format!(
+                "`{}` takes {} as `{}`, so the compiler can't check what callers pass; use a concrete type, an interface with the methods it needs, or a type parameter",
+                shown,
+                named,
+                node_text(ty, source_code)
+            )));
+        }
+    }
+    hits
+}
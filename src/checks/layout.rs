@@ -0,0 +1,414 @@
+use super::{import_path, node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::fix::{Fix, TextEdit};
+use std::collections::HashMap;
+use tree_sitter::Node;
+
+/// Flags named struct types whose fields, in the order written, take more
+/// padding than they would sorted by alignment, using the sizes and
+/// alignments of the gc compiler.
+///
+/// Sizes are known for the predeclared types, pointers, slices, maps,
+/// channels, functions, interfaces, arrays with a literal length, struct
+/// types of the file and a few standard library types such as `time.Time`
+/// and `sync.Mutex`; a struct with a field of any other type isn't
+/// reported. Neither are files that import `"C"`, whose structs often
+/// mirror C layouts, nor structs with a `structs.HostLayout` field.
+///
+/// The fix rewrites the field list in the better order. It isn't offered
+/// for structs with comments, tags, blank fields, more than one field on
+/// a line or fields spanning lines, since their order may be deliberate:
+/// encoding/json and encoding/binary follow it.
+///
+/// Options:
+/// - `min_savings` (default `8`): the fewest bytes per value a better order
+///   has to save.
+/// - `word_size` (default `8`): the size of a pointer, `4` for 32-bit
+///   targets.
+pub struct GoStructLayout;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("min_savings", OptionKind::Count),
+    ("word_size", OptionKind::Count),
+];
+
+/// Sizes of standard library types, in words or in bytes with their
+/// alignment.
+const LIBRARY_TYPES: &[(&str, Size)] = &[
+    ("time.Time", Size::Words(3)),
+    ("time.Duration", Size::Bytes(8, 8)),
+    ("time.Month", Size::Words(1)),
+    ("sync.Mutex", Size::Bytes(8, 4)),
+    ("sync.RWMutex", Size::Bytes(24, 4)),
+    ("sync.Once", Size::Bytes(12, 4)),
+    ("atomic.Bool", Size::Bytes(4, 4)),
+    ("atomic.Int32", Size::Bytes(4, 4)),
+    ("atomic.Uint32", Size::Bytes(4, 4)),
+    ("atomic.Int64", Size::Bytes(8, 8)),
+    ("atomic.Uint64", Size::Bytes(8, 8)),
+    ("atomic.Pointer", Size::Words(1)),
+    ("atomic.Value", Size::Words(2)),
+    ("context.Context", Size::Words(2)),
+    ("unsafe.Pointer", Size::Words(1)),
+];
+
+#[derive(Clone, Copy)]
+enum Size {
+    /// A number of pointer-sized words, aligned to a word.
+    Words(usize),
+    /// Bytes and alignment that don't depend on the word size.
+    Bytes(usize, usize),
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+struct Layout {
+    size: usize,
+    align: usize,
+}
+
+impl Check for GoStructLayout {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        let mut cgo = false;
+        let mut types = HashMap::new();
+        visit(root, &mut |node| match node.kind() {
+            "import_spec" => cgo |= import_path(node, source_code).as_deref() == Some("C"),
+            // Generic types take their layout from their arguments.
+            "type_spec" if node.child_by_field_name("type_parameters").is_none() => {
+                if let (Some(name), Some(ty)) = (
+                    node.child_by_field_name("name"),
+                    node.child_by_field_name("type"),
+                ) {
+                    types.insert(node_text(name, source_code), ty);
+                }
+            }
+            _ => {}
+        });
+        if cgo {
+            return Vec::new();
+        }
+        let sizes = Sizes {
+            source_code,
+            types,
+            word: match options.usize("word_size") {
+                Some(4) => 4,
+                _ => 8,
+            },
+        };
+        let min_savings = options.usize("min_savings").unwrap_or(8).max(1);
+
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if node.kind() != "type_spec" || node.child_by_field_name("type_parameters").is_some() {
+                return;
+            }
+            let (Some(name), Some(ty)) = (
+                node.child_by_field_name("name"),
+                node.child_by_field_name("type"),
+            ) else {
+                return;
+            };
+            if ty.kind() != "struct_type" {
+                return;
+            }
+            let Some(fields) = sizes.fields(ty, 0) else {
+                return;
+            };
+            if fields.iter().any(|field| field.ty == "structs.HostLayout") {
+                return;
+            }
+            let layouts: Vec<Layout> = fields.iter().map(|field| field.layout).collect();
+            let current = struct_layout(&layouts);
+            let order = best_order(&fields);
+            let sorted: Vec<Layout> = order.iter().map(|&i| layouts[i]).collect();
+            let best = struct_layout(&sorted);
+            if current.size < best.size + min_savings {
+                return;
+            }
+
+            let names: Vec<&str> = order.iter().map(|&i| fields[i].name.as_str()).collect();
+            let message = format!(
+                "`{}` takes {} bytes, but {} with its fields in the order {}; reordering them saves {} bytes per value",
+                node_text(name, source_code),
+                current.size,
+                best.size,
+                names.iter().map(|name| format!("`{}`", name)).collect::<Vec<_>>().join(", "),
+                current.size - best.size
+            );
+            let mut hit = Hit::new(name).with_message(message);
+            if let Some(fix) = reorder(ty, &fields, &order, source_code) {
+                hit = hit.with_fix(fix);
+            }
+            hits.push(hit);
+        });
+        hits
+    }
+}
+
+/// A field of a struct, with the declaration it comes from. `a, b int`
+/// is one declaration and two fields.
+struct Field<'t> {
+    name: String,
+    ty: String,
+    layout: Layout,
+    declaration: Node<'t>,
+}
+
+struct Sizes<'t, 's> {
+    source_code: &'s str,
+    /// The non-generic named types of the file.
+    types: HashMap<&'s str, Node<'t>>,
+    word: usize,
+}
+
+impl<'t> Sizes<'t, '_> {
+    fn scaled(&self, size: Size) -> Layout {
+        match size {
+            Size::Words(words) => Layout {
+                size: words * self.word,
+                align: self.word,
+            },
+            Size::Bytes(size, align) => Layout {
+                size,
+                align: align.min(self.word),
+            },
+        }
+    }
+
+    /// The fields of a struct type, or `None` when any field's size isn't
+    /// known. `depth` bounds the chase through named types.
+    fn fields(&self, ty: Node<'t>, depth: usize) -> Option<Vec<Field<'t>>> {
+        let list = ty.named_child(0)?;
+        let mut fields = Vec::new();
+        let mut cursor = list.walk();
+        for declaration in list.named_children(&mut cursor) {
+            if declaration.kind() != "field_declaration" {
+                continue;
+            }
+            let field_type = declaration.child_by_field_name("type")?;
+            let mut names = declaration.walk();
+            let named: Vec<&str> = declaration
+                .children_by_field_name("name", &mut names)
+                .map(|name| node_text(name, self.source_code))
+                .collect();
+            // Depending on the grammar, the `*` of an embedded pointer may
+            // not be part of its type node.
+            let embedded_pointer =
+                named.is_empty() && node_text(declaration, self.source_code).starts_with('*');
+            let layout = if embedded_pointer {
+                self.scaled(Size::Words(1))
+            } else {
+                self.layout(field_type, depth + 1)?
+            };
+            let type_text = node_text(field_type, self.source_code);
+            if named.is_empty() {
+                // An embedded field is named after its type.
+                let embedded = type_text.trim_start_matches('*');
+                let embedded = embedded.rsplit('.').next().unwrap_or(embedded);
+                let pointer = if embedded_pointer && !type_text.starts_with('*') {
+                    "*"
+                } else {
+                    ""
+                };
+                fields.push(Field {
+                    name: embedded.to_string(),
+                    ty: format!("{}{}", pointer, type_text),
+                    layout,
+                    declaration,
+                });
+            }
+            for name in named {
+                fields.push(Field {
+                    name: name.to_string(),
+                    ty: type_text.to_string(),
+                    layout,
+                    declaration,
+                });
+            }
+        }
+        Some(fields)
+    }
+
+    fn layout(&self, ty: Node<'t>, depth: usize) -> Option<Layout> {
+        if depth > 8 {
+            return None;
+        }
+        let word = self.word;
+        let text = node_text(ty, self.source_code);
+        let bytes = |size: usize| Layout {
+            size,
+            align: size.min(word),
+        };
+        match ty.kind() {
+            "pointer_type" | "map_type" | "channel_type" | "function_type" => {
+                Some(self.scaled(Size::Words(1)))
+            }
+            "slice_type" => Some(self.scaled(Size::Words(3))),
+            "interface_type" => Some(self.scaled(Size::Words(2))),
+            "parenthesized_type" => self.layout(ty.named_child(0)?, depth + 1),
+            "array_type" => {
+                let length = ty.child_by_field_name("length")?;
+                let element = self.layout(ty.child_by_field_name("element")?, depth + 1)?;
+                let length: usize = node_text(length, self.source_code).parse().ok()?;
+                Some(Layout {
+                    size: element.size * length,
+                    align: element.align,
+                })
+            }
+            "struct_type" => {
+                let fields = self.fields(ty, depth)?;
+                let layouts: Vec<Layout> = fields.iter().map(|field| field.layout).collect();
+                Some(struct_layout(&layouts))
+            }
+            "qualified_type" => LIBRARY_TYPES
+                .iter()
+                .find(|(name, _)| *name == text)
+                .map(|(_, size)| self.scaled(*size)),
+            "type_identifier" => match text {
+                "bool" | "int8" | "uint8" | "byte" => Some(bytes(1)),
+                "int16" | "uint16" => Some(bytes(2)),
+                "int32" | "uint32" | "rune" | "float32" => Some(bytes(4)),
+                "int64" | "uint64" | "float64" => Some(bytes(8)),
+                "complex64" => Some(Layout { size: 8, align: 4 }),
+                "complex128" => Some(Layout {
+                    size: 16,
+                    align: word,
+                }),
+                "int" | "uint" | "uintptr" => Some(self.scaled(Size::Words(1))),
+                "string" => Some(self.scaled(Size::Words(2))),
+                "error" | "any" => Some(self.scaled(Size::Words(2))),
+                _ => self.layout(*self.types.get(text)?, depth + 1),
+            },
+            _ => None,
+        }
+    }
+}
+
+/// The size and alignment of a struct with fields laid out in order.
+fn struct_layout(fields: &[Layout]) -> Layout {
+    let mut offset = 0;
+    let mut align = 1;
+    for field in fields {
+        offset = round_up(offset, field.align);
+        offset += field.size;
+        align = align.max(field.align);
+    }
+    // A zero-size last field would point past the value, so it gets a
+    // byte of its own.
+    if offset > 0 && fields.last().is_some_and(|field| field.size == 0) {
+        offset += 1;
+    }
+    Layout {
+        size: round_up(offset, align),
+        align,
+    }
+}
+
+fn round_up(offset: usize, align: usize) -> usize {
+    offset.div_ceil(align.max(1)) * align.max(1)
+}
+
+/// The fields' indexes sorted for the least padding: zero-size fields
+/// first, then by alignment and size, both descending. Equal fields keep
+/// their order.
+fn best_order(fields: &[Field]) -> Vec<usize> {
+    let mut order: Vec<usize> = (0..fields.len()).collect();
+    order.sort_by_key(|&i| {
+        let layout = fields[i].layout;
+        (
+            layout.size != 0,
+            std::cmp::Reverse(layout.align),
+            std::cmp::Reverse(layout.size),
+        )
+    });
+    order
+}
+
+/// Rewrites the field list in `order`, one field per line with the types
+/// aligned the way gofmt aligns them.
+fn reorder(ty: Node, fields: &[Field], order: &[usize], source_code: &str) -> Option<Fix> {
+    let list = ty.named_child(0)?;
+    let mut cursor = list.walk();
+    let mut declarations = Vec::new();
+    for child in list.named_children(&mut cursor) {
+        match child.kind() {
+            "field_declaration" => declarations.push(child),
+            _ => return None,
+        }
+    }
+    let mut lines = Vec::new();
+    for declaration in &declarations {
+        let line = declaration.start_position().row;
+        if declaration.child_by_field_name("tag").is_some()
+            || declaration.end_position().row != line
+            || lines.contains(&line)
+        {
+            return None;
+        }
+        lines.push(line);
+    }
+    if fields.iter().any(|field| field.name == "_") {
+        return None;
+    }
+
+    let first = declarations.first()?;
+    let line_start = source_code[..first.start_byte()]
+        .rfind('\n')
+        .map_or(0, |i| i + 1);
+    let indent = &source_code[line_start..first.start_byte()];
+    if !indent.chars().all(char::is_whitespace) {
+        return None;
+    }
+    let close_start = source_code[..list.end_byte() - 1]
+        .rfind('\n')
+        .map_or(0, |i| i + 1);
+    let close_indent = &source_code[close_start..list.end_byte() - 1];
+    if !close_indent.chars().all(char::is_whitespace) {
+        return None;
+    }
+
+    // Named fields, each on its own line, as `(name, type)`; embedded
+    // ones as `(type, "")`.
+    let rows: Vec<(String, String)> = order
+        .iter()
+        .map(|&i| {
+            let field = &fields[i];
+            match field.declaration.child_by_field_name("name") {
+                Some(_) => (field.name.clone(), field.ty.clone()),
+                None => (field.ty.clone(), String::new()),
+            }
+        })
+        .collect();
+    // gofmt aligns the types of consecutive named fields.
+    let mut text = String::from("{\n");
+    let mut start = 0;
+    while start < rows.len() {
+        let end = (start..rows.len())
+            .find(|&i| rows[i].1.is_empty())
+            .map_or(rows.len(), |i| if i == start { i + 1 } else { i });
+        let width = rows[start..end]
+            .iter()
+            .map(|(name, _)| name.len())
+            .max()
+            .unwrap_or(0);
+        for (name, ty) in &rows[start..end] {
+            text.push_str(indent);
+            if ty.is_empty() {
+                text.push_str(name);
+            } else {
+                text.push_str(&format!("{:width$} {}", name, ty, width = width));
+            }
+            text.push('\n');
+        }
+        start = end;
+    }
+    text.push_str(close_indent);
+    text.push('}');
+
+    Some(Fix {
+        description: "Reorder the fields".to_string(),
+        edits: vec![TextEdit {
+            start_byte: list.start_byte(),
+            end_byte: list.end_byte(),
+            replacement: text,
+        }],
+    })
+}
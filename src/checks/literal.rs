@@ -0,0 +1,649 @@
+use super::sql::unescape;
+use super::{import_path, local_name, node_text, visit, Check, Hit, RuleOptions};
+use crate::regexp;
+use crate::template::{self, Options, PREDEFINED_ESCAPERS};
+use std::collections::HashMap;
+use tree_sitter::Node;
+
+/// Checks the little languages Go code writes in string literals, where
+/// the compiler can't: regular expressions, templates and struct tags.
+///
+/// - Patterns passed to `regexp.Compile`, `MustCompile`, `Match`,
+///   `MatchString` and `MatchReader` are checked with [`crate::regexp`], for
+///   what `Compile` would reject and for nested unbounded repetitions. The
+///   POSIX variants take a different syntax and aren't checked.
+/// - Templates passed to `Parse` at the end of a chain from `template.New`
+///   of `text/template` or `html/template` are checked with
+///   [`crate::template`], with the delimiters of a `Delims` in the chain
+///   and the functions of a `Funcs` with a `FuncMap` literal. A `Funcs` of
+///   anything else lets any function name through. In `html/template`,
+///   the predefined `html` and `urlquery` escapers are reported.
+/// - Struct tags are checked against the `key:"value"` syntax
+///   `reflect.StructTag` reads, for keys given twice, and for the `json`
+///   key's options and names: two exported fields with the same name are
+///   both dropped by encoding/json, or the untagged one is when only one
+///   has a tag.
+///
+/// Patterns and templates are followed through the file's constants;
+/// findings point at the string literal. There are no options.
+pub struct GoStringLiteral {
+    issue: LiteralIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum LiteralIssue {
+    /// Patterns `regexp.Compile` rejects.
+    RegexpSyntax,
+    /// Patterns repeating an unbounded repetition, like `(a+)+`.
+    RegexpNested,
+    /// Templates `Parse` rejects.
+    TemplateSyntax,
+    /// `html` and `urlquery` in `html/template` pipelines.
+    TemplateEscaper,
+    /// Struct tags that don't parse or whose `json` names clash.
+    StructTag,
+}
+
+impl GoStringLiteral {
+    pub fn new(issue: LiteralIssue) -> Self {
+        GoStringLiteral { issue }
+    }
+}
+
+/// The `regexp` functions taking a pattern as their first argument.
+const REGEXP_FUNCTIONS: &[&str] = &[
+    "Compile",
+    "MustCompile",
+    "Match",
+    "MatchString",
+    "MatchReader",
+];
+
+/// The `json` options encoding/json and encoding/json/v2 understand. Those
+/// ending in `:` take a value.
+const JSON_OPTIONS: &[&str] = &[
+    "omitempty",
+    "omitzero",
+    "string",
+    "inline",
+    "unknown",
+    "nocase",
+    "strictcase",
+    "case:",
+    "format:",
+];
+
+impl Check for GoStringLiteral {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+        let mut imports = HashMap::new();
+        visit(root, &mut |node| {
+            if node.kind() != "import_spec" {
+                return;
+            }
+            if let (Some(path), Some(local)) = (
+                import_path(node, source_code),
+                local_name(node, source_code),
+            ) {
+                imports.insert(local, path);
+            }
+        });
+        let constants = constants(root, source_code);
+        let literals = Literals {
+            source_code,
+            constants,
+        };
+        match self.issue {
+            LiteralIssue::RegexpSyntax | LiteralIssue::RegexpNested => {
+                regexps(root, &literals, &imports, self.issue)
+            }
+            LiteralIssue::TemplateSyntax | LiteralIssue::TemplateEscaper => {
+                templates(root, &literals, &imports, self.issue)
+            }
+            LiteralIssue::StructTag => struct_tags(root, source_code),
+        }
+    }
+}
+
+/// Constant values by name, from every `const` in the file.
+fn constants<'t>(root: Node<'t>, source_code: &str) -> HashMap<String, Node<'t>> {
+    let mut constants = HashMap::new();
+    visit(root, &mut |node| {
+        if node.kind() != "const_spec" {
+            return;
+        }
+        let Some(values) = node.child_by_field_name("value") else {
+            return;
+        };
+        let mut names = node.walk();
+        let mut cursor = values.walk();
+        let values: Vec<Node> = values.named_children(&mut cursor).collect();
+        for (name, value) in node.children_by_field_name("name", &mut names).zip(values) {
+            constants.insert(node_text(name, source_code).to_string(), value);
+        }
+    });
+    constants
+}
+
+struct Literals<'t, 's> {
+    source_code: &'s str,
+    constants: HashMap<String, Node<'t>>,
+}
+
+impl<'t> Literals<'t, '_> {
+    /// The string literal `node` is or names through constants, with its
+    /// value.
+    fn resolve(&self, node: Node<'t>) -> Option<(Node<'t>, String)> {
+        let mut node = node;
+        for _ in 0..8 {
+            match node.kind() {
+                "interpreted_string_literal" | "raw_string_literal" => {
+                    return Some((node, string_value(node, self.source_code)))
+                }
+                "parenthesized_expression" => node = node.named_child(0)?,
+                "identifier" => node = *self.constants.get(node_text(node, self.source_code))?,
+                _ => return None,
+            }
+        }
+        None
+    }
+}
+
+pub(super) fn string_value(literal: Node, source_code: &str) -> String {
+    let text = node_text(literal, source_code);
+    match literal.kind() {
+        "raw_string_literal" => text.trim_matches('`').to_string(),
+        _ => unescape(text),
+    }
+}
+
+fn arguments(call: Node) -> Vec<Node> {
+    let mut cursor = call.walk();
+    call.child_by_field_name("arguments")
+        .map(|arguments| arguments.named_children(&mut cursor).collect())
+        .unwrap_or_default()
+}
+
+/// The package and name of a call like `regexp.MustCompile(...)`.
+fn selector<'s>(function: Node, source_code: &'s str) -> Option<(&'s str, &'s str)> {
+    if function.kind() != "selector_expression" {
+        return None;
+    }
+    let operand = function.child_by_field_name("operand")?;
+    let field = function.child_by_field_name("field")?;
+    Some((
+        node_text(operand, source_code),
+        node_text(field, source_code),
+    ))
+}
+
+fn regexps<'t>(
+    root: Node<'t>,
+    literals: &Literals<'t, '_>,
+    imports: &HashMap<String, &str>,
+    issue: LiteralIssue,
+) -> Vec<Hit<'t>> {
+    let source_code = literals.source_code;
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "call_expression" {
+            return;
+        }
+        let Some((package, function)) = node
+            .child_by_field_name("function")
+            .and_then(|function| selector(function, source_code))
+        else {
+            return;
+        };
+        if imports.get(package) != Some(&"regexp") || !REGEXP_FUNCTIONS.contains(&function) {
+            return;
+        }
+        let Some((literal, pattern)) = arguments(node)
+            .first()
+            .and_then(|argument| literals.resolve(*argument))
+        else {
+            return;
+        };
+        let callee = format!("regexp.{}", function);
+        match issue {
+            LiteralIssue::RegexpSyntax => {
+                if let Err(problem) = regexp::lint(&pattern) {
+                    hits.push(Hit::new(literal).with_message(format!(
+                        "`{}` can't compile this pattern: {}",
+                        callee, problem
+                    )));
+                }
+            }
+            _ => {
+                if let Some(repetition) = regexp::nested_repetition(&pattern) {
+                    hits.push(Hit::new(literal).with_message(format!(
+                        "`{}` repeats a repetition; the inner one is redundant, and though Go matches it in linear time the pattern backtracks catastrophically in other regex engines",
+                        repetition
+                    )));
+                }
+            }
+        }
+    });
+    hits
+}
+
+/// What a chain like `template.New("x").Delims("[[", "]]").Parse(s)` sets
+/// up before `Parse`.
+struct Chain {
+    html: bool,
+    left: String,
+    right: String,
+    /// `None` when a `Funcs` argument isn't a `FuncMap` literal.
+    functions: Option<Vec<String>>,
+}
+
+/// The setup of the template `receiver` is, when it comes from
+/// `template.New` in this expression.
+fn chain(
+    receiver: Node,
+    source_code: &str,
+    imports: &HashMap<String, &str>,
+    literals: &Literals,
+) -> Option<Chain> {
+    let mut chain = Chain {
+        html: false,
+        left: String::new(),
+        right: String::new(),
+        functions: Some(Vec::new()),
+    };
+    let mut delims = None;
+    let mut current = receiver;
+    loop {
+        if current.kind() != "call_expression" {
+            return None;
+        }
+        let function = current.child_by_field_name("function")?;
+        if function.kind() != "selector_expression" {
+            return None;
+        }
+        let operand = function.child_by_field_name("operand")?;
+        let name = node_text(function.child_by_field_name("field")?, source_code);
+        let args = arguments(current);
+        match name {
+            // The last `Delims` in the chain is the one `Parse` sees.
+            "Delims" if delims.is_none() => {
+                let [left, right] = args.as_slice() else {
+                    return None;
+                };
+                delims = Some((literals.resolve(*left)?.1, literals.resolve(*right)?.1));
+            }
+            "Funcs" => {
+                let names = args.first().and_then(|map| func_map(*map, source_code));
+                match (names, chain.functions.as_mut()) {
+                    (Some(names), Some(functions)) => functions.extend(names),
+                    _ => chain.functions = None,
+                }
+            }
+            "New" if operand.kind() == "identifier" => {
+                let path = imports.get(node_text(operand, source_code))?;
+                chain.html = match *path {
+                    "html/template" => true,
+                    "text/template" => false,
+                    _ => return None,
+                };
+                break;
+            }
+            "Delims" | "Option" => {}
+            _ => return None,
+        }
+        current = operand;
+    }
+    let (left, right) = delims.unwrap_or_default();
+    chain.left = if left.is_empty() {
+        "{{".to_string()
+    } else {
+        left
+    };
+    chain.right = if right.is_empty() {
+        "}}".to_string()
+    } else {
+        right
+    };
+    Some(chain)
+}
+
+/// The keys of a `template.FuncMap{...}` literal.
+fn func_map(map: Node, source_code: &str) -> Option<Vec<String>> {
+    if map.kind() != "composite_literal" {
+        return None;
+    }
+    let body = map.child_by_field_name("body")?;
+    let mut names = Vec::new();
+    let mut cursor = body.walk();
+    for element in body.named_children(&mut cursor) {
+        if element.kind() != "keyed_element" {
+            continue;
+        }
+        let key = element.named_child(0)?;
+        // Newer grammars wrap keys in a `literal_element`.
+        let key = match key.kind() {
+            "literal_element" => key.named_child(0).unwrap_or(key),
+            _ => key,
+        };
+        if !matches!(
+            key.kind(),
+            "interpreted_string_literal" | "raw_string_literal"
+        ) {
+            return None;
+        }
+        names.push(string_value(key, source_code));
+    }
+    Some(names)
+}
+
+fn templates<'t>(
+    root: Node<'t>,
+    literals: &Literals<'t, '_>,
+    imports: &HashMap<String, &str>,
+    issue: LiteralIssue,
+) -> Vec<Hit<'t>> {
+    let source_code = literals.source_code;
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "call_expression" {
+            return;
+        }
+        let Some(function) = node
+            .child_by_field_name("function")
+            .filter(|function| function.kind() == "selector_expression")
+        else {
+            return;
+        };
+        let (Some(receiver), Some(field)) = (
+            function.child_by_field_name("operand"),
+            function.child_by_field_name("field"),
+        ) else {
+            return;
+        };
+        if node_text(field, source_code) != "Parse" {
+            return;
+        }
+        let Some(chain) = chain(receiver, source_code, imports, literals) else {
+            return;
+        };
+        let Some((literal, text)) = arguments(node)
+            .first()
+            .and_then(|argument| literals.resolve(*argument))
+        else {
+            return;
+        };
+        let options = Options {
+            left: &chain.left,
+            right: &chain.right,
+            functions: chain.functions.as_deref(),
+        };
+        let package = if chain.html {
+            "html/template"
+        } else {
+            "text/template"
+        };
+        match (issue, template::parse(&text, &options)) {
+            (LiteralIssue::TemplateSyntax, Err(problem)) => {
+                hits.push(Hit::new(literal).with_message(format!(
+                    "{}'s `Parse` rejects this template: {}",
+                    package, problem
+                )));
+            }
+            (LiteralIssue::TemplateEscaper, Ok(pipelines)) if chain.html => {
+                for pipeline in pipelines {
+                    let last = pipeline.commands.len() - 1;
+                    for (i, command) in pipeline.commands.iter().enumerate() {
+                        let Some(escaper) = command
+                            .as_deref()
+                            .filter(|name| PREDEFINED_ESCAPERS.contains(name))
+                        else {
+                            continue;
+                        };
+                        let message = if i < last {
+                            format!(
+                                "line {}: `{}` in the middle of a pipeline makes html/template's `Execute` fail with ErrPredefinedEscaper; remove it and let the template escape the value for its context",
+                                pipeline.line, escaper
+                            )
+                        } else {
+                            format!(
+                                "line {}: html/template already escapes every pipeline for its context; the `{}` is redundant and escapes for the wrong context inside scripts, styles and attributes",
+                                pipeline.line, escaper
+                            )
+                        };
+                        hits.push(Hit::new(literal).with_message(message));
+                    }
+                }
+            }
+            _ => {}
+        }
+    });
+    hits
+}
+
+/// A field's `json` name, for finding clashes.
+struct JsonName<'t> {
+    name: String,
+    field: String,
+    /// The tag, when the name comes from one.
+    tag: Option<Node<'t>>,
+    node: Node<'t>,
+}
+
+fn struct_tags<'t>(root: Node<'t>, source_code: &str) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "field_declaration_list" {
+            return;
+        }
+        let mut names: Vec<JsonName> = Vec::new();
+        let mut cursor = node.walk();
+        for field in node.named_children(&mut cursor) {
+            if field.kind() != "field_declaration" {
+                continue;
+            }
+            let mut cursor = field.walk();
+            let fields: Vec<Node> = field.children_by_field_name("name", &mut cursor).collect();
+            let tag = field.child_by_field_name("tag");
+            let json = match tag {
+                Some(tag) => match check_tag(field, tag, source_code, &mut hits) {
+                    Some(json) => json,
+                    None => continue,
+                },
+                None => None,
+            };
+            let json_name = json
+                .as_deref()
+                .map(|json| json.split(',').next().unwrap_or(""));
+            if json_name == Some("-") && json.as_deref() == Some("-") {
+                continue;
+            }
+            for name in &fields {
+                let field_name = node_text(*name, source_code);
+                if !field_name.starts_with(|c: char| c.is_uppercase()) {
+                    continue;
+                }
+                let tagged = json_name.filter(|name| !name.is_empty());
+                names.push(JsonName {
+                    name: tagged.unwrap_or(field_name).to_string(),
+                    field: field_name.to_string(),
+                    tag: tag.filter(|_| tagged.is_some()),
+                    node: *name,
+                });
+            }
+            // An embedded field takes its tag's name, or promotes its own
+            // fields, which aren't followed.
+            if let (true, Some(name), Some(tag)) = (
+                fields.is_empty(),
+                json_name.filter(|name| !name.is_empty()),
+                tag,
+            ) {
+                names.push(JsonName {
+                    name: name.to_string(),
+                    field: field
+                        .child_by_field_name("type")
+                        .map(|ty| node_text(ty, source_code).trim_start_matches('*'))
+                        .unwrap_or("")
+                        .to_string(),
+                    tag: Some(tag),
+                    node: tag,
+                });
+            }
+        }
+
+        for (i, later) in names.iter().enumerate() {
+            let Some(earlier) = names[..i].iter().find(|earlier| earlier.name == later.name) else {
+                continue;
+            };
+            let (hit, message) = match (earlier.tag, later.tag) {
+                (Some(_), Some(tag)) => (
+                    tag,
+                    format!(
+                        "fields `{}` and `{}` both have the `json` name `{}`, so encoding/json ignores both",
+                        earlier.field, later.field, later.name
+                    ),
+                ),
+                (Some(tag), None) | (None, Some(tag)) => {
+                    let (tagged, untagged) = if earlier.tag.is_some() {
+                        (earlier, later)
+                    } else {
+                        (later, earlier)
+                    };
+                    (
+                        tag,
+                        format!(
+                            "`{}`'s `json` name `{}` is also the name of field `{}`, which encoding/json then ignores",
+                            tagged.field, tagged.name, untagged.field
+                        ),
+                    )
+                }
+                (None, None) => continue,
+            };
+            let other = if hit == later.tag.unwrap_or(later.node) {
+                earlier
+            } else {
+                later
+            };
+            hits.push(
+                Hit::new(hit)
+                    .with_message(message)
+                    .with_related(other.node, &format!("`{}` is declared here", other.field)),
+            );
+        }
+    });
+    hits
+}
+
+/// Checks one field's tag, reporting what's wrong with it. `None` when the
+/// tag doesn't parse; otherwise its `json` value, if it has one.
+fn check_tag<'t>(
+    field: Node<'t>,
+    tag: Node<'t>,
+    source_code: &str,
+    hits: &mut Vec<Hit<'t>>,
+) -> Option<Option<String>> {
+    let text = string_value(tag, source_code);
+    let pairs = match tag_pairs(&text) {
+        Ok(pairs) => pairs,
+        Err(problem) => {
+            hits.push(Hit::new(tag).with_message(format!(
+                "the struct tag `{}` doesn't parse: {}; `reflect.StructTag.Get` ignores what follows",
+                text, problem
+            )));
+            return None;
+        }
+    };
+    for (i, (key, _)) in pairs.iter().enumerate() {
+        if pairs[..i].iter().any(|(earlier, _)| earlier == key) {
+            hits.push(Hit::new(tag).with_message(format!(
+                "the struct tag repeats the `{}` key; only the first one is read",
+                key
+            )));
+        }
+    }
+    let Some((_, json)) = pairs.into_iter().find(|(key, _)| key == "json") else {
+        return Some(None);
+    };
+
+    let mut parts = json.split(',');
+    let name = parts.next().unwrap_or("");
+    if !name
+        .chars()
+        .all(|c| c.is_alphanumeric() || "!#$%&()*+-./:;<=>?@[]^_{|}~ ".contains(c))
+    {
+        hits.push(Hit::new(tag).with_message(format!(
+            "`{}` isn't a name encoding/json accepts, so it uses the field's name instead",
+            name
+        )));
+    }
+    for option in parts {
+        let known = JSON_OPTIONS
+            .iter()
+            .any(|known| match known.strip_suffix(':') {
+                Some(prefix) => option
+                    .strip_prefix(prefix)
+                    .is_some_and(|value| value.starts_with(':')),
+                None => option == *known,
+            });
+        if !known {
+            hits.push(Hit::new(tag).with_message(format!(
+                "`{}` isn't a `json` option encoding/json knows, so it's ignored",
+                option
+            )));
+        } else if option == "string" {
+            let ty = field
+                .child_by_field_name("type")
+                .map(|ty| node_text(ty, source_code).trim_start_matches('*'))
+                .unwrap_or("");
+            if ["[", "map[", "struct", "func", "chan", "interface"]
+                .iter()
+                .any(|prefix| ty.starts_with(prefix))
+            {
+                hits.push(Hit::new(tag).with_message(format!(
+                    "the `json` option `string` only applies to strings, numbers and booleans, not `{}`",
+                    ty
+                )));
+            }
+        }
+    }
+    Some(Some(json))
+}
+
+/// The `key:"value"` pairs of a struct tag, parsed the way `go vet` checks
+/// them.
+pub(super) fn tag_pairs(tag: &str) -> Result<Vec<(String, String)>, String> {
+    let mut pairs = Vec::new();
+    let mut rest = tag;
+    loop {
+        rest = rest.trim_start_matches(' ');
+        if rest.is_empty() {
+            return Ok(pairs);
+        }
+        let key_length = rest
+            .find(|c: char| c <= ' ' || c == ':' || c == '"' || c == '\u{7f}')
+            .unwrap_or(rest.len());
+        if key_length == 0 {
+            return Err("bad syntax for struct tag key".to_string());
+        }
+        let key = &rest[..key_length];
+        rest = &rest[key_length..];
+        let Some(quoted) = rest.strip_prefix(':') else {
+            return Err("bad syntax for struct tag pair".to_string());
+        };
+        if !quoted.starts_with('"') {
+            return Err("bad syntax for struct tag value".to_string());
+        }
+        let mut escaped = false;
+        let Some(close) = quoted[1..].find(|c: char| {
+            let done = !escaped && c == '"';
+            escaped = !escaped && c == '\\';
+            done
+        }) else {
+            return Err("bad syntax for struct tag value".to_string());
+        };
+        let value = &quoted[1..close + 1];
+        pairs.push((key.to_string(), unescape(&format!("\"{}\"", value))));
+        rest = &quoted[close + 2..];
+        if !rest.is_empty() && !rest.starts_with(' ') {
+            return Err("key:\"value\" pairs not separated by spaces".to_string());
+        }
+    }
+}
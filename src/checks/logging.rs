@@ -0,0 +1,851 @@
+use super::unused_import::{import_path, local_name};
+use super::{node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::taint::matches_pattern;
+use std::collections::{HashMap, HashSet};
+use tree_sitter::Node;
+
+/// Misuse of the structured logging libraries `log/slog`, zap and logrus.
+///
+/// Calls are recognized on the package (`slog.Info`, `logrus.Infof`), on
+/// parameters, fields and variables of the libraries' logger types
+/// (`*slog.Logger`, `*zap.SugaredLogger`, `*logrus.Entry`, ...), and on
+/// loggers returned by constructors such as `zap.NewProduction` or derived
+/// with `With`. Without type information a logger stored anywhere else isn't
+/// recognized.
+///
+/// Every rule takes the same options:
+/// - `libraries` (default `["slog", "zap", "logrus"]`): the libraries to check.
+/// - `printf`: your own printf-style logging functions, as `pattern:N` with
+///   `N` the zero-based index of the format (default `0`).
+/// - `key_values`: your own key/value logging functions, as `pattern:N` with
+///   `N` the index of the first key (default `1`, after the message).
+///
+/// `log_in_loop` also takes `levels` (default `["trace", "debug", "info"]`),
+/// and `log_secret` takes `names`, the substrings that mark a key or value as
+/// secret.
+pub struct GoLogging {
+    issue: LogIssue,
+}
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("libraries", OptionKind::Strings),
+    ("printf", OptionKind::Strings),
+    ("key_values", OptionKind::Strings),
+];
+
+pub(super) const LOOP_OPTIONS: &[(&str, OptionKind)] = &[
+    ("libraries", OptionKind::Strings),
+    ("printf", OptionKind::Strings),
+    ("key_values", OptionKind::Strings),
+    ("levels", OptionKind::Strings),
+];
+
+pub(super) const SECRET_OPTIONS: &[(&str, OptionKind)] = &[
+    ("libraries", OptionKind::Strings),
+    ("printf", OptionKind::Strings),
+    ("key_values", OptionKind::Strings),
+    ("names", OptionKind::Strings),
+];
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum LogIssue {
+    /// Printf-style calls with a non-constant format, and structured calls
+    /// whose message is built with `fmt.Sprintf` or concatenation.
+    FormatString,
+    /// Key/value arguments that don't pair up.
+    KeyValues,
+    /// Logging on every iteration of a loop.
+    HotLoop,
+    /// Keys and values whose names look like secrets.
+    Secret,
+}
+
+impl GoLogging {
+    pub fn new(issue: LogIssue) -> Self {
+        GoLogging { issue }
+    }
+}
+
+#[derive(Clone, Copy, PartialEq)]
+enum Args {
+    /// Alternating keys and values, where a key may also be a whole
+    /// attribute or field.
+    KeyValues,
+    /// Typed attributes or fields, such as `zap.String(...)`.
+    Fields,
+    /// A printf format and its operands.
+    Printf,
+    /// Values printed one after another.
+    Values,
+}
+
+/// A logging method: its name, how it reads its arguments, and where they
+/// start. For key/value and field methods the message is the argument just
+/// before; for printf methods the index is the format's.
+type Method = (&'static str, Args, usize);
+
+struct Library {
+    name: &'static str,
+    path: &'static str,
+    methods: &'static [Method],
+    /// Logger types, whose values log.
+    types: &'static [&'static str],
+    /// Package functions returning a logger.
+    constructors: &'static [&'static str],
+    /// Logger methods returning a logger.
+    derive: &'static [&'static str],
+}
+
+const SLOG: Library = Library {
+    name: "slog",
+    path: "log/slog",
+    methods: &[
+        ("Debug", Args::KeyValues, 1),
+        ("Info", Args::KeyValues, 1),
+        ("Warn", Args::KeyValues, 1),
+        ("Error", Args::KeyValues, 1),
+        ("DebugContext", Args::KeyValues, 2),
+        ("InfoContext", Args::KeyValues, 2),
+        ("WarnContext", Args::KeyValues, 2),
+        ("ErrorContext", Args::KeyValues, 2),
+        ("Log", Args::KeyValues, 3),
+        ("LogAttrs", Args::Fields, 3),
+        ("With", Args::KeyValues, 0),
+    ],
+    types: &["Logger"],
+    constructors: &["New", "Default", "With"],
+    derive: &["With", "WithGroup"],
+};
+
+const ZAP: Library = Library {
+    name: "zap",
+    path: "go.uber.org/zap",
+    methods: &[
+        ("Debug", Args::Fields, 1),
+        ("Info", Args::Fields, 1),
+        ("Warn", Args::Fields, 1),
+        ("Error", Args::Fields, 1),
+        ("DPanic", Args::Fields, 1),
+        ("Panic", Args::Fields, 1),
+        ("Fatal", Args::Fields, 1),
+        ("Debugw", Args::KeyValues, 1),
+        ("Infow", Args::KeyValues, 1),
+        ("Warnw", Args::KeyValues, 1),
+        ("Errorw", Args::KeyValues, 1),
+        ("DPanicw", Args::KeyValues, 1),
+        ("Panicw", Args::KeyValues, 1),
+        ("Fatalw", Args::KeyValues, 1),
+        ("Debugf", Args::Printf, 0),
+        ("Infof", Args::Printf, 0),
+        ("Warnf", Args::Printf, 0),
+        ("Errorf", Args::Printf, 0),
+        ("DPanicf", Args::Printf, 0),
+        ("Panicf", Args::Printf, 0),
+        ("Fatalf", Args::Printf, 0),
+        ("Debugln", Args::Values, 0),
+        ("Infoln", Args::Values, 0),
+        ("Warnln", Args::Values, 0),
+        ("Errorln", Args::Values, 0),
+        // `Logger.With` takes fields, `SugaredLogger.With` keys and values;
+        // both accept fields, so only secrets are checked.
+        ("With", Args::Fields, 0),
+    ],
+    types: &["Logger", "SugaredLogger"],
+    constructors: &[
+        "New",
+        "NewProduction",
+        "NewDevelopment",
+        "NewExample",
+        "NewNop",
+        "L",
+        "S",
+        "Must",
+    ],
+    derive: &[
+        "With",
+        "Named",
+        "Sugar",
+        "Desugar",
+        "WithOptions",
+        "WithLazy",
+    ],
+};
+
+const LOGRUS: Library = Library {
+    name: "logrus",
+    path: "github.com/sirupsen/logrus",
+    methods: &[
+        ("Trace", Args::Values, 0),
+        ("Debug", Args::Values, 0),
+        ("Info", Args::Values, 0),
+        ("Print", Args::Values, 0),
+        ("Warn", Args::Values, 0),
+        ("Warning", Args::Values, 0),
+        ("Error", Args::Values, 0),
+        ("Fatal", Args::Values, 0),
+        ("Panic", Args::Values, 0),
+        ("Tracef", Args::Printf, 0),
+        ("Debugf", Args::Printf, 0),
+        ("Infof", Args::Printf, 0),
+        ("Printf", Args::Printf, 0),
+        ("Warnf", Args::Printf, 0),
+        ("Warningf", Args::Printf, 0),
+        ("Errorf", Args::Printf, 0),
+        ("Fatalf", Args::Printf, 0),
+        ("Panicf", Args::Printf, 0),
+        ("Traceln", Args::Values, 0),
+        ("Debugln", Args::Values, 0),
+        ("Infoln", Args::Values, 0),
+        ("Println", Args::Values, 0),
+        ("Warnln", Args::Values, 0),
+        ("Errorln", Args::Values, 0),
+        ("Logf", Args::Printf, 1),
+        ("WithField", Args::KeyValues, 0),
+        ("WithFields", Args::Fields, 0),
+        ("WithError", Args::Values, 0),
+    ],
+    types: &["Logger", "Entry", "FieldLogger"],
+    constructors: &[
+        "New",
+        "StandardLogger",
+        "NewEntry",
+        "WithField",
+        "WithFields",
+        "WithError",
+        "WithContext",
+        "WithTime",
+    ],
+    derive: &[
+        "WithField",
+        "WithFields",
+        "WithError",
+        "WithContext",
+        "WithTime",
+    ],
+};
+
+const LIBRARIES: &[&Library] = &[&SLOG, &ZAP, &LOGRUS];
+
+const LEVELS: &[&str] = &[
+    "trace", "debug", "info", "print", "warn", "warning", "error", "dpanic", "panic", "fatal",
+];
+
+const DEFAULT_LOOP_LEVELS: &[&str] = &["trace", "debug", "info"];
+
+pub(super) const DEFAULT_SECRET_NAMES: &[&str] = &[
+    "password",
+    "passwd",
+    "secret",
+    "token",
+    "apikey",
+    "privatekey",
+    "credential",
+    "authorization",
+];
+
+/// A recognized logging call.
+struct LogCall<'t> {
+    node: Node<'t>,
+    callee: String,
+    args: Vec<Node<'t>>,
+    kind: Args,
+    index: usize,
+    level: Option<&'static str>,
+}
+
+impl Check for GoLogging {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        let enabled = options.string_list("libraries");
+        let libraries: Vec<(&Library, String)> = imported_libraries(root, source_code)
+            .into_iter()
+            .filter(|(library, _)| {
+                enabled
+                    .as_ref()
+                    .is_none_or(|names| names.iter().any(|name| name == library.name))
+            })
+            .collect();
+        let custom = custom_methods(options);
+        if libraries.is_empty() && custom.is_empty() {
+            return Vec::new();
+        }
+
+        let scope = Scope::collect(root, source_code, &libraries);
+        let mut calls = Vec::new();
+        visit(root, &mut |node| {
+            if let Some(call) = scope.log_call(node, &custom) {
+                calls.push(call);
+            }
+        });
+
+        let mut hits = Vec::new();
+        for call in &calls {
+            match self.issue {
+                LogIssue::FormatString => format_string(call, &scope, &mut hits),
+                LogIssue::KeyValues => key_values(call, &scope, &mut hits),
+                LogIssue::HotLoop => hot_loop(call, options, &mut hits),
+                LogIssue::Secret => secrets(call, &scope, options, &mut hits),
+            }
+        }
+        hits
+    }
+}
+
+fn imported_libraries(root: Node, source_code: &str) -> Vec<(&'static Library, String)> {
+    let mut found = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "import_spec" {
+            return;
+        }
+        let path = import_path(node, source_code);
+        let library = LIBRARIES.iter().find(|library| Some(library.path) == path);
+        if let (Some(library), Some(name)) = (library, local_name(node, source_code)) {
+            found.push((*library, name));
+        }
+    });
+    found
+}
+
+/// `printf` and `key_values` entries, as (pattern, kind, index).
+fn custom_methods(options: &RuleOptions) -> Vec<(String, Args, usize)> {
+    let mut methods = Vec::new();
+    for (key, kind, default) in [
+        ("printf", Args::Printf, 0),
+        ("key_values", Args::KeyValues, 1),
+    ] {
+        for spec in options.string_list(key).unwrap_or_default() {
+            let (pattern, index) = match spec.rsplit_once(':') {
+                Some((pattern, index)) if index.parse::<usize>().is_ok() => {
+                    (pattern.to_string(), index.parse().unwrap_or(default))
+                }
+                _ => (spec, default),
+            };
+            methods.push((pattern, kind, index));
+        }
+    }
+    methods
+}
+
+/// What the file says about its loggers and constants.
+struct Scope<'a> {
+    source_code: &'a str,
+    libraries: &'a [(&'static Library, String)],
+    /// Variables and parameters holding loggers, by library index.
+    loggers: HashMap<String, usize>,
+    /// Struct fields holding loggers.
+    fields: HashMap<String, usize>,
+    constants: HashSet<String>,
+}
+
+impl<'a> Scope<'a> {
+    fn collect(
+        root: Node,
+        source_code: &'a str,
+        libraries: &'a [(&'static Library, String)],
+    ) -> Self {
+        let mut scope = Scope {
+            source_code,
+            libraries,
+            loggers: HashMap::new(),
+            fields: HashMap::new(),
+            constants: HashSet::new(),
+        };
+        visit(root, &mut |node| match node.kind() {
+            "const_spec" => {
+                let mut cursor = node.walk();
+                for name in node.children_by_field_name("name", &mut cursor) {
+                    scope.constants.insert(scope.text(name).to_string());
+                }
+            }
+            "parameter_declaration" | "field_declaration" | "var_spec" => {
+                let library = node
+                    .child_by_field_name("type")
+                    .and_then(|ty| scope.logger_type(ty));
+                let library = library.or_else(|| {
+                    let value = node.child_by_field_name("value")?.named_child(0)?;
+                    scope.logger(value)
+                });
+                let Some(library) = library else {
+                    return;
+                };
+                let target = if node.kind() == "field_declaration" {
+                    &mut scope.fields
+                } else {
+                    &mut scope.loggers
+                };
+                let mut cursor = node.walk();
+                for name in node.children_by_field_name("name", &mut cursor) {
+                    target.insert(node_text(name, source_code).to_string(), library);
+                }
+            }
+            "short_var_declaration" | "assignment_statement" => {
+                let (Some(left), Some(right)) = (
+                    node.child_by_field_name("left"),
+                    node.child_by_field_name("right"),
+                ) else {
+                    return;
+                };
+                let mut cursor = right.walk();
+                let values: Vec<Node> = right.named_children(&mut cursor).collect();
+                let mut cursor = left.walk();
+                let targets: Vec<Node> = left.named_children(&mut cursor).collect();
+                for (index, target) in targets.iter().enumerate() {
+                    // `logger, err := zap.NewProduction()` has one value.
+                    let value = match values.len() {
+                        1 if index == 0 => values[0],
+                        _ => match values.get(index) {
+                            Some(value) if values.len() == targets.len() => *value,
+                            _ => continue,
+                        },
+                    };
+                    let Some(library) = scope.logger(value) else {
+                        continue;
+                    };
+                    match target.kind() {
+                        "identifier" => {
+                            scope
+                                .loggers
+                                .insert(scope.text(*target).to_string(), library);
+                        }
+                        "selector_expression" => {
+                            if let Some(field) = target.child_by_field_name("field") {
+                                scope.fields.insert(scope.text(field).to_string(), library);
+                            }
+                        }
+                        _ => {}
+                    }
+                }
+            }
+            _ => {}
+        });
+        scope
+    }
+
+    fn text(&self, node: Node) -> &'a str {
+        node_text(node, self.source_code)
+    }
+
+    /// The library whose package `name` refers to.
+    fn package(&self, name: &str) -> Option<usize> {
+        self.libraries.iter().position(|(_, local)| local == name)
+    }
+
+    fn logger_type(&self, ty: Node) -> Option<usize> {
+        let text = self.text(ty).trim_start_matches('*');
+        let (package, name) = text.split_once('.')?;
+        let library = self.package(package)?;
+        self.libraries[library]
+            .0
+            .types
+            .contains(&name)
+            .then_some(library)
+    }
+
+    /// The library of the logger `node` evaluates to, if it is one.
+    fn logger(&self, node: Node) -> Option<usize> {
+        match node.kind() {
+            "identifier" => self.loggers.get(self.text(node)).copied(),
+            "parenthesized_expression" => self.logger(node.named_child(0)?),
+            "selector_expression" => {
+                let field = self.text(node.child_by_field_name("field")?);
+                self.fields.get(field).copied()
+            }
+            "call_expression" => {
+                let function = node.child_by_field_name("function")?;
+                if function.kind() != "selector_expression" {
+                    return None;
+                }
+                let operand = function.child_by_field_name("operand")?;
+                let name = self.text(function.child_by_field_name("field")?);
+                if operand.kind() == "identifier" {
+                    if let Some(library) = self.package(self.text(operand)) {
+                        let constructor = self.libraries[library].0.constructors.contains(&name);
+                        return constructor.then_some(library);
+                    }
+                }
+                let library = self.logger(operand)?;
+                self.libraries[library]
+                    .0
+                    .derive
+                    .contains(&name)
+                    .then_some(library)
+            }
+            _ => None,
+        }
+    }
+
+    fn log_call<'t>(
+        &self,
+        node: Node<'t>,
+        custom: &[(String, Args, usize)],
+    ) -> Option<LogCall<'t>> {
+        if node.kind() != "call_expression" {
+            return None;
+        }
+        let function = node.child_by_field_name("function")?;
+        let arguments = node.child_by_field_name("arguments")?;
+        let mut cursor = arguments.walk();
+        let args: Vec<Node<'t>> = arguments
+            .named_children(&mut cursor)
+            .filter(|arg| arg.kind() != "comment")
+            .collect();
+        let callee = self.text(function).to_string();
+
+        if let Some((_, kind, index)) = custom
+            .iter()
+            .find(|(pattern, _, _)| matches_pattern(&callee, pattern))
+        {
+            let name = callee.rsplit('.').next().unwrap_or(&callee);
+            return Some(LogCall {
+                node,
+                level: level(name),
+                callee,
+                args,
+                kind: *kind,
+                index: *index,
+            });
+        }
+
+        if function.kind() != "selector_expression" {
+            return None;
+        }
+        let operand = function.child_by_field_name("operand")?;
+        let name = self.text(function.child_by_field_name("field")?);
+        let library = match operand.kind() {
+            "identifier" => self
+                .package(self.text(operand))
+                .or_else(|| self.logger(operand)),
+            _ => self.logger(operand),
+        }?;
+        let (_, kind, index) = self.libraries[library]
+            .0
+            .methods
+            .iter()
+            .find(|(method, _, _)| *method == name)?;
+        Some(LogCall {
+            node,
+            callee,
+            args,
+            kind: *kind,
+            index: *index,
+            level: level(name),
+        })
+    }
+
+    /// A call such as `slog.String("key", v)` or `zap.Int("key", n)`,
+    /// which stands for a whole key/value pair.
+    fn is_field(&self, node: Node) -> bool {
+        if node.kind() != "call_expression" {
+            return false;
+        }
+        let Some(function) = node.child_by_field_name("function") else {
+            return false;
+        };
+        function.kind() == "selector_expression"
+            && function
+                .child_by_field_name("operand")
+                .is_some_and(|operand| self.package(self.text(operand)).is_some())
+    }
+
+    fn is_constant(&self, node: Node) -> bool {
+        match node.kind() {
+            "interpreted_string_literal" | "raw_string_literal" => true,
+            "identifier" => self.constants.contains(self.text(node)),
+            "parenthesized_expression" => node.named_child(0).is_some_and(|n| self.is_constant(n)),
+            "binary_expression" => {
+                let (Some(left), Some(right)) = (
+                    node.child_by_field_name("left"),
+                    node.child_by_field_name("right"),
+                ) else {
+                    return false;
+                };
+                self.is_constant(left) && self.is_constant(right)
+            }
+            // A constant from another package can't be told from a variable.
+            "selector_expression" => {
+                node.child_by_field_name("operand")
+                    .is_some_and(|operand| operand.kind() == "identifier")
+                    && node
+                        .child_by_field_name("field")
+                        .is_some_and(|field| self.text(field).starts_with(char::is_uppercase))
+            }
+            _ => false,
+        }
+    }
+}
+
+/// The level a method logs at: `Infow`, `InfoContext` and `Infof` all log
+/// at info.
+fn level(method: &str) -> Option<&'static str> {
+    for suffix in ["Context", "ln", "f", "w", ""] {
+        let Some(base) = method.strip_suffix(suffix) else {
+            continue;
+        };
+        let base = base.to_lowercase();
+        if let Some(level) = LEVELS.iter().find(|level| **level == base) {
+            return Some(if *level == "print" { "info" } else { level });
+        }
+    }
+    None
+}
+
+pub(super) fn is_string_literal(node: Node) -> bool {
+    matches!(
+        node.kind(),
+        "interpreted_string_literal" | "raw_string_literal"
+    )
+}
+
+fn format_string<'t>(call: &LogCall<'t>, scope: &Scope, hits: &mut Vec<Hit<'t>>) {
+    match call.kind {
+        Args::Printf => {
+            let Some(format) = call.args.get(call.index) else {
+                return;
+            };
+            // A wrapper passing its own format and arguments through.
+            let forwards = format.kind() == "identifier"
+                && call
+                    .args
+                    .last()
+                    .is_some_and(|arg| arg.kind() == "variadic_argument");
+            if scope.is_constant(*format) || forwards {
+                return;
+            }
+            let message = format!(
+                "the format string of `{}` is not a constant, so a `%` in it is read as a verb; pass it as an argument to a constant format",
+                call.callee
+            );
+            hits.push(Hit::new(*format).with_message(message));
+        }
+        Args::KeyValues | Args::Fields if call.index > 0 => {
+            let Some(message) = call.args.get(call.index - 1) else {
+                return;
+            };
+            let built = match message.kind() {
+                "call_expression" => message
+                    .child_by_field_name("function")
+                    .map(|function| scope.text(function))
+                    .filter(|function| function.starts_with("fmt.Sprint"))
+                    .map(|function| format!("`{}`", function)),
+                "binary_expression" if !scope.is_constant(*message) => {
+                    Some("concatenation".to_string())
+                }
+                _ => None,
+            };
+            if let Some(built) = built {
+                let message_text = format!(
+                    "the message of `{}` is built with {}; keep it constant and pass the values as attributes",
+                    call.callee, built
+                );
+                hits.push(Hit::new(*message).with_message(message_text));
+            }
+        }
+        _ => {}
+    }
+}
+
+fn key_values<'t>(call: &LogCall<'t>, scope: &Scope, hits: &mut Vec<Hit<'t>>) {
+    if call.kind != Args::KeyValues {
+        return;
+    }
+    let mut index = call.index;
+    while let Some(arg) = call.args.get(index) {
+        if scope.is_field(*arg) {
+            index += 1;
+            continue;
+        }
+        if is_string_literal(*arg) || (arg.kind() == "identifier" && scope.is_constant(*arg)) {
+            if index + 1 >= call.args.len() {
+                let message = format!(
+                    "the key {} passed to `{}` has no value",
+                    scope.text(*arg),
+                    call.callee
+                );
+                hits.push(Hit::new(*arg).with_message(message));
+            }
+            index += 2;
+            continue;
+        }
+        if matches!(
+            arg.kind(),
+            "int_literal" | "float_literal" | "true" | "false" | "nil" | "rune_literal"
+        ) {
+            let message = format!(
+                "`{}` is in a key position of `{}` but isn't a string; the keys and values are out of step",
+                scope.text(*arg),
+                call.callee
+            );
+            hits.push(Hit::new(*arg).with_message(message));
+        }
+        // A variable could be a key or a whole attribute; stop guessing.
+        return;
+    }
+}
+
+fn hot_loop<'t>(call: &LogCall<'t>, options: &RuleOptions, hits: &mut Vec<Hit<'t>>) {
+    let Some(level) = call.level else {
+        return;
+    };
+    let levels = options
+        .string_list("levels")
+        .unwrap_or_else(|| DEFAULT_LOOP_LEVELS.iter().map(|s| s.to_string()).collect());
+    if !levels.iter().any(|l| l == level) {
+        return;
+    }
+
+    let mut current = call.node.parent();
+    while let Some(node) = current {
+        match node.kind() {
+            "for_statement" => {
+                // `for { ... }` is a worker or event loop, logging per event.
+                if node.named_child_count() > 1 {
+                    let message = format!(
+                        "`{}` logs at {} level on every iteration of the loop; log a summary after it, or sample",
+                        call.callee, level
+                    );
+                    hits.push(Hit::new(call.node).with_message(message));
+                }
+                return;
+            }
+            "if_statement"
+            | "expression_switch_statement"
+            | "type_switch_statement"
+            | "select_statement"
+            | "func_literal"
+            | "function_declaration"
+            | "method_declaration" => return,
+            _ => {}
+        }
+        current = node.parent();
+    }
+}
+
+fn secrets<'t>(call: &LogCall<'t>, scope: &Scope, options: &RuleOptions, hits: &mut Vec<Hit<'t>>) {
+    let names: Vec<String> = options
+        .string_list("names")
+        .unwrap_or_else(|| DEFAULT_SECRET_NAMES.iter().map(|s| s.to_string()).collect())
+        .iter()
+        .map(|name| normalize(name))
+        .collect();
+    let mut report = |node: Node<'t>, name: &str| {
+        let normalized = normalize(name);
+        let secret = names
+            .iter()
+            .any(|secret| normalized.contains(secret.as_str()));
+        if secret {
+            let message = format!(
+                "`{}` looks like a secret and is written to the log by `{}`",
+                name, call.callee
+            );
+            hits.push(Hit::new(node).with_message(message));
+        }
+        secret
+    };
+
+    // Everything from the first key, field or operand on is logged.
+    let start = match call.kind {
+        Args::Printf => call.index + 1,
+        _ => call.index,
+    };
+    let mut index = start;
+    while let Some(arg) = call.args.get(index) {
+        index += 1;
+        if call.kind == Args::KeyValues && is_string_literal(*arg) {
+            let value = call.args.get(index).copied();
+            index += 1;
+            if value.is_some_and(|value| is_transformed(value, scope)) {
+                continue;
+            }
+            if !report(*arg, unquote(scope.text(*arg))) {
+                if let Some(value) = value {
+                    secret_values(value, scope, &mut report);
+                }
+            }
+            continue;
+        }
+        secret_values(*arg, scope, &mut report);
+    }
+}
+
+/// A value passed through a call other than an attribute constructor,
+/// which may well redact it.
+fn is_transformed(node: Node, scope: &Scope) -> bool {
+    node.kind() == "call_expression" && !scope.is_field(node)
+}
+
+pub(super) fn unquote(literal: &str) -> &str {
+    literal.trim_matches(|c| c == '"' || c == '`')
+}
+
+/// Reports the names a logged value comes from: variables, fields, the
+/// keys of attributes and `logrus.Fields` literals. Other calls are assumed
+/// to transform the value, e.g. by redacting it.
+fn secret_values<'t>(
+    node: Node<'t>,
+    scope: &Scope,
+    report: &mut dyn FnMut(Node<'t>, &str) -> bool,
+) {
+    match node.kind() {
+        "identifier" => {
+            report(node, scope.text(node));
+        }
+        "selector_expression" => {
+            if let Some(field) = node.child_by_field_name("field") {
+                report(node, scope.text(field));
+            }
+        }
+        "unary_expression" | "parenthesized_expression" => {
+            if let Some(inner) = node.child_by_field_name("operand").or(node.named_child(0)) {
+                secret_values(inner, scope, report);
+            }
+        }
+        "call_expression" if scope.is_field(node) => {
+            let Some(arguments) = node.child_by_field_name("arguments") else {
+                return;
+            };
+            let mut cursor = arguments.walk();
+            let args: Vec<Node> = arguments.named_children(&mut cursor).collect();
+            let (key, values) = match args.split_first() {
+                Some((key, values)) if is_string_literal(*key) => (Some(*key), values),
+                _ => (None, &args[..]),
+            };
+            if values.iter().any(|value| is_transformed(*value, scope)) {
+                return;
+            }
+            if key.is_some_and(|key| report(key, unquote(scope.text(key)))) {
+                return;
+            }
+            for value in values {
+                secret_values(*value, scope, report);
+            }
+        }
+        "composite_literal" => {
+            let Some(body) = node.child_by_field_name("body") else {
+                return;
+            };
+            visit(body, &mut |element| {
+                if element.kind() != "keyed_element" {
+                    return;
+                }
+                let Some(key) = element.named_child(0) else {
+                    return;
+                };
+                // Newer grammars wrap keys in a `literal_element`.
+                let key = match key.kind() {
+                    "literal_element" => key.named_child(0).unwrap_or(key),
+                    _ => key,
+                };
+                if is_string_literal(key) {
+                    report(key, unquote(scope.text(key)));
+                }
+            });
+        }
+        _ => {}
+    }
+}
+
+pub(super) fn normalize(name: &str) -> String {
+    name.chars()
+        .filter(|c| *c != '_' && *c != '-')
+        .flat_map(char::to_lowercase)
+        .collect()
+}
@@ -0,0 +1,355 @@
+use super::{list_items, node_text, visit, Check, Hit, RuleOptions};
+use crate::analyzer::Confidence;
+use crate::fix::{Fix, TextEdit};
+use crate::module::version_at_least;
+use crate::package::Package;
+use tree_sitter::Node;
+
+/// Flags goroutines and deferred closures started in a loop that capture a
+/// variable the loop changes, so they may see a later iteration's value.
+///
+/// Before Go 1.22, a variable declared by a `for` or `for ... range` loop
+/// is shared by every iteration. When the file's module declares `go 1.22`
+/// or later, each iteration gets its own, so only variables declared outside
+/// the loop and reassigned in it are reported. Without a module, loop
+/// variables are reported with medium confidence, since the Go version is
+/// unknown. The fix copies the captured variables just before the closure
+/// starts, with `v := v`.
+pub struct GoLoopCapture;
+
+const DECLARED: &str = "declared by the loop here";
+
+/// Why a captured variable may change under the closure.
+#[derive(Clone, Copy, PartialEq)]
+enum Sharing {
+    /// Declared by the loop.
+    LoopVariable,
+    /// Declared outside the loop and assigned in it.
+    Reassigned,
+}
+
+struct Captured<'t> {
+    name: String,
+    sharing: Sharing,
+    /// The loop's declaration, or the first assignment in the loop.
+    origin: Node<'t>,
+    reference: Node<'t>,
+}
+
+impl Check for GoLoopCapture {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        _options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let go = package
+            .and_then(|package| package.module.as_ref())
+            .and_then(|module| module.go.as_deref());
+        let per_iteration = go.is_some_and(|go| version_at_least(go, 1, 22));
+
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if matches!(node.kind(), "go_statement" | "defer_statement") {
+                if let Some(mut hit) = capture(node, source_code, per_iteration) {
+                    if go.is_none() && hit.related.iter().any(|(_, note)| note == DECLARED) {
+                        hit = hit.with_confidence(Confidence::Medium);
+                    }
+                    hits.push(hit);
+                }
+            }
+        });
+        hits
+    }
+}
+
+fn capture<'t>(statement: Node<'t>, source_code: &str, per_iteration: bool) -> Option<Hit<'t>> {
+    let call = statement.named_child(0)?;
+    let literal = call
+        .child_by_field_name("function")
+        .filter(|function| call.kind() == "call_expression" && function.kind() == "func_literal")?;
+
+    let mut candidates: Vec<(String, Sharing, Node<'t>)> = Vec::new();
+    for looping in enclosing_loops(statement) {
+        if !per_iteration {
+            // `v := v` in the body already copies it.
+            let copied = looping
+                .child_by_field_name("body")
+                .map(|body| declared_in(body, source_code))
+                .unwrap_or_default();
+            for variable in loop_variables(looping, source_code)
+                .into_iter()
+                .filter(|variable| {
+                    !copied
+                        .iter()
+                        .any(|name| name == node_text(*variable, source_code))
+                })
+            {
+                candidates.push((
+                    node_text(variable, source_code).to_string(),
+                    Sharing::LoopVariable,
+                    variable,
+                ));
+            }
+        }
+        for target in reassigned(looping, source_code) {
+            candidates.push((
+                node_text(target, source_code).to_string(),
+                Sharing::Reassigned,
+                target,
+            ));
+        }
+    }
+    if candidates.is_empty() {
+        return None;
+    }
+
+    let own = declared_in(literal, source_code);
+    let mut captured: Vec<Captured<'t>> = Vec::new();
+    visit(literal, &mut |node| {
+        if node.kind() != "identifier" || is_composite_key(node) {
+            return;
+        }
+        let name = node_text(node, source_code);
+        if own.iter().any(|declared| declared == name)
+            || captured.iter().any(|captured| captured.name == name)
+        {
+            return;
+        }
+        if let Some((_, sharing, origin)) = candidates.iter().find(|(n, _, _)| n == name) {
+            captured.push(Captured {
+                name: name.to_string(),
+                sharing: *sharing,
+                origin: *origin,
+                reference: node,
+            });
+        }
+    });
+    let first = captured.first()?;
+
+    let closure = if statement.kind() == "go_statement" {
+        "the goroutine"
+    } else {
+        "the deferred function"
+    };
+    let describe = |sharing: Sharing| {
+        let names: Vec<String> = captured
+            .iter()
+            .filter(|captured| captured.sharing == sharing)
+            .map(|captured| format!("`{}`", captured.name))
+            .collect();
+        match sharing {
+            _ if names.is_empty() => None,
+            Sharing::LoopVariable => Some(format!(
+                "loop {} {}, which all iterations share before Go 1.22",
+                if names.len() == 1 {
+                    "variable"
+                } else {
+                    "variables"
+                },
+                names.join(", ")
+            )),
+            Sharing::Reassigned => Some(format!("{}, which the loop reassigns", names.join(", "))),
+        }
+    };
+    let parts: Vec<String> = [Sharing::LoopVariable, Sharing::Reassigned]
+        .into_iter()
+        .filter_map(describe)
+        .collect();
+    let names: Vec<&str> = captured
+        .iter()
+        .map(|captured| captured.name.as_str())
+        .collect();
+    let copy = format!("{} := {}", names.join(", "), names.join(", "));
+    let message = format!(
+        "{} captures {}; copy {} first with `{}`",
+        closure,
+        parts.join(", and "),
+        if names.len() == 1 { "it" } else { "them" },
+        copy
+    );
+
+    let line_start = source_code[..statement.start_byte()]
+        .rfind('\n')
+        .map_or(0, |i| i + 1);
+    let indent = &source_code[line_start..statement.start_byte()];
+    let fix = Fix {
+        description: format!("Copy {} before the closure starts", names.join(", ")),
+        edits: vec![TextEdit {
+            start_byte: statement.start_byte(),
+            end_byte: statement.start_byte(),
+            replacement: format!("{}\n{}", copy, indent),
+        }],
+    };
+
+    let mut hit = Hit::new(first.reference)
+        .with_message(message)
+        .with_fix(fix);
+    for captured in &captured {
+        let note = match captured.sharing {
+            Sharing::LoopVariable => DECLARED,
+            Sharing::Reassigned => "reassigned here",
+        };
+        hit = hit.with_related(captured.origin, note);
+    }
+    Some(hit)
+}
+
+/// The `for` statements around `node` in the same function, innermost first.
+pub(super) fn enclosing_loops(node: Node) -> Vec<Node> {
+    let mut loops = Vec::new();
+    let mut current = node.parent();
+    while let Some(ancestor) = current {
+        match ancestor.kind() {
+            "for_statement" => loops.push(ancestor),
+            "func_literal" | "function_declaration" | "method_declaration" => break,
+            _ => {}
+        }
+        current = ancestor.parent();
+    }
+    loops
+}
+
+/// The variables a loop declares: `i` in `for i := 0; ...` and `k, v` in
+/// `for k, v := range ...`.
+fn loop_variables<'t>(looping: Node<'t>, source_code: &str) -> Vec<Node<'t>> {
+    let mut cursor = looping.walk();
+    let Some(header) = looping
+        .named_children(&mut cursor)
+        .find(|child| matches!(child.kind(), "for_clause" | "range_clause"))
+    else {
+        return Vec::new();
+    };
+    let left = match header.kind() {
+        "for_clause" => header
+            .child_by_field_name("initializer")
+            .filter(|init| init.kind() == "short_var_declaration")
+            .and_then(|init| init.child_by_field_name("left")),
+        _ => header
+            .child_by_field_name("left")
+            .filter(|_| declares(header, source_code)),
+    };
+    left.map(list_items)
+        .unwrap_or_default()
+        .into_iter()
+        .filter(|item| item.kind() == "identifier" && node_text(*item, source_code) != "_")
+        .collect()
+}
+
+/// Whether a range clause declares its variables with `:=`.
+fn declares(range: Node, source_code: &str) -> bool {
+    let (Some(left), Some(right)) = (
+        range.child_by_field_name("left"),
+        range.child_by_field_name("right"),
+    ) else {
+        return false;
+    };
+    source_code[left.end_byte()..right.start_byte()].contains(":=")
+}
+
+/// Variables assigned in the loop, outside closures, that aren't declared
+/// in it, each at its first assignment.
+fn reassigned<'t>(looping: Node<'t>, source_code: &str) -> Vec<Node<'t>> {
+    let declared = declared_in(looping, source_code);
+    let mut targets: Vec<Node<'t>> = Vec::new();
+    let add = |target: Node<'t>, targets: &mut Vec<Node<'t>>| {
+        let name = node_text(target, source_code);
+        if target.kind() == "identifier"
+            && name != "_"
+            && !declared.iter().any(|declared| declared == name)
+            && !targets
+                .iter()
+                .any(|other| node_text(*other, source_code) == name)
+        {
+            targets.push(target);
+        }
+    };
+    outside_closures(looping, &mut |node| match node.kind() {
+        "assignment_statement" => {
+            if let Some(left) = node.child_by_field_name("left") {
+                for target in list_items(left) {
+                    add(target, &mut targets);
+                }
+            }
+        }
+        "inc_statement" | "dec_statement" => {
+            if let Some(target) = node.named_child(0) {
+                add(target, &mut targets);
+            }
+        }
+        "range_clause" if !declares(node, source_code) => {
+            if let Some(left) = node.child_by_field_name("left") {
+                for target in list_items(left) {
+                    add(target, &mut targets);
+                }
+            }
+        }
+        _ => {}
+    });
+    targets
+}
+
+/// Names declared anywhere in `node`: parameters, `:=`, `var` and loop
+/// variables.
+fn declared_in(node: Node, source_code: &str) -> Vec<String> {
+    let mut names = Vec::new();
+    visit(node, &mut |inner| {
+        let declared: Vec<Node> = match inner.kind() {
+            "short_var_declaration" => inner
+                .child_by_field_name("left")
+                .map(list_items)
+                .unwrap_or_default(),
+            "range_clause" if declares(inner, source_code) => inner
+                .child_by_field_name("left")
+                .map(list_items)
+                .unwrap_or_default(),
+            "var_spec" | "parameter_declaration" | "variadic_parameter_declaration" => {
+                let mut cursor = inner.walk();
+                inner.children_by_field_name("name", &mut cursor).collect()
+            }
+            _ => Vec::new(),
+        };
+        names.extend(
+            declared
+                .into_iter()
+                .map(|name| node_text(name, source_code).to_string()),
+        );
+    });
+    names
+}
+
+/// Like [`visit`], without going into function literals.
+fn outside_closures<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    f(node);
+    let mut cursor = node.walk();
+    for child in node.children(&mut cursor) {
+        if child.kind() != "func_literal" {
+            outside_closures(child, f);
+        }
+    }
+}
+
+/// Whether `identifier` is the key of a keyed element, as in `T{Name: v}`,
+/// which names a field rather than a variable.
+fn is_composite_key(identifier: Node) -> bool {
+    let Some(element) = identifier
+        .parent()
+        .filter(|parent| parent.kind() == "literal_element")
+    else {
+        return false;
+    };
+    element
+        .parent()
+        .filter(|keyed| keyed.kind() == "keyed_element")
+        .and_then(|keyed| keyed.named_child(0))
+        == Some(element)
+}
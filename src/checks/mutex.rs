@@ -0,0 +1,432 @@
+use super::flow::{collect_calls, merge, union, Path, Walk};
+use super::{node_text, visit, Check, Hit, RuleOptions};
+use std::collections::{HashMap, HashSet};
+use tree_sitter::Node;
+
+/// Flags deadlock-prone uses of `sync.Mutex` and `sync.RWMutex`.
+///
+/// Each function body is walked path by path: branches of `if`, `switch` and
+/// `select` are followed separately and merged afterwards, and a loop body
+/// may or may not run. Along the way this reports:
+///
+/// - a lock that some path leaves held, either by returning or by reaching
+///   the end of the function, unless the unlock is deferred;
+/// - locking a mutex that the same path already holds;
+/// - calling a method on the receiver that locks a receiver mutex the caller
+///   already holds;
+/// - value receivers and parameters whose type is a struct declared in the
+///   file with a mutex field, which copy the mutex.
+///
+/// Functions whose name mentions "lock" are assumed to acquire or release
+/// locks for their caller and are not checked for unbalanced locking.
+pub struct GoMutex;
+
+const MUTEX_TYPES: &[&str] = &["sync.Mutex", "sync.RWMutex"];
+
+impl Check for GoMutex {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+        let with_mutex = types_with_mutex(root, source_code);
+        let summaries = method_locks(root, source_code);
+
+        let mut hits = copies(root, source_code, &with_mutex);
+        visit(root, &mut |node| {
+            if !matches!(node.kind(), "function_declaration" | "method_declaration") {
+                return;
+            }
+            let Some(body) = node.child_by_field_name("body") else {
+                return;
+            };
+            let name = node
+                .child_by_field_name("name")
+                .map(|name| node_text(name, source_code))
+                .unwrap_or("");
+            let mut walker = Walker {
+                source_code,
+                receiver: receiver(node, source_code),
+                summaries: &summaries,
+                check_balance: !name.to_ascii_lowercase().contains("lock"),
+                reported: HashSet::new(),
+                hits: Vec::new(),
+            };
+            let mut state = State::default();
+            walker.statements(body, &mut state);
+            if !state.terminated {
+                walker.report_held(&state, None);
+            }
+            hits.extend(walker.hits);
+        });
+        hits
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Mode {
+    Write,
+    Read,
+}
+
+#[derive(Clone)]
+struct Held<'t> {
+    key: String,
+    mode: Mode,
+    node: Node<'t>,
+    /// Released by a deferred unlock when the function returns.
+    deferred: bool,
+}
+
+#[derive(Clone, Default)]
+struct State<'t> {
+    held: Vec<Held<'t>>,
+    terminated: bool,
+}
+
+impl Path for State<'_> {
+    fn terminated(&self) -> bool {
+        self.terminated
+    }
+
+    fn terminate(&mut self) {
+        self.terminated = true;
+    }
+
+    fn join(&mut self, other: Self) {
+        union(&mut self.held, other.held, |known, held| {
+            known.key == held.key
+        });
+    }
+}
+
+/// The method's receiver variable and its type name without the pointer.
+struct Receiver {
+    name: String,
+    type_name: String,
+}
+
+struct Walker<'a, 't> {
+    source_code: &'a str,
+    receiver: Option<Receiver>,
+    /// Receiver fields each method locks, keyed by type and method name.
+    summaries: &'a HashMap<(String, String), Vec<(String, Mode)>>,
+    check_balance: bool,
+    /// Lock calls already reported as unbalanced.
+    reported: HashSet<usize>,
+    hits: Vec<Hit<'t>>,
+}
+
+impl<'t> Walk<'t> for Walker<'_, 't> {
+    type State = State<'t>;
+
+    fn statement(&mut self, node: Node<'t>, state: &mut State<'t>) {
+        match node.kind() {
+            "block" | "statement_list" => self.statements(node, state),
+            "labeled_statement" => {
+                if let Some(inner) = node.named_child(1) {
+                    self.statement(inner, state);
+                }
+            }
+            "defer_statement" => self.defer(node, state),
+            "go_statement" | "comment" => {}
+            "return_statement" => {
+                self.calls(node, state);
+                self.report_held(state, Some(node));
+                state.terminated = true;
+            }
+            "break_statement" | "continue_statement" | "goto_statement" => {
+                state.terminated = true;
+            }
+            "if_statement" => {
+                for field in ["initializer", "condition"] {
+                    if let Some(part) = node.child_by_field_name(field) {
+                        self.calls(part, state);
+                    }
+                }
+                let mut then = state.clone();
+                if let Some(consequence) = node.child_by_field_name("consequence") {
+                    self.statement(consequence, &mut then);
+                }
+                let mut otherwise = state.clone();
+                if let Some(alternative) = node.child_by_field_name("alternative") {
+                    self.statement(alternative, &mut otherwise);
+                }
+                *state = merge(vec![then, otherwise]);
+            }
+            "for_statement" => {
+                let mut body = state.clone();
+                if let Some(inner) = node.child_by_field_name("body") {
+                    self.statement(inner, &mut body);
+                }
+                *state = merge(vec![state.clone(), body]);
+            }
+            "expression_switch_statement" | "type_switch_statement" | "select_statement" => {
+                self.switch(node, state);
+            }
+            _ => self.calls(node, state),
+        }
+    }
+}
+
+impl<'a, 't> Walker<'a, 't> {
+    fn defer(&mut self, node: Node<'t>, state: &mut State<'t>) {
+        let mut unlocks = Vec::new();
+        visit(node, &mut |inner| {
+            if let Some((key, "Unlock" | "RUnlock")) = lock_call(inner, self.source_code) {
+                unlocks.push(key);
+            }
+        });
+        for held in &mut state.held {
+            if unlocks.contains(&held.key) {
+                held.deferred = true;
+            }
+        }
+    }
+
+    /// Applies the calls in `node`, outside closures, in document order.
+    fn calls(&mut self, node: Node<'t>, state: &mut State<'t>) {
+        let mut calls = Vec::new();
+        collect_calls(node, &mut calls);
+        for call in calls {
+            self.call(call, state);
+            if state.terminated {
+                return;
+            }
+        }
+    }
+
+    fn call(&mut self, call: Node<'t>, state: &mut State<'t>) {
+        let function = call.child_by_field_name("function");
+        if function.is_some_and(|f| node_text(f, self.source_code) == "panic") {
+            state.terminated = true;
+            return;
+        }
+
+        if let Some((key, method)) = lock_call(call, self.source_code) {
+            match method {
+                "Lock" | "RLock" => {
+                    let mode = if method == "Lock" {
+                        Mode::Write
+                    } else {
+                        Mode::Read
+                    };
+                    if let Some(previous) = state.held.iter().find(|held| held.key == key) {
+                        let message = format!("`{}` is locked again while already held", key);
+                        let previous = previous.node;
+                        self.hits.push(
+                            Hit::new(call)
+                                .with_message(message)
+                                .with_related(previous, "first locked here"),
+                        );
+                        return;
+                    }
+                    state.held.push(Held {
+                        key,
+                        mode,
+                        node: call,
+                        deferred: false,
+                    });
+                }
+                _ => state.held.retain(|held| held.key != key),
+            }
+            return;
+        }
+
+        self.call_on_receiver(call, state);
+    }
+
+    /// `s.other()` where `other` locks a mutex of `s` that is already held.
+    fn call_on_receiver(&mut self, call: Node<'t>, state: &State<'t>) {
+        let Some(receiver) = &self.receiver else {
+            return;
+        };
+        let Some(function) = call
+            .child_by_field_name("function")
+            .filter(|f| f.kind() == "selector_expression")
+        else {
+            return;
+        };
+        let operand = function
+            .child_by_field_name("operand")
+            .map(|operand| node_text(operand, self.source_code));
+        let method = function
+            .child_by_field_name("field")
+            .map(|field| node_text(field, self.source_code));
+        let (Some(operand), Some(method)) = (operand, method) else {
+            return;
+        };
+        if operand != receiver.name {
+            return;
+        }
+        let key = (receiver.type_name.clone(), method.to_string());
+        let Some(locked) = self.summaries.get(&key) else {
+            return;
+        };
+        for (field, _) in locked {
+            let key = format!("{}.{}", receiver.name, field);
+            if let Some(held) = state.held.iter().find(|held| held.key == key) {
+                let message = format!(
+                    "`{}.{}` locks `{}`, which is already held here",
+                    operand, method, key
+                );
+                self.hits.push(
+                    Hit::new(call)
+                        .with_message(message)
+                        .with_related(held.node, "locked here"),
+                );
+                return;
+            }
+        }
+    }
+
+    fn report_held(&mut self, state: &State<'t>, exit: Option<Node<'t>>) {
+        if !self.check_balance {
+            return;
+        }
+        for held in state.held.iter().filter(|held| !held.deferred) {
+            if !self.reported.insert(held.node.id()) {
+                continue;
+            }
+            let unlock = match held.mode {
+                Mode::Write => "unlocked",
+                Mode::Read => "read-unlocked",
+            };
+            let message = format!("`{}` is not {} on every path", held.key, unlock);
+            let mut hit = Hit::new(held.node).with_message(message);
+            if let Some(exit) = exit {
+                hit = hit.with_related(exit, "returns with the lock held");
+            }
+            self.hits.push(hit);
+        }
+    }
+}
+
+/// `key.Lock()` and friends, as the key text and the method name.
+fn lock_call<'s>(node: Node, source_code: &'s str) -> Option<(String, &'s str)> {
+    if node.kind() != "call_expression" {
+        return None;
+    }
+    let function = node.child_by_field_name("function")?;
+    if function.kind() != "selector_expression" {
+        return None;
+    }
+    let method = node_text(function.child_by_field_name("field")?, source_code);
+    if !matches!(method, "Lock" | "Unlock" | "RLock" | "RUnlock") {
+        return None;
+    }
+    let operand = function.child_by_field_name("operand")?;
+    Some((node_text(operand, source_code).to_string(), method))
+}
+
+fn receiver(method: Node, source_code: &str) -> Option<Receiver> {
+    let parameter = method.child_by_field_name("receiver")?.named_child(0)?;
+    let name = node_text(parameter.child_by_field_name("name")?, source_code);
+    let type_name = node_text(parameter.child_by_field_name("type")?, source_code);
+    Some(Receiver {
+        name: name.to_string(),
+        type_name: type_name.trim_start_matches('*').to_string(),
+    })
+}
+
+/// The receiver fields each method locks directly.
+fn method_locks(root: Node, source_code: &str) -> HashMap<(String, String), Vec<(String, Mode)>> {
+    let mut summaries = HashMap::new();
+    visit(root, &mut |node| {
+        if node.kind() != "method_declaration" {
+            return;
+        }
+        let (Some(receiver), Some(name), Some(body)) = (
+            receiver(node, source_code),
+            node.child_by_field_name("name"),
+            node.child_by_field_name("body"),
+        ) else {
+            return;
+        };
+        let prefix = format!("{}.", receiver.name);
+        let mut calls = Vec::new();
+        collect_calls(body, &mut calls);
+        let locked: Vec<(String, Mode)> = calls
+            .into_iter()
+            .filter_map(|call| lock_call(call, source_code))
+            .filter_map(|(key, method)| {
+                let field = key.strip_prefix(&prefix)?.to_string();
+                match method {
+                    "Lock" => Some((field, Mode::Write)),
+                    "RLock" => Some((field, Mode::Read)),
+                    _ => None,
+                }
+            })
+            .collect();
+        if !locked.is_empty() {
+            let key = (receiver.type_name, node_text(name, source_code).to_string());
+            summaries.insert(key, locked);
+        }
+    });
+    summaries
+}
+
+/// Struct types declared in the file with a mutex field, embedded or named.
+fn types_with_mutex(root: Node, source_code: &str) -> HashSet<String> {
+    let mut found = HashSet::new();
+    visit(root, &mut |node| {
+        if node.kind() != "type_spec" {
+            return;
+        }
+        let (Some(name), Some(ty)) = (
+            node.child_by_field_name("name"),
+            node.child_by_field_name("type"),
+        ) else {
+            return;
+        };
+        if ty.kind() != "struct_type" {
+            return;
+        }
+        let mut has_mutex = false;
+        visit(ty, &mut |field| {
+            if field.kind() == "field_declaration" {
+                let ty = field.child_by_field_name("type");
+                has_mutex |= ty.is_some_and(|ty| MUTEX_TYPES.contains(&node_text(ty, source_code)));
+            }
+        });
+        if has_mutex {
+            found.insert(node_text(name, source_code).to_string());
+        }
+    });
+    found
+}
+
+/// Value receivers and parameters of a type that holds a mutex.
+fn copies<'t>(root: Node<'t>, source_code: &str, with_mutex: &HashSet<String>) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    if with_mutex.is_empty() {
+        return hits;
+    }
+    visit(root, &mut |node| {
+        if node.kind() != "parameter_declaration" {
+            return;
+        }
+        let Some(ty) = node.child_by_field_name("type") else {
+            return;
+        };
+        let type_name = node_text(ty, source_code);
+        if !with_mutex.contains(type_name) {
+            return;
+        }
+        let is_receiver = node
+            .parent()
+            .and_then(|list| list.parent())
+            .zip(node.parent())
+            .is_some_and(|(function, list)| {
+                function.kind() == "method_declaration"
+                    && function.child_by_field_name("receiver") == Some(list)
+            });
+        let role = if is_receiver {
+            "value receiver"
+        } else {
+            "parameter"
+        };
+        let message = format!(
+            "`{}` contains a mutex and is copied by this {}",
+            type_name, role
+        );
+        hits.push(Hit::new(ty).with_message(message));
+    });
+    hits
+}
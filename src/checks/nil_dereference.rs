@@ -0,0 +1,972 @@
+use super::contract::{parameter_of, Contracts};
+use super::flow::{merge, nil_check, union, Path, Walk};
+use super::generics::{callee, Generics};
+use super::unchecked_error::is_error_name;
+use super::unreachable::TERMINATORS;
+use super::{list_items, node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::module;
+use crate::package::Package;
+use crate::taint::matches_pattern;
+use std::collections::{HashMap, HashSet};
+use tree_sitter::Node;
+
+/// Flags pointers and interfaces that are dereferenced on a path where they
+/// may be nil: through a field, a method call or `*p`.
+///
+/// Each function body is walked path by path, the way the resource leak rule
+/// does, following values that may be nil:
+///
+/// - results returned alongside an error, on the paths where that error is
+///   not nil: inside `if err != nil`, after an `if err != nil` that doesn't
+///   return, and after the inverted `if err == nil { return }`;
+/// - map lookups whose value type is a pointer or an interface, when the
+///   lookup's `ok` is ignored;
+/// - variables declared as `var p *T`, or set to `nil`, until they are
+///   assigned on every path;
+/// - anything compared with nil, on the branch where it is nil.
+///
+/// A value stops being followed when it is assigned, has its address
+/// taken, is assigned in a closure, or is checked against nil. Results of
+/// functions declared in the file are only followed when their type is a
+/// pointer or an interface; results of other functions are assumed to be.
+/// A generic function's result typed by a type parameter is followed when
+/// the call instantiates it with a pointer or an interface, as in
+/// `Find[*User](id)` or `First(users)` with `users` a `[]*User`.
+/// Method calls matching `Get*`, which generated protobuf code makes safe on
+/// nil receivers, aren't dereferences.
+///
+/// Contracts declared with `//compass:` annotations in the package, or in
+/// imported packages, are taken at their word: the results of a function
+/// marked `//compass:nonnil` aren't followed, and a nil `return` in it is
+/// reported. Passing nil, or a value that may be nil, as a parameter marked
+/// `//compass:nonnil param=name` is reported at the call.
+///
+/// Options:
+/// - `terminators` (default `[]`): extra calls that never return, as for
+///   `unreachable_code`.
+/// - `nil_safe` (default `[]`): extra methods that accept a nil receiver:
+///   names, or prefixes ending in `*`.
+pub struct GoNilDereference;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("nil_safe", OptionKind::Strings),
+    ("terminators", OptionKind::Strings),
+];
+
+const NIL_SAFE: &[&str] = &["Get*"];
+
+/// Types from other packages that are values, never nil.
+const VALUE_TYPES: &[&str] = &["time.Time", "time.Duration", "time.Month", "time.Weekday"];
+
+const FUNCTION_KINDS: &[&str] = &["function_declaration", "method_declaration", "func_literal"];
+
+impl Check for GoNilDereference {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let spec = Spec::new(root, source_code, options, package);
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if !FUNCTION_KINDS.contains(&node.kind()) {
+                return;
+            }
+            let Some(body) = node.child_by_field_name("body") else {
+                return;
+            };
+            if node
+                .parent()
+                .is_some_and(|parent| parent.kind() == "source_file")
+            {
+                let nonnil = module::contract(node, source_code)
+                    .is_some_and(|contract| contract.nonnil_result);
+                if nonnil {
+                    hits.extend(nil_returns(node, body, source_code));
+                }
+            }
+            let mut walker = Walker {
+                source_code,
+                spec: &spec,
+                body,
+                reported: HashSet::new(),
+                hits: Vec::new(),
+            };
+            walker.statement(body, &mut State::default());
+            hits.extend(walker.hits);
+        });
+        hits.sort_by_key(|hit| hit.node.start_byte());
+        hits
+    }
+}
+
+struct Spec {
+    terminators: Vec<String>,
+    nil_safe: Vec<String>,
+    /// For each function and method declared in the file, whether each of
+    /// its results may be nil.
+    results: HashMap<String, Vec<bool>>,
+    /// Variables and fields holding maps whose values may be nil.
+    maps: HashSet<String>,
+    contracts: Contracts,
+    /// Interface types declared in the file.
+    interfaces: HashSet<String>,
+    generics: Generics,
+}
+
+impl Spec {
+    fn new(
+        root: Node,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Self {
+        let mut terminators: Vec<String> = TERMINATORS.iter().map(|s| s.to_string()).collect();
+        terminators.extend(options.string_list("terminators").unwrap_or_default());
+        let mut nil_safe: Vec<String> = NIL_SAFE.iter().map(|s| s.to_string()).collect();
+        nil_safe.extend(options.string_list("nil_safe").unwrap_or_default());
+
+        let mut interfaces = HashSet::new();
+        visit(root, &mut |node| {
+            let Some(name) = node.child_by_field_name("name") else {
+                return;
+            };
+            let is_interface = node
+                .child_by_field_name("type")
+                .is_some_and(|ty| ty.kind() == "interface_type");
+            if node.kind() == "type_spec" && is_interface {
+                interfaces.insert(node_text(name, source_code).to_string());
+            }
+        });
+        let types = Types {
+            source_code,
+            interfaces: interfaces.clone(),
+        };
+
+        let mut results = HashMap::new();
+        let mut maps = HashSet::new();
+        visit(root, &mut |node| match node.kind() {
+            "function_declaration" | "method_declaration" => {
+                let Some(name) = node.child_by_field_name("name") else {
+                    return;
+                };
+                let nilable = match node.child_by_field_name("result") {
+                    Some(list) if list.kind() == "parameter_list" => {
+                        let mut nilable = Vec::new();
+                        let mut cursor = list.walk();
+                        for declaration in list.named_children(&mut cursor) {
+                            let Some(ty) = declaration.child_by_field_name("type") else {
+                                continue;
+                            };
+                            let mut names = declaration.walk();
+                            let count = declaration
+                                .children_by_field_name("name", &mut names)
+                                .count();
+                            nilable.extend(vec![types.may_be_nil(ty); count.max(1)]);
+                        }
+                        nilable
+                    }
+                    Some(ty) => vec![types.may_be_nil(ty)],
+                    None => Vec::new(),
+                };
+                results.insert(node_text(name, source_code).to_string(), nilable);
+            }
+            "var_spec" | "parameter_declaration" | "field_declaration" => {
+                let values = node
+                    .child_by_field_name("value")
+                    .map(list_items)
+                    .unwrap_or_default();
+                let typed = node
+                    .child_by_field_name("type")
+                    .is_some_and(|ty| types.is_nilable_map(ty));
+                let mut cursor = node.walk();
+                for (position, name) in node.children_by_field_name("name", &mut cursor).enumerate()
+                {
+                    let made = values
+                        .get(position)
+                        .is_some_and(|value| types.makes_nilable_map(*value));
+                    if typed || made {
+                        maps.insert(node_text(name, source_code).to_string());
+                    }
+                }
+            }
+            "short_var_declaration" => {
+                let (Some(left), Some(right)) = (
+                    node.child_by_field_name("left"),
+                    node.child_by_field_name("right"),
+                ) else {
+                    return;
+                };
+                for (name, value) in list_items(left).into_iter().zip(list_items(right)) {
+                    if types.makes_nilable_map(value) {
+                        maps.insert(node_text(name, source_code).to_string());
+                    }
+                }
+            }
+            _ => {}
+        });
+
+        Spec {
+            terminators,
+            nil_safe,
+            results,
+            maps,
+            contracts: Contracts::collect(root, source_code, package),
+            interfaces,
+            generics: Generics::collect(root, source_code),
+        }
+    }
+
+    /// Whether result `position` of `call` may be nil. Calls to functions
+    /// that aren't declared in the file may return anything, unless they are
+    /// marked `//compass:nonnil`.
+    fn may_return_nil(&self, call: Node, position: usize, source_code: &str) -> bool {
+        let nonnil = self
+            .contracts
+            .of_call(call, source_code)
+            .is_some_and(|(contract, _)| contract.nonnil_result);
+        if nonnil {
+            return false;
+        }
+        // A generic function's result may be nil depending on what its
+        // type parameters are at the call.
+        if let Some(instance) = self.generics.of_call(call, source_code) {
+            return instance
+                .result(position)
+                .is_some_and(|ty| self.may_be_nil(&ty));
+        }
+        let Some(function) = callee(call) else {
+            return true;
+        };
+        let name = match function.kind() {
+            "selector_expression" => function.child_by_field_name("field"),
+            _ => Some(function),
+        };
+        let results = name.and_then(|name| self.results.get(node_text(name, source_code)));
+        results.is_none_or(|results| results.get(position).copied().unwrap_or(false))
+    }
+
+    /// [`Types::may_be_nil`] for a type written out as text, such as an
+    /// instantiated result. A type parameter nothing instantiates isn't
+    /// followed, as a named type of the package wouldn't be.
+    fn may_be_nil(&self, ty: &str) -> bool {
+        if ty.starts_with('*') || ty.starts_with("interface") {
+            return true;
+        }
+        if ["[", "map[", "chan ", "<-chan ", "func(", "struct"]
+            .iter()
+            .any(|prefix| ty.starts_with(prefix))
+        {
+            return false;
+        }
+        match ty.split_once('.') {
+            Some(_) => !VALUE_TYPES.contains(&ty),
+            None => ty == "error" || ty == "any" || self.interfaces.contains(ty),
+        }
+    }
+
+    fn is_nil_safe(&self, method: &str) -> bool {
+        self.nil_safe
+            .iter()
+            .any(|pattern| match pattern.strip_suffix('*') {
+                Some(prefix) => method.starts_with(prefix),
+                None => method == pattern,
+            })
+    }
+
+    fn terminates(&self, call: Node, source_code: &str) -> bool {
+        let Some(function) = call.child_by_field_name("function") else {
+            return false;
+        };
+        let function = node_text(function, source_code);
+        self.terminators
+            .iter()
+            .any(|pattern| matches_pattern(function, pattern))
+    }
+}
+
+struct Types<'s> {
+    source_code: &'s str,
+    /// Interface types declared in the file.
+    interfaces: HashSet<String>,
+}
+
+impl Types<'_> {
+    /// Pointers and interfaces. Named types from other packages are assumed
+    /// to be interfaces unless they're known values; named types from this
+    /// package must be declared as interfaces in the file.
+    fn may_be_nil(&self, ty: Node) -> bool {
+        match ty.kind() {
+            "pointer_type" | "interface_type" => true,
+            "qualified_type" => !VALUE_TYPES.contains(&node_text(ty, self.source_code)),
+            "type_identifier" => {
+                let name = node_text(ty, self.source_code);
+                name == "error" || name == "any" || self.interfaces.contains(name)
+            }
+            "parenthesized_type" => ty
+                .named_child(0)
+                .is_some_and(|inner| self.may_be_nil(inner)),
+            _ => false,
+        }
+    }
+
+    fn is_nilable_map(&self, ty: Node) -> bool {
+        ty.kind() == "map_type"
+            && ty
+                .child_by_field_name("value")
+                .is_some_and(|value| self.may_be_nil(value))
+    }
+
+    /// `map[K]*V{...}` or `make(map[K]*V)`.
+    fn makes_nilable_map(&self, value: Node) -> bool {
+        match value.kind() {
+            "composite_literal" => value
+                .child_by_field_name("type")
+                .is_some_and(|ty| self.is_nilable_map(ty)),
+            "call_expression" => {
+                let is_make = value
+                    .child_by_field_name("function")
+                    .is_some_and(|function| node_text(function, self.source_code) == "make");
+                let ty = value
+                    .child_by_field_name("arguments")
+                    .and_then(|arguments| arguments.named_child(0));
+                is_make && ty.is_some_and(|ty| self.is_nilable_map(ty))
+            }
+            _ => false,
+        }
+    }
+}
+
+/// A variable that may be nil on the current path.
+#[derive(Clone)]
+struct Nil<'t> {
+    name: String,
+    /// Where it became nil.
+    origin: Node<'t>,
+    note: &'static str,
+    /// Completes "`name` may be nil here: ...".
+    why: String,
+}
+
+/// A result returned alongside an error, which is nil where the error isn't.
+#[derive(Clone)]
+struct Paired<'t> {
+    name: String,
+    err: String,
+    call: Node<'t>,
+}
+
+#[derive(Clone, Default)]
+struct State<'t> {
+    nil: Vec<Nil<'t>>,
+    paired: Vec<Paired<'t>>,
+    terminated: bool,
+}
+
+impl<'t> State<'t> {
+    /// Stops following `name`, which now holds something else.
+    fn forget(&mut self, name: &str) {
+        self.nil.retain(|nil| nil.name != name);
+        self.paired
+            .retain(|paired| paired.name != name && paired.err != name);
+    }
+
+    /// Applies what `condition` being `holds` says about nil values.
+    fn refine(&mut self, condition: Node<'t>, holds: bool, source_code: &str) {
+        match condition.kind() {
+            "parenthesized_expression" => {
+                if let Some(inner) = condition.named_child(0) {
+                    self.refine(inner, holds, source_code);
+                }
+                return;
+            }
+            "unary_expression" => {
+                let operator = condition.child_by_field_name("operator");
+                if operator.is_some_and(|op| node_text(op, source_code) == "!") {
+                    if let Some(operand) = condition.child_by_field_name("operand") {
+                        self.refine(operand, !holds, source_code);
+                    }
+                }
+                return;
+            }
+            "binary_expression" => {
+                let operator = condition
+                    .child_by_field_name("operator")
+                    .map(|op| node_text(op, source_code));
+                // Only a true `&&` or a false `||` says something about both sides.
+                if (operator == Some("&&") && holds) || (operator == Some("||") && !holds) {
+                    for side in ["left", "right"] {
+                        if let Some(side) = condition.child_by_field_name(side) {
+                            self.refine(side, holds, source_code);
+                        }
+                    }
+                    return;
+                }
+            }
+            _ => return,
+        }
+
+        let Some((name, not_nil)) = nil_check(condition, source_code) else {
+            return;
+        };
+        if not_nil == holds {
+            self.nil.retain(|nil| nil.name != name);
+            for paired in &self.paired {
+                if paired.err == name && !self.nil.iter().any(|nil| nil.name == paired.name) {
+                    self.nil.push(Nil {
+                        name: paired.name.clone(),
+                        origin: paired.call,
+                        note: "returned here",
+                        why: format!(
+                            "it comes from the same call as `{}`, which is not nil on this path",
+                            name
+                        ),
+                    });
+                }
+            }
+        } else {
+            self.paired.retain(|paired| paired.err != name);
+            self.nil.retain(|nil| nil.name != name);
+            self.nil.push(Nil {
+                name,
+                origin: condition,
+                note: "compared with nil here",
+                why: "it is compared with nil above, and is nil on this path".to_string(),
+            });
+        }
+    }
+}
+
+impl Path for State<'_> {
+    fn terminated(&self) -> bool {
+        self.terminated
+    }
+
+    fn terminate(&mut self) {
+        self.terminated = true;
+    }
+
+    /// A value nil on either path may be nil after.
+    fn join(&mut self, other: Self) {
+        union(&mut self.nil, other.nil, |known, nil| {
+            known.name == nil.name
+        });
+        union(&mut self.paired, other.paired, |known, paired| {
+            known.name == paired.name
+        });
+    }
+}
+
+struct Walker<'a, 't> {
+    source_code: &'a str,
+    spec: &'a Spec,
+    body: Node<'t>,
+    /// Origins already reported.
+    reported: HashSet<usize>,
+    hits: Vec<Hit<'t>>,
+}
+
+impl<'t> Walk<'t> for Walker<'_, 't> {
+    type State = State<'t>;
+
+    fn statement(&mut self, node: Node<'t>, state: &mut State<'t>) {
+        match node.kind() {
+            "block" | "statement_list" => self.statements(node, state),
+            "labeled_statement" => {
+                if let Some(inner) = node.named_child(1) {
+                    self.statement(inner, state);
+                }
+            }
+            "comment" => {}
+            "return_statement" => {
+                self.check(node, state);
+                state.terminated = true;
+            }
+            "break_statement" | "continue_statement" | "goto_statement" => {
+                state.terminated = true;
+            }
+            "expression_statement" => {
+                self.check(node, state);
+                let call = node
+                    .named_child(0)
+                    .filter(|call| call.kind() == "call_expression");
+                if call.is_some_and(|call| self.spec.terminates(call, self.source_code)) {
+                    state.terminated = true;
+                }
+            }
+            "short_var_declaration" | "assignment_statement" => self.assignment(node, state),
+            "var_declaration" | "var_spec_list" => {
+                let mut cursor = node.walk();
+                let specs: Vec<Node<'t>> = node.named_children(&mut cursor).collect();
+                for spec in specs {
+                    self.statement(spec, state);
+                }
+            }
+            "var_spec" => self.var_spec(node, state),
+            "if_statement" => {
+                if let Some(initializer) = node.child_by_field_name("initializer") {
+                    self.statement(initializer, state);
+                }
+                let condition = node.child_by_field_name("condition");
+                if let Some(condition) = condition {
+                    self.check(condition, state);
+                }
+                let mut then = state.clone();
+                let mut otherwise = state.clone();
+                if let Some(condition) = condition {
+                    then.refine(condition, true, self.source_code);
+                    otherwise.refine(condition, false, self.source_code);
+                }
+                if let Some(consequence) = node.child_by_field_name("consequence") {
+                    self.statement(consequence, &mut then);
+                }
+                if let Some(alternative) = node.child_by_field_name("alternative") {
+                    self.statement(alternative, &mut otherwise);
+                }
+                *state = merge(vec![then, otherwise]);
+            }
+            "for_statement" => self.for_statement(node, state),
+            "expression_switch_statement" | "type_switch_statement" | "select_statement" => {
+                self.switch(node, state);
+            }
+            _ => self.check(node, state),
+        }
+    }
+}
+
+impl<'a, 't> Walker<'a, 't> {
+    fn for_statement(&mut self, node: Node<'t>, state: &mut State<'t>) {
+        let mut body = state.clone();
+        match node.named_child(0) {
+            Some(clause) if clause.kind() == "for_clause" => {
+                if let Some(initializer) = clause.child_by_field_name("initializer") {
+                    self.statement(initializer, state);
+                }
+                body = state.clone();
+                if let Some(condition) = clause.child_by_field_name("condition") {
+                    self.check(condition, state);
+                    body = state.clone();
+                    body.refine(condition, true, self.source_code);
+                }
+            }
+            Some(clause) if clause.kind() == "range_clause" => {
+                if let Some(right) = clause.child_by_field_name("right") {
+                    self.check(right, state);
+                }
+                if let Some(left) = clause.child_by_field_name("left") {
+                    for name in list_items(left) {
+                        state.forget(node_text(name, self.source_code));
+                    }
+                }
+                body = state.clone();
+            }
+            Some(condition) if condition.kind() != "block" => {
+                self.check(condition, state);
+                body = state.clone();
+                body.refine(condition, true, self.source_code);
+            }
+            _ => {}
+        }
+        if let Some(inner) = node.child_by_field_name("body") {
+            self.statement(inner, &mut body);
+        }
+        body.terminated = false;
+        *state = merge(vec![state.clone(), body]);
+    }
+
+    fn assignment(&mut self, node: Node<'t>, state: &mut State<'t>) {
+        let left = node
+            .child_by_field_name("left")
+            .map(list_items)
+            .unwrap_or_default();
+        let right = node
+            .child_by_field_name("right")
+            .map(list_items)
+            .unwrap_or_default();
+        for value in &right {
+            self.check(*value, state);
+        }
+        let operator = node
+            .child_by_field_name("operator")
+            .map(|op| node_text(op, self.source_code));
+        if node.kind() == "assignment_statement" && operator.is_some_and(|op| op != "=") {
+            // Compound assignments read their target.
+            for target in left {
+                self.check(target, state);
+            }
+            return;
+        }
+        self.bind(&left, &right, state);
+    }
+
+    fn var_spec(&mut self, node: Node<'t>, state: &mut State<'t>) {
+        let mut cursor = node.walk();
+        let names: Vec<Node<'t>> = node.children_by_field_name("name", &mut cursor).collect();
+        let values = node
+            .child_by_field_name("value")
+            .map(list_items)
+            .unwrap_or_default();
+        for value in &values {
+            self.check(*value, state);
+        }
+        if !values.is_empty() {
+            self.bind(&names, &values, state);
+            return;
+        }
+        let pointer = node
+            .child_by_field_name("type")
+            .is_some_and(|ty| ty.kind() == "pointer_type");
+        for name in names {
+            let text = node_text(name, self.source_code);
+            state.forget(text);
+            if pointer {
+                state.nil.push(Nil {
+                    name: text.to_string(),
+                    origin: name,
+                    note: "declared here",
+                    why: "it is declared without a value and isn't assigned on every path"
+                        .to_string(),
+                });
+            }
+        }
+    }
+
+    /// Assigns `values` to `targets`, following what may now be nil.
+    fn bind(&mut self, targets: &[Node<'t>], values: &[Node<'t>], state: &mut State<'t>) {
+        for target in targets {
+            match target.kind() {
+                "identifier" => state.forget(node_text(*target, self.source_code)),
+                // `p.x = v` and `*p = v` dereference `p`.
+                _ => self.check(*target, state),
+            }
+        }
+        let names: Vec<&str> = targets
+            .iter()
+            .map(|target| match target.kind() {
+                "identifier" => node_text(*target, self.source_code),
+                _ => "_",
+            })
+            .collect();
+
+        if names.len() == values.len() {
+            for (name, value) in names.iter().zip(values) {
+                if *name != "_" && value.kind() == "nil" {
+                    state.nil.push(Nil {
+                        name: name.to_string(),
+                        origin: *value,
+                        note: "set to nil here",
+                        why: "it is set to nil and isn't assigned on every path".to_string(),
+                    });
+                }
+            }
+        }
+        let [value] = values else {
+            return;
+        };
+        match value.kind() {
+            "call_expression" if names.len() > 1 => {
+                let err = names[names.len() - 1];
+                if !is_error_name(err) {
+                    return;
+                }
+                for (position, name) in names[..names.len() - 1].iter().enumerate() {
+                    if *name != "_" && self.spec.may_return_nil(*value, position, self.source_code)
+                    {
+                        state.paired.push(Paired {
+                            name: name.to_string(),
+                            err: err.to_string(),
+                            call: *value,
+                        });
+                    }
+                }
+            }
+            "index_expression" => {
+                let Some(map) = value
+                    .child_by_field_name("operand")
+                    .filter(|map| self.is_nilable_map(*map))
+                else {
+                    return;
+                };
+                let name = names[0];
+                let ok = names.get(1).copied().unwrap_or("_");
+                if name == "_" || (ok != "_" && self.read_after(ok, value.end_byte())) {
+                    return;
+                }
+                let why = if ok == "_" {
+                    format!(
+                        "`{}` gives nil for a missing key",
+                        node_text(map, self.source_code)
+                    )
+                } else {
+                    format!(
+                        "`{}` gives nil for a missing key, and `{}` is never checked",
+                        node_text(map, self.source_code),
+                        ok
+                    )
+                };
+                state.nil.push(Nil {
+                    name: name.to_string(),
+                    origin: *value,
+                    note: "looked up here",
+                    why,
+                });
+            }
+            _ => {}
+        }
+    }
+
+    fn is_nilable_map(&self, map: Node) -> bool {
+        let name = match map.kind() {
+            "identifier" => Some(map),
+            "selector_expression" => map.child_by_field_name("field"),
+            _ => None,
+        };
+        name.is_some_and(|name| self.spec.maps.contains(node_text(name, self.source_code)))
+    }
+
+    /// Whether `name` is read anywhere in the function after `offset`.
+    fn read_after(&self, name: &str, offset: usize) -> bool {
+        let mut found = false;
+        visit(self.body, &mut |node| {
+            found |= node.kind() == "identifier"
+                && node.start_byte() >= offset
+                && node_text(node, self.source_code) == name;
+        });
+        found
+    }
+
+    /// Reports dereferences in `node` of values that may be nil.
+    fn check(&mut self, node: Node<'t>, state: &mut State<'t>) {
+        match node.kind() {
+            "call_expression" => self.nonnil_arguments(node, state),
+            "func_literal" => {
+                // Closures are walked on their own, and may run at any time,
+                // so anything they assign is no longer known.
+                let mut assigned = Vec::new();
+                visit(node, &mut |inner| {
+                    assigned.extend(assigned_names(inner, self.source_code))
+                });
+                for name in assigned {
+                    state.forget(name);
+                }
+                return;
+            }
+            "binary_expression" => {
+                let operator = node
+                    .child_by_field_name("operator")
+                    .map(|op| node_text(op, self.source_code));
+                if let ("&&" | "||", Some(left), Some(right)) = (
+                    operator.unwrap_or(""),
+                    node.child_by_field_name("left"),
+                    node.child_by_field_name("right"),
+                ) {
+                    // The right side only runs when the left allows it.
+                    self.check(left, state);
+                    let mut guarded = state.clone();
+                    guarded.refine(left, operator == Some("&&"), self.source_code);
+                    self.check(right, &mut guarded);
+                    return;
+                }
+            }
+            "unary_expression" => {
+                let operator = node
+                    .child_by_field_name("operator")
+                    .map(|op| node_text(op, self.source_code));
+                let operand = node.child_by_field_name("operand");
+                if let (Some(op), Some(operand)) = (operator, operand) {
+                    if operand.kind() == "identifier" {
+                        match op {
+                            "*" => self.dereference(operand, node, state),
+                            "&" => state.forget(node_text(operand, self.source_code)),
+                            _ => {}
+                        }
+                        return;
+                    }
+                }
+            }
+            "selector_expression" => {
+                if let Some(operand) = node.child_by_field_name("operand") {
+                    if operand.kind() == "identifier" {
+                        if !self.is_nil_safe_call(node) {
+                            self.dereference(operand, node, state);
+                        }
+                        return;
+                    }
+                    self.check(operand, state);
+                }
+                return;
+            }
+            _ => {}
+        }
+        let mut cursor = node.walk();
+        let children: Vec<Node<'t>> = node.named_children(&mut cursor).collect();
+        for child in children {
+            self.check(child, state);
+        }
+    }
+
+    /// Reports arguments that are nil, or may be, passed as parameters the
+    /// callee marks `//compass:nonnil`.
+    fn nonnil_arguments(&mut self, call: Node<'t>, state: &State<'t>) {
+        let Some((contract, confidence)) = self.spec.contracts.of_call(call, self.source_code)
+        else {
+            return;
+        };
+        let (Some(function), Some(arguments)) = (
+            call.child_by_field_name("function"),
+            call.child_by_field_name("arguments"),
+        ) else {
+            return;
+        };
+        let callee = node_text(function, self.source_code);
+        let mut cursor = arguments.walk();
+        let arguments: Vec<Node<'t>> = arguments.named_children(&mut cursor).collect();
+        for argument in arguments {
+            let parameter = parameter_of(contract, argument);
+            if parameter.is_empty() || !contract.nonnil.iter().any(|name| name == parameter) {
+                continue;
+            }
+            let hit = match argument.kind() {
+                "nil" => Hit::new(argument).with_message(format!(
+                    "`{}` is passed nil for `{}`, which it marks `//compass:nonnil`",
+                    callee, parameter
+                )),
+                "identifier" => {
+                    let name = node_text(argument, self.source_code);
+                    let Some(nil) = state.nil.iter().find(|nil| nil.name == name) else {
+                        continue;
+                    };
+                    if !self.reported.insert(nil.origin.id()) {
+                        continue;
+                    }
+                    Hit::new(argument)
+                        .with_message(format!(
+                            "`{}` may be nil here, and `{}` marks `{}` `//compass:nonnil`: {}",
+                            name, callee, parameter, nil.why
+                        ))
+                        .with_related(nil.origin, nil.note)
+                }
+                _ => continue,
+            };
+            self.hits.push(hit.with_confidence(confidence));
+        }
+    }
+
+    /// `x.GetName()` and other calls that are fine on a nil receiver.
+    fn is_nil_safe_call(&self, selector: Node) -> bool {
+        let called = selector
+            .parent()
+            .filter(|call| call.kind() == "call_expression")
+            .and_then(|call| call.child_by_field_name("function"))
+            .is_some_and(|function| function == selector);
+        let field = selector
+            .child_by_field_name("field")
+            .map(|field| node_text(field, self.source_code));
+        called && field.is_some_and(|field| self.spec.is_nil_safe(field))
+    }
+
+    fn dereference(&mut self, variable: Node<'t>, at: Node<'t>, state: &mut State<'t>) {
+        let name = node_text(variable, self.source_code);
+        let Some(position) = state.nil.iter().position(|nil| nil.name == name) else {
+            return;
+        };
+        // Past this point the program has panicked or `name` wasn't nil.
+        let nil = state.nil.remove(position);
+        if !self.reported.insert(nil.origin.id()) {
+            return;
+        }
+        let message = format!("`{}` may be nil here: {}", nil.name, nil.why);
+        self.hits.push(
+            Hit::new(at)
+                .with_message(message)
+                .with_related(nil.origin, nil.note),
+        );
+    }
+}
+
+/// `return nil` in a function marked `//compass:nonnil`, for results other
+/// than errors.
+fn nil_returns<'t>(function: Node<'t>, body: Node<'t>, source_code: &str) -> Vec<Hit<'t>> {
+    // Whether each result is an error.
+    let errors: Vec<bool> = match function.child_by_field_name("result") {
+        Some(list) if list.kind() == "parameter_list" => {
+            let mut errors = Vec::new();
+            let mut cursor = list.walk();
+            for declaration in list.named_children(&mut cursor) {
+                let Some(ty) = declaration.child_by_field_name("type") else {
+                    continue;
+                };
+                let mut names = declaration.walk();
+                let count = declaration
+                    .children_by_field_name("name", &mut names)
+                    .count();
+                errors.extend(vec![node_text(ty, source_code) == "error"; count.max(1)]);
+            }
+            errors
+        }
+        Some(ty) => vec![node_text(ty, source_code) == "error"],
+        None => return Vec::new(),
+    };
+    let name = function
+        .child_by_field_name("name")
+        .map_or("", |name| node_text(name, source_code));
+
+    let mut hits = Vec::new();
+    let mut pending = vec![body];
+    while let Some(node) = pending.pop() {
+        // Closures return their own results.
+        if node.kind() == "func_literal" {
+            continue;
+        }
+        if node.kind() == "return_statement" {
+            let values = node.named_child(0).map(list_items).unwrap_or_default();
+            for (value, is_error) in values.into_iter().zip(&errors) {
+                if value.kind() == "nil" && !is_error {
+                    hits.push(Hit::new(value).with_message(format!(
+                        "`{}` is marked `//compass:nonnil` but returns nil here",
+                        name
+                    )));
+                }
+            }
+            continue;
+        }
+        let mut cursor = node.walk();
+        pending.extend(node.named_children(&mut cursor));
+    }
+    hits
+}
+
+/// The variables `node` assigns to or takes the address of.
+fn assigned_names<'s>(node: Node, source_code: &'s str) -> Vec<&'s str> {
+    let targets = match node.kind() {
+        "assignment_statement" | "short_var_declaration" => node
+            .child_by_field_name("left")
+            .map(list_items)
+            .unwrap_or_default(),
+        "unary_expression" => {
+            let address = node
+                .child_by_field_name("operator")
+                .is_some_and(|op| node_text(op, source_code) == "&");
+            node.child_by_field_name("operand")
+                .filter(|_| address)
+                .into_iter()
+                .collect()
+        }
+        _ => Vec::new(),
+    };
+    targets
+        .into_iter()
+        .filter(|target| target.kind() == "identifier")
+        .map(|target| node_text(target, source_code))
+        .collect()
+}
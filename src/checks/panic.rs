@@ -0,0 +1,163 @@
+use super::exhaustive::Declarations;
+use super::{node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::package::Package;
+use tree_sitter::Node;
+
+/// Flags `panic(...)` calls outside the places a team has agreed they belong.
+///
+/// Options:
+/// - `allow_functions` (default `["init", "main", "Must*"]`): functions, or
+///   name prefixes ending in `*`, whose panics are allowed, including those
+///   inside their closures.
+/// - `allow_test_helpers` (default `true`): allows panics in `Test*`,
+///   `Benchmark*`, `Fuzz*` and `Example*` functions and in functions that take
+///   a `*testing.T`, `*testing.B`, `*testing.F` or `testing.TB`.
+/// - `allow_unreachable_default` (default `true`): allows a panic that is the
+///   only statement of a switch's `default` case, the usual way to assert
+///   that the other cases are exhaustive, when they are: the switch lists
+///   every value of an enum, or every implementation of a sealed interface,
+///   declared in the package (see `go_exhaustive`).
+pub struct GoPanic;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("allow_functions", OptionKind::Strings),
+    ("allow_test_helpers", OptionKind::Bool),
+    ("allow_unreachable_default", OptionKind::Bool),
+];
+
+const DEFAULT_ALLOWED: &[&str] = &["init", "main", "Must*"];
+const TEST_PREFIXES: &[&str] = &["Test", "Benchmark", "Fuzz", "Example"];
+const TEST_TYPES: &[&str] = &["*testing.T", "*testing.B", "*testing.F", "testing.TB"];
+
+impl Check for GoPanic {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let allowed = options
+            .string_list("allow_functions")
+            .unwrap_or_else(|| DEFAULT_ALLOWED.iter().map(|s| s.to_string()).collect());
+        let allow_tests = options.bool("allow_test_helpers").unwrap_or(true);
+        let allow_default = options.bool("allow_unreachable_default").unwrap_or(true);
+        let declarations = match allow_default {
+            true => Declarations::in_package(root, source_code, package),
+            false => Declarations::default(),
+        };
+
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if node.kind() != "call_expression" {
+                return;
+            }
+            let is_panic = node
+                .child_by_field_name("function")
+                .is_some_and(|f| f.kind() == "identifier" && node_text(f, source_code) == "panic");
+            if !is_panic {
+                return;
+            }
+
+            if let Some(function) = enclosing_declaration(node) {
+                let name = function
+                    .child_by_field_name("name")
+                    .map(|n| node_text(n, source_code))
+                    .unwrap_or("");
+                if allowed.iter().any(|pattern| matches_name(name, pattern)) {
+                    return;
+                }
+                if allow_tests && is_test_helper(function, name, source_code) {
+                    return;
+                }
+            }
+            if allow_default && is_unreachable_default(node, &declarations, source_code) {
+                return;
+            }
+            hits.push(Hit::new(node));
+        });
+        hits
+    }
+}
+
+pub(crate) fn matches_name(name: &str, pattern: &str) -> bool {
+    match pattern.strip_suffix('*') {
+        Some(prefix) => name.starts_with(prefix),
+        None => name == pattern,
+    }
+}
+
+fn is_test_helper(function: Node, name: &str, source_code: &str) -> bool {
+    if TEST_PREFIXES.iter().any(|prefix| name.starts_with(prefix)) {
+        return true;
+    }
+    let Some(parameters) = function.child_by_field_name("parameters") else {
+        return false;
+    };
+    let mut cursor = parameters.walk();
+    let takes_testing = parameters.named_children(&mut cursor).any(|parameter| {
+        parameter
+            .child_by_field_name("type")
+            .is_some_and(|ty| TEST_TYPES.contains(&node_text(ty, source_code)))
+    });
+    takes_testing
+}
+
+/// `default: panic(...)` as the only statement of the case of a switch that
+/// `declarations` show lists everything else.
+pub(crate) fn is_unreachable_default(
+    call: Node,
+    declarations: &Declarations,
+    source_code: &str,
+) -> bool {
+    let Some(statement) = call.parent().filter(|p| p.kind() == "expression_statement") else {
+        return false;
+    };
+    let mut body = statement.parent();
+    // Newer grammars wrap case bodies in a statement_list.
+    if body.is_some_and(|node| node.kind() == "statement_list") {
+        body = body.and_then(|list| list.parent());
+    }
+    let Some(case) = body.filter(|case| case.kind() == "default_case") else {
+        return false;
+    };
+    is_only_statement(statement)
+        && case
+            .parent()
+            .is_some_and(|switch| declarations.is_complete(switch, source_code))
+}
+
+fn is_only_statement(statement: Node) -> bool {
+    let Some(parent) = statement.parent() else {
+        return false;
+    };
+    let mut cursor = parent.walk();
+    let others = parent
+        .named_children(&mut cursor)
+        .filter(|child| *child != statement && child.kind() != "comment")
+        .count();
+    others == 0
+}
+
+/// The named function or method a node is in, looking through closures.
+pub(super) fn enclosing_declaration(node: Node) -> Option<Node> {
+    let mut current = node.parent();
+    while let Some(ancestor) = current {
+        if matches!(
+            ancestor.kind(),
+            "function_declaration" | "method_declaration"
+        ) {
+            return Some(ancestor);
+        }
+        current = ancestor.parent();
+    }
+    None
+}
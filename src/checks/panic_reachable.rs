@@ -0,0 +1,160 @@
+use super::panic::matches_name;
+use super::{node_text, Check, Hit, OptionKind, RuleOptions};
+use crate::analyzer::Confidence;
+use crate::callgraph::{declaration_id, is_graphed, CallGraph};
+use crate::package::Package;
+use tree_sitter::Node;
+
+/// Flags exported functions of library packages that can reach a `panic`
+/// through the functions they call, following the module's call graph. A
+/// function that defers a `recover` stops the panics below it, and panics
+/// written directly in the exported function are left to `go_panic`.
+///
+/// Options:
+/// - `allow` (default `["Must*"]`): functions, or name prefixes ending in
+///   `*`, that may panic by contract. They aren't reported and the search
+///   doesn't go through them.
+/// - `max_depth` (default `10`): the longest chain of calls searched.
+/// - `dynamic_calls` (default `false`): also follow method calls the graph
+///   resolved by name alone, which finds more panics and more false ones.
+///   Findings that rely on one have low confidence.
+pub struct GoPanicReachable;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("allow", OptionKind::Strings),
+    ("max_depth", OptionKind::Count),
+    ("dynamic_calls", OptionKind::Bool),
+];
+
+const DEFAULT_ALLOWED: &[&str] = &["Must*"];
+
+impl Check for GoPanicReachable {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn reads_call_graph(&self) -> bool {
+        true
+    }
+
+    fn needs_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let Some(package) = package.filter(|package| is_graphed(&package.path)) else {
+            return Vec::new();
+        };
+        if package_name(root, source_code) == Some("main") {
+            return Vec::new();
+        }
+        let (Some(import_path), Some(graph), Some(module)) = (
+            package.import_path(),
+            package.call_graph(),
+            package.module.as_ref(),
+        ) else {
+            return Vec::new();
+        };
+        let allowed = options
+            .string_list("allow")
+            .unwrap_or_else(|| DEFAULT_ALLOWED.iter().map(|s| s.to_string()).collect());
+        let max_depth = options.usize("max_depth").unwrap_or(10);
+        let dynamic_calls = options.bool("dynamic_calls").unwrap_or(false);
+        let is_allowed = |function: usize| {
+            let name = &graph.functions[function].name;
+            allowed.iter().any(|pattern| matches_name(name, pattern))
+        };
+
+        let mut hits = Vec::new();
+        let mut cursor = root.walk();
+        for declaration in root.named_children(&mut cursor) {
+            let Some(name) = declaration.child_by_field_name("name") else {
+                continue;
+            };
+            let Some(from) = declaration_id(&import_path, declaration, source_code)
+                .and_then(|id| graph.find(&id))
+            else {
+                continue;
+            };
+            let function = &graph.functions[from];
+            if !function.is_exported() || function.recovers || is_allowed(from) {
+                continue;
+            }
+            let Some(path) = graph.path(
+                from,
+                Some(max_depth),
+                |call| {
+                    (dynamic_calls || !call.dynamic)
+                        && !graph.functions[call.callee].recovers
+                        && !is_allowed(call.callee)
+                },
+                |function| function != from && !graph.functions[function].panics.is_empty(),
+            ) else {
+                continue;
+            };
+
+            let chain = path[1..]
+                .iter()
+                .map(|&function| format!("`{}`", label(&graph, function, &import_path)))
+                .collect::<Vec<_>>()
+                .join(" → ");
+            let panicking = &graph.functions[*path.last().unwrap_or(&from)];
+            let file = panicking
+                .path
+                .strip_prefix(&module.root)
+                .unwrap_or(&panicking.path);
+            let message = format!(
+                "`{}` can panic through {}, which panics at {}:{}",
+                label(&graph, from, &import_path),
+                chain,
+                file.display(),
+                panicking.panics[0]
+            );
+            let mut hit = Hit::new(name).with_message(message);
+            let guessed = path.windows(2).any(|hop| {
+                !graph
+                    .callees(hop[0])
+                    .any(|call| call.callee == hop[1] && !call.dynamic)
+            });
+            if guessed {
+                hit = hit.with_confidence(Confidence::Low);
+            }
+            hits.push(hit);
+        }
+        hits
+    }
+}
+
+/// The function's name as code in `package` would write it: qualified by
+/// its receiver type for methods, and by its package's name outside it.
+pub(super) fn label(graph: &CallGraph, function: usize, package: &str) -> String {
+    let function = &graph.functions[function];
+    let name = match &function.receiver {
+        Some(receiver) => format!("{}.{}", receiver, function.name),
+        None => function.name.clone(),
+    };
+    if function.package == package {
+        return name;
+    }
+    let qualifier = function.package.rsplit('/').next().unwrap_or_default();
+    format!("{}.{}", qualifier, name)
+}
+
+pub(super) fn package_name<'s>(root: Node, source_code: &'s str) -> Option<&'s str> {
+    let mut cursor = root.walk();
+    let clause = root
+        .named_children(&mut cursor)
+        .find(|child| child.kind() == "package_clause")?;
+    let name = clause.named_child(0)?;
+    Some(node_text(name, source_code))
+}
@@ -0,0 +1,843 @@
+use super::api_misuse::imported_as;
+use super::loop_capture::enclosing_loops;
+use super::test_coverage::statements;
+use super::{enclosing_function, list_items, node_text, visit, Check, Hit, RuleOptions};
+use crate::analyzer::Confidence;
+use crate::fix::{Fix, TextEdit};
+use std::collections::HashSet;
+use tree_sitter::Node;
+
+/// Allocations that loops and frequently called functions repeat for no
+/// reason.
+///
+/// A slice or map is worth pre-sizing when it is filled by a loop over
+/// something whose length is known before the loop: `for ... range xs`,
+/// sized with `len(xs)`, or `for i := 0; i < n; i++`, sized with `n`. The
+/// loop has to add exactly one element per iteration, with nothing before
+/// the `append` or the assignment that could skip it, so the size is at
+/// most the final length. What `range` goes over is recognized by how it
+/// is declared in the function; anything else might be a channel, which
+/// has no useful length, so those findings have medium confidence.
+pub struct GoPerformance {
+    issue: PerformanceIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum PerformanceIssue {
+    /// A slice or map filled in a loop of known length but allocated
+    /// without a size.
+    Prealloc,
+    /// A string built up with `+=` in a loop.
+    StringConcat,
+    /// A constant regular expression compiled in a loop or a function.
+    RegexpCompile,
+}
+
+impl GoPerformance {
+    pub fn new(issue: PerformanceIssue) -> Self {
+        GoPerformance { issue }
+    }
+}
+
+/// `regexp` functions that compile their pattern argument, and those that
+/// compile it only to match once, which `*regexp.Regexp` has as methods.
+const COMPILERS: &[&str] = &["Compile", "CompilePOSIX", "MustCompile", "MustCompilePOSIX"];
+const MATCHERS: &[&str] = &["Match", "MatchReader", "MatchString"];
+
+const INTEGERS: &[&str] = &[
+    "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64",
+    "uintptr", "byte", "rune",
+];
+
+impl Check for GoPerformance {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+        match self.issue {
+            PerformanceIssue::Prealloc => prealloc(root, source_code),
+            PerformanceIssue::StringConcat => string_concat(root, source_code),
+            PerformanceIssue::RegexpCompile => match imported_as(root, source_code, "regexp") {
+                Some(regexp) => regexp_compile(root, source_code, &regexp),
+                None => Vec::new(),
+            },
+        }
+    }
+}
+
+/// A slice or map declared empty.
+struct Collection<'t> {
+    statement: Node<'t>,
+    name: String,
+    /// The slice or map type, such as `[]string`.
+    ty: String,
+    is_map: bool,
+    /// The empty literal or `make` a sized `make` replaces. A nil slice
+    /// has none, since sizing it would make it non-nil when nothing is
+    /// added, which `== nil` checks and JSON encoding tell apart.
+    value: Option<Node<'t>>,
+}
+
+impl<'t> Collection<'t> {
+    /// The collection `statement` declares: `var xs []T`, `xs := []T{}`,
+    /// `xs := make([]T, 0)`, `m := map[K]V{}` or `m := make(map[K]V)`.
+    fn declared(statement: Node<'t>, source_code: &str) -> Option<Self> {
+        let (name, ty, value) = match statement.kind() {
+            "var_declaration" => {
+                let spec = statement
+                    .named_child(0)
+                    .filter(|spec| spec.kind() == "var_spec")?;
+                let mut cursor = spec.walk();
+                let names: Vec<Node> = spec.children_by_field_name("name", &mut cursor).collect();
+                let [name] = names[..] else {
+                    return None;
+                };
+                (
+                    name,
+                    spec.child_by_field_name("type"),
+                    spec.child_by_field_name("value"),
+                )
+            }
+            "short_var_declaration" => {
+                let left = list_items(statement.child_by_field_name("left")?);
+                let [name] = left[..] else {
+                    return None;
+                };
+                (name, None, statement.child_by_field_name("right"))
+            }
+            _ => return None,
+        };
+        let name = node_text(name, source_code).to_string();
+        let Some(values) = value else {
+            let ty = ty.filter(|ty| ty.kind() == "slice_type")?;
+            return Some(Collection {
+                statement,
+                name,
+                ty: node_text(ty, source_code).to_string(),
+                is_map: false,
+                value: None,
+            });
+        };
+        let values = list_items(values);
+        let [value] = values[..] else {
+            return None;
+        };
+        let ty = empty_collection(value, source_code)?;
+        Some(Collection {
+            statement,
+            name,
+            ty: node_text(ty, source_code).to_string(),
+            is_map: ty.kind() == "map_type",
+            value: Some(value),
+        })
+    }
+}
+
+/// The type of `value` when it is an empty slice or map: `[]T{}`,
+/// `make([]T, 0)`, `map[K]V{}` or `make(map[K]V)`.
+fn empty_collection<'t>(value: Node<'t>, source_code: &str) -> Option<Node<'t>> {
+    let collection = |ty: &Node| matches!(ty.kind(), "slice_type" | "map_type");
+    match value.kind() {
+        "composite_literal" => {
+            let ty = value.child_by_field_name("type").filter(collection)?;
+            let body = value.child_by_field_name("body")?;
+            let mut cursor = body.walk();
+            let empty = body
+                .named_children(&mut cursor)
+                .all(|child| child.kind() == "comment");
+            empty.then_some(ty)
+        }
+        "call_expression" => {
+            let function = value.child_by_field_name("function")?;
+            if node_text(function, source_code) != "make" {
+                return None;
+            }
+            let arguments = value.child_by_field_name("arguments")?;
+            let mut cursor = arguments.walk();
+            let arguments: Vec<Node> = arguments.named_children(&mut cursor).collect();
+            match arguments[..] {
+                [ty] if ty.kind() == "map_type" => Some(ty),
+                [ty, length]
+                    if ty.kind() == "slice_type" && node_text(length, source_code) == "0" =>
+                {
+                    Some(ty)
+                }
+                _ => None,
+            }
+        }
+        _ => None,
+    }
+}
+
+fn prealloc<'t>(root: Node<'t>, source_code: &str) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "block" {
+            return;
+        }
+        let body = statements(Some(node));
+        for (at, statement) in body.iter().enumerate() {
+            let Some(collection) = Collection::declared(*statement, source_code) else {
+                continue;
+            };
+            if let Some(hit) = filled_later(&collection, &body[at + 1..], source_code) {
+                hits.push(hit);
+            }
+        }
+    });
+    hits
+}
+
+/// How a loop's length is known.
+struct Length {
+    /// The size to allocate, such as `len(users)`.
+    size: String,
+    /// What the loop runs once per, for the message.
+    per: String,
+    /// The variables `size` reads, which must not change before the loop.
+    reads: Vec<String>,
+    /// Whether what `range` goes over might not have a length.
+    guessed: bool,
+}
+
+/// The finding for `collection` when the first statement after it that
+/// uses it is a loop of known length adding one element per iteration.
+fn filled_later<'t>(
+    collection: &Collection<'t>,
+    rest: &[Node<'t>],
+    source_code: &str,
+) -> Option<Hit<'t>> {
+    let name = collection.name.as_str();
+    let (at, looping) = rest
+        .iter()
+        .enumerate()
+        .find(|(_, statement)| mentions(**statement, name, source_code))?;
+    if looping.kind() != "for_statement" {
+        return None;
+    }
+    let length = loop_length(*looping, source_code)?;
+    if length.reads.iter().any(|read| read == name) {
+        return None;
+    }
+    // Sized at the declaration, the length has to be in scope and final by then.
+    let changed = rest[..at].iter().any(|statement| {
+        length
+            .reads
+            .iter()
+            .any(|read| mentions(*statement, read, source_code))
+    });
+    if changed || !fills_once(*looping, collection, source_code) {
+        return None;
+    }
+
+    let (message, replacement) = if collection.is_map {
+        (
+            format!(
+                "`{}` gets an entry once per {}; allocate it with `make({}, {})`",
+                name, length.per, collection.ty, length.size
+            ),
+            format!("make({}, {})", collection.ty, length.size),
+        )
+    } else {
+        (
+            format!(
+                "`{}` grows by `append` once per {}; allocate it with `make({}, 0, {})`",
+                name, length.per, collection.ty, length.size
+            ),
+            format!("make({}, 0, {})", collection.ty, length.size),
+        )
+    };
+    let mut hit = Hit::new(collection.statement)
+        .with_message(message)
+        .with_related(*looping, "filled here");
+    if length.guessed {
+        hit = hit.with_confidence(Confidence::Medium);
+    } else if let Some(value) = collection.value {
+        hit = hit.with_fix(Fix {
+            description: format!("Allocate `{}` with its final size", name),
+            edits: vec![TextEdit {
+                start_byte: value.start_byte(),
+                end_byte: value.end_byte(),
+                replacement,
+            }],
+        });
+    }
+    Some(hit)
+}
+
+/// The length of `looping` when it is known before the loop starts.
+fn loop_length(looping: Node, source_code: &str) -> Option<Length> {
+    let mut cursor = looping.walk();
+    let header = looping
+        .named_children(&mut cursor)
+        .find(|child| matches!(child.kind(), "for_clause" | "range_clause"))?;
+    if header.kind() == "range_clause" {
+        let ranged = header.child_by_field_name("right")?;
+        let text = node_text(ranged, source_code);
+        if ranged.kind() == "int_literal" {
+            return Some(Length {
+                size: text.to_string(),
+                per: format!("iteration up to `{}`", text),
+                reads: Vec::new(),
+                guessed: false,
+            });
+        }
+        let root = path_root(ranged)?;
+        let reads = vec![node_text(root, source_code).to_string()];
+        let kind = (ranged.kind() == "identifier")
+            .then(|| ranged_kind(looping, text, source_code))
+            .unwrap_or(Ranged::Unknown);
+        return match kind {
+            Ranged::Channel => None,
+            Ranged::Integer => Some(Length {
+                size: text.to_string(),
+                per: format!("iteration up to `{}`", text),
+                reads,
+                guessed: false,
+            }),
+            Ranged::Sized | Ranged::Unknown => Some(Length {
+                size: format!("len({})", text),
+                per: format!("element of `{}`", text),
+                reads,
+                guessed: kind == Ranged::Unknown,
+            }),
+        };
+    }
+
+    // for i := 0; i < n; i++
+    let initializer = header
+        .child_by_field_name("initializer")
+        .filter(|initializer| initializer.kind() == "short_var_declaration")?;
+    let counters = list_items(initializer.child_by_field_name("left")?);
+    let starts = list_items(initializer.child_by_field_name("right")?);
+    let ([counter], [start]) = (&counters[..], &starts[..]) else {
+        return None;
+    };
+    if node_text(*start, source_code) != "0" {
+        return None;
+    }
+    let counter = node_text(*counter, source_code);
+    let condition = header.child_by_field_name("condition")?;
+    let (Some(left), Some(operator), Some(bound)) = (
+        condition.child_by_field_name("left"),
+        condition.child_by_field_name("operator"),
+        condition.child_by_field_name("right"),
+    ) else {
+        return None;
+    };
+    let update = header.child_by_field_name("update")?;
+    let counts_up = update.kind() == "inc_statement"
+        && update
+            .named_child(0)
+            .is_some_and(|target| node_text(target, source_code) == counter);
+    if node_text(left, source_code) != counter
+        || node_text(operator, source_code) != "<"
+        || !counts_up
+    {
+        return None;
+    }
+    let mut reads = Vec::new();
+    let mut calls = false;
+    visit(bound, &mut |node| match node.kind() {
+        "identifier" => reads.push(node_text(node, source_code).to_string()),
+        "call_expression" => {
+            calls |= node
+                .child_by_field_name("function")
+                .is_none_or(|function| node_text(function, source_code) != "len");
+        }
+        _ => {}
+    });
+    if calls || reads.iter().any(|read| read == counter) {
+        return None;
+    }
+    let bound = node_text(bound, source_code);
+    Some(Length {
+        size: bound.to_string(),
+        per: format!("iteration up to `{}`", bound),
+        reads,
+        guessed: false,
+    })
+}
+
+/// The variable at the start of `node` when it is a variable or a chain of
+/// fields, such as `req` in `req.Items`.
+fn path_root(node: Node) -> Option<Node> {
+    match node.kind() {
+        "identifier" => Some(node),
+        "selector_expression" => path_root(node.child_by_field_name("operand")?),
+        _ => None,
+    }
+}
+
+#[derive(PartialEq)]
+enum Ranged {
+    /// A slice, array, map or string.
+    Sized,
+    Integer,
+    Channel,
+    Unknown,
+}
+
+/// What kind of value `name` is, from how the function around `looping`
+/// declares it.
+fn ranged_kind(looping: Node, name: &str, source_code: &str) -> Ranged {
+    let Some(function) = enclosing_function(looping) else {
+        return Ranged::Unknown;
+    };
+    let mut kind = Ranged::Unknown;
+    visit(function, &mut |node| {
+        if node.start_byte() >= looping.start_byte() {
+            return;
+        }
+        let found = match node.kind() {
+            "variadic_parameter_declaration" => {
+                declares(node, name, source_code).then_some(Ranged::Sized)
+            }
+            "parameter_declaration" | "var_spec" if declares(node, name, source_code) => {
+                match node.child_by_field_name("type") {
+                    Some(ty) => Some(type_kind(ty, source_code)),
+                    None => node
+                        .child_by_field_name("value")
+                        .and_then(|values| list_items(values).first().copied())
+                        .map(|value| value_kind(value, source_code)),
+                }
+            }
+            "short_var_declaration" => {
+                let (Some(left), Some(right)) = (
+                    node.child_by_field_name("left"),
+                    node.child_by_field_name("right"),
+                ) else {
+                    return;
+                };
+                list_items(left)
+                    .iter()
+                    .zip(list_items(right))
+                    .find(|(target, _)| node_text(**target, source_code) == name)
+                    .map(|(_, value)| value_kind(value, source_code))
+            }
+            _ => None,
+        };
+        if let Some(found) = found {
+            kind = found;
+        }
+    });
+    kind
+}
+
+fn declares(declaration: Node, name: &str, source_code: &str) -> bool {
+    let mut cursor = declaration.walk();
+    let declared = declaration
+        .children_by_field_name("name", &mut cursor)
+        .any(|declared| node_text(declared, source_code) == name);
+    declared
+}
+
+fn type_kind(ty: Node, source_code: &str) -> Ranged {
+    match ty.kind() {
+        "slice_type" | "array_type" | "map_type" => Ranged::Sized,
+        "channel_type" => Ranged::Channel,
+        _ => match node_text(ty, source_code) {
+            "string" => Ranged::Sized,
+            ty if INTEGERS.contains(&ty) => Ranged::Integer,
+            _ => Ranged::Unknown,
+        },
+    }
+}
+
+fn value_kind(value: Node, source_code: &str) -> Ranged {
+    match value.kind() {
+        "composite_literal" => value
+            .child_by_field_name("type")
+            .map_or(Ranged::Unknown, |ty| type_kind(ty, source_code)),
+        "interpreted_string_literal" | "raw_string_literal" => Ranged::Sized,
+        "call_expression" => {
+            let is_make = value
+                .child_by_field_name("function")
+                .is_some_and(|function| node_text(function, source_code) == "make");
+            let ty = value
+                .child_by_field_name("arguments")
+                .and_then(|arguments| arguments.named_child(0));
+            match ty {
+                Some(ty) if is_make => type_kind(ty, source_code),
+                _ => Ranged::Unknown,
+            }
+        }
+        _ => Ranged::Unknown,
+    }
+}
+
+/// Whether the body of `looping` adds exactly one element to `collection`
+/// on every iteration: a single `xs = append(xs, x)` or `m[k] = v` among
+/// its statements, with nothing before it that could skip it and no other
+/// use of the collection in the loop.
+fn fills_once(looping: Node, collection: &Collection, source_code: &str) -> bool {
+    let name = collection.name.as_str();
+    let body = statements(looping.child_by_field_name("body"));
+    let Some(at) = body
+        .iter()
+        .position(|statement| mentions(*statement, name, source_code))
+    else {
+        return false;
+    };
+    let adds = if collection.is_map {
+        is_entry_assignment(body[at], name, source_code)
+    } else {
+        is_single_append(body[at], name, source_code)
+    };
+    adds && !body[at + 1..]
+        .iter()
+        .any(|statement| mentions(*statement, name, source_code))
+        && !body[..at].iter().any(|statement| jumps(*statement))
+}
+
+/// Whether `statement` is `name = append(name, x)`.
+fn is_single_append(statement: Node, name: &str, source_code: &str) -> bool {
+    let Some((target, value)) = plain_assignment(statement) else {
+        return false;
+    };
+    if target.kind() != "identifier" || node_text(target, source_code) != name {
+        return false;
+    }
+    let is_append = value.kind() == "call_expression"
+        && value
+            .child_by_field_name("function")
+            .is_some_and(|function| node_text(function, source_code) == "append");
+    let Some(arguments) = value.child_by_field_name("arguments").filter(|_| is_append) else {
+        return false;
+    };
+    let mut cursor = arguments.walk();
+    let arguments: Vec<Node> = arguments.named_children(&mut cursor).collect();
+    match arguments[..] {
+        [first, element] => {
+            node_text(first, source_code) == name
+                && element.kind() != "variadic_argument"
+                && !mentions(element, name, source_code)
+        }
+        _ => false,
+    }
+}
+
+/// Whether `statement` is `name[k] = v`.
+fn is_entry_assignment(statement: Node, name: &str, source_code: &str) -> bool {
+    plain_assignment(statement).is_some_and(|(target, value)| {
+        target.kind() == "index_expression"
+            && target
+                .child_by_field_name("operand")
+                .is_some_and(|operand| node_text(operand, source_code) == name)
+            && target
+                .child_by_field_name("index")
+                .is_some_and(|index| !mentions(index, name, source_code))
+            && !mentions(value, name, source_code)
+    })
+}
+
+/// The target and value of `statement` when it is a single `a = b`.
+fn plain_assignment(statement: Node) -> Option<(Node, Node)> {
+    if statement.kind() != "assignment_statement" {
+        return None;
+    }
+    let operator = statement.child_by_field_name("operator")?;
+    if operator.kind() != "=" {
+        return None;
+    }
+    let targets = list_items(statement.child_by_field_name("left")?);
+    let values = list_items(statement.child_by_field_name("right")?);
+    match (&targets[..], &values[..]) {
+        ([target], [value]) => Some((*target, *value)),
+        _ => None,
+    }
+}
+
+/// Whether `statement` can leave the iteration early.
+fn jumps(statement: Node) -> bool {
+    let mut found = false;
+    visit(statement, &mut |node| {
+        found |= matches!(
+            node.kind(),
+            "continue_statement" | "break_statement" | "return_statement" | "goto_statement"
+        );
+    });
+    found
+}
+
+/// Whether `node` reads or writes the variable `name`.
+fn mentions(node: Node, name: &str, source_code: &str) -> bool {
+    let mut found = false;
+    visit(node, &mut |inner| {
+        found |= inner.kind() == "identifier" && node_text(inner, source_code) == name;
+    });
+    found
+}
+
+fn string_concat<'t>(root: Node<'t>, source_code: &str) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    // The loop and variable of each finding, so each is reported once.
+    let mut reported = HashSet::new();
+    visit(root, &mut |node| {
+        let Some((name, added)) = concatenation(node, source_code) else {
+            return;
+        };
+        let Some(looping) = enclosing_loops(node).first().copied() else {
+            return;
+        };
+        let declaration = enclosing_function(node)
+            .and_then(|function| latest_declaration(function, node, name, source_code));
+        let inside = declaration.is_some_and(|(declaration, _)| {
+            declaration.start_byte() >= looping.start_byte()
+                && declaration.end_byte() <= looping.end_byte()
+        });
+        let declared_string = declaration.is_some_and(|(_, is_string)| is_string);
+        if inside || !(declared_string || is_string(added, source_code)) {
+            return;
+        }
+        if !reported.insert((looping.id(), name.to_string())) {
+            return;
+        }
+        let message = format!(
+            "`{}` is copied in full every time the loop adds to it; build it with a `strings.Builder` and call `String()` after the loop",
+            name
+        );
+        hits.push(Hit::new(node).with_message(message));
+    });
+    hits
+}
+
+/// The variable `node` appends to and what it appends, for `s += x` and
+/// `s = s + x`.
+fn concatenation<'t, 's>(node: Node<'t>, source_code: &'s str) -> Option<(&'s str, Node<'t>)> {
+    if node.kind() != "assignment_statement" {
+        return None;
+    }
+    let operator = node.child_by_field_name("operator")?;
+    let targets = list_items(node.child_by_field_name("left")?);
+    let values = list_items(node.child_by_field_name("right")?);
+    let ([target], [value]) = (&targets[..], &values[..]) else {
+        return None;
+    };
+    if target.kind() != "identifier" {
+        return None;
+    }
+    let name = node_text(*target, source_code);
+    match operator.kind() {
+        "+=" => Some((name, *value)),
+        "=" => {
+            // s = s + a + b is (s + a) + b.
+            let mut leftmost = *value;
+            while leftmost.kind() == "binary_expression"
+                && leftmost
+                    .child_by_field_name("operator")
+                    .is_some_and(|operator| operator.kind() == "+")
+            {
+                leftmost = leftmost.child_by_field_name("left")?;
+            }
+            (leftmost != *value
+                && leftmost.kind() == "identifier"
+                && node_text(leftmost, source_code) == name)
+                .then_some((name, *value))
+        }
+        _ => None,
+    }
+}
+
+/// The last declaration of `name` in `function` before `before`, and
+/// whether it makes `name` a string.
+fn latest_declaration<'t>(
+    function: Node<'t>,
+    before: Node,
+    name: &str,
+    source_code: &str,
+) -> Option<(Node<'t>, bool)> {
+    let mut found = None;
+    visit(function, &mut |node| {
+        if node.start_byte() >= before.start_byte() {
+            return;
+        }
+        let declared = match node.kind() {
+            "parameter_declaration" | "var_spec" if declares(node, name, source_code) => {
+                let typed = node
+                    .child_by_field_name("type")
+                    .is_some_and(|ty| node_text(ty, source_code) == "string");
+                let valued = node.child_by_field_name("value").is_some_and(|values| {
+                    list_items(values)
+                        .iter()
+                        .any(|value| is_string(*value, source_code))
+                });
+                Some(typed || valued)
+            }
+            "short_var_declaration" | "range_clause" => {
+                let Some(left) = node.child_by_field_name("left") else {
+                    return;
+                };
+                let targets = list_items(left);
+                let Some(at) = targets
+                    .iter()
+                    .position(|target| node_text(*target, source_code) == name)
+                else {
+                    return;
+                };
+                if node.kind() == "range_clause" {
+                    Some(false)
+                } else {
+                    let values = node.child_by_field_name("right").map(list_items);
+                    Some(
+                        values
+                            .and_then(|values| values.get(at).copied())
+                            .is_some_and(|value| is_string(value, source_code)),
+                    )
+                }
+            }
+            _ => None,
+        };
+        if let Some(is_string) = declared {
+            found = Some((node, is_string));
+        }
+    });
+    found
+}
+
+/// Whether `node` is evidently a string: a literal, a concatenation with
+/// one, or a call of `fmt.Sprint`, `fmt.Sprintf` or `fmt.Sprintln`.
+fn is_string(node: Node, source_code: &str) -> bool {
+    match node.kind() {
+        "interpreted_string_literal" | "raw_string_literal" => true,
+        "parenthesized_expression" => node
+            .named_child(0)
+            .is_some_and(|inner| is_string(inner, source_code)),
+        "binary_expression" => {
+            node.child_by_field_name("operator")
+                .is_some_and(|operator| operator.kind() == "+")
+                && ["left", "right"].iter().any(|side| {
+                    node.child_by_field_name(side)
+                        .is_some_and(|operand| is_string(operand, source_code))
+                })
+        }
+        "call_expression" => node
+            .child_by_field_name("function")
+            .is_some_and(|function| {
+                matches!(
+                    node_text(function, source_code),
+                    "fmt.Sprint" | "fmt.Sprintf" | "fmt.Sprintln"
+                )
+            }),
+        _ => false,
+    }
+}
+
+fn regexp_compile<'t>(root: Node<'t>, source_code: &str, regexp: &str) -> Vec<Hit<'t>> {
+    let mut constants = HashSet::new();
+    visit(root, &mut |node| {
+        if node.kind() == "const_spec" {
+            let mut cursor = node.walk();
+            for name in node.children_by_field_name("name", &mut cursor) {
+                constants.insert(node_text(name, source_code));
+            }
+        }
+    });
+
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "call_expression" {
+            return;
+        }
+        let Some(function) = node
+            .child_by_field_name("function")
+            .filter(|function| function.kind() == "selector_expression")
+        else {
+            return;
+        };
+        let (Some(operand), Some(field)) = (
+            function.child_by_field_name("operand"),
+            function.child_by_field_name("field"),
+        ) else {
+            return;
+        };
+        let field = node_text(field, source_code);
+        let compiles = COMPILERS.contains(&field);
+        if node_text(operand, source_code) != regexp || (!compiles && !MATCHERS.contains(&field)) {
+            return;
+        }
+        let constant = node
+            .child_by_field_name("arguments")
+            .and_then(|arguments| arguments.named_child(0))
+            .is_some_and(|pattern| is_constant(pattern, source_code, &constants));
+        if !constant {
+            return;
+        }
+
+        let repeated = if !enclosing_loops(node).is_empty() {
+            "on every iteration of the loop".to_string()
+        } else {
+            match repeating_function(node, source_code) {
+                Some(function) => format!("on every call of `{}`", function),
+                None => return,
+            }
+        };
+        let message = if compiles {
+            format!(
+                "`{}.{}` compiles the same pattern {}; compile it once into a package-level variable",
+                regexp, field, repeated
+            )
+        } else {
+            format!(
+                "`{}.{}` compiles its pattern {}; compile it once into a package-level `*{}.Regexp` and call its `{}` method",
+                regexp, field, repeated, regexp, field
+            )
+        };
+        hits.push(Hit::new(node).with_message(message));
+    });
+    hits
+}
+
+/// Whether `pattern` is a literal, a constant or a concatenation of them.
+fn is_constant(pattern: Node, source_code: &str, constants: &HashSet<&str>) -> bool {
+    match pattern.kind() {
+        "interpreted_string_literal" | "raw_string_literal" => true,
+        "identifier" => constants.contains(node_text(pattern, source_code)),
+        "parenthesized_expression" => pattern
+            .named_child(0)
+            .is_some_and(|inner| is_constant(inner, source_code, constants)),
+        "binary_expression" => ["left", "right"].iter().all(|side| {
+            pattern
+                .child_by_field_name(side)
+                .is_some_and(|operand| is_constant(operand, source_code, constants))
+        }),
+        _ => false,
+    }
+}
+
+/// The name of the function `call` is in when that function may run many
+/// times. Package initialization, `init`, `main` and tests run once, as
+/// does a closure passed to `sync.Once.Do`, `sync.OnceFunc` or
+/// `sync.OnceValue`.
+fn repeating_function<'s>(call: Node, source_code: &'s str) -> Option<&'s str> {
+    let mut current = call.parent();
+    while let Some(node) = current {
+        match node.kind() {
+            "func_literal" => {
+                let once = node
+                    .parent()
+                    .filter(|parent| parent.kind() == "argument_list")
+                    .and_then(|arguments| arguments.parent())
+                    .and_then(|outer| outer.child_by_field_name("function"))
+                    .is_some_and(|function| {
+                        let text = node_text(function, source_code);
+                        text.ends_with(".Do") || text.contains(".Once")
+                    });
+                if once {
+                    return None;
+                }
+            }
+            "function_declaration" | "method_declaration" => {
+                let name = node_text(node.child_by_field_name("name")?, source_code);
+                let runs_once = matches!(name, "init" | "main")
+                    || ["Test", "Benchmark", "Example", "Fuzz"]
+                        .iter()
+                        .any(|prefix| {
+                            name.strip_prefix(prefix)
+                                .is_some_and(|rest| !rest.starts_with(|c: char| c.is_lowercase()))
+                        });
+                return (!runs_once).then_some(name);
+            }
+            _ => {}
+        }
+        current = node.parent();
+    }
+    None
+}
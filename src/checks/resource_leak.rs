@@ -0,0 +1,447 @@
+use super::contract::{parameter_of, Contracts};
+use super::flow::{unreleased, Obligation, Open};
+use super::generics::{callee, Generics};
+use super::{node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::module;
+use crate::package::Package;
+use crate::taint::matches_pattern;
+use std::collections::HashSet;
+use tree_sitter::Node;
+
+/// Flags files, HTTP response bodies, SQL rows and other closers that some
+/// path through a function never closes.
+///
+/// A resource is the first result of a call to an acquiring function
+/// (`os.Open`, `http.Get`, `db.Query` and so on, or a function in the same
+/// file returning `*os.File`, `io.ReadCloser`, a type with a `Close` method
+/// and the like). A generic function in the file acquires one when its
+/// first result is a type parameter constrained to have `Close`, or that
+/// the call instantiates with a closer. Each function body is then walked path by path, the way
+/// the mutex rule does, and the resource stops being tracked when it is:
+///
+/// - closed, directly, in a `defer`, or in a closure;
+/// - known to be nil, on the `err != nil` branch after it was acquired;
+/// - handed on: returned, stored in a variable, field or composite literal,
+///   sent on a channel, or passed to a function. Functions that only read
+///   from it, such as `io.ReadAll` or `bufio.NewScanner`, don't take it.
+///
+/// For HTTP responses the resource is `resp.Body`. Results assigned to `_`
+/// are reported straight away.
+///
+/// `//compass:` contracts in the package, or in imported packages, say
+/// which calls take a resource: a function marked `//compass:closes conn`
+/// takes only `conn`, and one marked `//compass:noescape` takes nothing, so
+/// the caller still has to close what it passes. A parameter the function
+/// itself marks `//compass:closes` is tracked like a resource it acquired.
+///
+/// Options:
+/// - `resources`: extra call patterns whose first result must be closed,
+///   matched like taint sources (`".Acquire"`, `"pool.Get"`).
+/// - `types`: types that must be closed, such as `"*pool.Conn"`. Functions in
+///   the file returning them, and `pool.NewConn` or `pool.OpenConn`, then
+///   acquire one.
+/// - `non_owning`: extra calls that read from a resource without taking
+///   responsibility for closing it.
+pub struct GoResourceLeak;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("resources", OptionKind::Strings),
+    ("types", OptionKind::Strings),
+    ("non_owning", OptionKind::Strings),
+];
+
+const ACQUIRERS: &[&str] = &[
+    "os.Open",
+    "os.Create",
+    "os.OpenFile",
+    "os.CreateTemp",
+    "ioutil.TempFile",
+    ".Query",
+    ".QueryContext",
+    ".Prepare",
+    ".PrepareContext",
+    "net.Dial",
+    "net.DialTimeout",
+    "net.Listen",
+    "gzip.NewReader",
+    "zip.OpenReader",
+];
+
+/// Calls returning an `*http.Response`, whose body needs closing.
+const RESPONSES: &[&str] = &["http.Get", "http.Post", "http.PostForm", "http.Head", ".Do"];
+
+const CLOSER_TYPES: &[&str] = &[
+    "os.File",
+    "sql.Rows",
+    "sql.Stmt",
+    "sql.Conn",
+    "io.Closer",
+    "io.ReadCloser",
+    "io.WriteCloser",
+    "io.ReadWriteCloser",
+    "net.Conn",
+    "net.Listener",
+];
+
+const NON_OWNING: &[&str] = &[
+    "io.Copy",
+    "io.CopyN",
+    "io.CopyBuffer",
+    "io.ReadAll",
+    "io.ReadFull",
+    "ioutil.ReadAll",
+    "bufio.NewReader",
+    "bufio.NewScanner",
+    "bufio.NewWriter",
+    "json.NewDecoder",
+    "json.NewEncoder",
+    "xml.NewDecoder",
+    "csv.NewReader",
+    "csv.NewWriter",
+    "gzip.NewReader",
+    "fmt.Fprint",
+    "fmt.Fprintf",
+    "fmt.Fprintln",
+];
+
+const FUNCTION_KINDS: &[&str] = &["function_declaration", "method_declaration", "func_literal"];
+
+impl Check for GoResourceLeak {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let spec = Spec::new(root, source_code, options, package);
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if !FUNCTION_KINDS.contains(&node.kind()) {
+                return;
+            }
+            let Some(body) = node.child_by_field_name("body") else {
+                return;
+            };
+            let open = owned_parameters(node, source_code);
+            hits.extend(unreleased(&spec, body, open, source_code));
+        });
+        hits
+    }
+}
+
+struct Spec {
+    /// Call patterns and whether they return an HTTP response.
+    acquirers: Vec<(String, bool)>,
+    non_owning: Vec<String>,
+    contracts: Contracts,
+    /// Types that must be closed, without the `*`.
+    closers: HashSet<String>,
+    generics: Generics,
+}
+
+impl Spec {
+    fn new(
+        root: Node,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Self {
+        let mut acquirers: Vec<(String, bool)> = ACQUIRERS
+            .iter()
+            .map(|pattern| (pattern.to_string(), false))
+            .chain(RESPONSES.iter().map(|pattern| (pattern.to_string(), true)))
+            .collect();
+        acquirers.extend(
+            options
+                .string_list("resources")
+                .unwrap_or_default()
+                .into_iter()
+                .map(|pattern| (pattern, false)),
+        );
+
+        let configured: Vec<String> = options
+            .string_list("types")
+            .unwrap_or_default()
+            .iter()
+            .map(|ty| ty.trim_start_matches('*').to_string())
+            .collect();
+        for ty in &configured {
+            if let Some((package, name)) = ty.rsplit_once('.') {
+                for verb in ["New", "Open"] {
+                    acquirers.push((format!("{}.{}{}", package, verb, name), false));
+                }
+            }
+        }
+
+        let mut closers: HashSet<String> = CLOSER_TYPES.iter().map(|ty| ty.to_string()).collect();
+        closers.extend(configured);
+        closers.extend(types_with_close(root, source_code));
+        acquirers.extend(returning_closers(root, source_code, &closers));
+
+        let mut non_owning: Vec<String> = NON_OWNING.iter().map(|name| name.to_string()).collect();
+        non_owning.extend(options.string_list("non_owning").unwrap_or_default());
+        Spec {
+            acquirers,
+            non_owning,
+            contracts: Contracts::collect(root, source_code, package),
+            closers,
+            generics: Generics::collect(root, source_code),
+        }
+    }
+
+    /// Whether a type parameter's constraint makes it a closer: a closer
+    /// interface, or one listing `Close()`.
+    fn is_closer_constraint(&self, constraint: &str) -> bool {
+        self.closers.contains(constraint)
+            || (constraint.starts_with("interface") && constraint.contains("Close()"))
+    }
+
+    /// Whether `call` acquires a resource, and if so whether it's a response.
+    /// Receiver patterns like `.Query` need arguments, so `u.Query()` on a
+    /// URL doesn't count.
+    fn acquisition(&self, call: Node, source_code: &str) -> Option<bool> {
+        if let Some(instance) = self.generics.of_call(call, source_code) {
+            let ty = instance.result(0)?;
+            let ty = ty.trim_start_matches('*');
+            if ty == "http.Response" {
+                return Some(true);
+            }
+            // An uninstantiated type parameter is a closer when its
+            // constraint requires `Close`.
+            let closer = self.closers.contains(ty)
+                || instance
+                    .constraint(ty)
+                    .is_some_and(|constraint| self.is_closer_constraint(constraint));
+            if closer {
+                return Some(false);
+            }
+        }
+        let callee = node_text(callee(call)?, source_code);
+        let has_arguments = call
+            .child_by_field_name("arguments")
+            .is_some_and(|arguments| arguments.named_child_count() > 0);
+        self.acquirers
+            .iter()
+            .find(|(pattern, _)| {
+                matches_pattern(callee, pattern) && (has_arguments || !pattern.starts_with('.'))
+            })
+            .map(|(_, response)| *response)
+    }
+}
+
+/// What the walk knows about a resource besides its variable.
+#[derive(Clone)]
+struct Resource {
+    /// The resource is `name.Body`.
+    response: bool,
+    /// The function, for a parameter it marks `//compass:closes`.
+    owner: Option<String>,
+}
+
+impl Obligation for Spec {
+    type Kind = Resource;
+
+    const RETURNS: &'static str = "returns without closing it";
+
+    fn acquires(&self, call: Node, source_code: &str) -> Option<Resource> {
+        self.acquisition(call, source_code)
+            .map(|response| Resource {
+                response,
+                owner: None,
+            })
+    }
+
+    /// What gets closed: the variable, or the response's body.
+    fn handle(&self, open: &Open<Resource>) -> String {
+        if open.kind.response {
+            format!("{}.Body", open.name)
+        } else {
+            open.name.clone()
+        }
+    }
+
+    fn releases(&self, call: Node, source_code: &str) -> Option<String> {
+        close_call(call, source_code)
+    }
+
+    /// Functions that only read from the resource don't take it, and
+    /// contracts say which parameters do.
+    fn takes(&self, call: Node, argument: Node, source_code: &str) -> bool {
+        if let Some((contract, _)) = self.contracts.of_call(call, source_code) {
+            let parameter = parameter_of(contract, argument);
+            if contract.closes.iter().any(|name| name == parameter) {
+                return true;
+            }
+            if !contract.closes.is_empty() || contract.noescape.iter().any(|name| name == parameter)
+            {
+                return false;
+            }
+        }
+        call.child_by_field_name("function")
+            .is_some_and(|function| {
+                let callee = node_text(function, source_code);
+                !self.non_owning.iter().any(|name| name == callee)
+            })
+    }
+
+    fn discarded(&self, callee: &str) -> String {
+        format!(
+            "`{}` returns a resource that is discarded without being closed",
+            callee
+        )
+    }
+
+    fn unreleased(&self, open: &Open<Resource>) -> String {
+        match &open.kind.owner {
+            Some(owner) => format!(
+                "`{}` is not closed on every path, though `{}` is marked `//compass:closes {}`",
+                self.handle(open),
+                owner,
+                open.name
+            ),
+            None => format!(
+                "`{}` from `{}` is not closed on every path",
+                self.handle(open),
+                open.callee
+            ),
+        }
+    }
+}
+
+/// `x.Close()`, as the text of `x`.
+fn close_call(node: Node, source_code: &str) -> Option<String> {
+    if node.kind() != "call_expression" {
+        return None;
+    }
+    let function = node.child_by_field_name("function")?;
+    if function.kind() != "selector_expression" {
+        return None;
+    }
+    if node_text(function.child_by_field_name("field")?, source_code) != "Close" {
+        return None;
+    }
+    let operand = function.child_by_field_name("operand")?;
+    Some(node_text(operand, source_code).to_string())
+}
+
+/// The parameters a top-level function marks `//compass:closes`, which it
+/// has to close like a resource it acquired.
+fn owned_parameters<'t>(function: Node<'t>, source_code: &str) -> Vec<Open<'t, Resource>> {
+    if !function
+        .parent()
+        .is_some_and(|parent| parent.kind() == "source_file")
+    {
+        return Vec::new();
+    }
+    let Some(contract) = module::contract(function, source_code) else {
+        return Vec::new();
+    };
+    let (Some(name), Some(parameters)) = (
+        function.child_by_field_name("name"),
+        function.child_by_field_name("parameters"),
+    ) else {
+        return Vec::new();
+    };
+    let mut owned = Vec::new();
+    let mut cursor = parameters.walk();
+    for declaration in parameters.named_children(&mut cursor) {
+        let response = declaration
+            .child_by_field_name("type")
+            .is_some_and(|ty| node_text(ty, source_code) == "*http.Response");
+        let mut names = declaration.walk();
+        for parameter in declaration.children_by_field_name("name", &mut names) {
+            let text = node_text(parameter, source_code);
+            if contract.closes.iter().any(|closed| closed == text) {
+                owned.push(Open {
+                    name: text.to_string(),
+                    callee: String::new(),
+                    node: parameter,
+                    err: None,
+                    kind: Resource {
+                        response,
+                        owner: Some(node_text(name, source_code).to_string()),
+                    },
+                });
+            }
+        }
+    }
+    owned
+}
+
+/// Types declared in the file with a `Close` method.
+fn types_with_close(root: Node, source_code: &str) -> Vec<String> {
+    let mut found = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "method_declaration" {
+            return;
+        }
+        let is_close = node
+            .child_by_field_name("name")
+            .is_some_and(|name| node_text(name, source_code) == "Close");
+        let receiver_type = node
+            .child_by_field_name("receiver")
+            .and_then(|receiver| receiver.named_child(0))
+            .and_then(|parameter| parameter.child_by_field_name("type"));
+        if let (true, Some(ty)) = (is_close, receiver_type) {
+            found.push(
+                node_text(ty, source_code)
+                    .trim_start_matches('*')
+                    .to_string(),
+            );
+        }
+    });
+    found
+}
+
+/// Functions in the file whose first result is a closer, as call patterns:
+/// `name` for functions and `.name` for methods.
+fn returning_closers(
+    root: Node,
+    source_code: &str,
+    closers: &HashSet<String>,
+) -> Vec<(String, bool)> {
+    let mut found = Vec::new();
+    visit(root, &mut |node| {
+        let prefix = match node.kind() {
+            "function_declaration" => "",
+            "method_declaration" => ".",
+            _ => return,
+        };
+        let (Some(name), Some(result)) = (
+            node.child_by_field_name("name"),
+            node.child_by_field_name("result"),
+        ) else {
+            return;
+        };
+        let first = if result.kind() == "parameter_list" {
+            result
+                .named_child(0)
+                .and_then(|parameter| parameter.child_by_field_name("type"))
+        } else {
+            Some(result)
+        };
+        let Some(first) = first else {
+            return;
+        };
+        let ty = node_text(first, source_code).trim_start_matches('*');
+        let name = node_text(name, source_code);
+        if name == "Close" {
+            return;
+        }
+        if closers.contains(ty) {
+            found.push((format!("{}{}", prefix, name), false));
+        } else if ty == "http.Response" {
+            found.push((format!("{}{}", prefix, name), true));
+        }
+    });
+    found
+}
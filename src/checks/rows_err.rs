@@ -0,0 +1,78 @@
+use super::{enclosing_function, node_text, visit, Check, Granularity, Hit, RuleOptions};
+use tree_sitter::Node;
+
+/// Flags `for rows.Next()` loops that scan rows in a function that never
+/// checks `rows.Err()` after the loop.
+///
+/// `Next` returns false both at the end of the results and when fetching the
+/// next row fails, so without `Err` a broken connection reads as a short
+/// result set. The same holds for `database/sql`, sqlx and pgx rows, so any
+/// value with `Next` and `Scan` counts.
+pub struct GoRowsErr;
+
+impl Check for GoRowsErr {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if node.kind() != "for_statement" {
+                return;
+            }
+            let Some(condition) = node.named_child(0) else {
+                return;
+            };
+            let Some(rows) = method_call(condition, "Next", source_code) else {
+                return;
+            };
+            let Some(body) = node.child_by_field_name("body") else {
+                return;
+            };
+            if !calls(body, rows, "Scan", source_code) {
+                return;
+            }
+            let Some(function) = enclosing_function(node) else {
+                return;
+            };
+
+            let mut checked = false;
+            visit(function, &mut |call| {
+                checked |= call.start_byte() >= node.end_byte()
+                    && method_call(call, "Err", source_code) == Some(rows);
+            });
+            if !checked {
+                let message = format!(
+                    "`{0}.Err()` is not checked after the `{0}.Next()` loop, so a failed iteration looks like the end of the rows",
+                    rows
+                );
+                hits.push(Hit::new(condition).with_message(message));
+            }
+        });
+        hits
+    }
+
+    fn granularity(&self) -> Granularity {
+        Granularity::Declaration
+    }
+}
+
+/// The receiver of a `x.method()` call, when it's a plain identifier.
+fn method_call<'s>(node: Node, method: &str, source_code: &'s str) -> Option<&'s str> {
+    if node.kind() != "call_expression" {
+        return None;
+    }
+    let function = node.child_by_field_name("function")?;
+    if function.kind() != "selector_expression" {
+        return None;
+    }
+    let field = function.child_by_field_name("field")?;
+    let operand = function.child_by_field_name("operand")?;
+    (operand.kind() == "identifier" && node_text(field, source_code) == method)
+        .then(|| node_text(operand, source_code))
+}
+
+fn calls(root: Node, receiver: &str, method: &str, source_code: &str) -> bool {
+    let mut found = false;
+    visit(root, &mut |node| {
+        found |= method_call(node, method, source_code) == Some(receiver);
+    });
+    found
+}
@@ -0,0 +1,629 @@
+use super::api_misuse::imported_as;
+use super::doc_comment::is_checked;
+use super::import_policy::Pattern;
+use super::literal::{string_value, tag_pairs};
+use super::{
+    enclosing_function, node_text, receiver_type, unknown_option, visit, Check, Hit, OptionKind,
+    RuleOptions,
+};
+use crate::analyzer::Confidence;
+use crate::fix::{Fix, TextEdit};
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use std::collections::{HashMap, HashSet};
+use tree_sitter::{Node, Parser};
+
+/// Struct tags checked against how the fields are encoded, where
+/// [`super::literal`] checks their syntax:
+///
+/// - Exported fields without a `json` tag in a struct that is encoded as
+///   JSON, so renaming the field renames it on the wire. A struct is
+///   encoded when one of its fields has a `json` tag, or when a value of
+///   it is passed to encoding/json's `Marshal`, `MarshalIndent`,
+///   `Unmarshal` or an `Encoder`'s `Encode` or `Decoder`'s `Decode` in
+///   the package, as a literal, `new(T)` or a variable or parameter
+///   declared with the type. Structs with their own `MarshalJSON` and
+///   embedded fields are skipped. Only packages other modules can import
+///   are checked, or those `packages` matches. The fix adds a tag, in the
+///   style of the struct's other `json` names.
+/// - Fields whose names for different encodings disagree, such as
+///   `json:"email" db:"mail"`. Names are compared without case, `_` and
+///   `-`, so `user_id` and `userId` agree.
+/// - Unexported fields with a tag for an encoding, which ignores them.
+/// - `omitempty` on a `json` or `yaml` field that is a number or a bool,
+///   which drops a meaningful zero or `false` along with a missing value.
+///
+/// Options:
+/// - `packages` (untagged fields, default `[]`): package patterns, as
+///   `go_import_policy` takes them, to check.
+/// - `keys` (default `json`, `xml`, `yaml`, `toml`, `bson`, `db`,
+///   `mapstructure` and `msgpack`): the tag keys to compare and check on
+///   unexported fields.
+/// - `allow` (`omitempty`, default `[]`): field names whose zero may be
+///   dropped.
+pub struct GoSerialization {
+    issue: SerializationIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum SerializationIssue {
+    /// Exported fields of a JSON-encoded struct without a `json` tag.
+    Untagged,
+    /// Different names for one field in different encodings.
+    NameMismatch,
+    /// Encoding tags on unexported fields.
+    UnexportedTag,
+    /// `omitempty` on numbers and bools.
+    OmitEmptyZero,
+}
+
+impl GoSerialization {
+    pub fn new(issue: SerializationIssue) -> Self {
+        GoSerialization { issue }
+    }
+}
+
+pub(super) const UNTAGGED_OPTIONS: &[(&str, OptionKind)] = &[("packages", OptionKind::Strings)];
+
+pub(super) const KEY_OPTIONS: &[(&str, OptionKind)] = &[("keys", OptionKind::Strings)];
+
+pub(super) const OMITEMPTY_OPTIONS: &[(&str, OptionKind)] = &[("allow", OptionKind::Strings)];
+
+/// The tag keys of encodings that read exported fields by name.
+const ENCODING_KEYS: &[&str] = &[
+    "json",
+    "xml",
+    "yaml",
+    "toml",
+    "bson",
+    "db",
+    "mapstructure",
+    "msgpack",
+];
+
+/// The Go types whose zero value is a number or `false`.
+const ZERO_TYPES: &[&str] = &[
+    "bool", "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32",
+    "uint64", "uintptr", "byte", "rune", "float32", "float64",
+];
+
+/// Compiles `packages`, so that a bad pattern fails when the config loads.
+pub(super) fn api_packages(options: &RuleOptions) -> Result<Vec<Pattern>, String> {
+    if let Some(error) = options
+        .keys()
+        .find_map(|key| unknown_option(UNTAGGED_OPTIONS, key))
+    {
+        return Err(error);
+    }
+    options
+        .string_list("packages")
+        .unwrap_or_default()
+        .iter()
+        .map(|pattern| Pattern::compile(pattern).map_err(|e| format!("`packages`: {}", e)))
+        .collect()
+}
+
+impl Check for GoSerialization {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        self.issue == SerializationIssue::Untagged
+    }
+
+    // Like doc comments, internal packages and test files are told apart
+    // by the package's path.
+    fn needs_package(&self) -> bool {
+        self.issue == SerializationIssue::Untagged
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        match self.issue {
+            SerializationIssue::Untagged => untagged(root, source_code, options, package),
+            _ => {
+                let keys = options
+                    .string_list("keys")
+                    .unwrap_or_else(|| ENCODING_KEYS.iter().map(|key| key.to_string()).collect());
+                let allowed = options.string_list("allow").unwrap_or_default();
+                let mut hits = Vec::new();
+                visit(root, &mut |node| {
+                    if node.kind() != "field_declaration" {
+                        return;
+                    }
+                    let Some(field) = Field::read(node, source_code) else {
+                        return;
+                    };
+                    let hit = match self.issue {
+                        SerializationIssue::NameMismatch => field.mismatch(&keys),
+                        SerializationIssue::UnexportedTag => field.unexported(&keys),
+                        _ => field.omitempty(&allowed),
+                    };
+                    hits.extend(hit);
+                });
+                hits
+            }
+        }
+    }
+}
+
+/// A field declaration with a tag that parses.
+struct Field<'t, 's> {
+    names: Vec<&'s str>,
+    ty: &'s str,
+    tag: Node<'t>,
+    pairs: Vec<(String, String)>,
+}
+
+impl<'t, 's> Field<'t, 's> {
+    fn read(node: Node<'t>, source_code: &'s str) -> Option<Self> {
+        let tag = node.child_by_field_name("tag")?;
+        let pairs = tag_pairs(&string_value(tag, source_code)).ok()?;
+        let mut cursor = node.walk();
+        let names = node
+            .children_by_field_name("name", &mut cursor)
+            .map(|name| node_text(name, source_code))
+            .collect();
+        let ty = node
+            .child_by_field_name("type")
+            .map_or("", |ty| node_text(ty, source_code));
+        Some(Field {
+            names,
+            ty,
+            tag,
+            pairs,
+        })
+    }
+
+    /// The name `key` gives the field, with its options.
+    fn value(&self, key: &str) -> Option<&str> {
+        self.pairs
+            .iter()
+            .find(|(name, _)| name == key)
+            .map(|(_, value)| value.as_str())
+    }
+
+    fn mismatch(&self, keys: &[String]) -> Option<Hit<'t>> {
+        let names: Vec<(&str, &str)> = keys
+            .iter()
+            .filter_map(|key| {
+                let name = self.value(key)?.split(',').next()?;
+                // An xml path such as `a>b` names an element, not the field.
+                (!name.is_empty() && name != "-" && !name.contains('>'))
+                    .then_some((key.as_str(), name))
+            })
+            .collect();
+        let (first_key, first) = *names.first()?;
+        let (key, name) = names
+            .iter()
+            .find(|(_, name)| folded(name) != folded(first))?;
+        Some(Hit::new(self.tag).with_message(format!(
+            "the `{}` name `{}` and the `{}` name `{}` differ, so the field goes by different names in each",
+            first_key, first, key, name
+        )))
+    }
+
+    fn unexported(&self, keys: &[String]) -> Option<Hit<'t>> {
+        let name = self
+            .names
+            .iter()
+            .find(|name| !name.starts_with(|c: char| c.is_uppercase()))?;
+        let key = keys
+            .iter()
+            .find(|key| self.value(key).is_some_and(|value| value != "-"))?;
+        Some(Hit::new(self.tag).with_message(format!(
+            "`{}` is unexported, so encoders ignore its `{}` tag and it's never written or read",
+            name, key
+        )))
+    }
+
+    fn omitempty(&self, allowed: &[String]) -> Option<Hit<'t>> {
+        if !ZERO_TYPES.contains(&self.ty) {
+            return None;
+        }
+        let name = *self.names.first()?;
+        if allowed.iter().any(|allowed| allowed == name) {
+            return None;
+        }
+        let key = ["json", "yaml"].into_iter().find(|key| {
+            self.value(key)
+                .is_some_and(|value| value.split(',').skip(1).any(|option| option == "omitempty"))
+        })?;
+        let zero = if self.ty == "bool" { "false" } else { "0" };
+        Some(
+            Hit::new(self.tag)
+                .with_message(format!(
+                    "`omitempty` in the `{}` tag drops `{}` when it's {}, so a reader can't tell {} from missing; make it a `*{}` if {} means something",
+                    key, name, zero, zero, self.ty, zero
+                ))
+                .with_confidence(Confidence::Medium),
+        )
+    }
+}
+
+/// A name without case, `_` and `-`.
+fn folded(name: &str) -> String {
+    name.chars()
+        .filter(|c| *c != '_' && *c != '-')
+        .flat_map(char::to_lowercase)
+        .collect()
+}
+
+fn untagged<'t>(
+    root: Node<'t>,
+    source_code: &str,
+    options: &RuleOptions,
+    package: Option<&Package>,
+) -> Vec<Hit<'t>> {
+    let Ok(patterns) = api_packages(options) else {
+        return Vec::new();
+    };
+    if !is_checked(root, source_code, package, &patterns) {
+        return Vec::new();
+    }
+    let mut structs = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "type_spec" {
+            return;
+        }
+        if let (Some(name), Some(fields)) = (
+            node.child_by_field_name("name"),
+            node.child_by_field_name("type")
+                .filter(|ty| ty.kind() == "struct_type")
+                .and_then(|ty| ty.named_child(0)),
+        ) {
+            structs.push((node_text(name, source_code), fields));
+        }
+    });
+    if structs.is_empty() {
+        return Vec::new();
+    }
+
+    let mut encoding = Encoding::default();
+    encoding.collect(root, source_code);
+    if let Some(package) = package {
+        let mut parser = Parser::new();
+        if parser
+            .set_language(&SupportedLanguage::Go.tree_sitter_language())
+            .is_ok()
+        {
+            for file in &package.files {
+                if let Some(tree) = parser.parse(&file.source_code, None) {
+                    encoding.collect(tree.root_node(), &file.source_code);
+                }
+            }
+        }
+    }
+
+    let mut hits = Vec::new();
+    for (name, list) in structs {
+        if encoding.custom.contains(name) {
+            continue;
+        }
+        let mut cursor = list.walk();
+        let fields: Vec<Node> = list
+            .named_children(&mut cursor)
+            .filter(|field| field.kind() == "field_declaration")
+            .collect();
+        let json_names: Vec<String> = fields
+            .iter()
+            .filter_map(|field| Field::read(*field, source_code))
+            .filter_map(|field| {
+                let name = field.value("json")?.split(',').next()?.to_string();
+                (!name.is_empty() && name != "-").then_some(name)
+            })
+            .collect();
+        let encoded = encoding.encoded.contains(name);
+        if json_names.is_empty() && !encoded {
+            continue;
+        }
+        let style = Style::of(&json_names);
+        for field in fields {
+            let tag = field.child_by_field_name("tag");
+            if tag.is_some_and(|tag| {
+                tag_pairs(&string_value(tag, source_code))
+                    .map_or(true, |pairs| pairs.iter().any(|(key, _)| key == "json"))
+            }) {
+                continue;
+            }
+            let mut cursor = field.walk();
+            let names: Vec<Node> = field.children_by_field_name("name", &mut cursor).collect();
+            for field_name in &names {
+                let text = node_text(*field_name, source_code);
+                if !text.starts_with(|c: char| c.is_uppercase()) {
+                    continue;
+                }
+                let because = match encoded {
+                    true => "is encoded as JSON",
+                    false => "has `json` tags",
+                };
+                let mut hit = Hit::new(*field_name).with_message(format!(
+                    "`{}.{}` has no `json` tag, though `{}` {}, so it goes by `{}` and renaming it changes the JSON",
+                    name, text, name, because, text
+                ));
+                if names.len() == 1 {
+                    if let Some(fix) = tag_fix(field, tag, &style.name(text), source_code) {
+                        hit = hit.with_fix(fix);
+                    }
+                }
+                hits.push(hit);
+            }
+        }
+    }
+    hits
+}
+
+/// The edit adding `json:"name"` to `field`, or `None` when its tag is
+/// an interpreted string.
+fn tag_fix(field: Node, tag: Option<Node>, name: &str, source_code: &str) -> Option<Fix> {
+    let pair = format!("json:\"{}\"", name);
+    let edit = match tag {
+        Some(tag) if tag.kind() == "raw_string_literal" => {
+            let text = node_text(tag, source_code).trim_matches('`');
+            TextEdit {
+                start_byte: tag.end_byte() - 1,
+                end_byte: tag.end_byte() - 1,
+                replacement: match text.is_empty() {
+                    true => pair.clone(),
+                    false => format!(" {}", pair),
+                },
+            }
+        }
+        Some(_) => return None,
+        None => {
+            let ty = field.child_by_field_name("type")?;
+            TextEdit {
+                start_byte: ty.end_byte(),
+                end_byte: ty.end_byte(),
+                replacement: format!(" `{}`", pair),
+            }
+        }
+    };
+    Some(Fix {
+        description: format!("Tag it `{}`", pair),
+        edits: vec![edit],
+    })
+}
+
+/// How a struct's `json` names are written.
+enum Style {
+    /// `userId`, also the default.
+    Camel,
+    /// `user_id`.
+    Snake,
+    /// `UserID`, the field's own name.
+    Field,
+}
+
+impl Style {
+    fn of(names: &[String]) -> Self {
+        if names.iter().any(|name| name.contains('_')) {
+            Style::Snake
+        } else if names
+            .iter()
+            .any(|name| name.starts_with(|c: char| c.is_uppercase()))
+        {
+            Style::Field
+        } else {
+            Style::Camel
+        }
+    }
+
+    fn name(&self, field: &str) -> String {
+        let words = words(field);
+        match self {
+            Style::Field => field.to_string(),
+            Style::Snake => words
+                .iter()
+                .map(|word| word.to_lowercase())
+                .collect::<Vec<_>>()
+                .join("_"),
+            Style::Camel => {
+                let mut name = words
+                    .first()
+                    .map(|word| word.to_lowercase())
+                    .unwrap_or_default();
+                for word in &words[1.min(words.len())..] {
+                    name.push_str(word);
+                }
+                name
+            }
+        }
+    }
+}
+
+/// The words of a Go name, with initialisms kept together: `HTTPServerID`
+/// is `HTTP`, `Server`, `ID`.
+fn words(name: &str) -> Vec<String> {
+    let chars: Vec<char> = name.chars().collect();
+    let mut words: Vec<String> = Vec::new();
+    for (i, c) in chars.iter().enumerate() {
+        let starts = i > 0
+            && c.is_uppercase()
+            && (chars[i - 1].is_lowercase()
+                || chars[i - 1].is_ascii_digit()
+                || chars.get(i + 1).is_some_and(|next| next.is_lowercase()));
+        match words.last_mut() {
+            Some(word) if !starts && *c != '_' => word.push(*c),
+            _ if *c == '_' => words.push(String::new()),
+            _ => words.push(c.to_string()),
+        }
+    }
+    words.retain(|word| !word.is_empty());
+    words
+}
+
+/// What the package's files say about how its types are encoded.
+#[derive(Default)]
+struct Encoding {
+    /// Types passed to encoding/json.
+    encoded: HashSet<String>,
+    /// Types with their own `MarshalJSON`.
+    custom: HashSet<String>,
+}
+
+impl Encoding {
+    fn collect(&mut self, root: Node, source_code: &str) {
+        visit(root, &mut |node| {
+            if node.kind() == "method_declaration"
+                && node
+                    .child_by_field_name("name")
+                    .is_some_and(|name| node_text(name, source_code) == "MarshalJSON")
+            {
+                if let Some(receiver) = receiver_type(node, source_code) {
+                    self.custom.insert(receiver.to_string());
+                }
+            }
+        });
+        let Some(json) = imported_as(root, source_code, "encoding/json") else {
+            return;
+        };
+        visit(root, &mut |node| {
+            if node.kind() != "call_expression" {
+                return;
+            }
+            if let Some(value) = encoded_value(node, source_code, &json) {
+                if let Some(ty) = value_type(value, source_code) {
+                    self.encoded.insert(ty);
+                }
+            }
+        });
+    }
+}
+
+/// The value `call` encodes or decodes as JSON.
+fn encoded_value<'t>(call: Node<'t>, source_code: &str, json: &str) -> Option<Node<'t>> {
+    let function = call.child_by_field_name("function")?;
+    if function.kind() != "selector_expression" {
+        return None;
+    }
+    let operand = function.child_by_field_name("operand")?;
+    let method = node_text(function.child_by_field_name("field")?, source_code);
+    let arguments = call.child_by_field_name("arguments")?;
+    let argument = |n| arguments.named_child(n);
+    if operand.kind() == "identifier" && node_text(operand, source_code) == json {
+        return match method {
+            "Marshal" | "MarshalIndent" => argument(0),
+            "Unmarshal" => argument(1),
+            _ => None,
+        };
+    }
+    let constructor = match method {
+        "Encode" => "NewEncoder",
+        "Decode" => "NewDecoder",
+        _ => return None,
+    };
+    let stream = match operand.kind() {
+        "call_expression" => Some(operand),
+        "identifier" => declaration(operand, source_code).and_then(|(_, value)| value),
+        _ => None,
+    }?;
+    (node_text(stream.child_by_field_name("function")?, source_code)
+        == format!("{}.{}", json, constructor))
+    .then(|| argument(0))
+    .flatten()
+}
+
+/// The local type a value has, when it's evident: `T{}`, `&T{}`,
+/// `new(T)`, or a variable or parameter declared as a `T`, `*T` or `[]T`.
+fn value_type(value: Node, source_code: &str) -> Option<String> {
+    let ty = match value.kind() {
+        "unary_expression" => {
+            return value_type(value.child_by_field_name("operand")?, source_code)
+        }
+        "composite_literal" => value.child_by_field_name("type")?,
+        "call_expression"
+            if value
+                .child_by_field_name("function")
+                .is_some_and(|function| node_text(function, source_code) == "new") =>
+        {
+            value.child_by_field_name("arguments")?.named_child(0)?
+        }
+        "identifier" => match declaration(value, source_code)? {
+            (Some(ty), _) => ty,
+            (None, Some(value)) => return value_type(value, source_code),
+            (None, None) => return None,
+        },
+        _ => return None,
+    };
+    let name = node_text(ty, source_code)
+        .rsplit(']')
+        .next()?
+        .trim_start_matches('*');
+    name.chars()
+        .all(|c| c.is_alphanumeric() || c == '_')
+        .then(|| name.to_string())
+}
+
+/// Where the variable `identifier` is declared in its function: its type,
+/// when written, and its value.
+fn declaration<'t>(
+    identifier: Node<'t>,
+    source_code: &str,
+) -> Option<(Option<Node<'t>>, Option<Node<'t>>)> {
+    let name = node_text(identifier, source_code);
+    let function = enclosing_function(identifier)?;
+    let mut found: HashMap<&str, (Option<Node<'t>>, Option<Node<'t>>)> = HashMap::new();
+    visit(function, &mut |node| {
+        if node.start_byte() > identifier.start_byte() || found.contains_key(name) {
+            return;
+        }
+        match node.kind() {
+            "parameter_declaration" | "var_spec" => {
+                let mut cursor = node.walk();
+                let position = node
+                    .children_by_field_name("name", &mut cursor)
+                    .position(|declared| node_text(declared, source_code) == name);
+                if let Some(position) = position {
+                    let value = node
+                        .child_by_field_name("value")
+                        .and_then(|values| values.named_child(position));
+                    found.insert(name, (node.child_by_field_name("type"), value));
+                }
+            }
+            "short_var_declaration" => {
+                let (Some(left), Some(right)) = (
+                    node.child_by_field_name("left"),
+                    node.child_by_field_name("right"),
+                ) else {
+                    return;
+                };
+                let mut cursor = left.walk();
+                let position = left
+                    .named_children(&mut cursor)
+                    .position(|declared| node_text(declared, source_code) == name);
+                if let Some(position) = position {
+                    found.insert(name, (None, right.named_child(position)));
+                }
+            }
+            _ => {}
+        }
+    });
+    found.remove(name)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_json_names_follow_the_struct_style() {
+        assert_eq!(words("HTTPServerID"), ["HTTP", "Server", "ID"]);
+        assert_eq!(words("user_name"), ["user", "name"]);
+        assert_eq!(Style::Camel.name("UserID"), "userID");
+        assert_eq!(Style::Camel.name("ID"), "id");
+        assert_eq!(Style::Snake.name("HTTPServerID"), "http_server_id");
+        assert_eq!(Style::Field.name("UserID"), "UserID");
+        assert_eq!(folded("user_id"), folded("userId"));
+        assert!(matches!(
+            Style::of(&["created_at".to_string(), "id".to_string()]),
+            Style::Snake
+        ));
+    }
+}
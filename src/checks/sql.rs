@@ -0,0 +1,460 @@
+use super::taint::SQL_SINKS;
+use super::unused_import::import_path;
+use super::{enclosing_function, node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::package::Package;
+use crate::sql::{self, Dialect};
+use crate::taint::{matches_pattern, Sink};
+use std::collections::HashMap;
+use tree_sitter::Node;
+
+/// Query strings passed to `database/sql`, sqlx, pgx and gorm query and
+/// exec methods.
+///
+/// The query is followed through string constants and the assignments of
+/// the enclosing function, so `q := "SELECT ..." + name; db.Query(q)` is
+/// seen as built by concatenation, and a constant query is checked with
+/// [`crate::sql`]. A leading `ctx` argument, as pgx methods take, is
+/// skipped over.
+///
+/// Every rule takes the same options:
+/// - `dialect` (`postgres`, `mysql` or `sqlite`): the SQL dialect. By
+///   default it follows the driver the package imports (`lib/pq` and pgx,
+///   `go-sql-driver/mysql`, `go-sqlite3` and `modernc.org/sqlite`), and
+///   without one only what every dialect rejects is reported.
+/// - `methods`: extra query calls, as `pattern:N` with `N` the index of the
+///   query argument.
+pub struct GoSqlQuery {
+    issue: SqlIssue,
+}
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("dialect", OptionKind::String),
+    ("methods", OptionKind::Strings),
+];
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum SqlIssue {
+    /// Queries built with `+` or `fmt.Sprintf` instead of parameters.
+    Concatenation,
+    /// Constant queries that aren't valid SQL.
+    Syntax,
+    /// Constant queries selecting `*`, outside tests.
+    SelectStar,
+}
+
+impl GoSqlQuery {
+    pub fn new(issue: SqlIssue) -> Self {
+        GoSqlQuery { issue }
+    }
+}
+
+/// sqlx methods, on top of the `database/sql` ones the taint rule uses.
+const SQLX_METHODS: &[&str] = &[
+    ".Get:1",
+    ".Select:1",
+    ".GetContext:2",
+    ".SelectContext:2",
+    ".MustExec:0",
+    ".MustExecContext:1",
+    ".Queryx:0",
+    ".QueryRowx:0",
+    ".QueryxContext:1",
+    ".QueryRowxContext:1",
+    ".NamedExec:0",
+    ".NamedQuery:0",
+    ".NamedExecContext:1",
+    ".NamedQueryContext:1",
+    ".Preparex:0",
+    ".PreparexContext:1",
+];
+
+/// Driver imports and the dialect they speak.
+const DRIVERS: &[(&str, Dialect)] = &[
+    ("\"github.com/lib/pq\"", Dialect::Postgres),
+    ("\"github.com/jackc/pgx", Dialect::Postgres),
+    ("\"github.com/go-sql-driver/mysql\"", Dialect::MySql),
+    ("\"github.com/mattn/go-sqlite3\"", Dialect::Sqlite),
+    ("\"modernc.org/sqlite\"", Dialect::Sqlite),
+];
+
+/// What a query argument evaluates to, as far as the file shows.
+enum Query {
+    Constant(String),
+    /// Built at run time; `how` names the non-constant part and `text`
+    /// joins the constant ones.
+    Built {
+        how: String,
+        text: String,
+    },
+    Unknown,
+}
+
+impl Check for GoSqlQuery {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let dialect = match options.string("dialect") {
+            Some(name) => Dialect::parse(name),
+            None => driver_dialect(source_code, package),
+        };
+        let mut methods: Vec<Sink> = SQL_SINKS
+            .iter()
+            .chain(SQLX_METHODS)
+            .map(|method| Sink::parse(method))
+            .collect();
+        methods.extend(
+            options
+                .string_list("methods")
+                .unwrap_or_default()
+                .iter()
+                .map(|method| Sink::parse(method)),
+        );
+        if self.issue == SqlIssue::SelectStar && is_test_code(root, source_code, package) {
+            return Vec::new();
+        }
+
+        let resolver = Resolver::new(root, source_code);
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if node.kind() != "call_expression" {
+                return;
+            }
+            let (Some(function), Some(arguments)) = (
+                node.child_by_field_name("function"),
+                node.child_by_field_name("arguments"),
+            ) else {
+                return;
+            };
+            let callee = node_text(function, source_code);
+            let Some(method) = methods.iter().find(|m| matches_pattern(callee, &m.pattern)) else {
+                return;
+            };
+            let mut cursor = arguments.walk();
+            let args: Vec<Node> = arguments
+                .named_children(&mut cursor)
+                .filter(|arg| arg.kind() != "comment")
+                .collect();
+            let mut index = method.argument.unwrap_or(0);
+            if args
+                .get(index)
+                .is_some_and(|arg| is_context(*arg, source_code))
+            {
+                index += 1;
+            }
+            let Some(query) = args.get(index) else {
+                return;
+            };
+
+            match (self.issue, resolver.resolve(*query, node.start_byte(), 0)) {
+                (SqlIssue::Concatenation, Query::Built { how, text }) if looks_like_sql(&text) => {
+                    let placeholder = match dialect {
+                        Some(dialect) => format!("`{}`", dialect.placeholder()),
+                        None => "`?` or `$1`".to_string(),
+                    };
+                    let message = format!(
+                        "the query passed to `{}` is built by {}; pass the values as parameters ({}) instead",
+                        callee, how, placeholder
+                    );
+                    hits.push(Hit::new(*query).with_message(message));
+                }
+                (SqlIssue::Syntax, Query::Constant(text)) if sql::is_statement(&text) => {
+                    if let Err(problem) = sql::lint(&text, dialect) {
+                        let language = dialect.map_or("SQL", |dialect| dialect.name());
+                        let message = format!(
+                            "the query passed to `{}` is not valid {}: {}",
+                            callee, language, problem
+                        );
+                        hits.push(Hit::new(*query).with_message(message));
+                    }
+                }
+                (SqlIssue::SelectStar, Query::Constant(text))
+                    if sql::is_statement(&text) && sql::selects_star(&text, dialect) =>
+                {
+                    let message = format!(
+                        "the query passed to `{}` selects `*`, so adding or reordering columns breaks the scan; list the columns",
+                        callee
+                    );
+                    hits.push(Hit::new(*query).with_message(message));
+                }
+                _ => {}
+            }
+        });
+        hits
+    }
+}
+
+fn driver_dialect(source_code: &str, package: Option<&Package>) -> Option<Dialect> {
+    let mut sources = vec![source_code];
+    if let Some(package) = package {
+        sources.extend(package.files.iter().map(|file| file.source_code.as_str()));
+    }
+    DRIVERS
+        .iter()
+        .find(|(import, _)| sources.iter().any(|source| source.contains(import)))
+        .map(|(_, dialect)| *dialect)
+}
+
+/// A `_test.go` file, or one importing `testing`.
+fn is_test_code(root: Node, source_code: &str, package: Option<&Package>) -> bool {
+    let test_file = package
+        .and_then(|package| package.path.file_name())
+        .is_some_and(|name| name.to_string_lossy().ends_with("_test.go"));
+    let mut imports_testing = false;
+    visit(root, &mut |node| {
+        imports_testing |=
+            node.kind() == "import_spec" && import_path(node, source_code) == Some("testing");
+    });
+    test_file || imports_testing
+}
+
+/// `ctx`, `r.Context()`, `context.Background()` and the like.
+fn is_context(node: Node, source_code: &str) -> bool {
+    let text = node_text(node, source_code);
+    match node.kind() {
+        "identifier" => text == "ctx" || text.ends_with("Ctx"),
+        "call_expression" => {
+            text.starts_with("context.") || text.ends_with(".Context()") || text.ends_with("Ctx()")
+        }
+        _ => false,
+    }
+}
+
+/// Constant text that reads like part of a query.
+fn looks_like_sql(text: &str) -> bool {
+    sql::is_statement(text)
+        || text.split(|c: char| !c.is_alphanumeric()).any(|word| {
+            matches!(
+                word.to_uppercase().as_str(),
+                "FROM" | "WHERE" | "VALUES" | "INTO" | "SET"
+            )
+        })
+}
+
+struct Resolver<'t, 's> {
+    source_code: &'s str,
+    /// Constant values by name, from every `const` in the file.
+    constants: HashMap<&'s str, Node<'t>>,
+}
+
+impl<'t, 's> Resolver<'t, 's> {
+    fn new(root: Node<'t>, source_code: &'s str) -> Self {
+        let mut constants = HashMap::new();
+        visit(root, &mut |node| {
+            if node.kind() != "const_spec" {
+                return;
+            }
+            let Some(values) = node.child_by_field_name("value") else {
+                return;
+            };
+            let mut names = node.walk();
+            let mut cursor = values.walk();
+            let values: Vec<Node> = values.named_children(&mut cursor).collect();
+            for (name, value) in node.children_by_field_name("name", &mut names).zip(values) {
+                constants.insert(node_text(name, source_code), value);
+            }
+        });
+        Resolver {
+            source_code,
+            constants,
+        }
+    }
+
+    fn text(&self, node: Node) -> &'s str {
+        node_text(node, self.source_code)
+    }
+
+    /// What `node` evaluates to at byte `at`. `depth` bounds the chase
+    /// through variables.
+    fn resolve(&self, node: Node<'t>, at: usize, depth: usize) -> Query {
+        if depth > 8 {
+            return Query::Unknown;
+        }
+        match node.kind() {
+            "interpreted_string_literal" => Query::Constant(unescape(self.text(node))),
+            "raw_string_literal" => Query::Constant(self.text(node).trim_matches('`').to_string()),
+            "parenthesized_expression" => match node.named_child(0) {
+                Some(inner) => self.resolve(inner, at, depth + 1),
+                None => Query::Unknown,
+            },
+            "binary_expression" => {
+                let (Some(left), Some(right), Some(operator)) = (
+                    node.child_by_field_name("left"),
+                    node.child_by_field_name("right"),
+                    node.child_by_field_name("operator"),
+                ) else {
+                    return Query::Unknown;
+                };
+                if self.text(operator) != "+" {
+                    return Query::Unknown;
+                }
+                let parts = [
+                    (left, self.resolve(left, at, depth + 1)),
+                    (right, self.resolve(right, at, depth + 1)),
+                ];
+                let mut text = String::new();
+                let mut how = None;
+                for (part, query) in parts {
+                    match query {
+                        Query::Constant(constant) => text.push_str(&constant),
+                        Query::Built {
+                            how: built,
+                            text: constant,
+                        } => {
+                            text.push_str(&constant);
+                            how.get_or_insert(built);
+                        }
+                        Query::Unknown => {
+                            how.get_or_insert(format!("concatenating `{}`", self.text(part)));
+                        }
+                    }
+                }
+                match how {
+                    Some(how) => Query::Built { how, text },
+                    None => Query::Constant(text),
+                }
+            }
+            "call_expression" => {
+                let function = node.child_by_field_name("function").map(|f| self.text(f));
+                let mut cursor = node.walk();
+                let args: Vec<Node> = node
+                    .child_by_field_name("arguments")
+                    .map(|arguments| arguments.named_children(&mut cursor).collect())
+                    .unwrap_or_default();
+                match (function, args.first()) {
+                    (Some("fmt.Sprintf"), Some(format)) if args.len() > 1 => {
+                        let text = match self.resolve(*format, at, depth + 1) {
+                            Query::Constant(text) => text,
+                            _ => String::new(),
+                        };
+                        Query::Built {
+                            how: "calling `fmt.Sprintf`".to_string(),
+                            text,
+                        }
+                    }
+                    _ => Query::Unknown,
+                }
+            }
+            "identifier" => {
+                let name = self.text(node);
+                if let Some(value) = self.constants.get(name) {
+                    return self.resolve(*value, at, depth + 1);
+                }
+                self.resolve_variable(node, name, at, depth)
+            }
+            _ => Query::Unknown,
+        }
+    }
+
+    /// A variable, from its assignments before `at` in the enclosing
+    /// function. One constant assignment makes it constant; any built
+    /// value or `+=` of a non-constant one makes it built.
+    fn resolve_variable(&self, node: Node<'t>, name: &str, at: usize, depth: usize) -> Query {
+        let Some(function) = enclosing_function(node) else {
+            return Query::Unknown;
+        };
+        let mut assignments = Vec::new();
+        visit(function, &mut |statement| {
+            if statement.start_byte() >= at
+                || !matches!(
+                    statement.kind(),
+                    "short_var_declaration" | "assignment_statement" | "var_spec"
+                )
+            {
+                return;
+            }
+            let mut cursor = statement.walk();
+            let (targets, values): (Vec<Node>, _) = match statement.kind() {
+                "var_spec" => (
+                    statement
+                        .children_by_field_name("name", &mut cursor)
+                        .collect(),
+                    statement.child_by_field_name("value"),
+                ),
+                _ => (
+                    statement
+                        .child_by_field_name("left")
+                        .map(|left| left.named_children(&mut cursor).collect())
+                        .unwrap_or_default(),
+                    statement.child_by_field_name("right"),
+                ),
+            };
+            let Some(values) = values else {
+                return;
+            };
+            let mut cursor = values.walk();
+            let values: Vec<Node> = values.named_children(&mut cursor).collect();
+            let appends = statement
+                .child_by_field_name("operator")
+                .is_some_and(|operator| self.text(operator) == "+=");
+            for (target, value) in targets.iter().zip(values) {
+                if self.text(*target) == name {
+                    assignments.push((value, appends));
+                }
+            }
+        });
+
+        // The constant parts of every assignment, so the SQL in
+        // `q := "SELECT ..."; q += " AND " + tag` is seen.
+        let mut text = String::new();
+        let mut how = None;
+        let mut plain = 0;
+        for (value, appends) in &assignments {
+            plain += usize::from(!appends);
+            match self.resolve(*value, value.start_byte(), depth + 1) {
+                Query::Constant(part) => text.push_str(&part),
+                Query::Built {
+                    how: built,
+                    text: part,
+                } => {
+                    text.push_str(&part);
+                    how.get_or_insert(built);
+                }
+                Query::Unknown if *appends => {
+                    how.get_or_insert(format!("appending `{}`", self.text(*value)));
+                }
+                Query::Unknown => return Query::Unknown,
+            }
+        }
+        match how {
+            Some(how) => Query::Built { how, text },
+            // Assigned in more than one place, the value depends on the path.
+            None if plain == 1 => Query::Constant(text),
+            None => Query::Unknown,
+        }
+    }
+}
+
+/// The value of an interpreted string literal.
+pub(super) fn unescape(literal: &str) -> String {
+    let inner = literal
+        .strip_prefix('"')
+        .and_then(|s| s.strip_suffix('"'))
+        .unwrap_or(literal);
+    let mut value = String::new();
+    let mut chars = inner.chars();
+    while let Some(c) = chars.next() {
+        if c != '\\' {
+            value.push(c);
+            continue;
+        }
+        match chars.next() {
+            Some('n') => value.push('\n'),
+            Some('t') => value.push('\t'),
+            Some(other) => value.push(other),
+            None => {}
+        }
+    }
+    value
+}
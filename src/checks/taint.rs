@@ -0,0 +1,173 @@
+use super::{Check, Hit, OptionKind, RuleOptions};
+use crate::taint::{find_flows, Sink, TaintSpec};
+use tree_sitter::Node;
+
+/// Untrusted input reaching a dangerous call, on top of [`crate::taint`].
+///
+/// Each kind ships default sources, sinks and sanitizers. A rule's
+/// `sources`, `sinks` and `sanitizers` options add to them, or replace them
+/// when `replace_defaults = true`.
+pub struct GoTaint {
+    kind: TaintKind,
+}
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("sources", OptionKind::Strings),
+    ("sinks", OptionKind::Strings),
+    ("sanitizers", OptionKind::Strings),
+    ("replace_defaults", OptionKind::Bool),
+];
+
+#[derive(Clone, Copy)]
+pub enum TaintKind {
+    Sql,
+    Command,
+    Path,
+    Template,
+}
+
+impl GoTaint {
+    pub fn new(kind: TaintKind) -> Self {
+        GoTaint { kind }
+    }
+
+    fn spec(&self, options: &RuleOptions) -> TaintSpec {
+        let (sinks, sanitizers): (&[&str], &[&str]) = match self.kind {
+            TaintKind::Sql => (SQL_SINKS, SQL_SANITIZERS),
+            TaintKind::Command => (COMMAND_SINKS, &[]),
+            TaintKind::Path => (PATH_SINKS, PATH_SANITIZERS),
+            TaintKind::Template => (TEMPLATE_SINKS, TEMPLATE_SANITIZERS),
+        };
+
+        let mut spec = TaintSpec::default();
+        if !options.bool("replace_defaults").unwrap_or(false) {
+            spec.sources = to_strings(SOURCES);
+            spec.sinks = sinks.iter().map(|s| Sink::parse(s)).collect();
+            spec.sanitizers = to_strings(CONVERSIONS);
+            spec.sanitizers.extend(to_strings(sanitizers));
+        }
+        spec.sources
+            .extend(options.string_list("sources").unwrap_or_default());
+        spec.sinks.extend(
+            options
+                .string_list("sinks")
+                .unwrap_or_default()
+                .iter()
+                .map(|s| Sink::parse(s)),
+        );
+        spec.sanitizers
+            .extend(options.string_list("sanitizers").unwrap_or_default());
+        spec
+    }
+}
+
+impl Check for GoTaint {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        find_flows(root, source_code, &self.spec(options))
+            .into_iter()
+            .map(Hit::new)
+            .collect()
+    }
+}
+
+fn to_strings(patterns: &[&str]) -> Vec<String> {
+    patterns.iter().map(|p| p.to_string()).collect()
+}
+
+const SOURCES: &[&str] = &[
+    // net/http requests and the common routers.
+    ".FormValue",
+    ".PostFormValue",
+    ".FormFile",
+    ".Form",
+    ".PostForm",
+    ".URL.Query",
+    ".URL.Path",
+    ".URL.RawQuery",
+    ".Header.Get",
+    ".Header.Values",
+    ".Cookie",
+    ".PathValue",
+    ".Body",
+    "mux.Vars",
+    ".Param",
+    ".DefaultQuery",
+    // Environment.
+    "os.Getenv",
+    "os.LookupEnv",
+    "os.Environ",
+    "os.Args",
+    // File contents.
+    "os.ReadFile",
+    "ioutil.ReadFile",
+    "io.ReadAll",
+    "ioutil.ReadAll",
+    ".ReadString",
+    ".ReadBytes",
+    ".ReadLine",
+];
+
+/// Parsing into a number or boolean leaves nothing to inject.
+const CONVERSIONS: &[&str] = &[
+    "strconv.Atoi",
+    "strconv.ParseInt",
+    "strconv.ParseUint",
+    "strconv.ParseFloat",
+    "strconv.ParseBool",
+    "uuid.Parse",
+];
+
+pub(super) const SQL_SINKS: &[&str] = &[
+    ".Exec:0",
+    ".Query:0",
+    ".QueryRow:0",
+    ".Prepare:0",
+    ".ExecContext:1",
+    ".QueryContext:1",
+    ".QueryRowContext:1",
+    ".PrepareContext:1",
+    ".Raw:0",
+];
+
+const SQL_SANITIZERS: &[&str] = &["pq.QuoteIdentifier", "pq.QuoteLiteral"];
+
+const COMMAND_SINKS: &[&str] = &[
+    "exec.Command",
+    "exec.CommandContext",
+    "syscall.Exec",
+    "os.StartProcess",
+];
+
+const PATH_SINKS: &[&str] = &[
+    "os.Open:0",
+    "os.OpenFile:0",
+    "os.Create:0",
+    "os.ReadFile:0",
+    "os.WriteFile:0",
+    "os.ReadDir:0",
+    "os.Remove:0",
+    "os.RemoveAll:0",
+    "os.Mkdir:0",
+    "os.MkdirAll:0",
+    "ioutil.ReadFile:0",
+    "ioutil.WriteFile:0",
+    "http.ServeFile:2",
+];
+
+const PATH_SANITIZERS: &[&str] = &["filepath.Base", "path.Base"];
+
+const TEMPLATE_SINKS: &[&str] = &[
+    "template.HTML",
+    "template.HTMLAttr",
+    "template.JS",
+    "template.CSS",
+    "template.URL",
+];
+
+const TEMPLATE_SANITIZERS: &[&str] = &[
+    "html.EscapeString",
+    "template.HTMLEscapeString",
+    "template.JSEscapeString",
+    "url.QueryEscape",
+    "url.PathEscape",
+];
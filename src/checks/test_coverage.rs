@@ -0,0 +1,275 @@
+use super::{
+    is_exported, matches_name, node_text, receiver_type, visit, Check, Hit, OptionKind, RuleOptions,
+};
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use crate::project::is_generated;
+use globset::Glob;
+use std::collections::{HashMap, HashSet};
+use tree_sitter::{Node, Parser};
+
+/// Flags exported functions and methods that no test in the package calls,
+/// directly or through other functions of the package.
+///
+/// References are syntactic: any identifier or selector in a `_test.go` file
+/// counts as a test reference, and a tested function's body passes the
+/// reference on to everything it names. Methods are matched by name alone,
+/// so a tested `Close` on one type covers every `Close`. Without a package
+/// (an unsaved buffer, say) the check reports nothing.
+///
+/// Options:
+/// - `min_coverage` (default `100`): the percentage of the file's exported
+///   functions that must be tested before untested ones stop being reported.
+/// - `min_statements` (default `1`): functions with fewer statements are
+///   too small to need a test.
+/// - `allow_getters` (default `true`): skips functions whose body only
+///   returns a field or variable.
+/// - `exclude` (default `[]`): function names, or prefixes ending in `*`.
+/// - `exclude_files` (default `["*.pb.go"]`): glob patterns matched against
+///   the file name. Files with a `// Code generated ... DO NOT EDIT.` header
+///   are always skipped.
+pub struct GoTestCoverage;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("exclude", OptionKind::Strings),
+    ("exclude_files", OptionKind::Strings),
+    ("allow_getters", OptionKind::Bool),
+    ("min_coverage", OptionKind::Count),
+    ("min_statements", OptionKind::Count),
+];
+
+const DEFAULT_EXCLUDED_FILES: &[&str] = &["*.pb.go"];
+
+impl Check for GoTestCoverage {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn needs_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let Some(package) = package else {
+            return Vec::new();
+        };
+        let file_name = package
+            .path
+            .file_name()
+            .map(|name| name.to_string_lossy().into_owned())
+            .unwrap_or_default();
+        let excluded_files = options.string_list("exclude_files").unwrap_or_else(|| {
+            DEFAULT_EXCLUDED_FILES
+                .iter()
+                .map(|s| s.to_string())
+                .collect()
+        });
+        let excluded_file = excluded_files.iter().any(|pattern| {
+            Glob::new(pattern).is_ok_and(|glob| glob.compile_matcher().is_match(&file_name))
+        });
+        if is_test_file(&file_name) || excluded_file || is_generated(source_code) {
+            return Vec::new();
+        }
+
+        let min_coverage = options.usize("min_coverage").unwrap_or(100);
+        let min_statements = options.usize("min_statements").unwrap_or(1);
+        let allow_getters = options.bool("allow_getters").unwrap_or(true);
+        let excluded = options.string_list("exclude").unwrap_or_default();
+
+        let candidates: Vec<(Node<'t>, &str)> = exported_functions(root, source_code)
+            .into_iter()
+            .filter(|(function, name)| {
+                let body = statements(function.child_by_field_name("body"));
+                body.len() >= min_statements
+                    && !(allow_getters && is_getter(&body))
+                    && !excluded.iter().any(|pattern| matches_name(name, pattern))
+            })
+            .collect();
+        if candidates.is_empty() {
+            return Vec::new();
+        }
+
+        let tested = tested_names(root, source_code, package);
+        let untested: Vec<_> = candidates
+            .iter()
+            .filter(|(_, name)| !tested.contains(*name))
+            .collect();
+        let covered = candidates.len() - untested.len();
+        if covered * 100 >= min_coverage * candidates.len() {
+            return Vec::new();
+        }
+
+        untested
+            .into_iter()
+            .filter_map(|(function, name)| {
+                let node = function.child_by_field_name("name")?;
+                let message = format!(
+                    "`{}` is not called by any test, directly or through other functions",
+                    name
+                );
+                Some(Hit::new(node).with_message(message))
+            })
+            .collect()
+    }
+}
+
+fn is_test_file(file_name: &str) -> bool {
+    file_name.ends_with("_test.go")
+}
+
+/// Exported functions, and exported methods of exported types.
+pub(super) fn exported_functions<'t, 's>(
+    root: Node<'t>,
+    source_code: &'s str,
+) -> Vec<(Node<'t>, &'s str)> {
+    let mut found = Vec::new();
+    let mut cursor = root.walk();
+    for node in root.named_children(&mut cursor) {
+        if !matches!(node.kind(), "function_declaration" | "method_declaration") {
+            continue;
+        }
+        let Some(name) = node.child_by_field_name("name") else {
+            continue;
+        };
+        let name = node_text(name, source_code);
+        let receiver_exported = receiver_type(node, source_code).is_none_or(is_exported);
+        if is_exported(name) && receiver_exported {
+            found.push((node, name));
+        }
+    }
+    found
+}
+
+/// The statements of a block, looking through the `statement_list` newer
+/// grammars wrap them in.
+pub(super) fn statements(block: Option<Node>) -> Vec<Node> {
+    let Some(block) = block else {
+        return Vec::new();
+    };
+    let mut found = Vec::new();
+    let mut cursor = block.walk();
+    for child in block.named_children(&mut cursor) {
+        match child.kind() {
+            "comment" => {}
+            "statement_list" => {
+                let mut inner = child.walk();
+                found.extend(
+                    child
+                        .named_children(&mut inner)
+                        .filter(|statement| statement.kind() != "comment"),
+                );
+            }
+            _ => found.push(child),
+        }
+    }
+    found
+}
+
+/// A body that only returns a field or a variable.
+fn is_getter(body: &[Node]) -> bool {
+    let [statement] = body else {
+        return false;
+    };
+    if statement.kind() != "return_statement" {
+        return false;
+    }
+    let Some(values) = statement.named_child(0) else {
+        return false;
+    };
+    let mut cursor = values.walk();
+    let mut values = values.named_children(&mut cursor);
+    values.all(|value| matches!(value.kind(), "selector_expression" | "identifier"))
+}
+
+/// Names referenced from the package's tests, followed through the bodies
+/// of the functions they name.
+fn tested_names(root: Node, source_code: &str, package: &Package) -> HashSet<String> {
+    let mut tests = HashSet::new();
+    let mut bodies: HashMap<String, HashSet<String>> = HashMap::new();
+    collect_bodies(root, source_code, &mut bodies);
+
+    let mut parser = Parser::new();
+    if parser
+        .set_language(&SupportedLanguage::Go.tree_sitter_language())
+        .is_err()
+    {
+        return tests;
+    }
+    for file in &package.files {
+        let Some(tree) = parser.parse(&file.source_code, None) else {
+            continue;
+        };
+        let file_name = file.path.file_name().unwrap_or_default().to_string_lossy();
+        if is_test_file(&file_name) {
+            tests.extend(references(tree.root_node(), &file.source_code));
+        } else {
+            collect_bodies(tree.root_node(), &file.source_code, &mut bodies);
+        }
+    }
+
+    let mut pending: Vec<String> = tests.iter().cloned().collect();
+    while let Some(name) = pending.pop() {
+        for reference in bodies.get(&name).into_iter().flatten() {
+            if tests.insert(reference.clone()) {
+                pending.push(reference.clone());
+            }
+        }
+    }
+    tests
+}
+
+/// What each function and method in the file names in its body.
+fn collect_bodies(root: Node, source_code: &str, bodies: &mut HashMap<String, HashSet<String>>) {
+    let mut cursor = root.walk();
+    for node in root.named_children(&mut cursor) {
+        if !matches!(node.kind(), "function_declaration" | "method_declaration") {
+            continue;
+        }
+        let (Some(name), Some(body)) = (
+            node.child_by_field_name("name"),
+            node.child_by_field_name("body"),
+        ) else {
+            continue;
+        };
+        bodies
+            .entry(node_text(name, source_code).to_string())
+            .or_default()
+            .extend(references(body, source_code));
+    }
+}
+
+fn references(root: Node, source_code: &str) -> HashSet<String> {
+    let mut names = HashSet::new();
+    visit(root, &mut |node| {
+        if matches!(node.kind(), "identifier" | "field_identifier") {
+            names.insert(node_text(node, source_code).to_string());
+        }
+    });
+    names
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_generated_header_must_precede_the_package_clause() {
+        assert!(is_generated(
+            "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage api\n"
+        ));
+        assert!(!is_generated(
+            "package api\n\n// Code generated by hand. DO NOT EDIT.\n"
+        ));
+        assert!(!is_generated("// Code generated by hand.\npackage api\n"));
+    }
+}
@@ -0,0 +1,479 @@
+use super::panic::enclosing_declaration;
+use super::{enclosing_function, imports, node_text, visit, Check, Hit, RuleOptions};
+use crate::fix::{Fix, TextEdit};
+use std::collections::HashMap;
+use tree_sitter::Node;
+
+/// Mistakes in Go tests, in files that import `testing`:
+///
+/// - A subtest of a parallel test or subtest that doesn't call
+///   `t.Parallel()` itself runs on its own. Subtests calling `t.Setenv` or
+///   `t.Chdir`, which parallel tests can't, are fine.
+/// - `time.Sleep` in a test or test helper waits a fixed time for
+///   something that happens in its own time.
+/// - `t.Fatal`, `t.FailNow` and `t.Skip` and their forms, called in a
+///   goroutine the test starts with `go` or an errgroup-style `Go`, only
+///   stop that goroutine.
+/// - A helper taking a `*testing.T`, `*testing.B`, `*testing.F` or
+///   `testing.TB` that reports failures without calling `t.Helper()`
+///   makes them point at itself. The fix adds the call.
+/// - `err.Error()` compared with `==`, `!=` or testify's `Equal`,
+///   `NotEqual` and `EqualError` checks the message, not the error.
+///
+/// `time` and `testing` are followed through their import names.
+pub struct GoTesting {
+    issue: TestIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum TestIssue {
+    /// Subtests of parallel tests that aren't parallel.
+    ParallelSubtest,
+    /// `time.Sleep` in tests.
+    Sleep,
+    /// `t.Fatal` and the like in goroutines.
+    FatalInGoroutine,
+    /// Helpers without `t.Helper()`.
+    MissingHelper,
+    /// Errors compared by their message.
+    ErrorString,
+}
+
+impl GoTesting {
+    pub fn new(issue: TestIssue) -> Self {
+        GoTesting { issue }
+    }
+}
+
+const TEST_PREFIXES: &[&str] = &["Test", "Benchmark", "Fuzz", "Example"];
+
+/// Methods that end the test, or the goroutine they run in.
+const STOPPING_METHODS: &[&str] = &["Fatal", "Fatalf", "FailNow", "Skip", "Skipf", "SkipNow"];
+
+/// Methods that report a failure at the caller's line.
+const REPORTING_METHODS: &[&str] = &["Error", "Errorf", "Fatal", "Fatalf"];
+
+/// Methods a parallel test can't call.
+const SERIAL_METHODS: &[&str] = &["Setenv", "Chdir"];
+
+/// testify assertions that compare errors by message.
+const STRING_ASSERTIONS: &[&str] = &["Equal", "NotEqual", "EqualError"];
+
+impl Check for GoTesting {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+        // By path, the other way around from `imports`.
+        let imports: HashMap<String, String> = imports(root, source_code)
+            .into_iter()
+            .map(|(local, path)| (path, local))
+            .collect();
+        let Some(testing) = imports.get("testing") else {
+            return Vec::new();
+        };
+        let file = TestFile {
+            source_code,
+            testing,
+        };
+        match self.issue {
+            TestIssue::ParallelSubtest => parallel_subtests(root, &file),
+            TestIssue::Sleep => match imports.get("time") {
+                Some(time) => sleeps(root, &file, time),
+                None => Vec::new(),
+            },
+            TestIssue::FatalInGoroutine => goroutine_fatals(root, &file),
+            TestIssue::MissingHelper => missing_helpers(root, &file),
+            TestIssue::ErrorString => {
+                let testify: Vec<&str> = ["assert", "require"]
+                    .iter()
+                    .filter_map(|package| {
+                        imports
+                            .get(&format!("github.com/stretchr/testify/{}", package))
+                            .map(String::as_str)
+                    })
+                    .collect();
+                error_strings(root, source_code, &testify)
+            }
+        }
+    }
+}
+
+struct TestFile<'s> {
+    source_code: &'s str,
+    /// The local name of `testing`.
+    testing: &'s str,
+}
+
+impl TestFile<'_> {
+    fn text<'a>(&'a self, node: Node) -> &'a str {
+        node_text(node, self.source_code)
+    }
+
+    /// The names of `function`'s parameters of a `testing` type.
+    fn test_parameters(&self, function: Node) -> Vec<String> {
+        let Some(parameters) = function.child_by_field_name("parameters") else {
+            return Vec::new();
+        };
+        let types: Vec<String> = ["*{}.T", "*{}.B", "*{}.F", "{}.TB"]
+            .iter()
+            .map(|ty| ty.replace("{}", self.testing))
+            .collect();
+        let mut names = Vec::new();
+        let mut cursor = parameters.walk();
+        for parameter in parameters.named_children(&mut cursor) {
+            let is_testing = parameter
+                .child_by_field_name("type")
+                .is_some_and(|ty| types.iter().any(|t| t == self.text(ty)));
+            if !is_testing {
+                continue;
+            }
+            let mut cursor = parameter.walk();
+            names.extend(
+                parameter
+                    .children_by_field_name("name", &mut cursor)
+                    .map(|name| self.text(name).to_string()),
+            );
+        }
+        names
+    }
+
+    /// The calls `receiver.method(...)` written in `function` itself, not in
+    /// the closures inside it, with the method's name.
+    fn method_calls<'t>(&self, function: Node<'t>, receiver: &str) -> Vec<(Node<'t>, String)> {
+        let mut calls = Vec::new();
+        visit(function, &mut |node| {
+            if node.kind() != "call_expression" || enclosing_function(node) != Some(function) {
+                return;
+            }
+            if let Some((operand, method)) = self.selector_call(node) {
+                if operand == receiver {
+                    calls.push((node, method.to_string()));
+                }
+            }
+        });
+        calls
+    }
+
+    /// `(operand, method)` of a call like `t.Run(...)`.
+    fn selector_call<'a>(&'a self, call: Node) -> Option<(&'a str, &'a str)> {
+        let function = call.child_by_field_name("function")?;
+        if function.kind() != "selector_expression" {
+            return None;
+        }
+        Some((
+            self.text(function.child_by_field_name("operand")?),
+            self.text(function.child_by_field_name("field")?),
+        ))
+    }
+}
+
+fn arguments(call: Node) -> Vec<Node> {
+    let mut cursor = call.walk();
+    call.child_by_field_name("arguments")
+        .map(|arguments| arguments.named_children(&mut cursor).collect())
+        .unwrap_or_default()
+}
+
+fn is_function(node: Node) -> bool {
+    matches!(
+        node.kind(),
+        "function_declaration" | "method_declaration" | "func_literal"
+    )
+}
+
+/// The name of the test or helper `node` is in, unless it's in neither.
+fn test_function<'s>(node: Node, file: &TestFile<'s>) -> Option<&'s str> {
+    let declaration = enclosing_declaration(node)?;
+    let name = node_text(declaration.child_by_field_name("name")?, file.source_code);
+    let is_test = TEST_PREFIXES.iter().any(|prefix| name.starts_with(prefix));
+    (is_test || !file.test_parameters(declaration).is_empty()).then_some(name)
+}
+
+fn parallel_subtests<'t>(root: Node<'t>, file: &TestFile) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    visit(root, &mut |function| {
+        if !is_function(function) {
+            return;
+        }
+        for t in file.test_parameters(function) {
+            let calls = file.method_calls(function, &t);
+            if !calls.iter().any(|(_, method)| method == "Parallel") {
+                continue;
+            }
+            for (call, method) in &calls {
+                if method != "Run" {
+                    continue;
+                }
+                let args = arguments(*call);
+                let (Some(name), Some(subtest)) = (args.first(), args.get(1)) else {
+                    continue;
+                };
+                if subtest.kind() != "func_literal" {
+                    continue;
+                }
+                let Some(sub_t) = file.test_parameters(*subtest).into_iter().next() else {
+                    continue;
+                };
+                let sub_calls = file.method_calls(*subtest, &sub_t);
+                let parallel_or_serial = sub_calls.iter().any(|(_, method)| {
+                    method == "Parallel" || SERIAL_METHODS.contains(&method.as_str())
+                });
+                if parallel_or_serial {
+                    continue;
+                }
+                let owner = match function.child_by_field_name("name") {
+                    Some(name) => format!("`{}`", file.text(name)),
+                    None => "the enclosing subtest".to_string(),
+                };
+                hits.push(
+                    Hit::new(*call)
+                        .with_message(format!(
+                            "{} runs in parallel but its subtest `{}` doesn't call `{}.Parallel()`, so it doesn't run alongside the other subtests",
+                            owner,
+                            file.text(*name),
+                            sub_t
+                        ))
+                        .with_related(*subtest, "the subtest"),
+                );
+            }
+        }
+    });
+    hits
+}
+
+fn sleeps<'t>(root: Node<'t>, file: &TestFile, time: &str) -> Vec<Hit<'t>> {
+    let sleep = format!("{}.Sleep", time);
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "call_expression" {
+            return;
+        }
+        let is_sleep = node
+            .child_by_field_name("function")
+            .is_some_and(|function| file.text(function) == sleep);
+        if !is_sleep {
+            return;
+        }
+        let Some(test) = test_function(node, file) else {
+            return;
+        };
+        hits.push(Hit::new(node).with_message(format!(
+            "`{}` in `{}` waits a fixed time instead of for what it waits on, so the test is slow when the wait is long and flaky when it isn't; wait on a channel or `sync.WaitGroup`, or poll with a deadline",
+            file.text(node), test
+        )));
+    });
+    hits
+}
+
+fn goroutine_fatals<'t>(root: Node<'t>, file: &TestFile) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    visit(root, &mut |function| {
+        if !is_function(function) || function.kind() == "func_literal" {
+            return;
+        }
+        let parameters = file.test_parameters(function);
+        if parameters.is_empty() {
+            return;
+        }
+        visit(function, &mut |call| {
+            if call.kind() != "call_expression" {
+                return;
+            }
+            let Some((receiver, method)) = file.selector_call(call) else {
+                return;
+            };
+            if !STOPPING_METHODS.contains(&method) || !parameters.iter().any(|t| t == receiver) {
+                return;
+            }
+            if let Some(start) = goroutine(call, receiver, file) {
+                hits.push(
+                    Hit::new(call)
+                        .with_message(format!(
+                            "`{}.{}` runs in a goroutine the test started, where it only stops that goroutine and the test goes on; report with `{}.Error` and return, or send the error back to the test",
+                            receiver, method, receiver
+                        ))
+                        .with_related(start, "the goroutine starts here"),
+                );
+            }
+        });
+    });
+    hits
+}
+
+/// The statement or call starting the goroutine `call` runs in, when that
+/// is inside the function `receiver` belongs to. A subtest closure
+/// declaring its own `receiver` runs in a goroutine of the testing
+/// package, and stops the search.
+fn goroutine<'t>(call: Node<'t>, receiver: &str, file: &TestFile) -> Option<Node<'t>> {
+    let mut current = enclosing_function(call);
+    while let Some(function) = current.filter(|function| function.kind() == "func_literal") {
+        let parent = function.parent()?;
+        // `go func() { ... }()`
+        if parent.kind() == "call_expression" {
+            if let Some(statement) = parent.parent().filter(|p| p.kind() == "go_statement") {
+                return Some(statement);
+            }
+        }
+        // `g.Go(func() error { ... })`
+        if parent.kind() == "argument_list" {
+            let outer = parent.parent()?;
+            if file
+                .selector_call(outer)
+                .is_some_and(|(_, method)| method == "Go")
+            {
+                return Some(outer);
+            }
+        }
+        if file.test_parameters(function).iter().any(|t| t == receiver) {
+            return None;
+        }
+        current = enclosing_function(function);
+    }
+    None
+}
+
+fn missing_helpers<'t>(root: Node<'t>, file: &TestFile) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    visit(root, &mut |function| {
+        if !matches!(
+            function.kind(),
+            "function_declaration" | "method_declaration"
+        ) {
+            return;
+        }
+        let (Some(name), Some(body)) = (
+            function.child_by_field_name("name"),
+            function.child_by_field_name("body"),
+        ) else {
+            return;
+        };
+        let name_text = file.text(name);
+        if TEST_PREFIXES
+            .iter()
+            .any(|prefix| name_text.starts_with(prefix))
+        {
+            return;
+        }
+        for t in file.test_parameters(function) {
+            let calls = file.method_calls(function, &t);
+            if calls.iter().any(|(_, method)| method == "Helper") {
+                continue;
+            }
+            let Some((report, method)) = calls
+                .iter()
+                .find(|(_, method)| REPORTING_METHODS.contains(&method.as_str()))
+            else {
+                continue;
+            };
+            let mut hit = Hit::new(name)
+                .with_message(format!(
+                    "`{}` reports failures with `{}.{}` without calling `{}.Helper()`, so they point at `{}` instead of the test that called it",
+                    name_text, t, method, t, name_text
+                ))
+                .with_related(*report, "reported here");
+            if let Some(fix) = helper_fix(body, &t, file.source_code) {
+                hit = hit.with_fix(fix);
+            }
+            hits.push(hit);
+        }
+    });
+    hits
+}
+
+/// Inserts `t.Helper()` as the first statement of `body`, indented like
+/// the statement it goes before.
+fn helper_fix(body: Node, t: &str, source_code: &str) -> Option<Fix> {
+    let mut cursor = body.walk();
+    let first = body
+        .named_children(&mut cursor)
+        .find(|child| child.kind() != "comment")?;
+    // Newer grammars wrap the statements in a statement_list.
+    let first = match first.kind() {
+        "statement_list" => first.named_child(0)?,
+        _ => first,
+    };
+    if first.start_position().row == body.start_position().row {
+        return None;
+    }
+    let line_start = source_code[..first.start_byte()]
+        .rfind('\n')
+        .map_or(0, |i| i + 1);
+    let indent = &source_code[line_start..first.start_byte()];
+    Some(Fix {
+        description: format!("Call `{}.Helper()`", t),
+        edits: vec![TextEdit {
+            start_byte: line_start,
+            end_byte: line_start,
+            replacement: format!("{}{}.Helper()\n", indent, t),
+        }],
+    })
+}
+
+/// Whether `node` is a call like `err.Error()`.
+pub(super) fn is_error_call(node: Node, source_code: &str) -> bool {
+    node.kind() == "call_expression"
+        && node
+            .child_by_field_name("function")
+            .filter(|function| function.kind() == "selector_expression")
+            .and_then(|function| function.child_by_field_name("field"))
+            .is_some_and(|field| node_text(field, source_code) == "Error")
+        && arguments(node).is_empty()
+}
+
+fn error_strings<'t>(root: Node<'t>, source_code: &str, testify: &[&str]) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    visit(root, &mut |node| match node.kind() {
+        "binary_expression" => {
+            let (Some(left), Some(right), Some(operator)) = (
+                node.child_by_field_name("left"),
+                node.child_by_field_name("right"),
+                node.child_by_field_name("operator"),
+            ) else {
+                return;
+            };
+            if !matches!(node_text(operator, source_code), "==" | "!=") {
+                return;
+            }
+            let Some(error) = [left, right]
+                .into_iter()
+                .find(|side| is_error_call(*side, source_code))
+            else {
+                return;
+            };
+            hits.push(Hit::new(node).with_message(format!(
+                "`{}` compares the error by its message, so the test breaks when the wording changes and passes for another error with the same text; check it with `errors.Is` or `errors.As`",
+                node_text(error, source_code)
+            )));
+        }
+        "call_expression" => {
+            let Some(function) = node
+                .child_by_field_name("function")
+                .filter(|function| function.kind() == "selector_expression")
+            else {
+                return;
+            };
+            let (Some(package), Some(assertion)) = (
+                function.child_by_field_name("operand"),
+                function.child_by_field_name("field"),
+            ) else {
+                return;
+            };
+            let assertion = node_text(assertion, source_code);
+            if !testify.contains(&node_text(package, source_code))
+                || !STRING_ASSERTIONS.contains(&assertion)
+            {
+                return;
+            }
+            let by_message = assertion == "EqualError"
+                || arguments(node)
+                    .iter()
+                    .any(|argument| is_error_call(*argument, source_code));
+            if by_message {
+                hits.push(Hit::new(node).with_message(format!(
+                    "`{}` compares the error by its message, so the test breaks when the wording changes and passes for another error with the same text; use `ErrorIs` or `ErrorAs`",
+                    node_text(function, source_code)
+                )));
+            }
+        }
+        _ => {}
+    });
+    hits
+}
@@ -0,0 +1,362 @@
+use super::api_misuse::imported_as;
+use super::{list_items, node_text, visit, Check, Hit, RuleOptions};
+use crate::fix::{Fix, TextEdit};
+use crate::module::version_at_least;
+use crate::package::Package;
+use std::collections::HashSet;
+use tree_sitter::Node;
+
+/// Misuse of the `time` package's timers and `time.Time` values.
+///
+/// Before Go 1.23, a timer or ticker nobody stops is never collected: the
+/// ticker behind `time.Tick` runs forever, and a `time.After` timer stays
+/// alive until it fires. When the file's module declares `go 1.23` or later
+/// they are collected, so `time.Tick` isn't reported and `time.After` only
+/// for the allocation.
+///
+/// `time.Time` values are recognized without type information: parameters,
+/// variables and struct fields declared as `time.Time`, and results of
+/// `time.Now`, `time.Unix`, `time.Date` and the methods that return another
+/// time, such as `Add` and `UTC`.
+pub struct GoTime {
+    issue: TimeIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum TimeIssue {
+    /// `time.Tick` outside `main`, which leaks a ticker on every call.
+    Tick,
+    /// `time.After` as a case of a `select` in a loop.
+    AfterInLoop,
+    /// `time.Time` values compared with `==` or `!=`.
+    Equality,
+    /// `time.Now().Sub(t)` and `t.Sub(time.Now())`, which have shorthands.
+    NowSub,
+}
+
+impl GoTime {
+    pub fn new(issue: TimeIssue) -> Self {
+        GoTime { issue }
+    }
+}
+
+/// Functions of the package returning a `time.Time`.
+const CONSTRUCTORS: &[&str] = &["Now", "Unix", "UnixMilli", "UnixMicro", "Date"];
+
+/// `time.Time` methods returning another `time.Time`.
+pub(super) const DERIVED: &[&str] = &["Add", "AddDate", "Truncate", "Round", "UTC", "Local", "In"];
+
+impl Check for GoTime {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        _options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let Some(time) = imported_as(root, source_code, "time") else {
+            return Vec::new();
+        };
+        let collected = package
+            .and_then(|package| package.module.as_ref())
+            .and_then(|module| module.go.as_deref())
+            .is_some_and(|go| version_at_least(go, 1, 23));
+        let times = match self.issue {
+            TimeIssue::Equality => Times::collect(root, source_code, &time),
+            _ => Times::default(),
+        };
+
+        let mut hits = Vec::new();
+        visit(root, &mut |node| match (self.issue, node.kind()) {
+            (TimeIssue::Tick, "call_expression") if !collected => {
+                if is_call_to(node, source_code, &time, "Tick") && !in_main(node, source_code) {
+                    let message = format!(
+                        "`{time}.Tick` never stops its ticker, so every call leaks one; use `{time}.NewTicker` and `defer ticker.Stop()`",
+                    );
+                    hits.push(Hit::new(node).with_message(message));
+                }
+            }
+            (TimeIssue::AfterInLoop, "call_expression") => {
+                if is_call_to(node, source_code, &time, "After") && is_select_in_loop(node) {
+                    let lifetime = if collected {
+                        ""
+                    } else {
+                        ", and each one lives until it fires"
+                    };
+                    let message = format!(
+                        "`{time}.After` starts a new timer on every iteration of the loop{lifetime}; create a `{time}.NewTimer` before the loop and `Reset` it",
+                    );
+                    hits.push(Hit::new(node).with_message(message));
+                }
+            }
+            (TimeIssue::Equality, "binary_expression") => {
+                if let Some(hit) = equality(node, source_code, &time, &times) {
+                    hits.push(hit);
+                }
+            }
+            (TimeIssue::NowSub, "call_expression") => {
+                if let Some(hit) = now_sub(node, source_code, &time) {
+                    hits.push(hit);
+                }
+            }
+            _ => {}
+        });
+        hits
+    }
+}
+
+/// Whether `call` is `time.<name>(...)`, with `time` the package's local name.
+fn is_call_to(call: Node, source_code: &str, time: &str, name: &str) -> bool {
+    call.child_by_field_name("function")
+        .is_some_and(|function| is_qualified(function, source_code, time, name))
+}
+
+fn is_qualified(node: Node, source_code: &str, package: &str, name: &str) -> bool {
+    node.kind() == "selector_expression"
+        && node.child_by_field_name("operand").is_some_and(|operand| {
+            operand.kind() == "identifier" && node_text(operand, source_code) == package
+        })
+        && node
+            .child_by_field_name("field")
+            .is_some_and(|field| node_text(field, source_code) == name)
+}
+
+/// Whether `node` is inside `func main`, which runs for the whole program.
+fn in_main(node: Node, source_code: &str) -> bool {
+    let mut current = node.parent();
+    while let Some(ancestor) = current {
+        if ancestor.kind() == "function_declaration" {
+            return ancestor
+                .child_by_field_name("name")
+                .is_some_and(|name| node_text(name, source_code) == "main");
+        }
+        current = ancestor.parent();
+    }
+    false
+}
+
+/// Whether `call` is the channel operand of a `select` case, and that
+/// `select` runs in a loop of the same function.
+fn is_select_in_loop(call: Node) -> bool {
+    let mut current = call.parent();
+    let mut in_case = false;
+    while let Some(node) = current {
+        match node.kind() {
+            "communication_case" => {
+                let Some(communication) = node.child_by_field_name("communication") else {
+                    return false;
+                };
+                if call.start_byte() < communication.start_byte()
+                    || call.end_byte() > communication.end_byte()
+                {
+                    return false;
+                }
+                in_case = true;
+            }
+            "for_statement" if in_case => return true,
+            "func_literal" | "function_declaration" | "method_declaration" => return false,
+            _ => {}
+        }
+        current = node.parent();
+    }
+    false
+}
+
+/// Names in the file that hold a `time.Time`.
+#[derive(Default)]
+pub(super) struct Times {
+    /// Parameters and variables.
+    names: HashSet<String>,
+    /// Struct fields.
+    fields: HashSet<String>,
+}
+
+impl Times {
+    pub(super) fn collect(root: Node, source_code: &str, time: &str) -> Self {
+        let mut times = Times::default();
+        let time_type = format!("{}.Time", time);
+        visit(root, &mut |node| match node.kind() {
+            "parameter_declaration" | "var_spec" | "field_declaration" => {
+                let declared = node
+                    .child_by_field_name("type")
+                    .is_some_and(|ty| node_text(ty, source_code) == time_type);
+                let valued = node
+                    .child_by_field_name("value")
+                    .and_then(|value| value.named_child(0))
+                    .is_some_and(|value| times.is_time(value, source_code, time));
+                if !declared && !valued {
+                    return;
+                }
+                let target = if node.kind() == "field_declaration" {
+                    &mut times.fields
+                } else {
+                    &mut times.names
+                };
+                let mut cursor = node.walk();
+                for name in node.children_by_field_name("name", &mut cursor) {
+                    target.insert(node_text(name, source_code).to_string());
+                }
+            }
+            "short_var_declaration" | "assignment_statement" => {
+                let (Some(left), Some(right)) = (
+                    node.child_by_field_name("left"),
+                    node.child_by_field_name("right"),
+                ) else {
+                    return;
+                };
+                let targets = list_items(left);
+                let values = list_items(right);
+                for (target, value) in targets.iter().zip(&values) {
+                    if target.kind() == "identifier" && times.is_time(*value, source_code, time) {
+                        times
+                            .names
+                            .insert(node_text(*target, source_code).to_string());
+                    }
+                }
+                // t, err := time.Parse(...)
+                if let ([first, _], [call]) = (targets.as_slice(), values.as_slice()) {
+                    if is_call_to(*call, source_code, time, "Parse")
+                        || is_call_to(*call, source_code, time, "ParseInLocation")
+                    {
+                        times
+                            .names
+                            .insert(node_text(*first, source_code).to_string());
+                    }
+                }
+            }
+            _ => {}
+        });
+        times
+    }
+
+    pub(super) fn is_time(&self, node: Node, source_code: &str, time: &str) -> bool {
+        match node.kind() {
+            "identifier" => self.names.contains(node_text(node, source_code)),
+            "parenthesized_expression" => node
+                .named_child(0)
+                .is_some_and(|inner| self.is_time(inner, source_code, time)),
+            "selector_expression" => node
+                .child_by_field_name("field")
+                .is_some_and(|field| self.fields.contains(node_text(field, source_code))),
+            "composite_literal" => is_zero_time(node, source_code, time),
+            "call_expression" => {
+                let Some(function) = node.child_by_field_name("function") else {
+                    return false;
+                };
+                if CONSTRUCTORS
+                    .iter()
+                    .any(|name| is_qualified(function, source_code, time, name))
+                {
+                    return true;
+                }
+                function.kind() == "selector_expression"
+                    && function
+                        .child_by_field_name("field")
+                        .is_some_and(|field| DERIVED.contains(&node_text(field, source_code)))
+                    && function
+                        .child_by_field_name("operand")
+                        .is_some_and(|operand| self.is_time(operand, source_code, time))
+            }
+            _ => false,
+        }
+    }
+}
+
+/// Whether `node` is `time.Time{}`.
+fn is_zero_time(node: Node, source_code: &str, time: &str) -> bool {
+    node.kind() == "composite_literal"
+        && node
+            .child_by_field_name("type")
+            .is_some_and(|ty| node_text(ty, source_code) == format!("{}.Time", time))
+        && node
+            .child_by_field_name("body")
+            .is_some_and(|body| body.named_child_count() == 0)
+}
+
+fn equality<'t>(node: Node<'t>, source_code: &str, time: &str, times: &Times) -> Option<Hit<'t>> {
+    let operator = node_text(node.child_by_field_name("operator")?, source_code);
+    if operator != "==" && operator != "!=" {
+        return None;
+    }
+    let left = node.child_by_field_name("left")?;
+    let right = node.child_by_field_name("right")?;
+    if !times.is_time(left, source_code, time) && !times.is_time(right, source_code, time) {
+        return None;
+    }
+    let not = if operator == "!=" { "!" } else { "" };
+    let instead = match (
+        is_zero_time(left, source_code, time),
+        is_zero_time(right, source_code, time),
+    ) {
+        (false, true) => format!("{}{}.IsZero()", not, node_text(left, source_code)),
+        (true, false) => format!("{}{}.IsZero()", not, node_text(right, source_code)),
+        _ => format!(
+            "{}{}.Equal({})",
+            not,
+            node_text(left, source_code),
+            node_text(right, source_code)
+        ),
+    };
+    let message = format!(
+        "`{}` also compares the times' locations and monotonic clock readings; use `{}`",
+        node_text(node, source_code),
+        instead
+    );
+    Some(Hit::new(node).with_message(message))
+}
+
+/// `time.Now().Sub(t)`, which is `time.Since(t)`, and `t.Sub(time.Now())`,
+/// which is `time.Until(t)`.
+fn now_sub<'t>(call: Node<'t>, source_code: &str, time: &str) -> Option<Hit<'t>> {
+    let function = call.child_by_field_name("function")?;
+    if function.kind() != "selector_expression"
+        || node_text(function.child_by_field_name("field")?, source_code) != "Sub"
+    {
+        return None;
+    }
+    let receiver = function.child_by_field_name("operand")?;
+    let arguments = call.child_by_field_name("arguments")?;
+    if arguments.named_child_count() != 1 {
+        return None;
+    }
+    let argument = arguments.named_child(0)?;
+    let is_now = |node: Node| {
+        node.kind() == "call_expression"
+            && is_call_to(node, source_code, time, "Now")
+            && node
+                .child_by_field_name("arguments")
+                .is_some_and(|arguments| arguments.named_child_count() == 0)
+    };
+    let (shorthand, operand) = if is_now(receiver) {
+        ("Since", argument)
+    } else if is_now(argument) {
+        ("Until", receiver)
+    } else {
+        return None;
+    };
+
+    let replacement = format!(
+        "{}.{}({})",
+        time,
+        shorthand,
+        node_text(operand, source_code)
+    );
+    let message = format!("`{}` is `{}`", node_text(call, source_code), replacement);
+    let fix = Fix {
+        description: format!("Use {}.{}", time, shorthand),
+        edits: vec![TextEdit {
+            start_byte: call.start_byte(),
+            end_byte: call.end_byte(),
+            replacement,
+        }],
+    };
+    Some(Hit::new(call).with_message(message).with_fix(fix))
+}
@@ -0,0 +1,1101 @@
+use super::api_misuse::imported_as;
+use super::{
+    enclosing_function, list_items, node_text, visit, Check, Hit, OptionKind, RuleOptions,
+};
+use crate::analyzer::Confidence;
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use std::collections::{HashMap, HashSet};
+use tree_sitter::{Node, Parser};
+
+/// Network clients, servers and dials that can wait forever.
+///
+/// None of Go's networking defaults time out: `http.DefaultClient` and an
+/// `http.Client` without `Timeout` wait as long as the server takes, an
+/// `http.Server` without read, write and idle timeouts lets slow clients
+/// hold connections open, and `net.Dial` waits as long as the operating
+/// system does, often minutes, for a host that doesn't answer.
+///
+/// gRPC calls are bounded by their context. A call is reported when its
+/// context has no deadline because it comes from `context.Background()`,
+/// `context.TODO()` or `context.WithoutCancel`, directly or through the
+/// parameters of the functions that lead to it; callers are looked for in
+/// the whole package. A context whose origin can't be followed, such as a
+/// request's, isn't reported. Clients are recognized without type
+/// information, as values of `pkg.FooClient` types and results of
+/// `NewFooClient(conn)`, so those findings are of medium confidence.
+///
+/// `max_timeout`, a duration such as `"30s"` or `"1m30s"`, also reports
+/// timeouts and deadlines that are constant and longer than it.
+pub struct GoTimeout {
+    issue: TimeoutIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum TimeoutIssue {
+    /// `http.Client` literals without a `Timeout`, and `http.Get` and the
+    /// other calls through `http.DefaultClient`.
+    HttpClient,
+    /// `http.Server` literals missing a read, write or idle timeout, and
+    /// `http.ListenAndServe` and the other servers that set none.
+    HttpServer,
+    /// `net.Dial` and `tls.Dial`, which have no timeout of their own.
+    NetDial,
+    /// gRPC calls, and blocking dials, whose context has no deadline.
+    GrpcDeadline,
+}
+
+impl GoTimeout {
+    pub fn new(issue: TimeoutIssue) -> Self {
+        GoTimeout { issue }
+    }
+}
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("max_timeout", OptionKind::String)];
+
+/// The `time` constants, with the symbols durations are written with and
+/// their length in nanoseconds.
+const UNITS: &[(&str, &str, f64)] = &[
+    ("Nanosecond", "ns", 1.0),
+    ("Microsecond", "us", 1e3),
+    ("Millisecond", "ms", 1e6),
+    ("Second", "s", 1e9),
+    ("Minute", "m", 6e10),
+    ("Hour", "h", 3.6e12),
+];
+
+/// Package functions that send their request through `http.DefaultClient`.
+const DEFAULT_CLIENT_CALLS: &[&str] = &["Get", "Head", "Post", "PostForm"];
+
+/// Package functions that serve on a server without timeouts.
+const DEFAULT_SERVERS: &[&str] = &["ListenAndServe", "ListenAndServeTLS", "Serve", "ServeTLS"];
+
+/// `http.Server` fields that bound how long a connection is held.
+const SERVER_TIMEOUTS: &[&str] = &[
+    "ReadTimeout",
+    "ReadHeaderTimeout",
+    "WriteTimeout",
+    "IdleTimeout",
+];
+
+/// How many calls deep contexts are followed through parameters.
+const MAX_DEPTH: usize = 8;
+
+impl Check for GoTimeout {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        self.issue == TimeoutIssue::GrpcDeadline
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let limit = Limit {
+            max: max_timeout(options).ok().flatten(),
+            time: imported_as(root, source_code, "time"),
+            constants: constants(root, source_code),
+            source_code,
+        };
+        match self.issue {
+            TimeoutIssue::HttpClient => http_client(root, source_code, &limit),
+            TimeoutIssue::HttpServer => http_server(root, source_code, &limit),
+            TimeoutIssue::NetDial => net_dial(root, source_code, &limit),
+            TimeoutIssue::GrpcDeadline => grpc_deadline(root, source_code, package, &limit),
+        }
+    }
+}
+
+/// The `max_timeout` option in nanoseconds.
+pub(super) fn max_timeout(options: &RuleOptions) -> Result<Option<f64>, String> {
+    let Some(text) = options.string("max_timeout") else {
+        return Ok(None);
+    };
+    parse_duration(text).map(Some).ok_or_else(|| {
+        format!(
+            "option 'max_timeout' expects a duration such as \"30s\" or \"1m30s\", got \"{}\"",
+            text
+        )
+    })
+}
+
+/// A duration written as Go's `time.ParseDuration` reads it, in nanoseconds.
+fn parse_duration(text: &str) -> Option<f64> {
+    let mut rest = text.trim();
+    if rest.is_empty() {
+        return None;
+    }
+    let mut total = 0.0;
+    while !rest.is_empty() {
+        let number_end = rest
+            .find(|c: char| !c.is_ascii_digit() && c != '.')
+            .unwrap_or(rest.len());
+        let number: f64 = rest[..number_end].parse().ok()?;
+        rest = &rest[number_end..];
+        let unit_end = rest
+            .find(|c: char| c.is_ascii_digit() || c == '.')
+            .unwrap_or(rest.len());
+        let unit = match &rest[..unit_end] {
+            "µs" | "μs" => "us",
+            unit => unit,
+        };
+        let (_, _, scale) = UNITS.iter().find(|(_, symbol, _)| *symbol == unit)?;
+        total += number * scale;
+        rest = &rest[unit_end..];
+    }
+    Some(total)
+}
+
+/// A duration in nanoseconds the way it would be written in `max_timeout`.
+fn format_duration(nanos: f64) -> String {
+    let (_, symbol, scale) = UNITS
+        .iter()
+        .rev()
+        .find(|(_, _, scale)| nanos >= *scale && (nanos / scale).fract() == 0.0)
+        .unwrap_or(&UNITS[0]);
+    format!("{}{}", nanos / scale, symbol)
+}
+
+/// What a timeout is checked against, and what's needed to work out the
+/// constant value of one.
+struct Limit<'t, 's> {
+    max: Option<f64>,
+    /// The name the file imports `time` under.
+    time: Option<String>,
+    constants: HashMap<&'s str, Node<'t>>,
+    source_code: &'s str,
+}
+
+impl Limit<'_, '_> {
+    /// The value of a constant duration expression, such as
+    /// `30 * time.Second`, in nanoseconds.
+    fn duration(&self, node: Node, depth: usize) -> Option<f64> {
+        if depth > MAX_DEPTH {
+            return None;
+        }
+        let text = node_text(node, self.source_code);
+        match node.kind() {
+            "int_literal" | "float_literal" => text.replace('_', "").parse().ok(),
+            "parenthesized_expression" => self.duration(node.named_child(0)?, depth + 1),
+            "identifier" => self.duration(*self.constants.get(text)?, depth + 1),
+            "selector_expression" => {
+                let operand = node.child_by_field_name("operand")?;
+                if Some(node_text(operand, self.source_code)) != self.time.as_deref() {
+                    return None;
+                }
+                let field = node_text(node.child_by_field_name("field")?, self.source_code);
+                UNITS
+                    .iter()
+                    .find(|(name, _, _)| *name == field)
+                    .map(|(_, _, scale)| *scale)
+            }
+            "call_expression" => {
+                // A conversion such as `time.Duration(n)`.
+                let function = node.child_by_field_name("function")?;
+                let time = self.time.as_deref()?;
+                if node_text(function, self.source_code) != format!("{}.Duration", time) {
+                    return None;
+                }
+                let arguments = node.child_by_field_name("arguments")?;
+                self.duration(arguments.named_child(0)?, depth + 1)
+            }
+            "binary_expression" => {
+                let left = self.duration(node.child_by_field_name("left")?, depth + 1)?;
+                let right = self.duration(node.child_by_field_name("right")?, depth + 1)?;
+                let operator = node.child_by_field_name("operator")?;
+                match node_text(operator, self.source_code) {
+                    "*" => Some(left * right),
+                    "+" => Some(left + right),
+                    "-" => Some(left - right),
+                    "/" if right != 0.0 => Some(left / right),
+                    _ => None,
+                }
+            }
+            _ => None,
+        }
+    }
+
+    /// The finding for a timeout longer than `max_timeout`, if `value` is.
+    fn too_long<'t>(&self, field: &str, value: Node<'t>) -> Option<Hit<'t>> {
+        let max = self.max?;
+        let duration = self.duration(value, 0)?;
+        (duration > max).then(|| {
+            Hit::new(value).with_message(format!(
+                "`{}` of {} is longer than the {} allowed",
+                field,
+                format_duration(duration),
+                format_duration(max)
+            ))
+        })
+    }
+}
+
+/// The values of the file's constants by name, for durations such as
+/// `const timeout = 5 * time.Second`.
+fn constants<'t, 's>(root: Node<'t>, source_code: &'s str) -> HashMap<&'s str, Node<'t>> {
+    let mut constants = HashMap::new();
+    visit(root, &mut |node| {
+        if node.kind() != "const_spec" {
+            return;
+        }
+        let Some(values) = node.child_by_field_name("value") else {
+            return;
+        };
+        let mut cursor = node.walk();
+        let names = node.children_by_field_name("name", &mut cursor);
+        for (name, value) in names.zip(list_items(values)) {
+            constants.insert(node_text(name, source_code), value);
+        }
+    });
+    constants
+}
+
+fn http_client<'t>(root: Node<'t>, source_code: &str, limit: &Limit) -> Vec<Hit<'t>> {
+    let Some(http) = imported_as(root, source_code, "net/http") else {
+        return Vec::new();
+    };
+    // Setting `http.DefaultClient.Timeout` bounds the package functions too.
+    let default_bounded = assigns_field(
+        root,
+        source_code,
+        &format!("{}.DefaultClient", http),
+        "Timeout",
+    );
+
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if let Some(fields) = literal_fields(node, source_code, &http, "Client") {
+            match fields.iter().find(|(name, _)| name == "Timeout") {
+                Some((_, value)) if !is_zero(*value, source_code) => {
+                    hits.extend(limit.too_long("Timeout", *value));
+                }
+                _ => hits.push(Hit::new(node).with_message(format!(
+                    "`{}.Client` has no `Timeout`, so a server that stops responding hangs the request forever",
+                    http
+                ))),
+            }
+            return;
+        }
+        if node.kind() != "call_expression" || default_bounded {
+            return;
+        }
+        let Some(function) = node.child_by_field_name("function") else {
+            return;
+        };
+        let Some((operand, name)) = selector(function, source_code) else {
+            return;
+        };
+        if operand == http && DEFAULT_CLIENT_CALLS.contains(&name) {
+            hits.push(Hit::new(node).with_message(format!(
+                "`{0}.{1}` sends the request with `{0}.DefaultClient`, which has no timeout",
+                http, name
+            )));
+        } else if operand == format!("{}.DefaultClient", http) {
+            hits.push(Hit::new(node).with_message(format!(
+                "`{}.DefaultClient` has no timeout, so a server that stops responding hangs the request forever",
+                http
+            )));
+        }
+    });
+    hits
+}
+
+fn http_server<'t>(root: Node<'t>, source_code: &str, limit: &Limit) -> Vec<Hit<'t>> {
+    let Some(http) = imported_as(root, source_code, "net/http") else {
+        return Vec::new();
+    };
+
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if let Some(fields) = literal_fields(node, source_code, &http, "Server") {
+            let set = |field: &str| {
+                fields
+                    .iter()
+                    .any(|(name, value)| name == field && !is_zero(*value, source_code))
+            };
+            // A zero `ReadHeaderTimeout` or `IdleTimeout` falls back to
+            // `ReadTimeout`.
+            let mut missing = Vec::new();
+            if !set("ReadTimeout") && !set("ReadHeaderTimeout") {
+                missing.push("`ReadHeaderTimeout`");
+            }
+            if !set("WriteTimeout") {
+                missing.push("`WriteTimeout`");
+            }
+            if !set("IdleTimeout") && !set("ReadTimeout") {
+                missing.push("`IdleTimeout`");
+            }
+            if !missing.is_empty() {
+                hits.push(Hit::new(node).with_message(format!(
+                    "`{}.Server` sets no {}, so slow or idle clients can hold connections open indefinitely",
+                    http,
+                    join_or(&missing)
+                )));
+            }
+            for (name, value) in &fields {
+                if SERVER_TIMEOUTS.contains(&name.as_str()) {
+                    hits.extend(limit.too_long(name, *value));
+                }
+            }
+            return;
+        }
+        if node.kind() != "call_expression" {
+            return;
+        }
+        let Some(function) = node.child_by_field_name("function") else {
+            return;
+        };
+        if let Some((operand, name)) = selector(function, source_code) {
+            if operand == http && DEFAULT_SERVERS.contains(&name) {
+                hits.push(Hit::new(node).with_message(format!(
+                    "`{}.{}` serves without read, write or idle timeouts, so slow or idle clients can hold connections open indefinitely",
+                    http, name
+                )));
+            }
+        }
+    });
+    hits
+}
+
+fn net_dial<'t>(root: Node<'t>, source_code: &str, limit: &Limit) -> Vec<Hit<'t>> {
+    let net = imported_as(root, source_code, "net");
+    let tls = imported_as(root, source_code, "crypto/tls");
+    if net.is_none() && tls.is_none() {
+        return Vec::new();
+    }
+
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if let Some(net) = &net {
+            if let Some(fields) = literal_fields(node, source_code, net, "Dialer") {
+                if let Some((_, value)) = fields.iter().find(|(name, _)| name == "Timeout") {
+                    hits.extend(limit.too_long("Timeout", *value));
+                }
+                return;
+            }
+        }
+        if node.kind() != "call_expression" {
+            return;
+        }
+        let Some((operand, name)) = node
+            .child_by_field_name("function")
+            .and_then(|function| selector(function, source_code))
+        else {
+            return;
+        };
+        let arguments = node
+            .child_by_field_name("arguments")
+            .map(|arguments| {
+                let mut cursor = arguments.walk();
+                arguments.named_children(&mut cursor).collect::<Vec<_>>()
+            })
+            .unwrap_or_default();
+        match (Some(operand) == net.as_deref(), Some(operand) == tls.as_deref(), name) {
+            (true, _, "Dial") => hits.push(Hit::new(node).with_message(format!(
+                "`{0}.Dial` has no timeout, so dialing a host that doesn't answer waits as long as the operating system does; use `{0}.DialTimeout` or a `{0}.Dialer` with a `Timeout`",
+                operand
+            ))),
+            (true, _, "DialTimeout") => {
+                if let Some(timeout) = arguments.get(2) {
+                    hits.extend(limit.too_long("DialTimeout", *timeout));
+                }
+            }
+            (_, true, "Dial") => hits.push(Hit::new(node).with_message(format!(
+                "`{0}.Dial` has no timeout, so dialing a host that doesn't answer waits as long as the operating system does; use `{0}.DialWithDialer` with a `Timeout`",
+                operand
+            ))),
+            _ => {}
+        }
+    });
+    hits
+}
+
+fn grpc_deadline<'t>(
+    root: Node<'t>,
+    source_code: &str,
+    package: Option<&Package>,
+    limit: &Limit,
+) -> Vec<Hit<'t>> {
+    let clients = clients(root, source_code);
+    let grpc = imported_as(root, source_code, "google.golang.org/grpc");
+    if clients.is_empty() && grpc.is_none() {
+        return Vec::new();
+    }
+
+    // Contexts are followed through the callers in every file of the
+    // package; the analyzed file is the first.
+    let mut parser = Parser::new();
+    let trees: Vec<_> = match parser.set_language(&SupportedLanguage::Go.tree_sitter_language()) {
+        Ok(()) => package
+            .map(|package| package.files.as_slice())
+            .unwrap_or_default()
+            .iter()
+            .filter_map(|file| {
+                let tree = parser.parse(&file.source_code, None)?;
+                Some((tree, file))
+            })
+            .collect(),
+        Err(_) => Vec::new(),
+    };
+    let file_name = |path: &std::path::Path| {
+        path.file_name()
+            .map(|name| name.to_string_lossy().into_owned())
+    };
+    let mut files = vec![Source::new(
+        root,
+        source_code,
+        package.and_then(|package| file_name(&package.path)),
+    )];
+    for (tree, file) in &trees {
+        files.push(Source::new(
+            tree.root_node(),
+            &file.source_code,
+            file_name(&file.path),
+        ));
+    }
+    let mut resolver = Resolver {
+        files,
+        parameters: HashMap::new(),
+    };
+
+    let mut calls = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "call_expression" {
+            return;
+        }
+        let (Some(function), Some(arguments)) = (
+            node.child_by_field_name("function"),
+            node.child_by_field_name("arguments"),
+        ) else {
+            return;
+        };
+        let mut cursor = arguments.walk();
+        let arguments: Vec<Node<'t>> = arguments
+            .named_children(&mut cursor)
+            .filter(|argument| argument.kind() != "comment")
+            .collect();
+        let Some(context) = arguments.first().copied() else {
+            return;
+        };
+        if let Some(method) = rpc_method(function, source_code, &clients) {
+            calls.push((node, context, format!("`{}`", method), Confidence::Medium));
+            return;
+        }
+        // A blocking dial waits until it connects, as long as its context
+        // allows.
+        let is_blocking_dial = grpc.as_deref().is_some_and(|grpc| {
+            node_text(function, source_code) == format!("{}.DialContext", grpc)
+                && arguments[1..].iter().any(|argument| {
+                    node_text(*argument, source_code).starts_with(&format!("{}.WithBlock(", grpc))
+                })
+        });
+        if is_blocking_dial {
+            let dial = format!("`{}`", node_text(function, source_code));
+            calls.push((node, context, dial, Confidence::High));
+        }
+    });
+
+    let mut hits = Vec::new();
+    for (call, context, what, confidence) in calls {
+        match resolver.deadline(0, context, 0) {
+            Deadline::Missing(origin) => hits.push(
+                Hit::new(call)
+                    .with_message(format!(
+                        "{} runs without a deadline: its context is {}",
+                        what,
+                        describe(&origin)
+                    ))
+                    .with_confidence(confidence),
+            ),
+            Deadline::Set(Some(timeout)) if limit.max.is_some_and(|max| timeout > max) => hits
+                .push(
+                    Hit::new(call)
+                        .with_message(format!(
+                            "{} has a deadline of {}, longer than the {} allowed",
+                            what,
+                            format_duration(timeout),
+                            format_duration(limit.max.unwrap_or_default())
+                        ))
+                        .with_confidence(confidence),
+                ),
+            _ => {}
+        }
+    }
+    hits
+}
+
+/// Where a context without a deadline comes from: how it was made, then
+/// each call it was passed down through, outermost first.
+fn describe(origin: &[String]) -> String {
+    match origin {
+        [made] => made.clone(),
+        [made, from] => format!("{} from {}", made, from),
+        [made, from, through @ ..] => format!(
+            "{} from {}, passed on by {}",
+            made,
+            from,
+            through.join(", ")
+        ),
+        [] => String::new(),
+    }
+}
+
+/// Names bound to generated gRPC clients: variables, fields and parameters
+/// of `pkg.FooClient` types, and variables assigned `NewFooClient(conn)`.
+fn clients(root: Node, source_code: &str) -> HashSet<String> {
+    let is_client_type = |ty: Node| {
+        ty.kind() == "qualified_type"
+            && ty
+                .child_by_field_name("name")
+                .map(|name| node_text(name, source_code))
+                .is_some_and(|name| name.len() > "Client".len() && name.ends_with("Client"))
+    };
+    let mut names = HashSet::new();
+    visit(root, &mut |node| match node.kind() {
+        "field_declaration" | "parameter_declaration" | "var_spec" => {
+            let typed = node.child_by_field_name("type").is_some_and(is_client_type)
+                || node
+                    .child_by_field_name("type")
+                    .filter(|ty| ty.kind() == "pointer_type")
+                    .and_then(|ty| ty.named_child(0))
+                    .is_some_and(is_client_type);
+            if typed {
+                let mut cursor = node.walk();
+                for name in node.children_by_field_name("name", &mut cursor) {
+                    names.insert(node_text(name, source_code).to_string());
+                }
+            }
+        }
+        "call_expression" => {
+            let constructor = node
+                .child_by_field_name("function")
+                .and_then(|function| match function.kind() {
+                    "selector_expression" => function.child_by_field_name("field"),
+                    _ => Some(function),
+                })
+                .map(|name| node_text(name, source_code))
+                .is_some_and(|name| {
+                    name.starts_with("New")
+                        && name.ends_with("Client")
+                        && name.len() > "NewClient".len()
+                });
+            let one_argument = node
+                .child_by_field_name("arguments")
+                .is_some_and(|arguments| arguments.named_child_count() == 1);
+            if constructor && one_argument {
+                names.extend(bound_name(node, source_code));
+            }
+        }
+        _ => {}
+    });
+    names
+}
+
+/// The method of `function` when it calls an RPC on a known client, as in
+/// `client.GetUser` or `s.users.GetUser`.
+fn rpc_method<'s>(
+    function: Node,
+    source_code: &'s str,
+    clients: &HashSet<String>,
+) -> Option<&'s str> {
+    if function.kind() != "selector_expression" {
+        return None;
+    }
+    let operand = function.child_by_field_name("operand")?;
+    let client = match operand.kind() {
+        "identifier" => operand,
+        "selector_expression" => operand.child_by_field_name("field")?,
+        _ => return None,
+    };
+    let method = node_text(function.child_by_field_name("field")?, source_code);
+    (clients.contains(node_text(client, source_code)) && method.starts_with(char::is_uppercase))
+        .then_some(method)
+}
+
+/// Whether a context has a deadline, as far as can be told.
+#[derive(Clone)]
+enum Deadline {
+    /// It has one, of this many nanoseconds when it's a constant timeout.
+    Set(Option<f64>),
+    /// It has none; see [`describe`].
+    Missing(Vec<String>),
+    Unknown,
+}
+
+/// A file of the package, with the names it imports the packages that make
+/// contexts under.
+struct Source<'t, 's> {
+    limit: Limit<'t, 's>,
+    root: Node<'t>,
+    context: Option<String>,
+    name: Option<String>,
+}
+
+impl<'t, 's> Source<'t, 's> {
+    fn new(root: Node<'t>, source_code: &'s str, name: Option<String>) -> Self {
+        Source {
+            limit: Limit {
+                max: None,
+                time: imported_as(root, source_code, "time"),
+                constants: constants(root, source_code),
+                source_code,
+            },
+            root,
+            context: imported_as(root, source_code, "context"),
+            name,
+        }
+    }
+
+    fn text(&self, node: Node) -> &'s str {
+        node_text(node, self.limit.source_code)
+    }
+
+    /// Where `node` is, for a message: `main.go:12`, or `line 12` when the
+    /// file's name isn't known.
+    fn location(&self, node: Node) -> String {
+        let line = node.start_position().row + 1;
+        match &self.name {
+            Some(name) => format!("{}:{}", name, line),
+            None => format!("line {}", line),
+        }
+    }
+}
+
+struct Resolver<'t, 's> {
+    files: Vec<Source<'t, 's>>,
+    /// The deadline of each parameter followed so far, by function name and
+    /// position.
+    parameters: HashMap<(String, usize), Deadline>,
+}
+
+impl<'t> Resolver<'t, '_> {
+    fn deadline(&mut self, file: usize, expression: Node<'t>, depth: usize) -> Deadline {
+        if depth > MAX_DEPTH {
+            return Deadline::Unknown;
+        }
+        let source = &self.files[file];
+        match expression.kind() {
+            "parenthesized_expression" => match expression.named_child(0) {
+                Some(inner) => self.deadline(file, inner, depth + 1),
+                None => Deadline::Unknown,
+            },
+            "identifier" => self.variable(file, expression, depth),
+            "call_expression" => {
+                let Some((package, name)) = expression
+                    .child_by_field_name("function")
+                    .and_then(|function| selector(function, source.limit.source_code))
+                else {
+                    return Deadline::Unknown;
+                };
+                if Some(package) != source.context.as_deref() {
+                    return Deadline::Unknown;
+                }
+                let arguments: Vec<Node<'t>> = expression
+                    .child_by_field_name("arguments")
+                    .map(|arguments| {
+                        let mut cursor = arguments.walk();
+                        arguments.named_children(&mut cursor).collect()
+                    })
+                    .unwrap_or_default();
+                match name {
+                    "Background" | "TODO" => {
+                        Deadline::Missing(vec![format!("`{}`", source.text(expression))])
+                    }
+                    "WithoutCancel" => Deadline::Missing(vec![format!(
+                        "`{}.WithoutCancel`, which drops the deadline",
+                        package
+                    )]),
+                    "WithTimeout" | "WithTimeoutCause" => Deadline::Set(
+                        arguments
+                            .get(1)
+                            .and_then(|timeout| source.limit.duration(*timeout, 0)),
+                    ),
+                    "WithDeadline" | "WithDeadlineCause" => Deadline::Set(None),
+                    "WithCancel" | "WithCancelCause" | "WithValue" => match arguments.first() {
+                        Some(parent) => self.deadline(file, *parent, depth + 1),
+                        None => Deadline::Unknown,
+                    },
+                    _ => Deadline::Unknown,
+                }
+            }
+            _ => Deadline::Unknown,
+        }
+    }
+
+    /// The deadline of the context a variable holds at `use_`: that of its
+    /// last assignment before, or of the parameter it is.
+    fn variable(&mut self, file: usize, use_: Node<'t>, depth: usize) -> Deadline {
+        let name = self.files[file].text(use_);
+        let mut scope = enclosing_function(use_);
+        while let Some(function) = scope {
+            if let Some(assigned) =
+                last_assignment(function, name, use_, self.files[file].limit.source_code)
+            {
+                return match assigned {
+                    Some(value) => self.deadline(file, value, depth + 1),
+                    None => Deadline::Unknown,
+                };
+            }
+            if let Some(index) = parameter_index(function, name, self.files[file].limit.source_code)
+            {
+                return match function.kind() {
+                    "func_literal" => Deadline::Unknown,
+                    _ => self.parameter(file, function, index, depth),
+                };
+            }
+            scope = enclosing_function(function);
+        }
+        Deadline::Unknown
+    }
+
+    /// The deadline of the `index`th parameter of `function`, from every
+    /// call of it in the package: missing when one caller passes a context
+    /// without one, set when every caller passes one with one.
+    fn parameter(
+        &mut self,
+        file: usize,
+        function: Node<'t>,
+        index: usize,
+        depth: usize,
+    ) -> Deadline {
+        let Some(name) = function
+            .child_by_field_name("name")
+            .map(|name| self.files[file].text(name).to_string())
+        else {
+            return Deadline::Unknown;
+        };
+        let key = (name.clone(), index);
+        if let Some(deadline) = self.parameters.get(&key) {
+            return deadline.clone();
+        }
+        // A recursive call doesn't decide anything.
+        self.parameters.insert(key.clone(), Deadline::Unknown);
+
+        let method = function.kind() == "method_declaration";
+        let mut arguments = Vec::new();
+        for (caller_file, source) in self.files.iter().enumerate() {
+            visit(source.root, &mut |node| {
+                if node.kind() != "call_expression" {
+                    return;
+                }
+                let Some(called) = node.child_by_field_name("function") else {
+                    return;
+                };
+                let calls = match (called.kind(), method) {
+                    ("identifier", false) => source.text(called) == name,
+                    ("selector_expression", true) => called
+                        .child_by_field_name("field")
+                        .is_some_and(|field| source.text(field) == name),
+                    _ => false,
+                };
+                let argument = node.child_by_field_name("arguments").and_then(|arguments| {
+                    let mut cursor = arguments.walk();
+                    let argument = arguments
+                        .named_children(&mut cursor)
+                        .filter(|argument| argument.kind() != "comment")
+                        .nth(index);
+                    argument
+                });
+                if let Some(argument) = argument.filter(|_| calls) {
+                    arguments.push((caller_file, node, argument));
+                }
+            });
+        }
+
+        let mut deadline = Deadline::Unknown;
+        let mut longest = None;
+        let mut all_set = !arguments.is_empty();
+        for (caller_file, call, argument) in arguments {
+            match self.deadline(caller_file, argument, depth + 1) {
+                Deadline::Missing(mut origin) => {
+                    let source = &self.files[caller_file];
+                    let caller = enclosing_name(call, source.limit.source_code).map_or_else(
+                        || "package scope".to_string(),
+                        |caller| format!("`{}`", caller),
+                    );
+                    origin.push(format!("{} ({})", caller, source.location(call)));
+                    deadline = Deadline::Missing(origin);
+                    break;
+                }
+                Deadline::Set(timeout) => {
+                    if let Some(timeout) = timeout {
+                        longest =
+                            Some(longest.map_or(timeout, |longest: f64| longest.max(timeout)));
+                    }
+                }
+                Deadline::Unknown => all_set = false,
+            }
+        }
+        if !matches!(deadline, Deadline::Missing(_)) && all_set {
+            deadline = Deadline::Set(longest);
+        }
+        self.parameters.insert(key, deadline.clone());
+        deadline
+    }
+}
+
+/// The value last assigned to `name` in `function` before `use_`: `None`
+/// when there's no assignment, `Some(None)` when there is one but which of
+/// its values `name` gets can't be told.
+fn last_assignment<'t>(
+    function: Node<'t>,
+    name: &str,
+    use_: Node<'t>,
+    source_code: &str,
+) -> Option<Option<Node<'t>>> {
+    let body = function.child_by_field_name("body")?;
+    let mut last = None;
+    visit(body, &mut |node| {
+        if node.start_byte() >= use_.start_byte() {
+            return;
+        }
+        let (left, right) = match node.kind() {
+            "short_var_declaration" | "assignment_statement" => (
+                node.child_by_field_name("left").map(list_items),
+                node.child_by_field_name("right"),
+            ),
+            "var_spec" => {
+                let mut cursor = node.walk();
+                let names = node.children_by_field_name("name", &mut cursor).collect();
+                (Some(names), node.child_by_field_name("value"))
+            }
+            _ => return,
+        };
+        let (Some(left), Some(right)) = (left, right) else {
+            return;
+        };
+        let Some(position) = left
+            .iter()
+            .position(|target| node_text(*target, source_code) == name)
+        else {
+            return;
+        };
+        let right = list_items(right);
+        last = Some(match (right.len(), position) {
+            // `ctx, cancel := context.WithTimeout(...)`: a context is
+            // always the first result.
+            (1, 0) => Some(right[0]),
+            (1, _) => None,
+            _ => right.get(position).copied(),
+        });
+    });
+    last
+}
+
+/// The position of the parameter `name` of `function`, counting each name
+/// of `a, b T` separately.
+fn parameter_index(function: Node, name: &str, source_code: &str) -> Option<usize> {
+    let parameters = function.child_by_field_name("parameters")?;
+    let mut index = 0;
+    let mut cursor = parameters.walk();
+    for declaration in parameters.named_children(&mut cursor) {
+        if !matches!(
+            declaration.kind(),
+            "parameter_declaration" | "variadic_parameter_declaration"
+        ) {
+            continue;
+        }
+        let mut names = declaration.walk();
+        let declared: Vec<_> = declaration
+            .children_by_field_name("name", &mut names)
+            .collect();
+        if declared.is_empty() {
+            index += 1;
+        }
+        for declared in declared {
+            if node_text(declared, source_code) == name {
+                return Some(index);
+            }
+            index += 1;
+        }
+    }
+    None
+}
+
+/// The name of the function or method `node` is in, looking past function
+/// literals.
+fn enclosing_name<'s>(node: Node, source_code: &'s str) -> Option<&'s str> {
+    let mut scope = enclosing_function(node);
+    while let Some(function) = scope {
+        if let Some(name) = function.child_by_field_name("name") {
+            return Some(node_text(name, source_code));
+        }
+        scope = enclosing_function(function);
+    }
+    None
+}
+
+/// `(operand, field)` of a selector such as `http.Get`.
+fn selector<'s>(node: Node, source_code: &'s str) -> Option<(&'s str, &'s str)> {
+    if node.kind() != "selector_expression" {
+        return None;
+    }
+    Some((
+        node_text(node.child_by_field_name("operand")?, source_code),
+        node_text(node.child_by_field_name("field")?, source_code),
+    ))
+}
+
+/// The fields of a `package.Type{...}` literal with their values, and
+/// those assigned to the variable it's stored in afterwards, as in
+/// `server.WriteTimeout = d` after `server := &http.Server{...}`.
+fn literal_fields<'t>(
+    node: Node<'t>,
+    source_code: &str,
+    package: &str,
+    type_name: &str,
+) -> Option<Vec<(String, Node<'t>)>> {
+    if node.kind() != "composite_literal" {
+        return None;
+    }
+    let ty = node.child_by_field_name("type")?;
+    let is_type = ty.kind() == "qualified_type"
+        && ty
+            .child_by_field_name("package")
+            .is_some_and(|name| node_text(name, source_code) == package)
+        && ty
+            .child_by_field_name("name")
+            .is_some_and(|name| node_text(name, source_code) == type_name);
+    if !is_type {
+        return None;
+    }
+
+    // Newer grammars wrap keys and values in a `literal_element`.
+    let unwrap = |element: Node<'t>| match element.kind() {
+        "literal_element" => element.named_child(0).unwrap_or(element),
+        _ => element,
+    };
+    let mut fields = Vec::new();
+    let body = node.child_by_field_name("body")?;
+    let mut cursor = body.walk();
+    for element in body.named_children(&mut cursor) {
+        if element.kind() != "keyed_element" {
+            continue;
+        }
+        if let (Some(key), Some(value)) = (element.named_child(0), element.named_child(1)) {
+            fields.push((
+                node_text(unwrap(key), source_code).to_string(),
+                unwrap(value),
+            ));
+        }
+    }
+
+    if let Some(variable) = bound_name(node, source_code) {
+        let mut scope = node;
+        while let Some(parent) = scope.parent() {
+            scope = parent;
+            if matches!(scope.kind(), "function_declaration" | "method_declaration") {
+                break;
+            }
+        }
+        visit(scope, &mut |statement| {
+            if statement.kind() != "assignment_statement" {
+                return;
+            }
+            let (Some(left), Some(right)) = (
+                statement.child_by_field_name("left"),
+                statement.child_by_field_name("right"),
+            ) else {
+                return;
+            };
+            for (target, value) in list_items(left).into_iter().zip(list_items(right)) {
+                if let Some((operand, field)) = selector(target, source_code) {
+                    if operand == variable {
+                        fields.push((field.to_string(), value));
+                    }
+                }
+            }
+        });
+    }
+    Some(fields)
+}
+
+/// Whether the file assigns `field` of `operand`, as in
+/// `http.DefaultClient.Timeout = d`.
+fn assigns_field(root: Node, source_code: &str, operand: &str, field: &str) -> bool {
+    let target = format!("{}.{}", operand, field);
+    let mut found = false;
+    visit(root, &mut |node| {
+        if node.kind() == "assignment_statement" {
+            found |= node.child_by_field_name("left").is_some_and(|left| {
+                list_items(left)
+                    .iter()
+                    .any(|item| node_text(*item, source_code) == target)
+            });
+        }
+    });
+    found
+}
+
+/// The variable `expression` is stored in, as `server` in `server := &http.Server{}`.
+fn bound_name(expression: Node, source_code: &str) -> Option<String> {
+    let mut value = expression;
+    while let Some(parent) = value.parent() {
+        if !matches!(
+            parent.kind(),
+            "unary_expression" | "parenthesized_expression"
+        ) {
+            break;
+        }
+        value = parent;
+    }
+    let list = value
+        .parent()
+        .filter(|list| list.kind() == "expression_list")?;
+    let statement = list.parent()?;
+    let position = list_items(list).iter().position(|item| *item == value)?;
+    let names: Vec<Node> = match statement.kind() {
+        "short_var_declaration" | "assignment_statement" => {
+            list_items(statement.child_by_field_name("left")?)
+        }
+        "var_spec" => {
+            let mut cursor = statement.walk();
+            statement
+                .children_by_field_name("name", &mut cursor)
+                .collect()
+        }
+        _ => return None,
+    };
+    let name = names.get(position)?;
+    (name.kind() == "identifier").then(|| node_text(*name, source_code).to_string())
+}
+
+fn is_zero(value: Node, source_code: &str) -> bool {
+    value.kind() == "int_literal"
+        && node_text(value, source_code)
+            .trim_start_matches('0')
+            .is_empty()
+}
+
+/// `a`, `a or b`, `a, b or c`.
+fn join_or(items: &[&str]) -> String {
+    match items.split_last() {
+        Some((last, [])) => last.to_string(),
+        Some((last, rest)) => format!("{} or {}", rest.join(", "), last),
+        None => String::new(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_durations_read_like_go() {
+        assert_eq!(parse_duration("30s"), Some(3e10));
+        assert_eq!(parse_duration("1m30s"), Some(9e10));
+        assert_eq!(parse_duration("1.5h"), Some(5.4e12));
+        assert_eq!(parse_duration("250ms"), Some(2.5e8));
+        assert_eq!(parse_duration("30"), None);
+        assert_eq!(parse_duration("5 minutes"), None);
+        assert_eq!(format_duration(9e10), "90s");
+        assert_eq!(format_duration(6e11), "10m");
+        assert_eq!(format_duration(2.5e8), "250ms");
+    }
+}
@@ -0,0 +1,414 @@
+use super::flow::{nil_check, unreleased, Obligation, Open};
+use super::{
+    enclosing_function, node_text, visit, Check, Granularity, Hit, OptionKind, RuleOptions,
+};
+use crate::taint::matches_pattern;
+use std::collections::HashSet;
+use tree_sitter::Node;
+
+/// Misused `database/sql`, sqlx and pgx transactions.
+///
+/// - A transaction from `Begin`, `BeginTx` or their sqlx spellings that
+///   some path through the function neither commits nor rolls back. The
+///   body is walked path by path, the way the resource leak rule does. The
+///   transaction stops being tracked when it is committed or rolled back,
+///   directly, in a `defer` or in a closure; when it is known to be nil,
+///   on the `err != nil` branch; when it is passed to a function of the
+///   file that commits or rolls back its parameter, or to one of
+///   `finishers`; and when it is returned, stored or sent. Other functions
+///   it is passed to only run statements in it, so the caller still has
+///   to finish it.
+/// - A `tx.Rollback()` statement in the branch taken when `tx.Commit()`
+///   fails. The failed commit has already ended the transaction, so the
+///   rollback can only return `sql.ErrTxDone`, and its error is dropped.
+/// - A query on the handle a transaction was begun on, between `Begin` and
+///   the transaction's last `Commit` in the same function. It runs on
+///   another connection, outside the transaction, and can block on the
+///   rows the transaction has locked.
+pub struct GoTransaction {
+    issue: TransactionIssue,
+}
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("finishers", OptionKind::Strings)];
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum TransactionIssue {
+    /// Transactions some path neither commits nor rolls back.
+    Unfinished,
+    /// Dropped rollback errors after a failed commit.
+    RollbackAfterCommit,
+    /// Queries on the database handle while a transaction is open on it.
+    OutsideQuery,
+}
+
+impl GoTransaction {
+    pub fn new(issue: TransactionIssue) -> Self {
+        GoTransaction { issue }
+    }
+}
+
+const BEGINS: &[&str] = &[
+    "Begin",
+    "BeginTx",
+    "Beginx",
+    "BeginTxx",
+    "MustBegin",
+    "MustBeginTx",
+];
+
+/// Methods that run a statement, on a database handle or a transaction.
+const QUERIES: &[&str] = &[
+    "Exec",
+    "ExecContext",
+    "Query",
+    "QueryContext",
+    "QueryRow",
+    "QueryRowContext",
+    "Prepare",
+    "PrepareContext",
+    "Get",
+    "GetContext",
+    "Select",
+    "SelectContext",
+    "MustExec",
+    "MustExecContext",
+    "NamedExec",
+    "NamedExecContext",
+    "NamedQuery",
+    "NamedQueryContext",
+    "Queryx",
+    "QueryxContext",
+    "QueryRowx",
+    "QueryRowxContext",
+];
+
+const FUNCTION_KINDS: &[&str] = &["function_declaration", "method_declaration", "func_literal"];
+
+impl Check for GoTransaction {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        match self.issue {
+            TransactionIssue::Unfinished => unfinished(root, source_code, options),
+            TransactionIssue::RollbackAfterCommit => rollbacks_after_commit(root, source_code),
+            TransactionIssue::OutsideQuery => outside_queries(root, source_code),
+        }
+    }
+
+    fn granularity(&self) -> Granularity {
+        match self.issue {
+            // Which functions finish a transaction is read from the whole file.
+            TransactionIssue::Unfinished => Granularity::File,
+            TransactionIssue::RollbackAfterCommit | TransactionIssue::OutsideQuery => {
+                Granularity::Declaration
+            }
+        }
+    }
+}
+
+/// A `tx, err := db.BeginTx(...)`.
+struct Begin<'t> {
+    call: Node<'t>,
+    /// The database handle, `db`.
+    handle: String,
+    /// The transaction, `tx`, or `_`.
+    name: String,
+}
+
+/// The transaction `call` begins, when its results are assigned. A plain
+/// `Begin` is only counted with an error alongside, so `Begin` methods of
+/// other types returning one value aren't mistaken for it.
+fn begin<'t>(call: Node<'t>, source_code: &str) -> Option<Begin<'t>> {
+    if call.kind() != "call_expression" {
+        return None;
+    }
+    let function = call.child_by_field_name("function")?;
+    if function.kind() != "selector_expression" {
+        return None;
+    }
+    let method = node_text(function.child_by_field_name("field")?, source_code);
+    if !BEGINS.contains(&method) {
+        return None;
+    }
+    let right = call
+        .parent()
+        .filter(|list| list.kind() == "expression_list")?;
+    let statement = right.parent().filter(|statement| {
+        matches!(
+            statement.kind(),
+            "short_var_declaration" | "assignment_statement"
+        ) && statement.child_by_field_name("right") == Some(right)
+    })?;
+    if right.named_child_count() != 1 {
+        return None;
+    }
+    let left = statement.child_by_field_name("left")?;
+    let mut cursor = left.walk();
+    let names: Vec<&str> = left
+        .named_children(&mut cursor)
+        .map(|name| node_text(name, source_code))
+        .collect();
+    let expected = if method.starts_with("Must") { 1 } else { 2 };
+    if names.len() != expected {
+        return None;
+    }
+    Some(Begin {
+        call,
+        handle: node_text(function.child_by_field_name("operand")?, source_code).to_string(),
+        name: names[0].to_string(),
+    })
+}
+
+/// `x.method(...)` for one of `methods`, as the text of `x`.
+fn method_call<'s>(node: Node, methods: &[&str], source_code: &'s str) -> Option<&'s str> {
+    if node.kind() != "call_expression" {
+        return None;
+    }
+    let function = node.child_by_field_name("function")?;
+    if function.kind() != "selector_expression" {
+        return None;
+    }
+    let field = node_text(function.child_by_field_name("field")?, source_code);
+    let operand = function.child_by_field_name("operand")?;
+    methods
+        .contains(&field)
+        .then(|| node_text(operand, source_code))
+}
+
+/// `tx.Commit()` or `tx.Rollback()`, as the text of `tx`.
+fn finish_call<'s>(node: Node, source_code: &'s str) -> Option<&'s str> {
+    method_call(node, &["Commit", "Rollback"], source_code)
+}
+
+fn unfinished<'t>(root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+    let mut finishers = finishing_functions(root, source_code);
+    finishers.extend(options.string_list("finishers").unwrap_or_default());
+    let transactions = Transactions { finishers };
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if !FUNCTION_KINDS.contains(&node.kind()) {
+            return;
+        }
+        if let Some(body) = node.child_by_field_name("body") {
+            hits.extend(unreleased(&transactions, body, Vec::new(), source_code));
+        }
+    });
+    hits
+}
+
+/// Functions in the file that commit or roll back a parameter, as call
+/// patterns: `name` for functions and `.name` for methods.
+fn finishing_functions(root: Node, source_code: &str) -> Vec<String> {
+    let mut found = Vec::new();
+    visit(root, &mut |node| {
+        let prefix = match node.kind() {
+            "function_declaration" => "",
+            "method_declaration" => ".",
+            _ => return,
+        };
+        let (Some(name), Some(parameters), Some(body)) = (
+            node.child_by_field_name("name"),
+            node.child_by_field_name("parameters"),
+            node.child_by_field_name("body"),
+        ) else {
+            return;
+        };
+        let mut names = HashSet::new();
+        visit(parameters, &mut |parameter| {
+            if parameter.kind() == "parameter_declaration" {
+                let mut cursor = parameter.walk();
+                for name in parameter.children_by_field_name("name", &mut cursor) {
+                    names.insert(node_text(name, source_code));
+                }
+            }
+        });
+        let mut finishes = false;
+        visit(body, &mut |call| {
+            finishes |= finish_call(call, source_code).is_some_and(|tx| names.contains(tx));
+        });
+        if finishes {
+            found.push(format!("{}{}", prefix, node_text(name, source_code)));
+        }
+    });
+    found
+}
+
+struct Transactions {
+    finishers: Vec<String>,
+}
+
+impl Obligation for Transactions {
+    type Kind = ();
+
+    const RETURNS: &'static str = "returns without finishing it";
+
+    fn acquires(&self, call: Node, source_code: &str) -> Option<()> {
+        begin(call, source_code).map(drop)
+    }
+
+    fn releases(&self, call: Node, source_code: &str) -> Option<String> {
+        finish_call(call, source_code).map(str::to_string)
+    }
+
+    /// Functions other than `finishers` only run statements in the
+    /// transaction, so the caller still has to finish it.
+    fn takes(&self, call: Node, _argument: Node, source_code: &str) -> bool {
+        call.child_by_field_name("function")
+            .is_some_and(|function| {
+                let callee = node_text(function, source_code);
+                self.finishers
+                    .iter()
+                    .any(|pattern| matches_pattern(callee, pattern))
+            })
+    }
+
+    fn discarded(&self, callee: &str) -> String {
+        format!(
+            "The transaction from `{}` is discarded, so it is never committed or rolled back",
+            callee
+        )
+    }
+
+    fn unreleased(&self, open: &Open<()>) -> String {
+        format!(
+            "`{}` from `{}` is neither committed nor rolled back on every path",
+            open.name, open.callee
+        )
+    }
+}
+
+fn rollbacks_after_commit<'t>(root: Node<'t>, source_code: &str) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    visit(root, &mut |node| {
+        if node.kind() != "if_statement" {
+            return;
+        }
+        let (Some(condition), Some(consequence)) = (
+            node.child_by_field_name("condition"),
+            node.child_by_field_name("consequence"),
+        ) else {
+            return;
+        };
+        let Some(tx) = failed_commit(node, condition, source_code) else {
+            return;
+        };
+        visit(consequence, &mut |statement| {
+            if statement.kind() != "expression_statement" {
+                return;
+            }
+            let Some(call) = statement.named_child(0) else {
+                return;
+            };
+            if method_call(call, &["Rollback"], source_code) != Some(tx) {
+                return;
+            }
+            let message = format!(
+                "The error of `{0}.Rollback()` is dropped after `{0}.Commit()` failed; the failed commit has already ended the transaction, so the rollback can only return `sql.ErrTxDone`",
+                tx
+            );
+            hits.push(
+                Hit::new(call)
+                    .with_message(message)
+                    .with_related(condition, "the commit fails here"),
+            );
+        });
+    });
+    hits
+}
+
+/// The transaction whose `Commit` failed when `condition` of the `if`
+/// statement holds: `tx.Commit() != nil`, or `err != nil` with `err` set
+/// by `tx.Commit()` in the initializer or the statement before.
+fn failed_commit<'s>(statement: Node, condition: Node, source_code: &'s str) -> Option<&'s str> {
+    let condition = if condition.kind() == "parenthesized_expression" {
+        condition.named_child(0)?
+    } else {
+        condition
+    };
+    if condition.kind() != "binary_expression" {
+        return None;
+    }
+    let operator = node_text(condition.child_by_field_name("operator")?, source_code);
+    let left = condition.child_by_field_name("left")?;
+    let right = condition.child_by_field_name("right")?;
+    if operator != "!=" || right.kind() != "nil" {
+        return None;
+    }
+    if let Some(tx) = method_call(left, &["Commit"], source_code) {
+        return Some(tx);
+    }
+    let (err, true) = nil_check(condition, source_code)? else {
+        return None;
+    };
+    let assignment = statement
+        .child_by_field_name("initializer")
+        .or_else(|| statement.prev_named_sibling())?;
+    if !matches!(
+        assignment.kind(),
+        "short_var_declaration" | "assignment_statement"
+    ) {
+        return None;
+    }
+    let left = assignment.child_by_field_name("left")?;
+    let right = assignment.child_by_field_name("right")?;
+    if left.named_child_count() != 1 || node_text(left, source_code) != err {
+        return None;
+    }
+    method_call(right.named_child(0)?, &["Commit"], source_code)
+}
+
+fn outside_queries<'t>(root: Node<'t>, source_code: &str) -> Vec<Hit<'t>> {
+    let mut hits = Vec::new();
+    visit(root, &mut |call| {
+        let Some(begin) = begin(call, source_code) else {
+            return;
+        };
+        let Some(function) = enclosing_function(call) else {
+            return;
+        };
+        // Queries after the last commit run once the transaction is over.
+        let mut end = function.end_byte();
+        visit(function, &mut |commit| {
+            if commit.start_byte() > call.end_byte()
+                && method_call(commit, &["Commit"], source_code) == Some(begin.name.as_str())
+            {
+                end = commit.start_byte();
+            }
+        });
+        visit(function, &mut |query| {
+            if query.start_byte() < call.end_byte() || query.start_byte() >= end {
+                return;
+            }
+            if method_call(query, QUERIES, source_code) != Some(begin.handle.as_str()) {
+                return;
+            }
+            let Some(method) = query
+                .child_by_field_name("function")
+                .and_then(|function| function.child_by_field_name("field"))
+            else {
+                return;
+            };
+            let method = node_text(method, source_code);
+            let message = if begin.name == "_" {
+                format!(
+                    "`{}.{}` runs outside the transaction begun on line {}",
+                    begin.handle,
+                    method,
+                    begin.call.start_position().row + 1
+                )
+            } else {
+                format!(
+                    "`{0}.{1}` runs outside the transaction `{2}` begun on line {3}; call `{2}.{1}` to make it part of the transaction",
+                    begin.handle,
+                    method,
+                    begin.name,
+                    begin.call.start_position().row + 1
+                )
+            };
+            hits.push(
+                Hit::new(query)
+                    .with_message(message)
+                    .with_related(begin.call, "the transaction begins here"),
+            );
+        });
+    });
+    hits
+}
@@ -0,0 +1,468 @@
+use super::{list_items, node_text, visit, Check, Hit, RuleOptions};
+use std::collections::HashSet;
+use tree_sitter::Node;
+
+/// Flags error values that are assigned and then lost without being looked at.
+///
+/// Variables named like errors (`err`, `closeErr`, `errWrite`) are followed
+/// through each function in document order with Go's block scoping, so a
+/// `:=` in an inner block shadows rather than reuses the outer variable. A
+/// stored error is reported when it is overwritten before any read, goes out
+/// of scope unread, or is followed by a `return` in the same block that
+/// doesn't return it. Named results count as read by bare returns and by
+/// deferred closures; an assignment in a deferred closure to any other outer
+/// variable is reported because nothing can observe it. Errors stored in
+/// struct fields are reported when the field is never read in the file.
+pub struct GoUncheckedError;
+
+impl Check for GoUncheckedError {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+        let mut functions = Vec::new();
+        let mut field_reads = HashSet::new();
+        visit(root, &mut |node| match node.kind() {
+            "function_declaration" | "method_declaration" => functions.push(node),
+            "selector_expression" if !is_assignment_target(node) => {
+                if let Some(field) = node.child_by_field_name("field") {
+                    field_reads.insert(node_text(field, source_code).to_string());
+                }
+            }
+            _ => {}
+        });
+
+        let mut walker = Walker {
+            source_code,
+            vars: Vec::new(),
+            scopes: Vec::new(),
+            next_scope: 0,
+            closures: Vec::new(),
+            field_stores: Vec::new(),
+            hits: Vec::new(),
+        };
+        for function in functions {
+            walker.function(function);
+        }
+
+        for field in std::mem::take(&mut walker.field_stores) {
+            let name = node_text(field, source_code);
+            if !field_reads.contains(name) {
+                walker.hits.push(
+                    Hit::new(field)
+                        .with_message(format!("error stored in field `{}` is never read", name)),
+                );
+            }
+        }
+
+        walker.hits.sort_by_key(|hit| hit.node.start_byte());
+        walker.hits
+    }
+}
+
+struct Store<'t> {
+    target: Node<'t>,
+    scope: usize,
+    closure: usize,
+}
+
+struct Var<'t> {
+    name: String,
+    named_result: bool,
+    /// Read from inside a closure, which may run at any later point.
+    captured: bool,
+    closure: usize,
+    pending: Option<Store<'t>>,
+    reads: Vec<usize>,
+}
+
+struct Scope {
+    id: usize,
+    vars: Vec<usize>,
+}
+
+struct Closure {
+    deferred: bool,
+}
+
+struct Walker<'t, 's> {
+    source_code: &'s str,
+    vars: Vec<Var<'t>>,
+    scopes: Vec<Scope>,
+    next_scope: usize,
+    closures: Vec<Closure>,
+    field_stores: Vec<Node<'t>>,
+    hits: Vec<Hit<'t>>,
+}
+
+impl<'t, 's> Walker<'t, 's> {
+    fn function(&mut self, function: Node<'t>) {
+        let Some(body) = function.child_by_field_name("body") else {
+            return;
+        };
+        self.vars.clear();
+        self.push_scope();
+        if let Some(parameters) = function.child_by_field_name("parameters") {
+            self.declare_parameters(parameters, false);
+        }
+        if let Some(result) = function.child_by_field_name("result") {
+            if result.kind() == "parameter_list" {
+                self.declare_parameters(result, true);
+            }
+        }
+        // Parameters and results share the body's block.
+        self.walk_children(body);
+        self.pop_scope();
+    }
+
+    fn declare_parameters(&mut self, list: Node<'t>, named_result: bool) {
+        let mut cursor = list.walk();
+        for declaration in list.named_children(&mut cursor) {
+            let mut names = declaration.walk();
+            for name in declaration.children_by_field_name("name", &mut names) {
+                let index = self.declare(node_text(name, self.source_code));
+                self.vars[index].named_result = named_result;
+            }
+        }
+    }
+
+    fn walk(&mut self, node: Node<'t>) {
+        match node.kind() {
+            "identifier" => self.read(node),
+            "short_var_declaration" | "assignment_statement" => self.assignment(node),
+            "var_spec" => self.var_spec(node),
+            "selector_expression" => {
+                if let Some(operand) = node.child_by_field_name("operand") {
+                    self.walk(operand);
+                }
+            }
+            "func_literal" => self.closure(node),
+            "return_statement" => self.return_statement(node),
+            "block"
+            | "if_statement"
+            | "for_statement"
+            | "expression_switch_statement"
+            | "type_switch_statement"
+            | "select_statement"
+            | "expression_case"
+            | "type_case"
+            | "communication_case"
+            | "default_case" => {
+                self.push_scope();
+                self.walk_children(node);
+                if node.kind() == "for_statement" {
+                    self.settle_loop(node);
+                }
+                self.pop_scope();
+            }
+            _ => self.walk_children(node),
+        }
+    }
+
+    fn walk_children(&mut self, node: Node<'t>) {
+        let mut cursor = node.walk();
+        let children: Vec<Node<'t>> = node.children(&mut cursor).collect();
+        for child in children {
+            self.walk(child);
+        }
+    }
+
+    fn read(&mut self, identifier: Node<'t>) {
+        let Some(index) = self.resolve(node_text(identifier, self.source_code)) else {
+            return;
+        };
+        let var = &mut self.vars[index];
+        var.pending = None;
+        var.reads.push(identifier.start_byte());
+        if var.closure < self.closures.len() {
+            var.captured = true;
+        }
+    }
+
+    fn assignment(&mut self, node: Node<'t>) {
+        let left = node
+            .child_by_field_name("left")
+            .map(list_items)
+            .unwrap_or_default();
+        let right = node
+            .child_by_field_name("right")
+            .map(list_items)
+            .unwrap_or_default();
+        for value in &right {
+            self.walk(*value);
+        }
+
+        let declares = node.kind() == "short_var_declaration";
+        let operator = node
+            .child_by_field_name("operator")
+            .map(|op| node_text(op, self.source_code));
+        if !declares && operator.is_some_and(|op| op != "=") {
+            // Compound assignments read their target.
+            for target in left {
+                self.walk(target);
+            }
+            return;
+        }
+
+        for (position, target) in left.iter().enumerate() {
+            let value = if left.len() == right.len() {
+                right.get(position)
+            } else {
+                right.first()
+            };
+            let from_call = value.is_some_and(|v| contains_call(*v));
+
+            match target.kind() {
+                "identifier" => {
+                    let name = node_text(*target, self.source_code);
+                    if !is_error_name(name) {
+                        continue;
+                    }
+                    let declared_here = self
+                        .scopes
+                        .last()
+                        .is_some_and(|scope| scope.vars.iter().any(|&i| self.vars[i].name == name));
+                    let index = if declares && !declared_here {
+                        self.declare(name)
+                    } else {
+                        match self.resolve(name) {
+                            Some(index) => index,
+                            None => continue,
+                        }
+                    };
+                    if from_call {
+                        self.store(index, *target);
+                    } else {
+                        self.vars[index].pending = None;
+                    }
+                }
+                "selector_expression" => {
+                    if let Some(operand) = target.child_by_field_name("operand") {
+                        self.walk(operand);
+                    }
+                    let field = target.child_by_field_name("field");
+                    if let Some(field) = field {
+                        if from_call && is_error_name(node_text(field, self.source_code)) {
+                            self.field_stores.push(field);
+                        }
+                    }
+                }
+                _ => self.walk(*target),
+            }
+        }
+    }
+
+    fn var_spec(&mut self, node: Node<'t>) {
+        let values = node
+            .child_by_field_name("value")
+            .map(list_items)
+            .unwrap_or_default();
+        for value in &values {
+            self.walk(*value);
+        }
+        let from_call = values.iter().any(|v| contains_call(*v));
+
+        let mut cursor = node.walk();
+        let names: Vec<Node<'t>> = node.children_by_field_name("name", &mut cursor).collect();
+        for name in names {
+            let text = node_text(name, self.source_code);
+            if !is_error_name(text) {
+                continue;
+            }
+            let index = self.declare(text);
+            if from_call {
+                self.store(index, name);
+            }
+        }
+    }
+
+    fn closure(&mut self, node: Node<'t>) {
+        let deferred = node
+            .parent()
+            .filter(|call| call.kind() == "call_expression")
+            .and_then(|call| call.parent())
+            .is_some_and(|statement| statement.kind() == "defer_statement");
+
+        self.closures.push(Closure { deferred });
+        self.push_scope();
+        if let Some(parameters) = node.child_by_field_name("parameters") {
+            self.declare_parameters(parameters, false);
+        }
+        if let Some(body) = node.child_by_field_name("body") {
+            self.walk_children(body);
+        }
+        self.pop_scope();
+        let closure = self.closures.pop().expect("pushed above");
+
+        // Outer variables written here are only observable if the closure's
+        // caller looks at them later, which a deferred call's caller can't.
+        let depth = self.closures.len();
+        for index in 0..self.vars.len() {
+            let var = &mut self.vars[index];
+            let Some(store) = &var.pending else {
+                continue;
+            };
+            if store.closure <= depth {
+                continue;
+            }
+            let store = var.pending.take().expect("checked above");
+            if closure.deferred && !var.named_result {
+                let message = format!(
+                    "`{}` is set in a deferred function but never returned",
+                    var.name
+                );
+                self.hits.push(Hit::new(store.target).with_message(message));
+            }
+        }
+    }
+
+    fn return_statement(&mut self, node: Node<'t>) {
+        self.walk_children(node);
+        if node.named_child_count() == 0 {
+            for var in &mut self.vars {
+                if var.named_result && var.closure == self.closures.len() {
+                    var.pending = None;
+                }
+            }
+        }
+
+        // A store in the returning block can't be checked on any later path.
+        let Some(block) = self.scopes.last().map(|scope| scope.id) else {
+            return;
+        };
+        for index in 0..self.vars.len() {
+            let returns_here = self.vars[index]
+                .pending
+                .as_ref()
+                .is_some_and(|store| store.scope == block);
+            if returns_here {
+                self.report_unchecked(index);
+            }
+        }
+    }
+
+    /// Reads anywhere in a loop may see a store made later in its body on
+    /// the next iteration.
+    fn settle_loop(&mut self, node: Node<'t>) {
+        let range = node.start_byte()..node.end_byte();
+        for var in &mut self.vars {
+            let stored_in_loop = var
+                .pending
+                .as_ref()
+                .is_some_and(|store| range.contains(&store.target.start_byte()));
+            if stored_in_loop && var.reads.iter().any(|read| range.contains(read)) {
+                var.pending = None;
+            }
+        }
+    }
+
+    fn store(&mut self, index: usize, target: Node<'t>) {
+        let scope = self.scopes.last().map_or(0, |scope| scope.id);
+        let active: Vec<usize> = self.scopes.iter().map(|scope| scope.id).collect();
+
+        if let Some(previous) = self.vars[index].pending.take() {
+            // A store from a sibling branch that already ended may not have run.
+            if active.contains(&previous.scope) {
+                let message = format!(
+                    "`{}` is overwritten before it is checked",
+                    self.vars[index].name
+                );
+                self.hits.push(
+                    Hit::new(previous.target)
+                        .with_message(message)
+                        .with_related(target, "overwritten here"),
+                );
+            }
+        }
+
+        self.vars[index].pending = Some(Store {
+            target,
+            scope,
+            closure: self.closures.len(),
+        });
+    }
+
+    fn report_unchecked(&mut self, index: usize) {
+        let var = &mut self.vars[index];
+        let Some(store) = var.pending.take() else {
+            return;
+        };
+        if var.captured {
+            return;
+        }
+        let message = format!("`{}` is assigned but never checked", var.name);
+        self.hits.push(Hit::new(store.target).with_message(message));
+    }
+
+    fn declare(&mut self, name: &str) -> usize {
+        let index = self.vars.len();
+        self.vars.push(Var {
+            name: name.to_string(),
+            named_result: false,
+            captured: false,
+            closure: self.closures.len(),
+            pending: None,
+            reads: Vec::new(),
+        });
+        if let Some(scope) = self.scopes.last_mut() {
+            scope.vars.push(index);
+        }
+        index
+    }
+
+    fn resolve(&self, name: &str) -> Option<usize> {
+        self.scopes
+            .iter()
+            .rev()
+            .flat_map(|scope| scope.vars.iter().rev())
+            .copied()
+            .find(|&index| self.vars[index].name == name)
+    }
+
+    fn push_scope(&mut self) {
+        self.next_scope += 1;
+        self.scopes.push(Scope {
+            id: self.next_scope,
+            vars: Vec::new(),
+        });
+    }
+
+    fn pop_scope(&mut self) {
+        let Some(scope) = self.scopes.pop() else {
+            return;
+        };
+        for index in scope.vars {
+            self.report_unchecked(index);
+        }
+    }
+}
+
+/// `err`, `closeErr` and `errClose` style names.
+pub(super) fn is_error_name(name: &str) -> bool {
+    if name == "err" || name == "Err" || name.ends_with("Err") {
+        return true;
+    }
+    name.strip_prefix("err")
+        .and_then(|rest| rest.chars().next())
+        .is_some_and(char::is_uppercase)
+}
+
+fn contains_call(node: Node) -> bool {
+    let mut found = false;
+    visit(node, &mut |inner| {
+        if inner.kind() == "call_expression" {
+            found = true;
+        }
+    });
+    found
+}
+
+fn is_assignment_target(node: Node) -> bool {
+    let Some(list) = node.parent() else {
+        return false;
+    };
+    let Some(statement) = list.parent() else {
+        return false;
+    };
+    statement.kind() == "assignment_statement"
+        && statement
+            .child_by_field_name("left")
+            .is_some_and(|left| left == list)
+}
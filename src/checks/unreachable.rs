@@ -0,0 +1,210 @@
+use super::{node_text, visit, Check, Granularity, Hit, OptionKind, RuleOptions};
+use crate::taint::matches_pattern;
+use tree_sitter::Node;
+
+/// Flags the first statement of a block that can't run because the one
+/// before it never completes: a `return`, `goto`, `break` or `continue`, a
+/// call that doesn't return such as `panic` or `os.Exit`, a `for` loop
+/// without a condition or a `break`, or an `if`, `switch` or `select` that
+/// ends that way in every branch.
+///
+/// Branches follow the Go spec's terminating statements, so a `switch` only
+/// ends when it has a `default`, none of its cases `break`, and each ends or
+/// falls through. A labeled statement can be reached with `goto`, so it and
+/// the code after it are left alone.
+///
+/// Options:
+/// - `terminators` (default `[]`): extra calls that never return, added to
+///   the built-in list; a leading `.` matches any receiver.
+pub struct GoUnreachable;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("terminators", OptionKind::Strings)];
+
+/// Calls that never return.
+pub(super) const TERMINATORS: &[&str] = &[
+    "panic",
+    "os.Exit",
+    "log.Fatal",
+    "log.Fatalf",
+    "log.Fatalln",
+    "log.Panic",
+    "log.Panicf",
+    "log.Panicln",
+    "runtime.Goexit",
+    // `testing.T`, and the zap and logrus loggers.
+    ".Fatal",
+    ".Fatalf",
+    ".Fatalln",
+    ".FailNow",
+    ".SkipNow",
+];
+
+impl Check for GoUnreachable {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        let mut terminators: Vec<String> = TERMINATORS.iter().map(|s| s.to_string()).collect();
+        terminators.extend(options.string_list("terminators").unwrap_or_default());
+        let flow = Flow {
+            source_code,
+            terminators,
+        };
+
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if !matches!(
+                node.kind(),
+                "block" | "expression_case" | "type_case" | "default_case" | "communication_case"
+            ) {
+                return;
+            }
+            let mut ended: Option<(Node, String)> = None;
+            for statement in statements(node) {
+                if let Some((before, reason)) = ended.take() {
+                    if statement.kind() != "labeled_statement" {
+                        let message = format!(
+                            "this code is unreachable: the statement before it {}",
+                            reason
+                        );
+                        hits.push(
+                            Hit::new(statement)
+                                .with_message(message)
+                                .with_related(before, "never completes"),
+                        );
+                        break;
+                    }
+                }
+                ended = flow.ends(statement).map(|reason| (statement, reason));
+            }
+        });
+        hits
+    }
+
+    fn granularity(&self) -> Granularity {
+        Granularity::Declaration
+    }
+}
+
+struct Flow<'s> {
+    source_code: &'s str,
+    terminators: Vec<String>,
+}
+
+impl Flow<'_> {
+    /// Why control never moves past `statement`, if it doesn't.
+    fn ends(&self, statement: Node) -> Option<String> {
+        match statement.kind() {
+            "return_statement" => Some("returns".to_string()),
+            "goto_statement" => Some("jumps away with `goto`".to_string()),
+            "break_statement" => Some("breaks out".to_string()),
+            "continue_statement" => Some("continues with the next iteration".to_string()),
+            "expression_statement" => {
+                let call = statement.named_child(0)?;
+                if call.kind() != "call_expression" {
+                    return None;
+                }
+                let function = node_text(call.child_by_field_name("function")?, self.source_code);
+                self.terminators
+                    .iter()
+                    .any(|pattern| matches_pattern(function, pattern))
+                    .then(|| format!("calls `{}`, which doesn't return", function))
+            }
+            "block" => self.ends(*statements(statement).last()?),
+            "labeled_statement" => {
+                let mut cursor = statement.walk();
+                let inner = statement.named_children(&mut cursor).last()?;
+                self.ends(inner)
+            }
+            "if_statement" => {
+                let consequence = statement.child_by_field_name("consequence")?;
+                let alternative = statement.child_by_field_name("alternative")?;
+                self.ends(consequence)?;
+                self.ends(alternative)?;
+                Some("ends on every branch".to_string())
+            }
+            "for_statement" => {
+                let infinite = match statement.named_child(0) {
+                    Some(clause) if clause.kind() == "for_clause" => {
+                        clause.child_by_field_name("condition").is_none()
+                    }
+                    Some(body) => body.kind() == "block",
+                    None => false,
+                };
+                (infinite && !breaks_out(statement.child_by_field_name("body")?))
+                    .then(|| "loops forever".to_string())
+            }
+            "expression_switch_statement" | "type_switch_statement" | "select_statement" => {
+                let mut cursor = statement.walk();
+                let cases: Vec<Node> = statement
+                    .named_children(&mut cursor)
+                    .filter(|child| child.kind().ends_with("_case"))
+                    .collect();
+                let has_default = cases.iter().any(|case| case.kind() == "default_case");
+                if !has_default && statement.kind() != "select_statement" {
+                    return None;
+                }
+                for case in &cases {
+                    if breaks_out(*case) {
+                        return None;
+                    }
+                    let last = *statements(*case).last()?;
+                    if last.kind() != "fallthrough_statement" {
+                        self.ends(last)?;
+                    }
+                }
+                Some("ends in every case".to_string())
+            }
+            _ => None,
+        }
+    }
+}
+
+/// The statements of a block or case clause, looking through the
+/// `statement_list` newer grammars wrap them in and leaving out a case's
+/// values and its receive or send.
+fn statements(node: Node) -> Vec<Node> {
+    let mut found = Vec::new();
+    let mut cursor = node.walk();
+    if !cursor.goto_first_child() {
+        return found;
+    }
+    loop {
+        let child = cursor.node();
+        let is_label = matches!(
+            cursor.field_name(),
+            Some("value" | "type" | "communication")
+        );
+        match child.kind() {
+            _ if is_label || !child.is_named() => {}
+            "comment" | "empty_statement" => {}
+            "statement_list" => found.extend(statements(child)),
+            _ => found.push(child),
+        }
+        if !cursor.goto_next_sibling() {
+            return found;
+        }
+    }
+}
+
+/// Whether a `break` or `goto` inside `node` can leave the loop, switch or
+/// select it belongs to. Labeled breaks are assumed to.
+fn breaks_out(node: Node) -> bool {
+    let mut cursor = node.walk();
+    let children: Vec<Node> = node.named_children(&mut cursor).collect();
+    children.into_iter().any(|child| match child.kind() {
+        "break_statement" | "goto_statement" => true,
+        "for_statement"
+        | "expression_switch_statement"
+        | "type_switch_statement"
+        | "select_statement"
+        | "func_literal" => labeled_break(child),
+        _ => breaks_out(child),
+    })
+}
+
+fn labeled_break(node: Node) -> bool {
+    let mut found = false;
+    visit(node, &mut |child| {
+        found |= child.kind() == "goto_statement"
+            || (child.kind() == "break_statement" && child.named_child_count() > 0);
+    });
+    found
+}
@@ -0,0 +1,239 @@
+use super::api_misuse::imported_as;
+use super::import_policy::Pattern;
+use super::unused_import::import_path;
+use super::{node_text, unknown_option, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::package::Package;
+use tree_sitter::Node;
+
+/// Code that steps outside Go's memory safety, catalogued so that it stays
+/// in the few packages meant to hold it.
+///
+/// Every use is reported, not just the ones that look wrong: these rules
+/// are for reviewing where such code lives, and they leave out the
+/// packages their `allow_in` option names. `compass audit` lists the uses
+/// there as well.
+///
+/// Options:
+/// - `allow_in` (default `[]`): package patterns, as `go_import_policy`
+///   takes them, where uses are expected, e.g. `"./internal/runtime-shim/..."`.
+/// - `audit` (default `false`): report uses in `allow_in` packages too,
+///   saying they are allowed there.
+pub struct GoUnsafe {
+    issue: UnsafeIssue,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+pub enum UnsafeIssue {
+    /// `unsafe.Pointer` and the pointer arithmetic built on it.
+    Pointer,
+    /// `reflect.SliceHeader` and `reflect.StringHeader`.
+    SliceHeader,
+    /// `//go:linkname` directives.
+    Linkname,
+    /// `import "C"`.
+    Cgo,
+}
+
+impl GoUnsafe {
+    pub fn new(issue: UnsafeIssue) -> Self {
+        GoUnsafe { issue }
+    }
+}
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[
+    ("allow_in", OptionKind::Strings),
+    ("audit", OptionKind::Bool),
+];
+
+/// The `unsafe` functions that make or move pointers. `Sizeof`, `Alignof`
+/// and `Offsetof` are constants and are left alone.
+const POINTER_FUNCTIONS: &[&str] = &[
+    "Pointer",
+    "Add",
+    "Slice",
+    "SliceData",
+    "String",
+    "StringData",
+];
+
+const HEADERS: &[&str] = &["SliceHeader", "StringHeader"];
+
+/// Compiles `allow_in`, so that a bad pattern fails when the config loads.
+pub(super) fn allowed_packages(options: &RuleOptions) -> Result<Vec<Pattern>, String> {
+    if let Some(error) = options.keys().find_map(|key| unknown_option(OPTIONS, key)) {
+        return Err(error);
+    }
+    options
+        .string_list("allow_in")
+        .unwrap_or_default()
+        .iter()
+        .map(|pattern| Pattern::compile(pattern).map_err(|e| format!("`allow_in`: {}", e)))
+        .collect()
+}
+
+impl Check for GoUnsafe {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let Ok(allow_in) = allowed_packages(options) else {
+            return Vec::new();
+        };
+        // Without a module there is no import path to match the patterns to.
+        let allowed = package.and_then(|package| {
+            let path = package.import_path()?;
+            let module = package.module.as_ref()?;
+            allow_in
+                .iter()
+                .find(|pattern| pattern.matches(&path, &module.path))
+                .map(|pattern| pattern.text.clone())
+        });
+        let audit = options.bool("audit").unwrap_or(false);
+        if allowed.is_some() && !audit {
+            return Vec::new();
+        }
+
+        let mut hits: Vec<Hit<'t>> = match self.issue {
+            UnsafeIssue::Pointer => uses(root, source_code, "unsafe", POINTER_FUNCTIONS)
+                .into_iter()
+                .map(|(node, name)| {
+                    let message = if name == "Pointer" {
+                        "`unsafe.Pointer` bypasses Go's type and memory safety".to_string()
+                    } else {
+                        format!(
+                            "`unsafe.{}` works on raw pointers that Go doesn't check",
+                            name
+                        )
+                    };
+                    Hit::new(node).with_message(message)
+                })
+                .collect(),
+            UnsafeIssue::SliceHeader => uses(root, source_code, "reflect", HEADERS)
+                .into_iter()
+                .map(|(node, name)| {
+                    let replacement = if name == "SliceHeader" {
+                        "unsafe.Slice"
+                    } else {
+                        "unsafe.String"
+                    };
+                    let message = format!(
+                        "`reflect.{}` is deprecated, and a header built by hand isn't kept alive by the garbage collector; use `{}`",
+                        name, replacement
+                    );
+                    Hit::new(node).with_message(message)
+                })
+                .collect(),
+            UnsafeIssue::Linkname => {
+                let mut hits = Vec::new();
+                visit(root, &mut |node| {
+                    if node.kind() != "comment" {
+                        return;
+                    }
+                    let Some(rest) = node_text(node, source_code).strip_prefix("//go:linkname")
+                    else {
+                        return;
+                    };
+                    let mut names = rest.split_whitespace();
+                    let message = match (names.next(), names.next()) {
+                        (Some(local), Some(target)) => format!(
+                            "`//go:linkname` binds `{}` to `{}`, which its package doesn't export and can change in any release",
+                            local, target
+                        ),
+                        _ => "`//go:linkname` exposes a symbol its package doesn't export".to_string(),
+                    };
+                    hits.push(Hit::new(node).with_message(message));
+                });
+                hits
+            }
+            UnsafeIssue::Cgo => {
+                let mut cgo = None;
+                let mut references = 0;
+                visit(root, &mut |node| match node.kind() {
+                    "import_spec" if import_path(node, source_code) == Some("C") => {
+                        cgo.get_or_insert(node);
+                    }
+                    "selector_expression" | "qualified_type" => {
+                        let operand = node
+                            .child_by_field_name("operand")
+                            .or_else(|| node.child_by_field_name("package"));
+                        if operand.is_some_and(|operand| node_text(operand, source_code) == "C") {
+                            references += 1;
+                        }
+                    }
+                    _ => {}
+                });
+                cgo.map(|node| {
+                    let message = format!(
+                        "the file uses cgo ({} reference{} to C), whose code Go's memory safety doesn't cover and which needs a C toolchain to build",
+                        references,
+                        if references == 1 { "" } else { "s" }
+                    );
+                    Hit::new(node).with_message(message)
+                })
+                .into_iter()
+                .collect()
+            }
+        };
+
+        if let Some(pattern) = allowed {
+            hits = hits
+                .into_iter()
+                .map(|hit| {
+                    let message = format!(
+                        "{} (allowed in `{}`)",
+                        hit.message.clone().unwrap_or_default(),
+                        pattern
+                    );
+                    hit.with_message(message)
+                })
+                .collect();
+        }
+        hits
+    }
+}
+
+/// The references to `names` of the package imported from `path`, with the
+/// name each refers to, in expressions and in types.
+fn uses<'t>(
+    root: Node<'t>,
+    source_code: &str,
+    path: &str,
+    names: &[&'static str],
+) -> Vec<(Node<'t>, &'static str)> {
+    let Some(local) = imported_as(root, source_code, path) else {
+        return Vec::new();
+    };
+    let mut found = Vec::new();
+    visit(root, &mut |node| {
+        let (package, name) = match node.kind() {
+            "selector_expression" => ("operand", "field"),
+            "qualified_type" => ("package", "name"),
+            _ => return,
+        };
+        let (Some(package), Some(name)) = (
+            node.child_by_field_name(package),
+            node.child_by_field_name(name),
+        ) else {
+            return;
+        };
+        if node_text(package, source_code) != local {
+            return;
+        }
+        let name = node_text(name, source_code);
+        if let Some(&name) = names.iter().find(|candidate| **candidate == name) {
+            found.push((node, name));
+        }
+    });
+    found
+}
@@ -0,0 +1,346 @@
+use super::unused_import::import_path;
+use super::{matches_name, node_text, receiver_type, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use crate::project::is_generated;
+use std::collections::HashSet;
+use std::ops::Range;
+use tree_sitter::{Node, Parser};
+
+/// Flags unexported functions, methods, constants and struct fields that
+/// nothing in the package refers to.
+///
+/// Unexported names can only be used inside their package, so every Go
+/// file in the directory is searched, tests included and whatever their
+/// build constraints: code used only under another GOOS or build tag still
+/// counts as used. References are matched by name, so a local variable or
+/// another type's method of the same name also counts, and a method named
+/// in an interface is taken to implement it. Without a package the check
+/// reports nothing.
+///
+/// Some uses don't show up as references and are allowed for:
+/// - functions named in `//go:linkname` or cgo `//export` directives, and
+///   functions without a body, which are implemented elsewhere;
+/// - methods, when the package calls `MethodByName`;
+/// - fields with a struct tag, fields of structs built by position
+///   (`point{1, 2}`), and every field when the package imports `unsafe` or
+///   `encoding/binary`, where the layout matters;
+/// - constants in a group using `iota`, whose values depend on position.
+///
+/// `init`, `main`, `_` and generated files are never reported.
+///
+/// Options:
+/// - `allow` (default `[]`): names, or prefixes ending in `*`, not to report.
+pub struct GoUnused;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("allow", OptionKind::Strings)];
+
+struct Candidate<'t> {
+    name_node: Node<'t>,
+    name: String,
+    kind: Kind,
+    /// What the name is shown as: `conn.reset` for a method or a field.
+    label: String,
+    /// References here, e.g. a recursive call, don't count.
+    own: Range<usize>,
+}
+
+#[derive(Clone, Copy, PartialEq)]
+enum Kind {
+    Function,
+    Method,
+    Constant,
+    Field,
+}
+
+/// Names referenced across the package.
+#[derive(Default)]
+struct References {
+    /// Identifiers, with where they are: functions and constants.
+    identifiers: Vec<(String, usize)>,
+    /// Selected names and composite literal keys: methods and fields.
+    members: Vec<(String, usize)>,
+    /// Functions named by `//go:linkname` and `//export`.
+    directives: HashSet<String>,
+    /// Struct types built with positional fields.
+    positional: HashSet<String>,
+    reflects_methods: bool,
+    layout_matters: bool,
+}
+
+impl Check for GoUnused {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn needs_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let Some(package) = package else {
+            return Vec::new();
+        };
+        if is_generated(source_code) {
+            return Vec::new();
+        }
+        let allowed = options.string_list("allow").unwrap_or_default();
+        let candidates: Vec<Candidate<'t>> = candidates(root, source_code)
+            .into_iter()
+            .filter(|candidate| !allowed.iter().any(|p| matches_name(&candidate.name, p)))
+            .collect();
+        if candidates.is_empty() {
+            return Vec::new();
+        }
+
+        let mut references = References::default();
+        collect_references(root, source_code, true, &mut references);
+        let mut parser = Parser::new();
+        if parser
+            .set_language(&SupportedLanguage::Go.tree_sitter_language())
+            .is_err()
+        {
+            return Vec::new();
+        }
+        for file in &package.files {
+            if let Some(tree) = parser.parse(&file.source_code, None) {
+                collect_references(tree.root_node(), &file.source_code, false, &mut references);
+            }
+        }
+
+        let mut hits = Vec::new();
+        for candidate in candidates {
+            let names = match candidate.kind {
+                Kind::Function | Kind::Constant => &references.identifiers,
+                Kind::Method | Kind::Field => &references.members,
+            };
+            let used = names
+                .iter()
+                .any(|(name, at)| *name == candidate.name && !candidate.own.contains(at));
+            let exempt = match candidate.kind {
+                Kind::Function => references.directives.contains(&candidate.name),
+                Kind::Method => references.reflects_methods,
+                Kind::Field => {
+                    references.layout_matters
+                        || candidate
+                            .label
+                            .split_once('.')
+                            .is_some_and(|(ty, _)| references.positional.contains(ty))
+                }
+                Kind::Constant => false,
+            };
+            if used || exempt {
+                continue;
+            }
+            let kind = match candidate.kind {
+                Kind::Function => "function",
+                Kind::Method => "method",
+                Kind::Constant => "constant",
+                Kind::Field => "field",
+            };
+            let message = format!(
+                "the {} `{}` is not used anywhere in the package",
+                kind, candidate.label
+            );
+            hits.push(Hit::new(candidate.name_node).with_message(message));
+        }
+        hits
+    }
+}
+
+fn is_unexported(name: &str) -> bool {
+    name != "_" && name.starts_with(|c: char| c == '_' || c.is_lowercase())
+}
+
+/// The file's unexported top-level declarations.
+fn candidates<'t>(root: Node<'t>, source_code: &str) -> Vec<Candidate<'t>> {
+    let mut found = Vec::new();
+    let mut push = |name_node: Node<'t>, kind: Kind, label: String, own: Range<usize>| {
+        let name = node_text(name_node, source_code).to_string();
+        if is_unexported(&name) {
+            found.push(Candidate {
+                name_node,
+                name,
+                kind,
+                label,
+                own,
+            });
+        }
+    };
+
+    let mut cursor = root.walk();
+    for node in root.named_children(&mut cursor) {
+        match node.kind() {
+            "function_declaration" => {
+                let Some(name) = node.child_by_field_name("name") else {
+                    continue;
+                };
+                let text = node_text(name, source_code);
+                if text == "init" || text == "main" || node.child_by_field_name("body").is_none() {
+                    continue;
+                }
+                push(name, Kind::Function, text.to_string(), node.byte_range());
+            }
+            "method_declaration" => {
+                let Some(name) = node.child_by_field_name("name") else {
+                    continue;
+                };
+                let label = match receiver_type(node, source_code) {
+                    Some(receiver) => format!("{}.{}", receiver, node_text(name, source_code)),
+                    None => node_text(name, source_code).to_string(),
+                };
+                push(name, Kind::Method, label, node.byte_range());
+            }
+            "const_declaration" => {
+                if node_text(node, source_code).contains("iota") {
+                    continue;
+                }
+                let mut specs = node.walk();
+                for spec in node.named_children(&mut specs) {
+                    if spec.kind() != "const_spec" {
+                        continue;
+                    }
+                    let mut names = spec.walk();
+                    for name in spec.children_by_field_name("name", &mut names) {
+                        let label = node_text(name, source_code).to_string();
+                        push(name, Kind::Constant, label, name.byte_range());
+                    }
+                }
+            }
+            "type_declaration" => {
+                let mut specs = node.walk();
+                for spec in node.named_children(&mut specs) {
+                    let (Some(type_name), Some(ty)) = (
+                        spec.child_by_field_name("name"),
+                        spec.child_by_field_name("type"),
+                    ) else {
+                        continue;
+                    };
+                    if ty.kind() != "struct_type" {
+                        continue;
+                    }
+                    let type_name = node_text(type_name, source_code);
+                    visit(ty, &mut |field| {
+                        // Nested struct types have fields of their own, but
+                        // no name to show them under.
+                        let nested = field
+                            .parent()
+                            .and_then(|list| list.parent())
+                            .is_some_and(|owner| owner.id() != ty.id());
+                        if field.kind() != "field_declaration"
+                            || nested
+                            || field.child_by_field_name("tag").is_some()
+                        {
+                            return;
+                        }
+                        let mut names = field.walk();
+                        for name in field.children_by_field_name("name", &mut names) {
+                            let label = format!("{}.{}", type_name, node_text(name, source_code));
+                            push(name, Kind::Field, label, name.byte_range());
+                        }
+                    });
+                }
+            }
+            _ => {}
+        }
+    }
+    found
+}
+
+/// Adds what `root` refers to. References in sibling files are placed past
+/// the end of the analyzed file, outside any of its declarations.
+fn collect_references(root: Node, source_code: &str, in_file: bool, refs: &mut References) {
+    let at = |node: Node| match in_file {
+        true => node.start_byte(),
+        false => usize::MAX,
+    };
+    visit(root, &mut |node| {
+        let text = || node_text(node, source_code).to_string();
+        match node.kind() {
+            "identifier" => {
+                if declares(node, &["function_declaration", "const_spec"]) {
+                    return;
+                }
+                if is_literal_key(node) {
+                    refs.members.push((text(), at(node)));
+                }
+                refs.identifiers.push((text(), at(node)));
+            }
+            "field_identifier" => {
+                if !declares(node, &["method_declaration", "field_declaration"]) {
+                    refs.members.push((text(), at(node)));
+                }
+            }
+            "composite_literal" => {
+                let (Some(ty), Some(body)) = (
+                    node.child_by_field_name("type"),
+                    node.child_by_field_name("body"),
+                ) else {
+                    return;
+                };
+                let mut cursor = body.walk();
+                let positional = body
+                    .named_children(&mut cursor)
+                    .any(|element| !matches!(element.kind(), "keyed_element" | "comment"));
+                if positional {
+                    let ty = node_text(ty, source_code);
+                    refs.positional
+                        .insert(ty.split('[').next().unwrap_or(ty).to_string());
+                }
+            }
+            "comment" => {
+                let comment = node_text(node, source_code);
+                let directive = comment
+                    .strip_prefix("//go:linkname ")
+                    .or_else(|| comment.strip_prefix("//export "));
+                if let Some(name) = directive.and_then(|rest| rest.split_whitespace().next()) {
+                    refs.directives.insert(name.to_string());
+                }
+            }
+            "import_spec" => {
+                let path = import_path(node, source_code);
+                refs.layout_matters |= matches!(path, Some("unsafe" | "encoding/binary"));
+            }
+            "selector_expression" => {
+                refs.reflects_methods |= node
+                    .child_by_field_name("field")
+                    .is_some_and(|field| node_text(field, source_code) == "MethodByName");
+            }
+            _ => {}
+        }
+    });
+}
+
+/// Whether `name` is the name declared by its parent, of one of `kinds`.
+fn declares(name: Node, kinds: &[&str]) -> bool {
+    let Some(parent) = name.parent().filter(|p| kinds.contains(&p.kind())) else {
+        return false;
+    };
+    let mut cursor = parent.walk();
+    let declared = parent
+        .children_by_field_name("name", &mut cursor)
+        .any(|declared| declared == name);
+    declared
+}
+
+/// The key of a keyed element, as in `config{retries: 3}`.
+fn is_literal_key(identifier: Node) -> bool {
+    let mut key = identifier;
+    if let Some(parent) = key.parent().filter(|p| p.kind() == "literal_element") {
+        key = parent;
+    }
+    key.parent()
+        .filter(|element| element.kind() == "keyed_element")
+        .and_then(|element| element.named_child(0))
+        == Some(key)
+}
@@ -0,0 +1,119 @@
+use super::{line_extent, node_text, visit, Check, Hit, RuleOptions};
+use crate::fix::{Fix, TextEdit};
+use std::collections::{HashMap, HashSet};
+use tree_sitter::Node;
+
+/// Flags Go imports whose package name is never referenced in the file.
+///
+/// Without type information the package name is taken from the alias or the
+/// last import path element, so paths whose last element isn't a valid
+/// identifier (`go-sqlite3`, `yaml.v3`) are skipped rather than guessed.
+pub struct GoUnusedImport;
+
+impl Check for GoUnusedImport {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+        let mut specs = Vec::new();
+        let mut referenced = HashSet::new();
+
+        visit(root, &mut |node| match node.kind() {
+            "import_spec" => specs.push(node),
+            "package_identifier" => {
+                if node.parent().map(|p| p.kind()) != Some("import_spec") {
+                    referenced.insert(node_text(node, source_code).to_string());
+                }
+            }
+            "selector_expression" => {
+                if let Some(operand) = node.child_by_field_name("operand") {
+                    if operand.kind() == "identifier" {
+                        referenced.insert(node_text(operand, source_code).to_string());
+                    }
+                }
+            }
+            _ => {}
+        });
+
+        specs
+            .into_iter()
+            .filter_map(|spec| {
+                let name = local_name(spec, source_code)?;
+                if referenced.contains(&name) {
+                    return None;
+                }
+                Some(Hit::new(spec).with_fix(removal(spec, source_code)))
+            })
+            .collect()
+    }
+}
+
+/// The name an import spec binds in the file: its alias, or else the last
+/// import path element that looks like a package name.
+pub(crate) fn local_name(spec: Node, source_code: &str) -> Option<String> {
+    if let Some(alias) = spec.child_by_field_name("name") {
+        // Blank and dot imports are used for their side effects or scope.
+        return match alias.kind() {
+            "package_identifier" => Some(node_text(alias, source_code).to_string()),
+            _ => None,
+        };
+    }
+
+    let path = import_path(spec, source_code)?;
+    if path == "C" {
+        return None;
+    }
+
+    let mut segments = path.rsplit('/');
+    let mut last = segments.next()?;
+    if is_major_version(last) {
+        last = segments.next()?;
+    }
+
+    let valid = last.chars().all(|c| c.is_alphanumeric() || c == '_')
+        && !last.starts_with(|c: char| c.is_ascii_digit());
+    valid.then(|| last.to_string())
+}
+
+pub(crate) fn import_path<'s>(spec: Node, source_code: &'s str) -> Option<&'s str> {
+    let path = spec.child_by_field_name("path")?;
+    Some(node_text(path, source_code).trim_matches(|c| c == '"' || c == '`'))
+}
+
+/// The file's imports, from the name they are used under to their path.
+pub(crate) fn imports(root: Node, source_code: &str) -> HashMap<String, String> {
+    let mut imports = HashMap::new();
+    visit(root, &mut |node| {
+        if node.kind() != "import_spec" {
+            return;
+        }
+        if let (Some(path), Some(local)) = (
+            import_path(node, source_code),
+            local_name(node, source_code),
+        ) {
+            imports.insert(local, path.to_string());
+        }
+    });
+    imports
+}
+
+fn is_major_version(segment: &str) -> bool {
+    segment.len() > 1
+        && segment.starts_with('v')
+        && segment[1..].chars().all(|c| c.is_ascii_digit())
+}
+
+fn removal(spec: Node, source_code: &str) -> Fix {
+    // A lone `import "fmt"` goes away entirely; a spec in a group leaves the group.
+    let target = match spec.parent() {
+        Some(parent) if parent.kind() == "import_declaration" => parent,
+        _ => spec,
+    };
+    let (start_byte, end_byte) = line_extent(target, source_code);
+
+    Fix {
+        description: "Remove unused import".to_string(),
+        edits: vec![TextEdit {
+            start_byte,
+            end_byte,
+            replacement: String::new(),
+        }],
+    }
+}
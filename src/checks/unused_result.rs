@@ -0,0 +1,434 @@
+use super::api_misuse::{imported_as, is_discarded};
+use super::time::{Times, DERIVED};
+use super::unused_import::{import_path, local_name};
+use super::{node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::analyzer::Confidence;
+use crate::fix::{Fix, TextEdit};
+use crate::language::SupportedLanguage;
+use crate::module::{collect_facts, Module, PackageFacts};
+use crate::package::Package;
+use std::collections::{HashMap, HashSet};
+use std::sync::Arc;
+use tree_sitter::{Node, Parser};
+
+/// Calls whose only effect is their result, made without using it: the
+/// string, byte, path and slice functions of the standard library that
+/// return a modified copy, `append`, `ctx.Value`, `time.Time` methods, and
+/// functions marked `//compass:mustuse` or listed in config. A result is
+/// unused when the call is a statement of its own, is deferred or started
+/// as a goroutine, or is assigned only to `_`.
+///
+/// `//compass:mustuse` on a line of a function's doc comment covers calls
+/// from its own package and, through the file's `go.mod`, from packages
+/// importing it. A marked method is matched by name alone, since the
+/// receiver's type isn't known, so those findings have medium confidence.
+///
+/// Options:
+/// - `functions` (default `[]`): more functions whose result must be
+///   used, by import path and name (`"example.com/app/money.Round"`), or
+///   methods of any receiver as `".Name"`.
+pub struct GoUnusedResult;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] = &[("functions", OptionKind::Strings)];
+
+/// Functions that only compute their result, by import path.
+const PURE: &[(&str, &[&str])] = &[
+    (
+        "strings",
+        &[
+            "Clone",
+            "Compare",
+            "Contains",
+            "ContainsAny",
+            "ContainsRune",
+            "Count",
+            "EqualFold",
+            "Fields",
+            "HasPrefix",
+            "HasSuffix",
+            "Index",
+            "Join",
+            "LastIndex",
+            "Repeat",
+            "Replace",
+            "ReplaceAll",
+            "Split",
+            "SplitN",
+            "Title",
+            "ToLower",
+            "ToTitle",
+            "ToUpper",
+            "Trim",
+            "TrimFunc",
+            "TrimLeft",
+            "TrimPrefix",
+            "TrimRight",
+            "TrimSpace",
+            "TrimSuffix",
+        ],
+    ),
+    (
+        "bytes",
+        &[
+            "Clone",
+            "Compare",
+            "Contains",
+            "Equal",
+            "EqualFold",
+            "Fields",
+            "HasPrefix",
+            "HasSuffix",
+            "Index",
+            "Join",
+            "Repeat",
+            "Replace",
+            "ReplaceAll",
+            "Split",
+            "ToLower",
+            "ToUpper",
+            "Trim",
+            "TrimLeft",
+            "TrimPrefix",
+            "TrimRight",
+            "TrimSpace",
+            "TrimSuffix",
+        ],
+    ),
+    ("errors", &["Is", "Join", "New", "Unwrap"]),
+    ("fmt", &["Errorf", "Sprint", "Sprintf", "Sprintln"]),
+    ("path", &["Base", "Clean", "Dir", "Ext", "Join"]),
+    (
+        "path/filepath",
+        &["Base", "Clean", "Dir", "Ext", "Join", "ToSlash"],
+    ),
+    (
+        "strconv",
+        &["FormatBool", "FormatFloat", "FormatInt", "Itoa", "Quote"],
+    ),
+    (
+        "slices",
+        &[
+            "Clone",
+            "Compact",
+            "CompactFunc",
+            "Concat",
+            "Contains",
+            "Delete",
+            "DeleteFunc",
+            "Equal",
+            "Grow",
+            "Index",
+            "Insert",
+            "Max",
+            "Min",
+            "Replace",
+        ],
+    ),
+    ("maps", &["Clone", "Keys", "Values"]),
+    (
+        "context",
+        &["WithCancel", "WithDeadline", "WithTimeout", "WithValue"],
+    ),
+];
+
+/// `time.Time` methods with no effect besides their result, on top of the
+/// ones returning another time.
+const TIME_QUERIES: &[&str] = &[
+    "After",
+    "Before",
+    "Compare",
+    "Equal",
+    "Format",
+    "IsZero",
+    "String",
+    "Sub",
+    "Unix",
+    "UnixMilli",
+    "UnixNano",
+];
+
+/// Why a call's result must be used.
+enum Reason {
+    /// Only computes its result.
+    Pure,
+    /// `append`, whose result is the only way to reach what it appended.
+    Append,
+    /// A `time.Time` method returning a new time.
+    DerivedTime,
+    /// Marked `//compass:mustuse`.
+    Marked,
+    /// Listed in `functions`.
+    Listed,
+}
+
+impl Check for GoUnusedResult {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let listed = options.string_list("functions").unwrap_or_default();
+        let own = own_facts(root, source_code, package);
+        let own_path = package.and_then(Package::import_path);
+        let module = package.and_then(|package| package.module.as_ref());
+        let time = imported_as(root, source_code, "time");
+        let times = time
+            .as_deref()
+            .map(|time| Times::collect(root, source_code, time))
+            .unwrap_or_default();
+        let contexts = imported_as(root, source_code, "context")
+            .map(|context| contexts(root, source_code, &context))
+            .unwrap_or_default();
+
+        let imports = imports(root, source_code, module);
+
+        let mut hits = Vec::new();
+        visit(root, &mut |call| {
+            if call.kind() != "call_expression" || !is_discarded(call, source_code) {
+                return;
+            }
+            let Some(function) = call.child_by_field_name("function") else {
+                return;
+            };
+            let callee = node_text(function, source_code);
+            let mut confidence = Confidence::High;
+            let reason = match function.kind() {
+                "identifier" if callee == "append" => Some(Reason::Append),
+                "identifier" if own.must_use.contains(callee) => Some(Reason::Marked),
+                "identifier"
+                    if own_path
+                        .as_ref()
+                        .is_some_and(|path| listed.contains(&format!("{}.{}", path, callee))) =>
+                {
+                    Some(Reason::Listed)
+                }
+                "selector_expression" => {
+                    let (Some(operand), Some(field)) = (
+                        function.child_by_field_name("operand"),
+                        function.child_by_field_name("field"),
+                    ) else {
+                        return;
+                    };
+                    let method = node_text(field, source_code);
+                    let import = (operand.kind() == "identifier")
+                        .then(|| imports.get(node_text(operand, source_code)))
+                        .flatten();
+                    if let Some((path, facts)) = import {
+                        let qualified = format!("{}.{}", path, method);
+                        if listed.contains(&qualified) {
+                            Some(Reason::Listed)
+                        } else if facts
+                            .as_ref()
+                            .is_some_and(|facts| facts.must_use.contains(method))
+                        {
+                            Some(Reason::Marked)
+                        } else {
+                            is_pure(path, method).then_some(Reason::Pure)
+                        }
+                    } else if listed.iter().any(|entry| entry == &format!(".{}", method)) {
+                        Some(Reason::Listed)
+                    } else if time
+                        .as_deref()
+                        .is_some_and(|time| times.is_time(operand, source_code, time))
+                    {
+                        if DERIVED.contains(&method) {
+                            Some(Reason::DerivedTime)
+                        } else {
+                            TIME_QUERIES.contains(&method).then_some(Reason::Pure)
+                        }
+                    } else if method == "Value" && is_context(operand, source_code, &contexts) {
+                        Some(Reason::Pure)
+                    } else if own
+                        .must_use
+                        .iter()
+                        .any(|name| name.split_once('.').is_some_and(|(_, m)| m == method))
+                    {
+                        confidence = Confidence::Medium;
+                        Some(Reason::Marked)
+                    } else {
+                        None
+                    }
+                }
+                _ => None,
+            };
+            let Some(reason) = reason else {
+                return;
+            };
+
+            let message = match reason {
+                Reason::Pure => format!(
+                    "the result of `{}` is discarded, and the call has no other effect",
+                    callee
+                ),
+                Reason::Append => "the result of `append` is discarded, so the appended elements are lost; assign it back to the slice".to_string(),
+                Reason::DerivedTime => {
+                    let operand = function
+                        .child_by_field_name("operand")
+                        .map_or("", |operand| node_text(operand, source_code));
+                    format!(
+                        "`{}` returns a new time and leaves `{}` unchanged, so discarding the result makes the call do nothing",
+                        callee, operand
+                    )
+                }
+                Reason::Marked => format!(
+                    "the result of `{}` must be used: it is marked `//compass:mustuse`",
+                    callee
+                ),
+                Reason::Listed => format!(
+                    "the result of `{}` must be used: it is listed in this rule's `functions`",
+                    callee
+                ),
+            };
+            let mut hit = Hit::new(call)
+                .with_message(message)
+                .with_confidence(confidence);
+            if let Some(fix) = assign_back(call, function, &reason, source_code) {
+                hit = hit.with_fix(fix);
+            }
+            hits.push(hit);
+        });
+        hits
+    }
+}
+
+/// Local package name to import path, and what the module says about the
+/// package.
+pub(super) fn imports(
+    root: Node,
+    source_code: &str,
+    module: Option<&Module>,
+) -> HashMap<String, (String, Option<Arc<PackageFacts>>)> {
+    let mut imports = HashMap::new();
+    visit(root, &mut |node| {
+        if node.kind() != "import_spec" {
+            return;
+        }
+        let Some(path) = import_path(node, source_code) else {
+            return;
+        };
+        let facts = module.and_then(|module| module.facts(path));
+        let name = match (&facts, node.child_by_field_name("name")) {
+            (Some(facts), None) if !facts.name.is_empty() => Some(facts.name.clone()),
+            _ => local_name(node, source_code),
+        };
+        if let Some(name) = name {
+            imports.insert(name, (path.to_string(), facts));
+        }
+    });
+    imports
+}
+
+fn is_pure(path: &str, name: &str) -> bool {
+    PURE.iter()
+        .any(|(package, names)| *package == path && names.contains(&name))
+}
+
+/// What the file and the rest of its package declare, for
+/// `//compass:mustuse` marks and contracts.
+pub(super) fn own_facts(root: Node, source_code: &str, package: Option<&Package>) -> PackageFacts {
+    let mut facts = PackageFacts::default();
+    collect_facts(root, source_code, &mut facts);
+    let Some(package) = package else {
+        return facts;
+    };
+    let mut parser = Parser::new();
+    if parser
+        .set_language(&SupportedLanguage::Go.tree_sitter_language())
+        .is_err()
+    {
+        return facts;
+    }
+    for file in &package.files {
+        if let Some(tree) = parser.parse(&file.source_code, None) {
+            collect_facts(tree.root_node(), &file.source_code, &mut facts);
+        }
+    }
+    facts
+}
+
+/// Names in the file that hold a `context.Context`: parameters and
+/// variables declared with the type, and the first name assigned from a
+/// call to the package.
+fn contexts(root: Node, source_code: &str, context: &str) -> HashSet<String> {
+    let context_type = format!("{}.Context", context);
+    let mut names = HashSet::new();
+    visit(root, &mut |node| match node.kind() {
+        "parameter_declaration" | "var_spec" => {
+            let declared = node
+                .child_by_field_name("type")
+                .is_some_and(|ty| node_text(ty, source_code) == context_type);
+            if declared {
+                let mut cursor = node.walk();
+                for name in node.children_by_field_name("name", &mut cursor) {
+                    names.insert(node_text(name, source_code).to_string());
+                }
+            }
+        }
+        "short_var_declaration" => {
+            let (Some(left), Some(right)) = (
+                node.child_by_field_name("left"),
+                node.child_by_field_name("right"),
+            ) else {
+                return;
+            };
+            let from_package = right
+                .named_child(0)
+                .filter(|value| value.kind() == "call_expression")
+                .and_then(|call| call.child_by_field_name("function"))
+                .and_then(|function| function.child_by_field_name("operand"))
+                .is_some_and(|operand| node_text(operand, source_code) == context);
+            if let Some(first) = left.named_child(0).filter(|_| from_package) {
+                names.insert(node_text(first, source_code).to_string());
+            }
+        }
+        _ => {}
+    });
+    names
+}
+
+/// Whether `node` is a context: a name holding one, or `r.Context()`.
+fn is_context(node: Node, source_code: &str, contexts: &HashSet<String>) -> bool {
+    match node.kind() {
+        "identifier" => contexts.contains(node_text(node, source_code)),
+        "call_expression" => node
+            .child_by_field_name("function")
+            .and_then(|function| function.child_by_field_name("field"))
+            .is_some_and(|field| node_text(field, source_code) == "Context"),
+        _ => false,
+    }
+}
+
+/// `s = append(s, x)` and `t = t.Add(d)` for a call standing alone, when
+/// the slice or time is a plain name or field.
+fn assign_back(call: Node, function: Node, reason: &Reason, source_code: &str) -> Option<Fix> {
+    if call.parent()?.kind() != "expression_statement" {
+        return None;
+    }
+    let target = match reason {
+        Reason::Append => call.child_by_field_name("arguments")?.named_child(0)?,
+        Reason::DerivedTime => function.child_by_field_name("operand")?,
+        _ => return None,
+    };
+    if !matches!(target.kind(), "identifier" | "selector_expression") {
+        return None;
+    }
+    let target = node_text(target, source_code);
+    Some(Fix {
+        description: format!("Assign the result to `{}`", target),
+        edits: vec![TextEdit {
+            start_byte: call.start_byte(),
+            end_byte: call.start_byte(),
+            replacement: format!("{} = ", target),
+        }],
+    })
+}
@@ -0,0 +1,245 @@
+use super::panic_reachable::label;
+use super::unused_result::imports;
+use super::{node_text, visit, Check, Hit, OptionKind, RuleOptions};
+use crate::analyzer::Confidence;
+use crate::callgraph::{declaration_id, is_graphed, CallGraph};
+use crate::package::Package;
+use crate::vuln::{Database, Vulnerability};
+use std::sync::Arc;
+use tree_sitter::Node;
+
+/// Flags calls to symbols that the Go vulnerability database lists as
+/// vulnerable at the version the file's `go.mod` selects; see
+/// [`crate::vuln`]. `compass check --vuln` turns the rule on.
+///
+/// Package-qualified calls such as `pkg.Func(...)` are matched exactly. A
+/// vulnerable method is matched by name on any value in a file importing
+/// its package, with medium confidence, since the receiver's type isn't
+/// known. Each finding says whether the call is reachable: the module's
+/// call graph is followed back from the calling function to `main`, an
+/// `init` or an exported function. Calls nothing reaches, such as from a
+/// function only ever passed as a value, have their confidence lowered,
+/// and calls in tests lowered further. Only the module's own calls are
+/// seen, not those a dependency makes on its behalf.
+///
+/// Options:
+/// - `db` (default `$GOVULNDB`): the database, a directory or `file://`
+///   URL. Without one the rule finds nothing.
+/// - `ignore` (default `[]`): vulnerability IDs or aliases that don't
+///   apply, once assessed.
+pub struct GoVulnerableCall;
+
+pub(super) const OPTIONS: &[(&str, OptionKind)] =
+    &[("db", OptionKind::String), ("ignore", OptionKind::Strings)];
+
+/// A package the file imports that has vulnerabilities.
+struct Vulnerable {
+    /// The module providing it and its version.
+    module: String,
+    version: String,
+    found: Vec<(Arc<Vulnerability>, Vec<String>)>,
+}
+
+impl Check for GoVulnerableCall {
+    fn run<'t>(&self, root: Node<'t>, source_code: &str, options: &RuleOptions) -> Vec<Hit<'t>> {
+        self.run_in_package(root, source_code, options, None)
+    }
+
+    fn reads_package(&self) -> bool {
+        true
+    }
+
+    fn reads_call_graph(&self) -> bool {
+        true
+    }
+
+    fn needs_package(&self) -> bool {
+        true
+    }
+
+    fn run_in_package<'t>(
+        &self,
+        root: Node<'t>,
+        source_code: &str,
+        options: &RuleOptions,
+        package: Option<&Package>,
+    ) -> Vec<Hit<'t>> {
+        let Some(module) = package.and_then(|package| package.module.as_ref()) else {
+            return Vec::new();
+        };
+        let Some(database) = Database::locate(options.string("db"))
+            .and_then(|location| Database::open(&location).ok())
+        else {
+            return Vec::new();
+        };
+        let ignored = options.string_list("ignore").unwrap_or_default();
+
+        let imported = imports(root, source_code, Some(module));
+        // Local package name to what is vulnerable in it.
+        let mut vulnerable: Vec<(String, Vulnerable)> = Vec::new();
+        for (name, (path, _)) in &imported {
+            let Some((selected, path)) = module.selected(path) else {
+                continue;
+            };
+            let found: Vec<_> = database
+                .affecting(&selected.path, &selected.version)
+                .into_iter()
+                .filter(|vulnerability| !vulnerability.is_named(&ignored))
+                .filter_map(|vulnerability| {
+                    let symbols = vulnerability
+                        .affecting(&selected.path, &selected.version)?
+                        .symbols(&path)?
+                        .to_vec();
+                    Some((vulnerability, symbols))
+                })
+                .collect();
+            if !found.is_empty() {
+                let package = Vulnerable {
+                    module: selected.path.clone(),
+                    version: selected.version.clone(),
+                    found,
+                };
+                vulnerable.push((name.clone(), package));
+            }
+        }
+        if vulnerable.is_empty() {
+            return Vec::new();
+        }
+        vulnerable.sort_by(|a, b| a.0.cmp(&b.0));
+
+        let graph = package
+            .filter(|package| is_graphed(&package.path))
+            .and_then(|package| Some((package.import_path()?, package.call_graph()?)));
+        let mut hits = Vec::new();
+        visit(root, &mut |node| {
+            if node.kind() != "call_expression" {
+                return;
+            }
+            let Some(function) = node
+                .child_by_field_name("function")
+                .filter(|function| function.kind() == "selector_expression")
+            else {
+                return;
+            };
+            let (Some(operand), Some(field)) = (
+                function.child_by_field_name("operand"),
+                function.child_by_field_name("field"),
+            ) else {
+                return;
+            };
+            let field = node_text(field, source_code);
+            // Calls qualified by another package are that package's.
+            let qualifier = (operand.kind() == "identifier")
+                .then(|| node_text(operand, source_code))
+                .filter(|name| imported.contains_key(*name));
+
+            for (local, package) in &vulnerable {
+                for (vulnerability, symbols) in &package.found {
+                    let (called, guessed) = match qualifier {
+                        Some(qualifier) if qualifier == local => {
+                            if !symbols.is_empty() && !symbols.iter().any(|symbol| symbol == field)
+                            {
+                                continue;
+                            }
+                            (format!("{}.{}", local, field), None)
+                        }
+                        Some(_) => continue,
+                        None => {
+                            let Some(method) = symbols.iter().find(|symbol| {
+                                symbol
+                                    .split_once('.')
+                                    .is_some_and(|(_, method)| method == field)
+                            }) else {
+                                continue;
+                            };
+                            (format!("{}.{}", local, method), Some(Confidence::Medium))
+                        }
+                    };
+                    let (reached, unreached) = reachability(node, source_code, graph.as_ref());
+                    let message = format!(
+                        "`{}` is affected by {}: {}; {}; {}",
+                        called,
+                        vulnerability.title(),
+                        vulnerability.summary,
+                        reached,
+                        vulnerability.fix(&package.module, &package.version)
+                    );
+                    let mut hit = Hit::new(node).with_message(message);
+                    if let Some(confidence) = lowered(guessed, unreached) {
+                        hit = hit.with_confidence(confidence);
+                    }
+                    hits.push(hit);
+                }
+            }
+        });
+        hits
+    }
+}
+
+/// How the function making `call` is reached from the module's entry
+/// points, and the confidence left when it isn't.
+fn reachability(
+    call: Node,
+    source_code: &str,
+    graph: Option<&(String, Arc<CallGraph>)>,
+) -> (String, Option<Confidence>) {
+    let Some((package, graph)) = graph else {
+        return ("only tests call it".to_string(), Some(Confidence::Low));
+    };
+    let mut declaration = call.parent();
+    while let Some(node) = declaration {
+        if matches!(node.kind(), "function_declaration" | "method_declaration") {
+            break;
+        }
+        declaration = node.parent();
+    }
+    let Some(declaration) = declaration else {
+        return ("called when the package is initialized".to_string(), None);
+    };
+    let Some(caller) =
+        declaration_id(package, declaration, source_code).and_then(|id| graph.find(&id))
+    else {
+        return (
+            "nothing in the module reaches it".to_string(),
+            Some(Confidence::Medium),
+        );
+    };
+    let is_entry = |function: usize| {
+        let function = &graph.functions[function];
+        let entry =
+            function.receiver.is_none() && matches!(function.name.as_str(), "main" | "init");
+        entry || function.is_exported()
+    };
+    match graph.path_to(caller, |_| true, is_entry) {
+        Some(path) => {
+            let chain = path
+                .iter()
+                .map(|&function| format!("`{}`", label(graph, function, package)))
+                .collect::<Vec<_>>()
+                .join(" → ");
+            (format!("called from {}", chain), None)
+        }
+        None => (
+            format!(
+                "called from `{}`, which nothing reaches from `main`, an `init` or an exported function",
+                label(graph, caller, package)
+            ),
+            Some(Confidence::Medium),
+        ),
+    }
+}
+
+/// The confidence left after both doubts, each of which lowers it a step
+/// from the rule's own.
+fn lowered(a: Option<Confidence>, b: Option<Confidence>) -> Option<Confidence> {
+    let steps = |confidence: Option<Confidence>| match confidence {
+        Some(Confidence::Low) => 2,
+        Some(Confidence::Medium) => 1,
+        _ => 0,
+    };
+    match steps(a) + steps(b) {
+        0 => None,
+        1 => Some(Confidence::Medium),
+        _ => Some(Confidence::Low),
+    }
+}
@@ -1,185 +1,3889 @@
+use std::collections::{BTreeMap, BTreeSet};
 use std::env;
 use std::fs;
+use std::io::{self, Read, Write};
+use std::net::TcpListener;
 use std::path::Path;
 use std::process;
+use std::sync::{Mutex, OnceLock};
+use std::thread;
+use std::time::{Duration, Instant};
 
+use crate::analyzer::{AnalysisResult, AnalysisRule, CodeAnalyzer, Confidence, Severity};
+use crate::apidiff::{self, Api};
+use crate::baseline::{Baseline, DEFAULT_BASELINE_PATH};
+use crate::build::{BuildContext, Platform};
+use crate::cache::Cache;
+use crate::callgraph::{self, CallGraph, GoFile};
+use crate::checks;
+use crate::codeowners::Owners;
+use crate::compare::{Comparison, Status};
+use crate::complexity;
 use crate::config::AnalyzerConfig;
-use serde_json::to_string_pretty;
-use tree_sitter::Language;
+use crate::deps::{Dependencies, Issue, Source, GO_SUM_FILE};
+use crate::diff;
+use crate::docs::{self, RuleSet};
+use crate::dupes;
+use crate::fix::{self, Conflicts, Fix, TextEdit};
+use crate::format::bitbucket;
+use crate::format::checkstyle::{self, CheckstyleWriter};
+use crate::format::github::{self, WorkflowWriter, ANNOTATIONS_PER_LEVEL};
+use crate::format::gitlab::{self, CodeQualityWriter};
+use crate::format::html::{self, Repository};
+use crate::format::json::{self, to_report, ReportWriter};
+use crate::format::rdjson::{self, Layout, RdjsonWriter};
+use crate::format::sarif::{self, SarifWriter};
+use crate::format::text::{self, TextStyle, TextWriter};
+use crate::format::{self, junit, FileFindings, JsonArray, OutputFormat};
+use crate::history::{History, Snapshot, DEFAULT_HISTORY_DIR};
+use crate::hook;
+use crate::init::{self, Ci, GITLAB_INCLUDE};
+use crate::language::{SupportedLanguage, SUPPORTED_EXTENSIONS};
+use crate::lint::{self, Level, Problem};
+use crate::lsp;
+use crate::metadata::{Metadata, Settings};
+use crate::metrics::{self, Metrics};
+use crate::migrate::{self, GOLANGCI_CONFIG_FILES};
+use crate::mode::Mode;
+use crate::module::{Module, GO_MOD_FILE};
+use crate::package::Package;
+use crate::package_list::{ListedPackage, PackageList};
+use crate::parallel;
+use crate::plugin::Registry;
+use crate::policy::Assertion;
+use crate::postprocess::{self, Grouping, Limiter, Limits};
+use crate::preset::{self, Preset};
+use crate::profile::{FileProfile, Profile};
+use crate::project::{EffectiveConfig, PROJECT_CONFIG_FILE};
+use crate::scan::{self, Repo, Scanned, DEFAULT_CHECKOUT_DIR};
+use crate::scope::{self, Scope};
+use crate::serve;
+use crate::shard::Shard;
+use crate::trace::{self, Trace};
+use crate::vuln::Database;
+use crate::walk;
+use crate::watch::{PackageUpdate, Watcher};
+use crate::workspace::{self, ModuleSummary, Workspace};
+use serde_json::{json, to_string_pretty};
+use tree_sitter::Parser;
 
-const RUST_CONFIG: &str = include_str!("../config/rust.toml");
-const GO_CONFIG: &str = include_str!("../config/go.toml");
-const JAVASCRIPT_CONFIG: &str = include_str!("../config/javascript.toml");
-const JAVA_CONFIG: &str = include_str!("../config/java.toml");
-const ZIG_CONFIG: &str = include_str!("../config/zig.toml");
-const CPP_CONFIG: &str = include_str!("../config/cpp.toml");
-const SWIFT_CONFIG: &str = include_str!("../config/swift.toml");
+struct Options {
+    format: OutputFormat,
+    baseline: Option<String>,
+    compare_to: Option<String>,
+    output: Option<String>,
+    fix: bool,
+    fix_diff: bool,
+    fix_conflicts: Conflicts,
+    interactive: bool,
+    force: bool,
+    yes: bool,
+    base: Option<String>,
+    from: Option<String>,
+    path: Option<String>,
+    fail_on: Option<Severity>,
+    min_confidence: Option<Confidence>,
+    top: usize,
+    min_tokens: Option<usize>,
+    vuln: bool,
+    preset: Option<Preset>,
+    enable_experimental: bool,
+    mode: Mode,
+    no_color: bool,
+    context_lines: usize,
+    history: Option<String>,
+    max_drop: Option<f64>,
+    no_record: bool,
+    no_cache: bool,
+    profile: bool,
+    pprof: Option<String>,
+    otlp_endpoint: Option<String>,
+    pushgateway: Option<String>,
+    statsd: Option<String>,
+    emit_metadata: Option<String>,
+    listen: Option<String>,
+    stdin: bool,
+    stdin_filename: Option<String>,
+    package_list: Option<String>,
+    repos: Option<String>,
+    group_by: Grouping,
+    owner: Option<String>,
+    scope: Scope,
+    limits: Limits,
+    build_tags: Vec<String>,
+    platforms: Vec<Platform>,
+    jobs: usize,
+    shard: Option<Shard>,
+    positional: Vec<String>,
+}
+
+fn parse_args(args: Vec<String>) -> Result<Options, String> {
+    let mut options = Options {
+        format: OutputFormat::Score,
+        baseline: None,
+        compare_to: None,
+        output: None,
+        fix: false,
+        fix_diff: false,
+        fix_conflicts: Conflicts::First,
+        interactive: false,
+        force: false,
+        yes: false,
+        base: None,
+        from: None,
+        path: None,
+        fail_on: None,
+        min_confidence: None,
+        top: 10,
+        min_tokens: None,
+        vuln: false,
+        preset: None,
+        enable_experimental: false,
+        mode: Mode::Full,
+        no_color: false,
+        context_lines: text::DEFAULT_CONTEXT_LINES,
+        history: None,
+        max_drop: None,
+        no_record: false,
+        no_cache: false,
+        profile: false,
+        pprof: None,
+        otlp_endpoint: None,
+        pushgateway: None,
+        statsd: None,
+        emit_metadata: None,
+        listen: None,
+        stdin: false,
+        stdin_filename: None,
+        package_list: None,
+        repos: None,
+        group_by: Grouping::File,
+        owner: None,
+        scope: Scope::default(),
+        limits: Limits::default(),
+        build_tags: Vec::new(),
+        platforms: Vec::new(),
+        jobs: parallel::default_jobs(),
+        shard: None,
+        positional: Vec::new(),
+    };
+
+    let mut fix_conflicts = None;
+    let mut fix_priority = Vec::new();
+    let mut iter = args.into_iter();
+    while let Some(arg) = iter.next() {
+        let (flag, inline_value) = match arg.split_once('=') {
+            Some((flag, value)) if arg.starts_with("--") => {
+                (flag.to_string(), Some(value.to_string()))
+            }
+            _ => (arg.clone(), None),
+        };
+        let mut value = |name: &str| {
+            inline_value
+                .clone()
+                .or_else(|| iter.next())
+                .ok_or_else(|| format!("{} requires a value", name))
+        };
+
+        match flag.as_str() {
+            "--format" => options.format = parse_format(&value("--format")?)?,
+            "--baseline" => options.baseline = Some(value("--baseline")?),
+            "--compare-to" => options.compare_to = Some(value("--compare-to")?),
+            "--output" | "-o" => options.output = Some(value("--output")?),
+            "--fix" => options.fix = true,
+            "--vuln" => options.vuln = true,
+            "--no-color" => options.no_color = true,
+            "--context-lines" => {
+                let lines = value("--context-lines")?;
+                options.context_lines = lines
+                    .parse()
+                    .map_err(|_| format!("--context-lines expects a number, got '{}'", lines))?;
+            }
+            "--preset" => {
+                let preset = value("--preset")?;
+                options.preset = Some(Preset::from_name(&preset).ok_or_else(|| {
+                    format!(
+                        "unknown --preset '{}'. Supported presets: {}",
+                        preset,
+                        Preset::NAMES
+                    )
+                })?);
+            }
+            "--mode" => {
+                let mode = value("--mode")?;
+                options.mode = Mode::from_name(&mode).ok_or_else(|| {
+                    format!(
+                        "unknown --mode '{}'. Supported modes: {}",
+                        mode,
+                        Mode::NAMES
+                    )
+                })?;
+            }
+            "--enable-experimental" => options.enable_experimental = true,
+            "--fix-diff" => options.fix_diff = true,
+            "--fix-conflicts" => fix_conflicts = Some(value("--fix-conflicts")?),
+            "--fix-priority" => {
+                fix_priority = value("--fix-priority")?
+                    .split(',')
+                    .map(str::trim)
+                    .filter(|rule| !rule.is_empty())
+                    .map(str::to_string)
+                    .collect()
+            }
+            "--interactive" | "-i" => options.interactive = true,
+            "--no-cache" => options.no_cache = true,
+            "--profile" => options.profile = true,
+            "--pprof" => options.pprof = Some(value("--pprof")?),
+            "--otlp-endpoint" => options.otlp_endpoint = Some(value("--otlp-endpoint")?),
+            "--pushgateway" => options.pushgateway = Some(value("--pushgateway")?),
+            "--statsd" => options.statsd = Some(value("--statsd")?),
+            "--emit-metadata" => options.emit_metadata = Some(value("--emit-metadata")?),
+            "--listen" => options.listen = Some(value("--listen")?),
+            "--stdin" => options.stdin = true,
+            "--stdin-filename" => options.stdin_filename = Some(value("--stdin-filename")?),
+            "--shard" => options.shard = Some(Shard::parse(&value("--shard")?)?),
+            "--package-list-from-file" => {
+                options.package_list = Some(value("--package-list-from-file")?)
+            }
+            "--repos" => options.repos = Some(value("--repos")?),
+            "--no-record" => options.no_record = true,
+            "--history" => options.history = Some(value("--history")?),
+            "--force" => options.force = true,
+            "--yes" | "-y" => options.yes = true,
+            "--base" => options.base = Some(value("--base")?),
+            "--from" => options.from = Some(value("--from")?),
+            "--path" => options.path = Some(value("--path")?),
+            "--fail-on" => options.fail_on = Some(parse_fail_on(&value("--fail-on")?)?),
+            "--min-confidence" => {
+                let confidence = value("--min-confidence")?;
+                options.min_confidence =
+                    Some(Confidence::from_name(&confidence).ok_or_else(|| {
+                        format!(
+                            "unknown --min-confidence level '{}'. Supported levels: {}",
+                            confidence,
+                            Confidence::NAMES
+                        )
+                    })?);
+            }
+            "--jobs" | "-j" => {
+                let jobs = value("--jobs")?;
+                options.jobs =
+                    jobs.parse().ok().filter(|jobs| *jobs > 0).ok_or_else(|| {
+                        format!("--jobs expects a positive number, got '{}'", jobs)
+                    })?;
+            }
+            "--group-by" => {
+                let grouping = value("--group-by")?;
+                options.group_by = Grouping::from_name(&grouping).ok_or_else(|| {
+                    format!(
+                        "unknown --group-by '{}'. Supported groupings: file, rule, owner",
+                        grouping
+                    )
+                })?;
+            }
+            "--owner" => options.owner = Some(value("--owner")?),
+            "--since" => options.scope.since = Some(value("--since")?),
+            "--author" => options.scope.authors.push(value("--author")?),
+            "--max-issues-per-rule" => {
+                options.limits.per_rule =
+                    parse_limit("--max-issues-per-rule", &value("--max-issues-per-rule")?)?
+            }
+            "--max-same-issues" => {
+                options.limits.same =
+                    parse_limit("--max-same-issues", &value("--max-same-issues")?)?
+            }
+            "--build-tags" => {
+                options.build_tags = value("--build-tags")?
+                    .split(',')
+                    .map(str::trim)
+                    .filter(|tag| !tag.is_empty())
+                    .map(str::to_string)
+                    .collect()
+            }
+            "--platforms" => {
+                for platform in value("--platforms")?.split(',') {
+                    let platform =
+                        Platform::parse(platform).map_err(|e| format!("--platforms: {}", e))?;
+                    if !options.platforms.contains(&platform) {
+                        options.platforms.push(platform);
+                    }
+                }
+            }
+            "--top" => {
+                let top = value("--top")?;
+                options.top = top
+                    .parse()
+                    .map_err(|_| format!("--top expects a number, got '{}'", top))?;
+            }
+            "--min-tokens" => {
+                let tokens = value("--min-tokens")?;
+                options.min_tokens = Some(
+                    tokens
+                        .parse()
+                        .ok()
+                        .filter(|tokens| *tokens > 0)
+                        .ok_or_else(|| {
+                            format!("--min-tokens expects a positive number, got '{}'", tokens)
+                        })?,
+                );
+            }
+            "--max-drop" => {
+                let drop = value("--max-drop")?;
+                options.max_drop = Some(
+                    drop.parse()
+                        .ok()
+                        .filter(|drop: &f64| *drop >= 0.0)
+                        .ok_or_else(|| {
+                            format!("--max-drop expects a non-negative number, got '{}'", drop)
+                        })?,
+                );
+            }
+            _ if flag.starts_with("--") => return Err(format!("unknown option '{}'", arg)),
+            _ => options.positional.push(arg),
+        }
+    }
+
+    // A priority list alone asks for the priority strategy.
+    let strategy = fix_conflicts.unwrap_or_else(|| {
+        match fix_priority.is_empty() {
+            true => "first",
+            false => "priority",
+        }
+        .to_string()
+    });
+    options.fix_conflicts = Conflicts::from_name(&strategy, fix_priority).ok_or_else(|| {
+        format!(
+            "unknown --fix-conflicts '{}'. Supported strategies: {}",
+            strategy,
+            Conflicts::NAMES
+        )
+    })?;
+
+    Ok(options)
+}
+
+/// The builds `--build-tags` and `--platforms` ask for: one per platform,
+/// or for the host with only tags. Empty without either flag, when every
+/// file is analyzed whatever its constraints.
+fn build_contexts(options: &Options) -> Vec<BuildContext> {
+    if options.build_tags.is_empty() && options.platforms.is_empty() {
+        return Vec::new();
+    }
+    let platforms = if options.platforms.is_empty() {
+        vec![Platform::host()]
+    } else {
+        options.platforms.clone()
+    };
+    platforms
+        .into_iter()
+        .map(|platform| BuildContext {
+            platform,
+            tags: options.build_tags.clone(),
+        })
+        .collect()
+}
+
+fn parse_format(value: &str) -> Result<OutputFormat, String> {
+    OutputFormat::from_name(value).ok_or_else(|| {
+        format!(
+            "unknown format '{}'. Supported formats: {}",
+            value,
+            OutputFormat::names()
+        )
+    })
+}
+
+/// A cap on findings, where `0` means no cap.
+fn parse_limit(flag: &str, value: &str) -> Result<Option<usize>, String> {
+    match value.parse::<usize>() {
+        Ok(0) => Ok(None),
+        Ok(max) => Ok(Some(max)),
+        Err(_) => Err(format!("{} expects a number, got '{}'", flag, value)),
+    }
+}
+
+/// `any` fails on findings of every severity, including info and style.
+fn parse_fail_on(value: &str) -> Result<Severity, String> {
+    match value {
+        "error" => Ok(Severity::Error),
+        "warning" => Ok(Severity::Warning),
+        "any" => Ok(Severity::Style),
+        _ => Err(format!(
+            "unknown --fail-on level '{}'. Supported levels: error, warning, any",
+            value
+        )),
+    }
+}
 
 pub fn run() {
+    run_with(Registry::new());
+}
+
+/// Runs the CLI with additional checks available to `check = "..."` rules.
+pub fn run_with(registry: Registry) {
     let mut args = env::args();
     let program = args.next().unwrap_or_else(|| "compass".to_string());
-    let remaining: Vec<String> = args.collect();
+    let args: Vec<String> = args.collect();
 
-    if remaining.is_empty() || remaining.len() > 2 {
+    let command = args.first().map(String::as_str);
+    let options = parse_args(match command {
+        Some("baseline") | Some("lsp") | Some("diff") | Some("config") | Some("metrics")
+        | Some("watch") | Some("cache") | Some("rules") | Some("explain") | Some("hook")
+        | Some("migrate") | Some("score") | Some("callgraph") | Some("check") | Some("apidiff")
+        | Some("audit") | Some("serve") | Some("dupes") | Some("deps") | Some("preset")
+        | Some("init") | Some("policy") | Some("merge") | Some("scan") => args[1..].to_vec(),
+        _ => args.clone(),
+    })
+    .unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        usage(&program);
+    });
+    if options.format == OutputFormat::Markdown && command != Some("rules") {
+        eprintln!("Error: --format markdown is only supported by compass rules");
+        usage(&program);
+    }
+    if options.format == OutputFormat::Dot && command != Some("callgraph") {
+        eprintln!("Error: --format dot is only supported by compass callgraph");
+        usage(&program);
+    }
+    if options.group_by != Grouping::File && options.format != OutputFormat::Score {
+        eprintln!("Error: --group-by is only supported by --format score");
+        usage(&program);
+    }
+    if options.vuln && options.mode == Mode::Syntax {
+        // Reachability needs the call graph, and the versions go.mod.
+        eprintln!("Error: --vuln needs the package, so it can't run with --mode syntax");
         usage(&program);
     }
 
-    let source_path = remaining[0].clone();
-    let config_override = remaining.get(1).cloned();
+    match command {
+        Some("apidiff") => run_apidiff(&program, options),
+        Some("audit") => run_audit(&program, options, &registry),
+        Some("baseline") => run_baseline(&program, options, &registry),
+        Some("lsp") => run_lsp(&program, options, registry),
+        Some("deps") => run_deps(&program, options),
+        Some("diff") => run_diff(&program, options, &registry),
+        Some("dupes") => run_dupes(&program, options),
+        Some("cache") => run_cache(&program, options),
+        Some("callgraph") => run_callgraph(&program, options),
+        Some("hook") => run_hook(&program, options, &registry),
+        Some("init") => run_init(&program, options, &registry),
+        Some("config") => run_config(&program, options, &registry),
+        Some("rules") => run_rules(&program, options),
+        Some("explain") => run_explain(&program, options),
+        Some("policy") => run_policy(&program, options),
+        Some("preset") => run_preset(&program, options),
+        Some("merge") => run_merge(&program, options),
+        Some("metrics") => run_metrics(&program, options),
+        Some("migrate") => run_migrate(&program, options),
+        Some("scan") => run_scan(&program, options, &registry),
+        Some("score") => run_score(&program, options, &registry),
+        Some("serve") => run_serve(&program, options, registry),
+        Some("watch") => run_watch(&program, options, registry),
+        _ => run_check(&program, options, &registry),
+    }
+}
 
-    if !Path::new(&source_path).exists() {
-        eprintln!("Error: file '{}' does not exist", source_path);
-        process::exit(1);
+fn run_check(program: &str, options: Options, registry: &Registry) {
+    if let Some(list) = &options.package_list {
+        if options.stdin || options.positional.len() > 1 {
+            usage(program);
+        }
+        let config_override = options.positional.first().map(String::as_str);
+        run_check_list(program, list, config_override, &options, registry);
+        return;
+    }
+    let (source_path, config_override) = if options.stdin {
+        // The path only names the buffer; it needn't exist yet.
+        let Some(path) = options.stdin_filename.clone() else {
+            eprintln!("Error: --stdin requires --stdin-filename to pick the language and package");
+            usage(program);
+        };
+        if options.positional.len() > 1 {
+            usage(program);
+        }
+        (path, options.positional.first().cloned())
+    } else {
+        if options.positional.is_empty() || options.positional.len() > 2 {
+            usage(program);
+        }
+        (
+            options.positional[0].clone(),
+            options.positional.get(1).cloned(),
+        )
+    };
+    let dir = scope::package_pattern(&source_path)
+        .or_else(|| Some(source_path.as_str()).filter(|path| Path::new(path).is_dir()));
+    if options.vuln {
+        open_vuln_db(dir.unwrap_or(&source_path), config_override.as_deref());
+    }
+    if let Some(dir) = dir.filter(|_| !options.stdin) {
+        run_check_dir(program, dir, config_override.as_deref(), &options, registry);
+        return;
+    }
+    if !options.scope.is_empty() {
+        eprintln!("Error: --since and --author take a directory, not a single file");
+        usage(program);
+    }
+    if options.shard.is_some() {
+        eprintln!("Error: --shard takes a directory or a package list, not a single file");
+        usage(program);
+    }
+    if options.interactive && !(options.fix || options.fix_diff) {
+        eprintln!("Error: --interactive requires --fix or --fix-diff");
+        usage(program);
+    }
+    if options.interactive && options.stdin {
+        eprintln!("Error: --interactive reads answers from stdin, so it can't take --stdin");
+        usage(program);
     }
 
-    let language = SupportedLanguage::from_path(&source_path).unwrap_or_else(|| {
+    let started = Instant::now();
+    let cache = open_cache(&options);
+    let builds = build_contexts(&options);
+    let analysis = if options.stdin {
+        analyze_stdin(
+            &source_path,
+            config_override.as_deref(),
+            options.min_confidence,
+            options.vuln,
+            options.preset,
+            options.enable_experimental,
+            options.mode,
+            registry,
+            cache.as_ref(),
+            &builds,
+        )
+    } else {
+        analyze_path(
+            &source_path,
+            config_override.as_deref(),
+            options.min_confidence,
+            options.vuln,
+            options.preset,
+            options.enable_experimental,
+            options.mode,
+            registry,
+            cache.as_ref(),
+            &builds,
+        )
+    };
+    report_profile(
+        &options,
+        started,
+        [(source_path.as_str(), &analysis.profile)],
+    );
+    // A single file's trace ends with its analysis; the report is written
+    // on several paths that exit.
+    if let Some(mut trace) = start_trace(&options, started) {
+        trace.file(&source_path, &analysis.profile, analysis.results.len());
+        export_trace(&options, trace);
+    }
+    if let Some(mut metrics) = start_metrics(&options) {
+        metrics.file(&source_path, &analysis.results, analysis.profile.cached);
+        push_metrics(&options, &metrics, started.elapsed());
+    }
+    if !analysis.in_build {
         eprintln!(
-            "Error: unsupported file extension for '{}'. Supported extensions: .rs, .go, .js, .jsx, .zig, .java, .cpp, .cc, .cxx, .h, .hpp, .swift",
+            "{}: no build asked for compiles this file; nothing was analyzed",
+            source_path
+        );
+    }
+
+    let mut results = analysis.results;
+    if let Some(baseline) = load_baseline(&options) {
+        results = baseline.filter(&source_path, results);
+    }
+    postprocess::dedup(&mut results);
+    if let Some(mut metadata) = start_metadata(&options, started) {
+        if analysis.in_build {
+            metadata.file(
+                &source_path,
+                &analysis.config_label,
+                analysis.analyzer.rules(),
+                analysis.profile.cached,
+            );
+        }
+        metadata.stage("analyze");
+        write_metadata(&options, metadata);
+    }
+
+    if options.fix || options.fix_diff {
+        let output = match (options.fix, options.stdin) {
+            (false, _) => FixOutput::Diff,
+            (true, false) => FixOutput::Write,
+            (true, true) => FixOutput::Stdout,
+        };
+        apply_fixes(
+            &source_path,
+            &analysis.source_code,
+            &results,
+            output,
+            &options,
+        );
+        return;
+    }
+
+    if options.format == OutputFormat::Score {
+        println!(
+            "Analyzing {} file with custom preferences: {}",
+            analysis.language.display_name(),
             source_path
         );
+        println!("Config: {}", analysis.config_label);
+        println!("----------------------------------------");
+    }
+
+    let owners = Owners::default().of(&source_path);
+    let mut comparison = load_comparison(&options);
+    if !is_owned(&options, &owners) {
+        results.clear();
+    } else if let Some(comparison) = &mut comparison {
+        comparison.label(&source_path, &mut results);
+    }
+    let analyzer = &analysis.analyzer;
+    let score = analyzer.calculate_score(&results, &analysis.source_code);
+    let module = Path::new(&source_path)
+        .parent()
+        .filter(|dir| !dir.as_os_str().is_empty())
+        .map_or(Module::find(Path::new(".")), Module::find);
+    let mut files = [FileFindings {
+        path: source_path,
+        module: module.ok().flatten().map(|module| module.path),
+        owners,
+        results,
+    }];
+    let omitted = options.limits.apply(&mut files);
+    report_omitted(&omitted);
+    if let Some(comparison) = &comparison {
+        report_comparison(&options, comparison);
+    }
+    let output = match options.format {
+        OutputFormat::Score => {
+            let mut report = analyzer.format_score_as_json(&files[0].results, &score);
+            if !files[0].owners.is_empty() {
+                report["owners"] = json!(files[0].owners);
+            }
+            group_issues(&options, &mut report, &files);
+            if !omitted.is_empty() {
+                report["omitted"] = json!(omitted);
+            }
+            if let Some(comparison) = &comparison {
+                report["previous_run"] = previous_run(&options, comparison);
+            }
+            report
+        }
+        OutputFormat::Json => {
+            let mut report = to_report(&files);
+            if let Some(comparison) = &comparison {
+                report.fixed = comparison.fixed();
+            }
+            json!(report)
+        }
+        OutputFormat::Sarif => sarif::to_sarif(
+            analyzer.rules(),
+            &[(&files[0], analysis.source_code.as_str())],
+        ),
+        OutputFormat::Github => {
+            print_github(&files);
+            exit_for_failures(options.fail_on, &files);
+            return;
+        }
+        OutputFormat::Html => {
+            let repository = Repository::detect(Path::new("."));
+            let page = html::to_html(
+                analyzer.rules(),
+                &files,
+                &[&analysis.source_code],
+                repository.as_ref(),
+            );
+            print!("{}", page);
+            exit_for_failures(options.fail_on, &files);
+            return;
+        }
+        OutputFormat::Checkstyle | OutputFormat::Junit => {
+            print_xml(options.format, &files);
+            exit_for_failures(options.fail_on, &files);
+            return;
+        }
+        OutputFormat::GitlabCodeQuality => {
+            print!("{}", gitlab::to_codequality(&files));
+            exit_for_failures(options.fail_on, &files);
+            return;
+        }
+        OutputFormat::Bitbucket => {
+            let failed = failing(options.fail_on, &files[0].results) > 0;
+            print_json(&bitbucket::to_bitbucket(&files, failed));
+            exit_for_failures(options.fail_on, &files);
+            return;
+        }
+        OutputFormat::Rdjson | OutputFormat::Rdjsonl => {
+            let sources = [(&files[0], analysis.source_code.as_str())];
+            print!(
+                "{}",
+                rdjson::to_rdjson(&sources, rdjson_layout(options.format))
+            );
+            exit_for_failures(options.fail_on, &files);
+            return;
+        }
+        OutputFormat::Text => {
+            let excerpts = [(&files[0], analysis.source_code.as_str())];
+            print!("{}", text::to_text(&excerpts, text_style(&options)));
+            exit_for_failures(options.fail_on, &files);
+            return;
+        }
+        OutputFormat::Markdown | OutputFormat::Dot => unreachable!("rejected by run_with"),
+    };
+    print_json(&output);
+    exit_for_failures(options.fail_on, &files);
+}
+
+/// Analyzes every supported file under `root` across `--jobs` workers, and
+/// under any module outside `root` that its `go.work` uses.
+fn run_check_dir(
+    program: &str,
+    root: &str,
+    config_override: Option<&str>,
+    options: &Options,
+    registry: &Registry,
+) {
+    let started = Instant::now();
+    if options.fix || options.fix_diff {
+        eprintln!("Error: --fix and --fix-diff take a single file, not a directory");
+        usage(program);
+    }
+
+    let workspace = Workspace::discover(Path::new(root)).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
         process::exit(1);
     });
-
-    let config_label;
-    let config = match config_override.as_deref() {
-        Some(path) => {
-            config_label = path.to_string();
-            AnalyzerConfig::from_file(path).unwrap_or_else(|e| {
-                eprintln!("Error: failed to load config '{}': {}", path, e);
+    let mut dirs = vec![Path::new(root)];
+    dirs.extend(workspace.roots_outside(Path::new(root)));
+    let mut paths = Vec::new();
+    for dir in dirs {
+        let mut found = walk::source_files(dir).unwrap_or_else(|e| {
+            eprintln!("Error: {}", e);
+            process::exit(1);
+        });
+        if !options.scope.is_empty() {
+            let touched = options.scope.files(dir).unwrap_or_else(|e| {
+                eprintln!("Error: {}", e);
                 process::exit(1);
-            })
+            });
+            found.retain(|path| touched.contains(path));
         }
-        None => {
-            config_label = format!("built-in {}", language.config_key());
-            let default_config = language.default_config();
-            AnalyzerConfig::from_str(default_config).expect("embedded config should parse")
+        paths.extend(found.iter().map(|path| path.to_string_lossy().into_owned()));
+    }
+    let baseline = load_baseline(options);
+    let cache = open_cache(options);
+    let builds = build_contexts(options);
+
+    // The same file reached twice, through a symlink or a `go.work` module
+    // under `root`, is analyzed once.
+    let paths: Vec<String> =
+        postprocess::distinct_paths(paths.into_iter().map(|path| (path, ())).collect())
+            .into_iter()
+            .map(|(path, ())| path)
+            .collect();
+    let paths = match options.shard {
+        Some(shard) => shard.select(paths, |path| path),
+        None => paths,
+    };
+    let mut summary = json!({});
+    if let Some(shard) = options.shard {
+        summary["shard"] = json!(shard.to_string());
+    }
+    if let Some(since) = &options.scope.since {
+        summary["since"] = json!(since);
+    }
+    if !options.scope.authors.is_empty() {
+        summary["authors"] = json!(options.scope.authors);
+    }
+    let mut reporter = Reporter::new(options, &workspace, summary, started);
+    parallel::for_each_ordered(
+        &paths,
+        options.jobs,
+        |path| {
+            let mut analysis = analyze_path(
+                path,
+                config_override,
+                options.min_confidence,
+                options.vuln,
+                options.preset,
+                options.enable_experimental,
+                options.mode,
+                registry,
+                cache.as_ref(),
+                &builds,
+            );
+            if let Some(baseline) = &baseline {
+                analysis.results = baseline.filter(path, analysis.results);
+            }
+            (path.clone(), analysis)
+        },
+        |(path, analysis)| {
+            // Files that no build compiles are left out.
+            if analysis.in_build {
+                reporter.file(path, analysis);
+            }
+        },
+    );
+    // go.mod and go.sum aren't in a package, so the first shard takes them.
+    if options.vuln && options.shard.is_none_or(|shard| shard.is_first()) {
+        for module in &workspace.modules {
+            for (path, analysis) in dependency_files(module, config_override, options, true) {
+                reporter.file(path, analysis);
+            }
         }
+    }
+    reporter.finish();
+}
+
+/// Analyzes the packages a build system listed in `list`; see
+/// [`crate::package_list`].
+fn run_check_list(
+    program: &str,
+    list: &str,
+    config_override: Option<&str>,
+    options: &Options,
+    registry: &Registry,
+) {
+    let started = Instant::now();
+    if options.fix || options.fix_diff {
+        eprintln!("Error: --fix and --fix-diff take a single file, not a package list");
+        usage(program);
+    }
+    if !options.scope.is_empty() || options.vuln {
+        eprintln!("Error: --since, --author and --vuln take a directory, not a package list");
+        usage(program);
+    }
+    let list = PackageList::from_file(list).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    });
+    let baseline = load_baseline(options);
+    let files = list.files();
+    let files = match options.shard {
+        Some(shard) => shard.select(files, |(path, _)| path),
+        None => files,
     };
+    let mut summary = json!({});
+    if let Some(shard) = options.shard {
+        summary["shard"] = json!(shard.to_string());
+    }
 
-    let analyzer = config.to_analyzer();
-    if !analyzer.has_rules() {
+    // The build system caches the action, so compass's own cache, which
+    // lives outside it, isn't used.
+    let workspace = Workspace::default();
+    let mut reporter = Reporter::new(options, &workspace, summary, started);
+    parallel::for_each_ordered(
+        &files,
+        options.jobs,
+        |(path, package)| {
+            let builds = match package.build() {
+                Ok(Some(build)) => vec![build],
+                _ => build_contexts(options),
+            };
+            let source_code = fs::read_to_string(path).unwrap_or_else(|e| {
+                eprintln!("Error: failed to read '{}': {}", path, e);
+                process::exit(1);
+            });
+            let language = SupportedLanguage::from_path(path).expect("listed files are supported");
+            let mut analysis = analyze_source(
+                path,
+                language,
+                source_code,
+                config_override,
+                options.min_confidence,
+                false,
+                options.preset,
+                options.enable_experimental,
+                options.mode,
+                registry,
+                None,
+                &builds,
+                Some(package),
+            );
+            if let Some(baseline) = &baseline {
+                analysis.results = baseline.filter(path, analysis.results);
+            }
+            (path.clone(), analysis)
+        },
+        |(path, analysis)| {
+            if analysis.in_build {
+                reporter.file(path, analysis);
+            }
+        },
+    );
+    reporter.finish();
+}
+
+fn run_diff(program: &str, options: Options, registry: &Registry) {
+    let started = Instant::now();
+    if options.positional.len() > 1 {
+        usage(program);
+    }
+    let Some(base) = options.base.as_deref() else {
+        eprintln!("Error: compass diff requires --base <git-ref>");
+        usage(program);
+    };
+    if options.compare_to.is_some() {
         eprintln!(
-            "Error: config '{}' contains no enabled rules for language '{}'",
-            config_label,
-            language.config_key()
+            "Error: compass diff already reports only changed lines; --compare-to takes a full run"
         );
-        process::exit(1);
+        usage(program);
     }
 
-    let source_code = fs::read_to_string(&source_path).unwrap_or_else(|e| {
-        eprintln!("Error: failed to read '{}': {}", source_path, e);
+    let changed = diff::changed_lines(base).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
         process::exit(1);
     });
+    let config_override = options.positional.first().map(String::as_str);
+    let baseline = load_baseline(&options);
+    let cache = open_cache(&options);
+    let builds = build_contexts(&options);
 
-    println!(
-        "Analyzing {} file with custom preferences: {}",
-        language.display_name(),
-        source_path
+    let changed: Vec<_> = changed
+        .iter()
+        .filter(|(path, _)| {
+            SupportedLanguage::from_path(path).is_some()
+                && Path::new(path).exists()
+                && !load_project(path).is_excluded(path)
+        })
+        .collect();
+    let workspace = Workspace::discover(Path::new(".")).unwrap_or_default();
+    let mut reporter = Reporter::new(&options, &workspace, json!({ "base": base }), started);
+    parallel::for_each_ordered(
+        &changed,
+        options.jobs,
+        |(path, ranges)| {
+            let mut analysis = analyze_path(
+                path,
+                config_override,
+                options.min_confidence,
+                options.vuln,
+                options.preset,
+                options.enable_experimental,
+                options.mode,
+                registry,
+                cache.as_ref(),
+                &builds,
+            );
+            if let Some(baseline) = &baseline {
+                analysis.results = baseline.filter(path, analysis.results);
+            }
+            analysis
+                .results
+                .retain(|result| diff::intersects(result, ranges));
+            (path.to_string(), analysis)
+        },
+        |(path, analysis)| {
+            if analysis.in_build {
+                reporter.file(path, analysis);
+            }
+        },
     );
-    println!("Config: {}", config_label);
-    println!("----------------------------------------");
+    reporter.finish();
+}
 
-    let tree_sitter_language = language.tree_sitter_language();
-    let (results, score) = analyzer
-        .analyze_with_score(&source_code, &tree_sitter_language)
-        .unwrap_or_else(|e| {
-            eprintln!("Error: analysis failed: {}", e);
-            process::exit(1);
+/// Prints the findings of several files in `options.format` as they are
+/// analyzed, tagging each file with its module in `workspace`, and applies
+/// `--fail-on` at the end. Formats that can be written a file at a time
+/// are, so a large run only holds the findings of the files in flight;
+/// HTML, JUnit and `--group-by` need the whole run, and only HTML keeps
+/// the sources. The score report starts from `summary` and adds the
+/// totals, a report per file and, when there are modules, a summary per
+/// module.
+struct Reporter<'a> {
+    options: &'a Options,
+    workspace: &'a Workspace,
+    summary: serde_json::Value,
+    owned: Owners,
+    limiter: Limiter,
+    rules: Vec<AnalysisRule>,
+    output: Output,
+    total_issues: usize,
+    failing: usize,
+    modules: Vec<ModuleSummary>,
+    /// The findings of every file, when the output needs them at the end.
+    kept: Vec<FileFindings>,
+    sources: Vec<String>,
+    metadata: Option<Metadata>,
+    comparison: Option<Comparison>,
+    started: Instant,
+    /// Each file's profile, for `--profile` and `--pprof`.
+    profiles: Vec<(String, FileProfile)>,
+    trace: Option<Trace>,
+    metrics: Option<Metrics>,
+    /// Time spent writing findings as each file came in.
+    streamed: Duration,
+}
+
+type Stdout = io::BufWriter<io::Stdout>;
+
+enum Output {
+    Json(ReportWriter<Stdout>),
+    Sarif(SarifWriter<Stdout>),
+    Checkstyle(CheckstyleWriter<Stdout>),
+    CodeQuality(CodeQualityWriter<Stdout>),
+    Github(WorkflowWriter<Stdout>),
+    Rdjson(RdjsonWriter<Stdout>),
+    /// The score report, up to the elements of its `files`.
+    Score(Stdout, JsonArray),
+    Text(TextWriter<Stdout>),
+    /// HTML, JUnit and Bitbucket, written once every file is in.
+    Whole,
+}
+
+impl<'a> Reporter<'a> {
+    /// Starts the report of a run that began at `started` and has just
+    /// found the files to analyze.
+    fn new(
+        options: &'a Options,
+        workspace: &'a Workspace,
+        summary: serde_json::Value,
+        started: Instant,
+    ) -> Self {
+        let comparison = load_comparison(options);
+        let trace = start_trace(options, started).map(|mut trace| {
+            let root = trace.root();
+            trace.span("discover", Some(root), started, Instant::now());
+            trace
         });
+        let out = io::BufWriter::new(io::stdout());
+        let output = match options.format {
+            OutputFormat::Json => ReportWriter::new(out).map(Output::Json),
+            OutputFormat::Sarif => SarifWriter::new(out).map(Output::Sarif),
+            OutputFormat::Checkstyle => CheckstyleWriter::new(out).map(Output::Checkstyle),
+            OutputFormat::GitlabCodeQuality => Ok(Output::CodeQuality(CodeQualityWriter::new(out))),
+            OutputFormat::Github => Ok(Output::Github(WorkflowWriter::new(
+                out,
+                ANNOTATIONS_PER_LEVEL,
+            ))),
+            OutputFormat::Rdjson | OutputFormat::Rdjsonl => {
+                RdjsonWriter::new(out, rdjson_layout(options.format)).map(Output::Rdjson)
+            }
+            OutputFormat::Score => start_score(out, &summary),
+            OutputFormat::Text => Ok(Output::Text(TextWriter::new(out, text_style(options)))),
+            OutputFormat::Html | OutputFormat::Junit | OutputFormat::Bitbucket => Ok(Output::Whole),
+            OutputFormat::Markdown | OutputFormat::Dot => unreachable!("rejected by run_with"),
+        };
+        Reporter {
+            options,
+            workspace,
+            summary,
+            owned: Owners::default(),
+            limiter: options.limits.limiter(),
+            rules: Vec::new(),
+            output: output.unwrap_or_else(|e| write_failed(e)),
+            total_issues: 0,
+            failing: 0,
+            modules: Vec::new(),
+            kept: Vec::new(),
+            sources: Vec::new(),
+            metadata: start_metadata(options, started).map(|mut metadata| {
+                metadata.stage("discover");
+                metadata
+            }),
+            comparison,
+            started,
+            profiles: Vec::new(),
+            trace,
+            metrics: start_metrics(options),
+            streamed: Duration::ZERO,
+        }
+    }
 
-    let output = analyzer.format_score_as_json(&results, &score);
-    match to_string_pretty(&output) {
-        Ok(json) => println!("{}", json),
-        Err(e) => {
-            eprintln!("Error: failed to format analysis result: {}", e);
-            process::exit(1);
+    fn file(&mut self, path: String, mut analysis: FileAnalysis) {
+        if let Some(trace) = &mut self.trace {
+            trace.file(&path, &analysis.profile, analysis.results.len());
         }
+        if self.options.profile || self.options.pprof.is_some() {
+            self.profiles.push((path.clone(), analysis.profile.clone()));
+        }
+        // Other owners' files are left out of the scores as well.
+        let owners = self.owned.of(&path);
+        if !is_owned(self.options, &owners) {
+            return;
+        }
+        if let Some(metadata) = &mut self.metadata {
+            metadata.file(
+                &path,
+                &analysis.config_label,
+                analysis.analyzer.rules(),
+                analysis.profile.cached,
+            );
+        }
+        for rule in analysis.analyzer.rules() {
+            if !self.rules.iter().any(|known| known.name == rule.name) {
+                self.rules.push(rule.clone());
+            }
+        }
+
+        postprocess::dedup(&mut analysis.results);
+        if let Some(metrics) = &mut self.metrics {
+            metrics.file(&path, &analysis.results, analysis.profile.cached);
+        }
+        if let Some(comparison) = &mut self.comparison {
+            comparison.label(&path, &mut analysis.results);
+        }
+        // Scores count every finding; the report only shows those within
+        // the limits.
+        let score = analysis
+            .analyzer
+            .calculate_score(&analysis.results, &analysis.source_code);
+        let module = self.workspace.tag(&path);
+        let mut file = FileFindings {
+            path,
+            module,
+            owners,
+            results: analysis.results,
+        };
+        self.limiter.apply(&mut file);
+        self.total_issues += file.results.len();
+        self.failing += failing(self.options.fail_on, &file.results);
+        workspace::add_to_summary(&mut self.modules, &file);
+
+        let writing = Instant::now();
+        let written = match &mut self.output {
+            Output::Json(writer) => writer.file(&file),
+            Output::Sarif(writer) => writer.file(&self.rules, &file, &analysis.source_code),
+            Output::Checkstyle(writer) => writer.file(&file),
+            Output::CodeQuality(writer) => writer.file(&file),
+            Output::Github(writer) => writer.file(&file),
+            Output::Rdjson(writer) => writer.file(&file, &analysis.source_code),
+            Output::Text(writer) => writer.file(&file, &analysis.source_code),
+            Output::Score(out, files) => {
+                let mut report = analysis
+                    .analyzer
+                    .format_score_as_json(&file.results, &score);
+                report["file"] = json!(file.path);
+                if let Some(module) = &file.module {
+                    report["module"] = json!(module);
+                }
+                if !file.owners.is_empty() {
+                    report["owners"] = json!(file.owners);
+                }
+                if self.options.group_by != Grouping::File {
+                    if let Some(report) = report.as_object_mut() {
+                        report.remove("issues");
+                    }
+                }
+                files.push(out, &report)
+            }
+            Output::Whole => Ok(()),
+        };
+        if let Err(e) = written {
+            write_failed(e);
+        }
+        self.streamed += writing.elapsed();
+
+        let whole = matches!(self.output, Output::Whole);
+        if whole || self.options.group_by != Grouping::File {
+            if self.options.format == OutputFormat::Html {
+                self.sources.push(analysis.source_code);
+            }
+            self.kept.push(file);
+        }
+    }
+
+    fn finish(mut self) {
+        if let Some(metadata) = &mut self.metadata {
+            metadata.stage("analyze");
+        }
+        let formatting = Instant::now();
+        let files = &self.kept;
+        let fixed = self
+            .comparison
+            .as_ref()
+            .map(Comparison::fixed)
+            .unwrap_or_default();
+        let written = match self.output {
+            Output::Json(writer) => writer.finish(&fixed).and_then(|mut out| out.flush()),
+            Output::Sarif(writer) => writer.finish(&self.rules).and_then(|mut out| out.flush()),
+            Output::Checkstyle(writer) => writer.finish().and_then(|mut out| out.flush()),
+            Output::CodeQuality(writer) => writer.finish().and_then(|mut out| out.flush()),
+            Output::Rdjson(writer) => writer.finish().and_then(|mut out| out.flush()),
+            Output::Text(writer) => writer.finish().and_then(|mut out| out.flush()),
+            Output::Github(writer) => writer.finish().and_then(|(mut out, summary)| {
+                out.flush()?;
+                write_job_summary(summary);
+                Ok(())
+            }),
+            Output::Score(mut out, reports) => {
+                let mut summary = self.summary;
+                summary["total_issues"] = json!(self.total_issues);
+                match self.options.group_by {
+                    Grouping::File => {}
+                    Grouping::Rule => summary["rules"] = json!(postprocess::group_by_rule(files)),
+                    Grouping::Owner => {
+                        summary["owners"] = json!(postprocess::group_by_owner(files))
+                    }
+                }
+                if !self.limiter.omitted.is_empty() {
+                    summary["omitted"] = json!(self.limiter.omitted);
+                }
+                if !self.workspace.modules.is_empty() {
+                    summary["modules"] = json!(self.modules);
+                }
+                if let Some(comparison) = &self.comparison {
+                    summary["previous_run"] = previous_run(self.options, comparison);
+                }
+                finish_score(&mut out, reports, &summary).and_then(|()| out.flush())
+            }
+            Output::Whole if self.options.format == OutputFormat::Html => {
+                let sources: Vec<&str> = self.sources.iter().map(String::as_str).collect();
+                let repository = Repository::detect(Path::new("."));
+                print!(
+                    "{}",
+                    html::to_html(&self.rules, files, &sources, repository.as_ref())
+                );
+                Ok(())
+            }
+            Output::Whole if self.options.format == OutputFormat::Bitbucket => {
+                print_json(&bitbucket::to_bitbucket(files, self.failing > 0));
+                Ok(())
+            }
+            Output::Whole => {
+                print_xml(self.options.format, files);
+                Ok(())
+            }
+        };
+        if let Err(e) = written {
+            write_failed(e);
+        }
+        if let Some(mut metadata) = self.metadata {
+            metadata.stage("report");
+            write_metadata(self.options, metadata);
+        }
+        if let Some(mut trace) = self.trace {
+            let root = trace.root();
+            trace.set(root, "compass.findings", self.total_issues);
+            let format = trace.span("format", Some(root), formatting, Instant::now());
+            // Streamed formats write most of the report as files come in.
+            trace.set(
+                format,
+                "compass.streamed_ms",
+                self.streamed.as_millis() as usize,
+            );
+            export_trace(self.options, trace);
+        }
+        if let Some(metrics) = &self.metrics {
+            push_metrics(self.options, metrics, self.started.elapsed());
+        }
+        report_profile(
+            self.options,
+            self.started,
+            self.profiles
+                .iter()
+                .map(|(path, profile)| (path.as_str(), profile)),
+        );
+        report_omitted(&self.limiter.omitted);
+        if let Some(comparison) = &self.comparison {
+            report_comparison(self.options, comparison);
+        }
+        exit_if_failing(self.failing);
     }
 }
 
-fn usage(program: &str) -> ! {
-    eprintln!("Usage: {} <source-file> [config-file]", program);
-    eprintln!("Example: {} src/main.rs", program);
-    eprintln!("         {} src/main.rs my-preferences.toml", program);
-    eprintln!("\nSupported extensions: .rs, .go, .js, .jsx, .zig, .java, .cpp, .cc, .cxx, .h, .hpp, .swift");
+/// Writes the score report's members that sort before `files`, as
+/// `to_string_pretty` orders them, and opens `files`.
+fn start_score(mut out: Stdout, summary: &serde_json::Value) -> io::Result<Output> {
+    out.write_all(b"{\n")?;
+    if let Some(members) = summary.as_object() {
+        for (key, value) in members.iter().filter(|(key, _)| key.as_str() < "files") {
+            write!(out, "  {}: ", json!(key))?;
+            format::write_nested(&mut out, value, 2)?;
+            out.write_all(b",\n")?;
+        }
+    }
+    out.write_all(b"  \"files\": ")?;
+    Ok(Output::Score(out, JsonArray::new(2)))
+}
+
+/// Closes `files` and writes the rest of the score report's members.
+fn finish_score(out: &mut Stdout, files: JsonArray, summary: &serde_json::Value) -> io::Result<()> {
+    files.close(out)?;
+    if let Some(members) = summary.as_object() {
+        for (key, value) in members.iter().filter(|(key, _)| key.as_str() > "files") {
+            write!(out, ",\n  {}: ", json!(key))?;
+            format::write_nested(out, value, 2)?;
+        }
+    }
+    out.write_all(b"\n}\n")
+}
+
+/// The `--emit-metadata` record of a run that began at `started`, when
+/// one was asked for.
+fn start_metadata(options: &Options, started: Instant) -> Option<Metadata> {
+    options.emit_metadata.as_ref()?;
+    let settings = Settings {
+        preset: options.preset.map(|preset| preset.as_str().to_string()),
+        mode: options.mode.as_str().to_string(),
+        min_confidence: options
+            .min_confidence
+            .map(|confidence| confidence.as_str().to_string()),
+        build_tags: options.build_tags.clone(),
+        platforms: options.platforms.iter().map(ToString::to_string).collect(),
+        baseline: options.baseline.clone(),
+        vuln: options.vuln,
+        experimental: options.enable_experimental,
+    };
+    let mut metadata = Metadata::new(
+        env::args().skip(1).collect(),
+        settings,
+        !options.no_cache,
+        started,
+    );
+    metadata.commit = diff::git_in(Path::new("."), &["rev-parse", "HEAD"])
+        .ok()
+        .map(|commit| commit.trim().to_string());
+    Some(metadata)
+}
+
+fn write_metadata(options: &Options, mut metadata: Metadata) {
+    let Some(path) = &options.emit_metadata else {
+        return;
+    };
+    if let Err(e) = metadata.write(path) {
+        eprintln!("Error: failed to write '{}': {}", path, e);
+        process::exit(1);
+    }
+}
+
+/// How `--format text` lays findings out on stdout.
+fn text_style(options: &Options) -> TextStyle {
+    TextStyle::for_stdout(options.no_color, options.context_lines)
+}
+
+fn write_failed(e: io::Error) -> ! {
+    eprintln!("Error: failed to write the report: {}", e);
     process::exit(1);
 }
 
-#[derive(Clone, Copy)]
-enum SupportedLanguage {
-    Rust,
-    Go,
-    JavaScript,
-    Zig,
-    Java,
-    Cpp,
-    Swift,
+/// With `--group-by rule` or `owner`, replaces a file's `issues` with its
+/// findings grouped that way.
+fn group_issues(options: &Options, report: &mut serde_json::Value, files: &[FileFindings]) {
+    let (key, groups) = match options.group_by {
+        Grouping::File => return,
+        Grouping::Rule => ("rules", json!(postprocess::group_by_rule(files))),
+        Grouping::Owner => ("owners", json!(postprocess::group_by_owner(files))),
+    };
+    if let Some(report) = report.as_object_mut() {
+        report.remove("issues");
+        report.insert(key.to_string(), groups);
+    }
+}
+
+/// Whether `--owner`, if given, is one of `owners`. Team and user names
+/// are compared ignoring case, as GitHub does, and the `@` is optional.
+fn is_owned(options: &Options, owners: &[String]) -> bool {
+    let Some(wanted) = &options.owner else {
+        return true;
+    };
+    let wanted = wanted.trim_start_matches('@');
+    owners
+        .iter()
+        .any(|owner| owner.trim_start_matches('@').eq_ignore_ascii_case(wanted))
 }
 
-impl SupportedLanguage {
-    fn from_path(file_path: &str) -> Option<Self> {
-        let extension = Path::new(file_path)
-            .extension()
-            .and_then(|ext| ext.to_str())?
-            .to_ascii_lowercase();
+/// Tells stderr how many findings `--max-issues-per-rule` and
+/// `--max-same-issues` left out, and of which rules.
+fn report_omitted(omitted: &BTreeMap<String, usize>) {
+    if omitted.is_empty() {
+        return;
+    }
+    let rules: Vec<String> = omitted
+        .iter()
+        .map(|(rule, count)| format!("{} {}", count, rule))
+        .collect();
+    eprintln!(
+        "compass: {} more finding(s) not shown because of the issue limits: {}",
+        omitted.values().sum::<usize>(),
+        rules.join(", ")
+    );
+}
+
+fn rdjson_layout(format: OutputFormat) -> Layout {
+    match format {
+        OutputFormat::Rdjsonl => Layout::Lines,
+        _ => Layout::Document,
+    }
+}
+
+fn print_xml(format: OutputFormat, files: &[FileFindings]) {
+    match format {
+        OutputFormat::Checkstyle => print!("{}", checkstyle::to_checkstyle(files)),
+        _ => print!("{}", junit::to_junit(files)),
+    }
+}
+
+/// Prints workflow commands and, when the annotation limit left findings
+/// out, appends them to the job summary if `$GITHUB_STEP_SUMMARY` is set.
+fn print_github(files: &[FileFindings]) {
+    let workflow = github::to_workflow(files, ANNOTATIONS_PER_LEVEL);
+    print!("{}", workflow.commands);
+    write_job_summary(workflow.summary);
+}
+
+fn write_job_summary(summary: Option<String>) {
+    let (Some(summary), Some(path)) = (summary, env::var_os("GITHUB_STEP_SUMMARY")) else {
+        return;
+    };
+    let written = fs::OpenOptions::new()
+        .create(true)
+        .append(true)
+        .open(&path)
+        .and_then(|mut file| io::Write::write_all(&mut file, summary.as_bytes()));
+    if let Err(e) = written {
+        eprintln!(
+            "Error: failed to write job summary '{}': {}",
+            Path::new(&path).display(),
+            e
+        );
+        process::exit(1);
+    }
+}
+
+/// How many of `results` are at or above `fail_on`. With `--compare-to`,
+/// findings the earlier report had don't count.
+fn failing(fail_on: Option<Severity>, results: &[AnalysisResult]) -> usize {
+    let Some(threshold) = fail_on else {
+        return 0;
+    };
+    results
+        .iter()
+        .filter(|result| result.severity.is_at_least(threshold))
+        .filter(|result| result.status != Some(Status::Unchanged))
+        .count()
+}
+
+/// Exits with status 1 when any finding is at or above `fail_on`.
+fn exit_for_failures(fail_on: Option<Severity>, files: &[FileFindings]) {
+    exit_if_failing(
+        files
+            .iter()
+            .map(|file| failing(fail_on, &file.results))
+            .sum(),
+    );
+}
+
+fn exit_if_failing(failing: usize) {
+    if failing > 0 {
+        eprintln!("compass: {} finding(s) fail the --fail-on policy", failing);
+        process::exit(1);
+    }
+}
+
+fn run_config(program: &str, options: Options, registry: &Registry) {
+    if options.positional.first().map(String::as_str) == Some("lint") {
+        run_config_lint(program, options, registry);
+        return;
+    }
+    if options.positional != ["show"] {
+        usage(program);
+    }
+
+    let path = options.path.as_deref().unwrap_or(".");
+    let project = load_project(path);
+    match project.to_toml() {
+        Ok(text) => print!("{}", text),
+        Err(e) => {
+            eprintln!("Error: failed to format config: {}", e);
+            process::exit(1);
+        }
+    }
+    if project.is_excluded(path) {
+        println!("# {} is excluded", path);
+    }
+}
+
+/// Lints a rule config, or the built-in ones, and the `.compass.toml` files
+/// that apply to `--path`. Any error fails the run; warnings don't.
+fn run_config_lint(program: &str, options: Options, registry: &Registry) {
+    if options.positional.len() > 2
+        || !matches!(options.format, OutputFormat::Score | OutputFormat::Json)
+    {
+        usage(program);
+    }
 
-        match extension.as_str() {
-            "rs" => Some(SupportedLanguage::Rust),
-            "go" => Some(SupportedLanguage::Go),
-            "js" | "jsx" => Some(SupportedLanguage::JavaScript),
-            "zig" => Some(SupportedLanguage::Zig),
-            "java" => Some(SupportedLanguage::Java),
-            "cpp" | "cc" | "cxx" | "h" | "hpp" => Some(SupportedLanguage::Cpp),
-            "swift" => Some(SupportedLanguage::Swift),
-            _ => None,
+    let mut problems = Vec::new();
+    // Rules of the built-in configs can always be set in `.compass.toml`,
+    // though the configs themselves are linted only when none is given.
+    let mut configs = Vec::new();
+    for language in SupportedLanguage::ALL {
+        let content = language.default_config();
+        if options.positional.len() == 1 {
+            let label = format!("built-in {} config", language.config_key());
+            problems.extend(lint::lint_rules(&label, content, None, registry));
         }
+        configs.extend(toml::from_str::<AnalyzerConfig>(content).ok());
+    }
+    if let Some(path) = options.positional.get(1) {
+        let content = fs::read_to_string(path).unwrap_or_else(|e| {
+            eprintln!("Error: failed to read config '{}': {}", path, e);
+            process::exit(1);
+        });
+        let base_dir = Path::new(path).parent().unwrap_or(Path::new("."));
+        problems.extend(lint::lint_rules(path, &content, Some(base_dir), registry));
+        configs.extend(toml::from_str::<AnalyzerConfig>(&content).ok());
     }
 
-    fn tree_sitter_language(&self) -> Language {
-        match self {
-            SupportedLanguage::Rust => tree_sitter_rust::LANGUAGE.into(),
-            SupportedLanguage::Go => tree_sitter_go::LANGUAGE.into(),
-            SupportedLanguage::JavaScript => tree_sitter_javascript::LANGUAGE.into(),
-            SupportedLanguage::Zig => tree_sitter_zig::LANGUAGE.into(),
-            SupportedLanguage::Java => tree_sitter_java::LANGUAGE.into(),
-            SupportedLanguage::Cpp => tree_sitter_cpp::LANGUAGE.into(),
-            SupportedLanguage::Swift => tree_sitter_swift::LANGUAGE.into(),
+    let rules = lint::known_rules(&configs);
+    let dir = options.path.as_deref().unwrap_or(".");
+    let dir = fs::canonicalize(dir).unwrap_or_else(|e| {
+        eprintln!("Error: failed to resolve '{}': {}", dir, e);
+        process::exit(1);
+    });
+    for file in lint::project_files(&dir) {
+        let label = file.display().to_string();
+        match fs::read_to_string(&file) {
+            Ok(content) => problems.extend(lint::lint_project(&label, &content, &rules)),
+            Err(e) => eprintln!("Warning: failed to read '{}': {}", label, e),
         }
     }
 
-    fn config_key(&self) -> &'static str {
-        match self {
-            SupportedLanguage::Rust => "rust",
-            SupportedLanguage::Go => "go",
-            SupportedLanguage::JavaScript => "javascript",
-            SupportedLanguage::Zig => "zig",
-            SupportedLanguage::Java => "java",
-            SupportedLanguage::Cpp => "cpp",
-            SupportedLanguage::Swift => "swift",
+    let errors = problems
+        .iter()
+        .filter(|problem| problem.level == Level::Error)
+        .count();
+    if options.format == OutputFormat::Json {
+        print_json(&json!({ "problems": problems }));
+    } else {
+        print_problems(&problems, errors);
+    }
+    if errors > 0 {
+        process::exit(1);
+    }
+}
+
+fn print_problems(problems: &[Problem], errors: usize) {
+    for problem in problems {
+        let level = match problem.level {
+            Level::Error => "error",
+            Level::Warning => "warning",
+        };
+        match problem.line {
+            Some(line) => println!("{}:{}: {}: {}", problem.file, line, level, problem.message),
+            None => println!("{}: {}: {}", problem.file, level, problem.message),
         }
     }
+    if problems.is_empty() {
+        println!("No problems found");
+    } else {
+        println!(
+            "{} error(s), {} warning(s)",
+            errors,
+            problems.len() - errors
+        );
+    }
+}
+
+/// The rule configs `compass rules` and `compass explain` document: the
+/// built-in set of every language, or just `config_file`.
+fn rule_configs(config_file: Option<&str>) -> Vec<(String, AnalyzerConfig)> {
+    if let Some(path) = config_file {
+        let config = AnalyzerConfig::from_file(path).unwrap_or_else(|e| {
+            eprintln!("Error: failed to load config '{}': {}", path, e);
+            process::exit(1);
+        });
+        let label = Path::new(path)
+            .file_stem()
+            .map_or(path.to_string(), |stem| stem.to_string_lossy().into_owned());
+        return vec![(label, config)];
+    }
+
+    SupportedLanguage::ALL
+        .iter()
+        .map(|language| {
+            let config = AnalyzerConfig::from_str(language.default_config()).unwrap_or_else(|e| {
+                eprintln!(
+                    "Error: failed to load built-in {} config: {}",
+                    language.config_key(),
+                    e
+                );
+                process::exit(1);
+            });
+            (language.config_key().to_string(), config)
+        })
+        .collect()
+}
+
+fn rule_sets(configs: &[(String, AnalyzerConfig)]) -> Vec<RuleSet<'_>> {
+    configs
+        .iter()
+        .map(|(label, config)| {
+            let language = SupportedLanguage::ALL
+                .into_iter()
+                .find(|language| language.config_key() == label);
+            RuleSet {
+                label,
+                title: language.map_or(label.as_str(), |language| language.display_name()),
+                code_language: language.map_or("", |language| language.config_key()),
+                rules: &config.rules,
+            }
+        })
+        .collect()
+}
+
+fn run_rules(program: &str, options: Options) {
+    if options.positional.len() > 1
+        || !matches!(options.format, OutputFormat::Score | OutputFormat::Markdown)
+    {
+        usage(program);
+    }
 
-    fn display_name(&self) -> &'static str {
-        match self {
-            SupportedLanguage::Rust => "Rust",
-            SupportedLanguage::Go => "Go",
-            SupportedLanguage::JavaScript => "JavaScript",
-            SupportedLanguage::Zig => "Zig",
-            SupportedLanguage::Java => "Java",
-            SupportedLanguage::Cpp => "C++",
-            SupportedLanguage::Swift => "Swift",
+    let configs = rule_configs(options.positional.first().map(String::as_str));
+    let sets = rule_sets(&configs);
+    if options.format == OutputFormat::Markdown {
+        print!("{}", docs::markdown(&sets));
+    } else {
+        print!("{}", docs::list(&sets));
+    }
+}
+
+/// Accepts `go/panic_usage`, or a bare rule name when only one set has it.
+fn run_explain(program: &str, options: Options) {
+    if options.positional.is_empty() || options.positional.len() > 2 {
+        usage(program);
+    }
+
+    let rule_id = options.positional[0].as_str();
+    let configs = rule_configs(options.positional.get(1).map(String::as_str));
+    let sets = rule_sets(&configs);
+    let matches: Vec<_> = sets
+        .iter()
+        .flat_map(|set| set.rules.iter().map(move |rule| (set, rule)))
+        .filter(|(set, rule)| set.id(rule) == rule_id || rule.name == rule_id)
+        .collect();
+
+    match matches.as_slice() {
+        [] => {
+            eprintln!(
+                "Error: unknown rule '{}'. Run `{} rules` to list them",
+                rule_id, program
+            );
+            process::exit(1);
+        }
+        [(set, rule)] => print!("{}", docs::explain(set, rule)),
+        _ => {
+            let ids: Vec<String> = matches.iter().map(|(set, rule)| set.id(rule)).collect();
+            eprintln!(
+                "Error: '{}' names several rules: {}",
+                rule_id,
+                ids.join(", ")
+            );
+            process::exit(1);
         }
     }
+}
 
-    fn default_config(&self) -> &'static str {
-        match self {
-            SupportedLanguage::Rust => RUST_CONFIG,
-            SupportedLanguage::Go => GO_CONFIG,
-            SupportedLanguage::JavaScript => JAVASCRIPT_CONFIG,
-            SupportedLanguage::Zig => ZIG_CONFIG,
-            SupportedLanguage::Java => JAVA_CONFIG,
-            SupportedLanguage::Cpp => CPP_CONFIG,
-            SupportedLanguage::Swift => SWIFT_CONFIG,
+/// Lists the presets, or what changes between two of them in the built-in
+/// configs or a given one.
+fn run_preset(program: &str, options: Options) {
+    if !matches!(options.format, OutputFormat::Score | OutputFormat::Json) {
+        usage(program);
+    }
+    let (from, to, config_file) = match options.positional.as_slice() {
+        [command] if command == "list" => {
+            if options.format == OutputFormat::Json {
+                let presets: Vec<_> = Preset::ALL
+                    .iter()
+                    .map(|preset| json!({ "name": preset.as_str(), "description": preset.description() }))
+                    .collect();
+                print_json(&json!(presets));
+            } else {
+                for preset in Preset::ALL {
+                    println!("{:<10} {}", preset.as_str(), preset.description());
+                }
+            }
+            return;
+        }
+        [command, from, to, rest @ ..] if command == "diff" && rest.len() <= 1 => {
+            (from, to, rest.first())
         }
+        _ => usage(program),
+    };
+    let parse = |name: &str| {
+        Preset::from_name(name).unwrap_or_else(|| {
+            eprintln!(
+                "Error: unknown preset '{}'. Supported presets: {}",
+                name,
+                Preset::NAMES
+            );
+            process::exit(1);
+        })
+    };
+    let (from, to) = (parse(from), parse(to));
+
+    let diffs: Vec<_> = rule_configs(config_file.map(String::as_str))
+        .into_iter()
+        .map(|(label, config)| (label, preset::diff(&config, from, to)))
+        .filter(|(_, changes)| !changes.is_empty())
+        .collect();
+    if options.format == OutputFormat::Json {
+        let configs: serde_json::Map<_, _> = diffs
+            .iter()
+            .map(|(label, changes)| (label.clone(), preset::changes_json(changes)))
+            .collect();
+        print_json(&json!({ "from": from.as_str(), "to": to.as_str(), "configs": configs }));
+        return;
     }
+    if diffs.is_empty() {
+        println!(
+            "{} and {} enable the same rules",
+            from.as_str(),
+            to.as_str()
+        );
+    }
+    for (label, changes) in &diffs {
+        println!("{} ({} → {}):", label, from.as_str(), to.as_str());
+        for change in changes {
+            println!("  {}: {} → {}", change.name, change.from, change.to);
+        }
+    }
+}
+
+fn run_cache(program: &str, options: Options) {
+    if options.positional != ["clean"] {
+        usage(program);
+    }
+
+    let cache = Cache::new(Cache::default_dir());
+    match cache.clean() {
+        Ok(removed) => println!(
+            "Removed {} cached result(s) from {}",
+            removed,
+            cache.dir().display()
+        ),
+        Err(e) => {
+            eprintln!(
+                "Error: failed to clean cache '{}': {}",
+                cache.dir().display(),
+                e
+            );
+            process::exit(1);
+        }
+    }
+}
+
+/// How often `compass watch` looks for changes.
+const WATCH_INTERVAL: Duration = Duration::from_millis(300);
+
+fn run_watch(program: &str, options: Options, registry: Registry) {
+    if options.positional.len() > 2
+        || matches!(
+            options.format,
+            OutputFormat::Sarif
+                | OutputFormat::Github
+                | OutputFormat::Html
+                | OutputFormat::Checkstyle
+                | OutputFormat::Junit
+                | OutputFormat::GitlabCodeQuality
+                | OutputFormat::Bitbucket
+                | OutputFormat::Rdjson
+                | OutputFormat::Rdjsonl
+        )
+    {
+        usage(program);
+    }
+    let root = options.positional.first().map_or(".", String::as_str);
+    let config_override = options.positional.get(1).cloned();
+    let mut watcher = Watcher::new(root, config_override, registry);
+
+    eprintln!("Watching {} for changes (Ctrl-C to stop)", root);
+    let mut last_error = None;
+    loop {
+        match watcher.poll() {
+            Ok(updates) => {
+                last_error = None;
+                for update in &updates {
+                    print_update(update, options.format);
+                }
+            }
+            Err(e) => {
+                // A broken config is reported once, then retried quietly.
+                let message = e.to_string();
+                if last_error.as_ref() != Some(&message) {
+                    eprintln!("Error: {}", message);
+                }
+                last_error = Some(message);
+            }
+        }
+        thread::sleep(WATCH_INTERVAL);
+    }
+}
+
+fn print_update(update: &PackageUpdate, format: OutputFormat) {
+    if format == OutputFormat::Json {
+        match serde_json::to_string(&to_report(&update.files)) {
+            Ok(line) => println!("{}", line),
+            Err(e) => eprintln!("Error: failed to format analysis result: {}", e),
+        }
+        for error in &update.errors {
+            eprintln!("Error: {}", error);
+        }
+        return;
+    }
+
+    let count: usize = update.files.iter().map(|file| file.results.len()).sum();
+    println!("{}: {} finding(s)", update.dir.display(), count);
+    for file in &update.files {
+        for result in &file.results {
+            println!(
+                "  {}:{}:{}: {} {}: {}",
+                file.path,
+                result.line,
+                result.column,
+                result.severity.as_str(),
+                result.rule_name,
+                result.message
+            );
+        }
+    }
+    for error in &update.errors {
+        println!("  error: {}", error);
+    }
+}
+
+fn run_metrics(program: &str, options: Options) {
+    if options.positional.len() > 1 {
+        usage(program);
+    }
+    let root = options.positional.first().map_or(".", String::as_str);
+    let files = walk::source_files(root).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    });
+
+    let per_file = parallel::map_ordered(&files, options.jobs, |path| {
+        let path = path.to_string_lossy().into_owned();
+        let Some(language) = SupportedLanguage::from_path(&path) else {
+            return Vec::new();
+        };
+        let source_code = fs::read_to_string(&path).unwrap_or_else(|e| {
+            eprintln!("Error: failed to read '{}': {}", path, e);
+            process::exit(1);
+        });
+        let mut parser = Parser::new();
+        if parser
+            .set_language(&language.tree_sitter_language())
+            .is_err()
+        {
+            return Vec::new();
+        }
+        let Some(tree) = parser.parse(&source_code, None) else {
+            return Vec::new();
+        };
+        complexity::functions(tree.root_node(), &source_code)
+            .iter()
+            .map(|function| {
+                json!({
+                    "file": path,
+                    "function": function.name,
+                    "line": function.node.start_position().row + 1,
+                    "cyclomatic": function.cyclomatic,
+                    "cognitive": function.cognitive
+                })
+            })
+            .collect()
+    });
+    let functions: Vec<serde_json::Value> = per_file.into_iter().flatten().collect();
+
+    let average = |metric: &str| {
+        let total: u64 = functions.iter().filter_map(|f| f[metric].as_u64()).sum();
+        if functions.is_empty() {
+            0.0
+        } else {
+            (total as f64 / functions.len() as f64 * 100.0).round() / 100.0
+        }
+    };
+    let worst = |metric: &str| {
+        let mut sorted: Vec<&serde_json::Value> = functions.iter().collect();
+        sorted.sort_by_key(|f| std::cmp::Reverse(f[metric].as_u64()));
+        sorted
+            .into_iter()
+            .take(options.top)
+            .cloned()
+            .collect::<Vec<_>>()
+    };
+
+    print_json(&json!({
+        "files": files.len(),
+        "functions": functions.len(),
+        "average_cyclomatic": average("cyclomatic"),
+        "average_cognitive": average("cognitive"),
+        "worst_cyclomatic": worst("cyclomatic"),
+        "worst_cognitive": worst("cognitive")
+    }));
+}
+
+/// The width of each column of `compass dupes`, so two fit beside each
+/// other on a wide terminal.
+const DUPES_COLUMN_WIDTH: usize = 60;
+
+/// Reports functions that are copies of each other under a path, in
+/// columns side by side. Clones fail the run, so it can gate merges the
+/// way `apidiff` does.
+fn run_dupes(program: &str, options: Options) {
+    if options.positional.len() > 1
+        || !matches!(options.format, OutputFormat::Score | OutputFormat::Json)
+    {
+        usage(program);
+    }
+    let root = options.positional.first().map_or(".", String::as_str);
+    let settings = load_project(root).merged.dupes.unwrap_or_default();
+    let min_tokens = options
+        .min_tokens
+        .or(settings.min_tokens)
+        .unwrap_or(dupes::DEFAULT_MIN_TOKENS);
+    let tests = settings.tests.unwrap_or(false);
+    let files: Vec<_> = walk::source_files(root)
+        .unwrap_or_else(|e| {
+            eprintln!("Error: {}", e);
+            process::exit(1);
+        })
+        .into_iter()
+        .filter(|path| tests || !path.to_string_lossy().ends_with("_test.go"))
+        .collect();
+
+    let per_file = parallel::map_ordered(&files, options.jobs, |path| {
+        let path = path.to_string_lossy().into_owned();
+        let Some(language) = SupportedLanguage::from_path(&path) else {
+            return Vec::new();
+        };
+        let source_code = fs::read_to_string(&path).unwrap_or_else(|e| {
+            eprintln!("Error: failed to read '{}': {}", path, e);
+            process::exit(1);
+        });
+        let mut parser = Parser::new();
+        if parser
+            .set_language(&language.tree_sitter_language())
+            .is_err()
+        {
+            return Vec::new();
+        }
+        let Some(tree) = parser.parse(&source_code, None) else {
+            return Vec::new();
+        };
+        dupes::bodies(&path, tree.root_node(), &source_code)
+    });
+    let found = dupes::duplicates(per_file.into_iter().flatten().collect(), min_tokens);
+
+    if options.format == OutputFormat::Json {
+        print_json(&json!({
+            "files": files.len(),
+            "min_tokens": min_tokens,
+            "duplicated_lines": found.iter().map(dupes::Duplicate::duplicated_lines).sum::<usize>(),
+            "duplicates": found
+        }));
+    } else {
+        print!("{}", dupes::report(&found, DUPES_COLUMN_WIDTH));
+    }
+    if !found.is_empty() {
+        process::exit(1);
+    }
+}
+
+/// Lists every use of unsafe code, reflection headers, linkname and cgo, and
+/// every `init` and package-level variable, under a path, including what
+/// their rules allow, grouped by rule. The list is a report, so it never
+/// fails the run.
+fn run_audit(program: &str, options: Options, registry: &Registry) {
+    if options.positional.len() > 2
+        || !matches!(options.format, OutputFormat::Score | OutputFormat::Json)
+    {
+        usage(program);
+    }
+    let root = options.positional.first().map_or(".", String::as_str);
+    let config_override = options.positional.get(1).map(String::as_str);
+    let paths: Vec<_> = walk::source_files(root)
+        .unwrap_or_else(|e| {
+            eprintln!("Error: {}", e);
+            process::exit(1);
+        })
+        .into_iter()
+        .filter(|path| {
+            matches!(
+                SupportedLanguage::from_path(&path.to_string_lossy()),
+                Some(SupportedLanguage::Go)
+            )
+        })
+        .collect();
+
+    let analyses = parallel::map_ordered(&paths, options.jobs, |path| {
+        let source_path = path.to_string_lossy();
+        let (mut config, _, _) = load_config_for(
+            &source_path,
+            SupportedLanguage::Go,
+            config_override,
+            options.preset,
+            options.enable_experimental,
+        );
+        // Rules turned off for a directory still list what they would find.
+        config.rules.retain(|rule| {
+            rule.check
+                .as_deref()
+                .is_some_and(|check| checks::AUDIT_CHECKS.contains(&check))
+        });
+        for rule in &mut config.rules {
+            rule.enabled = true;
+            rule.options
+                .insert("audit".to_string(), toml::Value::Boolean(true));
+        }
+        let mut analyzer = config.to_analyzer();
+        analyzer.set_registry(registry.clone());
+        if !analyzer.has_rules() {
+            return Vec::new();
+        }
+        let source_code = fs::read_to_string(path).unwrap_or_else(|e| {
+            eprintln!("Error: failed to read '{}': {}", source_path, e);
+            process::exit(1);
+        });
+        let package = Package::load(path).ok();
+        analyzer
+            .analyze_in_package(
+                &source_code,
+                &SupportedLanguage::Go.tree_sitter_language(),
+                package.as_ref(),
+            )
+            .unwrap_or_else(|e| {
+                eprintln!("Error: analysis failed for '{}': {}", source_path, e);
+                process::exit(1);
+            })
+    });
+
+    let mut uses: BTreeMap<&str, Vec<(String, &AnalysisResult)>> = BTreeMap::new();
+    let mut files = 0;
+    for (path, results) in paths.iter().zip(&analyses) {
+        files += usize::from(!results.is_empty());
+        for result in results {
+            uses.entry(result.rule_name.as_str())
+                .or_default()
+                .push((path.display().to_string(), result));
+        }
+    }
+    let total: usize = uses.values().map(Vec::len).sum();
+
+    if options.format == OutputFormat::Json {
+        let rules: serde_json::Map<String, serde_json::Value> = uses
+            .iter()
+            .map(|(rule, uses)| {
+                let uses = uses
+                    .iter()
+                    .map(|(file, result)| {
+                        json!({
+                            "file": file,
+                            "line": result.line,
+                            "column": result.column,
+                            "message": result.message
+                        })
+                    })
+                    .collect();
+                (rule.to_string(), serde_json::Value::Array(uses))
+            })
+            .collect();
+        print_json(&json!({ "uses": total, "files": files, "rules": rules }));
+        return;
+    }
+    for (rule, uses) in &uses {
+        println!("{} ({})", rule, uses.len());
+        for (file, result) in uses {
+            println!(
+                "  {}:{}:{}: {}",
+                file, result.line, result.column, result.message
+            );
+        }
+    }
+    println!("{} use(s) in {} file(s)", total, files);
+}
+
+/// Reports the changes to the exported API of the Go module under a path
+/// since `--base`, a git revision or a published version. Breaking changes
+/// fail the run.
+fn run_apidiff(program: &str, options: Options) {
+    if options.positional.len() > 1
+        || !matches!(options.format, OutputFormat::Score | OutputFormat::Json)
+    {
+        usage(program);
+    }
+    let Some(base) = options.base.as_deref() else {
+        eprintln!("Error: compass apidiff requires --base <git-ref|version>");
+        usage(program);
+    };
+    let root = options.positional.first().map_or(".", String::as_str);
+    let module = match Module::find(Path::new(root)) {
+        Ok(Some(module)) => module,
+        Ok(None) => {
+            eprintln!("Error: no go.mod found in '{}' or above it", root);
+            process::exit(1);
+        }
+        Err(e) => {
+            eprintln!("Error: failed to read '{}': {}", root, e);
+            process::exit(1);
+        }
+    };
+    let (old, new) = match (Api::read_base(&module, base), Api::read_dir(&module.root)) {
+        (Ok(old), Ok(new)) => (old, new),
+        (Err(e), _) | (_, Err(e)) => {
+            eprintln!("Error: {}", e);
+            process::exit(1);
+        }
+    };
+
+    let changes = apidiff::compare(&old, &new);
+    let breaking = changes.iter().filter(|change| change.breaking).count();
+    if options.format == OutputFormat::Json {
+        print_json(&json!({
+            "base": base,
+            "breaking": breaking,
+            "changes": changes
+        }));
+    } else {
+        print!("{}", apidiff::report(base, &changes));
+    }
+    if breaking > 0 {
+        process::exit(1);
+    }
+}
+
+/// Reports the risks in the dependencies of the Go module at a path as
+/// findings in its `go.mod` and `go.sum`, from the rules that use the
+/// `go_mod_*` checks, in any of the usual formats and with `--fail-on`.
+/// `--vuln` adds the known vulnerabilities.
+fn run_deps(program: &str, options: Options) {
+    let started = Instant::now();
+    if options.positional.len() > 2
+        || matches!(options.format, OutputFormat::Markdown | OutputFormat::Dot)
+    {
+        usage(program);
+    }
+    let root = options.positional.first().map_or(".", String::as_str);
+    let config_override = options.positional.get(1).map(String::as_str);
+    let module = match Module::find(Path::new(root)) {
+        Ok(Some(module)) => module,
+        Ok(None) => {
+            eprintln!("Error: no go.mod found in '{}' or above it", root);
+            process::exit(1);
+        }
+        Err(e) => {
+            eprintln!("Error: failed to read '{}': {}", root, e);
+            process::exit(1);
+        }
+    };
+    let workspace = Workspace::discover(&module.root).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    });
+    if options.vuln {
+        open_vuln_db(root, config_override);
+    }
+    let mut reporter = Reporter::new(&options, &workspace, json!({}), started);
+    for (path, analysis) in dependency_files(&module, config_override, &options, false) {
+        reporter.file(path, analysis);
+    }
+    reporter.finish();
+}
+
+/// The findings of the `go_mod_*` rules in `module`'s `go.mod` and
+/// `go.sum`, or with `vuln_only` of the one reporting vulnerabilities.
+fn dependency_files(
+    module: &Module,
+    config_override: Option<&str>,
+    options: &Options,
+    vuln_only: bool,
+) -> Vec<(String, FileAnalysis)> {
+    let dependencies = Dependencies::read(module);
+    // Reported relative to the working directory, as walked files are.
+    let cwd = env::current_dir().and_then(|cwd| cwd.canonicalize()).ok();
+    let path_of = |name: &str| {
+        let path = module.root.join(name);
+        cwd.as_deref()
+            .and_then(|cwd| path.strip_prefix(cwd).ok())
+            .map_or_else(|| path.clone(), Path::to_path_buf)
+            .to_string_lossy()
+            .into_owned()
+    };
+    let go_mod_path = path_of(GO_MOD_FILE);
+    let (mut config, config_label, _) = load_config_for(
+        &go_mod_path,
+        SupportedLanguage::Go,
+        config_override,
+        options.preset,
+        options.enable_experimental,
+    );
+    if let Some(confidence) = options.min_confidence {
+        config.min_confidence = Some(confidence.as_str().to_string());
+    }
+    if options.vuln {
+        enable_vuln_rules(&mut config);
+    }
+    config.rules.retain(
+        |rule| match rule.check.as_deref().and_then(Issue::from_check) {
+            Some(issue) => !vuln_only || issue == Issue::Vulnerable,
+            None => false,
+        },
+    );
+
+    let baseline = load_baseline(options);
+    let mut files = vec![(Source::GoMod, go_mod_path, dependencies.go_mod.clone())];
+    if let Some(go_sum) = &dependencies.go_sum {
+        files.push((Source::GoSum, path_of(GO_SUM_FILE), go_sum.clone()));
+    }
+    let mut analyses = Vec::new();
+    for (source, path, source_code) in files {
+        let analyzer = config.to_analyzer();
+        let mut results = Vec::new();
+        for rule in analyzer.rules() {
+            let Some(issue) = rule.check.as_deref().and_then(Issue::from_check) else {
+                continue;
+            };
+            results.extend(
+                dependencies
+                    .findings(issue, &rule.options)
+                    .into_iter()
+                    .filter(|finding| finding.source == source)
+                    .map(|finding| {
+                        rule.result_in_text(
+                            &source_code,
+                            finding.span,
+                            Some(finding.message),
+                            finding.confidence,
+                        )
+                    }),
+            );
+        }
+        let mut results = analyzer
+            .finish_text_results(&source_code, results)
+            .unwrap_or_else(|e| {
+                eprintln!("Error: {}: {}", path, e);
+                process::exit(1);
+            });
+        if let Some(baseline) = &baseline {
+            results = baseline.filter(&path, results);
+        }
+        let analysis = FileAnalysis {
+            language: SupportedLanguage::Go,
+            config_label: config_label.clone(),
+            analyzer,
+            source_code,
+            results,
+            in_build: true,
+            profile: FileProfile::default(),
+        };
+        analyses.push((path, analysis));
+    }
+    analyses
+}
+
+/// Turns on the rules reporting known vulnerabilities, for `--vuln`.
+fn enable_vuln_rules(config: &mut AnalyzerConfig) {
+    for rule in &mut config.rules {
+        if rule
+            .check
+            .as_deref()
+            .is_some_and(|check| checks::VULN_CHECKS.contains(&check))
+        {
+            rule.enabled = true;
+        }
+    }
+}
+
+/// Checks that `--vuln` has a vulnerability database to read, from the
+/// rules' `db` option for `path` or `$GOVULNDB`, rather than finding
+/// nothing.
+fn open_vuln_db(path: &str, config_override: Option<&str>) {
+    let (config, _, _) = load_config_for(path, SupportedLanguage::Go, config_override, None, false);
+    let option = config
+        .rules
+        .iter()
+        .filter(|rule| {
+            rule.check
+                .as_deref()
+                .is_some_and(|check| checks::VULN_CHECKS.contains(&check))
+        })
+        .find_map(|rule| rule.options.get("db").and_then(|db| db.as_str()));
+    let Some(location) = Database::locate(option) else {
+        eprintln!(
+            "Error: --vuln needs a copy of the Go vulnerability database: set {} or the rules' db option to its directory, such as an unpacked https://vuln.go.dev/vulndb.zip",
+            crate::vuln::DB_ENV
+        );
+        process::exit(1);
+    };
+    if let Err(e) = Database::open(&location) {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    }
+}
+
+/// Prints the call graph of the Go code under a path as JSON, or with
+/// `--format dot` for Graphviz. Packages are named by import path when their
+/// module is found.
+fn run_callgraph(program: &str, options: Options) {
+    if options.positional.len() > 1
+        || !matches!(
+            options.format,
+            OutputFormat::Score | OutputFormat::Json | OutputFormat::Dot
+        )
+    {
+        usage(program);
+    }
+    let root = options.positional.first().map_or(".", String::as_str);
+    let (graph, _) = build_call_graph(root);
+    if options.format == OutputFormat::Dot {
+        print!("{}", graph.to_dot());
+    } else {
+        print_json(&json!(graph));
+    }
+}
+
+/// The call graph of the Go code under `root`, and the path of the module
+/// `root` is in, or an empty one outside a module.
+fn build_call_graph(root: &str) -> (CallGraph, String) {
+    let dir = match Path::new(root) {
+        path if path.is_dir() => path,
+        path => path
+            .parent()
+            .filter(|dir| !dir.as_os_str().is_empty())
+            .unwrap_or(Path::new(".")),
+    };
+    let workspace = Workspace::discover(dir).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    });
+    let paths = walk::source_files(root).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    });
+
+    let mut files = Vec::new();
+    for path in paths.into_iter().filter(|path| callgraph::is_graphed(path)) {
+        let source_code = fs::read_to_string(&path).unwrap_or_else(|e| {
+            eprintln!("Error: failed to read '{}': {}", path.display(), e);
+            process::exit(1);
+        });
+        let parent = path.parent().unwrap_or(Path::new("."));
+        let package = workspace
+            .module_for(&path)
+            .and_then(|module| module.package_path(parent))
+            .unwrap_or_else(|| parent.display().to_string());
+        files.push(GoFile {
+            package,
+            path,
+            source_code,
+        });
+    }
+
+    let module = workspace
+        .module_for(dir)
+        .map(|module| module.path.clone())
+        .unwrap_or_default();
+    (CallGraph::build(&files), module)
+}
+
+/// Checks an assertion about the call graph of the Go code under a path,
+/// listing the functions that break it. It exits with 1 when there are any.
+fn run_policy(program: &str, options: Options) {
+    if options.positional.is_empty()
+        || options.positional.len() > 2
+        || !matches!(options.format, OutputFormat::Score | OutputFormat::Json)
+    {
+        usage(program);
+    }
+    let assertion = Assertion::parse(&options.positional[0]).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    });
+    let root = options.positional.get(1).map_or(".", String::as_str);
+    let (graph, module) = build_call_graph(root);
+    let violations = assertion.violations(&graph, &module);
+
+    if options.format == OutputFormat::Json {
+        let violations: Vec<_> = violations
+            .iter()
+            .map(|violation| {
+                let function = &graph.functions[violation.function];
+                json!({
+                    "function": function.id,
+                    "path": function.path,
+                    "line": function.line,
+                    "chain": violation
+                        .chain
+                        .iter()
+                        .map(|&f| graph.functions[f].id.as_str())
+                        .collect::<Vec<_>>(),
+                    "message": assertion.describe(violation, |f| graph.functions[f].id.clone()),
+                })
+            })
+            .collect();
+        print_json(&json!({
+            "assertion": assertion.text,
+            "functions": graph.functions.len(),
+            "violations": violations,
+        }));
+    } else if violations.is_empty() {
+        println!("✓ {} ({} functions)", assertion.text, graph.functions.len());
+    } else {
+        for violation in &violations {
+            let function = &graph.functions[violation.function];
+            println!(
+                "{}:{}: {}",
+                function.path.display(),
+                function.line,
+                assertion.describe(violation, |f| graph.functions[f].id.clone())
+            );
+        }
+        println!(
+            "\n{} of {} functions break the policy",
+            violations.len(),
+            graph.functions.len()
+        );
+    }
+    if !violations.is_empty() {
+        process::exit(1);
+    }
+}
+
+/// Combines the `--format json` reports of `--shard` runs into one, written
+/// to `--output` or stdout. With `--fail-on`, it fails like the run would
+/// have, had it been one.
+fn run_merge(program: &str, options: Options) {
+    if options.positional.is_empty() || options.format != OutputFormat::Score {
+        usage(program);
+    }
+    let mut reports = Vec::new();
+    for path in &options.positional {
+        let content = fs::read_to_string(path).unwrap_or_else(|e| {
+            eprintln!("Error: failed to read '{}': {}", path, e);
+            process::exit(1);
+        });
+        let report: json::Report = serde_json::from_str(&content).unwrap_or_else(|e| {
+            eprintln!(
+                "Error: '{}' is not a compass --format json report: {}",
+                path, e
+            );
+            process::exit(1);
+        });
+        if report.schema_version > json::SCHEMA_VERSION {
+            eprintln!(
+                "Error: '{}' has schema version {}, newer than this compass's {}",
+                path,
+                report.schema_version,
+                json::SCHEMA_VERSION
+            );
+            process::exit(1);
+        }
+        reports.push(report);
+    }
+
+    let merged = json::merge(reports);
+    // Through a value, so the members are sorted as in a run's report.
+    let text = to_string_pretty(&json!(merged)).unwrap_or_default() + "\n";
+    match &options.output {
+        Some(output) => fs::write(output, text).unwrap_or_else(|e| {
+            eprintln!("Error: failed to write '{}': {}", output, e);
+            process::exit(1);
+        }),
+        None => print!("{}", text),
+    }
+    if let Some(threshold) = options.fail_on {
+        exit_if_failing(
+            merged
+                .findings
+                .iter()
+                .filter(|finding| {
+                    Severity::from_name(&finding.severity)
+                        .is_some_and(|severity| severity.is_at_least(threshold))
+                })
+                .filter(|finding| finding.status.as_deref() != Some(Status::Unchanged.as_str()))
+                .count(),
+        );
+    }
+}
+
+/// Clones or updates the repositories listed in `--repos` and given as
+/// arguments, analyzes each as `compass score` does and writes one report
+/// grouped by repository and rule; see [`crate::scan`]. A repository that
+/// couldn't be scanned fails the run once the report is written.
+fn run_scan(program: &str, options: Options, registry: &Registry) {
+    if !matches!(options.format, OutputFormat::Score | OutputFormat::Json) {
+        usage(program);
+    }
+    // Repositories are URLs and directories, so the config is told apart
+    // by its extension.
+    let (configs, sources): (Vec<&String>, Vec<&String>) = options
+        .positional
+        .iter()
+        .partition(|arg| arg.ends_with(".toml"));
+    if configs.len() > 1 {
+        usage(program);
+    }
+    let config_override = configs.first().map(|config| config.as_str());
+    let mut repos = match &options.repos {
+        Some(list) => {
+            let text = fs::read_to_string(list).unwrap_or_else(|e| {
+                eprintln!("Error: failed to read '{}': {}", list, e);
+                process::exit(1);
+            });
+            Repo::parse_list(&text).unwrap_or_else(|e| {
+                eprintln!("Error: {}: {}", list, e);
+                process::exit(1);
+            })
+        }
+        None => Vec::new(),
+    };
+    repos.extend(sources.into_iter().map(|source| Repo::new(source, None)));
+    if repos.is_empty() {
+        usage(program);
+    }
+
+    let checkouts = Path::new(options.path.as_deref().unwrap_or(DEFAULT_CHECKOUT_DIR));
+    let cache = open_cache(&options);
+    let scanned: Vec<Scanned> = repos
+        .into_iter()
+        .map(|repo| {
+            let root = match repo.checkout(checkouts) {
+                Ok(root) => root,
+                Err(e) => {
+                    eprintln!("compass: skipping {}: {}", repo.name, e);
+                    return Scanned {
+                        repo,
+                        commit: None,
+                        outcome: Err(e),
+                    };
+                }
+            };
+            let commit = diff::git_in(&root, &["rev-parse", "HEAD"])
+                .ok()
+                .map(|commit| commit.trim().to_string());
+            let outcome = walk::source_files(&root)
+                .map_err(|e| e.to_string())
+                .map(|paths| {
+                    let analyses = parallel::map_ordered(&paths, options.jobs, |path| {
+                        analyze_path(
+                            &path.to_string_lossy(),
+                            config_override,
+                            options.min_confidence,
+                            false,
+                            options.preset,
+                            options.enable_experimental,
+                            options.mode,
+                            registry,
+                            cache.as_ref(),
+                            &[],
+                        )
+                    });
+                    let lines = analyses
+                        .iter()
+                        .map(|analysis| analysis.source_code.lines().count())
+                        .sum();
+                    let files = paths
+                        .iter()
+                        .zip(analyses)
+                        .map(|(path, analysis)| FileFindings {
+                            path: path
+                                .strip_prefix(&root)
+                                .unwrap_or(path)
+                                .to_string_lossy()
+                                .into_owned(),
+                            module: None,
+                            owners: Vec::new(),
+                            results: analysis.results,
+                        })
+                        .collect();
+                    (files, lines)
+                });
+            Scanned {
+                repo,
+                commit,
+                outcome,
+            }
+        })
+        .collect();
+
+    let report = scan::report(&scanned, options.format == OutputFormat::Json);
+    let text = to_string_pretty(&report).unwrap_or_default() + "\n";
+    match &options.output {
+        Some(output) => fs::write(output, text).unwrap_or_else(|e| {
+            eprintln!("Error: failed to write '{}': {}", output, e);
+            process::exit(1);
+        }),
+        None => print!("{}", text),
+    }
+    let files: Vec<&FileFindings> = scanned
+        .iter()
+        .filter_map(|scanned| scanned.outcome.as_ref().ok())
+        .flat_map(|(files, _)| files)
+        .collect();
+    exit_if_failing(
+        files
+            .iter()
+            .map(|file| failing(options.fail_on, &file.results))
+            .sum(),
+    );
+    if scanned.iter().any(|scanned| scanned.outcome.is_err()) {
+        process::exit(1);
+    }
+}
+
+/// Scores the whole tree, compares it with the last snapshot in the history
+/// and records it. With `--max-drop`, a score more than that below the last
+/// one fails the run and isn't recorded, so the history keeps the score to
+/// beat.
+fn run_score(program: &str, options: Options, registry: &Registry) {
+    if options.positional.len() > 2 {
+        usage(program);
+    }
+    let root = options.positional.first().map_or(".", String::as_str);
+    let config_override = options.positional.get(1).map(String::as_str);
+    let paths = walk::source_files(root).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    });
+    let cache = open_cache(&options);
+    let analyses = parallel::map_ordered(&paths, options.jobs, |path| {
+        analyze_path(
+            &path.to_string_lossy(),
+            config_override,
+            options.min_confidence,
+            options.vuln,
+            options.preset,
+            options.enable_experimental,
+            options.mode,
+            registry,
+            cache.as_ref(),
+            &[],
+        )
+    });
+
+    let lines = analyses
+        .iter()
+        .map(|analysis| analysis.source_code.lines().count())
+        .sum();
+    let results = analyses.iter().flat_map(|analysis| &analysis.results);
+    let mut snapshot = Snapshot::new(results, analyses.len(), lines);
+    snapshot.commit = diff::git_in(Path::new(root), &["rev-parse", "HEAD"])
+        .ok()
+        .map(|commit| commit.trim().to_string());
+
+    let location = options.history.as_deref().unwrap_or(DEFAULT_HISTORY_DIR);
+    let history = History::open(location);
+    let previous = history.latest().unwrap_or_else(|e| {
+        eprintln!("Error: failed to read the score history: {}", e);
+        process::exit(1);
+    });
+    let delta = previous
+        .as_ref()
+        .map(|previous| ((snapshot.score - previous.score) * 10.0).round() / 10.0);
+    print_json(&json!({
+        "score": snapshot.score,
+        "previous": previous.as_ref().map(|previous| previous.score),
+        "delta": delta,
+        "files": snapshot.files,
+        "lines": snapshot.lines,
+        "weighted": snapshot.weighted,
+        "errors": snapshot.errors,
+        "warnings": snapshot.warnings,
+        "info": snapshot.info,
+        "style": snapshot.style,
+        "history": location
+    }));
+
+    if let (Some(max_drop), Some(delta)) = (options.max_drop, delta) {
+        if -delta > max_drop {
+            eprintln!(
+                "compass: the score dropped by {:.1}, more than the --max-drop of {}",
+                -delta, max_drop
+            );
+            process::exit(1);
+        }
+    }
+    if !options.no_record {
+        if let Err(e) = history.record(&snapshot) {
+            eprintln!("Error: {}", e);
+            process::exit(1);
+        }
+    }
+}
+
+/// Writes a `.compass.toml` equivalent to a golangci-lint config and reports
+/// what had no equivalent.
+fn run_migrate(program: &str, options: Options) {
+    if options.from.as_deref() != Some("golangci-lint") || options.positional.len() > 1 {
+        if let Some(from) = options
+            .from
+            .as_deref()
+            .filter(|from| *from != "golangci-lint")
+        {
+            eprintln!(
+                "Error: unknown --from '{}'. Supported tools: golangci-lint",
+                from
+            );
+        }
+        usage(program);
+    }
+
+    let source = match options.positional.first() {
+        Some(path) => path.clone(),
+        None => GOLANGCI_CONFIG_FILES
+            .iter()
+            .find(|name| Path::new(name).is_file())
+            .map(|name| name.to_string())
+            .unwrap_or_else(|| {
+                eprintln!(
+                    "Error: no golangci-lint config found (looked for {})",
+                    GOLANGCI_CONFIG_FILES.join(", ")
+                );
+                process::exit(1);
+            }),
+    };
+    let migration = migrate::from_golangci_lint(&source).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    });
+    let text = migration.to_toml(&source).unwrap_or_else(|e| {
+        eprintln!("Error: failed to format config: {}", e);
+        process::exit(1);
+    });
+
+    let output = options.output.as_deref().unwrap_or(PROJECT_CONFIG_FILE);
+    if Path::new(output).exists() && !options.force {
+        eprintln!(
+            "Error: {} already exists; rerun with --force to replace it",
+            output
+        );
+        process::exit(1);
+    }
+    if let Err(e) = fs::write(output, text) {
+        eprintln!("Error: failed to write '{}': {}", output, e);
+        process::exit(1);
+    }
+
+    println!("Wrote {} from {}", output, source);
+    for change in &migration.rules {
+        let state = if change.enabled {
+            "enabled"
+        } else {
+            "disabled"
+        };
+        println!(
+            "  {:<24} {} ({})",
+            change.rule,
+            state,
+            change.linters.join(", ")
+        );
+    }
+    if !migration.unmapped.is_empty() {
+        println!("No compass equivalent:");
+        for item in &migration.unmapped {
+            println!("  {}", item);
+        }
+    }
+}
+
+/// Proposes a `.compass.toml` for the repository in the working directory
+/// and, once confirmed, writes it, a baseline and a CI job.
+fn run_init(program: &str, options: Options, registry: &Registry) {
+    if !options.positional.is_empty() {
+        usage(program);
+    }
+    if Path::new(PROJECT_CONFIG_FILE).exists() && !options.force {
+        eprintln!(
+            "Error: {} already exists; rerun with --force to replace it",
+            PROJECT_CONFIG_FILE
+        );
+        process::exit(1);
+    }
+    let dir = Path::new(".");
+    let survey = init::inspect(dir).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    });
+    let text = survey
+        .propose(dir, options.preset)
+        .and_then(|proposal| proposal.to_toml().map_err(|e| e.to_string()))
+        .unwrap_or_else(|e| {
+            eprintln!("Error: {}", e);
+            process::exit(1);
+        });
+
+    let modules: Vec<String> = survey
+        .modules
+        .iter()
+        .map(|(module, root)| format!("{} ({})", module, root))
+        .collect();
+    println!(
+        "Modules:       {}",
+        if modules.is_empty() {
+            "none".to_string()
+        } else {
+            modules.join(", ")
+        }
+    );
+    println!(
+        "Go files:      {}, {} of them tests; {} of {} packages tested",
+        survey.go_files,
+        survey.test_files,
+        survey.tested.len(),
+        survey.packages.len()
+    );
+    println!(
+        "Generated:     {} with the standard header, {} without",
+        survey.generated,
+        survey.unmarked.len()
+    );
+    let mut linters: Vec<&str> = survey.golangci.iter().map(String::as_str).collect();
+    linters.extend(survey.other_linters.iter().map(String::as_str));
+    println!(
+        "Lint configs:  {}",
+        if linters.is_empty() {
+            "none".to_string()
+        } else {
+            linters.join(", ")
+        }
+    );
+    println!("CI:            {}", Ci::name(survey.ci));
+    println!("\nProposed {}:\n\n{}", PROJECT_CONFIG_FILE, text);
+
+    if !confirm(
+        &format!("Write {}?", PROJECT_CONFIG_FILE),
+        true,
+        options.yes,
+    ) {
+        return;
+    }
+    if let Err(e) = fs::write(PROJECT_CONFIG_FILE, &text) {
+        eprintln!("Error: failed to write '{}': {}", PROJECT_CONFIG_FILE, e);
+        process::exit(1);
+    }
+    println!("Wrote {}", PROJECT_CONFIG_FILE);
+
+    let question = format!(
+        "Record the current findings in {}, so only new ones fail?",
+        DEFAULT_BASELINE_PATH
+    );
+    let baseline = if Path::new(DEFAULT_BASELINE_PATH).exists() && !options.force {
+        println!("Keeping the existing {}", DEFAULT_BASELINE_PATH);
+        Some(DEFAULT_BASELINE_PATH)
+    } else if confirm(&question, true, options.yes) {
+        let cache = open_cache(&options);
+        let analysis = analyze_path(
+            ".",
+            None,
+            options.min_confidence,
+            options.vuln,
+            None,
+            false,
+            Mode::Full,
+            registry,
+            cache.as_ref(),
+            &[],
+        );
+        let mut baseline = Baseline::new();
+        baseline.record(".", &analysis.results);
+        if let Err(e) = baseline.save_to_file(DEFAULT_BASELINE_PATH) {
+            eprintln!(
+                "Error: failed to write baseline '{}': {}",
+                DEFAULT_BASELINE_PATH, e
+            );
+            process::exit(1);
+        }
+        println!(
+            "Recorded {} findings in {}",
+            analysis.results.len(),
+            DEFAULT_BASELINE_PATH
+        );
+        Some(DEFAULT_BASELINE_PATH)
+    } else {
+        None
+    };
+
+    let ci = loop {
+        let answer = ask(
+            &format!("Add a CI job? ({})", Ci::NAMES),
+            Ci::name(survey.ci),
+            options.yes,
+        );
+        match Ci::from_name(&answer) {
+            Some(ci) => break ci,
+            None => eprintln!("Expected one of: {}", Ci::NAMES),
+        }
+    };
+    let Some(ci) = ci else {
+        return;
+    };
+    let (path, job) = init::ci_job(dir, ci, baseline);
+    if Path::new(&path).exists() && !options.force {
+        eprintln!(
+            "Error: {} already exists; rerun with --force to replace it",
+            path
+        );
+        process::exit(1);
+    }
+    let written = Path::new(&path)
+        .parent()
+        .map_or(Ok(()), fs::create_dir_all)
+        .and_then(|_| fs::write(&path, job));
+    if let Err(e) = written {
+        eprintln!("Error: failed to write '{}': {}", path, e);
+        process::exit(1);
+    }
+    println!("Wrote {}", path);
+    if path == GITLAB_INCLUDE {
+        println!(
+            "Include it from {}:\n\ninclude:\n  - local: {}",
+            init::GITLAB_CI_FILE,
+            GITLAB_INCLUDE
+        );
+    }
+}
+
+/// Asks `question` on stderr and reads the answer from stdin. `default` is
+/// the answer with `--yes`, to an empty line, and at the end of input.
+fn ask(question: &str, default: &str, yes: bool) -> String {
+    eprint!("{} [{}] ", question, default);
+    if yes {
+        eprintln!("{}", default);
+        return default.to_string();
+    }
+    match read_answer() {
+        None => {
+            eprintln!();
+            default.to_string()
+        }
+        Some(answer) if answer.is_empty() => default.to_string(),
+        Some(answer) => answer,
+    }
+}
+
+/// A trimmed, lowercased line from stdin, or `None` at the end of input.
+fn read_answer() -> Option<String> {
+    let _ = io::stderr().flush();
+    let mut answer = String::new();
+    match io::stdin().read_line(&mut answer) {
+        Ok(0) | Err(_) => None,
+        Ok(_) => Some(answer.trim().to_lowercase()),
+    }
+}
+
+fn confirm(question: &str, default: bool, yes: bool) -> bool {
+    loop {
+        let answer = ask(question, if default { "Y/n" } else { "y/N" }, yes);
+        match answer.as_str() {
+            "y" | "yes" => return true,
+            "n" | "no" => return false,
+            "Y/n" | "y/N" => return default,
+            _ => eprintln!("Expected y or n"),
+        }
+    }
+}
+
+fn load_project(path: &str) -> EffectiveConfig {
+    EffectiveConfig::for_path(path).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    })
+}
+
+fn load_comparison(options: &Options) -> Option<Comparison> {
+    let report_path = options.compare_to.as_deref()?;
+    Some(Comparison::from_file(report_path).unwrap_or_else(|e| {
+        eprintln!("Error: failed to load report '{}': {}", report_path, e);
+        process::exit(1);
+    }))
+}
+
+/// The score report's summary of a `--compare-to` run.
+fn previous_run(options: &Options, comparison: &Comparison) -> serde_json::Value {
+    let mut summary = json!(comparison.delta());
+    summary["report"] = json!(options.compare_to);
+    summary
+}
+
+fn report_comparison(options: &Options, comparison: &Comparison) {
+    eprintln!(
+        "compass: compared with {}: {}",
+        options.compare_to.as_deref().unwrap_or_default(),
+        comparison.delta().summary()
+    );
+}
+
+fn load_baseline(options: &Options) -> Option<Baseline> {
+    let baseline_path = options.baseline.as_deref()?;
+    Some(Baseline::from_file(baseline_path).unwrap_or_else(|e| {
+        eprintln!("Error: failed to load baseline '{}': {}", baseline_path, e);
+        process::exit(1);
+    }))
+}
+
+/// Where `--fix` and `--fix-diff` put their result.
+enum FixOutput {
+    /// A unified diff on stdout.
+    Diff,
+    /// Back to the file.
+    Write,
+    /// The fixed source on stdout, for `--stdin`.
+    Stdout,
+}
+
+fn apply_fixes(
+    source_path: &str,
+    source_code: &str,
+    results: &[AnalysisResult],
+    output: FixOutput,
+    options: &Options,
+) {
+    let mut chooser = FixChooser::new(source_path, source_code);
+    let outcome = if options.interactive {
+        fix::resolve_fixes(
+            source_code,
+            results,
+            &options.fix_conflicts,
+            &mut |result, fix| chooser.choose(result, fix),
+        )
+    } else {
+        fix::resolve_fixes(
+            source_code,
+            results,
+            &options.fix_conflicts,
+            &mut |_, fix| Some(fix.clone()),
+        )
+    };
+    if chooser.quit {
+        eprintln!("Quit; {} is unchanged", source_path);
+        return;
+    }
+
+    for skipped in &outcome.skipped {
+        match outcome.conflicts.iter().find(|(fix, _)| fix == skipped) {
+            Some((_, (rule, line))) => eprintln!(
+                "Skipped fix for '{}' at line {}: overlaps the fix for '{}' at line {}",
+                skipped.0, skipped.1, rule, line
+            ),
+            None => eprintln!(
+                "Skipped fix for '{}' at line {}: its edits don't fit the file",
+                skipped.0, skipped.1
+            ),
+        }
+    }
+
+    match output {
+        FixOutput::Diff => {
+            print!(
+                "{}",
+                fix::unified_diff(source_path, source_code, &outcome.applied)
+            );
+            return;
+        }
+        FixOutput::Stdout => {
+            print!("{}", outcome.source);
+            return;
+        }
+        FixOutput::Write => {}
+    }
+
+    if outcome.applied_count > 0 {
+        if let Err(e) = fs::write(source_path, &outcome.source) {
+            eprintln!("Error: failed to write '{}': {}", source_path, e);
+            process::exit(1);
+        }
+    }
+    match outcome.declined.len() {
+        0 => println!(
+            "Applied {} fixes to {} ({} skipped)",
+            outcome.applied_count,
+            source_path,
+            outcome.skipped.len()
+        ),
+        declined => println!(
+            "Applied {} fixes to {} ({} skipped, {} declined)",
+            outcome.applied_count,
+            source_path,
+            outcome.skipped.len(),
+            declined
+        ),
+    }
+}
+
+/// The prompt of `--interactive`, which shows each fix as a diff and asks
+/// what to do with it, as `git add -p` does with hunks.
+struct FixChooser<'s> {
+    source_path: &'s str,
+    source_code: &'s str,
+    asked: usize,
+    /// Set by `a` and `d`: the answer to every later fix.
+    rest: Option<bool>,
+    /// Set by `q`: leave the file as it was.
+    quit: bool,
+}
+
+const FIX_CHOICES: &str = "y - apply this fix
+n - don't apply this fix
+e - edit the fixed lines, then apply them
+a - apply this fix and every later one
+d - don't apply this fix or any later one
+q - quit, leaving the file unchanged
+? - print this help";
+
+impl<'s> FixChooser<'s> {
+    fn new(source_path: &'s str, source_code: &'s str) -> Self {
+        FixChooser {
+            source_path,
+            source_code,
+            asked: 0,
+            rest: None,
+            quit: false,
+        }
+    }
+
+    fn choose(&mut self, result: &AnalysisResult, fix: &Fix) -> Option<Fix> {
+        if self.quit || self.rest == Some(false) {
+            return None;
+        }
+        if self.rest == Some(true) {
+            return Some(fix.clone());
+        }
+        self.asked += 1;
+        let mut edits = fix.edits.clone();
+        edits.sort_by_key(|edit| (edit.start_byte, edit.end_byte));
+        eprintln!(
+            "\n({}) {} at line {}: {}\nFix: {}",
+            self.asked, result.rule_name, result.line, result.message, fix.description
+        );
+        eprint!(
+            "{}",
+            fix::unified_diff(self.source_path, self.source_code, &edits)
+        );
+        loop {
+            eprint!("Apply this fix [y,n,e,a,d,q,?]? ");
+            let answer = read_answer();
+            match answer.as_deref() {
+                Some("y") => return Some(fix.clone()),
+                Some("n") => return None,
+                Some("a") => {
+                    self.rest = Some(true);
+                    return Some(fix.clone());
+                }
+                Some("d") => {
+                    self.rest = Some(false);
+                    return None;
+                }
+                Some("q") | None => {
+                    if answer.is_none() {
+                        eprintln!();
+                    }
+                    self.quit = true;
+                    return None;
+                }
+                Some("e") => match self.edit(&edits) {
+                    Ok(edited) => return Some(edited),
+                    Err(e) => eprintln!("Error: {}", e),
+                },
+                _ => eprintln!("{}", FIX_CHOICES),
+            }
+        }
+    }
+
+    /// Opens the lines `edits` touch, with the edits made, in `$VISUAL` or
+    /// `$EDITOR`, and returns a fix that replaces those lines with what
+    /// was saved.
+    fn edit(&self, edits: &[TextEdit]) -> Result<Fix, String> {
+        let source = self.source_code;
+        let (Some(first), Some(last)) = (edits.first(), edits.iter().map(|e| e.end_byte).max())
+        else {
+            return Err("the fix has no edits".to_string());
+        };
+        let start = source[..first.start_byte].rfind('\n').map_or(0, |i| i + 1);
+        let end = match last {
+            last if last > 0 && source.as_bytes()[last - 1] == b'\n' => last,
+            last => source[last..]
+                .find('\n')
+                .map_or(source.len(), |i| last + i + 1),
+        };
+        let shifted: Vec<TextEdit> = edits
+            .iter()
+            .map(|edit| TextEdit {
+                start_byte: edit.start_byte - start,
+                end_byte: edit.end_byte - start,
+                replacement: edit.replacement.clone(),
+            })
+            .collect();
+        let fixed = fix::apply_edits(&source[start..end], &shifted);
+
+        // The source's extension lets the editor pick its syntax.
+        let extension = Path::new(self.source_path)
+            .extension()
+            .map(|ext| format!(".{}", ext.to_string_lossy()))
+            .unwrap_or_default();
+        let path = std::env::temp_dir().join(format!("compass-fix-{}{}", process::id(), extension));
+        fs::write(&path, &fixed)
+            .map_err(|e| format!("failed to write {}: {}", path.display(), e))?;
+        let editor = std::env::var("VISUAL")
+            .or_else(|_| std::env::var("EDITOR"))
+            .unwrap_or_else(|_| "vi".to_string());
+        let status = process::Command::new("sh")
+            .arg("-c")
+            .arg(format!("{} \"$1\"", editor))
+            .arg("sh")
+            .arg(&path)
+            .status();
+        let edited = fs::read_to_string(&path);
+        let _ = fs::remove_file(&path);
+        match status {
+            Ok(status) if status.success() => {}
+            Ok(status) => return Err(format!("{} exited with {}", editor, status)),
+            Err(e) => return Err(format!("failed to run {}: {}", editor, e)),
+        }
+        let edited = edited.map_err(|e| format!("failed to read {}: {}", path.display(), e))?;
+        Ok(Fix {
+            description: "edited".to_string(),
+            edits: vec![TextEdit {
+                start_byte: start,
+                end_byte: end,
+                replacement: edited,
+            }],
+        })
+    }
+}
+
+fn run_baseline(program: &str, options: Options, registry: &Registry) {
+    if options.positional.first().map(String::as_str) != Some("generate")
+        || options.positional.len() < 2
+        || options.positional.len() > 3
+    {
+        usage(program);
+    }
+
+    let source_path = options.positional[1].clone();
+    let config_override = options.positional.get(2).cloned();
+    let output_path = options
+        .output
+        .clone()
+        .unwrap_or_else(|| DEFAULT_BASELINE_PATH.to_string());
+
+    // Merge into an existing baseline so files can be snapshotted one at a time.
+    let mut baseline = if Path::new(&output_path).exists() {
+        Baseline::from_file(&output_path).unwrap_or_else(|e| {
+            eprintln!("Error: failed to load baseline '{}': {}", output_path, e);
+            process::exit(1);
+        })
+    } else {
+        Baseline::new()
+    };
+
+    let cache = open_cache(&options);
+    let analysis = analyze_path(
+        &source_path,
+        config_override.as_deref(),
+        options.min_confidence,
+        options.vuln,
+        options.preset,
+        options.enable_experimental,
+        options.mode,
+        registry,
+        cache.as_ref(),
+        &[],
+    );
+    baseline.record(&source_path, &analysis.results);
+
+    if let Err(e) = baseline.save_to_file(&output_path) {
+        eprintln!("Error: failed to write baseline '{}': {}", output_path, e);
+        process::exit(1);
+    }
+
+    println!(
+        "Recorded {} findings for {} in {}",
+        analysis.results.len(),
+        source_path,
+        output_path
+    );
+}
+
+fn run_hook(program: &str, options: Options, registry: &Registry) {
+    let config_file = options.positional.get(1).cloned();
+    match options.positional.first().map(String::as_str) {
+        Some("install") if options.positional.len() <= 2 => {
+            match hook::install(Path::new("."), config_file.as_deref(), options.force) {
+                Ok(path) => println!("Installed pre-commit hook at {}", path.display()),
+                Err(e) => {
+                    eprintln!("Error: {}", e);
+                    process::exit(1);
+                }
+            }
+        }
+        Some("run") if options.positional.len() <= 2 => {
+            run_hook_check(options, config_file.as_deref(), registry)
+        }
+        _ => usage(program),
+    }
+}
+
+/// Analyzes the staged contents of every staged file. Fails the commit on
+/// errors unless `--fail-on` says otherwise.
+fn run_hook_check(mut options: Options, config_override: Option<&str>, registry: &Registry) {
+    let started = Instant::now();
+    let dir = Path::new(".");
+    let staged = hook::staged_files(dir).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        process::exit(1);
+    });
+    let staged: Vec<(String, SupportedLanguage)> = staged
+        .into_iter()
+        .filter_map(|path| {
+            let language = SupportedLanguage::from_path(&path)?;
+            let excluded = load_project(&path).is_excluded(&path);
+            (!excluded).then_some((path, language))
+        })
+        .collect();
+    let baseline = load_baseline(&options);
+    let cache = open_cache(&options);
+
+    options.fail_on.get_or_insert(Severity::Error);
+    let workspace = Workspace::discover(dir).unwrap_or_default();
+    let mut reporter = Reporter::new(&options, &workspace, json!({}), started);
+    parallel::for_each_ordered(
+        &staged,
+        options.jobs,
+        |(path, language)| {
+            let source_code = hook::staged_content(dir, path).unwrap_or_else(|e| {
+                eprintln!("Error: failed to read staged '{}': {}", path, e);
+                process::exit(1);
+            });
+            let mut analysis = analyze_source(
+                path,
+                *language,
+                source_code,
+                config_override,
+                options.min_confidence,
+                options.vuln,
+                options.preset,
+                options.enable_experimental,
+                options.mode,
+                registry,
+                cache.as_ref(),
+                &[],
+                None,
+            );
+            if let Some(baseline) = &baseline {
+                analysis.results = baseline.filter(path, analysis.results);
+            }
+            (path.clone(), analysis)
+        },
+        |(path, analysis)| reporter.file(path, analysis),
+    );
+    reporter.finish();
+}
+
+fn run_lsp(program: &str, options: Options, registry: Registry) {
+    if options.positional.len() > 1 {
+        usage(program);
+    }
+
+    let stdin = io::stdin();
+    let stdout = io::stdout();
+    let mut server = lsp::Server::new(options.positional.first().cloned(), registry);
+    match server.serve(stdin.lock(), stdout.lock()) {
+        Ok(status) => process::exit(status),
+        Err(e) => {
+            eprintln!("Error: language server stopped: {}", e);
+            process::exit(1);
+        }
+    }
+}
+
+fn run_serve(program: &str, options: Options, registry: Registry) {
+    if options.positional.len() > 1 {
+        usage(program);
+    }
+
+    let address = options.listen.as_deref().unwrap_or(serve::DEFAULT_ADDRESS);
+    let listener = TcpListener::bind(address).unwrap_or_else(|e| {
+        eprintln!("Error: failed to listen on {}: {}", address, e);
+        process::exit(1);
+    });
+    eprintln!("Serving on http://{} (Ctrl-C to stop)", address);
+    let mut server = serve::Server::new(options.positional.first().cloned(), registry);
+    if let Err(e) = server.serve(listener) {
+        eprintln!("Error: server stopped: {}", e);
+        process::exit(1);
+    }
+}
+
+struct FileAnalysis {
+    language: SupportedLanguage,
+    config_label: String,
+    analyzer: CodeAnalyzer,
+    source_code: String,
+    results: Vec<AnalysisResult>,
+    /// Whether a build asked for with `--build-tags` or `--platforms`
+    /// compiles the file; without them, always.
+    in_build: bool,
+    profile: FileProfile,
+}
+
+/// Prints the `--profile` report to stderr and writes the `--pprof` file,
+/// when asked for.
+fn report_profile<'a>(
+    options: &Options,
+    started: Instant,
+    files: impl IntoIterator<Item = (&'a str, &'a FileProfile)>,
+) {
+    if !options.profile && options.pprof.is_none() {
+        return;
+    }
+    let mut profile = Profile::new();
+    for (path, file) in files {
+        profile.record(path, file);
+    }
+    if options.profile {
+        eprint!("{}", profile.report(started.elapsed(), options.top));
+    }
+    if let Some(path) = &options.pprof {
+        if let Err(e) = fs::write(path, profile.to_pprof()) {
+            eprintln!("Error: failed to write '{}': {}", path, e);
+            process::exit(1);
+        }
+    }
+}
+
+/// The `--otlp-endpoint` trace of a run that began at `started`, when one
+/// was asked for.
+fn start_trace(options: &Options, started: Instant) -> Option<Trace> {
+    options.otlp_endpoint.as_ref()?;
+    Some(Trace::new(started))
+}
+
+/// Sends the trace to `--otlp-endpoint`. Failing to isn't an error of the
+/// run, so it only warns.
+fn export_trace(options: &Options, trace: Trace) {
+    let Some(endpoint) = &options.otlp_endpoint else {
+        return;
+    };
+    let request = trace.finish(Instant::now());
+    if let Err(e) = trace::export(endpoint, &request) {
+        eprintln!("Warning: failed to send the trace to '{}': {}", endpoint, e);
+    }
+}
+
+/// The metrics of a run for `--pushgateway` and `--statsd`, when either
+/// was asked for.
+fn start_metrics(options: &Options) -> Option<Metrics> {
+    (options.pushgateway.is_some() || options.statsd.is_some())
+        .then(|| Metrics::new(!options.no_cache))
+}
+
+/// Pushes the run's metrics. Failing to isn't an error of the run, so it
+/// only warns.
+fn push_metrics(options: &Options, metrics: &Metrics, duration: Duration) {
+    if let Some(url) = &options.pushgateway {
+        if let Err(e) = metrics::push_gateway(url, &metrics.to_prometheus(duration)) {
+            eprintln!("Warning: failed to push metrics to '{}': {}", url, e);
+        }
+    }
+    if let Some(address) = &options.statsd {
+        if let Err(e) = metrics::send_statsd(address, &metrics.to_statsd(duration)) {
+            eprintln!(
+                "Warning: failed to send metrics to statsd at '{}': {}",
+                address, e
+            );
+        }
+    }
+}
+
+fn open_cache(options: &Options) -> Option<Cache> {
+    (!options.no_cache).then(|| Cache::new(Cache::default_dir()))
+}
+
+fn analyze_path(
+    source_path: &str,
+    config_override: Option<&str>,
+    min_confidence: Option<Confidence>,
+    vuln: bool,
+    preset: Option<Preset>,
+    experimental: bool,
+    mode: Mode,
+    registry: &Registry,
+    cache: Option<&Cache>,
+    builds: &[BuildContext],
+) -> FileAnalysis {
+    if !Path::new(source_path).exists() {
+        eprintln!("Error: file '{}' does not exist", source_path);
+        process::exit(1);
+    }
+
+    let language = SupportedLanguage::from_path(source_path).unwrap_or_else(|| {
+        eprintln!(
+            "Error: unsupported file extension for '{}'. Supported extensions: {}",
+            source_path, SUPPORTED_EXTENSIONS
+        );
+        process::exit(1);
+    });
+
+    let source_code = fs::read_to_string(source_path).unwrap_or_else(|e| {
+        eprintln!("Error: failed to read '{}': {}", source_path, e);
+        process::exit(1);
+    });
+    analyze_source(
+        source_path,
+        language,
+        source_code,
+        config_override,
+        min_confidence,
+        vuln,
+        preset,
+        experimental,
+        mode,
+        registry,
+        cache,
+        builds,
+        None,
+    )
+}
+
+/// Analyzes the buffer on stdin as the contents of `source_path`, in the
+/// context of the package on disk around it.
+fn analyze_stdin(
+    source_path: &str,
+    config_override: Option<&str>,
+    min_confidence: Option<Confidence>,
+    vuln: bool,
+    preset: Option<Preset>,
+    experimental: bool,
+    mode: Mode,
+    registry: &Registry,
+    cache: Option<&Cache>,
+    builds: &[BuildContext],
+) -> FileAnalysis {
+    let language = SupportedLanguage::from_path(source_path).unwrap_or_else(|| {
+        eprintln!(
+            "Error: unsupported file extension for '{}'. Supported extensions: {}",
+            source_path, SUPPORTED_EXTENSIONS
+        );
+        process::exit(1);
+    });
+    let mut source_code = String::new();
+    if let Err(e) = io::stdin().read_to_string(&mut source_code) {
+        eprintln!("Error: failed to read stdin: {}", e);
+        process::exit(1);
+    }
+    analyze_source(
+        source_path,
+        language,
+        source_code,
+        config_override,
+        min_confidence,
+        vuln,
+        preset,
+        experimental,
+        mode,
+        registry,
+        cache,
+        builds,
+        None,
+    )
+}
+
+/// Analyzes `source_code` as the contents of `source_path`, which need not
+/// match what is on disk. `min_confidence` overrides the configs' own, and
+/// with `mode` syntax the package isn't loaded.
+fn analyze_source(
+    source_path: &str,
+    language: SupportedLanguage,
+    source_code: String,
+    config_override: Option<&str>,
+    min_confidence: Option<Confidence>,
+    vuln: bool,
+    preset: Option<Preset>,
+    experimental: bool,
+    mode: Mode,
+    registry: &Registry,
+    cache: Option<&Cache>,
+    builds: &[BuildContext],
+    listed: Option<&ListedPackage>,
+) -> FileAnalysis {
+    let started = Instant::now();
+    let (mut config, mut config_label, project) =
+        load_config_for(source_path, language, config_override, preset, experimental);
+    if let Some(confidence) = min_confidence {
+        config.min_confidence = Some(confidence.as_str().to_string());
+    }
+    if vuln {
+        enable_vuln_rules(&mut config);
+    }
+    mode.apply(&mut config, registry);
+    if let Some(nearest) = project.files.last() {
+        config_label = format!("{} + {}", config_label, nearest.display());
+    }
+
+    let mut analyzer = config.to_analyzer();
+    analyzer.set_registry(registry.clone());
+    if !analyzer.has_rules() {
+        eprintln!(
+            "Error: config '{}' contains no enabled rules for language '{}'",
+            config_label,
+            language.config_key()
+        );
+        process::exit(1);
+    }
+
+    // Excluded files are still accepted so scripts can pass any path, but
+    // nothing is read or parsed for them.
+    let excluded = project.is_excluded(source_path);
+    let path = Path::new(source_path);
+    let builds: Vec<Option<&BuildContext>> = if builds.is_empty() {
+        vec![None]
+    } else {
+        builds
+            .iter()
+            .filter(|build| build.includes(path, &source_code))
+            .map(Some)
+            .collect()
+    };
+    let in_build = !builds.is_empty();
+
+    let mut profile = FileProfile {
+        started: Some(started),
+        ..FileProfile::default()
+    };
+    let mut runs = Vec::new();
+    for build in builds.into_iter().filter(|_| !excluded) {
+        let loading = Instant::now();
+        let package = (mode.loads_package() && analyzer.reads_package()).then(|| {
+            let package = match listed {
+                Some(listed) => listed.package(path),
+                None => Package::load(source_path),
+            };
+            let mut package = package.unwrap_or_else(|e| {
+                eprintln!(
+                    "Error: failed to read the package of '{}': {}",
+                    source_path, e
+                );
+                process::exit(1);
+            });
+            if let Some(build) = build {
+                package.restrict_to(build);
+            }
+            package
+        });
+        if package.is_some() {
+            profile
+                .steps
+                .push(("load package".to_string(), loading, loading.elapsed()));
+        }
+        // The cache key covers the package, not the rest of the module.
+        let cached = cache
+            .filter(|_| !analyzer.reads_call_graph())
+            .and_then(|cache| {
+                let key = Cache::key(&config, language, &source_code, package.as_ref()).ok()?;
+                Some((cache, key))
+            });
+
+        let results = if let Some(results) = cached.as_ref().and_then(|(cache, key)| cache.get(key))
+        {
+            profile.cached = true;
+            results
+        } else {
+            let analyzing = Instant::now();
+            let (results, timings) = analyzer
+                .analyze_timed(
+                    &source_code,
+                    &language.tree_sitter_language(),
+                    package.as_ref(),
+                )
+                .unwrap_or_else(|e| {
+                    eprintln!("Error: analysis failed: {}", e);
+                    process::exit(1);
+                });
+            if let Some((cache, key)) = &cached {
+                // The cache is an optimisation; failing to write it isn't an error.
+                let _ = cache.put(key, &results);
+            }
+            // The file is parsed before the rules run, one after another.
+            let in_rules: Duration = timings.iter().map(|(_, time)| *time).sum();
+            let parsed = analyzing.elapsed().saturating_sub(in_rules);
+            profile.steps.push(("parse".to_string(), analyzing, parsed));
+            let mut at = analyzing + parsed;
+            for (rule, time) in &timings {
+                profile.steps.push((format!("rule {}", rule), at, *time));
+                at += *time;
+            }
+            profile.rules.extend(timings);
+            results
+        };
+        runs.push((build, results));
+    }
+    let results = match runs.first() {
+        Some((Some(_), _)) => postprocess::merge_platforms(
+            runs.into_iter()
+                .filter_map(|(build, results)| Some((build?.platform.to_string(), results)))
+                .collect(),
+        ),
+        _ => runs.pop().map(|(_, results)| results).unwrap_or_default(),
+    };
+
+    profile.elapsed = started.elapsed();
+    FileAnalysis {
+        language,
+        config_label,
+        analyzer,
+        source_code,
+        results,
+        in_build,
+        profile,
+    }
+}
+
+/// The rule config for `source_path` with the `.compass.toml` files that
+/// apply to it, its label, and those files merged.
+/// Loads the rule config for `source_path` with the `.compass.toml` files
+/// that apply to it; `preset` replaces the one they choose.
+fn load_config_for(
+    source_path: &str,
+    language: SupportedLanguage,
+    config_override: Option<&str>,
+    preset: Option<Preset>,
+    experimental: bool,
+) -> (AnalyzerConfig, String, EffectiveConfig) {
+    let (mut config, config_label) = AnalyzerConfig::load(config_override, language)
+        .unwrap_or_else(|e| {
+            let label = config_override.unwrap_or("built-in");
+            eprintln!("Error: failed to load config '{}': {}", label, e);
+            process::exit(1);
+        });
+    let mut project = load_project(source_path);
+    if let Some(preset) = preset {
+        project.merged.preset = Some(preset.as_str().to_string());
+    }
+    if experimental {
+        project.merged.experimental = Some(true);
+    }
+    if let Err(e) = project.apply(&mut config) {
+        let nearest = project
+            .files
+            .last()
+            .map_or(config_label.clone(), |file| file.display().to_string());
+        eprintln!("Error: failed to load config '{}': {}", nearest, e);
+        process::exit(1);
+    }
+    warn_deprecations(&config);
+    (config, config_label, project)
+}
+
+/// Prints each deprecated rule a config enables once per run, however many
+/// files share the config.
+fn warn_deprecations(config: &AnalyzerConfig) {
+    static WARNED: OnceLock<Mutex<BTreeSet<String>>> = OnceLock::new();
+    let mut warned = WARNED.get_or_init(Default::default).lock().unwrap();
+    for warning in config.deprecations() {
+        if warned.insert(warning.clone()) {
+            eprintln!("compass: warning: {}", warning);
+        }
+    }
+}
+
+fn print_json(output: &serde_json::Value) {
+    match to_string_pretty(output) {
+        Ok(json) => println!("{}", json),
+        Err(e) => {
+            eprintln!("Error: failed to format analysis result: {}", e);
+            process::exit(1);
+        }
+    }
+}
+
+fn usage(program: &str) -> ! {
+    eprintln!(
+        "Usage: {} [--format score|json|sarif|github|html|checkstyle|junit|gitlab-codequality|bitbucket|rdjson|rdjsonl|text] [--no-color] [--context-lines N] [--baseline FILE] [--compare-to REPORT] [--fail-on error|warning|any] [--min-confidence high|medium|low] [--preset minimal|standard|strict] [--enable-experimental] [--mode full|syntax] [--group-by file|rule|owner] [--owner TEAM] [--since DATE] [--author NAME] [--max-issues-per-rule N] [--max-same-issues N] [--build-tags TAGS] [--platforms GOOS/GOARCH,...] [--no-cache] [--jobs N] [--shard K/N] [--profile] [--pprof FILE] [--otlp-endpoint URL] [--pushgateway URL] [--statsd HOST:PORT] [--emit-metadata FILE] [--fix | --fix-diff] [--fix-conflicts first|priority|skip] [--fix-priority RULES] [--interactive] [--vuln] <source-file|dir|dir/...> [config-file]",
+        program
+    );
+    eprintln!(
+        "       {} check --stdin --stdin-filename PATH [--format score|json|sarif|github|html|checkstyle|junit|gitlab-codequality|bitbucket|rdjson|rdjsonl|text] [--no-color] [--context-lines N] [--preset minimal|standard|strict] [--enable-experimental] [--mode full|syntax] [--build-tags TAGS] [--platforms GOOS/GOARCH,...] [--fix | --fix-diff] [--fix-conflicts first|priority|skip] [--fix-priority RULES] [config-file]",
+        program
+    );
+    eprintln!(
+        "       {} check --package-list-from-file FILE [--format score|json|sarif|github|html|checkstyle|junit|gitlab-codequality|bitbucket|rdjson|rdjsonl|text] [--no-color] [--baseline FILE] [--min-confidence high|medium|low] [--preset minimal|standard|strict] [--enable-experimental] [--build-tags TAGS] [--platforms GOOS/GOARCH,...] [--jobs N] [--shard K/N] [config-file]",
+        program
+    );
+    eprintln!(
+        "       {} baseline generate [--output FILE] <source-file> [config-file]",
+        program
+    );
+    eprintln!(
+        "       {} diff --base <git-ref> [--jobs N] [--format score|json|sarif|github|html|checkstyle|junit|gitlab-codequality|bitbucket|rdjson|rdjsonl|text] [--no-color] [--context-lines N] [--fail-on error|warning|any] [--min-confidence high|medium|low] [--preset minimal|standard|strict] [--enable-experimental] [--mode full|syntax] [--group-by file|rule|owner] [--owner TEAM] [--max-issues-per-rule N] [--max-same-issues N] [--build-tags TAGS] [--platforms GOOS/GOARCH,...] [--emit-metadata FILE] [config-file]",
+        program
+    );
+    eprintln!(
+        "       {} apidiff --base <git-ref|version> [--format score|json] [path]",
+        program
+    );
+    eprintln!(
+        "       {} audit [--format score|json] [--jobs N] [path] [config-file]",
+        program
+    );
+    eprintln!("       {} lsp [config-file]", program);
+    eprintln!("       {} serve [--listen ADDR] [config-file]", program);
+    eprintln!("       {} config show [--path DIR]", program);
+    eprintln!(
+        "       {} config lint [--format score|json] [--path DIR] [config-file]",
+        program
+    );
+    eprintln!(
+        "       {} preset list | diff <preset> <preset> [--format score|json] [config-file]",
+        program
+    );
+    eprintln!("       {} cache clean", program);
+    eprintln!("       {} hook install [--force] [config-file]", program);
+    eprintln!(
+        "       {} hook run [--format score|json|sarif|github|html|checkstyle|junit|gitlab-codequality|bitbucket|rdjson|rdjsonl|text] [--no-color] [--context-lines N] [--fail-on error|warning|any] [--min-confidence high|medium|low] [--mode full|syntax] [config-file]",
+        program
+    );
+    eprintln!("       {} rules [--format markdown] [config-file]", program);
+    eprintln!("       {} explain <rule-id> [config-file]", program);
+    eprintln!("       {} metrics [--top N] [--jobs N] [path]", program);
+    eprintln!(
+        "       {} dupes [--min-tokens N] [--format score|json] [--jobs N] [path]",
+        program
+    );
+    eprintln!("       {} callgraph [--format json|dot] [path]", program);
+    eprintln!(
+        "       {} policy [--format score|json] <assertion> [path]",
+        program
+    );
+    eprintln!(
+        "       {} merge [--output FILE] [--fail-on error|warning|any] <report.json>...",
+        program
+    );
+    eprintln!(
+        "       {} scan [--repos FILE] [--path DIR] [--format score|json] [--output FILE] [--fail-on error|warning|any] [--min-confidence high|medium|low] [--preset minimal|standard|strict] [--enable-experimental] [--jobs N] [repo...] [config-file]",
+        program
+    );
+    eprintln!(
+        "       {} deps [--format score|json|sarif|github|html|checkstyle|junit|gitlab-codequality|bitbucket|rdjson|rdjsonl|text] [--no-color] [--context-lines N] [--fail-on error|warning|any] [--min-confidence high|medium|low] [--preset minimal|standard|strict] [--enable-experimental] [--baseline FILE] [--vuln] [--emit-metadata FILE] [path] [config-file]",
+        program
+    );
+    eprintln!(
+        "       {} score [--history DIR|URL] [--max-drop N] [--no-record] [--jobs N] [path] [config-file]",
+        program
+    );
+    eprintln!(
+        "       {} init [--yes] [--force] [--preset minimal|standard|strict]",
+        program
+    );
+    eprintln!(
+        "       {} migrate --from golangci-lint [--output FILE] [--force] [golangci-config]",
+        program
+    );
+    eprintln!(
+        "       {} watch [--format score|json] [path] [config-file]",
+        program
+    );
+    eprintln!("Example: {} src/main.rs", program);
+    eprintln!("         {} check ./pkg/... --since 2.weeks", program);
+    eprintln!("         {} src/main.rs my-preferences.toml", program);
+    eprintln!(
+        "         {} --format sarif src/main.rs > compass.sarif",
+        program
+    );
+    eprintln!("\nSupported extensions: {}", SUPPORTED_EXTENSIONS);
+    process::exit(1);
 }
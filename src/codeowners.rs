@@ -0,0 +1,162 @@
+//! Who owns a file, from the repository's `CODEOWNERS`.
+//!
+//! GitHub reads the first of `.github/CODEOWNERS`, `CODEOWNERS` and
+//! `docs/CODEOWNERS` at the repository root, and so does compass. Each line
+//! is a gitignore-style pattern followed by owners, and the last line that
+//! matches a path decides its owners; a line without owners leaves the
+//! path unowned. Findings carry the owners of their file, so `--owner` and
+//! `--group-by owner` can split them into per-team queues.
+
+use globset::{GlobBuilder, GlobMatcher};
+use std::collections::HashMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// Where `CODEOWNERS` is looked for, relative to the repository root, in
+/// the order GitHub looks.
+pub const CODEOWNERS_FILES: &[&str] = &[".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"];
+
+#[derive(Debug, Clone)]
+pub struct CodeOwners {
+    /// The directory patterns are relative to.
+    pub root: PathBuf,
+    entries: Vec<Entry>,
+}
+
+#[derive(Debug, Clone)]
+struct Entry {
+    glob: GlobMatcher,
+    /// Whether the pattern ends in `/`, so only matches directories.
+    directory: bool,
+    owners: Vec<String>,
+}
+
+impl CodeOwners {
+    /// Parses `content` as the `CODEOWNERS` of the repository at `root`.
+    /// Lines whose pattern isn't valid are skipped, as GitHub skips them.
+    pub fn parse(root: &Path, content: &str) -> CodeOwners {
+        let entries = content
+            .lines()
+            .filter_map(|line| {
+                let line = line.split_once(" #").map_or(line, |(line, _)| line).trim();
+                if line.is_empty() || line.starts_with('#') {
+                    return None;
+                }
+                let mut fields = line.split_whitespace();
+                let pattern = fields.next()?;
+                Entry::compile(pattern, fields.map(str::to_string).collect())
+            })
+            .collect();
+        CodeOwners {
+            root: root.to_path_buf(),
+            entries,
+        }
+    }
+
+    /// The `CODEOWNERS` of the repository at `root`, if it has one.
+    pub fn load(root: &Path) -> Option<CodeOwners> {
+        CODEOWNERS_FILES.iter().find_map(|name| {
+            let content = fs::read_to_string(root.join(name)).ok()?;
+            Some(CodeOwners::parse(root, &content))
+        })
+    }
+
+    /// The owners of `path`, which is relative to the root or absolute.
+    /// Empty when no line matches, or the one that does names nobody.
+    pub fn owners(&self, path: &Path) -> &[String] {
+        let relative = path.strip_prefix(&self.root).unwrap_or(path);
+        self.entries
+            .iter()
+            .rev()
+            .find(|entry| entry.matches(relative))
+            .map_or(&[], |entry| &entry.owners)
+    }
+}
+
+impl Entry {
+    fn compile(pattern: &str, owners: Vec<String>) -> Option<Entry> {
+        let directory = pattern.ends_with('/');
+        let trimmed = pattern.trim_end_matches('/');
+        // As in gitignore, a `/` anywhere but the end anchors the pattern
+        // at the root; without one it matches at any depth.
+        let anchored = trimmed.contains('/');
+        let trimmed = trimmed.trim_start_matches('/');
+        let glob = match (trimmed, anchored) {
+            ("", _) | ("*", true) => "**".to_string(),
+            (pattern, true) => pattern.to_string(),
+            (pattern, false) => format!("**/{}", pattern),
+        };
+        let glob = GlobBuilder::new(&glob)
+            .literal_separator(true)
+            .build()
+            .ok()?
+            .compile_matcher();
+        Some(Entry {
+            glob,
+            directory,
+            owners,
+        })
+    }
+
+    /// Whether the pattern matches `path` or a directory containing it,
+    /// since a directory's owners own everything below it.
+    fn matches(&self, path: &Path) -> bool {
+        path.ancestors()
+            .filter(|candidate| !candidate.as_os_str().is_empty())
+            .filter(|candidate| !self.directory || *candidate != path)
+            .any(|candidate| self.glob.is_match(candidate))
+    }
+}
+
+/// The `CODEOWNERS` of each repository files are analyzed in, read once.
+#[derive(Debug, Default)]
+pub struct Owners {
+    repositories: HashMap<PathBuf, Option<CodeOwners>>,
+}
+
+impl Owners {
+    /// The owners of the file at `path`, from the `CODEOWNERS` of its
+    /// repository.
+    pub fn of(&mut self, path: &str) -> Vec<String> {
+        let Ok(path) = Path::new(path).canonicalize() else {
+            return Vec::new();
+        };
+        // The repository is the nearest directory above with `.git`.
+        let Some(root) = path.ancestors().find(|dir| dir.join(".git").exists()) else {
+            return Vec::new();
+        };
+        let codeowners = self
+            .repositories
+            .entry(root.to_path_buf())
+            .or_insert_with(|| CodeOwners::load(root));
+        match codeowners {
+            Some(codeowners) => codeowners.owners(&path).to_vec(),
+            None => Vec::new(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_last_matching_line_wins() {
+        let codeowners = CodeOwners::parse(
+            Path::new("/repo"),
+            "# Fallback\n*       @acme/core\n*.md    @acme/docs\n/cmd/   @acme/cli # tools\ninternal/db/** @acme/storage @alice\napps/*/gen.go\nvendor @acme/deps\n",
+        );
+        let owners = |path: &str| codeowners.owners(Path::new(path)).join(" ");
+        assert_eq!(owners("main.go"), "@acme/core");
+        assert_eq!(owners("/repo/docs/guide.md"), "@acme/docs");
+        assert_eq!(owners("cmd/tool/main.go"), "@acme/cli");
+        // `cmd/` is anchored, so a nested cmd isn't the CLI team's
+        assert_eq!(owners("pkg/cmd/run.go"), "@acme/core");
+        assert_eq!(owners("internal/db/pool/pool.go"), "@acme/storage @alice");
+        // An entry without owners leaves its files unowned
+        assert_eq!(owners("apps/web/gen.go"), "");
+        assert_eq!(owners("apps/web/main.go"), "@acme/core");
+        // An unanchored name matches a directory at any depth
+        assert_eq!(owners("third_party/vendor/lib.go"), "@acme/deps");
+    }
+}
@@ -0,0 +1,199 @@
+//! Comparing a run with an earlier one.
+//!
+//! `--compare-to previous.json` reads a `--format json` report and labels
+//! each finding of the run `new` or `unchanged` by its fingerprint, the
+//! same one baselines use, so findings that only moved up or down their
+//! file keep their label. Findings of the earlier report that are gone are
+//! `fixed`. Unlike a baseline, nothing is hidden: the report shows every
+//! finding with its label, and only `--fail-on` looks at the new ones.
+//!
+//! The fingerprint covers the path as it was given to compass, so both
+//! runs should start from the same directory. A finding only counts as
+//! fixed when its file was analyzed again or no longer exists, so a run
+//! over part of the tree doesn't report the rest as fixed.
+
+use crate::analyzer::AnalysisResult;
+use crate::fingerprint::fingerprints;
+use crate::format::json::{Finding, Report, SCHEMA_VERSION};
+use serde::{Deserialize, Serialize};
+use std::collections::HashSet;
+use std::fs;
+use std::path::Path;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Status {
+    /// Not in the earlier report.
+    New,
+    /// In both reports.
+    Unchanged,
+    /// Only in the earlier report.
+    Fixed,
+}
+
+impl Status {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Status::New => "new",
+            Status::Unchanged => "unchanged",
+            Status::Fixed => "fixed",
+        }
+    }
+}
+
+/// How many findings of each status a comparison found.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize)]
+pub struct Delta {
+    pub new: usize,
+    pub unchanged: usize,
+    pub fixed: usize,
+}
+
+/// The earlier report, and what the run has matched against it so far.
+pub struct Comparison {
+    previous: Vec<Finding>,
+    known: HashSet<String>,
+    seen: HashSet<String>,
+    files: HashSet<String>,
+    new: usize,
+    unchanged: usize,
+}
+
+impl Comparison {
+    pub fn new(previous: Report) -> Self {
+        Comparison {
+            known: previous
+                .findings
+                .iter()
+                .map(|finding| finding.fingerprint.clone())
+                .collect(),
+            previous: previous.findings,
+            seen: HashSet::new(),
+            files: HashSet::new(),
+            new: 0,
+            unchanged: 0,
+        }
+    }
+
+    pub fn from_file<P: AsRef<Path>>(path: P) -> Result<Self, Box<dyn std::error::Error>> {
+        let content = fs::read_to_string(path)?;
+        let report: Report = serde_json::from_str(&content)
+            .map_err(|e| format!("not a `--format json` report: {}", e))?;
+        if report.schema_version != SCHEMA_VERSION {
+            return Err(format!(
+                "unsupported report schema version {} (expected {})",
+                report.schema_version, SCHEMA_VERSION
+            )
+            .into());
+        }
+        Ok(Comparison::new(report))
+    }
+
+    /// Sets the status of each of `file`'s results.
+    pub fn label(&mut self, file: &str, results: &mut [AnalysisResult]) {
+        self.files.insert(file.to_string());
+        let prints = fingerprints(file, results);
+        for (result, fingerprint) in results.iter_mut().zip(prints) {
+            let status = if self.known.contains(&fingerprint) {
+                self.unchanged += 1;
+                Status::Unchanged
+            } else {
+                self.new += 1;
+                Status::New
+            };
+            result.status = Some(status);
+            self.seen.insert(fingerprint);
+        }
+    }
+
+    /// The earlier findings the run didn't find again, in the order of the
+    /// earlier report.
+    pub fn fixed(&self) -> Vec<Finding> {
+        self.previous
+            .iter()
+            .filter(|finding| !self.seen.contains(&finding.fingerprint))
+            .filter(|finding| {
+                self.files.contains(&finding.file) || !Path::new(&finding.file).exists()
+            })
+            .cloned()
+            .map(|mut finding| {
+                finding.status = Some(Status::Fixed.as_str().to_string());
+                finding
+            })
+            .collect()
+    }
+
+    pub fn delta(&self) -> Delta {
+        Delta {
+            new: self.new,
+            unchanged: self.unchanged,
+            fixed: self.fixed().len(),
+        }
+    }
+}
+
+impl Delta {
+    /// One line for the end of a run, such as `2 new, 14 unchanged, 3 fixed`.
+    pub fn summary(&self) -> String {
+        format!(
+            "{} new, {} unchanged, {} fixed",
+            self.new, self.unchanged, self.fixed
+        )
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analyzer::Severity;
+    use crate::format::{json::to_report, FileFindings};
+
+    fn result(line: usize, text: &str) -> AnalysisResult {
+        AnalysisResult {
+            rule_name: "panic_usage".to_string(),
+            severity: Severity::Warning,
+            message: "Use of panic()".to_string(),
+            line,
+            text: text.to_string(),
+            symbol: Some("load".to_string()),
+            ..Default::default()
+        }
+    }
+
+    fn report(path: &str, results: Vec<AnalysisResult>) -> Report {
+        to_report(&[FileFindings {
+            path: path.to_string(),
+            module: None,
+            owners: Vec::new(),
+            results,
+        }])
+    }
+
+    #[test]
+    fn test_comparison_labels_findings_across_runs() {
+        let previous = report(
+            "main.go",
+            vec![result(10, "panic(\"a\")"), result(20, "panic(\"b\")")],
+        );
+        let mut comparison = Comparison::new(previous);
+
+        // "a" moved down two lines, "b" is fixed and "c" is new
+        let mut results = vec![result(12, "panic(\"a\")"), result(30, "panic(\"c\")")];
+        comparison.label("main.go", &mut results);
+
+        assert_eq!(results[0].status, Some(Status::Unchanged));
+        assert_eq!(results[1].status, Some(Status::New));
+        let fixed = comparison.fixed();
+        assert_eq!(fixed.len(), 1);
+        assert_eq!(fixed[0].text, "panic(\"b\")");
+        assert_eq!(fixed[0].status.as_deref(), Some("fixed"));
+        assert_eq!(
+            comparison.delta(),
+            Delta {
+                new: 1,
+                unchanged: 1,
+                fixed: 1
+            }
+        );
+    }
+}
@@ -0,0 +1,278 @@
+//! Per-function cyclomatic and cognitive complexity.
+//!
+//! Both metrics work from node kinds shared by the supported grammars, so
+//! they apply to every language. Closures count towards the function they
+//! appear in; nested named functions are measured on their own.
+//!
+//! Cyclomatic complexity is one plus the number of decision points:
+//! conditionals, loops, non-default cases, catch clauses and `&&`/`||`
+//! operators. Cognitive complexity follows the SonarSource definition: each
+//! break in linear flow costs one, plus one per level of nesting for
+//! conditionals, loops, switches and catches; `else` branches and each run of
+//! like boolean operators cost one, and closures deepen the nesting.
+
+use crate::analyzer::declaration_name_node;
+use crate::checks::node_text;
+use tree_sitter::Node;
+
+#[derive(Debug, Clone)]
+pub struct FunctionComplexity<'t> {
+    pub node: Node<'t>,
+    pub name: String,
+    pub cyclomatic: usize,
+    pub cognitive: usize,
+}
+
+impl<'t> FunctionComplexity<'t> {
+    /// Where findings about the function are reported: its name, or the
+    /// whole function when it has none.
+    pub fn anchor(&self) -> Node<'t> {
+        declaration_name_node(self.node).unwrap_or(self.node)
+    }
+}
+
+const FUNCTION_KINDS: &[&str] = &[
+    "function_declaration",
+    "method_declaration",
+    "constructor_declaration",
+    "function_item",
+    "function_definition",
+    "method_definition",
+];
+
+const CLOSURE_KINDS: &[&str] = &[
+    "func_literal",
+    "closure_expression",
+    "arrow_function",
+    "function_expression",
+    "lambda_expression",
+    "lambda_literal",
+];
+
+const CONDITIONAL_KINDS: &[&str] = &["if_statement", "if_expression"];
+
+const LOOP_KINDS: &[&str] = &[
+    "for_statement",
+    "for_expression",
+    "for_in_statement",
+    "enhanced_for_statement",
+    "for_range_loop",
+    "while_statement",
+    "while_expression",
+    "do_statement",
+    "loop_expression",
+    "repeat_while_statement",
+];
+
+const SWITCH_KINDS: &[&str] = &[
+    "expression_switch_statement",
+    "type_switch_statement",
+    "select_statement",
+    "switch_statement",
+    "switch_expression",
+    "match_expression",
+];
+
+const CASE_KINDS: &[&str] = &[
+    "expression_case",
+    "type_case",
+    "communication_case",
+    "switch_case",
+    "switch_label",
+    "switch_entry",
+    "case_statement",
+    "match_arm",
+];
+
+const TERNARY_KINDS: &[&str] = &["conditional_expression", "ternary_expression"];
+
+const CATCH_KINDS: &[&str] = &["catch_clause"];
+
+/// Every function in the tree, in document order.
+pub fn functions<'t>(root: Node<'t>, source_code: &str) -> Vec<FunctionComplexity<'t>> {
+    let mut found = Vec::new();
+    collect(root, false, source_code, &mut found);
+    found
+}
+
+fn collect<'t>(
+    node: Node<'t>,
+    in_function: bool,
+    source_code: &str,
+    found: &mut Vec<FunctionComplexity<'t>>,
+) {
+    let kind = node.kind();
+    let is_unit = FUNCTION_KINDS.contains(&kind) || (!in_function && CLOSURE_KINDS.contains(&kind));
+    if is_unit {
+        found.push(FunctionComplexity {
+            node,
+            name: function_name(node, source_code),
+            cyclomatic: cyclomatic(node, source_code),
+            cognitive: cognitive(node, source_code),
+        });
+    }
+
+    let mut cursor = node.walk();
+    for child in node.children(&mut cursor) {
+        collect(child, in_function || is_unit, source_code, found);
+    }
+}
+
+fn function_name(node: Node, source_code: &str) -> String {
+    if let Some(name) = declaration_name_node(node) {
+        return node_text(name, source_code).to_string();
+    }
+    // `const handler = () => { ... }` and similar take the variable's name.
+    node.parent()
+        .and_then(|parent| parent.child_by_field_name("name"))
+        .map(|name| node_text(name, source_code).to_string())
+        .unwrap_or_else(|| "<anonymous>".to_string())
+}
+
+/// Children of `node` that belong to the same function.
+fn own_children(node: Node) -> Vec<Node> {
+    let mut cursor = node.walk();
+    node.children(&mut cursor)
+        .filter(|child| !FUNCTION_KINDS.contains(&child.kind()))
+        .collect()
+}
+
+pub fn cyclomatic(function: Node, source_code: &str) -> usize {
+    let mut stack = own_children(function);
+    let mut complexity = 1;
+    while let Some(node) = stack.pop() {
+        let kind = node.kind();
+        let decision = CONDITIONAL_KINDS.contains(&kind)
+            || LOOP_KINDS.contains(&kind)
+            || TERNARY_KINDS.contains(&kind)
+            || CATCH_KINDS.contains(&kind)
+            || (CASE_KINDS.contains(&kind) && !is_default_case(node, source_code))
+            || logical_operator(node, source_code).is_some();
+        if decision {
+            complexity += 1;
+        }
+        stack.extend(own_children(node));
+    }
+    complexity
+}
+
+pub fn cognitive(function: Node, source_code: &str) -> usize {
+    let mut score = 0;
+    for child in own_children(function) {
+        cognitive_walk(child, 0, source_code, &mut score);
+    }
+    score
+}
+
+fn cognitive_walk(node: Node, nesting: usize, source_code: &str, score: &mut usize) {
+    let kind = node.kind();
+
+    if CONDITIONAL_KINDS.contains(&kind) {
+        *score += 1 + nesting;
+        conditional_walk(node, nesting, source_code, score);
+        return;
+    }
+
+    let nests = LOOP_KINDS.contains(&kind)
+        || SWITCH_KINDS.contains(&kind)
+        || TERNARY_KINDS.contains(&kind)
+        || CATCH_KINDS.contains(&kind);
+    if nests {
+        *score += 1 + nesting;
+    }
+
+    if let Some(operator) = logical_operator(node, source_code) {
+        // Only the first operator of a run like `a && b && c` counts.
+        let continues_run = node
+            .parent()
+            .and_then(|parent| logical_operator(parent, source_code))
+            == Some(operator);
+        if !continues_run {
+            *score += 1;
+        }
+    }
+
+    if is_labeled_jump(node) {
+        *score += 1;
+    }
+
+    let inner = if nests || CLOSURE_KINDS.contains(&kind) {
+        nesting + 1
+    } else {
+        nesting
+    };
+    for child in own_children(node) {
+        cognitive_walk(child, inner, source_code, score);
+    }
+}
+
+/// Walks an `if` whose own increment is already counted. `else if` chains
+/// stay at the same nesting level and cost one each, as does a final `else`.
+fn conditional_walk(node: Node, nesting: usize, source_code: &str, score: &mut usize) {
+    let alternative = node.child_by_field_name("alternative");
+    for child in own_children(node) {
+        if Some(child) != alternative {
+            cognitive_walk(child, nesting + 1, source_code, score);
+            continue;
+        }
+
+        *score += 1;
+        let branch = unwrap_else(child);
+        if CONDITIONAL_KINDS.contains(&branch.kind()) {
+            conditional_walk(branch, nesting, source_code, score);
+        } else {
+            cognitive_walk(branch, nesting + 1, source_code, score);
+        }
+    }
+}
+
+/// The statement or block of an `else`, looking through `else_clause`
+/// wrappers used by some grammars.
+fn unwrap_else(node: Node) -> Node {
+    if node.kind() == "else_clause" {
+        if let Some(inner) = node.named_child(0) {
+            return inner;
+        }
+    }
+    node
+}
+
+fn logical_operator<'s>(node: Node, source_code: &'s str) -> Option<&'s str> {
+    match node.kind() {
+        "binary_expression" => {}
+        "conjunction_expression" => return Some("&&"),
+        "disjunction_expression" => return Some("||"),
+        _ => return None,
+    }
+    let operator = match node.child_by_field_name("operator") {
+        Some(operator) => node_text(operator, source_code),
+        None => {
+            let mut cursor = node.walk();
+            let found = node
+                .children(&mut cursor)
+                .filter(|child| !child.is_named())
+                .map(|child| node_text(child, source_code))
+                .find(|text| matches!(*text, "&&" | "||"));
+            found?
+        }
+    };
+    matches!(operator, "&&" | "||").then_some(operator)
+}
+
+fn is_default_case(node: Node, source_code: &str) -> bool {
+    let text = node_text(node, source_code).trim_start();
+    text.starts_with("default") || text.starts_with("_ =>")
+}
+
+fn is_labeled_jump(node: Node) -> bool {
+    match node.kind() {
+        "goto_statement" => true,
+        "break_statement" | "continue_statement" | "break_expression" | "continue_expression" => {
+            node.named_child_count() > 0 && {
+                let kind = node.named_child(0).map(|child| child.kind()).unwrap_or("");
+                kind.contains("label")
+            }
+        }
+        _ => false,
+    }
+}
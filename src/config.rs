@@ -1,4 +1,9 @@
-use crate::analyzer::{AnalysisRule, CodeAnalyzer, Severity};
+use crate::analyzer::{AnalysisRule, CodeAnalyzer, Confidence, Severity, Stage};
+use crate::checks::{self, RuleOptions};
+use crate::docs::RuleDocs;
+use crate::fix::FixTemplate;
+use crate::language::SupportedLanguage;
+use crate::messages;
 use serde::{Deserialize, Serialize};
 use std::fs;
 use std::path::Path;
@@ -6,58 +11,249 @@ use std::path::Path;
 #[derive(Debug, Deserialize, Serialize)]
 pub struct RuleConfig {
     pub name: String,
+    #[serde(default)]
     pub query: String,
+    pub check: Option<String>,
     pub severity: String,
     pub message: String,
     pub suggestion: Option<String>,
+    /// Replaces the text of each finding; see [`crate::messages`].
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub template: Option<String>,
+    /// Where to read more about fixing a finding, such as an internal wiki
+    /// page. `{rule}` is the rule's name.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub url: Option<String>,
     #[serde(default = "default_weight")]
     pub weight: f64,
+    /// `high`, `medium` or `low`; unset is `high`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub confidence: Option<String>,
+    /// `experimental`, `stable` or `deprecated`; unset is `stable`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub stage: Option<String>,
+    /// The rule to use instead of a deprecated one.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub replaced_by: Option<String>,
     #[serde(default)]
     pub enabled: bool,
+    pub fix: Option<FixTemplate>,
+    #[serde(default)]
+    pub options: toml::Table,
+    #[serde(default)]
+    pub docs: Option<RuleDocs>,
+}
+
+impl RuleConfig {
+    pub fn stage(&self) -> Stage {
+        self.stage
+            .as_deref()
+            .and_then(Stage::from_name)
+            .unwrap_or_default()
+    }
+
+    /// Why the rule shouldn't be used, when it is deprecated.
+    pub fn deprecation(&self) -> Option<String> {
+        if self.stage() != Stage::Deprecated {
+            return None;
+        }
+        Some(match &self.replaced_by {
+            Some(replacement) => format!(
+                "rule '{}' is deprecated; use '{}' instead",
+                self.name, replacement
+            ),
+            None => format!("rule '{}' is deprecated and will be removed", self.name),
+        })
+    }
+
+    /// What is wrong with the rule's severity, confidence and options, each
+    /// as the error loading the config would report.
+    pub fn problems(&self) -> Vec<String> {
+        let mut problems = Vec::new();
+        if Severity::from_name(&self.severity).is_none() {
+            problems.push(format!(
+                "rule '{}' has unknown severity '{}' (expected one of: {})",
+                self.name,
+                self.severity,
+                Severity::NAMES
+            ));
+        }
+        if let Some(confidence) = &self.confidence {
+            if Confidence::from_name(confidence).is_none() {
+                problems.push(format!(
+                    "rule '{}' has unknown confidence '{}' (expected one of: {})",
+                    self.name,
+                    confidence,
+                    Confidence::NAMES
+                ));
+            }
+        }
+        if let Some(stage) = &self.stage {
+            if Stage::from_name(stage).is_none() {
+                problems.push(format!(
+                    "rule '{}' has unknown stage '{}' (expected one of: {})",
+                    self.name,
+                    stage,
+                    Stage::NAMES
+                ));
+            }
+        }
+        problems.extend(
+            messages::template_problems(
+                self.template.as_deref(),
+                self.suggestion.as_deref(),
+                self.url.as_deref(),
+            )
+            .into_iter()
+            .map(|problem| format!("rule '{}': {}", self.name, problem)),
+        );
+        if let Some(check) = &self.check {
+            let options = RuleOptions::new(self.options.clone());
+            let mut errors = checks::option_errors(check, &options);
+            if errors.is_empty() {
+                errors.extend(checks::validate_options(check, &options).err());
+            }
+            problems.extend(
+                errors
+                    .into_iter()
+                    .map(|e| format!("rule '{}': {}", self.name, e)),
+            );
+        }
+        problems
+    }
 }
 
 fn default_weight() -> f64 {
     1.0
 }
 
+fn default_true() -> bool {
+    true
+}
+
 #[derive(Debug, Deserialize, Serialize)]
 pub struct AnalyzerConfig {
+    #[serde(default = "default_true")]
+    pub report_unused_suppressions: bool,
+    /// Findings less confident than this are left out; unset keeps them all.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub min_confidence: Option<String>,
+    #[serde(default)]
+    pub plugins: Vec<String>,
     #[serde(default)]
     pub rules: Vec<RuleConfig>,
 }
 
 impl AnalyzerConfig {
     pub fn from_file<P: AsRef<Path>>(path: P) -> Result<Self, Box<dyn std::error::Error>> {
+        let path = path.as_ref();
         let content = fs::read_to_string(path)?;
-        let config: AnalyzerConfig = toml::from_str(&content)?;
+        let mut config: AnalyzerConfig = toml::from_str(&content)?;
+        config.load_plugins(path.parent().unwrap_or_else(|| Path::new(".")))?;
+        config.validate()?;
         Ok(config)
     }
 
+    /// Appends the rules of every rule pack listed in `plugins`, resolved
+    /// relative to `base_dir`.
+    pub(crate) fn load_plugins(
+        &mut self,
+        base_dir: &Path,
+    ) -> Result<(), Box<dyn std::error::Error>> {
+        for plugin in &self.plugins {
+            let plugin_path = base_dir.join(plugin);
+            let is_rule_pack = plugin_path.extension().and_then(|ext| ext.to_str()) == Some("toml");
+            if !is_rule_pack {
+                return Err(format!(
+                    "plugin '{}' is not a .toml rule pack; native checks must be compiled in via compass::plugin",
+                    plugin
+                )
+                .into());
+            }
+
+            let content = fs::read_to_string(&plugin_path)
+                .map_err(|e| format!("failed to read plugin '{}': {}", plugin, e))?;
+            let pack: AnalyzerConfig = toml::from_str(&content)
+                .map_err(|e| format!("failed to parse plugin '{}': {}", plugin, e))?;
+            if !pack.plugins.is_empty() {
+                return Err(
+                    format!("plugin '{}' cannot declare plugins of its own", plugin).into(),
+                );
+            }
+            self.rules.extend(pack.rules);
+        }
+        Ok(())
+    }
+
     pub fn from_str(content: &str) -> Result<Self, Box<dyn std::error::Error>> {
         let config: AnalyzerConfig = toml::from_str(content)?;
+        config.validate()?;
         Ok(config)
     }
 
+    /// Checks the severities, confidences and built-in check options.
+    pub fn validate(&self) -> Result<(), String> {
+        if let Some(confidence) = &self.min_confidence {
+            if Confidence::from_name(confidence).is_none() {
+                return Err(format!(
+                    "unknown min_confidence '{}' (expected one of: {})",
+                    confidence,
+                    Confidence::NAMES
+                ));
+            }
+        }
+        match self.rules.iter().flat_map(RuleConfig::problems).next() {
+            Some(problem) => Err(problem),
+            None => Ok(()),
+        }
+    }
+
+    /// Loads `config_override` if given, otherwise the built-in config for
+    /// `language`. Returns the config with a label describing where it came from.
+    pub fn load(
+        config_override: Option<&str>,
+        language: SupportedLanguage,
+    ) -> Result<(Self, String), Box<dyn std::error::Error>> {
+        match config_override {
+            Some(path) => Ok((Self::from_file(path)?, path.to_string())),
+            None => Ok((
+                Self::from_str(language.default_config())?,
+                format!("built-in {}", language.config_key()),
+            )),
+        }
+    }
+
     pub fn from_language(language: &str) -> Result<Self, Box<dyn std::error::Error>> {
         let config_name = format!("config/{}.toml", language);
         Self::from_file(&config_name)
     }
 
+    /// A warning for each enabled deprecated rule, naming its replacement.
+    pub fn deprecations(&self) -> Vec<String> {
+        self.rules
+            .iter()
+            .filter(|rule| rule.enabled)
+            .filter_map(RuleConfig::deprecation)
+            .collect()
+    }
+
     pub fn to_analyzer(&self) -> CodeAnalyzer {
         let mut analyzer = CodeAnalyzer::new();
+        analyzer.set_report_unused_suppressions(self.report_unused_suppressions);
+        if let Some(confidence) = self
+            .min_confidence
+            .as_deref()
+            .and_then(Confidence::from_name)
+        {
+            analyzer.set_min_confidence(confidence);
+        }
 
         for rule_config in &self.rules {
             if !rule_config.enabled {
                 continue;
             }
 
-            let severity = match rule_config.severity.to_lowercase().as_str() {
-                "error" => Severity::Error,
-                "warning" => Severity::Warning,
-                "info" => Severity::Info,
-                "style" => Severity::Style,
-                _ => Severity::Info,
-            };
+            let severity = Severity::from_name(&rule_config.severity).unwrap_or_default();
 
             let rule = AnalysisRule::new(
                 rule_config.name.clone(),
@@ -66,7 +262,19 @@ impl AnalyzerConfig {
                 rule_config.message.clone(),
                 rule_config.suggestion.clone(),
             )
-            .with_weight(rule_config.weight);
+            .with_weight(rule_config.weight)
+            .with_check(rule_config.check.clone())
+            .with_options(RuleOptions::new(rule_config.options.clone()))
+            .with_fix(rule_config.fix.clone())
+            .with_messages(rule_config.template.clone(), rule_config.url.clone())
+            .with_confidence(
+                rule_config
+                    .confidence
+                    .as_deref()
+                    .and_then(Confidence::from_name)
+                    .unwrap_or_default(),
+            )
+            .with_stage(rule_config.stage());
 
             analyzer.add_rule(rule);
         }
@@ -102,4 +310,90 @@ weight = 2.0
         assert_eq!(config.rules[0].name, "test_rule");
         assert_eq!(config.rules[0].weight, 2.0);
     }
+
+    #[test]
+    fn test_unknown_severity_is_rejected() {
+        let toml_str = r#"
+[[rules]]
+name = "test_rule"
+query = "(ERROR) @error"
+severity = "critical"
+message = "Test error"
+enabled = true
+        "#;
+
+        let error = AnalyzerConfig::from_str(toml_str).unwrap_err();
+        assert!(error.to_string().contains("unknown severity 'critical'"));
+    }
+
+    #[test]
+    fn test_confidence_is_validated_and_applied() {
+        let toml_str = r#"
+min_confidence = "medium"
+
+[[rules]]
+name = "test_rule"
+query = "(ERROR) @error"
+severity = "info"
+message = "Test error"
+confidence = "low"
+enabled = true
+        "#;
+
+        let config = AnalyzerConfig::from_str(toml_str).unwrap();
+        let analyzer = config.to_analyzer();
+        assert_eq!(analyzer.rules()[0].confidence, Confidence::Low);
+
+        let error =
+            AnalyzerConfig::from_str(&toml_str.replace("\"low\"", "\"certain\"")).unwrap_err();
+        assert!(error.to_string().contains("unknown confidence 'certain'"));
+        let error =
+            AnalyzerConfig::from_str(&toml_str.replace("\"medium\"", "\"some\"")).unwrap_err();
+        assert!(error.to_string().contains("unknown min_confidence 'some'"));
+    }
+
+    #[test]
+    fn test_invalid_misuse_declaration_is_rejected() {
+        let toml_str = r#"
+[[rules]]
+name = "no_md5"
+check = "go_api_misuse"
+severity = "error"
+message = "MD5 is not collision resistant"
+enabled = true
+
+[rules.options]
+function = "md5"
+forbidden_in = ["example.com/app/..."]
+        "#;
+
+        let error = AnalyzerConfig::from_str(toml_str).unwrap_err();
+        assert!(error
+            .to_string()
+            .starts_with("rule 'no_md5': `function` must be an import path and a name"));
+    }
+
+    #[test]
+    fn test_plugins_append_rule_packs() {
+        let dir = std::env::temp_dir().join(format!("compass-plugins-{}", std::process::id()));
+        fs::create_dir_all(dir.join("rules")).unwrap();
+        fs::write(
+            dir.join("rules/mycorp.toml"),
+            "[[rules]]\nname = \"mycorp_rule\"\nquery = \"(comment) @c\"\nseverity = \"info\"\nmessage = \"m\"\nenabled = true\n",
+        )
+        .unwrap();
+        fs::write(
+            dir.join("compass.toml"),
+            "plugins = [\"rules/mycorp.toml\"]\n\n[[rules]]\nname = \"local\"\nquery = \"(ERROR) @e\"\nseverity = \"error\"\nmessage = \"m\"\nenabled = true\n",
+        )
+        .unwrap();
+        fs::write(dir.join("native.toml"), "plugins = [\"rules/mycorp.so\"]\n").unwrap();
+
+        let config = AnalyzerConfig::from_file(dir.join("compass.toml")).unwrap();
+        let names: Vec<_> = config.rules.iter().map(|r| r.name.as_str()).collect();
+        assert_eq!(names, vec!["local", "mycorp_rule"]);
+
+        assert!(AnalyzerConfig::from_file(dir.join("native.toml")).is_err());
+        fs::remove_dir_all(&dir).unwrap();
+    }
 }
@@ -0,0 +1,735 @@
+//! `compass deps`: risks in the modules a Go module depends on, read from
+//! its `go.mod` and `go.sum`.
+//!
+//! Each [`Issue`] belongs to a rule of the Go config, so `.compass.toml`
+//! can turn it off, change its severity or set its options, and its
+//! findings go through the usual reports and `--fail-on`:
+//!
+//! - [`Issue::Archived`]: modules their authors archived. A few well-known
+//!   ones are built in; the rule's `archived` option adds more, and a
+//!   module whose newest `go.mod` in the cache is marked `Deprecated:` is
+//!   one as well.
+//! - [`Issue::Unmaintained`]: modules that published nothing for
+//!   `max_years`.
+//! - [`Issue::Retracted`]: required versions that a newer `go.mod` of the
+//!   module retracts.
+//! - [`Issue::LocalReplace`]: `replace` directives pointing at a directory,
+//!   which other machines, and modules importing this one, don't have.
+//! - [`Issue::MajorVersion`]: `+incompatible` versions, and versions whose
+//!   major version doesn't match the `/vN` suffix of their path.
+//! - [`Issue::Vulnerable`]: versions with known vulnerabilities, from the
+//!   Go vulnerability database (see [`crate::vuln`]). A vulnerability in
+//!   packages the module doesn't import has low confidence; the calls that
+//!   reach one are rules of their own.
+//!
+//! Modules that only `go.sum` lists are what the requirements need in turn.
+//! They are checked for the first three issues at the highest version
+//! `go.sum` has, with medium confidence, since the build may select
+//! another one.
+//!
+//! Nothing is downloaded: compass knows what the module cache knows.
+//! `go mod download` fetches the required versions; `go list -m -u all`
+//! also fetches each module's latest version and its `go.mod`, which
+//! retractions, deprecations and release dates are read from. Without the
+//! published version list, a module counts as unmaintained when the newest
+//! version the cache has is that old, with medium confidence.
+
+use crate::analyzer::Confidence;
+use crate::callgraph::CallGraph;
+use crate::checks::RuleOptions;
+use crate::module::{self, compare_versions, within, Directive, Module};
+use crate::vuln::Database;
+use std::cmp::Ordering;
+use std::collections::{BTreeMap, BTreeSet};
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::sync::OnceLock;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+pub const GO_SUM_FILE: &str = "go.sum";
+
+/// The default `max_years` of [`Issue::Unmaintained`].
+pub const DEFAULT_MAX_YEARS: f64 = 3.0;
+
+const SECONDS_PER_YEAR: f64 = 365.25 * 24.0 * 60.0 * 60.0;
+
+/// Archived modules reported whether or not the cache knows anything about
+/// them, with what to use instead.
+const KNOWN_ARCHIVED: &[(&str, &str)] = &[
+    (
+        "github.com/dgrijalva/jwt-go",
+        "use github.com/golang-jwt/jwt, its maintained fork",
+    ),
+    (
+        "github.com/golang/mock",
+        "use go.uber.org/mock, its maintained fork",
+    ),
+    (
+        "github.com/mitchellh/go-homedir",
+        "use os.UserHomeDir from the standard library",
+    ),
+    (
+        "github.com/mitchellh/mapstructure",
+        "use github.com/go-viper/mapstructure/v2, its maintained fork",
+    ),
+    (
+        "github.com/pkg/errors",
+        "use the standard errors package and fmt.Errorf with %w",
+    ),
+];
+
+/// What a rule of `compass deps` looks for, by the check its rule uses.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Issue {
+    Archived,
+    Unmaintained,
+    Retracted,
+    LocalReplace,
+    MajorVersion,
+    Vulnerable,
+}
+
+impl Issue {
+    pub fn from_check(name: &str) -> Option<Issue> {
+        match name {
+            "go_mod_archived" => Some(Issue::Archived),
+            "go_mod_unmaintained" => Some(Issue::Unmaintained),
+            "go_mod_retracted" => Some(Issue::Retracted),
+            "go_mod_local_replace" => Some(Issue::LocalReplace),
+            "go_mod_major_version" => Some(Issue::MajorVersion),
+            "go_mod_vulnerable" => Some(Issue::Vulnerable),
+            _ => None,
+        }
+    }
+}
+
+/// Which file a finding is in.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub enum Source {
+    GoMod,
+    GoSum,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Finding {
+    pub source: Source,
+    /// The bytes of the requirement, replacement or `go.sum` line.
+    pub span: (usize, usize),
+    pub message: String,
+    /// Lowers the rule's confidence for this finding.
+    pub confidence: Option<Confidence>,
+}
+
+#[derive(Debug, Clone)]
+struct Dependency {
+    path: String,
+    version: String,
+    source: Source,
+    span: (usize, usize),
+}
+
+#[derive(Debug, Clone)]
+struct Replace {
+    from: String,
+    /// A directory, when the replacement has no version.
+    dir: Option<String>,
+    span: (usize, usize),
+}
+
+/// What the module cache has about one module.
+#[derive(Debug, Default)]
+struct Cached {
+    /// Versions with a `.info`, and when they were published.
+    published: Vec<(String, Option<u64>)>,
+    /// Every published version, once something asked the proxy.
+    listed: Option<Vec<String>>,
+    /// Versions with a `.mod`, which needn't have a `.info`.
+    fetched: Vec<String>,
+    /// The `go.mod` of the newest version that has one.
+    newest_go_mod: Option<(String, String)>,
+}
+
+/// The dependencies of a module.
+pub struct Dependencies {
+    pub go_mod: String,
+    pub go_sum: Option<String>,
+    dependencies: Vec<Dependency>,
+    replaces: Vec<Replace>,
+    /// The module cache, where `cache/download` is.
+    cache: Option<PathBuf>,
+    /// Seconds since the Unix epoch, for ages.
+    now: u64,
+    /// Where the module is, to find what it imports.
+    module: Option<Module>,
+    /// The packages the module's own code imports, once asked for.
+    imported: OnceLock<BTreeSet<String>>,
+}
+
+impl Dependencies {
+    /// Reads the `go.mod` and `go.sum` of `module`.
+    pub fn read(module: &Module) -> Self {
+        let go_sum = fs::read_to_string(module.root.join(GO_SUM_FILE)).ok();
+        let now = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .map_or(0, |elapsed| elapsed.as_secs());
+        let mut dependencies =
+            Dependencies::parse(&module.go_mod, go_sum, module::module_cache(), now);
+        dependencies.module = Some(module.clone());
+        dependencies
+    }
+
+    fn parse(go_mod: &str, go_sum: Option<String>, cache: Option<PathBuf>, now: u64) -> Self {
+        let mut dependencies = Vec::new();
+        let mut replaces = Vec::new();
+        let mut own = String::new();
+        for directive in module::directive_lines(go_mod) {
+            let span = (directive.start_byte, directive.end_byte);
+            match (directive.name, directive.args.as_slice()) {
+                ("module", [path, ..]) => own = path.clone(),
+                ("require", [path, version, ..]) => dependencies.push(Dependency {
+                    path: path.clone(),
+                    version: version.clone(),
+                    source: Source::GoMod,
+                    span,
+                }),
+                ("replace", args) => {
+                    let Some(arrow) = args.iter().position(|arg| arg == "=>") else {
+                        continue;
+                    };
+                    let (Some(from), Some(to)) = (args.first(), args.get(arrow + 1)) else {
+                        continue;
+                    };
+                    replaces.push(Replace {
+                        from: from.clone(),
+                        dir: args.get(arrow + 2).is_none().then(|| to.clone()),
+                        span,
+                    });
+                }
+                _ => {}
+            }
+        }
+
+        // The highest version of each module only `go.sum` has.
+        let mut summed: BTreeMap<&str, Dependency> = BTreeMap::new();
+        let mut offset = 0;
+        for line in go_sum.as_deref().unwrap_or_default().split_inclusive('\n') {
+            let start = offset;
+            offset += line.len();
+            let mut fields = line.split_whitespace();
+            let (Some(path), Some(version)) = (fields.next(), fields.next()) else {
+                continue;
+            };
+            let required = dependencies
+                .iter()
+                .any(|dependency| dependency.path == path);
+            if version.ends_with("/go.mod") || required || path == own {
+                continue;
+            }
+            let newer = summed
+                .get(path)
+                .is_none_or(|known| compare_versions(version, &known.version) == Ordering::Greater);
+            if newer {
+                let end = start + line.find(version).unwrap_or(0) + version.len();
+                summed.insert(
+                    path,
+                    Dependency {
+                        path: path.to_string(),
+                        version: version.to_string(),
+                        source: Source::GoSum,
+                        span: (start, end),
+                    },
+                );
+            }
+        }
+        dependencies.extend(summed.into_values());
+
+        Dependencies {
+            go_mod: go_mod.to_string(),
+            go_sum,
+            dependencies,
+            replaces,
+            cache,
+            now,
+            module: None,
+            imported: OnceLock::new(),
+        }
+    }
+
+    /// What the rule looking for `issue` reports, in file order.
+    pub fn findings(&self, issue: Issue, options: &RuleOptions) -> Vec<Finding> {
+        let allow = options.string_list("allow").unwrap_or_default();
+        let allowed = |path: &str| allow.iter().any(|allowed| within(path, allowed));
+        let mut found = match issue {
+            Issue::Archived => self.archived(options),
+            Issue::Unmaintained => self.unmaintained(options),
+            Issue::Retracted => self.retracted(),
+            Issue::LocalReplace => self.local_replaces(),
+            Issue::MajorVersion => self.major_versions(),
+            Issue::Vulnerable => self.vulnerable(options),
+        };
+        found.retain(|(path, _)| !allowed(path));
+        let mut found: Vec<Finding> = found.into_iter().map(|(_, finding)| finding).collect();
+        found.sort_by_key(|finding| (finding.source, finding.span));
+        found
+    }
+
+    fn archived(&self, options: &RuleOptions) -> Vec<(String, Finding)> {
+        let extra = options.string_list("archived").unwrap_or_default();
+        let mut found = Vec::new();
+        for dependency in &self.dependencies {
+            let known = KNOWN_ARCHIVED
+                .iter()
+                .find(|(path, _)| within(&dependency.path, path))
+                .map(|(_, note)| format!("`{}` is archived; {}", dependency.path, note));
+            let listed = || {
+                extra
+                    .iter()
+                    .any(|path| within(&dependency.path, path))
+                    .then(|| format!("`{}` is archived", dependency.path))
+            };
+            let deprecated = || {
+                let (_, go_mod) = self.cached(&dependency.path).newest_go_mod?;
+                let note = module::go_mod_deprecation(&go_mod)?;
+                Some(format!("`{}` is deprecated: {}", dependency.path, note))
+            };
+            if let Some(message) = known.or_else(listed).or_else(deprecated) {
+                found.push(self.finding(dependency, message, None));
+            }
+        }
+        found
+    }
+
+    fn unmaintained(&self, options: &RuleOptions) -> Vec<(String, Finding)> {
+        let max_years = options.float("max_years").unwrap_or(DEFAULT_MAX_YEARS);
+        let mut found = Vec::new();
+        for dependency in self.published_dependencies() {
+            let cached = self.cached(&dependency.path);
+            let newest = cached
+                .published
+                .iter()
+                .map(|(version, _)| version)
+                .chain(cached.listed.iter().flatten())
+                .chain(&cached.fetched)
+                .max_by(|a, b| compare_versions(a, b));
+            let Some(newest) = newest else {
+                continue;
+            };
+            // A newer version the cache hasn't fetched has no date.
+            let published = cached
+                .published
+                .iter()
+                .find(|(version, _)| version == newest)
+                .and_then(|(_, time)| *time);
+            let Some(published) = published else {
+                continue;
+            };
+            let years = self.now.saturating_sub(published) as f64 / SECONDS_PER_YEAR;
+            if years <= max_years {
+                continue;
+            }
+            let message = format!(
+                "`{}` has published nothing since {} on {}, more than {} years ago",
+                dependency.path,
+                newest,
+                date(published),
+                max_years
+            );
+            let confidence = cached.listed.is_none().then_some(Confidence::Medium);
+            found.push(self.finding(dependency, message, confidence));
+        }
+        found
+    }
+
+    fn retracted(&self) -> Vec<(String, Finding)> {
+        let mut found = Vec::new();
+        for dependency in self.published_dependencies() {
+            let Some((_, go_mod)) = self.cached(&dependency.path).newest_go_mod else {
+                continue;
+            };
+            let retraction = module::directive_lines(&go_mod)
+                .into_iter()
+                .filter(|directive| directive.name == "retract")
+                .find(|directive| retracts(directive, &dependency.version));
+            let Some(retraction) = retraction else {
+                continue;
+            };
+            let mut message = format!("`{}@{}` is retracted", dependency.path, dependency.version);
+            if !retraction.comment.is_empty() {
+                message.push_str(&format!(": {}", retraction.comment));
+            }
+            found.push(self.finding(dependency, message, None));
+        }
+        found
+    }
+
+    fn local_replaces(&self) -> Vec<(String, Finding)> {
+        self.replaces
+            .iter()
+            .filter_map(|replace| {
+                let dir = replace.dir.as_deref()?;
+                let message = format!(
+                    "`{}` is replaced by the directory `{}`, which other machines and modules importing this one don't have",
+                    replace.from, dir
+                );
+                let finding = Finding {
+                    source: Source::GoMod,
+                    span: replace.span,
+                    message,
+                    confidence: None,
+                };
+                Some((replace.from.clone(), finding))
+            })
+            .collect()
+    }
+
+    fn major_versions(&self) -> Vec<(String, Finding)> {
+        let mut found = Vec::new();
+        for dependency in &self.dependencies {
+            if dependency.source != Source::GoMod {
+                continue;
+            }
+            let Some(major) = major_version(&dependency.version) else {
+                continue;
+            };
+            let suffix = path_major(&dependency.path);
+            let message = if dependency.version.ends_with("+incompatible") {
+                format!(
+                    "`{}@{}` is a v{} module without a `/v{}` path; `+incompatible` versions bypass semantic import versioning",
+                    dependency.path, dependency.version, major, major
+                )
+            } else if suffix.unwrap_or(1).max(1) != major.max(1) {
+                format!(
+                    "`{}` is required at {}, but its path is for major version {}",
+                    dependency.path,
+                    dependency.version,
+                    suffix.unwrap_or(1)
+                )
+            } else {
+                continue;
+            };
+            found.push(self.finding(dependency, message, None));
+        }
+        found
+    }
+
+    fn vulnerable(&self, options: &RuleOptions) -> Vec<(String, Finding)> {
+        let Some(database) = Database::locate(options.string("db"))
+            .and_then(|location| Database::open(&location).ok())
+        else {
+            return Vec::new();
+        };
+        let ignored = options.string_list("ignore").unwrap_or_default();
+        let imported = self.imported.get_or_init(|| {
+            self.module.as_ref().map_or_else(BTreeSet::new, |module| {
+                let graph = CallGraph::for_module(module);
+                graph.imports.values().flatten().cloned().collect()
+            })
+        });
+
+        let mut found = Vec::new();
+        for dependency in self.published_dependencies() {
+            for vulnerability in database.affecting(&dependency.path, &dependency.version) {
+                if vulnerability.is_named(&ignored) {
+                    continue;
+                }
+                let Some(affected) = vulnerability.affecting(&dependency.path, &dependency.version)
+                else {
+                    continue;
+                };
+                let used: Vec<String> = imported
+                    .iter()
+                    .filter(|path| within(path, &dependency.path))
+                    .filter(|path| affected.symbols(path).is_some())
+                    .map(|path| format!("`{}`", path))
+                    .collect();
+                let (usage, confidence) = match used.is_empty() {
+                    true => (
+                        "nothing in the module imports the vulnerable packages".to_string(),
+                        Some(Confidence::Low),
+                    ),
+                    false => (format!("the module imports {}", used.join(", ")), None),
+                };
+                let message = format!(
+                    "`{}@{}` is affected by {}: {}; {}; {}",
+                    dependency.path,
+                    dependency.version,
+                    vulnerability.title(),
+                    vulnerability.summary,
+                    usage,
+                    vulnerability.fix(&dependency.path, &dependency.version)
+                );
+                found.push(self.finding(dependency, message, confidence));
+            }
+        }
+        found
+    }
+
+    /// Dependencies checked against what was published, which a replaced
+    /// module's source wasn't.
+    fn published_dependencies(&self) -> impl Iterator<Item = &Dependency> {
+        self.dependencies.iter().filter(|dependency| {
+            !self
+                .replaces
+                .iter()
+                .any(|replace| replace.from == dependency.path)
+        })
+    }
+
+    fn finding(
+        &self,
+        dependency: &Dependency,
+        message: String,
+        confidence: Option<Confidence>,
+    ) -> (String, Finding) {
+        let confidence = match (dependency.source, confidence) {
+            (Source::GoMod, confidence) => confidence,
+            (Source::GoSum, Some(Confidence::Low)) => Some(Confidence::Low),
+            (Source::GoSum, _) => Some(Confidence::Medium),
+        };
+        let finding = Finding {
+            source: dependency.source,
+            span: dependency.span,
+            message,
+            confidence,
+        };
+        (dependency.path.clone(), finding)
+    }
+
+    fn cached(&self, module_path: &str) -> Cached {
+        let Some(cache) = &self.cache else {
+            return Cached::default();
+        };
+        read_cached(&module::download_dir(cache, module_path))
+    }
+}
+
+fn read_cached(dir: &Path) -> Cached {
+    let mut cached = Cached::default();
+    let Ok(entries) = fs::read_dir(dir) else {
+        return cached;
+    };
+    let mut go_mods = Vec::new();
+    for entry in entries.flatten() {
+        let name = entry.file_name().to_string_lossy().into_owned();
+        if name == "list" {
+            let Ok(list) = fs::read_to_string(entry.path()) else {
+                continue;
+            };
+            cached.listed = Some(list.split_whitespace().map(str::to_string).collect());
+        } else if let Some(version) = name.strip_suffix(".info") {
+            let time = fs::read_to_string(entry.path())
+                .ok()
+                .and_then(|info| serde_json::from_str::<serde_json::Value>(&info).ok())
+                .and_then(|info| info["Time"].as_str().and_then(parse_time));
+            cached.published.push((version.to_string(), time));
+        } else if let Some(version) = name.strip_suffix(".mod") {
+            cached.fetched.push(version.to_string());
+            go_mods.push((version.to_string(), entry.path()));
+        }
+    }
+    cached.newest_go_mod = go_mods
+        .into_iter()
+        .max_by(|(a, _), (b, _)| compare_versions(a, b))
+        .and_then(|(version, path)| Some((version, fs::read_to_string(path).ok()?)));
+    cached
+}
+
+/// Whether a `retract` directive, of one version or of a `[low, high]`
+/// range, covers `version`.
+fn retracts(directive: &Directive, version: &str) -> bool {
+    let args = directive.args.join(" ");
+    let range = args.trim().trim_start_matches('[').trim_end_matches(']');
+    match range.split_once(',') {
+        Some((low, high)) => {
+            compare_versions(version, low.trim()) != Ordering::Less
+                && compare_versions(version, high.trim()) != Ordering::Greater
+        }
+        None => compare_versions(version, range.trim()) == Ordering::Equal,
+    }
+}
+
+/// The major version of `v2.3.4`, `v2.0.0+incompatible` or a pseudo-version
+/// such as `v2.0.0-20210101000000-abcdef123456`.
+fn major_version(version: &str) -> Option<u64> {
+    version.strip_prefix('v')?.split('.').next()?.parse().ok()
+}
+
+/// The major version a module path is for: `N` of a `/vN` suffix, or of
+/// `.vN` for `gopkg.in`, and `None` for a path without one.
+fn path_major(path: &str) -> Option<u64> {
+    let (rest, suffix) = if path.starts_with("gopkg.in/") {
+        path.rsplit_once(".v")?
+    } else {
+        path.rsplit_once("/v")?
+    };
+    if rest.is_empty() || suffix.is_empty() || !suffix.bytes().all(|b| b.is_ascii_digit()) {
+        return None;
+    }
+    suffix.parse().ok()
+}
+
+/// Seconds since the Unix epoch of an RFC 3339 time such as
+/// `2019-06-12T15:46:42Z`. Fractions and offsets are ignored, which is
+/// close enough for ages in years.
+fn parse_time(text: &str) -> Option<u64> {
+    let (day, time) = text.split_once('T')?;
+    let mut day = day.split('-').map(|part| part.parse::<i64>().ok());
+    let (year, month, day) = (day.next()??, day.next()??, day.next()??);
+    let mut time = time
+        .get(..8)?
+        .split(':')
+        .map(|part| part.parse::<i64>().ok());
+    let (hours, minutes, seconds) = (time.next()??, time.next()??, time.next()??);
+    let days = days_from_civil(year, month, day);
+    u64::try_from(days * 86400 + hours * 3600 + minutes * 60 + seconds).ok()
+}
+
+/// `YYYY-MM-DD` of a time in seconds since the Unix epoch.
+fn date(seconds: u64) -> String {
+    let days = (seconds / 86400) as i64;
+    // The inverse of `days_from_civil`, from Howard Hinnant's algorithms.
+    let z = days + 719468;
+    let era = z.div_euclid(146097);
+    let doe = z - era * 146097;
+    let yoe = (doe - doe / 1460 + doe / 36524 - doe / 146096) / 365;
+    let doy = doe - (365 * yoe + yoe / 4 - yoe / 100);
+    let mp = (5 * doy + 2) / 153;
+    let day = doy - (153 * mp + 2) / 5 + 1;
+    let month = if mp < 10 { mp + 3 } else { mp - 9 };
+    let year = yoe + era * 400 + i64::from(month <= 2);
+    format!("{:04}-{:02}-{:02}", year, month, day)
+}
+
+/// Days since the Unix epoch of a date in the proleptic Gregorian calendar.
+fn days_from_civil(year: i64, month: i64, day: i64) -> i64 {
+    let year = if month <= 2 { year - 1 } else { year };
+    let era = year.div_euclid(400);
+    let yoe = year - era * 400;
+    let mp = (month + 9) % 12;
+    let doy = (153 * mp + 2) / 5 + day - 1;
+    let doe = yoe * 365 + yoe / 4 - yoe / 100 + doy;
+    era * 146097 + doe - 719468
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const GO_MOD: &str = "module example.com/app\n\ngo 1.22\n\nrequire (\n\tgithub.com/pkg/errors v0.9.1\n\texample.com/old v1.2.0\n\texample.com/lib/v2 v3.0.0\n\texample.com/legacy v2.1.0+incompatible // indirect\n)\n\nreplace example.com/shared => ../shared\n";
+
+    fn scratch_cache(name: &str) -> PathBuf {
+        let dir =
+            std::env::temp_dir().join(format!("compass-deps-{}-{}", name, std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        dir
+    }
+
+    fn cache_file(cache: &Path, module_path: &str, name: &str, content: &str) {
+        let dir = module::download_dir(cache, module_path);
+        fs::create_dir_all(&dir).unwrap();
+        fs::write(dir.join(name), content).unwrap();
+    }
+
+    fn messages(dependencies: &Dependencies, issue: Issue, options: &str) -> Vec<String> {
+        let options = RuleOptions::new(toml::from_str(options).unwrap());
+        dependencies
+            .findings(issue, &options)
+            .into_iter()
+            .map(|finding| finding.message)
+            .collect()
+    }
+
+    #[test]
+    fn test_go_mod_risks_are_found_without_the_cache() {
+        let dependencies = Dependencies::parse(GO_MOD, None, None, 0);
+        assert_eq!(
+            messages(&dependencies, Issue::Archived, "archived = [\"example.com/old\"]"),
+            [
+                "`github.com/pkg/errors` is archived; use the standard errors package and fmt.Errorf with %w",
+                "`example.com/old` is archived",
+            ]
+        );
+        assert_eq!(
+            messages(&dependencies, Issue::LocalReplace, ""),
+            ["`example.com/shared` is replaced by the directory `../shared`, which other machines and modules importing this one don't have"]
+        );
+        assert_eq!(
+            messages(&dependencies, Issue::MajorVersion, ""),
+            [
+                "`example.com/lib/v2` is required at v3.0.0, but its path is for major version 2",
+                "`example.com/legacy@v2.1.0+incompatible` is a v2 module without a `/v2` path; `+incompatible` versions bypass semantic import versioning",
+            ]
+        );
+        assert!(messages(
+            &dependencies,
+            Issue::LocalReplace,
+            "allow = [\"example.com/shared\"]"
+        )
+        .is_empty());
+
+        let finding = &dependencies.findings(Issue::LocalReplace, &RuleOptions::default())[0];
+        assert_eq!(
+            &GO_MOD[finding.span.0..finding.span.1],
+            "replace example.com/shared => ../shared"
+        );
+    }
+
+    #[test]
+    fn test_cached_metadata_finds_stale_and_retracted_versions() {
+        let cache = scratch_cache("cache");
+        cache_file(
+            &cache,
+            "example.com/old",
+            "v1.2.0.info",
+            "{\"Version\":\"v1.2.0\",\"Time\":\"2019-06-12T15:46:42Z\"}",
+        );
+        cache_file(
+            &cache,
+            "example.com/old",
+            "v1.3.0.mod",
+            "module example.com/old\n\nretract [v1.1.0, v1.2.5] // leaks connections\n",
+        );
+        cache_file(
+            &cache,
+            "example.com/tool",
+            "v0.4.0.info",
+            "{\"Version\":\"v0.4.0\",\"Time\":\"2024-01-02T00:00:00Z\"}",
+        );
+        cache_file(&cache, "example.com/tool", "list", "v0.4.0\n");
+        let go_sum = "example.com/tool v0.3.0 h1:abc=\nexample.com/tool v0.4.0 h1:def=\nexample.com/tool v0.4.0/go.mod h1:ghi=\n";
+        let now = parse_time("2025-01-01T00:00:00Z").unwrap();
+        let dependencies = Dependencies::parse(GO_MOD, Some(go_sum.to_string()), Some(cache), now);
+
+        assert_eq!(
+            messages(&dependencies, Issue::Retracted, ""),
+            ["`example.com/old@v1.2.0` is retracted: leaks connections"]
+        );
+        // v1.3.0 has a go.mod but no release date, so the module's age is
+        // unknown.
+        assert!(messages(&dependencies, Issue::Unmaintained, "").is_empty());
+        assert_eq!(
+            messages(&dependencies, Issue::Unmaintained, "max_years = 0.5"),
+            ["`example.com/tool` has published nothing since v0.4.0 on 2024-01-02, more than 0.5 years ago"]
+        );
+        let finding = &dependencies.findings(
+            Issue::Unmaintained,
+            &RuleOptions::new(toml::from_str("max_years = 0.5").unwrap()),
+        )[0];
+        assert_eq!(finding.source, Source::GoSum);
+        assert_eq!(finding.confidence, Some(Confidence::Medium));
+        assert_eq!(
+            &go_sum[finding.span.0..finding.span.1],
+            "example.com/tool v0.4.0"
+        );
+    }
+
+    #[test]
+    fn test_dates_round_trip() {
+        let seconds = parse_time("2019-06-12T15:46:42Z").unwrap();
+        assert_eq!(seconds, 1560354402);
+        assert_eq!(date(seconds), "2019-06-12");
+        assert_eq!(path_major("gopkg.in/yaml.v3"), Some(3));
+        assert_eq!(path_major("example.com/lib/v2"), Some(2));
+        assert_eq!(path_major("example.com/vault"), None);
+    }
+}
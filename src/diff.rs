@@ -0,0 +1,130 @@
+use crate::analyzer::AnalysisResult;
+use std::collections::BTreeMap;
+use std::path::Path;
+use std::process::Command;
+
+/// Inclusive, one-based line ranges that were added or modified, per file.
+pub type ChangedLines = BTreeMap<String, Vec<(usize, usize)>>;
+
+/// Lines changed between the merge base of `base` and `HEAD` and the working
+/// tree, with paths relative to the current directory.
+pub fn changed_lines(base: &str) -> Result<ChangedLines, String> {
+    changed_lines_in(Path::new("."), base)
+}
+
+/// [`changed_lines`] of the checkout `dir`, with paths relative to it.
+/// Renamed and copied files count under their new path, like added ones.
+pub fn changed_lines_in(dir: &Path, base: &str) -> Result<ChangedLines, String> {
+    let merge_base = git_in(dir, &["merge-base", base, "HEAD"])?;
+    let diff = git_in(
+        dir,
+        &[
+            "diff",
+            "--unified=0",
+            "--no-color",
+            "--no-ext-diff",
+            "--relative",
+            "--diff-filter=ACMR",
+            merge_base.trim(),
+        ],
+    )?;
+    Ok(parse_unified_diff(&diff))
+}
+
+/// Runs git in `dir` and returns its stdout.
+pub(crate) fn git_in(dir: &Path, args: &[&str]) -> Result<String, String> {
+    let output = Command::new("git")
+        .current_dir(dir)
+        .args(args)
+        .output()
+        .map_err(|e| format!("failed to run git: {}", e))?;
+    if !output.status.success() {
+        return Err(format!(
+            "git {} failed: {}",
+            args.join(" "),
+            String::from_utf8_lossy(&output.stderr).trim()
+        ));
+    }
+    Ok(String::from_utf8_lossy(&output.stdout).into_owned())
+}
+
+pub fn parse_unified_diff(diff: &str) -> ChangedLines {
+    let mut changed = ChangedLines::new();
+    let mut current: Option<String> = None;
+
+    for line in diff.lines() {
+        if let Some(path) = line.strip_prefix("+++ ") {
+            current = path.strip_prefix("b/").map(str::to_string);
+            continue;
+        }
+
+        let (Some(file), Some(hunk)) = (&current, line.strip_prefix("@@ ")) else {
+            continue;
+        };
+        let Some(added) = hunk.split_whitespace().find(|part| part.starts_with('+')) else {
+            continue;
+        };
+
+        let mut numbers = added[1..].splitn(2, ',');
+        let start: usize = numbers.next().and_then(|n| n.parse().ok()).unwrap_or(0);
+        let count: usize = numbers.next().and_then(|n| n.parse().ok()).unwrap_or(1);
+        if count > 0 {
+            changed
+                .entry(file.clone())
+                .or_default()
+                .push((start, start + count - 1));
+        }
+    }
+
+    changed
+}
+
+pub fn intersects(result: &AnalysisResult, ranges: &[(usize, usize)]) -> bool {
+    let end_line = result.end_line.max(result.line);
+    ranges
+        .iter()
+        .any(|&(start, end)| result.line <= end && start <= end_line)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const DIFF: &str = "\
+diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -3,0 +4,2 @@ import \"fmt\"
++func extra() {
++}
+@@ -10 +12 @@ func main() {
+-	old()
++	new()
+@@ -20,3 +21,0 @@ func gone() {
+diff --git a/new.go b/new.go
+new file mode 100644
+--- /dev/null
++++ b/new.go
+@@ -0,0 +1,3 @@
++package main
+";
+
+    #[test]
+    fn test_parse_unified_diff() {
+        let changed = parse_unified_diff(DIFF);
+        assert_eq!(changed["main.go"], vec![(4, 5), (12, 12)]);
+        assert_eq!(changed["new.go"], vec![(1, 3)]);
+    }
+
+    #[test]
+    fn test_intersects_multi_line_findings() {
+        let result = AnalysisResult {
+            line: 10,
+            end_line: 14,
+            ..Default::default()
+        };
+        assert!(intersects(&result, &[(12, 12)]));
+        assert!(!intersects(&result, &[(1, 9), (15, 20)]));
+    }
+}
@@ -0,0 +1,272 @@
+//! Rule documentation for `compass rules` and `compass explain`.
+//!
+//! Rules describe themselves in an optional `[rules.docs]` table next to
+//! their definition. Rules without one are still listed, with their message
+//! and suggestion standing in for the description and rationale.
+
+use crate::analyzer::Stage;
+use crate::checks;
+use crate::config::RuleConfig;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+
+#[derive(Debug, Clone, Default, Deserialize, Serialize)]
+#[serde(deny_unknown_fields)]
+pub struct RuleDocs {
+    /// What the rule finds, in a sentence or two.
+    pub description: Option<String>,
+    /// Why the finding matters.
+    pub rationale: Option<String>,
+    /// Code the rule reports.
+    pub bad: Option<String>,
+    /// The same code written so the rule is satisfied.
+    pub good: Option<String>,
+    /// Option names and what they do, including defaults.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub options: BTreeMap<String, String>,
+    /// Whether the check behind the rule attaches fixes. Query rules with a
+    /// `fix` template are fixable without saying so.
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub autofix: bool,
+}
+
+/// The rules of one config, labelled by language or file name.
+pub struct RuleSet<'a> {
+    pub label: &'a str,
+    /// The heading of the set's markdown section.
+    pub title: &'a str,
+    /// Fence language for code examples in markdown.
+    pub code_language: &'a str,
+    pub rules: &'a [RuleConfig],
+}
+
+impl RuleSet<'_> {
+    /// `label/name`, the id `compass explain` accepts.
+    pub fn id(&self, rule: &RuleConfig) -> String {
+        format!("{}/{}", self.label, rule.name)
+    }
+}
+
+fn docs(rule: &RuleConfig) -> RuleDocs {
+    rule.docs.clone().unwrap_or_default()
+}
+
+fn has_autofix(rule: &RuleConfig) -> bool {
+    rule.fix.is_some() || rule.docs.as_ref().is_some_and(|docs| docs.autofix)
+}
+
+/// Whether `--mode syntax` runs the rule: query rules, and checks that
+/// don't need the package.
+fn runs_on_syntax(rule: &RuleConfig) -> bool {
+    !rule
+        .check
+        .as_deref()
+        .and_then(checks::builtin)
+        .is_some_and(|check| check.needs_package())
+}
+
+/// Whether the rule runs without being turned on: experimental rules
+/// don't, whatever the config says.
+fn on_by_default(rule: &RuleConfig) -> bool {
+    rule.enabled && rule.stage() != Stage::Experimental
+}
+
+fn yes_no(value: bool) -> &'static str {
+    if value {
+        "yes"
+    } else {
+        "no"
+    }
+}
+
+/// One line per rule: id, severity, autofix marker, summary, and the stage
+/// of rules that aren't stable.
+pub fn list(sets: &[RuleSet]) -> String {
+    let width = sets
+        .iter()
+        .flat_map(|set| set.rules.iter().map(|rule| set.id(rule).len()))
+        .max()
+        .unwrap_or(0);
+
+    let mut out = String::new();
+    for set in sets {
+        for rule in set.rules {
+            let marker = if has_autofix(rule) { "fix" } else { "" };
+            let mut notes = String::new();
+            if !rule.stage().is_stable() {
+                notes.push_str(&format!(" ({})", rule.stage().as_str()));
+            }
+            if !rule.enabled {
+                notes.push_str(" (disabled)");
+            }
+            out.push_str(&format!(
+                "{:width$}  {:7}  {:3}  {}{}\n",
+                set.id(rule),
+                rule.severity,
+                marker,
+                rule.message,
+                notes,
+                width = width
+            ));
+        }
+    }
+    out
+}
+
+/// Everything known about one rule, as plain text.
+pub fn explain(set: &RuleSet, rule: &RuleConfig) -> String {
+    let docs = docs(rule);
+    let mut out = format!(
+        "{}\n\nSeverity: {}\nEnabled by default: {}\nAutofix: {}\nSyntax mode: {}\n",
+        set.id(rule),
+        rule.severity,
+        yes_no(on_by_default(rule)),
+        yes_no(has_autofix(rule)),
+        yes_no(runs_on_syntax(rule))
+    );
+    if let Some(confidence) = &rule.confidence {
+        out.push_str(&format!("Confidence: {}\n", confidence));
+    }
+    if !rule.stage().is_stable() {
+        out.push_str(&format!("Stage: {}\n", rule.stage().as_str()));
+    }
+    if let Some(replacement) = &rule.replaced_by {
+        out.push_str(&format!("Replaced by: {}/{}\n", set.label, replacement));
+    }
+    out.push('\n');
+    out.push_str(docs.description.as_deref().unwrap_or(&rule.message));
+    out.push('\n');
+
+    let rationale = docs.rationale.as_deref().or(rule.suggestion.as_deref());
+    if let Some(rationale) = rationale {
+        out.push_str(&format!("\nWhy: {}\n", rationale));
+    }
+    for (title, example) in [("Bad", &docs.bad), ("Good", &docs.good)] {
+        if let Some(example) = example {
+            out.push_str(&format!("\n{}:\n", title));
+            for line in example.trim_end().lines() {
+                out.push_str(&format!("    {}\n", line));
+            }
+        }
+    }
+    if !docs.options.is_empty() {
+        out.push_str("\nOptions:\n");
+        for (name, description) in &docs.options {
+            out.push_str(&format!("  {}: {}\n", name, description));
+        }
+    }
+    out
+}
+
+/// A markdown page covering every rule, one section per rule set.
+pub fn markdown(sets: &[RuleSet]) -> String {
+    let mut out = String::from("# Compass Rules\n");
+    for set in sets {
+        out.push_str(&format!("\n## {}\n", set.title));
+        for rule in set.rules {
+            let docs = docs(rule);
+            out.push_str(&format!("\n### `{}`\n\n", set.id(rule)));
+            out.push_str(&format!(
+                "**Severity:** {} · **Enabled by default:** {} · **Autofix:** {} · **Syntax mode:** {}",
+                rule.severity,
+                yes_no(on_by_default(rule)),
+                yes_no(has_autofix(rule)),
+                yes_no(runs_on_syntax(rule))
+            ));
+            if !rule.stage().is_stable() {
+                out.push_str(&format!(" · **Stage:** {}", rule.stage().as_str()));
+            }
+            out.push_str("\n\n");
+            if let Some(replacement) = &rule.replaced_by {
+                out.push_str(&format!(
+                    "Deprecated in favor of `{}/{}`.\n\n",
+                    set.label, replacement
+                ));
+            }
+            out.push_str(docs.description.as_deref().unwrap_or(&rule.message));
+            out.push('\n');
+
+            let rationale = docs.rationale.as_deref().or(rule.suggestion.as_deref());
+            if let Some(rationale) = rationale {
+                out.push_str(&format!("\n**Why:** {}\n", rationale));
+            }
+            for (title, example) in [("Bad", &docs.bad), ("Good", &docs.good)] {
+                if let Some(example) = example {
+                    out.push_str(&format!(
+                        "\n**{}**\n\n```{}\n{}\n```\n",
+                        title,
+                        set.code_language,
+                        example.trim_end()
+                    ));
+                }
+            }
+            if !docs.options.is_empty() {
+                out.push_str("\n| Option | Description |\n| --- | --- |\n");
+                for (name, description) in &docs.options {
+                    out.push_str(&format!("| `{}` | {} |\n", name, description));
+                }
+            }
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::config::AnalyzerConfig;
+
+    const CONFIG: &str = r#"
+[[rules]]
+name = "panic_usage"
+check = "go_panic"
+severity = "warning"
+message = "Use of panic()"
+suggestion = "Return an error."
+enabled = true
+
+[rules.docs]
+description = "Flags calls to panic."
+bad = "panic(err)"
+good = "return err"
+
+[rules.docs.options]
+allow_functions = "Functions that may panic."
+
+[[rules]]
+name = "discarded_error"
+query = "(call_expression) @call"
+severity = "info"
+message = "Returned error is silently discarded"
+fix = { replacement = "_ = {text}", description = "Discard" }
+"#;
+
+    #[test]
+    fn test_docs_fall_back_to_message_and_suggestion() {
+        let config = AnalyzerConfig::from_str(CONFIG).unwrap();
+        let set = RuleSet {
+            label: "go",
+            title: "Go",
+            code_language: "go",
+            rules: &config.rules,
+        };
+
+        let panic = explain(&set, &config.rules[0]);
+        assert!(panic.starts_with("go/panic_usage\n"));
+        assert!(panic.contains("Flags calls to panic."));
+        assert!(panic.contains("Why: Return an error."));
+        assert!(panic.contains("Bad:\n    panic(err)\n"));
+        assert!(panic.contains("allow_functions: Functions that may panic."));
+
+        let discarded = explain(&set, &config.rules[1]);
+        assert!(discarded.contains("Autofix: yes"));
+        assert!(discarded.contains("Enabled by default: no"));
+        assert!(discarded.contains("Syntax mode: yes"));
+        assert!(discarded.contains("Returned error is silently discarded"));
+
+        let page = markdown(&[set]);
+        assert!(page.contains("### `go/panic_usage`"));
+        assert!(page.contains("```go\npanic(err)\n```"));
+        assert!(page.contains("| `allow_functions` | Functions that may panic. |"));
+    }
+}
@@ -0,0 +1,320 @@
+//! `compass dupes`: functions that were copied, and perhaps renamed, rather
+//! than shared.
+//!
+//! Each function body is reduced to its tokens, the leaves of its syntax
+//! tree without comments, with identifiers and literals replaced by their
+//! kind, so a copy whose variables, fields, types, calls and constants were
+//! renamed reads the same as the original. Functions whose tokens hash the
+//! same are clones, wherever they are: the same file, another package or
+//! another module. Bodies shorter than `min_tokens` are left out, since
+//! small functions such as getters look alike without being copies.
+//!
+//! The threshold is the `[dupes]` table of `.compass.toml` or
+//! `--min-tokens`; paths the config excludes are skipped as they are by
+//! every other command.
+
+use crate::analyzer::declaration_name_node;
+use crate::checks::node_text;
+use crate::complexity;
+use crate::fingerprint::content_hash;
+use serde::Serialize;
+use std::collections::HashMap;
+use tree_sitter::Node;
+
+/// The default `min_tokens`: about a dozen lines of Go.
+pub const DEFAULT_MIN_TOKENS: usize = 80;
+
+/// Literals that are one token whatever their text, including the parts of
+/// strings that tree-sitter splits into content and escapes.
+const LITERAL_KINDS: &[&str] = &[
+    "char_literal",
+    "float",
+    "float_literal",
+    "imaginary_literal",
+    "int_literal",
+    "integer",
+    "integer_literal",
+    "interpreted_string_literal",
+    "number",
+    "number_literal",
+    "raw_string_literal",
+    "rune_literal",
+    "string",
+    "string_literal",
+    "template_string",
+];
+
+/// One function, where it is.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct Fragment {
+    pub file: String,
+    pub function: String,
+    pub start_line: usize,
+    pub end_line: usize,
+    /// The function's source, for the side-by-side view.
+    #[serde(skip)]
+    pub text: String,
+}
+
+/// A function reduced to what clones have in common.
+#[derive(Debug, Clone)]
+pub struct Body {
+    pub fragment: Fragment,
+    pub tokens: usize,
+    pub digest: String,
+}
+
+/// Functions with the same normalized body, in path order.
+#[derive(Debug, Clone, Serialize)]
+pub struct Duplicate {
+    /// The hash of the shared tokens, which stays the same while the
+    /// copies do.
+    pub id: String,
+    pub tokens: usize,
+    pub fragments: Vec<Fragment>,
+}
+
+impl Duplicate {
+    /// The lines that sharing one copy would remove.
+    pub fn duplicated_lines(&self) -> usize {
+        self.fragments
+            .iter()
+            .skip(1)
+            .map(|fragment| fragment.end_line + 1 - fragment.start_line)
+            .sum()
+    }
+}
+
+/// The functions of one parsed file, measured the way
+/// [`complexity::functions`] finds them.
+pub fn bodies(file: &str, root: Node, source_code: &str) -> Vec<Body> {
+    complexity::functions(root, source_code)
+        .into_iter()
+        .filter_map(|function| {
+            let body = function.node.child_by_field_name("body")?;
+            let mut tokens = Vec::new();
+            collect_tokens(body, source_code, &mut tokens);
+            let start = function.node.start_position().row;
+            let end = function.node.end_position().row;
+            let text = source_code
+                .lines()
+                .skip(start)
+                .take(end + 1 - start)
+                .collect::<Vec<_>>()
+                .join("\n");
+            Some(Body {
+                fragment: Fragment {
+                    file: file.to_string(),
+                    function: function_name(function.node, &function.name, source_code),
+                    start_line: start + 1,
+                    end_line: end + 1,
+                    text,
+                },
+                tokens: tokens.len(),
+                digest: content_hash(&tokens.iter().map(String::as_str).collect::<Vec<_>>()),
+            })
+        })
+        .collect()
+}
+
+/// Groups the bodies of at least `min_tokens` tokens that have copies,
+/// largest first.
+pub fn duplicates(bodies: Vec<Body>, min_tokens: usize) -> Vec<Duplicate> {
+    let mut groups: HashMap<String, Vec<Body>> = HashMap::new();
+    for body in bodies.into_iter().filter(|body| body.tokens >= min_tokens) {
+        groups.entry(body.digest.clone()).or_default().push(body);
+    }
+    let mut found: Vec<Duplicate> = groups
+        .into_iter()
+        .filter(|(_, bodies)| bodies.len() > 1)
+        .map(|(id, bodies)| {
+            let tokens = bodies[0].tokens;
+            let mut fragments: Vec<Fragment> =
+                bodies.into_iter().map(|body| body.fragment).collect();
+            fragments.sort_by(|a, b| (&a.file, a.start_line).cmp(&(&b.file, b.start_line)));
+            Duplicate {
+                id,
+                tokens,
+                fragments,
+            }
+        })
+        .collect();
+    found.sort_by(|a, b| {
+        b.tokens.cmp(&a.tokens).then_with(|| {
+            let first = |duplicate: &Duplicate| {
+                let fragment = &duplicate.fragments[0];
+                (fragment.file.clone(), fragment.start_line)
+            };
+            first(a).cmp(&first(b))
+        })
+    });
+    found
+}
+
+/// The clones as text: each copy beside the first, with `|` between the
+/// lines that differ, as `diff --side-by-side` marks them.
+pub fn report(duplicates: &[Duplicate], width: usize) -> String {
+    if duplicates.is_empty() {
+        return "No duplicate functions\n".to_string();
+    }
+    let mut out = String::new();
+    for duplicate in duplicates {
+        out.push_str(&format!(
+            "{} copies of {} tokens ({}):\n",
+            duplicate.fragments.len(),
+            duplicate.tokens,
+            &duplicate.id[..12]
+        ));
+        let first = &duplicate.fragments[0];
+        for other in &duplicate.fragments[1..] {
+            out.push_str(&side_by_side(first, other, width));
+        }
+        out.push('\n');
+    }
+    let lines: usize = duplicates.iter().map(Duplicate::duplicated_lines).sum();
+    out.push_str(&format!(
+        "{} duplicate function(s), {} duplicated line(s)\n",
+        duplicates.len(),
+        lines
+    ));
+    out
+}
+
+/// `left` and `right` in columns `width` characters wide, under a line
+/// naming each.
+pub fn side_by_side(left: &Fragment, right: &Fragment, width: usize) -> String {
+    let heading = |fragment: &Fragment| {
+        format!(
+            "{}:{}-{} {}",
+            fragment.file, fragment.start_line, fragment.end_line, fragment.function
+        )
+    };
+    let mut out = format!("  {}   {}\n", column(&heading(left), width), heading(right));
+    let left_lines: Vec<&str> = left.text.lines().collect();
+    let right_lines: Vec<&str> = right.text.lines().collect();
+    for row in 0..left_lines.len().max(right_lines.len()) {
+        let a = left_lines.get(row).copied().unwrap_or_default();
+        let b = right_lines.get(row).copied().unwrap_or_default();
+        let marker = if a.trim() == b.trim() { ' ' } else { '|' };
+        let line = format!("  {} {} {}", column(a, width), marker, expand_tabs(b));
+        out.push_str(line.trim_end());
+        out.push('\n');
+    }
+    out
+}
+
+fn collect_tokens(node: Node, source_code: &str, tokens: &mut Vec<String>) {
+    if node.kind().contains("comment") {
+        return;
+    }
+    if let Some(token) = token(node.kind(), node.child_count(), || {
+        node_text(node, source_code)
+    }) {
+        tokens.push(token);
+        return;
+    }
+    let mut cursor = node.walk();
+    for child in node.children(&mut cursor) {
+        collect_tokens(child, source_code, tokens);
+    }
+}
+
+/// The token a node of `kind` stands for, or `None` when its children are
+/// the tokens.
+fn token<'s>(kind: &str, children: usize, text: impl FnOnce() -> &'s str) -> Option<String> {
+    if kind.ends_with("identifier") {
+        return Some("$id".to_string());
+    }
+    if LITERAL_KINDS.contains(&kind) {
+        return Some(format!("${}", kind));
+    }
+    (children == 0).then(|| text().to_string())
+}
+
+/// The function's name as Go tools write methods, `Type.Method`, when it
+/// has a receiver.
+fn function_name(function: Node, name: &str, source_code: &str) -> String {
+    let receiver = function
+        .child_by_field_name("receiver")
+        .and_then(|receiver| {
+            let mut cursor = receiver.walk();
+            let parameter = receiver.named_children(&mut cursor).next()?;
+            parameter.child_by_field_name("type")
+        })
+        .map(|receiver| node_text(receiver, source_code).trim_start_matches('*'));
+    match receiver {
+        Some(receiver) if declaration_name_node(function).is_some() => {
+            format!("{}.{}", receiver, name)
+        }
+        _ => name.to_string(),
+    }
+}
+
+fn column(text: &str, width: usize) -> String {
+    let text = expand_tabs(text);
+    let cut: String = text.chars().take(width).collect();
+    let padding = width - cut.chars().count();
+    cut + &" ".repeat(padding)
+}
+
+fn expand_tabs(text: &str) -> String {
+    text.replace('\t', "    ")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn body(file: &str, line: usize, text: &str, tokens: usize, digest: &str) -> Body {
+        Body {
+            fragment: Fragment {
+                file: file.to_string(),
+                function: "Load".to_string(),
+                start_line: line,
+                end_line: line + text.lines().count() - 1,
+                text: text.to_string(),
+            },
+            tokens,
+            digest: digest.to_string(),
+        }
+    }
+
+    #[test]
+    fn test_renamed_identifiers_and_literals_read_the_same() {
+        assert_eq!(token("identifier", 0, || "user"), Some("$id".to_string()));
+        assert_eq!(
+            token("field_identifier", 0, || "ID"),
+            Some("$id".to_string())
+        );
+        assert_eq!(
+            token("interpreted_string_literal", 3, || "\"users\""),
+            Some("$interpreted_string_literal".to_string())
+        );
+        assert_eq!(token("if", 0, || "if"), Some("if".to_string()));
+        assert_eq!(token("call_expression", 2, || "f(x)"), None);
+    }
+
+    #[test]
+    fn test_copies_are_grouped_above_the_threshold() {
+        let found = duplicates(
+            vec![
+                body("b.go", 3, "func b() {\n\treturn\n}", 90, "same"),
+                body("a.go", 10, "func a() {\n\treturn\n}", 90, "same"),
+                body("c.go", 1, "func c() {}", 90, "other"),
+                body("d.go", 1, "func d() {}", 10, "small"),
+                body("e.go", 1, "func e() {}", 10, "small"),
+            ],
+            50,
+        );
+        assert_eq!(found.len(), 1);
+        let files: Vec<&str> = found[0].fragments.iter().map(|f| f.file.as_str()).collect();
+        assert_eq!(files, ["a.go", "b.go"]);
+        assert_eq!(found[0].duplicated_lines(), 3);
+
+        let view = side_by_side(&found[0].fragments[0], &found[0].fragments[1], 14);
+        assert_eq!(
+            view,
+            "  a.go:10-12 Loa   b.go:3-5 Load\n  func a() {     | func b() {\n      return           return\n  }                }\n"
+        );
+    }
+}
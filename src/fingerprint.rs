@@ -0,0 +1,78 @@
+use crate::analyzer::AnalysisResult;
+use sha2::{Digest, Sha256};
+use std::collections::HashMap;
+
+/// Computes a stable fingerprint for every result in `results`.
+///
+/// The hash covers the rule name, the file path, the enclosing symbol and the
+/// matched text with whitespace collapsed, so it does not change when
+/// unrelated edits shift the finding up or down the file. Identical matches
+/// within the same symbol are disambiguated by their order of appearance.
+pub fn fingerprints(path: &str, results: &[AnalysisResult]) -> Vec<String> {
+    let mut seen: HashMap<String, usize> = HashMap::new();
+
+    results
+        .iter()
+        .map(|result| {
+            let symbol = result.symbol.as_deref().unwrap_or("");
+            let base = content_hash(&[&result.rule_name, path, symbol, &normalize(&result.text)]);
+            let occurrence = seen.entry(base.clone()).or_insert(0);
+            *occurrence += 1;
+            format!("{}:{}", base, occurrence)
+        })
+        .collect()
+}
+
+pub fn content_hash(parts: &[&str]) -> String {
+    let mut hasher = Sha256::new();
+    for part in parts {
+        hasher.update(part.as_bytes());
+        hasher.update([0u8]);
+    }
+
+    hasher
+        .finalize()
+        .iter()
+        .take(16)
+        .map(|byte| format!("{:02x}", byte))
+        .collect()
+}
+
+fn normalize(text: &str) -> String {
+    text.split_whitespace().collect::<Vec<_>>().join(" ")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analyzer::Severity;
+
+    fn result(line: usize, text: &str) -> AnalysisResult {
+        AnalysisResult {
+            rule_name: "panic_usage".to_string(),
+            severity: Severity::Warning,
+            message: "Use of panic()".to_string(),
+            line,
+            column: 5,
+            end_line: line,
+            end_column: 20,
+            text: text.to_string(),
+            symbol: Some("dangerous".to_string()),
+            score_impact: -1.6,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_fingerprint_ignores_line_shifts() {
+        let before = fingerprints("main.go", &[result(10, "panic(\"oh no\")")]);
+        let after = fingerprints("main.go", &[result(42, "panic(\"oh no\")")]);
+        assert_eq!(before, after);
+    }
+
+    #[test]
+    fn test_fingerprint_disambiguates_duplicates() {
+        let prints = fingerprints("main.go", &[result(3, "panic(x)"), result(9, "panic(x)")]);
+        assert_ne!(prints[0], prints[1]);
+    }
+}
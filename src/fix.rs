@@ -0,0 +1,481 @@
+use crate::analyzer::AnalysisResult;
+use serde::{Deserialize, Serialize};
+use std::cmp::Reverse;
+
+/// Replaces the bytes `start_byte..end_byte` of the original source.
+#[derive(Debug, Clone, PartialEq, Eq, Deserialize, Serialize)]
+pub struct TextEdit {
+    pub start_byte: usize,
+    pub end_byte: usize,
+    pub replacement: String,
+}
+
+/// A set of edits that must be applied together to resolve one finding.
+#[derive(Debug, Clone, Deserialize, Serialize)]
+pub struct Fix {
+    pub description: String,
+    pub edits: Vec<TextEdit>,
+}
+
+impl Fix {
+    fn span(&self) -> (usize, usize) {
+        let start = self.edits.iter().map(|e| e.start_byte).min().unwrap_or(0);
+        let end = self.edits.iter().map(|e| e.end_byte).max().unwrap_or(0);
+        (start, end)
+    }
+}
+
+/// How a query rule turns one of its captures into a fix.
+///
+/// `{text}` in the replacement expands to the captured node's source text, so
+/// `replacement = "_ = {text}"` discards a result and `replacement = ""`
+/// deletes the capture.
+#[derive(Debug, Clone, Deserialize, Serialize)]
+pub struct FixTemplate {
+    pub capture: Option<String>,
+    pub replacement: String,
+    pub description: Option<String>,
+}
+
+impl FixTemplate {
+    pub fn instantiate(&self, start_byte: usize, end_byte: usize, text: &str) -> Fix {
+        Fix {
+            description: self
+                .description
+                .clone()
+                .unwrap_or_else(|| "Apply suggested replacement".to_string()),
+            edits: vec![TextEdit {
+                start_byte,
+                end_byte,
+                replacement: self.replacement.replace("{text}", text),
+            }],
+        }
+    }
+}
+
+pub struct FixOutcome {
+    pub source: String,
+    pub applied: Vec<TextEdit>,
+    pub applied_count: usize,
+    /// Fixes that overlap another or don't fit the file, as `(rule, line)`.
+    pub skipped: Vec<(String, usize)>,
+    /// Each skipped fix that overlapped another, with that one.
+    pub conflicts: Vec<((String, usize), (String, usize))>,
+    /// Fixes the chooser of [`resolve_fixes`] turned down.
+    pub declined: Vec<(String, usize)>,
+}
+
+/// Which of two overlapping fixes is applied.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub enum Conflicts {
+    /// The one earlier in the file.
+    #[default]
+    First,
+    /// The one whose rule comes first in the list, then the one of higher
+    /// severity, then the more confident, then the earlier.
+    Priority(Vec<String>),
+    /// Neither: a fix that overlaps another is never applied.
+    Skip,
+}
+
+impl Conflicts {
+    pub const NAMES: &'static str = "first, priority, skip";
+
+    /// The strategy called `name`, with `priority` as the rule order for
+    /// `priority`.
+    pub fn from_name(name: &str, priority: Vec<String>) -> Option<Self> {
+        match name {
+            "first" => Some(Conflicts::First),
+            "priority" => Some(Conflicts::Priority(priority)),
+            "skip" => Some(Conflicts::Skip),
+            _ => None,
+        }
+    }
+}
+
+/// Applies every fix attached to `results` whose edits don't overlap a fix
+/// that was already accepted. Fixes are considered in source order; the
+/// later of two conflicting fixes is skipped and reported as `(rule, line)`.
+pub fn apply_fixes(source: &str, results: &[AnalysisResult]) -> FixOutcome {
+    resolve_fixes(source, results, &Conflicts::First, &mut |_, fix| {
+        Some(fix.clone())
+    })
+}
+
+/// Applies the fixes attached to `results`, settling overlaps as
+/// `conflicts` says. `choose` is offered each fix that can still be
+/// applied, in the order the strategy considers them, and returns the fix
+/// to apply, which it may have edited, or `None` to decline it. A fix the
+/// chooser declines doesn't block the ones that overlap it.
+pub fn resolve_fixes(
+    source: &str,
+    results: &[AnalysisResult],
+    conflicts: &Conflicts,
+    choose: &mut dyn FnMut(&AnalysisResult, &Fix) -> Option<Fix>,
+) -> FixOutcome {
+    let mut candidates: Vec<&AnalysisResult> = results
+        .iter()
+        .filter(|result| result.fix.is_some())
+        .collect();
+    candidates.sort_by_key(|result| result.fix.as_ref().map(Fix::span));
+    if let Conflicts::Priority(rules) = conflicts {
+        // A stable sort keeps source order among equals.
+        candidates.sort_by_key(|result| {
+            (
+                rules
+                    .iter()
+                    .position(|rule| *rule == result.rule_name)
+                    .unwrap_or(rules.len()),
+                Reverse(result.severity.rank()),
+                Reverse(result.confidence.rank()),
+            )
+        });
+    }
+    let id = |result: &AnalysisResult| (result.rule_name.clone(), result.line);
+
+    let mut accepted: Vec<TextEdit> = Vec::new();
+    // Who made each accepted edit, for reporting conflicts.
+    let mut owners: Vec<(String, usize)> = Vec::new();
+    let mut outcome = FixOutcome {
+        source: String::new(),
+        applied: Vec::new(),
+        applied_count: 0,
+        skipped: Vec::new(),
+        conflicts: Vec::new(),
+        declined: Vec::new(),
+    };
+
+    for (index, result) in candidates.iter().enumerate() {
+        let fix = result.fix.as_ref().expect("filtered on fix presence");
+        if fix.edits.iter().all(|edit| accepted.contains(edit)) {
+            continue;
+        }
+        if !fix.edits.iter().all(|edit| in_bounds(source, edit)) {
+            outcome.skipped.push(id(result));
+            continue;
+        }
+        if *conflicts == Conflicts::Skip {
+            let other = candidates.iter().enumerate().find(|(other, candidate)| {
+                *other != index
+                    && candidate
+                        .fix
+                        .as_ref()
+                        .is_some_and(|other| fixes_overlap(fix, other))
+            });
+            if let Some((_, other)) = other {
+                outcome.skipped.push(id(result));
+                outcome.conflicts.push((id(result), id(other)));
+                continue;
+            }
+        }
+        if let Some(owner) = blocking(fix, &accepted, &owners) {
+            outcome.skipped.push(id(result));
+            outcome.conflicts.push((id(result), owner));
+            continue;
+        }
+
+        let Some(chosen) = choose(result, fix) else {
+            outcome.declined.push(id(result));
+            continue;
+        };
+        // An edited fix may no longer fit.
+        if !chosen.edits.iter().all(|edit| in_bounds(source, edit)) {
+            outcome.skipped.push(id(result));
+            continue;
+        }
+        if let Some(owner) = blocking(&chosen, &accepted, &owners) {
+            outcome.skipped.push(id(result));
+            outcome.conflicts.push((id(result), owner));
+            continue;
+        }
+
+        // An edit several fixes share, such as adding the same import, is
+        // made once and doesn't conflict with itself.
+        for edit in chosen.edits {
+            if !accepted.contains(&edit) {
+                accepted.push(edit);
+                owners.push(id(result));
+            }
+        }
+        outcome.applied_count += 1;
+    }
+
+    accepted.sort_by_key(|edit| (edit.start_byte, edit.end_byte));
+    outcome.source = apply_edits(source, &accepted);
+    outcome.applied = accepted;
+    outcome
+}
+
+/// The source with `edits`, sorted and not overlapping, made.
+pub fn apply_edits(source: &str, edits: &[TextEdit]) -> String {
+    let mut fixed = String::with_capacity(source.len());
+    let mut cursor = 0;
+    for edit in edits {
+        fixed.push_str(&source[cursor..edit.start_byte]);
+        fixed.push_str(&edit.replacement);
+        cursor = edit.end_byte;
+    }
+    fixed.push_str(&source[cursor..]);
+    fixed
+}
+
+/// The fix that made an accepted edit `fix`'s new edits overlap.
+fn blocking(
+    fix: &Fix,
+    accepted: &[TextEdit],
+    owners: &[(String, usize)],
+) -> Option<(String, usize)> {
+    fix.edits
+        .iter()
+        .filter(|edit| !accepted.contains(edit))
+        .find_map(|edit| accepted.iter().position(|other| overlaps(edit, other)))
+        .map(|index| owners[index].clone())
+}
+
+/// Whether two fixes overlap, apart from edits they share.
+fn fixes_overlap(a: &Fix, b: &Fix) -> bool {
+    let same = a.edits.len() == b.edits.len() && a.edits.iter().all(|edit| b.edits.contains(edit));
+    !same
+        && a.edits.iter().any(|edit| {
+            b.edits
+                .iter()
+                .any(|other| edit != other && overlaps(edit, other))
+        })
+}
+
+fn overlaps(a: &TextEdit, b: &TextEdit) -> bool {
+    // Two pure insertions at the same point would be order dependent.
+    if a.start_byte == a.end_byte && b.start_byte == b.end_byte {
+        return a.start_byte == b.start_byte;
+    }
+    a.start_byte < b.end_byte && b.start_byte < a.end_byte
+}
+
+fn in_bounds(source: &str, edit: &TextEdit) -> bool {
+    edit.start_byte <= edit.end_byte
+        && edit.end_byte <= source.len()
+        && source.is_char_boundary(edit.start_byte)
+        && source.is_char_boundary(edit.end_byte)
+}
+
+const DIFF_CONTEXT: usize = 3;
+
+/// Renders the accepted edits of a fix run as a unified diff.
+pub fn unified_diff(path: &str, source: &str, edits: &[TextEdit]) -> String {
+    if edits.is_empty() {
+        return String::new();
+    }
+
+    let line_starts: Vec<usize> = std::iter::once(0)
+        .chain(source.match_indices('\n').map(|(i, _)| i + 1))
+        .collect();
+    let line_of = |byte: usize| match line_starts.binary_search(&byte) {
+        Ok(line) => line,
+        Err(next) => next - 1,
+    };
+    let old_lines: Vec<&str> = source.split_inclusive('\n').collect();
+
+    // Group edits into hunks of whole lines. Edits close enough for their
+    // context to overlap share a hunk so the diff stays applicable.
+    let mut groups: Vec<(usize, usize, Vec<&TextEdit>)> = Vec::new();
+    for edit in edits {
+        let first = line_of(edit.start_byte);
+        let last = line_of(edit.end_byte.saturating_sub(1).max(edit.start_byte));
+        match groups.last_mut() {
+            Some((_, group_last, members)) if first <= *group_last + 2 * DIFF_CONTEXT + 1 => {
+                *group_last = (*group_last).max(last);
+                members.push(edit);
+            }
+            _ => groups.push((first, last, vec![edit])),
+        }
+    }
+
+    let mut out = format!("--- a/{}\n+++ b/{}\n", path, path);
+    let mut line_delta: isize = 0;
+    for (first, last, members) in groups {
+        let last = last.min(old_lines.len().saturating_sub(1));
+        let region_start = line_starts[first];
+        let region_end = line_starts.get(last + 1).copied().unwrap_or(source.len());
+
+        let mut replaced = String::new();
+        let mut cursor = region_start;
+        for edit in members {
+            replaced.push_str(&source[cursor..edit.start_byte]);
+            replaced.push_str(&edit.replacement);
+            cursor = edit.end_byte;
+        }
+        replaced.push_str(&source[cursor..region_end]);
+
+        let removed = &old_lines[first..=last];
+        let added: Vec<&str> = replaced.split_inclusive('\n').collect();
+        let before_start = first.saturating_sub(DIFF_CONTEXT);
+        let after_end = (last + 1 + DIFF_CONTEXT).min(old_lines.len());
+        let before = &old_lines[before_start..first];
+        let after = &old_lines[last + 1..after_end];
+
+        let old_count = before.len() + removed.len() + after.len();
+        let new_count = before.len() + added.len() + after.len();
+        let new_start = before_start as isize + line_delta;
+        out.push_str(&format!(
+            "@@ -{},{} +{},{} @@\n",
+            before_start + 1,
+            old_count,
+            new_start + 1,
+            new_count
+        ));
+        push_lines(&mut out, ' ', before);
+        push_lines(&mut out, '-', removed);
+        push_lines(&mut out, '+', &added);
+        push_lines(&mut out, ' ', after);
+
+        line_delta += added.len() as isize - removed.len() as isize;
+    }
+
+    out
+}
+
+fn push_lines(out: &mut String, marker: char, lines: &[&str]) {
+    for line in lines {
+        out.push(marker);
+        out.push_str(line.trim_end_matches('\n'));
+        out.push('\n');
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analyzer::Severity;
+
+    fn fixable(rule: &str, start: usize, end: usize, replacement: &str) -> AnalysisResult {
+        AnalysisResult {
+            rule_name: rule.to_string(),
+            line: 1,
+            fix: Some(Fix {
+                description: "test".to_string(),
+                edits: vec![TextEdit {
+                    start_byte: start,
+                    end_byte: end,
+                    replacement: replacement.to_string(),
+                }],
+            }),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_apply_non_overlapping_fixes() {
+        let source = "a b c";
+        let results = vec![fixable("r1", 0, 1, "x"), fixable("r2", 4, 5, "z")];
+        let outcome = apply_fixes(source, &results);
+        assert_eq!(outcome.source, "x b z");
+        assert_eq!(outcome.applied_count, 2);
+        assert!(outcome.skipped.is_empty());
+    }
+
+    #[test]
+    fn test_overlapping_fix_is_skipped() {
+        let source = "abcdef";
+        let results = vec![fixable("r1", 0, 3, "X"), fixable("r2", 2, 5, "Y")];
+        let outcome = apply_fixes(source, &results);
+        assert_eq!(outcome.source, "Xdef");
+        assert_eq!(outcome.skipped, vec![("r2".to_string(), 1)]);
+    }
+
+    #[test]
+    fn test_identical_fix_from_two_captures_applies_once() {
+        let source = "f()";
+        let results = vec![
+            fixable("r1", 0, 3, "_ = f()"),
+            fixable("r1", 0, 3, "_ = f()"),
+        ];
+        let outcome = apply_fixes(source, &results);
+        assert_eq!(outcome.source, "_ = f()");
+        assert!(outcome.skipped.is_empty());
+    }
+
+    #[test]
+    fn test_shared_edit_is_made_once() {
+        let source = "package p\n\nx\ny\n";
+        let import = TextEdit {
+            start_byte: 10,
+            end_byte: 10,
+            replacement: "import \"errors\"\n".to_string(),
+        };
+        let results: Vec<AnalysisResult> = [(11, "X"), (13, "Y")]
+            .into_iter()
+            .map(|(start, replacement)| {
+                let mut result = fixable("r1", start, start + 1, replacement);
+                if let Some(fix) = result.fix.as_mut() {
+                    fix.edits.push(import.clone());
+                }
+                result
+            })
+            .collect();
+        let outcome = apply_fixes(source, &results);
+        assert_eq!(outcome.source, "package p\nimport \"errors\"\n\nX\nY\n");
+        assert_eq!(outcome.applied_count, 2);
+    }
+
+    #[test]
+    fn test_priority_prefers_listed_rule_then_severity() {
+        let source = "abcdef";
+        let mut results = vec![fixable("r1", 0, 3, "X"), fixable("r2", 2, 5, "Y")];
+        let priority = Conflicts::Priority(vec!["r2".to_string()]);
+        let outcome = resolve_fixes(source, &results, &priority, &mut |_, fix| Some(fix.clone()));
+        assert_eq!(outcome.source, "abYf");
+        assert_eq!(
+            outcome.conflicts,
+            vec![(("r1".to_string(), 1), ("r2".to_string(), 1))]
+        );
+
+        results[0].severity = Severity::Error;
+        results[1].severity = Severity::Warning;
+        let by_severity = Conflicts::Priority(Vec::new());
+        let outcome = resolve_fixes(source, &results, &by_severity, &mut |_, fix| {
+            Some(fix.clone())
+        });
+        assert_eq!(outcome.source, "Xdef");
+    }
+
+    #[test]
+    fn test_skip_drops_both_sides_of_a_conflict() {
+        let source = "abcdef";
+        let results = vec![
+            fixable("r1", 0, 3, "X"),
+            fixable("r2", 2, 5, "Y"),
+            fixable("r3", 5, 6, "Z"),
+        ];
+        let outcome = resolve_fixes(source, &results, &Conflicts::Skip, &mut |_, fix| {
+            Some(fix.clone())
+        });
+        assert_eq!(outcome.source, "abcdeZ");
+        assert_eq!(
+            outcome.skipped,
+            vec![("r1".to_string(), 1), ("r2".to_string(), 1)]
+        );
+    }
+
+    #[test]
+    fn test_declined_fix_does_not_block_others() {
+        let source = "abcdef";
+        let results = vec![fixable("r1", 0, 3, "X"), fixable("r2", 2, 5, "Y")];
+        let outcome = resolve_fixes(source, &results, &Conflicts::First, &mut |result, fix| {
+            (result.rule_name != "r1").then(|| fix.clone())
+        });
+        assert_eq!(outcome.source, "abYf");
+        assert_eq!(outcome.declined, vec![("r1".to_string(), 1)]);
+        assert!(outcome.skipped.is_empty());
+    }
+
+    #[test]
+    fn test_unified_diff() {
+        let source = "one\ntwo\nthree\n";
+        let outcome = apply_fixes(source, &[fixable("r1", 4, 8, "")]);
+        let diff = unified_diff("x.go", source, &outcome.applied);
+        assert_eq!(
+            diff,
+            "--- a/x.go\n+++ b/x.go\n@@ -1,3 +1,2 @@\n one\n-two\n three\n"
+        );
+    }
+}
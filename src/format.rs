@@ -0,0 +1,166 @@
+pub mod bitbucket;
+pub mod checkstyle;
+pub mod github;
+pub mod gitlab;
+pub mod html;
+pub mod json;
+pub mod junit;
+pub mod rdjson;
+pub mod sarif;
+pub mod text;
+
+use crate::analyzer::AnalysisResult;
+use serde::Serialize;
+use std::env;
+use std::io::{self, Write};
+use std::path::Path;
+
+/// The findings reported for one analyzed file.
+pub struct FileFindings {
+    pub path: String,
+    /// The path of the Go module the file belongs to, if any.
+    pub module: Option<String>,
+    /// The file's owners from `CODEOWNERS`, if any.
+    pub owners: Vec<String>,
+    pub results: Vec<AnalysisResult>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum OutputFormat {
+    /// The score report with a short header, for people and LLMs.
+    Score,
+    /// The versioned findings schema in [`json`].
+    Json,
+    Sarif,
+    /// GitHub Actions workflow commands, shown as annotations on the diff.
+    Github,
+    /// A self-contained page for sharing, see [`html`].
+    Html,
+    /// Checkstyle XML, for CI report publishers.
+    Checkstyle,
+    /// JUnit XML, one test suite per file.
+    Junit,
+    /// GitLab's Code Quality report, for merge request widgets.
+    GitlabCodeQuality,
+    /// Bitbucket Code Insights, a report and its annotations.
+    Bitbucket,
+    /// Reviewdog's diagnostics, as one document or a line each; see
+    /// [`rdjson`].
+    Rdjson,
+    Rdjsonl,
+    /// Findings with their source excerpts, for terminals; see [`text`].
+    Text,
+    /// Rule documentation as a markdown page; only for `compass rules`.
+    Markdown,
+    /// Graphviz DOT; only for `compass callgraph`.
+    Dot,
+}
+
+impl OutputFormat {
+    pub fn from_name(name: &str) -> Option<Self> {
+        match name.to_ascii_lowercase().as_str() {
+            "score" => Some(OutputFormat::Score),
+            "json" => Some(OutputFormat::Json),
+            "sarif" => Some(OutputFormat::Sarif),
+            "github" => Some(OutputFormat::Github),
+            "html" => Some(OutputFormat::Html),
+            "checkstyle" => Some(OutputFormat::Checkstyle),
+            "junit" => Some(OutputFormat::Junit),
+            "gitlab-codequality" => Some(OutputFormat::GitlabCodeQuality),
+            "bitbucket" => Some(OutputFormat::Bitbucket),
+            "rdjson" => Some(OutputFormat::Rdjson),
+            "rdjsonl" => Some(OutputFormat::Rdjsonl),
+            "text" => Some(OutputFormat::Text),
+            "markdown" => Some(OutputFormat::Markdown),
+            "dot" => Some(OutputFormat::Dot),
+            _ => None,
+        }
+    }
+
+    pub fn names() -> &'static str {
+        "score, json, sarif, github, html, checkstyle, junit, gitlab-codequality, bitbucket, rdjson, rdjsonl, text, markdown, dot"
+    }
+}
+
+/// Writes `value` as `serde_json::to_string_pretty` lays it out `indent`
+/// spaces deep in a document. The first line isn't indented, as it follows
+/// its key or the start of its line.
+pub fn write_nested(out: &mut impl Write, value: &impl Serialize, indent: usize) -> io::Result<()> {
+    let json = serde_json::to_string_pretty(value).map_err(io::Error::other)?;
+    let pad = " ".repeat(indent);
+    for (i, line) in json.lines().enumerate() {
+        if i > 0 {
+            writeln!(out)?;
+            out.write_all(pad.as_bytes())?;
+        }
+        out.write_all(line.as_bytes())?;
+    }
+    Ok(())
+}
+
+/// A JSON array `indent` spaces deep, written an element at a time, so a
+/// streamed report reads exactly as one serialized whole.
+pub struct JsonArray {
+    indent: usize,
+    len: usize,
+}
+
+impl JsonArray {
+    pub fn new(indent: usize) -> Self {
+        JsonArray { indent, len: 0 }
+    }
+
+    pub fn push(&mut self, out: &mut impl Write, value: &impl Serialize) -> io::Result<()> {
+        out.write_all(if self.len == 0 { b"[\n" } else { b",\n" })?;
+        out.write_all(" ".repeat(self.indent + 2).as_bytes())?;
+        self.len += 1;
+        write_nested(out, value, self.indent + 2)
+    }
+
+    pub fn close(self, out: &mut impl Write) -> io::Result<()> {
+        if self.len == 0 {
+            return out.write_all(b"[]");
+        }
+        write!(out, "\n{}]", " ".repeat(self.indent))
+    }
+}
+
+/// `path` relative to the working directory when it is below it.
+fn relative(path: &str) -> String {
+    let relative = env::current_dir().ok().and_then(|cwd| {
+        Path::new(path)
+            .strip_prefix(cwd)
+            .ok()
+            .map(Path::to_path_buf)
+    });
+    match relative {
+        Some(relative) if Path::new(path).is_absolute() => relative.display().to_string(),
+        _ => path.to_string(),
+    }
+}
+
+/// `path` as code hosts name files: relative to the working directory,
+/// taken to be the repository's root, with forward slashes and no `./`.
+fn repository_path(path: &str) -> String {
+    relative(path).trim_start_matches("./").replace('\\', "/")
+}
+
+/// Escapes text for HTML and XML, in content and in quoted attributes.
+/// Control characters other than tabs and line breaks aren't allowed in
+/// XML even as references, so they are dropped.
+fn escape(text: &str) -> String {
+    let mut escaped = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '&' => escaped.push_str("&amp;"),
+            '<' => escaped.push_str("&lt;"),
+            '>' => escaped.push_str("&gt;"),
+            '"' => escaped.push_str("&quot;"),
+            '\'' => escaped.push_str("&#39;"),
+            '\t' | '\n' | '\r' => escaped.push(c),
+            _ if c.is_control() => {}
+            _ => escaped.push(c),
+        }
+    }
+    escaped
+}
@@ -0,0 +1,153 @@
+//! Bitbucket Code Insights: a report for the commit and annotations on the
+//! lines of its pull request.
+//!
+//! Bitbucket takes the two in separate requests, so the output is one
+//! object with both, to split with `jq`: `report` is the body of
+//! `PUT .../commit/{commit}/reports/compass`, and `annotations` the body of
+//! `POST .../reports/compass/annotations`, at most 100 per request.
+//! Bitbucket keeps no more than 1000 annotations per report, so beyond that
+//! the most severe findings are annotated and the report counts the rest.
+//!
+//! Each annotation's `external_id` is the finding's fingerprint, the one
+//! baselines use, which stays the same when a rebase moves the line.
+
+use crate::analyzer::{AnalysisResult, Severity};
+use crate::fingerprint::fingerprints;
+use crate::format::{repository_path, FileFindings};
+use serde_json::{json, Value};
+use std::cmp::Reverse;
+
+/// The most annotations Bitbucket keeps for one report.
+pub const MAX_ANNOTATIONS: usize = 1000;
+
+/// Bitbucket cuts summaries longer than this.
+const MAX_SUMMARY: usize = 450;
+
+/// The report and annotations for `files`; `failed` is whether the
+/// findings fail the build, by `--fail-on`.
+pub fn to_bitbucket(files: &[FileFindings], failed: bool) -> Value {
+    let mut annotations: Vec<(&Severity, Value)> = Vec::new();
+    for file in files {
+        let path = repository_path(&file.path);
+        let prints = fingerprints(&path, &file.results);
+        for (result, print) in file.results.iter().zip(prints) {
+            annotations.push((&result.severity, annotation(&path, result, print)));
+        }
+    }
+    let total = annotations.len();
+    annotations.sort_by_key(|(severity, _)| Reverse(severity.rank()));
+    annotations.truncate(MAX_ANNOTATIONS);
+
+    let count = |severity: Severity| {
+        files
+            .iter()
+            .flat_map(|file| &file.results)
+            .filter(|result| result.severity == severity)
+            .count()
+    };
+    let analyzed = match files.len() {
+        1 => "1 file".to_string(),
+        count => format!("{} files", count),
+    };
+    let mut details = match total {
+        0 => format!("No findings in {}.", analyzed),
+        1 => format!("1 finding in {}.", analyzed),
+        _ => format!("{} findings in {}.", total, analyzed),
+    };
+    if total > MAX_ANNOTATIONS {
+        details.push_str(&format!(
+            " The {} most severe are annotated.",
+            MAX_ANNOTATIONS
+        ));
+    }
+    json!({
+        "report": {
+            "title": "Compass",
+            "details": details,
+            "report_type": "BUG",
+            "reporter": "compass",
+            "result": if failed { "FAILED" } else { "PASSED" },
+            "data": [
+                { "title": "Errors", "type": "NUMBER", "value": count(Severity::Error) },
+                { "title": "Warnings", "type": "NUMBER", "value": count(Severity::Warning) },
+                { "title": "Info", "type": "NUMBER", "value": count(Severity::Info) },
+                { "title": "Style", "type": "NUMBER", "value": count(Severity::Style) }
+            ]
+        },
+        "annotations": annotations.into_iter().map(|(_, annotation)| annotation).collect::<Vec<_>>()
+    })
+}
+
+fn annotation(path: &str, result: &AnalysisResult, fingerprint: String) -> Value {
+    let mut summary = format!("{}: {}", result.rule_name, result.message);
+    if summary.chars().count() > MAX_SUMMARY {
+        summary = summary.chars().take(MAX_SUMMARY - 1).collect::<String>() + "…";
+    }
+    let mut annotation = json!({
+        "external_id": fingerprint,
+        "annotation_type": if result.severity == Severity::Error { "BUG" } else { "CODE_SMELL" },
+        "path": path,
+        "line": result.line,
+        "summary": summary,
+        "severity": severity(&result.severity),
+        "result": "FAILED"
+    });
+    if let Some(suggestion) = &result.suggestion {
+        annotation["details"] = json!(suggestion);
+    }
+    if let Some(url) = &result.url {
+        annotation["link"] = json!(url);
+    }
+    annotation
+}
+
+fn severity(severity: &Severity) -> &'static str {
+    match severity {
+        Severity::Error => "HIGH",
+        Severity::Warning => "MEDIUM",
+        Severity::Info | Severity::Style => "LOW",
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn result(severity: Severity, line: usize) -> AnalysisResult {
+        AnalysisResult {
+            rule_name: "panic_usage".to_string(),
+            severity,
+            message: "Use of panic()".to_string(),
+            line,
+            text: format!("panic({})", line),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_the_most_severe_findings_are_annotated() {
+        let mut results = vec![result(Severity::Style, 1); MAX_ANNOTATIONS];
+        results.push(result(Severity::Error, 7));
+        let files = [FileFindings {
+            path: "./main.go".to_string(),
+            module: None,
+            owners: Vec::new(),
+            results,
+        }];
+        let insights = to_bitbucket(&files, true);
+
+        let report = &insights["report"];
+        assert_eq!(report["result"], "FAILED");
+        assert_eq!(
+            report["details"],
+            "1001 findings in 1 file. The 1000 most severe are annotated."
+        );
+        assert_eq!(report["data"][0]["value"], 1);
+        let annotations = insights["annotations"].as_array().unwrap();
+        assert_eq!(annotations.len(), MAX_ANNOTATIONS);
+        assert_eq!(annotations[0]["line"], 7);
+        assert_eq!(annotations[0]["annotation_type"], "BUG");
+        assert_eq!(annotations[0]["path"], "main.go");
+        assert_eq!(annotations[0]["summary"], "panic_usage: Use of panic()");
+    }
+}
@@ -0,0 +1,115 @@
+//! Checkstyle XML, which Jenkins' warnings plugin, Bamboo and most other CI
+//! report publishers read.
+//!
+//! Every analyzed file is listed, with or without findings, so publishers
+//! can tell a clean file from one that wasn't analyzed. Checkstyle has no
+//! style level, so style findings are reported as `info`. The rule id goes
+//! in `source` as `compass.<rule>`, the dotted form checkstyle's own checks
+//! use.
+
+use crate::analyzer::Severity;
+use crate::format::{escape, FileFindings};
+use std::io::{self, Write};
+
+const HEADER: &str = "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<checkstyle version=\"4.3\">\n";
+const FOOTER: &str = "</checkstyle>\n";
+
+pub fn to_checkstyle(files: &[FileFindings]) -> String {
+    let mut xml = String::from(HEADER);
+    for file in files {
+        xml.push_str(&file_element(file));
+    }
+    xml.push_str(FOOTER);
+    xml
+}
+
+/// Writes the same report as [`to_checkstyle`] a file at a time.
+pub struct CheckstyleWriter<W: Write> {
+    out: W,
+}
+
+impl<W: Write> CheckstyleWriter<W> {
+    pub fn new(mut out: W) -> io::Result<Self> {
+        out.write_all(HEADER.as_bytes())?;
+        Ok(CheckstyleWriter { out })
+    }
+
+    pub fn file(&mut self, file: &FileFindings) -> io::Result<()> {
+        self.out.write_all(file_element(file).as_bytes())
+    }
+
+    pub fn finish(mut self) -> io::Result<W> {
+        self.out.write_all(FOOTER.as_bytes())?;
+        Ok(self.out)
+    }
+}
+
+fn file_element(file: &FileFindings) -> String {
+    if file.results.is_empty() {
+        return format!("  <file name=\"{}\"/>\n", escape(&file.path));
+    }
+    let mut xml = format!("  <file name=\"{}\">\n", escape(&file.path));
+    for result in &file.results {
+        xml.push_str(&format!(
+            "    <error line=\"{}\" column=\"{}\" severity=\"{}\" message=\"{}\" source=\"compass.{}\"/>\n",
+            result.line,
+            result.column,
+            severity(&result.severity),
+            escape(&result.message),
+            escape(&result.rule_name)
+        ));
+    }
+    xml.push_str("  </file>\n");
+    xml
+}
+
+fn severity(severity: &Severity) -> &'static str {
+    match severity {
+        Severity::Error => "error",
+        Severity::Warning => "warning",
+        Severity::Info | Severity::Style => "info",
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analyzer::AnalysisResult;
+
+    #[test]
+    fn test_checkstyle_lists_every_file() {
+        let files = [
+            FileFindings {
+                path: "main.go".to_string(),
+                module: None,
+                owners: Vec::new(),
+                results: vec![AnalysisResult {
+                    rule_name: "panic_usage".to_string(),
+                    severity: Severity::Style,
+                    message: "Avoid \"panic\" <here>".to_string(),
+                    line: 4,
+                    column: 2,
+                    ..Default::default()
+                }],
+            },
+            FileFindings {
+                path: "clean.go".to_string(),
+                module: None,
+                owners: Vec::new(),
+                results: Vec::new(),
+            },
+        ];
+        assert_eq!(
+            to_checkstyle(&files),
+            concat!(
+                "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n",
+                "<checkstyle version=\"4.3\">\n",
+                "  <file name=\"main.go\">\n",
+                "    <error line=\"4\" column=\"2\" severity=\"info\" message=\"Avoid &quot;panic&quot; &lt;here&gt;\" source=\"compass.panic_usage\"/>\n",
+                "  </file>\n",
+                "  <file name=\"clean.go\"/>\n",
+                "</checkstyle>\n"
+            )
+        );
+    }
+}
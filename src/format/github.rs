@@ -0,0 +1,272 @@
+//! GitHub Actions workflow commands.
+//!
+//! Each finding becomes an `::error`, `::warning` or `::notice` command,
+//! which the runner turns into an annotation on the pull request diff.
+//! GitHub shows at most [`ANNOTATIONS_PER_LEVEL`] annotations of each level
+//! per step and drops the rest, so findings past the limit are printed in a
+//! collapsed log group instead and listed in the job summary.
+
+use crate::analyzer::{AnalysisResult, Severity};
+use crate::format::FileFindings;
+use std::io::{self, Write};
+
+pub const ANNOTATIONS_PER_LEVEL: usize = 10;
+
+pub struct Workflow {
+    /// The workflow commands, for stdout.
+    pub commands: String,
+    /// Markdown for `$GITHUB_STEP_SUMMARY`, when findings had to be left
+    /// out of the annotations.
+    pub summary: Option<String>,
+}
+
+pub fn to_workflow(files: &[FileFindings], limit: usize) -> Workflow {
+    let mut writer = WorkflowWriter::new(Vec::new(), limit);
+    for file in files {
+        writer.file(file).expect("writing to memory cannot fail");
+    }
+    let (commands, summary) = writer.finish().expect("writing to memory cannot fail");
+    Workflow {
+        commands: String::from_utf8(commands).expect("commands are UTF-8"),
+        summary,
+    }
+}
+
+/// Writes the same commands as [`to_workflow`] a file at a time. Only the
+/// lines of findings past the limit are kept, for the group and summary
+/// at the end.
+pub struct WorkflowWriter<W: Write> {
+    out: W,
+    limit: usize,
+    annotated: [usize; 3],
+    /// For each finding left out, its line in the log group and its row
+    /// in the summary.
+    omitted: Vec<(String, String)>,
+}
+
+impl<W: Write> WorkflowWriter<W> {
+    pub fn new(out: W, limit: usize) -> Self {
+        WorkflowWriter {
+            out,
+            limit,
+            annotated: [0; 3],
+            omitted: Vec::new(),
+        }
+    }
+
+    pub fn file(&mut self, file: &FileFindings) -> io::Result<()> {
+        for result in &file.results {
+            let level = level(&result.severity);
+            let count = &mut self.annotated[level as usize];
+            if *count < self.limit {
+                *count += 1;
+                self.out
+                    .write_all(command(level, &file.path, result).as_bytes())?;
+            } else {
+                self.omitted.push(omitted(&file.path, result));
+            }
+        }
+        Ok(())
+    }
+
+    /// Writes the group of findings past the limit, and returns the output
+    /// and the job summary, if any were.
+    pub fn finish(mut self) -> io::Result<(W, Option<String>)> {
+        if self.omitted.is_empty() {
+            return Ok((self.out, None));
+        }
+
+        writeln!(
+            self.out,
+            "::group::compass: {} more finding(s) not annotated",
+            self.omitted.len()
+        )?;
+        for (line, _) in &self.omitted {
+            self.out.write_all(line.as_bytes())?;
+        }
+        self.out.write_all(b"::endgroup::\n")?;
+
+        let summary = summary(&self.omitted, self.annotated.iter().sum());
+        Ok((self.out, Some(summary)))
+    }
+}
+
+#[derive(Clone, Copy)]
+enum Level {
+    Error,
+    Warning,
+    Notice,
+}
+
+impl Level {
+    fn name(self) -> &'static str {
+        match self {
+            Level::Error => "error",
+            Level::Warning => "warning",
+            Level::Notice => "notice",
+        }
+    }
+}
+
+fn level(severity: &Severity) -> Level {
+    match severity {
+        Severity::Error => Level::Error,
+        Severity::Warning => Level::Warning,
+        Severity::Info | Severity::Style => Level::Notice,
+    }
+}
+
+fn command(level: Level, path: &str, result: &AnalysisResult) -> String {
+    let mut properties = vec![
+        ("file", workspace_path(path)),
+        ("line", result.line.to_string()),
+        ("endLine", result.end_line.to_string()),
+    ];
+    // Columns are only honoured for single-line annotations.
+    if result.line == result.end_line {
+        properties.push(("col", result.column.to_string()));
+        properties.push(("endColumn", result.end_column.to_string()));
+    }
+    properties.push(("title", format!("compass: {}", result.rule_name)));
+
+    let properties: Vec<String> = properties
+        .iter()
+        .map(|(name, value)| format!("{}={}", name, escape_property(value)))
+        .collect();
+    let mut message = result.message.clone();
+    if let Some(suggestion) = &result.suggestion {
+        message.push('\n');
+        message.push_str(suggestion);
+    }
+    if let Some(url) = &result.url {
+        message.push('\n');
+        message.push_str(url);
+    }
+    format!(
+        "::{} {}::{}\n",
+        level.name(),
+        properties.join(","),
+        escape_data(&message)
+    )
+}
+
+fn omitted(path: &str, result: &AnalysisResult) -> (String, String) {
+    let line = format!(
+        "{}:{}:{}: {} {}: {}\n",
+        workspace_path(path),
+        result.line,
+        result.column,
+        result.severity.as_str(),
+        result.rule_name,
+        result.message
+    );
+    let row = format!(
+        "| `{}` | {} | {} | `{}` | {} |\n",
+        workspace_path(path),
+        result.line,
+        result.severity.as_str(),
+        result.rule_name,
+        result.message.replace('|', "\\|")
+    );
+    (line, row)
+}
+
+fn summary(omitted: &[(String, String)], annotated: usize) -> String {
+    let mut out = format!(
+        "### compass\n\n{} finding(s) were annotated; GitHub's annotation limit left out {} more:\n\n",
+        annotated,
+        omitted.len()
+    );
+    out.push_str("| File | Line | Severity | Rule | Message |\n| --- | --- | --- | --- | --- |\n");
+    for (_, row) in omitted {
+        out.push_str(row);
+    }
+    out
+}
+
+/// Annotations need paths relative to the workspace root.
+fn workspace_path(path: &str) -> String {
+    path.trim_start_matches("./").replace('\\', "/")
+}
+
+fn escape_data(value: &str) -> String {
+    value
+        .replace('%', "%25")
+        .replace('\r', "%0D")
+        .replace('\n', "%0A")
+}
+
+fn escape_property(value: &str) -> String {
+    escape_data(value).replace(':', "%3A").replace(',', "%2C")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn finding(rule: &str, severity: Severity, line: usize) -> AnalysisResult {
+        AnalysisResult {
+            rule_name: rule.to_string(),
+            message: format!("{} found", rule),
+            severity,
+            line,
+            column: 2,
+            end_line: line,
+            end_column: 9,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_commands_are_escaped() {
+        let mut result = finding("panic_usage", Severity::Warning, 3);
+        result.message = "100% sure: a, b".to_string();
+        result.suggestion = Some("Return an error.".to_string());
+        let files = [FileFindings {
+            path: "./cmd/main.go".to_string(),
+            module: None,
+            owners: Vec::new(),
+            results: vec![result],
+        }];
+
+        let workflow = to_workflow(&files, ANNOTATIONS_PER_LEVEL);
+        assert_eq!(
+            workflow.commands,
+            "::warning file=cmd/main.go,line=3,endLine=3,col=2,endColumn=9,title=compass%3A panic_usage::100%25 sure: a, b%0AReturn an error.\n"
+        );
+        assert!(workflow.summary.is_none());
+    }
+
+    #[test]
+    fn test_findings_past_the_limit_are_grouped_and_summarized() {
+        let results = vec![
+            finding("sql_injection", Severity::Error, 1),
+            finding("panic_usage", Severity::Warning, 2),
+            finding("panic_usage", Severity::Warning, 3),
+            finding("panic_usage", Severity::Warning, 4),
+        ];
+        let files = [FileFindings {
+            path: "main.go".to_string(),
+            module: None,
+            owners: Vec::new(),
+            results,
+        }];
+
+        let workflow = to_workflow(&files, 2);
+        let lines: Vec<&str> = workflow.commands.lines().collect();
+        assert!(lines[0].starts_with("::error file=main.go,line=1,"));
+        assert!(lines[2].starts_with("::warning file=main.go,line=3,"));
+        assert_eq!(
+            &lines[3..],
+            [
+                "::group::compass: 1 more finding(s) not annotated",
+                "main.go:4:2: warning panic_usage: panic_usage found",
+                "::endgroup::",
+            ]
+        );
+
+        let summary = workflow.summary.unwrap();
+        assert!(summary.contains("3 finding(s) were annotated"));
+        assert!(summary.contains("| `main.go` | 4 | warning | `panic_usage` | panic_usage found |"));
+    }
+}
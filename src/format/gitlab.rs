@@ -0,0 +1,131 @@
+//! GitLab's Code Quality report, which merge requests show as a widget and
+//! as markers on the changed lines of the diff.
+//!
+//! GitLab matches each finding to the same one in the target branch's
+//! report by its `fingerprint`, so only new and fixed findings appear in
+//! the widget. The fingerprint is the one baselines use, which doesn't
+//! change when other edits or a rebase move the finding to another line.
+//! Paths are relative to the working directory, which in a CI job is the
+//! repository's root.
+//!
+//! ```yaml
+//! code_quality:
+//!   script: compass --format gitlab-codequality . > gl-code-quality-report.json
+//!   artifacts:
+//!     reports:
+//!       codequality: gl-code-quality-report.json
+//! ```
+
+use crate::analyzer::{AnalysisResult, Severity};
+use crate::fingerprint::fingerprints;
+use crate::format::{repository_path, FileFindings, JsonArray};
+use serde_json::{json, Value};
+use std::io::{self, Write};
+
+pub fn to_codequality(files: &[FileFindings]) -> String {
+    let mut writer = CodeQualityWriter::new(Vec::new());
+    for file in files {
+        writer.file(file).expect("writing to memory cannot fail");
+    }
+    let out = writer.finish().expect("writing to memory cannot fail");
+    String::from_utf8(out).expect("JSON is UTF-8")
+}
+
+/// Writes the same report as [`to_codequality`] a file at a time.
+pub struct CodeQualityWriter<W: Write> {
+    out: W,
+    issues: JsonArray,
+}
+
+impl<W: Write> CodeQualityWriter<W> {
+    pub fn new(out: W) -> Self {
+        CodeQualityWriter {
+            out,
+            issues: JsonArray::new(0),
+        }
+    }
+
+    pub fn file(&mut self, file: &FileFindings) -> io::Result<()> {
+        let path = repository_path(&file.path);
+        let prints = fingerprints(&path, &file.results);
+        for (result, print) in file.results.iter().zip(prints) {
+            self.issues
+                .push(&mut self.out, &issue(&path, result, print))?;
+        }
+        Ok(())
+    }
+
+    pub fn finish(mut self) -> io::Result<W> {
+        self.issues.close(&mut self.out)?;
+        self.out.write_all(b"\n")?;
+        Ok(self.out)
+    }
+}
+
+fn issue(path: &str, result: &AnalysisResult, fingerprint: String) -> Value {
+    let mut issue = json!({
+        "type": "issue",
+        "check_name": result.rule_name,
+        "description": result.message,
+        "severity": severity(&result.severity),
+        "fingerprint": fingerprint,
+        "location": {
+            "path": path,
+            "lines": { "begin": result.line, "end": result.end_line.max(result.line) }
+        }
+    });
+    if let Some(suggestion) = &result.suggestion {
+        issue["content"] = json!({ "body": suggestion });
+    }
+    issue
+}
+
+/// GitLab's scale runs `info`, `minor`, `major`, `critical`, `blocker`;
+/// nothing compass finds blocks on its own.
+fn severity(severity: &Severity) -> &'static str {
+    match severity {
+        Severity::Error => "critical",
+        Severity::Warning => "major",
+        Severity::Info => "minor",
+        Severity::Style => "info",
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_issues_keep_their_fingerprint_when_lines_move() {
+        let file = |line| FileFindings {
+            path: "./pkg/main.go".to_string(),
+            module: None,
+            owners: Vec::new(),
+            results: vec![AnalysisResult {
+                rule_name: "panic_usage".to_string(),
+                severity: Severity::Warning,
+                message: "Use of panic()".to_string(),
+                line,
+                end_line: line,
+                text: "panic(err)".to_string(),
+                suggestion: Some("Return an error.".to_string()),
+                ..Default::default()
+            }],
+        };
+        let report =
+            |line| -> Value { serde_json::from_str(&to_codequality(&[file(line)])).unwrap() };
+
+        let before = report(4);
+        let issue = &before[0];
+        assert_eq!(issue["check_name"], "panic_usage");
+        assert_eq!(issue["severity"], "major");
+        assert_eq!(issue["location"]["path"], "pkg/main.go");
+        assert_eq!(issue["location"]["lines"]["begin"], 4);
+        assert_eq!(issue["content"]["body"], "Return an error.");
+        let after = report(9);
+        assert_eq!(after[0]["location"]["lines"]["begin"], 9);
+        assert_eq!(after[0]["fingerprint"], issue["fingerprint"]);
+
+        assert_eq!(to_codequality(&[]), "[]\n");
+    }
+}
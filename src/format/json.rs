@@ -0,0 +1,435 @@
+//! The `--format json` report.
+//!
+//! These types are the published schema: tools written in Rust can
+//! deserialize the output with `serde_json::from_str::<Report>`, and tools in
+//! other languages can treat the field names and doc comments below as the
+//! contract. `schema_version` is bumped whenever a field is removed, renamed
+//! or changes meaning; new optional fields may appear without a bump.
+
+use crate::analyzer::{AnalysisResult, RelatedLocation};
+use crate::fingerprint::fingerprints;
+use crate::format::{write_nested, FileFindings, JsonArray};
+use serde::{Deserialize, Serialize};
+use std::io::{self, Write};
+
+pub const SCHEMA_VERSION: u32 = 1;
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Report {
+    pub schema_version: u32,
+    pub tool: Tool,
+    pub findings: Vec<Finding>,
+    /// The findings of the `--compare-to` report that this run no longer
+    /// has, as that report had them.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub fixed: Vec<Finding>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Tool {
+    pub name: String,
+    pub version: String,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Finding {
+    /// The rule's `name` from its config.
+    pub rule_id: String,
+    pub message: String,
+    /// One of `error`, `warning`, `info` or `style`.
+    pub severity: String,
+    /// One of `high`, `medium` or `low`: how sure the rule is of the
+    /// finding.
+    #[serde(default = "default_confidence")]
+    pub confidence: String,
+    /// The path as it was given to compass.
+    pub file: String,
+    /// The path of the Go module the file belongs to, when there is one.
+    #[serde(default)]
+    pub module: Option<String>,
+    /// The file's owners from the repository's `CODEOWNERS`, such as
+    /// `@acme/platform`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub owners: Vec<String>,
+    pub range: Range,
+    /// The exact source text of `range`.
+    pub text: String,
+    /// The enclosing function, method or type, when there is one.
+    #[serde(default)]
+    pub symbol: Option<String>,
+    #[serde(default)]
+    pub suggestion: Option<String>,
+    /// Where to read more about fixing the finding, when the rule links
+    /// to guidance.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub url: Option<String>,
+    /// Stays the same when unrelated lines move; the value baselines use.
+    pub fingerprint: String,
+    #[serde(default)]
+    pub fixes: Vec<SuggestedFix>,
+    #[serde(default)]
+    pub related: Vec<Related>,
+    /// The `GOOS/GOARCH` builds the finding occurs in, when compass was run
+    /// with `--platforms` or `--build-tags`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub platforms: Vec<String>,
+    /// One of `new`, `unchanged` or `fixed` when compass was run with
+    /// `--compare-to`: whether the earlier report had the finding.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub status: Option<String>,
+    /// `experimental` or `deprecated` for a rule that isn't stable yet or
+    /// any more; absent for stable rules.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub stage: Option<String>,
+}
+
+fn default_confidence() -> String {
+    "high".to_string()
+}
+
+/// A span of the file. Lines and columns are 1-based, with the end column
+/// just past the last character; byte offsets are 0-based and half-open.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Range {
+    pub start_byte: usize,
+    pub end_byte: usize,
+    pub start_line: usize,
+    pub start_column: usize,
+    pub end_line: usize,
+    pub end_column: usize,
+}
+
+/// Edits that resolve the finding when applied together.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct SuggestedFix {
+    pub description: String,
+    pub edits: Vec<Edit>,
+}
+
+/// Replaces bytes `start_byte..end_byte` of the original file.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Edit {
+    pub start_byte: usize,
+    pub end_byte: usize,
+    pub replacement: String,
+}
+
+/// Another place in the same file that explains the finding.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Related {
+    pub file: String,
+    pub range: Range,
+    pub message: String,
+}
+
+/// Builds the report for every analyzed file.
+pub fn to_report(files: &[FileFindings]) -> Report {
+    Report {
+        schema_version: SCHEMA_VERSION,
+        tool: tool(),
+        findings: files.iter().flat_map(findings).collect(),
+        fixed: Vec::new(),
+    }
+}
+
+/// Combines the reports of `--shard` runs into one. A finding in more than
+/// one, such as one about a file two shards both read, is kept once, and
+/// the findings are ordered by file and position so the result doesn't
+/// depend on the order the reports came in.
+pub fn merge(reports: impl IntoIterator<Item = Report>) -> Report {
+    let mut findings = Vec::new();
+    let mut fixed = Vec::new();
+    for report in reports {
+        findings.extend(report.findings);
+        fixed.extend(report.fixed);
+    }
+    for findings in [&mut findings, &mut fixed] {
+        findings.sort_by(|a, b| {
+            let key = |f: &Finding| {
+                (
+                    f.file.clone(),
+                    f.range.start_byte,
+                    f.range.end_byte,
+                    f.rule_id.clone(),
+                    f.fingerprint.clone(),
+                )
+            };
+            key(a).cmp(&key(b))
+        });
+        findings.dedup_by(|a, b| a == b);
+    }
+    Report {
+        schema_version: SCHEMA_VERSION,
+        tool: tool(),
+        findings,
+        fixed,
+    }
+}
+
+/// Writes the same report as [`to_report`] a file at a time, so a run
+/// needn't hold every finding until the end. The members are in the order
+/// the CLI has always printed them in, sorted, so `findings` comes first.
+pub struct ReportWriter<W: Write> {
+    out: W,
+    findings: JsonArray,
+}
+
+impl<W: Write> ReportWriter<W> {
+    pub fn new(mut out: W) -> io::Result<Self> {
+        out.write_all(b"{\n  \"findings\": ")?;
+        Ok(ReportWriter {
+            out,
+            findings: JsonArray::new(2),
+        })
+    }
+
+    pub fn file(&mut self, file: &FileFindings) -> io::Result<()> {
+        for finding in findings(file) {
+            let finding = serde_json::to_value(finding).map_err(io::Error::other)?;
+            self.findings.push(&mut self.out, &finding)?;
+        }
+        Ok(())
+    }
+
+    /// Writes the rest of the report, with `fixed` after the findings.
+    pub fn finish(mut self, fixed: &[Finding]) -> io::Result<W> {
+        self.findings.close(&mut self.out)?;
+        if !fixed.is_empty() {
+            let fixed = serde_json::to_value(fixed).map_err(io::Error::other)?;
+            self.out.write_all(b",\n  \"fixed\": ")?;
+            write_nested(&mut self.out, &fixed, 2)?;
+        }
+        write!(
+            self.out,
+            ",\n  \"schema_version\": {},\n  \"tool\": ",
+            SCHEMA_VERSION
+        )?;
+        write_nested(&mut self.out, &tool(), 2)?;
+        self.out.write_all(b"\n}\n")?;
+        Ok(self.out)
+    }
+}
+
+fn tool() -> Tool {
+    Tool {
+        name: "compass".to_string(),
+        version: env!("CARGO_PKG_VERSION").to_string(),
+    }
+}
+
+fn findings(file: &FileFindings) -> Vec<Finding> {
+    let prints = fingerprints(&file.path, &file.results);
+    file.results
+        .iter()
+        .zip(prints)
+        .map(|(result, print)| finding(file, result, print))
+        .collect()
+}
+
+fn finding(file: &FileFindings, result: &AnalysisResult, fingerprint: String) -> Finding {
+    let path = &file.path;
+    Finding {
+        rule_id: result.rule_name.clone(),
+        message: result.message.clone(),
+        severity: result.severity.as_str().to_string(),
+        confidence: result.confidence.as_str().to_string(),
+        file: path.to_string(),
+        module: file.module.clone(),
+        owners: file.owners.clone(),
+        range: Range {
+            start_byte: result.start_byte,
+            end_byte: result.end_byte,
+            start_line: result.line,
+            start_column: result.column,
+            end_line: result.end_line,
+            end_column: result.end_column,
+        },
+        text: result.text.clone(),
+        symbol: result.symbol.clone(),
+        suggestion: result.suggestion.clone(),
+        url: result.url.clone(),
+        fingerprint,
+        fixes: result
+            .fix
+            .iter()
+            .map(|fix| SuggestedFix {
+                description: fix.description.clone(),
+                edits: fix
+                    .edits
+                    .iter()
+                    .map(|edit| Edit {
+                        start_byte: edit.start_byte,
+                        end_byte: edit.end_byte,
+                        replacement: edit.replacement.clone(),
+                    })
+                    .collect(),
+            })
+            .collect(),
+        related: result
+            .related
+            .iter()
+            .map(|location| related(path, location))
+            .collect(),
+        platforms: result.platforms.clone(),
+        status: result.status.map(|status| status.as_str().to_string()),
+        stage: (!result.stage.is_stable()).then(|| result.stage.as_str().to_string()),
+    }
+}
+
+fn related(path: &str, location: &RelatedLocation) -> Related {
+    Related {
+        file: location.file.as_deref().unwrap_or(path).to_string(),
+        range: Range {
+            start_byte: location.start_byte,
+            end_byte: location.end_byte,
+            start_line: location.line,
+            start_column: location.column,
+            end_line: location.end_line,
+            end_column: location.end_column,
+        },
+        message: location.message.clone(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analyzer::Severity;
+    use crate::fix::{Fix, TextEdit};
+
+    #[test]
+    fn test_report_round_trips() {
+        let files = [FileFindings {
+            path: "main.go".to_string(),
+            module: Some("example.com/app".to_string()),
+            owners: Vec::new(),
+            results: vec![AnalysisResult {
+                rule_name: "missing_error_check".to_string(),
+                severity: Severity::Warning,
+                message: "`err` is overwritten before it is checked".to_string(),
+                line: 3,
+                column: 2,
+                end_line: 3,
+                end_column: 5,
+                start_byte: 20,
+                end_byte: 23,
+                text: "err".to_string(),
+                fix: Some(Fix {
+                    description: "Discard the error".to_string(),
+                    edits: vec![TextEdit {
+                        start_byte: 20,
+                        end_byte: 23,
+                        replacement: "_".to_string(),
+                    }],
+                }),
+                related: vec![
+                    RelatedLocation {
+                        file: None,
+                        message: "overwritten here".to_string(),
+                        line: 4,
+                        column: 2,
+                        end_line: 4,
+                        end_column: 5,
+                        start_byte: 40,
+                        end_byte: 43,
+                    },
+                    RelatedLocation {
+                        file: Some("load.go".to_string()),
+                        message: "`load` is declared here".to_string(),
+                        line: 9,
+                        column: 6,
+                        end_line: 9,
+                        end_column: 10,
+                        start_byte: 95,
+                        end_byte: 99,
+                    },
+                ],
+                ..Default::default()
+            }],
+        }];
+
+        let report = to_report(&files);
+        let json = serde_json::to_string(&report).unwrap();
+        let parsed: Report = serde_json::from_str(&json).unwrap();
+
+        assert_eq!(parsed, report);
+        assert_eq!(parsed.schema_version, SCHEMA_VERSION);
+        let finding = &parsed.findings[0];
+        assert_eq!(finding.severity, "warning");
+        assert_eq!(finding.confidence, "high");
+        assert_eq!(finding.module.as_deref(), Some("example.com/app"));
+        assert_eq!(finding.range.start_byte, 20);
+        assert_eq!(finding.fixes[0].edits[0].replacement, "_");
+        assert_eq!(finding.related[0].range.start_line, 4);
+        assert_eq!(finding.related[0].file, "main.go");
+        assert_eq!(finding.related[1].file, "load.go");
+
+        // Written a file at a time, the report reads the same
+        let mut writer = ReportWriter::new(Vec::new()).unwrap();
+        writer.file(&files[0]).unwrap();
+        let streamed = String::from_utf8(writer.finish(&[]).unwrap()).unwrap();
+        let whole = serde_json::to_value(&report).unwrap();
+        assert_eq!(
+            streamed,
+            serde_json::to_string_pretty(&whole).unwrap() + "\n"
+        );
+
+        // Fixed findings from --compare-to go after the findings
+        let mut compared = report.clone();
+        compared.fixed = std::mem::take(&mut compared.findings);
+        let writer = ReportWriter::new(Vec::new()).unwrap();
+        let streamed = String::from_utf8(writer.finish(&compared.fixed).unwrap()).unwrap();
+        let whole = serde_json::to_value(&compared).unwrap();
+        assert_eq!(
+            streamed,
+            serde_json::to_string_pretty(&whole).unwrap() + "\n"
+        );
+
+        let empty = String::from_utf8(ReportWriter::new(Vec::new()).unwrap().finish(&[]).unwrap());
+        let whole = serde_json::to_value(to_report(&[])).unwrap();
+        assert_eq!(
+            empty.unwrap(),
+            serde_json::to_string_pretty(&whole).unwrap() + "\n"
+        );
+    }
+
+    #[test]
+    fn test_merged_shards_keep_each_finding_once() {
+        let file = |path: &str, lines: &[usize]| FileFindings {
+            path: path.to_string(),
+            module: None,
+            owners: Vec::new(),
+            results: lines
+                .iter()
+                .map(|&line| AnalysisResult {
+                    rule_name: "unused_code".to_string(),
+                    severity: Severity::Info,
+                    message: format!("unused at {}", line),
+                    line,
+                    start_byte: line * 10,
+                    end_byte: line * 10 + 3,
+                    ..Default::default()
+                })
+                .collect(),
+        };
+        let second = to_report(&[file("store/db.go", &[7]), file("go.mod", &[1])]);
+        let first = to_report(&[file("api/server.go", &[9, 2]), file("go.mod", &[1])]);
+
+        let merged = merge([second, first]);
+        let found: Vec<_> = merged
+            .findings
+            .iter()
+            .map(|finding| (finding.file.as_str(), finding.range.start_line))
+            .collect();
+        assert_eq!(
+            found,
+            [
+                ("api/server.go", 2),
+                ("api/server.go", 9),
+                ("go.mod", 1),
+                ("store/db.go", 7)
+            ]
+        );
+        assert_eq!(merged.schema_version, SCHEMA_VERSION);
+    }
+}
@@ -0,0 +1,159 @@
+//! JUnit XML, for CI systems that only publish test reports, such as
+//! GitLab's merge request test widget or Jenkins' JUnit plugin.
+//!
+//! Each analyzed file is a test suite and each finding a failed test case
+//! named after its rule and position; a file without findings gets one
+//! passing case, so it shows up as green rather than missing. Errors and
+//! warnings are failures, typed with their severity. Info and style
+//! findings are skipped cases, so they are listed without failing the
+//! build.
+
+use crate::analyzer::{AnalysisResult, Severity};
+use crate::format::{escape, FileFindings};
+
+pub fn to_junit(files: &[FileFindings]) -> String {
+    let tests: usize = files.iter().map(|file| file.results.len().max(1)).sum();
+    let failed: usize = files.iter().map(|file| failures(&file.results)).sum();
+
+    let mut xml = String::from("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n");
+    xml.push_str(&format!(
+        "<testsuites name=\"compass\" tests=\"{}\" failures=\"{}\" errors=\"0\">\n",
+        tests, failed
+    ));
+    for file in files {
+        let path = escape(&file.path);
+        let failed = failures(&file.results);
+        xml.push_str(&format!(
+            "  <testsuite name=\"{}\" tests=\"{}\" failures=\"{}\" errors=\"0\" skipped=\"{}\">\n",
+            path,
+            file.results.len().max(1),
+            failed,
+            file.results.len() - failed
+        ));
+        if file.results.is_empty() {
+            xml.push_str(&format!(
+                "    <testcase name=\"compass\" classname=\"{}\"/>\n",
+                path
+            ));
+        }
+        for result in &file.results {
+            xml.push_str(&format!(
+                "    <testcase name=\"{} ({}:{})\" classname=\"{}\">\n",
+                escape(&result.rule_name),
+                result.line,
+                result.column,
+                path
+            ));
+            xml.push_str(&outcome(&file.path, result));
+            xml.push_str("    </testcase>\n");
+        }
+        xml.push_str("  </testsuite>\n");
+    }
+    xml.push_str("</testsuites>\n");
+    xml
+}
+
+fn is_failure(severity: &Severity) -> bool {
+    matches!(severity, Severity::Error | Severity::Warning)
+}
+
+fn failures(results: &[AnalysisResult]) -> usize {
+    results
+        .iter()
+        .filter(|result| is_failure(&result.severity))
+        .count()
+}
+
+/// The `<failure>` or `<skipped>` element of a finding. Failures carry the
+/// location and suggestion in their body, as a test's output would.
+fn outcome(path: &str, result: &AnalysisResult) -> String {
+    let message = escape(&result.message);
+    if !is_failure(&result.severity) {
+        return format!("      <skipped message=\"{}\"/>\n", message);
+    }
+    let mut body = format!(
+        "{}:{}:{}: {} {}: {}",
+        path,
+        result.line,
+        result.column,
+        result.severity.as_str(),
+        result.rule_name,
+        result.message
+    );
+    if let Some(suggestion) = &result.suggestion {
+        body.push('\n');
+        body.push_str(suggestion);
+    }
+    if let Some(url) = &result.url {
+        body.push('\n');
+        body.push_str(url);
+    }
+    format!(
+        "      <failure message=\"{}\" type=\"{}\">{}</failure>\n",
+        message,
+        result.severity.as_str(),
+        escape(&body)
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_junit_fails_errors_and_skips_info() {
+        let files = [
+            FileFindings {
+                path: "main.go".to_string(),
+                module: None,
+                owners: Vec::new(),
+                results: vec![
+                    AnalysisResult {
+                        rule_name: "sql_injection".to_string(),
+                        severity: Severity::Error,
+                        message: "Untrusted input flows into a SQL query".to_string(),
+                        suggestion: Some("Pass it as an argument.".to_string()),
+                        line: 9,
+                        column: 3,
+                        ..Default::default()
+                    },
+                    AnalysisResult {
+                        rule_name: "log_in_loop".to_string(),
+                        severity: Severity::Info,
+                        message: "Logging in a loop".to_string(),
+                        line: 12,
+                        column: 5,
+                        ..Default::default()
+                    },
+                ],
+            },
+            FileFindings {
+                path: "clean.go".to_string(),
+                module: None,
+                owners: Vec::new(),
+                results: Vec::new(),
+            },
+        ];
+        assert_eq!(
+            to_junit(&files),
+            concat!(
+                "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n",
+                "<testsuites name=\"compass\" tests=\"3\" failures=\"1\" errors=\"0\">\n",
+                "  <testsuite name=\"main.go\" tests=\"2\" failures=\"1\" errors=\"0\" skipped=\"1\">\n",
+                "    <testcase name=\"sql_injection (9:3)\" classname=\"main.go\">\n",
+                "      <failure message=\"Untrusted input flows into a SQL query\" type=\"error\">",
+                "main.go:9:3: error sql_injection: Untrusted input flows into a SQL query\n",
+                "Pass it as an argument.</failure>\n",
+                "    </testcase>\n",
+                "    <testcase name=\"log_in_loop (12:5)\" classname=\"main.go\">\n",
+                "      <skipped message=\"Logging in a loop\"/>\n",
+                "    </testcase>\n",
+                "  </testsuite>\n",
+                "  <testsuite name=\"clean.go\" tests=\"1\" failures=\"0\" errors=\"0\" skipped=\"0\">\n",
+                "    <testcase name=\"compass\" classname=\"clean.go\"/>\n",
+                "  </testsuite>\n",
+                "</testsuites>\n"
+            )
+        );
+    }
+}
@@ -0,0 +1,259 @@
+//! Reviewdog Diagnostic Format, which reviewdog reads to post findings as
+//! review comments on GitHub, GitLab, Gerrit and Bitbucket.
+//!
+//! `rdjson` is one document with every diagnostic, `rdjsonl` a diagnostic
+//! per line. A finding's fix becomes its suggestion, a single replacement
+//! spanning every edit of the fix, which reviewdog posts as a suggested
+//! change reviewers can commit. Positions are 1-based, with columns in
+//! bytes, as the format counts them. Paths are relative to the working
+//! directory, which reviewdog takes to be the repository's root.
+//!
+//! ```bash
+//! compass --format rdjsonl . | reviewdog -f=rdjsonl -name=compass -reporter=github-pr-review
+//! ```
+
+use crate::analyzer::{AnalysisResult, Severity};
+use crate::fix::Fix;
+use crate::format::{repository_path, write_nested, FileFindings, JsonArray};
+use serde_json::{json, Value};
+use std::io::{self, Write};
+
+const INFORMATION_URI: &str = "https://github.com/lyledean1/compass";
+
+/// How the diagnostics are laid out.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Layout {
+    /// `rdjson`: a `DiagnosticResult` holding them all.
+    Document,
+    /// `rdjsonl`: one `Diagnostic` per line.
+    Lines,
+}
+
+/// Renders the findings of every file, with its contents, for the fixes'
+/// positions.
+pub fn to_rdjson(files: &[(&FileFindings, &str)], layout: Layout) -> String {
+    let mut writer = RdjsonWriter::new(Vec::new(), layout).expect("writing to memory cannot fail");
+    for (file, source_code) in files {
+        writer
+            .file(file, source_code)
+            .expect("writing to memory cannot fail");
+    }
+    let out = writer.finish().expect("writing to memory cannot fail");
+    String::from_utf8(out).expect("JSON is UTF-8")
+}
+
+/// Writes the same report as [`to_rdjson`] a file at a time.
+pub struct RdjsonWriter<W: Write> {
+    out: W,
+    /// The document's `diagnostics`, or `None` for lines.
+    diagnostics: Option<JsonArray>,
+}
+
+impl<W: Write> RdjsonWriter<W> {
+    pub fn new(mut out: W, layout: Layout) -> io::Result<Self> {
+        let diagnostics = match layout {
+            Layout::Document => {
+                // The members in the order `json!` serializes them: sorted.
+                out.write_all(b"{\n  \"diagnostics\": ")?;
+                Some(JsonArray::new(2))
+            }
+            Layout::Lines => None,
+        };
+        Ok(RdjsonWriter { out, diagnostics })
+    }
+
+    /// Writes the findings of `file`, whose contents are `source_code`.
+    pub fn file(&mut self, file: &FileFindings, source_code: &str) -> io::Result<()> {
+        let path = repository_path(&file.path);
+        for result in &file.results {
+            let mut diagnostic = diagnostic(&path, result, source_code);
+            match &mut self.diagnostics {
+                Some(diagnostics) => diagnostics.push(&mut self.out, &diagnostic)?,
+                None => {
+                    // Each line stands alone, so it names its tool.
+                    diagnostic["source"] = source();
+                    serde_json::to_writer(&mut self.out, &diagnostic).map_err(io::Error::other)?;
+                    self.out.write_all(b"\n")?;
+                }
+            }
+        }
+        Ok(())
+    }
+
+    pub fn finish(mut self) -> io::Result<W> {
+        if let Some(diagnostics) = self.diagnostics.take() {
+            diagnostics.close(&mut self.out)?;
+            self.out.write_all(b",\n  \"source\": ")?;
+            write_nested(&mut self.out, &source(), 2)?;
+            self.out.write_all(b"\n}\n")?;
+        }
+        Ok(self.out)
+    }
+}
+
+fn source() -> Value {
+    json!({ "name": "compass", "url": INFORMATION_URI })
+}
+
+fn diagnostic(path: &str, result: &AnalysisResult, source_code: &str) -> Value {
+    let mut diagnostic = json!({
+        "message": result.message,
+        "location": {
+            "path": path,
+            "range": range(
+                (result.line, result.column),
+                (result.end_line.max(result.line), result.end_column)
+            )
+        },
+        "severity": severity(&result.severity),
+        "code": { "value": result.rule_name }
+    });
+    if let Some(url) = &result.url {
+        diagnostic["code"]["url"] = json!(url);
+    }
+    if let Some(suggestion) = result
+        .fix
+        .as_ref()
+        .and_then(|fix| suggestion(fix, source_code))
+    {
+        diagnostic["suggestions"] = json!([suggestion]);
+    }
+    if !result.related.is_empty() {
+        diagnostic["related_locations"] = json!(result
+            .related
+            .iter()
+            .map(|location| json!({
+                "message": location.message,
+                "location": {
+                    "path": location.file.as_deref().map_or_else(|| path.to_string(), repository_path),
+                    "range": range(
+                        (location.line, location.column),
+                        (location.end_line, location.end_column)
+                    )
+                }
+            }))
+            .collect::<Vec<_>>());
+    }
+    diagnostic
+}
+
+/// The fix as one replacement of the text from its first edit to its last,
+/// or `None` when its edits don't fit the source.
+fn suggestion(fix: &Fix, source_code: &str) -> Option<Value> {
+    let mut edits: Vec<_> = fix.edits.iter().collect();
+    edits.sort_by_key(|edit| (edit.start_byte, edit.end_byte));
+    let start = edits.first()?.start_byte;
+    let end = edits.iter().map(|edit| edit.end_byte).max()?;
+    let mut text = String::new();
+    let mut at = start;
+    for edit in edits {
+        // Overlapping edits can't be applied together.
+        if edit.start_byte < at || edit.end_byte < edit.start_byte {
+            return None;
+        }
+        text.push_str(source_code.get(at..edit.start_byte)?);
+        text.push_str(&edit.replacement);
+        at = edit.end_byte;
+    }
+    text.push_str(source_code.get(at..end)?);
+    Some(json!({
+        "range": range(position(source_code, start), position(source_code, end)),
+        "text": text
+    }))
+}
+
+fn range(start: (usize, usize), end: (usize, usize)) -> Value {
+    json!({
+        "start": { "line": start.0, "column": start.1 },
+        "end": { "line": end.0, "column": end.1 }
+    })
+}
+
+/// The 1-based line and byte column of `byte` in `source_code`.
+fn position(source_code: &str, byte: usize) -> (usize, usize) {
+    let before = &source_code.as_bytes()[..byte];
+    let line_start = before
+        .iter()
+        .rposition(|&b| b == b'\n')
+        .map_or(0, |i| i + 1);
+    let line = before.iter().filter(|&&b| b == b'\n').count() + 1;
+    (line, byte - line_start + 1)
+}
+
+fn severity(severity: &Severity) -> &'static str {
+    match severity {
+        Severity::Error => "ERROR",
+        Severity::Warning => "WARNING",
+        Severity::Info | Severity::Style => "INFO",
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::fix::TextEdit;
+
+    #[test]
+    fn test_fixes_become_suggestions_and_streams_match() {
+        let source_code = "package main\n\nfunc f() {\n\tx := a + b\n}\n";
+        let files = [FileFindings {
+            path: "./main.go".to_string(),
+            module: None,
+            owners: Vec::new(),
+            results: vec![AnalysisResult {
+                rule_name: "swap".to_string(),
+                severity: Severity::Style,
+                message: "Swap the operands".to_string(),
+                line: 4,
+                column: 7,
+                end_line: 4,
+                end_column: 12,
+                fix: Some(Fix {
+                    description: "Swap".to_string(),
+                    edits: vec![
+                        TextEdit {
+                            start_byte: 35,
+                            end_byte: 36,
+                            replacement: "a".to_string(),
+                        },
+                        TextEdit {
+                            start_byte: 31,
+                            end_byte: 32,
+                            replacement: "b".to_string(),
+                        },
+                    ],
+                }),
+                ..Default::default()
+            }],
+        }];
+        let whole = to_rdjson(&[(&files[0], source_code)], Layout::Document);
+        let report: Value = serde_json::from_str(&whole).unwrap();
+        assert_eq!(whole, serde_json::to_string_pretty(&report).unwrap() + "\n");
+
+        let diagnostic = &report["diagnostics"][0];
+        assert_eq!(diagnostic["location"]["path"], "main.go");
+        assert_eq!(diagnostic["severity"], "INFO");
+        assert_eq!(
+            diagnostic["suggestions"],
+            json!([{
+                "range": {
+                    "start": { "line": 4, "column": 7 },
+                    "end": { "line": 4, "column": 12 }
+                },
+                "text": "b + a"
+            }])
+        );
+
+        let lines = to_rdjson(&[(&files[0], source_code)], Layout::Lines);
+        let mut line: Value = serde_json::from_str(lines.trim_end()).unwrap();
+        assert_eq!(line["source"]["name"], "compass");
+        line.as_object_mut().unwrap().remove("source");
+        assert_eq!(&line, diagnostic);
+
+        assert_eq!(
+            to_rdjson(&[], Layout::Document),
+            "{\n  \"diagnostics\": [],\n  \"source\": {\n    \"name\": \"compass\",\n    \"url\": \"https://github.com/lyledean1/compass\"\n  }\n}\n"
+        );
+        assert_eq!(to_rdjson(&[], Layout::Lines), "");
+    }
+}
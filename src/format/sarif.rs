@@ -0,0 +1,320 @@
+use crate::analyzer::{AnalysisRule, RelatedLocation, Severity};
+use crate::compare::Status;
+use crate::fingerprint::fingerprints;
+use crate::format::{write_nested, FileFindings, JsonArray};
+use serde_json::{json, Value};
+use std::fs;
+use std::io::{self, Write};
+
+const SARIF_SCHEMA: &str = "https://json.schemastore.org/sarif-2.1.0.json";
+const INFORMATION_URI: &str = "https://github.com/lyledean1/compass";
+
+/// How the run's columns count: SARIF's default, which GitHub code
+/// scanning and VS Code assume.
+const COLUMN_KIND: &str = "utf16CodeUnits";
+
+/// Renders the findings of every file, with its contents, as a SARIF 2.1.0
+/// log with a single run. Columns are converted from bytes to UTF-16 code
+/// units.
+pub fn to_sarif(rules: &[AnalysisRule], files: &[(&FileFindings, &str)]) -> Value {
+    let sarif_results: Vec<Value> = files
+        .iter()
+        .flat_map(|(file, source_code)| file_results(rules, file, source_code))
+        .collect();
+
+    json!({
+        "$schema": SARIF_SCHEMA,
+        "version": "2.1.0",
+        "runs": [{
+            "columnKind": COLUMN_KIND,
+            "tool": tool(rules),
+            "results": sarif_results
+        }]
+    })
+}
+
+/// Writes the same log as [`to_sarif`] a file at a time. The rules are
+/// only known at the end, so each call gets the rules seen so far; they
+/// must only ever be appended to, as `ruleIndex` points into them.
+pub struct SarifWriter<W: Write> {
+    out: W,
+    results: JsonArray,
+}
+
+impl<W: Write> SarifWriter<W> {
+    pub fn new(mut out: W) -> io::Result<Self> {
+        // The members in the order `to_sarif`'s are serialized: sorted.
+        write!(
+            out,
+            "{{\n  \"$schema\": {},\n  \"runs\": [\n    {{\n      \"columnKind\": {},\n      \"results\": ",
+            json!(SARIF_SCHEMA),
+            json!(COLUMN_KIND)
+        )?;
+        Ok(SarifWriter {
+            out,
+            results: JsonArray::new(6),
+        })
+    }
+
+    pub fn file(
+        &mut self,
+        rules: &[AnalysisRule],
+        file: &FileFindings,
+        source_code: &str,
+    ) -> io::Result<()> {
+        for result in file_results(rules, file, source_code) {
+            self.results.push(&mut self.out, &result)?;
+        }
+        Ok(())
+    }
+
+    pub fn finish(mut self, rules: &[AnalysisRule]) -> io::Result<W> {
+        self.results.close(&mut self.out)?;
+        self.out.write_all(b",\n      \"tool\": ")?;
+        write_nested(&mut self.out, &tool(rules), 6)?;
+        self.out
+            .write_all(b"\n    }\n  ],\n  \"version\": \"2.1.0\"\n}\n")?;
+        Ok(self.out)
+    }
+}
+
+fn tool(rules: &[AnalysisRule]) -> Value {
+    json!({
+        "driver": {
+            "name": "compass",
+            "version": env!("CARGO_PKG_VERSION"),
+            "informationUri": INFORMATION_URI,
+            "rules": rules.iter().map(rule_descriptor).collect::<Vec<_>>()
+        }
+    })
+}
+
+fn file_results(rules: &[AnalysisRule], file: &FileFindings, source_code: &str) -> Vec<Value> {
+    let (path, results) = (file.path.as_str(), &file.results);
+    let prints = fingerprints(path, results);
+
+    results
+        .iter()
+        .zip(prints.iter())
+        .map(|(result, print)| {
+            let start_column = utf16_column(source_code, result.line, result.column);
+            let end_column = utf16_column(source_code, result.end_line, result.end_column);
+            let mut entry = json!({
+                "ruleId": result.rule_name,
+                "level": level(&result.severity),
+                "message": { "text": result.message },
+                "locations": [{
+                    "physicalLocation": {
+                        "artifactLocation": { "uri": artifact_uri(path) },
+                        "region": {
+                            "startLine": result.line,
+                            "startColumn": start_column,
+                            "endLine": result.end_line,
+                            "endColumn": end_column,
+                            "snippet": { "text": result.text }
+                        }
+                    }
+                }],
+                "partialFingerprints": { "compassFingerprint/v1": print },
+                "properties": { "confidence": result.confidence.as_str() }
+            });
+
+            if let Some(index) = rules.iter().position(|r| r.name == result.rule_name) {
+                entry["ruleIndex"] = json!(index);
+            }
+
+            if let Some(status) = result.status {
+                entry["baselineState"] = json!(baseline_state(status));
+            }
+
+            if !result.stage.is_stable() {
+                entry["properties"]["stage"] = json!(result.stage.as_str());
+            }
+
+            if !result.related.is_empty() {
+                entry["relatedLocations"] = json!(result
+                    .related
+                    .iter()
+                    .enumerate()
+                    .map(|(id, location)| related_location(id, location, path, source_code))
+                    .collect::<Vec<_>>());
+            }
+
+            if let Some(fix) = &result.fix {
+                entry["fixes"] = json!([{
+                    "description": { "text": fix.description },
+                    "artifactChanges": [{
+                        "artifactLocation": { "uri": artifact_uri(path) },
+                        "replacements": fix.edits.iter().map(|edit| json!({
+                            "deletedRegion": {
+                                "byteOffset": edit.start_byte,
+                                "byteLength": edit.end_byte - edit.start_byte
+                            },
+                            "insertedContent": { "text": edit.replacement }
+                        })).collect::<Vec<_>>()
+                    }]
+                }]);
+            }
+
+            entry
+        })
+        .collect()
+}
+
+/// A related location. One in another file is read for its columns.
+fn related_location(id: usize, location: &RelatedLocation, path: &str, source_code: &str) -> Value {
+    let other = location
+        .file
+        .as_deref()
+        .map(|file| fs::read_to_string(file).ok());
+    let contents = match &other {
+        Some(contents) => contents.as_deref(),
+        None => Some(source_code),
+    };
+    let column =
+        |line, column| contents.map_or(column, |contents| utf16_column(contents, line, column));
+    json!({
+        "id": id,
+        "message": { "text": location.message },
+        "physicalLocation": {
+            "artifactLocation": {
+                "uri": artifact_uri(location.file.as_deref().unwrap_or(path))
+            },
+            "region": {
+                "startLine": location.line,
+                "startColumn": column(location.line, location.column),
+                "endLine": location.end_line,
+                "endColumn": column(location.end_line, location.end_column)
+            }
+        }
+    })
+}
+
+fn rule_descriptor(rule: &AnalysisRule) -> Value {
+    let mut descriptor = json!({
+        "id": rule.name,
+        "shortDescription": { "text": rule.message_template },
+        "defaultConfiguration": { "level": level(&rule.severity) },
+        "properties": { "weight": rule.weight_multiplier }
+    });
+
+    if let Some(suggestion) = &rule.suggestion {
+        descriptor["help"] = json!({ "text": suggestion });
+    }
+    if let Some(url) = rule.rendered_url() {
+        descriptor["helpUri"] = json!(url);
+    }
+
+    descriptor
+}
+
+/// SARIF's name for a `--compare-to` status.
+fn baseline_state(status: Status) -> &'static str {
+    match status {
+        Status::New => "new",
+        Status::Unchanged => "unchanged",
+        Status::Fixed => "absent",
+    }
+}
+
+fn level(severity: &Severity) -> &'static str {
+    match severity {
+        Severity::Error => "error",
+        Severity::Warning => "warning",
+        Severity::Info | Severity::Style => "note",
+    }
+}
+
+/// The 1-based column, in UTF-16 code units, of the 1-based byte `column`
+/// of `line` in `source_code`. Positions the source doesn't have are left
+/// as they are.
+fn utf16_column(source_code: &str, line: usize, column: usize) -> usize {
+    let before = line
+        .checked_sub(1)
+        .and_then(|index| source_code.split('\n').nth(index))
+        .and_then(|text| text.get(..column.checked_sub(1)?));
+    match before {
+        Some(before) => before.encode_utf16().count() + 1,
+        None => column,
+    }
+}
+
+fn artifact_uri(path: &str) -> String {
+    path.trim_start_matches("./").replace('\\', "/")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analyzer::{AnalysisResult, Confidence};
+
+    #[test]
+    fn test_streamed_log_matches_the_whole() {
+        let rule = AnalysisRule::new(
+            "panic_usage".to_string(),
+            String::new(),
+            Severity::Warning,
+            "Use of panic()".to_string(),
+            Some("Return an error.".to_string()),
+        );
+        let files = [FileFindings {
+            path: "./main.go".to_string(),
+            module: None,
+            owners: Vec::new(),
+            results: vec![AnalysisResult {
+                rule_name: "panic_usage".to_string(),
+                severity: Severity::Warning,
+                message: "Use of panic()".to_string(),
+                line: 3,
+                column: 2,
+                end_line: 3,
+                end_column: 14,
+                text: "panic(\"no\")".to_string(),
+                confidence: Confidence::High,
+                ..Default::default()
+            }],
+        }];
+        let rules = [rule];
+        let source_code = "package main\n\n\tpanic(\"no\")\n";
+
+        let mut writer = SarifWriter::new(Vec::new()).unwrap();
+        writer.file(&rules, &files[0], source_code).unwrap();
+        let streamed = String::from_utf8(writer.finish(&rules).unwrap()).unwrap();
+        let whole =
+            serde_json::to_string_pretty(&to_sarif(&rules, &[(&files[0], source_code)])).unwrap();
+        assert_eq!(streamed, whole + "\n");
+
+        let streamed =
+            String::from_utf8(SarifWriter::new(Vec::new()).unwrap().finish(&[]).unwrap()).unwrap();
+        let whole = serde_json::to_string_pretty(&to_sarif(&[], &[])).unwrap();
+        assert_eq!(streamed, whole + "\n");
+    }
+
+    #[test]
+    fn test_columns_are_utf16_code_units() {
+        // `é` is two bytes and one code unit, `😀` four bytes and two.
+        let source_code = "package main\n\nvar s = \"é😀\"; panic(s)\n";
+        let start = source_code.find("panic").unwrap() - source_code.find("var").unwrap() + 1;
+        let file = FileFindings {
+            path: "main.go".to_string(),
+            module: None,
+            owners: Vec::new(),
+            results: vec![AnalysisResult {
+                rule_name: "panic_usage".to_string(),
+                line: 3,
+                column: start,
+                end_line: 3,
+                end_column: start + "panic(s)".len(),
+                ..Default::default()
+            }],
+        };
+        let log = to_sarif(&[], &[(&file, source_code)]);
+        assert_eq!(log["runs"][0]["columnKind"], "utf16CodeUnits");
+        let region = &log["runs"][0]["results"][0]["locations"][0]["physicalLocation"]["region"];
+        assert_eq!(start, 19);
+        assert_eq!(region["startColumn"], 16);
+        assert_eq!(region["endColumn"], 24);
+
+        assert_eq!(utf16_column(source_code, 9, 4), 4);
+    }
+}
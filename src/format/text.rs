@@ -0,0 +1,388 @@
+//! Findings for people reading a terminal, laid out as rustc and eslint lay
+//! out theirs: the rule and message, the file and position, the source
+//! around it with the range underlined, and the suggestion.
+//!
+//! ```text
+//! warning[panic_usage]: avoid panic in library code
+//!   --> pkg/handler.go:12:5
+//!    |
+//! 11 |     if err != nil {
+//! 12 |         panic(err)
+//!    |         ^^^^^^^^^^
+//! 13 |     }
+//!    |
+//!    = help: Return an error instead.
+//! ```
+//!
+//! Messages and suggestions are wrapped to the terminal's width; the source
+//! never is, so the carets stay under what they mark. Colour and wrapping
+//! are only on when writing to a terminal, and `NO_COLOR` turns colour off.
+
+use crate::analyzer::{AnalysisResult, Confidence, Severity};
+use crate::compare::Status;
+use crate::format::{relative, FileFindings};
+use std::env;
+use std::io::{self, IsTerminal, Write};
+
+/// Lines around a finding shown unless `--context-lines` says otherwise.
+pub const DEFAULT_CONTEXT_LINES: usize = 2;
+
+/// The width wrapped to on a terminal that doesn't report one.
+const DEFAULT_WIDTH: usize = 80;
+
+/// A range longer than this shows only its first and last lines.
+const MAX_RANGE_LINES: usize = 6;
+
+const TAB_WIDTH: usize = 4;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct TextStyle {
+    pub color: bool,
+    pub context_lines: usize,
+    /// The width messages are wrapped to, if any.
+    pub width: Option<usize>,
+}
+
+impl TextStyle {
+    /// The style for stdout: colour and wrapping when it is a terminal,
+    /// unless `no_color` or `$NO_COLOR` is set. The width is `$COLUMNS`.
+    pub fn for_stdout(no_color: bool, context_lines: usize) -> Self {
+        let terminal = io::stdout().is_terminal();
+        let width = env::var("COLUMNS")
+            .ok()
+            .and_then(|columns| columns.parse().ok())
+            .filter(|columns: &usize| *columns > 0)
+            .unwrap_or(DEFAULT_WIDTH);
+        TextStyle {
+            color: terminal && !no_color && env::var_os("NO_COLOR").is_none_or(|v| v.is_empty()),
+            context_lines,
+            width: terminal.then_some(width),
+        }
+    }
+
+    fn paint(&self, code: &str, text: &str) -> String {
+        if self.color && !text.is_empty() {
+            format!("\x1b[{}m{}\x1b[0m", code, text)
+        } else {
+            text.to_string()
+        }
+    }
+}
+
+/// Writes each file's findings as they come in, and a count at the end.
+pub struct TextWriter<W: Write> {
+    out: W,
+    style: TextStyle,
+    files: usize,
+    /// Findings by [`Severity::rank`].
+    counts: [usize; 4],
+}
+
+impl<W: Write> TextWriter<W> {
+    pub fn new(out: W, style: TextStyle) -> Self {
+        TextWriter {
+            out,
+            style,
+            files: 0,
+            counts: [0; 4],
+        }
+    }
+
+    /// Writes the findings of `file`, whose contents are `source_code`.
+    pub fn file(&mut self, file: &FileFindings, source_code: &str) -> io::Result<()> {
+        self.files += 1;
+        let path = relative(&file.path);
+        let lines: Vec<&str> = source_code.lines().collect();
+        for result in &file.results {
+            self.counts[usize::from(result.severity.rank())] += 1;
+            let finding = finding(&self.style, &path, &lines, result);
+            self.out.write_all(finding.as_bytes())?;
+        }
+        Ok(())
+    }
+
+    pub fn finish(mut self) -> io::Result<W> {
+        let files = plural(self.files, "file", "files");
+        let total: usize = self.counts.iter().sum();
+        if total == 0 {
+            writeln!(self.out, "No findings in {}", files)?;
+            return Ok(self.out);
+        }
+        let counts: Vec<String> = [
+            (Severity::Error, "error", "errors"),
+            (Severity::Warning, "warning", "warnings"),
+            (Severity::Info, "info", "info"),
+            (Severity::Style, "style", "style"),
+        ]
+        .into_iter()
+        .filter(|(severity, _, _)| self.counts[usize::from(severity.rank())] > 0)
+        .map(|(severity, one, many)| {
+            let count = plural(self.counts[usize::from(severity.rank())], one, many);
+            self.style.paint(color(severity), &count)
+        })
+        .collect();
+        writeln!(self.out, "{} in {}", counts.join(", "), files)?;
+        Ok(self.out)
+    }
+}
+
+pub fn to_text(files: &[(&FileFindings, &str)], style: TextStyle) -> String {
+    let mut writer = TextWriter::new(Vec::new(), style);
+    for (file, source_code) in files {
+        writer
+            .file(file, source_code)
+            .expect("writing to memory cannot fail");
+    }
+    let out = writer.finish().expect("writing to memory cannot fail");
+    String::from_utf8(out).expect("findings are UTF-8")
+}
+
+fn finding(style: &TextStyle, path: &str, lines: &[&str], result: &AnalysisResult) -> String {
+    let severity = result.severity;
+    let level = style.paint(&format!("1;{}", color(severity)), severity.as_str());
+    let mut heading = format!("[{}]", result.rule_name);
+    if result.confidence != Confidence::High {
+        heading.push_str(&format!(" ({} confidence)", result.confidence.as_str()));
+    }
+    if !result.stage.is_stable() {
+        heading.push_str(&format!(" ({})", result.stage.as_str()));
+    }
+    let title = format!("{}{}: ", severity.as_str(), heading);
+    let mut message = wrap(&result.message, style.width, title.chars().count());
+    if style.color {
+        message = style.paint("1", &message);
+    }
+    let mut out = format!("{}{}: {}\n", level, style.paint("1", &heading), message);
+
+    let first = result.line.max(1);
+    let last = result.end_line.max(first);
+    let from = first.saturating_sub(style.context_lines).max(1);
+    let to = (last + style.context_lines).min(lines.len());
+    let gutter = to.max(first).to_string().len();
+    let bar = style.paint("1;34", "|");
+    let pad = " ".repeat(gutter);
+    out.push_str(&format!(
+        "{}{} {}:{}:{}\n",
+        pad,
+        style.paint("1;34", "-->"),
+        path,
+        result.line,
+        result.column
+    ));
+    if lines.is_empty() || first > lines.len() {
+        return out + "\n";
+    }
+
+    out.push_str(&format!("{} {}\n", pad, bar));
+    let mut skipped = false;
+    for number in from..=to {
+        let in_range = (first..=last).contains(&number);
+        let elided = in_range
+            && last - first + 1 > MAX_RANGE_LINES
+            && number > first + 1
+            && number + 2 <= last;
+        if elided {
+            if !skipped {
+                out.push_str(&format!("{}\n", style.paint("1;34", "...")));
+                skipped = true;
+            }
+            continue;
+        }
+        let line = lines[number - 1];
+        let shown = expand_tabs(line);
+        let label = style.paint("1;34", &format!("{:>width$}", number, width = gutter));
+        out.push_str(format!("{} {} {}", label, bar, shown).trim_end());
+        out.push('\n');
+        if !in_range {
+            continue;
+        }
+        let start = if number == first {
+            result.column.saturating_sub(1)
+        } else {
+            line.len() - line.trim_start().len()
+        };
+        let end = if number == last {
+            result.end_column.saturating_sub(1)
+        } else {
+            line.trim_end().len()
+        };
+        let (start, end) = (clamp(line, start), clamp(line, end));
+        let offset = display_width(&line[..start]);
+        let width = display_width(&line[start..end.max(start)]).max(1);
+        out.push_str(&format!(
+            "{} {} {}{}\n",
+            pad,
+            bar,
+            " ".repeat(offset),
+            style.paint(&format!("1;{}", color(severity)), &"^".repeat(width))
+        ));
+    }
+    out.push_str(&format!("{} {}\n", pad, bar));
+
+    let mut notes = Vec::new();
+    if let Some(suggestion) = &result.suggestion {
+        notes.push(("help", suggestion.clone()));
+    }
+    for related in &result.related {
+        let at = match &related.file {
+            Some(file) => format!("{}:{}:{}", relative(file), related.line, related.column),
+            None => format!("{}:{}", related.line, related.column),
+        };
+        notes.push(("note", format!("{} at {}", related.message, at)));
+    }
+    if !result.platforms.is_empty() {
+        notes.push(("note", format!("on {}", result.platforms.join(", "))));
+    }
+    if result.status == Some(Status::New) {
+        notes.push(("note", "new since the compared run".to_string()));
+    }
+    if let Some(url) = &result.url {
+        notes.push(("see", url.clone()));
+    }
+    for (kind, text) in notes {
+        let lead = format!("{} = {}: ", pad, kind);
+        out.push_str(&format!(
+            "{} {} {}: {}\n",
+            pad,
+            style.paint("1;34", "="),
+            style.paint("1", kind),
+            wrap(&text, style.width, lead.chars().count())
+        ));
+    }
+    out.push('\n');
+    out
+}
+
+/// `text` broken into lines no wider than `width`, where the first starts
+/// `indent` columns in and the rest are indented to match. Words longer
+/// than a line are left whole.
+fn wrap(text: &str, width: Option<usize>, indent: usize) -> String {
+    let Some(width) = width.filter(|width| *width > indent + 20) else {
+        return text.to_string();
+    };
+    let room = width - indent;
+    let mut lines: Vec<String> = Vec::new();
+    for paragraph in text.lines() {
+        let mut line = String::new();
+        for word in paragraph.split_whitespace() {
+            let needed = line.chars().count() + 1 + word.chars().count();
+            if !line.is_empty() && needed > room {
+                lines.push(std::mem::take(&mut line));
+            }
+            if !line.is_empty() {
+                line.push(' ');
+            }
+            line.push_str(word);
+        }
+        lines.push(line);
+    }
+    lines.join(&format!("\n{}", " ".repeat(indent)))
+}
+
+fn expand_tabs(line: &str) -> String {
+    line.replace('\t', &" ".repeat(TAB_WIDTH))
+}
+
+fn display_width(text: &str) -> usize {
+    text.chars()
+        .map(|c| if c == '\t' { TAB_WIDTH } else { 1 })
+        .sum()
+}
+
+/// `index` moved back onto a character boundary within `line`.
+fn clamp(line: &str, index: usize) -> usize {
+    let mut index = index.min(line.len());
+    while !line.is_char_boundary(index) {
+        index -= 1;
+    }
+    index
+}
+
+fn plural(count: usize, one: &str, many: &str) -> String {
+    format!("{} {}", count, if count == 1 { one } else { many })
+}
+
+/// The ANSI colour of a severity.
+fn color(severity: Severity) -> &'static str {
+    match severity {
+        Severity::Error => "31",
+        Severity::Warning => "33",
+        Severity::Info => "36",
+        Severity::Style => "32",
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SOURCE: &str =
+        "package main\n\nfunc main() {\n\tif err != nil {\n\t\tpanic(err)\n\t}\n}\n";
+
+    fn findings() -> FileFindings {
+        FileFindings {
+            path: "main.go".to_string(),
+            module: None,
+            owners: Vec::new(),
+            results: vec![AnalysisResult {
+                rule_name: "panic_usage".to_string(),
+                severity: Severity::Warning,
+                message: "avoid panic in library code".to_string(),
+                line: 5,
+                column: 3,
+                end_line: 5,
+                end_column: 13,
+                suggestion: Some("Return an error instead.".to_string()),
+                ..Default::default()
+            }],
+        }
+    }
+
+    #[test]
+    fn test_findings_show_the_source_around_them() {
+        let style = TextStyle {
+            color: false,
+            context_lines: 1,
+            width: None,
+        };
+        let file = findings();
+        assert_eq!(
+            to_text(&[(&file, SOURCE)], style),
+            concat!(
+                "warning[panic_usage]: avoid panic in library code\n",
+                " --> main.go:5:3\n",
+                "  |\n",
+                "4 |     if err != nil {\n",
+                "5 |         panic(err)\n",
+                "  |         ^^^^^^^^^^\n",
+                "6 |     }\n",
+                "  |\n",
+                "  = help: Return an error instead.\n",
+                "\n",
+                "1 warning in 1 file\n",
+            )
+        );
+
+        let colored = to_text(
+            &[(&file, SOURCE)],
+            TextStyle {
+                color: true,
+                ..style
+            },
+        );
+        assert!(
+            colored.starts_with("\x1b[1;33mwarning\x1b[0m"),
+            "{}",
+            colored
+        );
+    }
+
+    #[test]
+    fn test_long_messages_wrap_under_themselves() {
+        assert_eq!(
+            wrap("one two three four five six seven", Some(40), 10),
+            "one two three four five six\n          seven"
+        );
+        assert_eq!(wrap("one two", None, 10), "one two");
+    }
+}
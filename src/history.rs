@@ -0,0 +1,285 @@
+//! Repository quality scores and their history.
+//!
+//! `compass score` reduces a whole tree to one number. Every finding counts
+//! with its score impact, so severities and rule weights carry over from
+//! the per-file score, and the total is divided by the thousands of lines
+//! analyzed; a repository twice the size may have twice the findings for
+//! the same score. The density maps onto 0 to 10 as `100 / (10 + density)`:
+//! 10 with no findings, 5 at ten weighted points per thousand lines, and
+//! never quite 0.
+//!
+//! Each run can be kept as a [`Snapshot`]: in a directory, one JSON file per
+//! snapshot, or at an HTTP endpoint that answers `GET` with the JSON array
+//! of snapshots and accepts a new one by `POST`. The endpoint is reached
+//! with `curl`, so nothing is linked in for it.
+
+use crate::analyzer::{AnalysisResult, Severity};
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::io::Write;
+use std::path::PathBuf;
+use std::process::{Command, Stdio};
+use std::time::{SystemTime, UNIX_EPOCH};
+
+pub const DEFAULT_HISTORY_DIR: &str = ".compass/history";
+
+const SNAPSHOT_VERSION: u32 = 1;
+
+#[derive(Debug, Clone, PartialEq, Deserialize, Serialize)]
+pub struct Snapshot {
+    pub version: u32,
+    /// Seconds since the Unix epoch.
+    pub timestamp: u64,
+    /// The `HEAD` commit, when the tree is a git checkout.
+    pub commit: Option<String>,
+    pub score: f64,
+    pub files: usize,
+    pub lines: usize,
+    /// The findings' score impacts, added up.
+    pub weighted: f64,
+    pub errors: usize,
+    pub warnings: usize,
+    pub info: usize,
+    pub style: usize,
+}
+
+impl Snapshot {
+    pub fn new<'a>(
+        results: impl IntoIterator<Item = &'a AnalysisResult>,
+        files: usize,
+        lines: usize,
+    ) -> Self {
+        let mut snapshot = Snapshot {
+            version: SNAPSHOT_VERSION,
+            timestamp: SystemTime::now()
+                .duration_since(UNIX_EPOCH)
+                .map_or(0, |elapsed| elapsed.as_secs()),
+            commit: None,
+            score: 0.0,
+            files,
+            lines,
+            weighted: 0.0,
+            errors: 0,
+            warnings: 0,
+            info: 0,
+            style: 0,
+        };
+        for result in results {
+            snapshot.weighted += result.score_impact.abs();
+            match result.severity {
+                Severity::Error => snapshot.errors += 1,
+                Severity::Warning => snapshot.warnings += 1,
+                Severity::Info => snapshot.info += 1,
+                Severity::Style => snapshot.style += 1,
+            }
+        }
+        snapshot.weighted = (snapshot.weighted * 100.0).round() / 100.0;
+        snapshot.score = score(snapshot.weighted, lines);
+        snapshot
+    }
+}
+
+/// The score for `weighted` points of findings in `lines` lines, rounded to
+/// one decimal.
+pub fn score(weighted: f64, lines: usize) -> f64 {
+    let density = weighted * 1000.0 / lines.max(1) as f64;
+    let score = 100.0 / (10.0 + density);
+    (score * 10.0).round() / 10.0
+}
+
+/// Where snapshots are kept.
+#[derive(Debug, Clone, PartialEq)]
+pub enum History {
+    Dir(PathBuf),
+    Remote(String),
+}
+
+impl History {
+    /// An `http://` or `https://` URL is an endpoint, anything else a
+    /// directory.
+    pub fn open(location: &str) -> Self {
+        if location.starts_with("http://") || location.starts_with("https://") {
+            History::Remote(location.to_string())
+        } else {
+            History::Dir(PathBuf::from(location))
+        }
+    }
+
+    /// Every snapshot, oldest first. A directory that doesn't exist yet has
+    /// none.
+    pub fn snapshots(&self) -> Result<Vec<Snapshot>, String> {
+        let mut snapshots = match self {
+            History::Dir(dir) => {
+                let Ok(entries) = fs::read_dir(dir) else {
+                    return Ok(Vec::new());
+                };
+                let mut snapshots = Vec::new();
+                for entry in entries.flatten() {
+                    let path = entry.path();
+                    if path.extension().is_none_or(|extension| extension != "json") {
+                        continue;
+                    }
+                    let snapshot = fs::read_to_string(&path)
+                        .map_err(|e| e.to_string())
+                        .and_then(|content| parse(&content))
+                        .map_err(|e| format!("failed to read '{}': {}", path.display(), e))?;
+                    snapshots.push(snapshot);
+                }
+                snapshots
+            }
+            History::Remote(url) => {
+                let body = curl(&["-fsSL", url], None)?;
+                serde_json::from_str::<Vec<Snapshot>>(&body)
+                    .map_err(|e| format!("unexpected response from '{}': {}", url, e))?
+            }
+        };
+        snapshots.sort_by_key(|snapshot| snapshot.timestamp);
+        Ok(snapshots)
+    }
+
+    pub fn latest(&self) -> Result<Option<Snapshot>, String> {
+        Ok(self.snapshots()?.pop())
+    }
+
+    pub fn record(&self, snapshot: &Snapshot) -> Result<(), String> {
+        let content = serde_json::to_string_pretty(snapshot).map_err(|e| e.to_string())?;
+        match self {
+            History::Dir(dir) => {
+                let name = match &snapshot.commit {
+                    Some(commit) => format!("{}-{}.json", snapshot.timestamp, commit),
+                    None => format!("{}.json", snapshot.timestamp),
+                };
+                let path = dir.join(name);
+                fs::create_dir_all(dir)
+                    .and_then(|_| fs::write(&path, content + "\n"))
+                    .map_err(|e| format!("failed to write '{}': {}", path.display(), e))
+            }
+            History::Remote(url) => {
+                let args = [
+                    "-fsSL",
+                    "-X",
+                    "POST",
+                    "-H",
+                    "Content-Type: application/json",
+                    "--data-binary",
+                    "@-",
+                    url,
+                ];
+                curl(&args, Some(&content)).map(|_| ())
+            }
+        }
+    }
+}
+
+fn parse(content: &str) -> Result<Snapshot, String> {
+    let snapshot: Snapshot = serde_json::from_str(content).map_err(|e| e.to_string())?;
+    if snapshot.version != SNAPSHOT_VERSION {
+        return Err(format!(
+            "unsupported snapshot version {} (expected {})",
+            snapshot.version, SNAPSHOT_VERSION
+        ));
+    }
+    Ok(snapshot)
+}
+
+/// How long curl may take to connect, and to finish, unless the caller's
+/// own `--max-time` says otherwise.
+const CURL_TIMEOUTS: [&str; 4] = ["--connect-timeout", "10", "--max-time", "120"];
+
+/// Runs curl, writing `input` to its stdin, and returns its stdout. When
+/// the URL, the last argument, is `https://`, curl speaks only https to it
+/// and wherever it redirects, so a redirect can't downgrade it.
+pub(crate) fn curl(args: &[&str], input: Option<&str>) -> Result<String, String> {
+    let https_only = args.last().is_some_and(|url| url.starts_with("https://"));
+    let mut command = Command::new("curl");
+    command.args(CURL_TIMEOUTS);
+    if https_only {
+        command.args(["--proto", "=https", "--proto-redir", "=https"]);
+    }
+    let mut child = command
+        .args(args)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .map_err(|e| format!("failed to run curl: {}", e))?;
+    if let (Some(input), Some(mut stdin)) = (input, child.stdin.take()) {
+        stdin
+            .write_all(input.as_bytes())
+            .map_err(|e| format!("failed to send to curl: {}", e))?;
+    }
+    let output = child
+        .wait_with_output()
+        .map_err(|e| format!("failed to run curl: {}", e))?;
+    if !output.status.success() {
+        return Err(format!(
+            "curl {} failed: {}",
+            args.last().unwrap_or(&""),
+            String::from_utf8_lossy(&output.stderr).trim()
+        ));
+    }
+    Ok(String::from_utf8_lossy(&output.stdout).into_owned())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_score_is_normalized_by_size() {
+        assert_eq!(score(0.0, 0), 10.0);
+        assert_eq!(score(10.0, 1000), 5.0);
+        assert_eq!(score(20.0, 2000), 5.0);
+        assert_eq!(score(3.0, 1000), 7.7);
+
+        let results = [
+            AnalysisResult {
+                severity: Severity::Error,
+                score_impact: -3.0,
+                ..Default::default()
+            },
+            AnalysisResult {
+                severity: Severity::Info,
+                score_impact: -0.4,
+                ..Default::default()
+            },
+        ];
+        let snapshot = Snapshot::new(&results, 2, 500);
+        assert_eq!((snapshot.errors, snapshot.info), (1, 1));
+        assert_eq!(snapshot.weighted, 3.4);
+        assert_eq!(snapshot.score, 6.0);
+    }
+
+    #[test]
+    fn test_directory_history_round_trip() {
+        let dir = std::env::temp_dir().join(format!("compass-history-{}", std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        let history = History::open(dir.to_str().unwrap());
+        assert_eq!(history.latest().unwrap(), None);
+
+        let mut older = Snapshot::new(&[], 1, 10);
+        older.timestamp = 100;
+        let mut newer = older.clone();
+        newer.timestamp = 200;
+        newer.commit = Some("abc123".to_string());
+        history.record(&newer).unwrap();
+        history.record(&older).unwrap();
+
+        let snapshots = history.snapshots().unwrap();
+        assert_eq!(snapshots, [older, newer.clone()]);
+        assert_eq!(history.latest().unwrap(), Some(newer));
+        fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_urls_are_remote() {
+        assert_eq!(
+            History::open("https://metrics.example.com/compass"),
+            History::Remote("https://metrics.example.com/compass".to_string())
+        );
+        assert_eq!(
+            History::open(DEFAULT_HISTORY_DIR),
+            History::Dir(PathBuf::from(".compass/history"))
+        );
+    }
+}
@@ -0,0 +1,104 @@
+//! The git pre-commit hook.
+//!
+//! `compass hook install` writes a hook that runs `compass hook run`, which
+//! analyzes the staged version of each staged file. Reading blobs from the
+//! index rather than the working tree means unstaged edits can neither hide
+//! a finding nor cause one in the commit.
+
+use crate::diff::git_in;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// Identifies hooks written by [`install`], so reinstalling replaces them
+/// but never someone else's hook.
+const MARKER: &str = "# Installed by `compass hook install`.";
+
+/// The hook script, passing `config_file` to every run if given.
+pub fn script(config_file: Option<&str>) -> String {
+    let mut command = String::from("exec compass hook run");
+    if let Some(config_file) = config_file {
+        command.push(' ');
+        command.push_str(&shell_quote(config_file));
+    }
+    format!("#!/bin/sh\n{}\n{}\n", MARKER, command)
+}
+
+/// Writes the pre-commit hook for the repository containing `dir` and
+/// returns its path. An existing hook that compass didn't install is only
+/// replaced with `force`.
+pub fn install(dir: &Path, config_file: Option<&str>, force: bool) -> Result<PathBuf, String> {
+    // Respects core.hooksPath and linked worktrees.
+    let hooks = git_in(dir, &["rev-parse", "--git-path", "hooks"])?;
+    let hooks = dir.join(hooks.trim());
+    let path = hooks.join("pre-commit");
+
+    if let Ok(existing) = fs::read_to_string(&path) {
+        if !existing.contains(MARKER) && !force {
+            return Err(format!(
+                "{} already exists; rerun with --force to replace it",
+                path.display()
+            ));
+        }
+    }
+
+    fs::create_dir_all(&hooks)
+        .and_then(|_| fs::write(&path, script(config_file)))
+        .map_err(|e| format!("failed to write '{}': {}", path.display(), e))?;
+    make_executable(&path)?;
+    Ok(path)
+}
+
+#[cfg(unix)]
+fn make_executable(path: &Path) -> Result<(), String> {
+    use std::os::unix::fs::PermissionsExt;
+    fs::set_permissions(path, fs::Permissions::from_mode(0o755))
+        .map_err(|e| format!("failed to make '{}' executable: {}", path.display(), e))
+}
+
+#[cfg(not(unix))]
+fn make_executable(_path: &Path) -> Result<(), String> {
+    Ok(())
+}
+
+/// Files added, copied, modified or renamed in the index, relative to `dir`.
+pub fn staged_files(dir: &Path) -> Result<Vec<String>, String> {
+    let names = git_in(
+        dir,
+        &[
+            "diff",
+            "--cached",
+            "--name-only",
+            "--relative",
+            "--diff-filter=ACMR",
+            "-z",
+        ],
+    )?;
+    Ok(names
+        .split('\0')
+        .filter(|name| !name.is_empty())
+        .map(str::to_string)
+        .collect())
+}
+
+/// The staged contents of `path`, relative to `dir`.
+pub fn staged_content(dir: &Path, path: &str) -> Result<String, String> {
+    git_in(dir, &["cat-file", "blob", &format!(":./{}", path)])
+}
+
+fn shell_quote(value: &str) -> String {
+    format!("'{}'", value.replace('\'', r"'\''"))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_script_quotes_the_config_path() {
+        assert_eq!(
+            script(None),
+            "#!/bin/sh\n# Installed by `compass hook install`.\nexec compass hook run\n"
+        );
+        assert!(script(Some("it's.toml")).ends_with("exec compass hook run 'it'\\''s.toml'\n"));
+    }
+}
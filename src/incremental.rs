@@ -0,0 +1,189 @@
+//! Symbol-level incremental analysis, for `compass watch` and `compass lsp`.
+//!
+//! A [`Snapshot`] remembers each top-level declaration of a file, such as a
+//! function, a method or a `var` block, by a hash of its text, along with
+//! what the rules whose check is of [`Granularity::Declaration`] found in
+//! it. Analyzing the file again with the snapshot runs those rules only on
+//! the declarations that are new or changed, and moves the findings of the
+//! rest to where their declaration now starts. Query rules and checks of
+//! [`Granularity::File`] still run on the whole file.
+//!
+//! A snapshot only applies to the rule set it was made with, and to the
+//! same package when one of the reused checks reads it; otherwise every
+//! declaration is analyzed again.
+//!
+//! [`Granularity::Declaration`]: crate::checks::Granularity::Declaration
+//! [`Granularity::File`]: crate::checks::Granularity::File
+
+use crate::analyzer::AnalysisResult;
+use tree_sitter::Node;
+
+/// What an analysis found in each top-level declaration of a file.
+#[derive(Debug, Clone, Default)]
+pub struct Snapshot {
+    /// A hash of what the findings depend on besides the declarations: the
+    /// rules and, when a reused check reads it, the package.
+    pub(crate) context: String,
+    pub(crate) declarations: Vec<Declaration>,
+}
+
+#[derive(Debug, Clone)]
+pub(crate) struct Declaration {
+    pub(crate) hash: String,
+    pub(crate) start: Start,
+    /// Indexed by rule, before suppressions; empty for rules that run on the
+    /// whole file.
+    pub(crate) results: Vec<Vec<AnalysisResult>>,
+}
+
+/// Where a declaration starts: its byte offset, and its 0-based row and
+/// column.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) struct Start {
+    byte: usize,
+    row: usize,
+    column: usize,
+}
+
+impl Start {
+    pub(crate) fn of(node: Node) -> Start {
+        let position = node.start_position();
+        Start {
+            byte: node.start_byte(),
+            row: position.row,
+            column: position.column,
+        }
+    }
+}
+
+impl Snapshot {
+    /// Takes the first declaration with the text hashing to `hash`, with its
+    /// findings moved to `start`.
+    pub(crate) fn take(&mut self, hash: &str, start: Start) -> Option<Vec<Vec<AnalysisResult>>> {
+        let index = self.declarations.iter().position(|d| d.hash == hash)?;
+        let declaration = self.declarations.remove(index);
+        let shift = Shift {
+            from: declaration.start,
+            to: start,
+        };
+        Some(
+            declaration
+                .results
+                .into_iter()
+                .map(|results| results.into_iter().map(|r| shift.result(r)).collect())
+                .collect(),
+        )
+    }
+}
+
+/// Moves positions inside a declaration that moved from `from` to `to`.
+struct Shift {
+    from: Start,
+    to: Start,
+}
+
+impl Shift {
+    fn result(&self, mut result: AnalysisResult) -> AnalysisResult {
+        (result.line, result.column) = self.position(result.line, result.column);
+        (result.end_line, result.end_column) = self.position(result.end_line, result.end_column);
+        result.start_byte = self.byte(result.start_byte);
+        result.end_byte = self.byte(result.end_byte);
+        if let Some(fix) = &mut result.fix {
+            for edit in &mut fix.edits {
+                edit.start_byte = self.byte(edit.start_byte);
+                edit.end_byte = self.byte(edit.end_byte);
+            }
+        }
+        // Locations in other files stay where they are.
+        for location in result.related.iter_mut().filter(|l| l.file.is_none()) {
+            (location.line, location.column) = self.position(location.line, location.column);
+            (location.end_line, location.end_column) =
+                self.position(location.end_line, location.end_column);
+            location.start_byte = self.byte(location.start_byte);
+            location.end_byte = self.byte(location.end_byte);
+        }
+        result
+    }
+
+    fn byte(&self, byte: usize) -> usize {
+        byte - self.from.byte + self.to.byte
+    }
+
+    /// A 1-based line and column. Only the declaration's first line can
+    /// start at another column.
+    fn position(&self, line: usize, column: usize) -> (usize, usize) {
+        let column = if line == self.from.row + 1 {
+            column - self.from.column + self.to.column
+        } else {
+            column
+        };
+        (line - self.from.row + self.to.row, column)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::fix::{Fix, TextEdit};
+
+    fn declaration(
+        hash: &str,
+        byte: usize,
+        row: usize,
+        results: Vec<AnalysisResult>,
+    ) -> Declaration {
+        Declaration {
+            hash: hash.to_string(),
+            start: Start {
+                byte,
+                row,
+                column: 0,
+            },
+            results: vec![results],
+        }
+    }
+
+    #[test]
+    fn test_reused_findings_move_with_their_declaration() {
+        let panic = AnalysisResult {
+            rule_name: "panic_usage".to_string(),
+            line: 5,
+            column: 2,
+            end_line: 5,
+            end_column: 10,
+            start_byte: 40,
+            end_byte: 48,
+            fix: Some(Fix {
+                description: "Remove".to_string(),
+                edits: vec![TextEdit {
+                    start_byte: 40,
+                    end_byte: 48,
+                    replacement: String::new(),
+                }],
+            }),
+            ..Default::default()
+        };
+        let mut snapshot = Snapshot {
+            context: "rules".to_string(),
+            declarations: vec![
+                declaration("a", 0, 0, Vec::new()),
+                declaration("b", 30, 3, vec![panic]),
+            ],
+        };
+
+        // Two lines, 12 bytes, were added above `b`.
+        let start = Start {
+            byte: 42,
+            row: 5,
+            column: 0,
+        };
+        let moved = snapshot.take("b", start).unwrap().remove(0).remove(0);
+        assert_eq!((moved.line, moved.column, moved.end_line), (7, 2, 7));
+        assert_eq!((moved.start_byte, moved.end_byte), (52, 60));
+        assert_eq!(moved.fix.unwrap().edits[0].start_byte, 52);
+        assert!(
+            snapshot.take("b", start).is_none(),
+            "Each declaration is taken once"
+        );
+    }
+}
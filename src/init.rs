@@ -0,0 +1,453 @@
+//! `compass init`: a starter `.compass.toml` for an existing repository.
+//!
+//! [`inspect`] surveys the tree: its Go modules and `go.work`, which
+//! packages have tests, `testdata` directories of Go files, generated files
+//! and whether their header follows the `// Code generated ... DO NOT
+//! EDIT.` convention the default exclusions recognize, other linters'
+//! configs and the CI system in use. [`Survey::propose`] turns that into a
+//! config, starting from the golangci-lint migration when there is one, and
+//! [`ci_job`] writes a CI job running compass.
+
+use crate::migrate::{self, GOLANGCI_CONFIG_FILES};
+use crate::preset::Preset;
+use crate::project::{is_generated, ProjectConfig, RuleOverride};
+use crate::workspace::Workspace;
+use std::collections::BTreeSet;
+use std::fs::{self, File};
+use std::io::Read;
+use std::path::{Path, PathBuf};
+
+/// How much of a file is searched for a generated-code marker, as the
+/// default exclusions do.
+const HEADER_BYTES: u64 = 4096;
+
+/// Header phrases of generators that don't follow the Go convention, so
+/// the default exclusions miss their output.
+const GENERATED_MARKERS: &[&str] = &[
+    "Code generated",
+    "DO NOT EDIT",
+    "@generated",
+    "AUTO-GENERATED",
+    "Autogenerated",
+    "automatically generated",
+];
+
+/// Linter configs compass can't carry over, noted in the proposal.
+const OTHER_LINTER_CONFIGS: &[&str] = &["staticcheck.conf", "revive.toml", ".revive.toml"];
+
+/// Directories never searched: the go command ignores them, or they hold
+/// someone else's code.
+const SKIPPED_DIRS: &[&str] = &["vendor", "node_modules"];
+
+pub const GITHUB_WORKFLOW: &str = ".github/workflows/compass.yml";
+pub const GITLAB_CI_FILE: &str = ".gitlab-ci.yml";
+/// Where the GitLab job goes when `.gitlab-ci.yml` already exists, for it
+/// to include.
+pub const GITLAB_INCLUDE: &str = ".gitlab/ci/compass.yml";
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Ci {
+    GitHub,
+    GitLab,
+}
+
+impl Ci {
+    pub const NAMES: &'static str = "github, gitlab, none";
+
+    /// `none` is `Some(None)`.
+    pub fn from_name(name: &str) -> Option<Option<Ci>> {
+        match name {
+            "github" => Some(Some(Ci::GitHub)),
+            "gitlab" => Some(Some(Ci::GitLab)),
+            "none" => Some(None),
+            _ => None,
+        }
+    }
+
+    pub fn name(ci: Option<Ci>) -> &'static str {
+        match ci {
+            Some(Ci::GitHub) => "github",
+            Some(Ci::GitLab) => "gitlab",
+            None => "none",
+        }
+    }
+}
+
+/// What [`inspect`] found. Paths are relative to the inspected directory,
+/// with `/` separators.
+#[derive(Debug, Default)]
+pub struct Survey {
+    /// Module paths, and the directory of each.
+    pub modules: Vec<(String, String)>,
+    pub go_work: bool,
+    pub go_files: usize,
+    pub test_files: usize,
+    /// Directories with Go files other than tests.
+    pub packages: BTreeSet<String>,
+    /// The packages that have tests.
+    pub tested: BTreeSet<String>,
+    /// `testdata` directories holding Go files, with a trailing `/`.
+    pub testdata: Vec<String>,
+    /// Files with the conventional generated-code header, which are
+    /// excluded already.
+    pub generated: usize,
+    /// Files whose header says they're generated some other way.
+    pub unmarked: Vec<String>,
+    /// The golangci-lint config, if any.
+    pub golangci: Option<String>,
+    /// Configs of other linters, which compass can't read.
+    pub other_linters: Vec<String>,
+    pub ci: Option<Ci>,
+}
+
+/// The starter config and why it sets what it sets.
+pub struct Proposal {
+    pub config: ProjectConfig,
+    /// One comment line for each decision.
+    pub notes: Vec<String>,
+}
+
+impl Proposal {
+    /// The `.compass.toml` to write, the notes as its header comment.
+    pub fn to_toml(&self) -> Result<String, toml::ser::Error> {
+        let mut out = String::from("# Written by `compass init`.\n");
+        for note in &self.notes {
+            out.push_str(&format!("# - {}\n", note));
+        }
+        let body = toml::to_string(&self.config)?;
+        if !body.is_empty() {
+            out.push('\n');
+            out.push_str(&body);
+        }
+        Ok(out)
+    }
+}
+
+/// Surveys the repository at `dir`.
+pub fn inspect(dir: &Path) -> Result<Survey, String> {
+    let mut survey = Survey::default();
+    let workspace = Workspace::discover(dir)
+        .map_err(|e| format!("failed to read '{}': {}", dir.display(), e))?;
+    let root = dir
+        .canonicalize()
+        .map_err(|e| format!("failed to read '{}': {}", dir.display(), e))?;
+    survey.go_work = workspace.go_work.is_some();
+    for module in &workspace.modules {
+        let relative = module.root.strip_prefix(&root).unwrap_or(&module.root);
+        survey
+            .modules
+            .push((module.path.clone(), slashed(relative, "")));
+    }
+
+    let mut pending = vec![root.clone()];
+    while let Some(current) = pending.pop() {
+        let entries = fs::read_dir(&current)
+            .map_err(|e| format!("failed to read '{}': {}", current.display(), e))?;
+        let mut paths: Vec<PathBuf> = entries
+            .filter_map(|entry| Some(entry.ok()?.path()))
+            .collect();
+        paths.sort();
+        for path in paths {
+            let name = path
+                .file_name()
+                .map(|name| name.to_string_lossy().into_owned())
+                .unwrap_or_default();
+            let relative = path.strip_prefix(&root).unwrap_or(&path).to_path_buf();
+            if path.is_dir() {
+                if !name.starts_with('.') && !SKIPPED_DIRS.contains(&name.as_str()) {
+                    pending.push(path);
+                }
+                continue;
+            }
+            if !name.ends_with(".go") {
+                continue;
+            }
+            let package = relative.parent().unwrap_or(Path::new(""));
+            if let Some(testdata) = testdata_dir(package) {
+                let testdata = slashed(&testdata, "/");
+                if !survey.testdata.contains(&testdata) {
+                    survey.testdata.push(testdata);
+                }
+                continue;
+            }
+            survey.go_files += 1;
+            let package = slashed(package, "");
+            if name.ends_with("_test.go") {
+                survey.test_files += 1;
+                survey.tested.insert(package);
+                continue;
+            }
+            survey.packages.insert(package);
+            let header = header(&path);
+            if is_generated(&header) {
+                survey.generated += 1;
+            } else if header
+                .lines()
+                .take_while(|line| !line.starts_with("package "))
+                .any(|line| line.starts_with("//") && has_marker(line))
+            {
+                survey.unmarked.push(slashed(&relative, ""));
+            }
+        }
+    }
+    survey.testdata.sort();
+    survey
+        .tested
+        .retain(|package| survey.packages.contains(package));
+
+    survey.golangci = GOLANGCI_CONFIG_FILES
+        .iter()
+        .find(|name| dir.join(name).is_file())
+        .map(|name| name.to_string());
+    survey.other_linters = OTHER_LINTER_CONFIGS
+        .iter()
+        .filter(|name| dir.join(name).is_file())
+        .map(|name| name.to_string())
+        .collect();
+    survey.ci = if dir.join(GITLAB_CI_FILE).is_file() {
+        Some(Ci::GitLab)
+    } else if dir.join(".github").is_dir() {
+        Some(Ci::GitHub)
+    } else {
+        None
+    };
+    Ok(survey)
+}
+
+impl Survey {
+    /// The starter config for the repository at `dir`, on top of `preset`
+    /// if one is given.
+    pub fn propose(&self, dir: &Path, preset: Option<Preset>) -> Result<Proposal, String> {
+        let mut notes = Vec::new();
+        let mut config = match &self.golangci {
+            Some(golangci) => {
+                let migration = migrate::from_golangci_lint(dir.join(golangci))?;
+                notes.push(format!(
+                    "Rules and exclusions carried over from {}, as `compass migrate --from golangci-lint` does.",
+                    golangci
+                ));
+                if !migration.unmapped.is_empty() {
+                    notes.push(format!(
+                        "No compass equivalent for: {}.",
+                        migration.unmapped.join(", ")
+                    ));
+                }
+                migration.config
+            }
+            None => ProjectConfig::default(),
+        };
+        for other in &self.other_linters {
+            notes.push(format!(
+                "{} isn't carried over; compass can't read it.",
+                other
+            ));
+        }
+        if let Some(preset) = preset {
+            config.preset = Some(preset.as_str().to_string());
+        }
+
+        match self.modules.len() {
+            0 => notes.push("No go.mod found; the dependency rules have nothing to read.".to_string()),
+            1 => {}
+            count => notes.push(format!(
+                "{} modules{}; a `.compass.toml` in a module's directory applies to that module alone.",
+                count,
+                if self.go_work { " in go.work" } else { "" }
+            )),
+        }
+
+        for testdata in &self.testdata {
+            if !config.exclude.contains(testdata) {
+                config.exclude.push(testdata.clone());
+            }
+        }
+        if !self.testdata.is_empty() {
+            notes.push("testdata directories hold fixtures the go command never builds, so they're excluded.".to_string());
+        }
+        if self.generated > 0 {
+            notes.push(format!(
+                "{} generated files with a `// Code generated ... DO NOT EDIT.` header are excluded without being listed.",
+                self.generated
+            ));
+        }
+        if !self.unmarked.is_empty() {
+            config.exclude.extend(self.unmarked.iter().cloned());
+            notes.push(format!(
+                "{} files say they're generated without the standard header, so they're excluded by name.",
+                self.unmarked.len()
+            ));
+        }
+
+        if !self.packages.is_empty() && self.tested.len() == self.packages.len() {
+            config
+                .rules
+                .entry("untested_export".to_string())
+                .or_insert_with(|| RuleOverride {
+                    enabled: Some(true),
+                    ..Default::default()
+                });
+            notes.push(
+                "Every package has tests, so `untested_export` is on to keep it that way."
+                    .to_string(),
+            );
+        } else if self.test_files == 0 {
+            notes.push("No tests found; `untested_export` stays off.".to_string());
+        }
+        Ok(Proposal { config, notes })
+    }
+}
+
+/// The CI job running compass on `ci`, as the file to write and its
+/// contents. For GitLab, when `.gitlab-ci.yml` exists, the job goes in
+/// [`GITLAB_INCLUDE`] for it to include.
+pub fn ci_job(dir: &Path, ci: Ci, baseline: Option<&str>) -> (String, String) {
+    let baseline = baseline
+        .map(|baseline| format!(" --baseline {}", baseline))
+        .unwrap_or_default();
+    match ci {
+        Ci::GitHub => (
+            GITHUB_WORKFLOW.to_string(),
+            format!(
+                "# Written by `compass init`.
+name: compass
+
+on:
+  pull_request:
+  push:
+    branches: [main]
+
+jobs:
+  compass:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - name: Install compass
+        run: curl -fsSL https://raw.githubusercontent.com/lyledean1/compass/main/install.sh | bash
+      - name: Analyze
+        run: compass --format github --fail-on error{} .
+",
+                baseline
+            ),
+        ),
+        Ci::GitLab => {
+            let path = if dir.join(GITLAB_CI_FILE).is_file() {
+                GITLAB_INCLUDE
+            } else {
+                GITLAB_CI_FILE
+            };
+            (
+                path.to_string(),
+                format!(
+                    "# Written by `compass init`.
+compass:
+  stage: test
+  script:
+    - curl -fsSL https://raw.githubusercontent.com/lyledean1/compass/main/install.sh | bash
+    - compass --format gitlab-codequality --fail-on error{} . > gl-code-quality-report.json
+  artifacts:
+    when: always
+    reports:
+      codequality: gl-code-quality-report.json
+",
+                    baseline
+                ),
+            )
+        }
+    }
+}
+
+/// The `testdata` directory `dir` is in, if any.
+fn testdata_dir(dir: &Path) -> Option<PathBuf> {
+    dir.ancestors()
+        .find(|ancestor| ancestor.file_name().is_some_and(|name| name == "testdata"))
+        .map(Path::to_path_buf)
+}
+
+fn has_marker(line: &str) -> bool {
+    let lower = line.to_ascii_lowercase();
+    GENERATED_MARKERS
+        .iter()
+        .any(|marker| lower.contains(&marker.to_ascii_lowercase()))
+}
+
+fn header(path: &Path) -> String {
+    let mut header = Vec::new();
+    let _ = File::open(path).and_then(|file| file.take(HEADER_BYTES).read_to_end(&mut header));
+    String::from_utf8_lossy(&header).into_owned()
+}
+
+/// `path` with `/` separators and `suffix` after it, or `.` for the root.
+fn slashed(path: &Path, suffix: &str) -> String {
+    let parts: Vec<String> = path
+        .components()
+        .map(|part| part.as_os_str().to_string_lossy().into_owned())
+        .collect();
+    if parts.is_empty() {
+        ".".to_string()
+    } else {
+        format!("{}{}", parts.join("/"), suffix)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn write(path: &Path, content: &str) {
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(path, content).unwrap();
+    }
+
+    #[test]
+    fn test_proposal_follows_the_repository() {
+        let dir = std::env::temp_dir().join(format!("compass-init-{}", std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        write(&dir.join("go.mod"), "module example.com/app\n\ngo 1.22\n");
+        write(&dir.join("main.go"), "package main\n");
+        write(&dir.join("main_test.go"), "package main\n");
+        write(&dir.join("store/store.go"), "package store\n");
+        write(&dir.join("store/store_test.go"), "package store_test\n");
+        write(&dir.join("store/testdata/broken.go"), "package broken\n");
+        write(
+            &dir.join("api/api.pb.go"),
+            "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage api\n",
+        );
+        write(&dir.join("api/api_test.go"), "package api\n");
+        write(
+            &dir.join("db/queries.go"),
+            "// Code generated by sqlc. Edit queries.sql instead.\n\npackage db\n",
+        );
+        write(&dir.join("db/db_test.go"), "package db\n");
+        write(&dir.join(".gitlab-ci.yml"), "stages: [test]\n");
+
+        let survey = inspect(&dir).unwrap();
+        assert_eq!(
+            survey.modules,
+            [("example.com/app".to_string(), ".".to_string())]
+        );
+        assert_eq!((survey.go_files, survey.test_files), (8, 4));
+        assert_eq!(survey.testdata, ["store/testdata/"]);
+        assert_eq!(survey.generated, 1);
+        assert_eq!(survey.unmarked, ["db/queries.go"]);
+        assert_eq!(survey.ci, Some(Ci::GitLab));
+
+        let proposal = survey.propose(&dir, None).unwrap();
+        assert_eq!(
+            proposal.config.exclude,
+            ["store/testdata/", "db/queries.go"]
+        );
+        assert_eq!(proposal.config.rules["untested_export"].enabled, Some(true));
+        let text = proposal.to_toml().unwrap();
+        assert!(text.starts_with("# Written by `compass init`.\n# - testdata directories"));
+        ProjectConfig::from_toml(&text).unwrap();
+
+        assert_eq!(
+            ci_job(&dir, Ci::GitLab, Some("compass-baseline.json")).0,
+            GITLAB_INCLUDE
+        );
+        let (path, workflow) = ci_job(&dir, Ci::GitHub, None);
+        assert_eq!(path, GITHUB_WORKFLOW);
+        assert!(workflow.contains("run: compass --format github --fail-on error .\n"));
+        fs::remove_dir_all(&dir).unwrap();
+    }
+}
@@ -0,0 +1,102 @@
+use std::path::Path;
+use tree_sitter::Language;
+
+pub const SUPPORTED_EXTENSIONS: &str =
+    ".rs, .go, .js, .jsx, .zig, .java, .cpp, .cc, .cxx, .h, .hpp, .swift";
+
+const RUST_CONFIG: &str = include_str!("../config/rust.toml");
+const GO_CONFIG: &str = include_str!("../config/go.toml");
+const JAVASCRIPT_CONFIG: &str = include_str!("../config/javascript.toml");
+const JAVA_CONFIG: &str = include_str!("../config/java.toml");
+const ZIG_CONFIG: &str = include_str!("../config/zig.toml");
+const CPP_CONFIG: &str = include_str!("../config/cpp.toml");
+const SWIFT_CONFIG: &str = include_str!("../config/swift.toml");
+
+#[derive(Clone, Copy)]
+pub enum SupportedLanguage {
+    Rust,
+    Go,
+    JavaScript,
+    Zig,
+    Java,
+    Cpp,
+    Swift,
+}
+
+impl SupportedLanguage {
+    pub const ALL: [SupportedLanguage; 7] = [
+        SupportedLanguage::Rust,
+        SupportedLanguage::Go,
+        SupportedLanguage::JavaScript,
+        SupportedLanguage::Zig,
+        SupportedLanguage::Java,
+        SupportedLanguage::Cpp,
+        SupportedLanguage::Swift,
+    ];
+
+    pub fn from_path(file_path: &str) -> Option<Self> {
+        let extension = Path::new(file_path)
+            .extension()
+            .and_then(|ext| ext.to_str())?
+            .to_ascii_lowercase();
+
+        match extension.as_str() {
+            "rs" => Some(SupportedLanguage::Rust),
+            "go" => Some(SupportedLanguage::Go),
+            "js" | "jsx" => Some(SupportedLanguage::JavaScript),
+            "zig" => Some(SupportedLanguage::Zig),
+            "java" => Some(SupportedLanguage::Java),
+            "cpp" | "cc" | "cxx" | "h" | "hpp" => Some(SupportedLanguage::Cpp),
+            "swift" => Some(SupportedLanguage::Swift),
+            _ => None,
+        }
+    }
+
+    pub fn tree_sitter_language(&self) -> Language {
+        match self {
+            SupportedLanguage::Rust => tree_sitter_rust::LANGUAGE.into(),
+            SupportedLanguage::Go => tree_sitter_go::LANGUAGE.into(),
+            SupportedLanguage::JavaScript => tree_sitter_javascript::LANGUAGE.into(),
+            SupportedLanguage::Zig => tree_sitter_zig::LANGUAGE.into(),
+            SupportedLanguage::Java => tree_sitter_java::LANGUAGE.into(),
+            SupportedLanguage::Cpp => tree_sitter_cpp::LANGUAGE.into(),
+            SupportedLanguage::Swift => tree_sitter_swift::LANGUAGE.into(),
+        }
+    }
+
+    pub fn config_key(&self) -> &'static str {
+        match self {
+            SupportedLanguage::Rust => "rust",
+            SupportedLanguage::Go => "go",
+            SupportedLanguage::JavaScript => "javascript",
+            SupportedLanguage::Zig => "zig",
+            SupportedLanguage::Java => "java",
+            SupportedLanguage::Cpp => "cpp",
+            SupportedLanguage::Swift => "swift",
+        }
+    }
+
+    pub fn display_name(&self) -> &'static str {
+        match self {
+            SupportedLanguage::Rust => "Rust",
+            SupportedLanguage::Go => "Go",
+            SupportedLanguage::JavaScript => "JavaScript",
+            SupportedLanguage::Zig => "Zig",
+            SupportedLanguage::Java => "Java",
+            SupportedLanguage::Cpp => "C++",
+            SupportedLanguage::Swift => "Swift",
+        }
+    }
+
+    pub fn default_config(&self) -> &'static str {
+        match self {
+            SupportedLanguage::Rust => RUST_CONFIG,
+            SupportedLanguage::Go => GO_CONFIG,
+            SupportedLanguage::JavaScript => JAVASCRIPT_CONFIG,
+            SupportedLanguage::Zig => ZIG_CONFIG,
+            SupportedLanguage::Java => JAVA_CONFIG,
+            SupportedLanguage::Cpp => CPP_CONFIG,
+            SupportedLanguage::Swift => SWIFT_CONFIG,
+        }
+    }
+}
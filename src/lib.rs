@@ -1,3 +1,57 @@
 pub mod analyzer;
+pub mod apidiff;
+pub mod baseline;
+pub mod build;
+pub mod bundle;
+pub mod cache;
+pub mod callgraph;
+pub mod checks;
 pub mod cli;
+pub mod codeowners;
+pub mod compare;
+pub mod complexity;
 pub mod config;
+pub mod deps;
+pub mod diff;
+pub mod docs;
+pub mod dupes;
+pub mod fingerprint;
+pub mod fix;
+pub mod format;
+pub mod history;
+pub mod hook;
+pub mod incremental;
+pub mod init;
+pub mod language;
+pub mod lint;
+pub mod lsp;
+pub mod messages;
+pub mod metadata;
+pub mod metrics;
+pub mod migrate;
+pub mod mode;
+pub mod module;
+pub mod package;
+pub mod package_list;
+pub mod parallel;
+pub mod plugin;
+pub mod policy;
+pub mod postprocess;
+pub mod preset;
+pub mod profile;
+pub mod project;
+pub mod regexp;
+pub mod ruletest;
+pub mod scan;
+pub mod scope;
+pub mod serve;
+pub mod shard;
+pub mod sql;
+pub mod suppression;
+pub mod taint;
+pub mod template;
+pub mod trace;
+pub mod vuln;
+pub mod walk;
+pub mod watch;
+pub mod workspace;
@@ -0,0 +1,364 @@
+//! `compass config lint`: every problem in a rule config and in the
+//! `.compass.toml` files that apply to a directory, rather than just the
+//! first, which is all loading a config reports.
+//!
+//! Each [`Problem`] carries the line it was found on where that can be
+//! told, so editors can underline it from `--format json`.
+
+use crate::checks::{self, RuleOptions};
+use crate::config::AnalyzerConfig;
+use crate::plugin::Registry;
+use crate::project::{ProjectConfig, RuleOverride, PROJECT_CONFIG_FILE};
+use serde::Serialize;
+use std::collections::BTreeSet;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Level {
+    /// Loading the file fails, or a rule can't run as written.
+    Error,
+    /// The file loads, but is written in a way that may stop working.
+    Warning,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct Problem {
+    pub file: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub line: Option<usize>,
+    pub level: Level,
+    pub message: String,
+}
+
+/// Lints a rule config: its syntax and plugins, every rule's severity,
+/// confidence, stage and check, and the options of the checks it names.
+/// Enabled deprecated rules and replacements the config lacks are warned
+/// about.
+/// `base_dir` is what `plugins` are resolved against, or `None` for a
+/// config that isn't a file and can't load any.
+pub fn lint_rules(
+    file: &str,
+    content: &str,
+    base_dir: Option<&Path>,
+    registry: &Registry,
+) -> Vec<Problem> {
+    let mut problems = Vec::new();
+    let mut config: AnalyzerConfig = match toml::from_str(content) {
+        Ok(config) => config,
+        Err(e) => return vec![syntax_error(file, content, &e)],
+    };
+    if let Some(base_dir) = base_dir {
+        if let Err(e) = config.load_plugins(base_dir) {
+            problems.push(Problem::error(file, None, e.to_string()));
+        }
+    }
+
+    for rule in &config.rules {
+        let line = line_of(content, &format!("name = \"{}\"", rule.name));
+        let mut messages = rule.problems();
+        if let Some(check) = rule
+            .check
+            .as_ref()
+            .filter(|check| registry.get(check).is_none())
+        {
+            messages.push(format!(
+                "rule '{}' references unknown check '{}'",
+                rule.name, check
+            ));
+        }
+        problems.extend(
+            messages
+                .into_iter()
+                .map(|message| Problem::error(file, line, message)),
+        );
+        problems.extend(deprecated(&rule.options).map(|message| {
+            Problem::warning(file, line, format!("rule '{}': {}", rule.name, message))
+        }));
+        if rule.enabled {
+            problems.extend(
+                rule.deprecation()
+                    .map(|message| Problem::warning(file, line, message)),
+            );
+        }
+        if let Some(replacement) = rule
+            .replaced_by
+            .as_ref()
+            .filter(|replacement| !config.rules.iter().any(|r| &r.name == *replacement))
+        {
+            problems.push(Problem::warning(
+                file,
+                line,
+                format!(
+                    "rule '{}' is replaced by '{}', which this config doesn't define",
+                    rule.name, replacement
+                ),
+            ));
+        }
+    }
+    problems
+}
+
+/// Lints a `.compass.toml`: its syntax, severities and confidences, that
+/// each rule it configures is one of `rules`, and the options it sets
+/// against that rule's check. `rules` pairs each rule name with its check.
+pub fn lint_project(file: &str, content: &str, rules: &[(String, Option<String>)]) -> Vec<Problem> {
+    let config: ProjectConfig = match toml::from_str(content) {
+        Ok(config) => config,
+        Err(e) => return vec![syntax_error(file, content, &e)],
+    };
+    let mut problems: Vec<Problem> = config
+        .problems()
+        .into_iter()
+        .map(|message| Problem::error(file, None, message))
+        .collect();
+
+    for (name, rule) in &config.rules {
+        let line = line_of(content, &format!("[rules.{}]", name))
+            .or_else(|| line_of(content, &format!("[rules.{}.options]", name)));
+        problems.extend(lint_override(file, line, name, rule, rules));
+    }
+    // Overrides are reported at their block, as a setting can be inline.
+    let blocks: Vec<usize> = content
+        .lines()
+        .enumerate()
+        .filter(|(_, line)| line.trim() == "[[overrides]]")
+        .map(|(index, _)| index + 1)
+        .collect();
+    for (index, block) in config.overrides.iter().enumerate() {
+        for (name, setting) in &block.rules {
+            let line = blocks.get(index).copied();
+            problems.extend(lint_override(
+                file,
+                line,
+                name,
+                &setting.to_override(),
+                rules,
+            ));
+        }
+    }
+    problems
+}
+
+/// The problems with `.compass.toml` setting the rule `name` to `rule`.
+fn lint_override(
+    file: &str,
+    line: Option<usize>,
+    name: &str,
+    rule: &RuleOverride,
+    rules: &[(String, Option<String>)],
+) -> Vec<Problem> {
+    let Some((_, check)) = rules.iter().find(|(known, _)| known == name) else {
+        return vec![Problem::error(
+            file,
+            line,
+            format!("unknown rule '{}'", name),
+        )];
+    };
+    let mut problems = Vec::new();
+    // The options are merged onto the rule's own, so only names and
+    // types can be told apart from a whole config here.
+    if let Some(check) = check {
+        let options = RuleOptions::new(rule.options.clone());
+        problems.extend(
+            checks::option_errors(check, &options)
+                .into_iter()
+                .map(|e| Problem::error(file, line, format!("rule '{}': {}", name, e))),
+        );
+    }
+    problems.extend(
+        deprecated(&rule.options)
+            .map(|message| Problem::warning(file, line, format!("rule '{}': {}", name, message))),
+    );
+    problems
+}
+
+/// The `.compass.toml` files that apply to `dir`, outermost first, found
+/// the way [`crate::project::EffectiveConfig::for_path`] finds them but
+/// without stopping at one that doesn't load.
+pub fn project_files(dir: &Path) -> Vec<PathBuf> {
+    let mut files = Vec::new();
+    for dir in dir.ancestors() {
+        let candidate = dir.join(PROJECT_CONFIG_FILE);
+        let mut stop = dir.join(".git").exists();
+        if let Ok(content) = fs::read_to_string(&candidate) {
+            stop |= content
+                .parse::<toml::Table>()
+                .ok()
+                .and_then(|table| table.get("root").and_then(toml::Value::as_bool))
+                .unwrap_or(false);
+            files.push(candidate);
+        }
+        if stop {
+            break;
+        }
+    }
+    files.reverse();
+    files
+}
+
+/// The rule names `compass config lint` accepts in `.compass.toml`: those
+/// of every given config.
+pub fn known_rules<'a>(
+    configs: impl IntoIterator<Item = &'a AnalyzerConfig>,
+) -> Vec<(String, Option<String>)> {
+    let mut seen = BTreeSet::new();
+    configs
+        .into_iter()
+        .flat_map(|config| &config.rules)
+        .filter(|rule| seen.insert(rule.name.clone()))
+        .map(|rule| (rule.name.clone(), rule.check.clone()))
+        .collect()
+}
+
+impl Problem {
+    fn error(file: &str, line: Option<usize>, message: String) -> Self {
+        Problem {
+            file: file.to_string(),
+            line,
+            level: Level::Error,
+            message,
+        }
+    }
+
+    fn warning(file: &str, line: Option<usize>, message: String) -> Self {
+        Problem {
+            level: Level::Warning,
+            ..Problem::error(file, line, message)
+        }
+    }
+}
+
+fn syntax_error(file: &str, content: &str, error: &toml::de::Error) -> Problem {
+    let line = error.span().map(|span| {
+        content[..span.start.min(content.len())]
+            .lines()
+            .count()
+            .max(1)
+    });
+    Problem::error(file, line, error.message().to_string())
+}
+
+/// The one-based line of the first line containing `needle`, ignoring
+/// spaces around `=` so that `name="x"` is found too.
+fn line_of(content: &str, needle: &str) -> Option<usize> {
+    let squeeze = |text: &str| text.replace(' ', "");
+    let needle = squeeze(needle);
+    content
+        .lines()
+        .position(|line| squeeze(line).contains(&needle))
+        .map(|index| index + 1)
+}
+
+fn deprecated(options: &toml::Table) -> impl Iterator<Item = String> + '_ {
+    options.keys().filter_map(|key| {
+        checks::deprecated_spelling(key)
+            .map(|spelling| format!("option '{}' is deprecated; write '{}'", key, spelling))
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn messages(problems: &[Problem]) -> Vec<(Option<usize>, Level, &str)> {
+        problems
+            .iter()
+            .map(|problem| (problem.line, problem.level, problem.message.as_str()))
+            .collect()
+    }
+
+    #[test]
+    fn test_rule_configs_report_every_problem() {
+        let content = "[[rules]]\nname = \"loud\"\ncheck = \"go_panic\"\nseverity = \"fatal\"\nmessage = \"m\"\n\n[rules.options]\nallow_test_helpers = \"yes\"\n\n[[rules]]\nname = \"deep\"\ncheck = \"go_panic_reachable\"\nseverity = \"warning\"\nmessage = \"m\"\n\n[rules.options]\nmax-depth = 3\n\n[[rules]]\nname = \"mine\"\ncheck = \"mycorp_no_goto\"\nseverity = \"info\"\nmessage = \"m\"\n";
+        let problems = lint_rules("rules.toml", content, None, &Registry::new());
+        assert_eq!(
+            messages(&problems),
+            [
+                (
+                    Some(2),
+                    Level::Error,
+                    "rule 'loud' has unknown severity 'fatal' (expected one of: error, warning, info, style)"
+                ),
+                (
+                    Some(2),
+                    Level::Error,
+                    "rule 'loud': option 'allow_test_helpers' expects a bool, got a string"
+                ),
+                (
+                    Some(11),
+                    Level::Warning,
+                    "rule 'deep': option 'max-depth' is deprecated; write 'max_depth'"
+                ),
+                (
+                    Some(20),
+                    Level::Error,
+                    "rule 'mine' references unknown check 'mycorp_no_goto'"
+                ),
+            ]
+        );
+
+        let problems = lint_rules(
+            "rules.toml",
+            "[[rules]]\nname = 1\n",
+            None,
+            &Registry::new(),
+        );
+        assert_eq!(problems.len(), 1);
+        assert_eq!(problems[0].line, Some(2));
+    }
+
+    #[test]
+    fn test_deprecated_rules_point_to_their_replacement() {
+        let content = "[[rules]]\nname = \"old\"\nquery = \"(identifier) @id\"\nseverity = \"info\"\nmessage = \"m\"\nstage = \"deprecated\"\nreplaced_by = \"new\"\nenabled = true\n\n[[rules]]\nname = \"odd\"\nquery = \"(identifier) @id\"\nseverity = \"info\"\nmessage = \"m\"\nstage = \"beta\"\n";
+        let problems = lint_rules("rules.toml", content, None, &Registry::new());
+        assert_eq!(
+            messages(&problems),
+            [
+                (
+                    Some(2),
+                    Level::Warning,
+                    "rule 'old' is deprecated; use 'new' instead"
+                ),
+                (
+                    Some(2),
+                    Level::Warning,
+                    "rule 'old' is replaced by 'new', which this config doesn't define"
+                ),
+                (
+                    Some(11),
+                    Level::Error,
+                    "rule 'odd' has unknown stage 'beta' (expected one of: experimental, stable, deprecated)"
+                ),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_project_configs_are_checked_against_the_rules() {
+        let rules = vec![
+            ("panic_usage".to_string(), Some("go_panic".to_string())),
+            ("todo".to_string(), None),
+        ];
+        let content = "[rules.panic_usage.options]\nallow_functions = \"init\"\n\n[rules.panic_usge]\nenabled = false\n\n[rules.todo]\nconfidence = \"sure\"\n\n[[overrides]]\npaths = [\"*_test.go\"]\nrules = { panic_usag = \"off\" }\n";
+        let problems = lint_project(".compass.toml", content, &rules);
+        assert_eq!(
+            messages(&problems),
+            [
+                (
+                    None,
+                    Level::Error,
+                    "rule 'todo' has unknown confidence 'sure' (expected one of: high, medium, low)"
+                ),
+                (
+                    Some(1),
+                    Level::Error,
+                    "rule 'panic_usage': option 'allow_functions' expects a list of strings, got a string"
+                ),
+                (Some(4), Level::Error, "unknown rule 'panic_usge'"),
+                (Some(10), Level::Error, "unknown rule 'panic_usag'"),
+            ]
+        );
+    }
+}
@@ -0,0 +1,391 @@
+//! `compass lsp`: a Language Server Protocol front end over stdio.
+//!
+//! Documents are re-analyzed from the editor's in-memory text on open, change
+//! and save, and published as diagnostics. Code actions offer each finding's
+//! autofix and an inline suppression. Edits re-run the rules that only read
+//! a declaration on the declarations that changed; see [`crate::incremental`].
+
+use crate::analyzer::{AnalysisResult, Severity};
+use crate::incremental::Snapshot;
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use crate::plugin::Registry;
+use crate::project::{ConfigCache, EffectiveConfig};
+use crate::suppression::SuppressionError;
+use serde_json::{json, Value};
+use std::collections::HashMap;
+use std::io::{self, BufRead, Write};
+
+struct Document {
+    text: String,
+    results: Vec<AnalysisResult>,
+    /// What the last analysis found in each declaration.
+    snapshot: Option<Snapshot>,
+}
+
+pub struct Server {
+    configs: ConfigCache,
+    documents: HashMap<String, Document>,
+    shutdown_requested: bool,
+}
+
+impl Server {
+    pub fn new(config_override: Option<String>, registry: Registry) -> Self {
+        Server {
+            configs: ConfigCache::new(config_override, registry),
+            documents: HashMap::new(),
+            shutdown_requested: false,
+        }
+    }
+
+    /// Serves requests until the client sends `exit` or closes the stream,
+    /// and returns the exit status: 0 when `shutdown` came first and 1
+    /// otherwise, as the protocol asks.
+    pub fn serve<R: BufRead, W: Write>(&mut self, mut input: R, mut output: W) -> io::Result<i32> {
+        while let Some(message) = read_message(&mut input)? {
+            let method = message["method"].as_str().unwrap_or("").to_string();
+            if method == "exit" {
+                break;
+            }
+            for outgoing in self.handle(&method, &message) {
+                write_message(&mut output, &outgoing)?;
+            }
+        }
+        Ok(if self.shutdown_requested { 0 } else { 1 })
+    }
+
+    fn handle(&mut self, method: &str, message: &Value) -> Vec<Value> {
+        let params = &message["params"];
+        let id = message.get("id").cloned();
+
+        match method {
+            "initialize" => vec![response(id, initialize_result())],
+            "shutdown" => {
+                self.shutdown_requested = true;
+                vec![response(id, Value::Null)]
+            }
+            "textDocument/didOpen" => {
+                let uri = params["textDocument"]["uri"].as_str().unwrap_or("");
+                let text = params["textDocument"]["text"].as_str().unwrap_or("");
+                self.update(uri, text.to_string())
+            }
+            "textDocument/didChange" => {
+                let uri = params["textDocument"]["uri"].as_str().unwrap_or("");
+                // Full sync: the last change carries the whole document.
+                let text = params["contentChanges"]
+                    .as_array()
+                    .and_then(|changes| changes.last())
+                    .and_then(|change| change["text"].as_str());
+                match text {
+                    Some(text) => self.update(uri, text.to_string()),
+                    None => Vec::new(),
+                }
+            }
+            "textDocument/didSave" => {
+                let uri = params["textDocument"]["uri"].as_str().unwrap_or("");
+                let text = params["text"]
+                    .as_str()
+                    .map(str::to_string)
+                    .or_else(|| self.documents.get(uri).map(|doc| doc.text.clone()));
+                match text {
+                    Some(text) => self.update(uri, text),
+                    None => Vec::new(),
+                }
+            }
+            "textDocument/didClose" => {
+                let uri = params["textDocument"]["uri"].as_str().unwrap_or("");
+                self.documents.remove(uri);
+                vec![publish(uri, Vec::new())]
+            }
+            "textDocument/codeAction" => {
+                let uri = params["textDocument"]["uri"].as_str().unwrap_or("");
+                vec![response(
+                    id,
+                    Value::Array(self.code_actions(uri, &params["range"])),
+                )]
+            }
+            _ if id.is_some() => vec![error_response(id, -32601, "method not found")],
+            _ => Vec::new(),
+        }
+    }
+
+    fn update(&mut self, uri: &str, text: String) -> Vec<Value> {
+        let Some(language) = SupportedLanguage::from_path(&uri_to_path(uri)) else {
+            return Vec::new();
+        };
+
+        let path = uri_to_path(uri);
+        let previous = self
+            .documents
+            .get_mut(uri)
+            .and_then(|document| document.snapshot.take());
+        let diagnostics = match self.analyze(language, &path, &text, previous) {
+            Ok((results, snapshot)) => {
+                let diagnostics = results.iter().map(|r| diagnostic(r, &text)).collect();
+                self.documents.insert(
+                    uri.to_string(),
+                    Document {
+                        text,
+                        results,
+                        snapshot,
+                    },
+                );
+                diagnostics
+            }
+            Err(e) => {
+                self.documents.insert(
+                    uri.to_string(),
+                    Document {
+                        text,
+                        results: Vec::new(),
+                        snapshot: None,
+                    },
+                );
+                let line = e
+                    .downcast_ref::<SuppressionError>()
+                    .map_or(0, |err| err.line.saturating_sub(1));
+                vec![json!({
+                    "range": { "start": { "line": line, "character": 0 }, "end": { "line": line, "character": 0 } },
+                    "severity": 1,
+                    "source": "compass",
+                    "message": format!("compass: {}", e)
+                })]
+            }
+        };
+
+        vec![publish(uri, diagnostics)]
+    }
+
+    fn analyze(
+        &mut self,
+        language: SupportedLanguage,
+        path: &str,
+        text: &str,
+        previous: Option<Snapshot>,
+    ) -> Result<(Vec<AnalysisResult>, Option<Snapshot>), Box<dyn std::error::Error>> {
+        let project = EffectiveConfig::for_path(path)?;
+        if project.is_excluded(path) {
+            return Ok((Vec::new(), None));
+        }
+
+        let analyzer = &self.configs.get(language, &project)?.analyzer;
+        // Siblings come from disk; only the open document's own text may be
+        // unsaved.
+        let package = if analyzer.reads_package() {
+            Package::load(path).ok()
+        } else {
+            None
+        };
+        let (results, snapshot) = analyzer.analyze_incremental(
+            text,
+            &language.tree_sitter_language(),
+            package.as_ref(),
+            previous,
+        )?;
+        Ok((results, Some(snapshot)))
+    }
+
+    fn code_actions(&self, uri: &str, range: &Value) -> Vec<Value> {
+        let Some(document) = self.documents.get(uri) else {
+            return Vec::new();
+        };
+        let first_line = range["start"]["line"].as_u64().unwrap_or(0) as usize;
+        let last_line = range["end"]["line"].as_u64().unwrap_or(first_line as u64) as usize;
+
+        let mut actions = Vec::new();
+        for result in &document.results {
+            let (line, last) = (result.line - 1, result.end_line - 1);
+            if last < first_line || line > last_line {
+                continue;
+            }
+
+            if let Some(fix) = &result.fix {
+                let edits: Vec<Value> = fix
+                    .edits
+                    .iter()
+                    .map(|edit| {
+                        json!({
+                            "range": range_json(&document.text, edit.start_byte, edit.end_byte),
+                            "newText": edit.replacement
+                        })
+                    })
+                    .collect();
+                actions.push(json!({
+                    "title": format!("{} ({})", fix.description, result.rule_name),
+                    "kind": "quickfix",
+                    "diagnostics": [diagnostic(result, &document.text)],
+                    "edit": { "changes": { uri: edits } }
+                }));
+            }
+
+            let indent: String = document
+                .text
+                .lines()
+                .nth(line)
+                .unwrap_or("")
+                .chars()
+                .take_while(|c| c.is_whitespace())
+                .collect();
+            actions.push(json!({
+                "title": format!("Suppress '{}' on this line", result.rule_name),
+                "kind": "quickfix",
+                "edit": { "changes": { uri: [{
+                    "range": { "start": { "line": line, "character": 0 }, "end": { "line": line, "character": 0 } },
+                    "newText": format!("{}// compass:disable {} -- explain why this is safe\n", indent, result.rule_name)
+                }] } }
+            }));
+        }
+        actions
+    }
+}
+
+fn initialize_result() -> Value {
+    json!({
+        "capabilities": {
+            "textDocumentSync": {
+                "openClose": true,
+                "change": 1,
+                "save": { "includeText": true }
+            },
+            "codeActionProvider": { "codeActionKinds": ["quickfix"] }
+        },
+        "serverInfo": { "name": "compass", "version": env!("CARGO_PKG_VERSION") }
+    })
+}
+
+fn diagnostic(result: &AnalysisResult, text: &str) -> Value {
+    let severity = match result.severity {
+        Severity::Error => 1,
+        Severity::Warning => 2,
+        Severity::Info => 3,
+        Severity::Style => 4,
+    };
+    let message = match &result.suggestion {
+        Some(suggestion) => format!("{}\n{}", result.message, suggestion),
+        None => result.message.clone(),
+    };
+
+    json!({
+        "range": range_json(text, result.start_byte, result.end_byte),
+        "severity": severity,
+        "code": result.rule_name,
+        "source": "compass",
+        "message": message
+    })
+}
+
+fn range_json(text: &str, start_byte: usize, end_byte: usize) -> Value {
+    let (start_line, start_character) = position_at(text, start_byte);
+    let (end_line, end_character) = position_at(text, end_byte);
+    json!({
+        "start": { "line": start_line, "character": start_character },
+        "end": { "line": end_line, "character": end_character }
+    })
+}
+
+/// Converts a byte offset into an LSP position (zero-based line, UTF-16 column).
+fn position_at(text: &str, byte: usize) -> (usize, usize) {
+    let byte = byte.min(text.len());
+    let line_start = text[..byte].rfind('\n').map_or(0, |i| i + 1);
+    let line = text[..line_start].matches('\n').count();
+    let character = text[line_start..byte].chars().map(char::len_utf16).sum();
+    (line, character)
+}
+
+fn uri_to_path(uri: &str) -> String {
+    let path = uri.strip_prefix("file://").unwrap_or(uri);
+    path.replace("%20", " ")
+}
+
+fn publish(uri: &str, diagnostics: Vec<Value>) -> Value {
+    json!({
+        "jsonrpc": "2.0",
+        "method": "textDocument/publishDiagnostics",
+        "params": { "uri": uri, "diagnostics": diagnostics }
+    })
+}
+
+fn response(id: Option<Value>, result: Value) -> Value {
+    json!({ "jsonrpc": "2.0", "id": id, "result": result })
+}
+
+fn error_response(id: Option<Value>, code: i64, message: &str) -> Value {
+    json!({ "jsonrpc": "2.0", "id": id, "error": { "code": code, "message": message } })
+}
+
+fn read_message<R: BufRead>(input: &mut R) -> io::Result<Option<Value>> {
+    let mut content_length = None;
+    loop {
+        let mut header = String::new();
+        if input.read_line(&mut header)? == 0 {
+            return Ok(None);
+        }
+        let header = header.trim_end();
+        if header.is_empty() {
+            break;
+        }
+        if let Some(length) = header.strip_prefix("Content-Length:") {
+            content_length = length.trim().parse::<usize>().ok();
+        }
+    }
+
+    let length = content_length
+        .ok_or_else(|| io::Error::new(io::ErrorKind::InvalidData, "missing Content-Length"))?;
+    let mut body = vec![0; length];
+    input.read_exact(&mut body)?;
+    serde_json::from_slice(&body)
+        .map(Some)
+        .map_err(|e| io::Error::new(io::ErrorKind::InvalidData, e))
+}
+
+fn write_message<W: Write>(output: &mut W, message: &Value) -> io::Result<()> {
+    let body = message.to_string();
+    write!(output, "Content-Length: {}\r\n\r\n{}", body.len(), body)?;
+    output.flush()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn frame(message: Value) -> String {
+        let body = message.to_string();
+        format!("Content-Length: {}\r\n\r\n{}", body.len(), body)
+    }
+
+    #[test]
+    fn test_position_at_counts_utf16() {
+        let text = "ab\nx😀y\n";
+        assert_eq!(position_at(text, 0), (0, 0));
+        assert_eq!(position_at(text, 3), (1, 0));
+        // The emoji is four bytes in UTF-8 and two code units in UTF-16.
+        assert_eq!(position_at(text, 8), (1, 3));
+    }
+
+    #[test]
+    fn test_initialize_and_shutdown() {
+        let input = frame(json!({"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {}}))
+            + &frame(json!({"jsonrpc": "2.0", "id": 2, "method": "shutdown"}))
+            + &frame(json!({"jsonrpc": "2.0", "method": "exit"}));
+        let mut output = Vec::new();
+
+        let mut server = Server::new(None, Registry::new());
+        assert_eq!(server.serve(input.as_bytes(), &mut output).unwrap(), 0);
+
+        let output = String::from_utf8(output).unwrap();
+        assert!(output.contains("\"codeActionProvider\""));
+        assert!(output.contains("\"id\":2"));
+
+        // Exiting without a shutdown first is an error.
+        let input = frame(json!({"jsonrpc": "2.0", "method": "exit"}));
+        let mut server = Server::new(None, Registry::new());
+        assert_eq!(server.serve(input.as_bytes(), &mut Vec::new()).unwrap(), 1);
+    }
+
+    #[test]
+    fn test_unsupported_document_is_ignored() {
+        let mut server = Server::new(None, Registry::new());
+        let outgoing = server.update("file:///tmp/notes.txt", "hello".to_string());
+        assert!(outgoing.is_empty());
+    }
+}
@@ -0,0 +1,234 @@
+//! Rewording findings: message templates, remediation links and catalogs.
+//!
+//! A rule's `template` replaces the text of each of its findings, and its
+//! `url` links to guidance such as an internal wiki page. Both can be set
+//! where the rule is defined, per rule in `.compass.toml`, or in a message
+//! catalog: a TOML file of templates, suggestions and links that a
+//! `.compass.toml` names with `catalog`, so an organization can ship its own
+//! wording, or a translation, as one file:
+//!
+//! ```toml
+//! url = "https://wiki.example.com/compass/{rule}"
+//!
+//! [rules.panic_usage]
+//! template = "{symbol}: panic beendet den Prozess. {message}"
+//! suggestion = "Geben Sie einen Fehler zurück; siehe {url}."
+//! ```
+//!
+//! A catalog path containing `{locale}` is resolved for the user's locale,
+//! from `COMPASS_LOCALE` or else the usual `LC_ALL`, `LC_MESSAGES` and
+//! `LANG`; when there is no catalog for it, the rules keep their own text.
+
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::env;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// What `template`, `suggestion` and `url` can refer to. `url` itself only
+/// knows `{rule}`.
+pub const PLACEHOLDERS: &[&str] = &["message", "rule", "symbol", "text", "url"];
+
+/// The text of one rule in a catalog.
+#[derive(Debug, Clone, Default, Deserialize, Serialize)]
+#[serde(deny_unknown_fields)]
+pub struct Messages {
+    pub template: Option<String>,
+    pub suggestion: Option<String>,
+    pub url: Option<String>,
+}
+
+#[derive(Debug, Clone, Default, Deserialize, Serialize)]
+#[serde(deny_unknown_fields)]
+pub struct Catalog {
+    /// The link of every rule that doesn't set its own.
+    pub url: Option<String>,
+    #[serde(default)]
+    pub rules: BTreeMap<String, Messages>,
+}
+
+impl Catalog {
+    pub fn from_file(path: &Path) -> Result<Self, String> {
+        let content = fs::read_to_string(path)
+            .map_err(|e| format!("failed to read catalog '{}': {}", path.display(), e))?;
+        let catalog: Catalog = toml::from_str(&content)
+            .map_err(|e| format!("failed to parse catalog '{}': {}", path.display(), e))?;
+        if let Some(problem) = catalog.problems().into_iter().next() {
+            return Err(format!("catalog '{}': {}", path.display(), problem));
+        }
+        Ok(catalog)
+    }
+
+    pub fn problems(&self) -> Vec<String> {
+        let mut problems: Vec<String> = self
+            .url
+            .iter()
+            .flat_map(|url| unknown_placeholders("url", url, &["rule"]))
+            .collect();
+        for (name, messages) in &self.rules {
+            problems.extend(
+                template_problems(
+                    messages.template.as_deref(),
+                    messages.suggestion.as_deref(),
+                    messages.url.as_deref(),
+                )
+                .into_iter()
+                .map(|problem| format!("rule '{}': {}", name, problem)),
+            );
+        }
+        problems
+    }
+}
+
+/// Where the catalog `pattern` names is for `locale`, most specific first:
+/// `de_DE` tries `de_DE` and then `de`. A pattern without `{locale}` is the
+/// one path; one with it and no locale has none.
+pub fn catalog_candidates(dir: &Path, pattern: &str, locale: Option<&str>) -> Vec<PathBuf> {
+    if !pattern.contains("{locale}") {
+        return vec![dir.join(pattern)];
+    }
+    let Some(locale) = locale else {
+        return Vec::new();
+    };
+    let mut locales = vec![locale];
+    if let Some((language, _)) = locale.split_once('_') {
+        locales.push(language);
+    }
+    locales
+        .into_iter()
+        .map(|locale| dir.join(pattern.replace("{locale}", locale)))
+        .collect()
+}
+
+/// The user's locale without its encoding, such as `de_DE`, or `None` for
+/// the `C` and `POSIX` locales.
+pub fn locale() -> Option<String> {
+    ["COMPASS_LOCALE", "LC_ALL", "LC_MESSAGES", "LANG"]
+        .iter()
+        .filter_map(|name| env::var(name).ok())
+        .find(|value| !value.is_empty())
+        .map(|value| {
+            let value = value.split(['.', '@']).next().unwrap_or_default();
+            value.to_string()
+        })
+        .filter(|value| !value.is_empty() && value != "C" && value != "POSIX")
+}
+
+/// Replaces each `{name}` in `template` with its value. Unknown names are
+/// kept as written, and values are not expanded again.
+pub fn render(template: &str, values: &[(&str, &str)]) -> String {
+    let mut rendered = String::with_capacity(template.len());
+    let mut rest = template;
+    while let Some(start) = rest.find('{') {
+        rendered.push_str(&rest[..start]);
+        let after = &rest[start + 1..];
+        let value = after.find('}').and_then(|end| {
+            let name = &after[..end];
+            values
+                .iter()
+                .find(|(known, _)| *known == name)
+                .map(|(_, value)| (*value, end))
+        });
+        match value {
+            Some((value, end)) => {
+                rendered.push_str(value);
+                rest = &after[end + 1..];
+            }
+            None => {
+                rendered.push('{');
+                rest = after;
+            }
+        }
+    }
+    rendered.push_str(rest);
+    rendered
+}
+
+/// The placeholders in a rule's template, suggestion and link that nothing
+/// fills in, each as the error loading the config would report.
+pub fn template_problems(
+    template: Option<&str>,
+    suggestion: Option<&str>,
+    url: Option<&str>,
+) -> Vec<String> {
+    let mut problems = Vec::new();
+    if let Some(template) = template {
+        problems.extend(unknown_placeholders("template", template, PLACEHOLDERS));
+    }
+    if let Some(suggestion) = suggestion {
+        problems.extend(unknown_placeholders("suggestion", suggestion, PLACEHOLDERS));
+    }
+    if let Some(url) = url {
+        problems.extend(unknown_placeholders("url", url, &["rule"]));
+    }
+    problems
+}
+
+/// `{name}`s that look like placeholders, made of letters and `_`, but
+/// aren't one of `known`; other braces are left to be literal text.
+fn unknown_placeholders(field: &str, text: &str, known: &[&str]) -> Vec<String> {
+    let mut problems = Vec::new();
+    let mut rest = text;
+    while let Some(start) = rest.find('{') {
+        rest = &rest[start + 1..];
+        let Some(end) = rest.find('}') else {
+            break;
+        };
+        let name = &rest[..end];
+        let is_placeholder =
+            !name.is_empty() && name.chars().all(|c| c.is_ascii_lowercase() || c == '_');
+        if is_placeholder && !known.contains(&name) {
+            problems.push(format!(
+                "`{}` uses unknown placeholder '{{{}}}' (expected one of: {})",
+                field,
+                name,
+                known
+                    .iter()
+                    .map(|name| format!("{{{}}}", name))
+                    .collect::<Vec<_>>()
+                    .join(", ")
+            ));
+        }
+    }
+    problems
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_render_fills_known_placeholders_once() {
+        let values = [("rule", "panic_usage"), ("message", "uses {rule}")];
+        assert_eq!(
+            render("[{rule}] {message} {unknown} {", &values),
+            "[panic_usage] uses {rule} {unknown} {"
+        );
+        assert_eq!(
+            template_problems(Some("{message} in {symbl}"), None, Some("https://x/{rule}/{text}")),
+            [
+                "`template` uses unknown placeholder '{symbl}' (expected one of: {message}, {rule}, {symbol}, {text}, {url})",
+                "`url` uses unknown placeholder '{text}' (expected one of: {rule})",
+            ]
+        );
+        // JSON-looking braces are text
+        assert!(template_problems(Some("got {\"a\": 1} {}"), None, None).is_empty());
+    }
+
+    #[test]
+    fn test_catalogs_are_found_for_the_locale() {
+        let dir = Path::new("/repo");
+        assert_eq!(
+            catalog_candidates(dir, "i18n/compass.{locale}.toml", Some("de_DE")),
+            [
+                PathBuf::from("/repo/i18n/compass.de_DE.toml"),
+                PathBuf::from("/repo/i18n/compass.de.toml"),
+            ]
+        );
+        assert!(catalog_candidates(dir, "i18n/compass.{locale}.toml", None).is_empty());
+        assert_eq!(
+            catalog_candidates(dir, "guidance.toml", Some("de_DE")),
+            [PathBuf::from("/repo/guidance.toml")]
+        );
+    }
+}
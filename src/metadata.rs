@@ -0,0 +1,222 @@
+//! A record of how a run was configured, written with `--emit-metadata
+//! FILE` next to the findings, so an audit can show which policy checked a
+//! commit without rerunning compass.
+//!
+//! The record holds the compass version and command line, the commit, the
+//! config files and the rules that ran with their severities and options,
+//! the build tags and platforms, the packages analyzed, how long each
+//! stage took, and how many files came from the cache. A rule that
+//! `.compass.toml` files set differently in different directories is
+//! listed once per setting, with the number of files it ran on.
+
+use crate::analyzer::AnalysisRule;
+use serde::Serialize;
+use serde_json::Value;
+use std::collections::{BTreeMap, BTreeSet};
+use std::fs;
+use std::io;
+use std::path::Path;
+use std::time::{Instant, SystemTime, UNIX_EPOCH};
+
+pub const METADATA_VERSION: u32 = 1;
+
+#[derive(Debug, Clone, Serialize)]
+pub struct Metadata {
+    pub version: u32,
+    pub compass_version: String,
+    /// Seconds since the Unix epoch, when the run started.
+    pub timestamp: u64,
+    /// The `HEAD` commit, when the tree is a git checkout.
+    pub commit: Option<String>,
+    /// The command line, without the program.
+    pub arguments: Vec<String>,
+    pub settings: Settings,
+    /// The rule config files and `.compass.toml` files used.
+    pub configs: BTreeSet<String>,
+    pub rules: Vec<RuleSetting>,
+    /// Directories analyzed and how many files of each.
+    pub packages: BTreeMap<String, usize>,
+    pub files: usize,
+    pub stages: Vec<Stage>,
+    pub cache: CacheStats,
+    #[serde(skip)]
+    mark: Instant,
+}
+
+/// The command line options that change what is reported.
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct Settings {
+    pub preset: Option<String>,
+    /// `syntax` when files were analyzed without their packages.
+    pub mode: String,
+    pub min_confidence: Option<String>,
+    pub build_tags: Vec<String>,
+    pub platforms: Vec<String>,
+    pub baseline: Option<String>,
+    pub vuln: bool,
+    /// `--enable-experimental`.
+    pub experimental: bool,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct RuleSetting {
+    pub name: String,
+    pub severity: String,
+    pub confidence: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub check: Option<String>,
+    #[serde(skip_serializing_if = "BTreeMap::is_empty")]
+    pub options: BTreeMap<String, Value>,
+    /// How many files the rule ran on with this setting.
+    pub files: usize,
+}
+
+impl RuleSetting {
+    /// Whether `other` sets the same rule in the same way.
+    fn same(&self, other: &RuleSetting) -> bool {
+        self.name == other.name
+            && self.severity == other.severity
+            && self.confidence == other.confidence
+            && self.check == other.check
+            && self.options == other.options
+    }
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct Stage {
+    pub name: String,
+    pub milliseconds: u128,
+}
+
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct CacheStats {
+    pub enabled: bool,
+    pub hits: usize,
+    pub misses: usize,
+}
+
+impl Metadata {
+    /// Starts the record of a run that began at `started`.
+    pub fn new(arguments: Vec<String>, settings: Settings, cache: bool, started: Instant) -> Self {
+        Metadata {
+            version: METADATA_VERSION,
+            compass_version: env!("CARGO_PKG_VERSION").to_string(),
+            timestamp: SystemTime::now()
+                .duration_since(UNIX_EPOCH)
+                .map_or(0, |elapsed| elapsed.as_secs()),
+            commit: None,
+            arguments,
+            settings,
+            configs: BTreeSet::new(),
+            rules: Vec::new(),
+            packages: BTreeMap::new(),
+            files: 0,
+            stages: Vec::new(),
+            cache: CacheStats {
+                enabled: cache,
+                ..CacheStats::default()
+            },
+            mark: started,
+        }
+    }
+
+    /// Ends a stage named `name`, which began when the last one ended.
+    pub fn stage(&mut self, name: &str) {
+        let now = Instant::now();
+        self.stages.push(Stage {
+            name: name.to_string(),
+            milliseconds: now.duration_since(self.mark).as_millis(),
+        });
+        self.mark = now;
+    }
+
+    /// Records a file analyzed with `rules` from the config `config`.
+    pub fn file(&mut self, path: &str, config: &str, rules: &[AnalysisRule], cached: bool) {
+        self.files += 1;
+        let package = match Path::new(path).parent() {
+            Some(dir) if !dir.as_os_str().is_empty() => dir.display().to_string(),
+            _ => ".".to_string(),
+        };
+        *self.packages.entry(package).or_insert(0) += 1;
+        self.configs.insert(config.to_string());
+        if self.cache.enabled {
+            if cached {
+                self.cache.hits += 1;
+            } else {
+                self.cache.misses += 1;
+            }
+        }
+        for rule in rules {
+            let setting = RuleSetting {
+                name: rule.name.clone(),
+                severity: rule.severity.as_str().to_string(),
+                confidence: rule.confidence.as_str().to_string(),
+                check: rule.check.clone(),
+                options: rule
+                    .options
+                    .keys()
+                    .filter_map(|key| {
+                        let value = serde_json::to_value(rule.options.get(key)?).ok()?;
+                        Some((key.to_string(), value))
+                    })
+                    .collect(),
+                files: 0,
+            };
+            match self.rules.iter_mut().find(|known| known.same(&setting)) {
+                Some(known) => known.files += 1,
+                None => self.rules.push(RuleSetting {
+                    files: 1,
+                    ..setting
+                }),
+            }
+        }
+    }
+
+    pub fn write(&mut self, path: &str) -> io::Result<()> {
+        self.rules.sort_by(|a, b| a.name.cmp(&b.name));
+        let json = serde_json::to_string_pretty(self).map_err(io::Error::other)?;
+        fs::write(path, json + "\n")
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analyzer::Severity;
+
+    #[test]
+    fn test_rules_are_listed_once_per_setting() {
+        let rule = |severity| {
+            AnalysisRule::new(
+                "panic_usage".to_string(),
+                String::new(),
+                severity,
+                "Use of panic()".to_string(),
+                None,
+            )
+        };
+        let mut metadata = Metadata::new(Vec::new(), Settings::default(), true, Instant::now());
+        metadata.file("cmd/main.go", "go.toml", &[rule(Severity::Warning)], false);
+        metadata.file("cmd/tool.go", "go.toml", &[rule(Severity::Warning)], true);
+        metadata.file(
+            "main.go",
+            "go.toml + .compass.toml",
+            &[rule(Severity::Error)],
+            false,
+        );
+        metadata.stage("analyze");
+
+        let json = serde_json::to_value(&metadata).unwrap();
+        assert_eq!(json["files"], 3);
+        assert_eq!(json["packages"]["cmd"], 2);
+        assert_eq!(json["packages"]["."], 1);
+        assert_eq!(json["cache"]["hits"], 1);
+        assert_eq!(json["cache"]["misses"], 2);
+        assert_eq!(json["configs"].as_array().unwrap().len(), 2);
+        let rules = json["rules"].as_array().unwrap();
+        assert_eq!(rules.len(), 2);
+        assert_eq!(rules[0]["severity"], "warning");
+        assert_eq!(rules[0]["files"], 2);
+        assert_eq!(json["stages"][0]["name"], "analyze");
+    }
+}
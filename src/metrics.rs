@@ -0,0 +1,280 @@
+//! `--pushgateway` and `--statsd`: a run's numbers for dashboards.
+//!
+//! After the run, the finding counts by rule, severity and package (the
+//! file's directory), the files analyzed, the cache hits and misses and
+//! the run's duration are pushed, so a dashboard can follow them without
+//! parsing reports. Counts are of every finding, before `--max-issues-per-rule`
+//! and the other limits.
+//!
+//! The Pushgateway gets them in Prometheus's text format, with a `PUT` to
+//! `/metrics/job/compass` that replaces the previous run's, so counts that
+//! dropped to zero don't linger, through curl like `--otlp-endpoint`.
+//! statsd gets gauges over UDP, with the labels as DogStatsD tags, which
+//! Datadog, Telegraf and statsd_exporter read.
+
+use crate::analyzer::AnalysisResult;
+use crate::history::curl;
+use crate::profile::package_of;
+use std::collections::BTreeMap;
+use std::fmt::Write;
+use std::net::UdpSocket;
+use std::time::Duration;
+
+/// The largest statsd packet, to fit an Ethernet frame.
+const PACKET_SIZE: usize = 1432;
+
+#[derive(Debug, Default)]
+pub struct Metrics {
+    /// Findings by rule, severity and package.
+    findings: BTreeMap<(String, &'static str, String), usize>,
+    files: usize,
+    /// Cache hits and misses, when the cache is on.
+    cache: Option<(usize, usize)>,
+}
+
+impl Metrics {
+    pub fn new(cache: bool) -> Self {
+        Metrics {
+            cache: cache.then_some((0, 0)),
+            ..Metrics::default()
+        }
+    }
+
+    pub fn file(&mut self, path: &str, results: &[AnalysisResult], cached: bool) {
+        self.files += 1;
+        if let Some((hits, misses)) = &mut self.cache {
+            if cached {
+                *hits += 1;
+            } else {
+                *misses += 1;
+            }
+        }
+        let package = package_of(path);
+        for result in results {
+            let key = (
+                result.rule_name.clone(),
+                result.severity.as_str(),
+                package.clone(),
+            );
+            *self.findings.entry(key).or_default() += 1;
+        }
+    }
+
+    /// The metrics in Prometheus's text exposition format.
+    pub fn to_prometheus(&self, duration: Duration) -> String {
+        let mut out = String::new();
+        out.push_str("# HELP compass_findings Findings of the last run.\n");
+        out.push_str("# TYPE compass_findings gauge\n");
+        for ((rule, severity, package), count) in &self.findings {
+            let _ = writeln!(
+                out,
+                "compass_findings{{rule=\"{}\",severity=\"{}\",package=\"{}\"}} {}",
+                label(rule),
+                severity,
+                label(package),
+                count
+            );
+        }
+        for (name, help, value) in self.totals(duration) {
+            let _ = writeln!(out, "# HELP compass_{} {}", name, help);
+            let _ = writeln!(out, "# TYPE compass_{} gauge", name);
+            let _ = writeln!(out, "compass_{} {}", name, value);
+        }
+        out
+    }
+
+    /// The metrics as statsd gauges, in packets of at most
+    /// [`PACKET_SIZE`] bytes.
+    pub fn to_statsd(&self, duration: Duration) -> Vec<String> {
+        let mut lines: Vec<String> = self
+            .findings
+            .iter()
+            .map(|((rule, severity, package), count)| {
+                format!(
+                    "compass.findings:{}|g|#rule:{},severity:{},package:{}",
+                    count,
+                    tag(rule),
+                    severity,
+                    tag(package)
+                )
+            })
+            .collect();
+        lines.extend(
+            self.totals(duration)
+                .into_iter()
+                .map(|(name, _, value)| format!("compass.{}:{}|g", name, value)),
+        );
+
+        let mut packets: Vec<String> = Vec::new();
+        for line in lines {
+            match packets.last_mut() {
+                Some(packet) if packet.len() + 1 + line.len() <= PACKET_SIZE => {
+                    packet.push('\n');
+                    packet.push_str(&line);
+                }
+                _ => packets.push(line),
+            }
+        }
+        packets
+    }
+
+    /// The run's totals: name, description and value.
+    fn totals(&self, duration: Duration) -> Vec<(&'static str, &'static str, String)> {
+        let mut totals = vec![
+            (
+                "files",
+                "Files the last run analyzed.",
+                self.files.to_string(),
+            ),
+            (
+                "duration_seconds",
+                "How long the last run took.",
+                format!("{:.3}", duration.as_secs_f64()),
+            ),
+        ];
+        if let Some((hits, misses)) = self.cache {
+            totals.push((
+                "cache_hits",
+                "Files the last run read from the cache.",
+                hits.to_string(),
+            ));
+            totals.push((
+                "cache_misses",
+                "Files the last run analyzed and cached.",
+                misses.to_string(),
+            ));
+        }
+        totals
+    }
+}
+
+/// Pushes `body` to the Pushgateway at `url`, replacing the `compass`
+/// job's metrics, or those of the group `url` names if it already has a
+/// `/metrics/job/` path.
+pub fn push_gateway(url: &str, body: &str) -> Result<(), String> {
+    let url = match url.contains("/metrics/job/") {
+        true => url.to_string(),
+        false => format!("{}/metrics/job/compass", url.trim_end_matches('/')),
+    };
+    // A gateway that doesn't answer shouldn't hold up CI.
+    curl(
+        &[
+            "-fsSL",
+            "--max-time",
+            "10",
+            "-X",
+            "PUT",
+            "-H",
+            "Content-Type: text/plain; version=0.0.4",
+            "--data-binary",
+            "@-",
+            url.as_str(),
+        ],
+        Some(body),
+    )
+    .map(|_| ())
+}
+
+/// Sends `packets` to the statsd server at `address`, a `host:port`.
+pub fn send_statsd(address: &str, packets: &[String]) -> Result<(), String> {
+    let socket = UdpSocket::bind("0.0.0.0:0")
+        .and_then(|socket| socket.connect(address).map(|()| socket))
+        .map_err(|e| e.to_string())?;
+    for packet in packets {
+        socket.send(packet.as_bytes()).map_err(|e| e.to_string())?;
+    }
+    Ok(())
+}
+
+/// A Prometheus label value, escaped.
+fn label(value: &str) -> String {
+    value
+        .replace('\\', "\\\\")
+        .replace('"', "\\\"")
+        .replace('\n', "\\n")
+}
+
+/// A DogStatsD tag value, without the characters that separate tags.
+fn tag(value: &str) -> String {
+    value.replace([',', '|', '#', '\n'], "_")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analyzer::Severity;
+
+    fn finding(rule: &str, severity: Severity) -> AnalysisResult {
+        AnalysisResult {
+            rule_name: rule.to_string(),
+            severity,
+            ..AnalysisResult::default()
+        }
+    }
+
+    fn metrics() -> Metrics {
+        let mut metrics = Metrics::new(true);
+        metrics.file(
+            "api/server.go",
+            &[
+                finding("missing_error_check", Severity::Error),
+                finding("missing_error_check", Severity::Error),
+                finding("magic_number", Severity::Style),
+            ],
+            false,
+        );
+        metrics.file(
+            "api/client.go",
+            &[finding("missing_error_check", Severity::Error)],
+            true,
+        );
+        metrics.file("main.go", &[], false);
+        metrics
+    }
+
+    #[test]
+    fn test_prometheus_counts_by_rule_severity_and_package() {
+        let text = metrics().to_prometheus(Duration::from_millis(1500));
+        assert!(text.contains(
+            "compass_findings{rule=\"missing_error_check\",severity=\"error\",package=\"api\"} 3\n"
+        ));
+        assert!(text.contains(
+            "compass_findings{rule=\"magic_number\",severity=\"style\",package=\"api\"} 1\n"
+        ));
+        assert!(text.contains("compass_files 3\n"));
+        assert!(text.contains("compass_duration_seconds 1.500\n"));
+        assert!(text.contains("compass_cache_hits 1\n"));
+        assert!(text.contains("compass_cache_misses 2\n"));
+        assert!(!Metrics::new(false)
+            .to_prometheus(Duration::ZERO)
+            .contains("cache"));
+    }
+
+    #[test]
+    fn test_statsd_packets_stay_small() {
+        let packets = metrics().to_statsd(Duration::from_millis(20));
+        assert_eq!(packets.len(), 1);
+        assert!(packets[0]
+            .starts_with("compass.findings:1|g|#rule:magic_number,severity:style,package:api\n"));
+        assert!(packets[0].ends_with("compass.cache_misses:2|g"));
+
+        let mut metrics = Metrics::new(false);
+        for i in 0..100 {
+            metrics.file(
+                &format!("pkg{}/a.go", i),
+                &[finding("magic_number", Severity::Style)],
+                false,
+            );
+        }
+        let packets = metrics.to_statsd(Duration::ZERO);
+        assert!(packets.len() > 1);
+        assert!(packets.iter().all(|packet| packet.len() <= PACKET_SIZE));
+        assert_eq!(
+            packets
+                .iter()
+                .map(|packet| packet.lines().count())
+                .sum::<usize>(),
+            102
+        );
+    }
+}
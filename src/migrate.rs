@@ -0,0 +1,445 @@
+//! Project configs generated from other linters' configs.
+//!
+//! `compass migrate --from golangci-lint` reads a `.golangci.yml` (or its
+//! TOML and JSON forms) and writes the `.compass.toml` closest to it: each Go
+//! rule that overlaps a golangci-lint linter is enabled or disabled along
+//! with it, complexity thresholds carry over to the complexity rules, and
+//! path exclusions become `exclude` globs, and analyzing generated files
+//! becomes `default_excludes = false`. Linters and settings without a
+//! compass counterpart are listed so nothing is dropped silently.
+
+mod yaml;
+
+use crate::project::{ProjectConfig, RuleOverride};
+use serde_json::Value;
+use std::collections::BTreeSet;
+use std::fs;
+use std::path::Path;
+
+/// Config files golangci-lint looks for, in its order of preference.
+pub const GOLANGCI_CONFIG_FILES: &[&str] = &[
+    ".golangci.yml",
+    ".golangci.yaml",
+    ".golangci.toml",
+    ".golangci.json",
+];
+
+/// golangci-lint linters and the rules that cover the same ground. `govet`
+/// only overlaps through its `copylocks` analyzer.
+const LINTERS: &[(&str, &[&str])] = &[
+    ("errcheck", &["missing_error_check", "discarded_error"]),
+    ("govet", &["mutex_misuse"]),
+    ("bodyclose", &["resource_leak"]),
+    ("sqlclosecheck", &["resource_leak"]),
+    ("noctx", &["context_propagation"]),
+    ("contextcheck", &["context_propagation"]),
+    (
+        "gosec",
+        &[
+            "sql_injection",
+            "command_injection",
+            "path_traversal",
+            "template_injection",
+        ],
+    ),
+    ("gocyclo", &["cyclomatic_complexity"]),
+    ("cyclop", &["cyclomatic_complexity"]),
+    ("gocognit", &["cognitive_complexity"]),
+];
+
+/// The gosec checks behind each taint rule.
+const GOSEC_CHECKS: &[(&str, &str)] = &[
+    ("G201", "sql_injection"),
+    ("G202", "sql_injection"),
+    ("G203", "template_injection"),
+    ("G204", "command_injection"),
+    ("G304", "path_traversal"),
+];
+
+/// Settings that carry over, as (linter, setting, rule, option).
+const SETTINGS: &[(&str, &str, &str, &str)] = &[
+    ("gocyclo", "min-complexity", "cyclomatic_complexity", "max"),
+    ("cyclop", "max-complexity", "cyclomatic_complexity", "max"),
+    ("gocognit", "min-complexity", "cognitive_complexity", "max"),
+];
+
+/// Linters golangci-lint runs unless told otherwise. Version 1 also ran
+/// `gosimple`, which version 2 folded into `staticcheck`.
+const STANDARD_LINTERS: &[&str] = &["errcheck", "govet", "ineffassign", "staticcheck", "unused"];
+
+pub struct Migration {
+    pub config: ProjectConfig,
+    /// What happened to each rule, in the order of [`LINTERS`].
+    pub rules: Vec<RuleChange>,
+    /// Linters and settings compass has no equivalent for.
+    pub unmapped: Vec<String>,
+}
+
+pub struct RuleChange {
+    pub rule: &'static str,
+    pub enabled: bool,
+    /// The enabled linters the rule stands in for, or, for a disabled rule,
+    /// every linter it could have.
+    pub linters: Vec<&'static str>,
+}
+
+impl Migration {
+    /// The generated `.compass.toml`, noting where it came from and what it
+    /// couldn't carry over.
+    pub fn to_toml(&self, source: &str) -> Result<String, toml::ser::Error> {
+        let mut out = format!(
+            "# Generated from {} by `compass migrate --from golangci-lint`.\n",
+            source
+        );
+        if !self.unmapped.is_empty() {
+            out.push_str("# No compass equivalent:\n");
+            for item in &self.unmapped {
+                out.push_str(&format!("#   {}\n", item));
+            }
+        }
+        out.push('\n');
+        out.push_str(&toml::to_string(&self.config)?);
+        Ok(out)
+    }
+}
+
+/// Reads a golangci-lint config file and maps it onto compass rules.
+pub fn from_golangci_lint<P: AsRef<Path>>(path: P) -> Result<Migration, String> {
+    let path = path.as_ref();
+    let content = fs::read_to_string(path)
+        .map_err(|e| format!("failed to read '{}': {}", path.display(), e))?;
+    let extension = path.extension().and_then(|e| e.to_str()).unwrap_or("");
+    let config = match extension {
+        "json" => serde_json::from_str(&content).map_err(|e| e.to_string()),
+        "toml" => toml::from_str::<toml::Value>(&content)
+            .map_err(|e| e.to_string())
+            .and_then(|value| serde_json::to_value(value).map_err(|e| e.to_string())),
+        _ => yaml::parse(&content),
+    }
+    .map_err(|e| format!("failed to parse '{}': {}", path.display(), e))?;
+    Ok(migrate(&config))
+}
+
+fn migrate(golangci: &Value) -> Migration {
+    let version_2 = match golangci.get("version") {
+        Some(Value::String(version)) => version == "2",
+        Some(Value::Number(version)) => version.as_u64() == Some(2),
+        _ => false,
+    };
+    let linters = golangci.get("linters");
+    let settings = if version_2 {
+        linters.and_then(|linters| linters.get("settings"))
+    } else {
+        golangci.get("linters-settings")
+    };
+    let mut unmapped = Vec::new();
+
+    let default = if version_2 {
+        string(linters.and_then(|linters| linters.get("default"))).unwrap_or("standard")
+    } else if flag(linters, "disable-all") {
+        "none"
+    } else if flag(linters, "enable-all") {
+        "all"
+    } else {
+        "standard"
+    };
+    let mut enabled: BTreeSet<String> = BTreeSet::new();
+    match default {
+        "none" | "all" => {}
+        "standard" => enabled.extend(STANDARD_LINTERS.iter().map(|s| s.to_string())),
+        other => {
+            unmapped.push(format!("linters.default: {}", other));
+            enabled.extend(STANDARD_LINTERS.iter().map(|s| s.to_string()));
+        }
+    }
+    if !version_2 && default == "standard" {
+        enabled.insert("gosimple".to_string());
+    }
+    enabled.extend(strings(linters.and_then(|linters| linters.get("enable"))));
+    let disabled: BTreeSet<String> = strings(linters.and_then(|linters| linters.get("disable")))
+        .into_iter()
+        .collect();
+    let is_enabled =
+        |linter: &str| !disabled.contains(linter) && (default == "all" || enabled.contains(linter));
+
+    if default == "all" {
+        unmapped.push("every linter without a counterpart below (enable-all)".to_string());
+    }
+    for linter in &enabled {
+        let mapped = LINTERS.iter().any(|(name, _)| *name == linter.as_str());
+        if !mapped && !disabled.contains(linter) {
+            unmapped.push(linter.clone());
+        }
+    }
+
+    let gosec = settings.and_then(|settings| settings.get("gosec"));
+    let gosec_includes = strings(gosec.and_then(|gosec| gosec.get("includes")));
+    let gosec_excludes = strings(gosec.and_then(|gosec| gosec.get("excludes")));
+    let gosec_runs = |rule: &str| {
+        let checks: Vec<&str> = GOSEC_CHECKS
+            .iter()
+            .filter(|(_, mapped)| *mapped == rule)
+            .map(|(check, _)| *check)
+            .collect();
+        checks.iter().any(|check| {
+            (gosec_includes.is_empty() || gosec_includes.iter().any(|c| c == *check))
+                && !gosec_excludes.iter().any(|c| c == *check)
+        })
+    };
+
+    let mut config = ProjectConfig::default();
+    let mut rules: Vec<RuleChange> = Vec::new();
+    for (linter, mapped) in LINTERS {
+        for rule in *mapped {
+            let active = is_enabled(linter) && (*linter != "gosec" || gosec_runs(rule));
+            match rules.iter_mut().find(|change| change.rule == *rule) {
+                Some(change) if change.enabled => {
+                    if active {
+                        change.linters.push(*linter);
+                    }
+                }
+                Some(change) => {
+                    if active {
+                        change.enabled = true;
+                        change.linters = vec![*linter];
+                    } else {
+                        change.linters.push(*linter);
+                    }
+                }
+                None => rules.push(RuleChange {
+                    rule: *rule,
+                    enabled: active,
+                    linters: vec![*linter],
+                }),
+            }
+        }
+    }
+    for change in &rules {
+        config.rules.insert(
+            change.rule.to_string(),
+            RuleOverride {
+                enabled: Some(change.enabled),
+                ..Default::default()
+            },
+        );
+    }
+
+    if let Some(Value::Object(settings)) = settings {
+        for (linter, values) in settings {
+            if !is_enabled(linter) || !LINTERS.iter().any(|(name, _)| *name == linter.as_str()) {
+                continue;
+            }
+            let Value::Object(values) = values else {
+                continue;
+            };
+            for (setting, value) in values {
+                if linter == "gosec" && matches!(setting.as_str(), "includes" | "excludes") {
+                    continue;
+                }
+                let target = SETTINGS
+                    .iter()
+                    .find(|(l, s, _, _)| *l == linter.as_str() && *s == setting.as_str());
+                match (target, value.as_i64()) {
+                    (Some((_, _, rule, option)), Some(threshold)) => {
+                        let rule = config.rules.entry(rule.to_string()).or_default();
+                        rule.options
+                            .insert(option.to_string(), toml::Value::Integer(threshold));
+                    }
+                    _ => unmapped.push(format!("{}.{}", linter, setting)),
+                }
+            }
+        }
+    }
+
+    let run = golangci.get("run");
+    let issues = golangci.get("issues");
+    let exclusions = linters.and_then(|linters| linters.get("exclusions"));
+    let mut excludes = Vec::new();
+    for (section, key, is_dir) in [
+        (run, "skip-dirs", true),
+        (run, "skip-files", false),
+        (issues, "exclude-dirs", true),
+        (issues, "exclude-files", false),
+        (exclusions, "paths", false),
+    ] {
+        for pattern in strings(section.and_then(|section| section.get(key))) {
+            match regex_to_glob(&pattern, is_dir) {
+                Some(glob) => excludes.push(glob),
+                None => unmapped.push(format!("{} pattern '{}'", key, pattern)),
+            }
+        }
+    }
+    for glob in excludes {
+        if !config.exclude.contains(&glob) {
+            config.exclude.push(glob);
+        }
+    }
+    // Both tools skip generated files by default.
+    let generated = string(issues.and_then(|issues| issues.get("exclude-generated")))
+        .or_else(|| string(exclusions.and_then(|exclusions| exclusions.get("generated"))));
+    if generated == Some("disable") {
+        config.default_excludes = Some(false);
+    }
+
+    for (section, key, hint) in [
+        (
+            issues,
+            "exclude-rules",
+            "use compass:disable comments or a baseline",
+        ),
+        (
+            exclusions,
+            "rules",
+            "use compass:disable comments or a baseline",
+        ),
+        (
+            issues,
+            "exclude",
+            "use compass:disable comments or a baseline",
+        ),
+        (
+            golangci.get("severity"),
+            "rules",
+            "set each rule's severity",
+        ),
+    ] {
+        if let Some(Value::Array(entries)) = section.and_then(|section| section.get(key)) {
+            if !entries.is_empty() {
+                unmapped.push(format!(
+                    "{} ({} not converted; {})",
+                    key,
+                    entries.len(),
+                    hint
+                ));
+            }
+        }
+    }
+    for formatter in strings(
+        golangci
+            .get("formatters")
+            .and_then(|formatters| formatters.get("enable")),
+    ) {
+        unmapped.push(format!("{} (formatter)", formatter));
+    }
+
+    Migration {
+        config,
+        rules,
+        unmapped,
+    }
+}
+
+fn string(value: Option<&Value>) -> Option<&str> {
+    value.and_then(Value::as_str)
+}
+
+fn strings(value: Option<&Value>) -> Vec<String> {
+    match value {
+        Some(Value::Array(items)) => items
+            .iter()
+            .filter_map(|item| item.as_str().map(str::to_string))
+            .collect(),
+        _ => Vec::new(),
+    }
+}
+
+fn flag(section: Option<&Value>, key: &str) -> bool {
+    section
+        .and_then(|section| section.get(key))
+        .and_then(Value::as_bool)
+        .unwrap_or(false)
+}
+
+/// Converts the simple regexes golangci-lint matches paths against, such as
+/// `(^|/)vendor($|/)` or `.*\.pb\.go$`, to globs. Anything that needs a
+/// real regex gives `None`.
+fn regex_to_glob(pattern: &str, is_dir: bool) -> Option<String> {
+    let mut pattern = pattern.to_string();
+    let mut anchored = false;
+    for prefix in ["(^|/)", "^"] {
+        if let Some(rest) = pattern.strip_prefix(prefix) {
+            anchored = true;
+            pattern = rest.to_string();
+            break;
+        }
+    }
+    let mut ends = false;
+    for suffix in ["($|/)", "(/|$)", "/$", "$", "/"] {
+        if let Some(rest) = pattern.strip_suffix(suffix) {
+            ends = true;
+            pattern = rest.to_string();
+            break;
+        }
+    }
+
+    let mut glob = String::new();
+    let mut chars = pattern.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '\\' => glob.push(chars.next()?),
+            '.' if chars.peek() == Some(&'*') => {
+                chars.next();
+                glob.push('*');
+            }
+            '(' | ')' | '|' | '+' | '?' | '[' | ']' | '{' | '}' | '^' | '$' | '*' => {
+                return None;
+            }
+            c => glob.push(c),
+        }
+    }
+    if glob.is_empty() {
+        return None;
+    }
+    // Regexes match anywhere in the path; directory names are taken whole.
+    if !anchored && !is_dir && !glob.starts_with('*') && !glob.contains('/') {
+        glob.insert(0, '*');
+    }
+    if !ends && !is_dir && !glob.ends_with('*') {
+        glob.push('*');
+    }
+    Some(glob)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_regex_to_glob() {
+        assert_eq!(regex_to_glob("(^|/)vendor($|/)", true).unwrap(), "vendor");
+        assert_eq!(regex_to_glob("third_party", true).unwrap(), "third_party");
+        assert_eq!(regex_to_glob(r".*\.pb\.go$", false).unwrap(), "*.pb.go");
+        assert_eq!(regex_to_glob(r"_gen\.go$", false).unwrap(), "*_gen.go");
+        assert_eq!(
+            regex_to_glob("^internal/mocks/", false).unwrap(),
+            "internal/mocks"
+        );
+        assert!(regex_to_glob(r"(foo|bar)\.go$", false).is_none());
+    }
+
+    #[test]
+    fn test_disable_all_only_keeps_enabled_linters() {
+        let migration = migrate(&json!({
+            "linters": {"disable-all": true, "enable": ["errcheck", "gosec", "misspell"]},
+            "linters-settings": {
+                "gosec": {"excludes": ["G204"]},
+                "errcheck": {"check-blank": true},
+            },
+        }));
+        let enabled = |rule: &str| migration.config.rules[rule].enabled.unwrap();
+        assert!(enabled("missing_error_check"));
+        assert!(enabled("sql_injection"));
+        assert!(!enabled("command_injection"));
+        assert!(!enabled("mutex_misuse"));
+        assert_eq!(migration.unmapped, ["misspell", "errcheck.check-blank"]);
+    }
+
+    #[test]
+    fn test_analyzing_generated_files_carries_over() {
+        let v1 = migrate(&json!({"issues": {"exclude-generated": "disable"}}));
+        assert_eq!(v1.config.default_excludes, Some(false));
+        let v2 = migrate(&json!({"linters": {"exclusions": {"generated": "lax"}}}));
+        assert_eq!(v2.config.default_excludes, None);
+    }
+}
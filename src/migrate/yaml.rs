@@ -0,0 +1,400 @@
+//! Just enough YAML to read linter configs.
+//!
+//! Supported: block mappings and sequences, one-line flow sequences and
+//! mappings (`[a, b]`, `{a: 1}`), plain and quoted scalars, and comments.
+//! Anchors, aliases, tags, block scalars and multi-line flow collections are
+//! rejected with an error rather than misread.
+
+use serde_json::{Map, Number, Value};
+
+struct Line<'a> {
+    number: usize,
+    indent: usize,
+    text: &'a str,
+}
+
+pub fn parse(source: &str) -> Result<Value, String> {
+    let mut lines = Vec::new();
+    for (index, raw) in source.lines().enumerate() {
+        let number = index + 1;
+        let content = strip_comment(raw).trim_end();
+        let text = content.trim_start_matches(' ');
+        if text.is_empty() || text == "---" {
+            continue;
+        }
+        if text == "..." {
+            break;
+        }
+        if text.starts_with('\t') {
+            return Err(format!(
+                "line {}: tabs can't be used for indentation",
+                number
+            ));
+        }
+        lines.push(Line {
+            number,
+            indent: content.len() - text.len(),
+            text,
+        });
+    }
+
+    let mut reader = Reader { lines, pos: 0 };
+    let Some(first) = reader.lines.first() else {
+        return Ok(Value::Null);
+    };
+    let value = reader.block(first.indent)?;
+    match reader.lines.get(reader.pos) {
+        Some(line) => Err(format!("line {}: unexpected indentation", line.number)),
+        None => Ok(value),
+    }
+}
+
+struct Reader<'a> {
+    lines: Vec<Line<'a>>,
+    pos: usize,
+}
+
+impl Reader<'_> {
+    /// The mapping or sequence starting at the current line.
+    fn block(&mut self, indent: usize) -> Result<Value, String> {
+        let line = &self.lines[self.pos];
+        if is_item(line.text) {
+            self.sequence(indent)
+        } else if split_key(line.text).is_some() {
+            self.mapping(indent)
+        } else {
+            self.pos += 1;
+            scalar(line.text, line.number)
+        }
+    }
+
+    fn sequence(&mut self, indent: usize) -> Result<Value, String> {
+        let mut items = Vec::new();
+        while let Some(line) = self.lines.get(self.pos) {
+            if line.indent != indent || !is_item(line.text) {
+                break;
+            }
+            let rest = line.text[1..].trim_start_matches(' ');
+            if rest.is_empty() {
+                self.pos += 1;
+                items.push(self.nested(indent)?);
+                continue;
+            }
+            // `- key: value` opens a mapping indented to where `key` starts.
+            let item_indent = indent + line.text.len() - rest.len();
+            let number = line.number;
+            self.lines[self.pos] = Line {
+                number,
+                indent: item_indent,
+                text: rest,
+            };
+            items.push(self.block(item_indent)?);
+        }
+        Ok(Value::Array(items))
+    }
+
+    fn mapping(&mut self, indent: usize) -> Result<Value, String> {
+        let mut map = Map::new();
+        while let Some(line) = self.lines.get(self.pos) {
+            if line.indent < indent {
+                break;
+            }
+            if line.indent > indent {
+                return Err(format!("line {}: unexpected indentation", line.number));
+            }
+            let number = line.number;
+            let (key, rest) = split_key(line.text)
+                .ok_or_else(|| format!("line {}: expected `key: value`", number))?;
+            let key = match scalar(key, number)? {
+                Value::String(key) => key,
+                other => other.to_string(),
+            };
+            self.pos += 1;
+
+            let value = if rest.is_empty() {
+                match self.lines.get(self.pos) {
+                    // A sequence may sit at the same indentation as its key.
+                    Some(next) if next.indent == indent && is_item(next.text) => {
+                        self.sequence(indent)?
+                    }
+                    _ => self.nested(indent)?,
+                }
+            } else {
+                scalar(rest, number)?
+            };
+            if map.insert(key.clone(), value).is_some() {
+                return Err(format!("line {}: duplicate key '{}'", number, key));
+            }
+        }
+        Ok(Value::Object(map))
+    }
+
+    /// The block indented below the line just read, or null if there isn't one.
+    fn nested(&mut self, indent: usize) -> Result<Value, String> {
+        match self.lines.get(self.pos) {
+            Some(next) if next.indent > indent => self.block(next.indent),
+            _ => Ok(Value::Null),
+        }
+    }
+}
+
+fn is_item(text: &str) -> bool {
+    text == "-" || text.starts_with("- ")
+}
+
+/// Splits `key: value` at the first `: ` outside quotes and brackets.
+fn split_key(text: &str) -> Option<(&str, &str)> {
+    if text.starts_with('[') || text.starts_with('{') {
+        return None;
+    }
+    let mut quote = None;
+    for (index, c) in text.char_indices() {
+        match (quote, c) {
+            (Some(q), c) if c == q => quote = None,
+            (Some(_), _) => {}
+            (None, '"' | '\'') if index == 0 => quote = Some(c),
+            (None, ':') => {
+                let rest = &text[index + 1..];
+                if rest.is_empty() || rest.starts_with(' ') {
+                    return Some((text[..index].trim_end(), rest.trim_start()));
+                }
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+/// Drops a `#` comment, which must start the line or follow whitespace.
+fn strip_comment(line: &str) -> &str {
+    let mut quote = None;
+    let mut previous = ' ';
+    for (index, c) in line.char_indices() {
+        match (quote, c) {
+            (Some(q), c) if c == q => quote = None,
+            (Some(_), _) => {}
+            (None, '"' | '\'') if matches!(previous, ' ' | '[' | '{' | ',' | ':' | '-') => {
+                quote = Some(c)
+            }
+            (None, '#') if previous == ' ' => return &line[..index],
+            _ => {}
+        }
+        previous = c;
+    }
+    line
+}
+
+fn scalar(text: &str, number: usize) -> Result<Value, String> {
+    if let Some(c @ ('&' | '*' | '!' | '|' | '>')) = text.chars().next() {
+        let what = match c {
+            '&' => "anchors",
+            '*' => "aliases",
+            '!' => "tags",
+            _ => "block scalars",
+        };
+        return Err(format!("line {}: {} aren't supported", number, what));
+    }
+    let mut flow = Flow { text, pos: 0 };
+    let value = flow.value(false)?;
+    flow.skip_spaces();
+    if flow.pos < text.len() {
+        return Err(format!(
+            "line {}: unexpected '{}'",
+            number,
+            &text[flow.pos..]
+        ));
+    }
+    Ok(value)
+}
+
+/// A scalar or a one-line flow collection.
+struct Flow<'a> {
+    text: &'a str,
+    pos: usize,
+}
+
+impl Flow<'_> {
+    fn peek(&self) -> Option<char> {
+        self.text[self.pos..].chars().next()
+    }
+
+    fn skip_spaces(&mut self) {
+        while self.peek() == Some(' ') {
+            self.pos += 1;
+        }
+    }
+
+    fn expect(&mut self, c: char) -> Result<(), String> {
+        self.skip_spaces();
+        if self.peek() == Some(c) {
+            self.pos += 1;
+            Ok(())
+        } else {
+            Err(format!("expected '{}' in '{}'", c, self.text))
+        }
+    }
+
+    /// Inside a flow collection, plain scalars end at `,`, `]` and `}`.
+    fn value(&mut self, in_flow: bool) -> Result<Value, String> {
+        self.skip_spaces();
+        match self.peek() {
+            Some('[') => {
+                self.pos += 1;
+                let mut items = Vec::new();
+                loop {
+                    self.skip_spaces();
+                    if self.peek() == Some(']') {
+                        self.pos += 1;
+                        return Ok(Value::Array(items));
+                    }
+                    items.push(self.value(true)?);
+                    self.skip_spaces();
+                    if self.peek() != Some(']') {
+                        self.expect(',')?;
+                    }
+                }
+            }
+            Some('{') => {
+                self.pos += 1;
+                let mut map = Map::new();
+                loop {
+                    self.skip_spaces();
+                    if self.peek() == Some('}') {
+                        self.pos += 1;
+                        return Ok(Value::Object(map));
+                    }
+                    let key = match self.peek() {
+                        Some('"' | '\'') => self.quoted()?,
+                        _ => self.plain(&[':', ',', '}']).to_string(),
+                    };
+                    self.expect(':')?;
+                    let value = self.value(true)?;
+                    map.insert(key, value);
+                    self.skip_spaces();
+                    if self.peek() != Some('}') {
+                        self.expect(',')?;
+                    }
+                }
+            }
+            Some('"' | '\'') => self.quoted().map(Value::String),
+            _ if in_flow => Ok(typed(self.plain(&[',', ']', '}']))),
+            _ => Ok(typed(self.plain(&[]))),
+        }
+    }
+
+    fn plain(&mut self, terminators: &[char]) -> &str {
+        let start = self.pos;
+        let end = self.text[start..]
+            .find(|c| terminators.contains(&c))
+            .map_or(self.text.len(), |offset| start + offset);
+        self.pos = end;
+        self.text[start..end].trim()
+    }
+
+    fn quoted(&mut self) -> Result<String, String> {
+        let quote = self.peek().unwrap_or('"');
+        self.pos += 1;
+        let mut out = String::new();
+        let mut chars = self.text[self.pos..].char_indices();
+        while let Some((offset, c)) = chars.next() {
+            match c {
+                '\'' if quote == '\'' => {
+                    if self.text[self.pos + offset + 1..].starts_with('\'') {
+                        out.push('\'');
+                        chars.next();
+                    } else {
+                        self.pos += offset + 1;
+                        return Ok(out);
+                    }
+                }
+                '"' if quote == '"' => {
+                    self.pos += offset + 1;
+                    return Ok(out);
+                }
+                '\\' if quote == '"' => match chars.next() {
+                    Some((_, 'n')) => out.push('\n'),
+                    Some((_, 't')) => out.push('\t'),
+                    Some((_, c)) => out.push(c),
+                    None => break,
+                },
+                c => out.push(c),
+            }
+        }
+        Err(format!("unterminated string in '{}'", self.text))
+    }
+}
+
+fn typed(plain: &str) -> Value {
+    match plain {
+        "" | "~" | "null" | "Null" | "NULL" => Value::Null,
+        "true" | "True" | "TRUE" => Value::Bool(true),
+        "false" | "False" | "FALSE" => Value::Bool(false),
+        _ => {
+            if let Ok(number) = plain.parse::<i64>() {
+                Value::Number(number.into())
+            } else if let Some(number) = plain
+                .parse::<f64>()
+                .ok()
+                .filter(|_| plain.starts_with(|c: char| c.is_ascii_digit() || c == '-'))
+                .and_then(Number::from_f64)
+            {
+                Value::Number(number)
+            } else {
+                Value::String(plain.to_string())
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_parse_block_and_flow_collections() {
+        let source = r#"
+# leading comment
+version: "2"
+linters:
+  default: none
+  enable:
+  - errcheck   # trailing comment
+  - 'gocyclo'
+  disable: [gosec, "noctx"]
+  settings:
+    gocyclo: {min-complexity: 15}
+issues:
+  exclude-rules:
+    - path: _test\.go
+      linters:
+        - errcheck
+  max-same-issues: 0
+  new: false
+"#;
+        assert_eq!(
+            parse(source).unwrap(),
+            json!({
+                "version": "2",
+                "linters": {
+                    "default": "none",
+                    "enable": ["errcheck", "gocyclo"],
+                    "disable": ["gosec", "noctx"],
+                    "settings": {"gocyclo": {"min-complexity": 15}},
+                },
+                "issues": {
+                    "exclude-rules": [{"path": "_test\\.go", "linters": ["errcheck"]}],
+                    "max-same-issues": 0,
+                    "new": false,
+                },
+            })
+        );
+    }
+
+    #[test]
+    fn test_unsupported_syntax_is_an_error() {
+        assert!(parse("defaults: &defaults\n  a: 1\n").is_err());
+        assert!(parse("a:\n  b: 1\n    c: 2\n").is_err());
+        assert!(parse("a: 1\na: 2\n").is_err());
+    }
+}
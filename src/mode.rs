@@ -0,0 +1,113 @@
+//! `--mode syntax`: analysis for code that doesn't build yet.
+//!
+//! Mid-refactor, or in CI without access to a private module, the package
+//! around a file may not parse cleanly and its dependencies may not be in
+//! the module cache. In syntax mode no package, `go.mod` or call graph is
+//! loaded, and each file is analyzed on its own: query rules run as they
+//! always do, and so do checks that only sharpen their findings with the
+//! package, the way they do on a file outside one. The rules whose checks
+//! declare [`Check::needs_package`] are left out, since they'd report
+//! nothing or report what the rest of the package rules out.
+//!
+//! [`Check::needs_package`]: crate::checks::Check::needs_package
+
+use crate::config::AnalyzerConfig;
+use crate::plugin::Registry;
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum Mode {
+    /// Files analyzed with their package, when a rule reads it.
+    #[default]
+    Full,
+    /// Files analyzed alone.
+    Syntax,
+}
+
+impl Mode {
+    pub const NAMES: &'static str = "full, syntax";
+
+    pub fn from_name(name: &str) -> Option<Self> {
+        match name.to_lowercase().as_str() {
+            "full" => Some(Mode::Full),
+            "syntax" => Some(Mode::Syntax),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Mode::Full => "full",
+            Mode::Syntax => "syntax",
+        }
+    }
+
+    /// Whether files are analyzed with their package.
+    pub fn loads_package(&self) -> bool {
+        *self == Mode::Full
+    }
+
+    /// Disables the rules of `config` the mode doesn't run.
+    pub fn apply(&self, config: &mut AnalyzerConfig, registry: &Registry) {
+        if *self == Mode::Full {
+            return;
+        }
+        for rule in &mut config.rules {
+            if rule
+                .check
+                .as_deref()
+                .and_then(|name| registry.get(name))
+                .is_some_and(|check| check.needs_package())
+            {
+                rule.enabled = false;
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const CONFIG: &str = r#"
+[[rules]]
+name = "bug"
+query = "(ERROR) @error"
+severity = "error"
+message = "Bug"
+enabled = true
+
+[[rules]]
+name = "loop_capture"
+query = "(source_file) @file"
+check = "go_loop_capture"
+severity = "warning"
+message = "Captured"
+enabled = true
+
+[[rules]]
+name = "unused"
+query = "(source_file) @file"
+check = "go_unused"
+severity = "warning"
+message = "Unused"
+enabled = true
+"#;
+
+    #[test]
+    fn test_syntax_mode_leaves_out_rules_that_need_the_package() {
+        let enabled = |mode: Mode| {
+            let mut config = AnalyzerConfig::from_str(CONFIG).unwrap();
+            mode.apply(&mut config, &Registry::new());
+            config
+                .rules
+                .iter()
+                .filter(|rule| rule.enabled)
+                .map(|rule| rule.name.clone())
+                .collect::<Vec<_>>()
+        };
+        assert_eq!(enabled(Mode::Full), ["bug", "loop_capture", "unused"]);
+        assert_eq!(enabled(Mode::Syntax), ["bug", "loop_capture"]);
+        assert_eq!(Mode::from_name("Syntax"), Some(Mode::Syntax));
+        assert_eq!(Mode::from_name("types"), None);
+    }
+}
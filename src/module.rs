@@ -0,0 +1,856 @@
+//! The Go module a file belongs to, and facts about the packages it imports.
+//!
+//! A [`Module`] is read from the nearest `go.mod`. Its requirements say which
+//! version of each dependency the build uses, and locate the dependency's
+//! source: a local `replace` target, the `vendor/` directory, or the module
+//! cache (`$GOMODCACHE`, else `$GOPATH/pkg/mod`, else `~/go/pkg/mod`).
+//! Standard library packages are read from `$GOROOT/src`, else from the Go
+//! installation the `go` on `PATH` belongs to.
+//! [`Module::facts`] reads an imported package from there and records what
+//! rules need to know without type checking, such as which declarations are
+//! marked `Deprecated:`. Nothing is downloaded: a dependency missing from the
+//! cache has no facts, and rules fall back to what the file alone shows.
+
+use crate::checks::node_text;
+use crate::language::SupportedLanguage;
+use std::cmp::Ordering;
+use std::collections::{HashMap, HashSet};
+use std::env;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex, OnceLock};
+use tree_sitter::{Node, Parser};
+
+pub const GO_MOD_FILE: &str = "go.mod";
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Requirement {
+    pub path: String,
+    pub version: String,
+}
+
+#[derive(Debug, Clone)]
+enum Replacement {
+    Dir(PathBuf),
+    Module(Requirement),
+}
+
+#[derive(Debug, Clone)]
+pub struct Module {
+    /// The directory holding `go.mod`.
+    pub root: PathBuf,
+    /// The module path from the `module` directive.
+    pub path: String,
+    /// The language version from the `go` directive, such as `1.22`.
+    pub go: Option<String>,
+    /// The `go.mod` contents, for cache keys.
+    pub go_mod: String,
+    pub requires: Vec<Requirement>,
+    replaces: Vec<(String, Replacement)>,
+    cache: Option<PathBuf>,
+    goroot: Option<PathBuf>,
+}
+
+/// What an imported package declares, as far as rules care.
+#[derive(Debug, Default)]
+pub struct PackageFacts {
+    /// The name in the package clause, which needn't match the import path.
+    pub name: String,
+    /// The `Deprecated:` note of each deprecated declaration, keyed by name,
+    /// or `Type.Method` for methods.
+    pub deprecated: HashMap<String, String>,
+    /// The package's own `Deprecated:` note, or its module's.
+    pub package_deprecated: Option<String>,
+    /// Functions, and methods as `Type.Method`, whose doc comment has a
+    /// `//compass:mustuse` line: their result must not be discarded.
+    pub must_use: HashSet<String>,
+    /// Functions, and methods as `Type.Method`, whose doc comment declares
+    /// a contract with `//compass:` annotations.
+    pub contracts: HashMap<String, Contract>,
+}
+
+/// What a function's doc comment promises about its parameters and
+/// results, for rules to rely on instead of guessing:
+///
+/// - `//compass:nonnil` says it never returns a nil result besides an
+///   error, and `//compass:nonnil param=db,cache` that it must not be
+///   passed a nil `db` or `cache`;
+/// - `//compass:closes conn` that it takes `conn` over and closes it;
+/// - `//compass:noescape` that it neither keeps nor closes anything it's
+///   passed, or with `param=buf`, `buf`.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Contract {
+    /// The function's parameter names in order, `""` for unnamed ones.
+    pub parameters: Vec<String>,
+    pub nonnil_result: bool,
+    pub nonnil: Vec<String>,
+    pub closes: Vec<String>,
+    pub noescape: Vec<String>,
+}
+
+impl Contract {
+    /// The contract `doc` declares for a function with `parameters`, or
+    /// `None` when it has no contract annotations.
+    pub fn parse(doc: &str, parameters: Vec<String>) -> Option<Contract> {
+        let mut contract = Contract {
+            parameters,
+            ..Contract::default()
+        };
+        let mut annotated = false;
+        for line in doc.lines() {
+            let Some(annotation) = line.trim().strip_prefix("//compass:") else {
+                continue;
+            };
+            let (kind, rest) = annotation
+                .split_once(char::is_whitespace)
+                .unwrap_or((annotation, ""));
+            let rest = rest.trim();
+            let names: Vec<String> = rest
+                .strip_prefix("param=")
+                .unwrap_or(rest)
+                .split([',', ' '])
+                .filter(|name| !name.is_empty())
+                .map(str::to_string)
+                .collect();
+            match kind {
+                "nonnil" if names.is_empty() => contract.nonnil_result = true,
+                "nonnil" => contract.nonnil.extend(names),
+                "closes" => contract.closes.extend(names),
+                // Without names, every parameter.
+                "noescape" if names.is_empty() => {
+                    contract.noescape = contract.parameters.clone();
+                }
+                "noescape" => contract.noescape.extend(names),
+                _ => continue,
+            }
+            annotated = true;
+        }
+        annotated.then_some(contract)
+    }
+}
+
+impl Module {
+    /// The module containing `dir`, if any directory above it has a `go.mod`.
+    pub fn find(dir: &Path) -> io::Result<Option<Module>> {
+        let dir = dir.canonicalize()?;
+        for candidate in dir.ancestors() {
+            let go_mod = candidate.join(GO_MOD_FILE);
+            if go_mod.is_file() {
+                let content = fs::read_to_string(&go_mod)?;
+                return Ok(Some(Module::parse(candidate.to_path_buf(), content)));
+            }
+        }
+        Ok(None)
+    }
+
+    pub fn parse(root: PathBuf, go_mod: String) -> Module {
+        let mut module = Module {
+            root,
+            path: String::new(),
+            go: None,
+            requires: Vec::new(),
+            replaces: Vec::new(),
+            cache: module_cache(),
+            goroot: go_root(),
+            go_mod: String::new(),
+        };
+        for (directive, args) in directives(&go_mod) {
+            match directive {
+                "module" => module.path = args.first().cloned().unwrap_or_default(),
+                "go" => module.go = args.first().cloned(),
+                "require" => {
+                    if let [path, version, ..] = args.as_slice() {
+                        module.requires.push(Requirement {
+                            path: path.clone(),
+                            version: version.clone(),
+                        });
+                    }
+                }
+                "replace" => {
+                    let Some(arrow) = args.iter().position(|arg| arg == "=>") else {
+                        continue;
+                    };
+                    let (Some(from), Some(to)) = (args.first(), args.get(arrow + 1)) else {
+                        continue;
+                    };
+                    let replacement = match args.get(arrow + 2) {
+                        Some(version) => Replacement::Module(Requirement {
+                            path: to.clone(),
+                            version: version.clone(),
+                        }),
+                        None => Replacement::Dir(module.root.join(to)),
+                    };
+                    module.replaces.push((from.clone(), replacement));
+                }
+                _ => {}
+            }
+        }
+        module.go_mod = go_mod;
+        module
+    }
+
+    /// The module without the module cache or the standard library, so that
+    /// dependencies are only read from `vendor` and directory replacements,
+    /// which a sandboxed build can declare as inputs.
+    pub fn without_module_cache(mut self) -> Module {
+        self.cache = None;
+        self.goroot = None;
+        self
+    }
+
+    /// The import path of the package in `dir`, if `dir` is inside the module.
+    pub fn package_path(&self, dir: &Path) -> Option<String> {
+        let dir = dir.canonicalize().ok()?;
+        let rest = dir.strip_prefix(&self.root).ok()?;
+        let mut path = self.path.clone();
+        for segment in rest.iter() {
+            path.push('/');
+            path.push_str(&segment.to_string_lossy());
+        }
+        Some(path)
+    }
+
+    /// The requirement that provides `import_path`: the one with the longest
+    /// module path prefixing it.
+    pub fn requirement(&self, import_path: &str) -> Option<&Requirement> {
+        self.requires
+            .iter()
+            .filter(|require| within(import_path, &require.path))
+            .max_by_key(|require| require.path.len())
+    }
+
+    /// The published module and version the build uses for `import_path`,
+    /// with the package's path in that module: its requirement, or the
+    /// module a `replace` swaps in. `None` when a directory replaces it, or
+    /// nothing requires it.
+    pub fn selected(&self, import_path: &str) -> Option<(&Requirement, String)> {
+        let require = self.requirement(import_path)?;
+        match self.replaces.iter().find(|(from, _)| *from == require.path) {
+            Some((_, Replacement::Module(to))) => {
+                let rest = &import_path[require.path.len()..];
+                Some((to, format!("{}{}", to.path, rest)))
+            }
+            Some((_, Replacement::Dir(_))) => None,
+            None => Some((require, import_path.to_string())),
+        }
+    }
+
+    /// Where the source of `import_path` is, if it's on disk.
+    pub fn package_dir(&self, import_path: &str) -> Option<PathBuf> {
+        if within(import_path, &self.path) {
+            return Some(self.root.join(subdir(import_path, &self.path)));
+        }
+        if is_standard(import_path) {
+            let dir = self.goroot.as_ref()?.join("src").join(import_path);
+            return dir.is_dir().then_some(dir);
+        }
+        let require = self.requirement(import_path)?;
+        let rest = subdir(import_path, &require.path);
+
+        let replacement = self.replaces.iter().find(|(from, _)| *from == require.path);
+        let dir = match replacement.map(|(_, to)| to) {
+            Some(Replacement::Dir(dir)) => dir.join(rest),
+            Some(Replacement::Module(to)) => self.cache_dir(to)?.join(rest),
+            None => {
+                let vendored = self.root.join("vendor").join(import_path);
+                if vendored.is_dir() {
+                    vendored
+                } else {
+                    self.cache_dir(require)?.join(rest)
+                }
+            }
+        };
+        dir.is_dir().then_some(dir)
+    }
+
+    /// Facts about `import_path`, if its source is on disk. Packages in the
+    /// module cache or the standard library never change, so they're only
+    /// read once per process.
+    pub fn facts(&self, import_path: &str) -> Option<Arc<PackageFacts>> {
+        let dir = self.package_dir(import_path)?;
+        let immutable = [&self.cache, &self.goroot]
+            .iter()
+            .any(|root| root.as_ref().is_some_and(|root| dir.starts_with(root)));
+        if !immutable {
+            return read_facts(&dir, self.module_dir(import_path).as_deref()).map(Arc::new);
+        }
+
+        static CACHED: OnceLock<Mutex<HashMap<PathBuf, Option<Arc<PackageFacts>>>>> =
+            OnceLock::new();
+        let cached = CACHED.get_or_init(Default::default);
+        if let Some(facts) = cached.lock().ok()?.get(&dir) {
+            return facts.clone();
+        }
+        let facts = read_facts(&dir, self.module_dir(import_path).as_deref()).map(Arc::new);
+        cached.lock().ok()?.insert(dir, facts.clone());
+        facts
+    }
+
+    /// The root of the dependency module providing `import_path`.
+    fn module_dir(&self, import_path: &str) -> Option<PathBuf> {
+        let require = self.requirement(import_path)?;
+        self.package_dir(&require.path)
+    }
+
+    fn cache_dir(&self, require: &Requirement) -> Option<PathBuf> {
+        let cache = self.cache.as_ref()?;
+        Some(cache.join(format!(
+            "{}@{}",
+            escape(&require.path),
+            escape(&require.version)
+        )))
+    }
+}
+
+/// Whether a `vX.Y.Z` version is at least `major.minor`. Unparseable
+/// versions count as older.
+pub fn version_at_least(version: &str, major: u64, minor: u64) -> bool {
+    let version = version.trim_start_matches('v');
+    let mut parts = version
+        .split(|c| c == '.' || c == '-' || c == '+')
+        .map(|part| part.parse::<u64>().ok());
+    match (parts.next().flatten(), parts.next().flatten()) {
+        (Some(found_major), Some(found_minor)) => (found_major, found_minor) >= (major, minor),
+        _ => false,
+    }
+}
+
+/// Orders two module versions the way semantic versioning does, so that
+/// `v1.10.0` is newer than `v1.9.0` and a pre-release, including a
+/// pseudo-version, is older than the release it precedes. Build metadata
+/// such as `+incompatible` is ignored.
+pub fn compare_versions(a: &str, b: &str) -> Ordering {
+    fn parts(version: &str) -> (Vec<u64>, Option<&str>) {
+        let version = version.trim_start_matches('v');
+        let version = version.split('+').next().unwrap_or_default();
+        let (release, pre) = match version.split_once('-') {
+            Some((release, pre)) => (release, Some(pre)),
+            None => (version, None),
+        };
+        let numbers = release
+            .split('.')
+            .map(|part| part.parse().unwrap_or(0))
+            .chain(std::iter::repeat(0))
+            .take(3)
+            .collect();
+        (numbers, pre)
+    }
+    let (a_release, a_pre) = parts(a);
+    let (b_release, b_pre) = parts(b);
+    a_release
+        .cmp(&b_release)
+        .then_with(|| match (a_pre, b_pre) {
+            (None, None) => Ordering::Equal,
+            (None, Some(_)) => Ordering::Greater,
+            (Some(_), None) => Ordering::Less,
+            (Some(a), Some(b)) => {
+                let mut a = a.split('.');
+                let mut b = b.split('.');
+                loop {
+                    match (a.next(), b.next()) {
+                        (None, None) => return Ordering::Equal,
+                        (None, Some(_)) => return Ordering::Less,
+                        (Some(_), None) => return Ordering::Greater,
+                        (Some(a), Some(b)) => {
+                            let order = match (a.parse::<u64>(), b.parse::<u64>()) {
+                                (Ok(a), Ok(b)) => a.cmp(&b),
+                                (Ok(_), Err(_)) => Ordering::Less,
+                                (Err(_), Ok(_)) => Ordering::Greater,
+                                (Err(_), Err(_)) => a.cmp(b),
+                            };
+                            if order != Ordering::Equal {
+                                return order;
+                            }
+                        }
+                    }
+                }
+            }
+        })
+}
+
+/// Where the module cache at `cache` keeps what it downloaded about the module at
+/// `module_path`: a `.info`, `.mod` and `.zip` per version, and the list of
+/// published versions once something asked for it.
+pub(crate) fn download_dir(cache: &Path, module_path: &str) -> PathBuf {
+    cache
+        .join("cache")
+        .join("download")
+        .join(escape(module_path))
+        .join("@v")
+}
+
+/// Where the module cache keeps `version` of the module at `module_path`,
+/// whether or not it has been downloaded.
+pub(crate) fn published_dir(module_path: &str, version: &str) -> Option<PathBuf> {
+    Some(module_cache()?.join(format!("{}@{}", escape(module_path), escape(version))))
+}
+
+pub(crate) fn module_cache() -> Option<PathBuf> {
+    if let Some(cache) = env::var_os("GOMODCACHE").filter(|value| !value.is_empty()) {
+        return Some(PathBuf::from(cache));
+    }
+    let gopath = env::var_os("GOPATH")
+        .filter(|value| !value.is_empty())
+        .and_then(|value| env::split_paths(&value).next())
+        .or_else(|| env::var_os("HOME").map(|home| PathBuf::from(home).join("go")))?;
+    Some(gopath.join("pkg").join("mod"))
+}
+
+/// The Go installation: `$GOROOT`, else the one the `go` on `PATH` is in.
+fn go_root() -> Option<PathBuf> {
+    if let Some(root) = env::var_os("GOROOT").filter(|value| !value.is_empty()) {
+        return Some(PathBuf::from(root));
+    }
+    env::split_paths(&env::var_os("PATH")?)
+        .map(|dir| dir.join("go"))
+        .find(|go| go.is_file())
+        .and_then(|go| go.canonicalize().ok())
+        .and_then(|go| Some(go.parent()?.parent()?.to_path_buf()))
+}
+
+/// Whether `import_path` names a standard library package: its first
+/// element has no dot, as every module path outside it does.
+fn is_standard(import_path: &str) -> bool {
+    let first = import_path.split('/').next().unwrap_or_default();
+    !first.is_empty() && !first.contains('.')
+}
+
+/// The module cache spells capital letters as `!` and the lowercase letter,
+/// so paths stay unique on case-insensitive file systems.
+fn escape(path: &str) -> String {
+    let mut escaped = String::with_capacity(path.len());
+    for c in path.chars() {
+        if c.is_ascii_uppercase() {
+            escaped.push('!');
+            escaped.push(c.to_ascii_lowercase());
+        } else {
+            escaped.push(c);
+        }
+    }
+    escaped
+}
+
+/// Whether `import_path` is `module_path` or a package inside it.
+pub(crate) fn within(import_path: &str, module_path: &str) -> bool {
+    !module_path.is_empty()
+        && (import_path == module_path
+            || import_path
+                .strip_prefix(module_path)
+                .is_some_and(|rest| rest.starts_with('/')))
+}
+
+fn subdir<'a>(import_path: &'a str, module_path: &str) -> &'a str {
+    import_path[module_path.len()..].trim_start_matches('/')
+}
+
+/// The directives of a `go.mod` or `go.work`, with `require ( ... )` and
+/// `use ( ... )` blocks flattened into one directive per line and comments
+/// dropped.
+pub(crate) fn directives(go_mod: &str) -> Vec<(&str, Vec<String>)> {
+    directive_lines(go_mod)
+        .into_iter()
+        .map(|directive| (directive.name, directive.args))
+        .collect()
+}
+
+/// A directive of a `go.mod`, where it is written.
+#[derive(Debug, Clone, PartialEq)]
+pub(crate) struct Directive<'a> {
+    pub name: &'a str,
+    pub args: Vec<String>,
+    /// The comment trailing it, such as `indirect` or a retraction's
+    /// rationale, without `//`.
+    pub comment: &'a str,
+    /// The bytes of the directive, or of its line inside a block, without
+    /// the comment.
+    pub start_byte: usize,
+    pub end_byte: usize,
+}
+
+/// Like [`directives`], keeping where each is and its comment.
+pub(crate) fn directive_lines(go_mod: &str) -> Vec<Directive<'_>> {
+    let mut found = Vec::new();
+    let mut block: Option<&str> = None;
+    let mut offset = 0;
+    for raw in go_mod.split_inclusive('\n') {
+        let line_start = offset;
+        offset += raw.len();
+        let (code, comment) = match raw.split_once("//") {
+            Some((code, comment)) => (code, comment.trim()),
+            None => (raw, ""),
+        };
+        let line = code.trim();
+        if line.is_empty() {
+            continue;
+        }
+        let start_byte = line_start + (code.len() - code.trim_start().len());
+        let end_byte = start_byte + line.len();
+        if let Some(name) = block {
+            if line == ")" {
+                block = None;
+            } else {
+                found.push(Directive {
+                    name,
+                    args: arguments(line),
+                    comment,
+                    start_byte,
+                    end_byte,
+                });
+            }
+            continue;
+        }
+        let (name, rest) = line.split_once(char::is_whitespace).unwrap_or((line, ""));
+        if rest.trim() == "(" {
+            block = Some(name);
+        } else {
+            found.push(Directive {
+                name,
+                args: arguments(rest),
+                comment,
+                start_byte,
+                end_byte,
+            });
+        }
+    }
+    found
+}
+
+fn arguments(text: &str) -> Vec<String> {
+    text.split_whitespace()
+        .map(|arg| arg.trim_matches(|c| c == '"' || c == '`').to_string())
+        .collect()
+}
+
+/// The `Deprecated:` paragraph of a doc comment, collapsed onto one line.
+pub fn deprecation_note(doc: &str) -> Option<String> {
+    let mut note: Option<Vec<&str>> = None;
+    for line in doc.lines() {
+        let line = line
+            .trim()
+            .trim_start_matches("//")
+            .trim_start_matches("/*")
+            .trim_end_matches("*/")
+            .trim();
+        match &mut note {
+            Some(_) if line.is_empty() => break,
+            Some(words) => words.push(line),
+            None => {
+                if let Some(rest) = line.strip_prefix("Deprecated:") {
+                    note = Some(vec![rest.trim()]);
+                }
+            }
+        }
+    }
+    note.map(|words| words.join(" ").trim().to_string())
+}
+
+/// The comments directly above a module's `module` directive.
+fn module_deprecation(module_dir: &Path) -> Option<String> {
+    go_mod_deprecation(&fs::read_to_string(module_dir.join(GO_MOD_FILE)).ok()?)
+}
+
+/// The `Deprecated:` note above the `module` directive of `go_mod`.
+pub(crate) fn go_mod_deprecation(go_mod: &str) -> Option<String> {
+    let mut doc = Vec::new();
+    for line in go_mod.lines() {
+        let line = line.trim();
+        if line.starts_with("module ") || line.starts_with("module\t") {
+            return deprecation_note(&doc.join("\n"));
+        }
+        if line.starts_with("//") {
+            doc.push(line);
+        } else {
+            doc.clear();
+        }
+    }
+    None
+}
+
+fn read_facts(dir: &Path, module_dir: Option<&Path>) -> Option<PackageFacts> {
+    let mut parser = Parser::new();
+    parser
+        .set_language(&SupportedLanguage::Go.tree_sitter_language())
+        .ok()?;
+
+    let mut files: Vec<PathBuf> = fs::read_dir(dir)
+        .ok()?
+        .filter_map(|entry| Some(entry.ok()?.path()))
+        .filter(|path| {
+            let name = path.file_name().unwrap_or_default().to_string_lossy();
+            name.ends_with(".go") && !name.ends_with("_test.go")
+        })
+        .collect();
+    files.sort();
+    if files.is_empty() {
+        return None;
+    }
+
+    let mut facts = PackageFacts::default();
+    for file in files {
+        let Ok(source_code) = fs::read_to_string(&file) else {
+            continue;
+        };
+        let Some(tree) = parser.parse(&source_code, None) else {
+            continue;
+        };
+        collect_facts(tree.root_node(), &source_code, &mut facts);
+    }
+    if facts.package_deprecated.is_none() {
+        facts.package_deprecated = module_dir.and_then(module_deprecation);
+    }
+    Some(facts)
+}
+
+/// Adds what the declarations of one file say to `facts`.
+pub(crate) fn collect_facts(root: Node, source_code: &str, facts: &mut PackageFacts) {
+    let mut cursor = root.walk();
+    for node in root.named_children(&mut cursor) {
+        let doc = doc_comment(node, source_code);
+        let note = deprecation_note(&doc);
+        match node.kind() {
+            "package_clause" => {
+                if let Some(name) = node.named_child(0) {
+                    facts.name = node_text(name, source_code).to_string();
+                }
+                if note.is_some() {
+                    facts.package_deprecated = note;
+                }
+            }
+            "function_declaration" => {
+                let Some(name) = node.child_by_field_name("name") else {
+                    continue;
+                };
+                let name = node_text(name, source_code).to_string();
+                if is_must_use(&doc) {
+                    facts.must_use.insert(name.clone());
+                }
+                if let Some(contract) = Contract::parse(&doc, parameter_names(node, source_code)) {
+                    facts.contracts.insert(name.clone(), contract);
+                }
+                if let Some(note) = note {
+                    facts.deprecated.insert(name, note);
+                }
+            }
+            "method_declaration" => {
+                let Some(name) = node.child_by_field_name("name") else {
+                    continue;
+                };
+                let receiver = node
+                    .child_by_field_name("receiver")
+                    .and_then(|receiver| receiver.named_child(0))
+                    .and_then(|parameter| parameter.child_by_field_name("type"))
+                    .map(|ty| node_text(ty, source_code).trim_start_matches('*'))
+                    .map(|ty| ty.split('[').next().unwrap_or(ty).to_string())
+                    .unwrap_or_default();
+                let name = format!("{}.{}", receiver, node_text(name, source_code));
+                if is_must_use(&doc) {
+                    facts.must_use.insert(name.clone());
+                }
+                if let Some(contract) = Contract::parse(&doc, parameter_names(node, source_code)) {
+                    facts.contracts.insert(name.clone(), contract);
+                }
+                if let Some(note) = note {
+                    facts.deprecated.insert(name, note);
+                }
+            }
+            "type_declaration" | "const_declaration" | "var_declaration" => {
+                collect_specs(node, source_code, note, facts);
+            }
+            _ => {}
+        }
+    }
+}
+
+/// The contract the doc comment of a top-level function or method declares.
+pub(crate) fn contract(declaration: Node, source_code: &str) -> Option<Contract> {
+    Contract::parse(
+        &doc_comment(declaration, source_code),
+        parameter_names(declaration, source_code),
+    )
+}
+
+/// The names of a function's parameters, not counting a method's receiver.
+fn parameter_names(declaration: Node, source_code: &str) -> Vec<String> {
+    let mut names = Vec::new();
+    let Some(parameters) = declaration.child_by_field_name("parameters") else {
+        return names;
+    };
+    let mut cursor = parameters.walk();
+    for parameter in parameters.named_children(&mut cursor) {
+        if !matches!(
+            parameter.kind(),
+            "parameter_declaration" | "variadic_parameter_declaration"
+        ) {
+            continue;
+        }
+        let mut declared = parameter.walk();
+        let before = names.len();
+        names.extend(
+            parameter
+                .children_by_field_name("name", &mut declared)
+                .map(|name| node_text(name, source_code).to_string()),
+        );
+        if names.len() == before {
+            names.push(String::new());
+        }
+    }
+    names
+}
+
+fn is_must_use(doc: &str) -> bool {
+    doc.lines().any(|line| line.trim() == "//compass:mustuse")
+}
+
+/// Type, const and var specs, which may each have a doc comment inside a
+/// group or inherit the group's.
+fn collect_specs(
+    declaration: Node,
+    source_code: &str,
+    group_note: Option<String>,
+    facts: &mut PackageFacts,
+) {
+    let mut cursor = declaration.walk();
+    for spec in declaration.named_children(&mut cursor) {
+        // Newer grammars wrap grouped var specs in a `var_spec_list`.
+        if spec.kind().ends_with("_list") {
+            collect_specs(spec, source_code, group_note.clone(), facts);
+            continue;
+        }
+        if !spec.kind().ends_with("_spec") && spec.kind() != "type_alias" {
+            continue;
+        }
+        let note = deprecation_note(&doc_comment(spec, source_code)).or(group_note.clone());
+        let Some(note) = note else {
+            continue;
+        };
+        let mut names = spec.walk();
+        for name in spec.children_by_field_name("name", &mut names) {
+            facts
+                .deprecated
+                .insert(node_text(name, source_code).to_string(), note.clone());
+        }
+    }
+}
+
+/// The comment lines ending on the line above `node`.
+pub(crate) fn doc_comment(node: Node, source_code: &str) -> String {
+    let mut lines = Vec::new();
+    let mut next_row = node.start_position().row;
+    let mut previous = node.prev_sibling();
+    while let Some(comment) = previous.filter(|p| p.kind() == "comment") {
+        if comment.end_position().row + 1 != next_row {
+            break;
+        }
+        lines.push(node_text(comment, source_code));
+        next_row = comment.start_position().row;
+        previous = comment.prev_sibling();
+    }
+    lines.reverse();
+    lines.join("\n")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_go_mod() {
+        let module = Module::parse(
+            PathBuf::from("/src/app"),
+            "module example.com/app\n\ngo 1.22\n\nrequire (\n\tgoogle.golang.org/grpc v1.64.0\n\tgithub.com/BurntSushi/toml v1.3.2 // indirect\n)\n\nrequire github.com/aws/aws-sdk-go v1.55.5\n\nreplace github.com/BurntSushi/toml => ../toml\n"
+                .to_string(),
+        );
+        assert_eq!(module.path, "example.com/app");
+        assert_eq!(module.go.as_deref(), Some("1.22"));
+        assert_eq!(
+            module.requirement("google.golang.org/grpc/credentials/insecure"),
+            Some(&Requirement {
+                path: "google.golang.org/grpc".to_string(),
+                version: "v1.64.0".to_string(),
+            })
+        );
+        assert!(module.requirement("google.golang.org/grpcx").is_none());
+        assert_eq!(module.requires[2].path, "github.com/aws/aws-sdk-go");
+        assert!(matches!(
+            &module.replaces[0].1,
+            Replacement::Dir(dir) if dir == Path::new("/src/app/../toml")
+        ));
+        assert!(module.selected("github.com/BurntSushi/toml").is_none());
+        let forked = Module::parse(
+            PathBuf::from("/src/app"),
+            "module example.com/app\n\nrequire example.com/lib v1.0.0\n\nreplace example.com/lib => example.com/fork v1.0.1\n".to_string(),
+        );
+        let (selected, package) = forked.selected("example.com/lib/codec").unwrap();
+        assert_eq!(
+            (selected.path.as_str(), selected.version.as_str()),
+            ("example.com/fork", "v1.0.1")
+        );
+        assert_eq!(package, "example.com/fork/codec");
+        assert_eq!(
+            escape("github.com/BurntSushi/toml"),
+            "github.com/!burnt!sushi/toml"
+        );
+
+        let go_mod = "require (\n\tgithub.com/BurntSushi/toml v1.3.2 // indirect\n)\n";
+        let lines = directive_lines(go_mod);
+        assert_eq!(lines[0].comment, "indirect");
+        assert_eq!(
+            &go_mod[lines[0].start_byte..lines[0].end_byte],
+            "github.com/BurntSushi/toml v1.3.2"
+        );
+    }
+
+    #[test]
+    fn test_versions_compare_as_semver() {
+        assert_eq!(compare_versions("v1.10.0", "v1.9.3"), Ordering::Greater);
+        assert_eq!(compare_versions("v1.2.0-rc.1", "v1.2.0"), Ordering::Less);
+        assert_eq!(
+            compare_versions("v1.2.0-rc.2", "v1.2.0-rc.10"),
+            Ordering::Less
+        );
+        assert_eq!(
+            compare_versions("v0.0.0-20210101000000-abcdef123456", "v0.1.0"),
+            Ordering::Less
+        );
+        assert_eq!(
+            compare_versions("v2.0.0+incompatible", "v2.0.0"),
+            Ordering::Equal
+        );
+    }
+
+    #[test]
+    fn test_contracts_read_from_doc_comments() {
+        let parameters = || vec!["db".to_string(), "conn".to_string(), String::new()];
+        let doc = "// Serve handles one connection.\n//\n//compass:nonnil param=db\n//compass:closes conn\n//compass:nonnil";
+        assert_eq!(
+            Contract::parse(doc, parameters()),
+            Some(Contract {
+                parameters: parameters(),
+                nonnil_result: true,
+                nonnil: vec!["db".to_string()],
+                closes: vec!["conn".to_string()],
+                noescape: Vec::new(),
+            })
+        );
+        let noescape = Contract::parse("//compass:noescape", parameters()).unwrap();
+        assert_eq!(noescape.noescape, parameters());
+        assert!(Contract::parse(
+            "// Serve handles one connection.\n//compass:mustuse",
+            parameters()
+        )
+        .is_none());
+    }
+
+    #[test]
+    fn test_deprecation_note_stops_at_the_paragraph() {
+        let doc = "// Dial creates a client connection.\n//\n// Deprecated: use NewClient instead.\n// Will be supported throughout 1.x.\n//\n// More text.";
+        assert_eq!(
+            deprecation_note(doc).unwrap(),
+            "use NewClient instead. Will be supported throughout 1.x."
+        );
+        assert!(deprecation_note("// Dial is not deprecated.").is_none());
+        assert!(version_at_least("v1.64.0", 1, 63));
+        assert!(!version_at_least("v1.9.2", 1, 63));
+    }
+}
@@ -0,0 +1,145 @@
+//! The other files of the package a file belongs to.
+//!
+//! Most checks look at one file at a time. Checks that need the rest of the
+//! package, such as which symbols its tests call, get a [`Package`] holding
+//! the file's siblings: files in the same directory in the same language.
+//! For Go files it also carries the [`Module`] from the nearest `go.mod`,
+//! through which checks can look up facts about imported dependencies.
+
+use crate::build::BuildContext;
+use crate::callgraph::CallGraph;
+use crate::language::SupportedLanguage;
+use crate::module::Module;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+
+pub struct PackageFile {
+    pub path: PathBuf,
+    pub source_code: String,
+}
+
+pub struct Package {
+    /// The file being analyzed.
+    pub path: PathBuf,
+    /// Its siblings, sorted by path. The analyzed file isn't included; its
+    /// contents are the source passed to the check.
+    pub files: Vec<PackageFile>,
+    /// The Go module the package belongs to, if it has one.
+    pub module: Option<Module>,
+}
+
+impl Package {
+    /// Reads the siblings of `path` from disk.
+    pub fn load<P: AsRef<Path>>(path: P) -> io::Result<Self> {
+        let path = path.as_ref();
+        let language = language_key(path);
+        let dir = match path.parent() {
+            Some(dir) if !dir.as_os_str().is_empty() => dir,
+            _ => Path::new("."),
+        };
+
+        let mut siblings = Vec::new();
+        for entry in fs::read_dir(dir)? {
+            let sibling = entry?.path();
+            let is_self = sibling.file_name() == path.file_name();
+            if is_self || !sibling.is_file() || language_key(&sibling) != language {
+                continue;
+            }
+            siblings.push(sibling);
+        }
+        siblings.sort();
+
+        let mut files = Vec::new();
+        for sibling in siblings {
+            files.push(PackageFile {
+                source_code: fs::read_to_string(&sibling)?,
+                path: sibling,
+            });
+        }
+        let module = match language {
+            Some(key) if key == SupportedLanguage::Go.config_key() => Module::find(dir)?,
+            _ => None,
+        };
+        Ok(Package {
+            path: path.to_path_buf(),
+            files,
+            module,
+        })
+    }
+
+    /// Drops the siblings that `context` doesn't compile, such as
+    /// `store_windows.go` when building for Linux.
+    pub fn restrict_to(&mut self, context: &BuildContext) {
+        self.files
+            .retain(|file| context.includes(&file.path, &file.source_code));
+    }
+
+    /// The import path of the package, when it belongs to a module.
+    pub fn import_path(&self) -> Option<String> {
+        self.module.as_ref()?.package_path(self.dir())
+    }
+
+    /// The call graph of the package's module; see [`crate::callgraph`].
+    pub fn call_graph(&self) -> Option<Arc<CallGraph>> {
+        self.module.as_ref().map(CallGraph::for_module)
+    }
+
+    fn dir(&self) -> &Path {
+        match self.path.parent() {
+            Some(dir) if !dir.as_os_str().is_empty() => dir,
+            _ => Path::new("."),
+        }
+    }
+
+    /// Every sibling's path and contents, and the `go.mod` that pins the
+    /// dependencies, for cache keys.
+    pub fn contents(&self) -> Vec<String> {
+        let mut contents: Vec<String> = self
+            .files
+            .iter()
+            .map(|file| format!("{}\n{}", file.path.display(), file.source_code))
+            .collect();
+        if let Some(module) = &self.module {
+            contents.push(module.go_mod.clone());
+        }
+        contents
+    }
+}
+
+fn language_key(path: &Path) -> Option<&'static str> {
+    SupportedLanguage::from_path(&path.to_string_lossy()).map(|language| language.config_key())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_load_reads_siblings_in_the_same_language() {
+        let dir = std::env::temp_dir().join(format!("compass-package-{}", std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        fs::create_dir_all(dir.join("sub")).unwrap();
+        fs::write(dir.join("a.go"), "package a\n").unwrap();
+        fs::write(dir.join("a_test.go"), "package a\n").unwrap();
+        fs::write(dir.join("b.go"), "package a\n").unwrap();
+        fs::write(dir.join("notes.rs"), "fn main() {}\n").unwrap();
+        fs::write(dir.join("sub/c.go"), "package sub\n").unwrap();
+
+        let package = Package::load(dir.join("a.go")).unwrap();
+        let names: Vec<_> = package
+            .files
+            .iter()
+            .map(|file| {
+                file.path
+                    .file_name()
+                    .unwrap()
+                    .to_string_lossy()
+                    .into_owned()
+            })
+            .collect();
+        assert_eq!(names, ["a_test.go", "b.go"]);
+        fs::remove_dir_all(&dir).unwrap();
+    }
+}
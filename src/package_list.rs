@@ -0,0 +1,251 @@
+//! Packages listed by a build system, for `--package-list-from-file`.
+//!
+//! Bazel and Please know each target's sources, import path, build tags and
+//! platform before anything runs. Given them in a file, compass analyzes
+//! exactly those files, taking each package's other sources as its
+//! siblings, instead of reading directories and walking up to a `go.mod`.
+//! The sandbox of a build action only holds declared inputs, and nothing
+//! outside the list is read but the rule configs, so the analysis is as
+//! hermetic as the action:
+//!
+//! ```json
+//! {
+//!   "packages": [
+//!     {
+//!       "importpath": "example.com/shop/cart",
+//!       "srcs": ["cart/cart.go", "cart/cart_test.go"],
+//!       "tags": ["integration"],
+//!       "goos": "linux",
+//!       "goarch": "amd64",
+//!       "go_version": "1.22"
+//!     }
+//!   ]
+//! }
+//! ```
+//!
+//! Only `srcs` is required. Paths are relative to the working directory,
+//! which for a build action is the execution root.
+
+use crate::build::{BuildContext, Platform};
+use crate::language::SupportedLanguage;
+use crate::module::Module;
+use crate::package::{Package, PackageFile};
+use serde::Deserialize;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+
+#[derive(Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct PackageList {
+    pub packages: Vec<ListedPackage>,
+}
+
+#[derive(Debug, Default, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct ListedPackage {
+    /// The package's import path. Without it, and without `go_mod`, the
+    /// package has no module.
+    pub importpath: Option<String>,
+    /// The files of the package, in any of compass's languages.
+    pub srcs: Vec<PathBuf>,
+    /// The build tags the package is compiled with.
+    #[serde(default)]
+    pub tags: Vec<String>,
+    /// The platform it's compiled for. Either defaults to the host's.
+    pub goos: Option<String>,
+    pub goarch: Option<String>,
+    /// The Go language version, as in a `go` directive, such as `1.22`,
+    /// when the package has a module.
+    pub go_version: Option<String>,
+    /// A `go.mod` to read requirements from, when the build has one.
+    pub go_mod: Option<PathBuf>,
+}
+
+impl PackageList {
+    pub fn from_file<P: AsRef<Path>>(path: P) -> Result<Self, String> {
+        let path = path.as_ref();
+        let content = fs::read_to_string(path)
+            .map_err(|e| format!("failed to read '{}': {}", path.display(), e))?;
+        let list: PackageList = serde_json::from_str(&content)
+            .map_err(|e| format!("'{}' is not a package list: {}", path.display(), e))?;
+        for package in &list.packages {
+            package
+                .build()
+                .map_err(|e| format!("'{}': {}", path.display(), e))?;
+        }
+        Ok(list)
+    }
+
+    /// Every listed file compass can analyze, with its package.
+    pub fn files(&self) -> Vec<(String, &ListedPackage)> {
+        self.packages
+            .iter()
+            .flat_map(|package| {
+                package
+                    .srcs
+                    .iter()
+                    .map(|src| src.to_string_lossy().into_owned())
+                    .filter(|src| SupportedLanguage::from_path(src).is_some())
+                    .map(move |src| (src, package))
+            })
+            .collect()
+    }
+}
+
+impl ListedPackage {
+    /// The build the package is compiled in, when the list gives its tags
+    /// or platform. Without them every file is analyzed.
+    pub fn build(&self) -> Result<Option<BuildContext>, String> {
+        if self.tags.is_empty() && self.goos.is_none() && self.goarch.is_none() {
+            return Ok(None);
+        }
+        let host = Platform::host();
+        let platform = Platform::parse(&format!(
+            "{}/{}",
+            self.goos.as_deref().unwrap_or(&host.goos),
+            self.goarch.as_deref().unwrap_or(&host.goarch)
+        ))?;
+        Ok(Some(BuildContext {
+            platform,
+            tags: self.tags.clone(),
+        }))
+    }
+
+    /// The package of `path`, one of `srcs`: the other sources in its
+    /// language, read from disk, and its module.
+    pub fn package(&self, path: &Path) -> io::Result<Package> {
+        let language = SupportedLanguage::from_path(&path.to_string_lossy());
+        let key =
+            |language: Option<SupportedLanguage>| language.map(|language| language.config_key());
+        let mut siblings: Vec<&PathBuf> = self
+            .srcs
+            .iter()
+            .filter(|src| src.as_path() != path)
+            .filter(|src| {
+                key(SupportedLanguage::from_path(&src.to_string_lossy())) == key(language)
+            })
+            .collect();
+        siblings.sort();
+        siblings.dedup();
+
+        let mut files = Vec::new();
+        for sibling in siblings {
+            files.push(PackageFile {
+                source_code: fs::read_to_string(sibling)?,
+                path: sibling.clone(),
+            });
+        }
+        let module = match language {
+            Some(SupportedLanguage::Go) => self.module(path)?,
+            _ => None,
+        };
+        Ok(Package {
+            path: path.to_path_buf(),
+            files,
+            module,
+        })
+    }
+
+    /// A module rooted at the package's directory whose path is the import
+    /// path, so the package's import path is the listed one wherever the
+    /// build put its files, with `go_mod`'s requirements. The module cache
+    /// is left out, as it isn't an input of the build.
+    fn module(&self, path: &Path) -> io::Result<Option<Module>> {
+        let dir = match path.parent() {
+            Some(dir) if !dir.as_os_str().is_empty() => dir,
+            _ => Path::new("."),
+        };
+        let mut module = match &self.go_mod {
+            Some(go_mod) => {
+                let root = match go_mod.parent() {
+                    Some(root) if !root.as_os_str().is_empty() => root,
+                    _ => Path::new("."),
+                };
+                Module::parse(root.canonicalize()?, fs::read_to_string(go_mod)?)
+            }
+            None if self.importpath.is_some() => Module::parse(dir.canonicalize()?, String::new()),
+            None => return Ok(None),
+        };
+        if let Some(importpath) = &self.importpath {
+            module.root = dir.canonicalize()?;
+            module.path = importpath.clone();
+        }
+        if self.go_version.is_some() {
+            module.go = self.go_version.clone();
+        }
+        Ok(Some(module.without_module_cache()))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_listed_package_reads_only_its_sources() {
+        let dir = std::env::temp_dir().join(format!("compass-package-list-{}", std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        fs::create_dir_all(&dir).unwrap();
+        fs::write(dir.join("a.go"), "package cart\n").unwrap();
+        fs::write(dir.join("b.go"), "package cart\n").unwrap();
+        // In the directory but another target's
+        fs::write(dir.join("gen.go"), "package cart\n").unwrap();
+        let list = dir.join("packages.json");
+        fs::write(
+            &list,
+            serde_json::json!({
+                "packages": [{
+                    "importpath": "example.com/shop/cart",
+                    "srcs": [dir.join("a.go"), dir.join("b.go"), dir.join("README.md")],
+                    "go_version": "1.22",
+                }]
+            })
+            .to_string(),
+        )
+        .unwrap();
+
+        let list = PackageList::from_file(&list).unwrap();
+        let files = list.files();
+        assert_eq!(files.len(), 2, "README.md isn't analyzed");
+        let package = files[0].1.package(&dir.join("a.go")).unwrap();
+        let siblings: Vec<_> = package.files.iter().map(|file| file.path.clone()).collect();
+        assert_eq!(siblings, [dir.join("b.go")]);
+        assert_eq!(
+            package.import_path().as_deref(),
+            Some("example.com/shop/cart")
+        );
+        assert_eq!(
+            package
+                .module
+                .as_ref()
+                .and_then(|module| module.go.as_deref()),
+            Some("1.22")
+        );
+        assert!(files[0].1.build().unwrap().is_none());
+
+        let _ = fs::remove_dir_all(&dir);
+    }
+
+    #[test]
+    fn test_listed_platform_is_checked() {
+        let package = ListedPackage {
+            goos: Some("plan10".to_string()),
+            ..ListedPackage::default()
+        };
+        assert!(package
+            .build()
+            .unwrap_err()
+            .contains("unknown GOOS 'plan10'"));
+
+        let package = ListedPackage {
+            tags: vec!["integration".to_string()],
+            goos: Some("linux".to_string()),
+            goarch: Some("arm64".to_string()),
+            ..ListedPackage::default()
+        };
+        let build = package.build().unwrap().unwrap();
+        assert_eq!(build.platform.to_string(), "linux/arm64");
+        assert!(build.satisfies("integration"));
+    }
+}
@@ -0,0 +1,161 @@
+//! A bounded worker pool for analyzing independent files.
+
+use std::collections::BTreeMap;
+use std::sync::{Condvar, Mutex};
+use std::thread;
+
+/// The default pool size: one worker per available CPU.
+pub fn default_jobs() -> usize {
+    thread::available_parallelism().map_or(1, |n| n.get())
+}
+
+/// Applies `f` to every item on up to `jobs` threads and returns the results
+/// in the order of `items`, however the work was scheduled.
+pub fn map_ordered<T, R, F>(items: &[T], jobs: usize, f: F) -> Vec<R>
+where
+    T: Sync,
+    R: Send,
+    F: Fn(&T) -> R + Sync,
+{
+    let mut results = Vec::with_capacity(items.len());
+    for_each_ordered(items, jobs, f, |result| results.push(result));
+    results
+}
+
+/// Applies `f` to every item on up to `jobs` threads and hands each result
+/// to `sink` on the calling thread, in the order of `items`, as soon as it
+/// and every result before it are done. Workers stay at most a few items
+/// ahead of the one `sink` waits for, so a run holds that many results
+/// however many items there are.
+pub fn for_each_ordered<T, R, F, S>(items: &[T], jobs: usize, f: F, mut sink: S)
+where
+    T: Sync,
+    R: Send,
+    F: Fn(&T) -> R + Sync,
+    S: FnMut(R),
+{
+    let workers = jobs.clamp(1, items.len().max(1));
+    if workers == 1 {
+        items.iter().map(f).for_each(sink);
+        return;
+    }
+
+    let window = workers * 4;
+    let shared = Mutex::new(Window {
+        next: 0,
+        delivered: 0,
+        done: BTreeMap::new(),
+        panicked: false,
+    });
+    let changed = Condvar::new();
+    thread::scope(|scope| {
+        for _ in 0..workers {
+            scope.spawn(|| loop {
+                let index = {
+                    let mut window_state = shared.lock().unwrap();
+                    while !window_state.panicked
+                        && window_state.next < items.len()
+                        && window_state.next >= window_state.delivered + window
+                    {
+                        window_state = changed.wait(window_state).unwrap();
+                    }
+                    if window_state.panicked {
+                        break;
+                    }
+                    window_state.next += 1;
+                    window_state.next - 1
+                };
+                let Some(item) = items.get(index) else {
+                    break;
+                };
+                let _guard = PanicGuard(&shared, &changed);
+                let result = f(item);
+                shared.lock().unwrap().done.insert(index, result);
+                changed.notify_all();
+            });
+        }
+
+        for index in 0..items.len() {
+            let result = {
+                let mut window_state = shared.lock().unwrap();
+                loop {
+                    if let Some(result) = window_state.done.remove(&index) {
+                        window_state.delivered += 1;
+                        break result;
+                    }
+                    // The scope passes the worker's panic on once it ends.
+                    if window_state.panicked {
+                        return;
+                    }
+                    window_state = changed.wait(window_state).unwrap();
+                }
+            };
+            changed.notify_all();
+            // The lock is released, so the workers go on while `sink` runs.
+            sink(result);
+        }
+    });
+}
+
+/// The items taken and handed on so far, and the results waiting for
+/// their turn.
+struct Window<R> {
+    next: usize,
+    delivered: usize,
+    done: BTreeMap<usize, R>,
+    panicked: bool,
+}
+
+/// Wakes the calling thread when a worker panics, since the result it
+/// waits for may never come.
+struct PanicGuard<'a, R>(&'a Mutex<Window<R>>, &'a Condvar);
+
+impl<R> Drop for PanicGuard<'_, R> {
+    fn drop(&mut self) {
+        if thread::panicking() {
+            if let Ok(mut window) = self.0.lock() {
+                window.panicked = true;
+            }
+            self.1.notify_all();
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::atomic::{AtomicUsize, Ordering};
+
+    #[test]
+    fn test_results_keep_input_order() {
+        let items: Vec<u64> = (0..100).collect();
+        let squares = map_ordered(&items, 8, |n| {
+            // Uneven work so items finish out of order.
+            thread::sleep(std::time::Duration::from_micros((100 - n) * 10));
+            n * n
+        });
+        assert_eq!(squares, items.iter().map(|n| n * n).collect::<Vec<_>>());
+        assert!(map_ordered(&Vec::<u64>::new(), 4, |n| *n).is_empty());
+    }
+
+    #[test]
+    fn test_workers_stay_within_the_window() {
+        let items: Vec<usize> = (0..200).collect();
+        let started = AtomicUsize::new(0);
+        let mut seen = Vec::new();
+        for_each_ordered(
+            &items,
+            4,
+            |n| {
+                started.fetch_add(1, Ordering::SeqCst);
+                *n
+            },
+            |n| {
+                // Workers can be at most a window of 16 past what was handed on.
+                assert!(started.load(Ordering::SeqCst) <= n + 1 + 16);
+                seen.push(n);
+            },
+        );
+        assert_eq!(seen, items);
+    }
+}
@@ -0,0 +1,66 @@
+//! Extension points for custom rules.
+//!
+//! Teams with rules that don't fit a tree-sitter query implement [`Check`],
+//! register it under a name, and build their own binary around
+//! [`crate::cli::run_with`]:
+//!
+//! ```no_run
+//! use compass::plugin::{visit, Check, Hit, Registry, RuleOptions};
+//! use tree_sitter::Node;
+//!
+//! struct NoGoto;
+//!
+//! impl Check for NoGoto {
+//!     fn run<'t>(&self, root: Node<'t>, _source_code: &str, _options: &RuleOptions) -> Vec<Hit<'t>> {
+//!         let mut hits = Vec::new();
+//!         visit(root, &mut |node| {
+//!             if node.kind() == "goto_statement" {
+//!                 hits.push(Hit::new(node));
+//!             }
+//!         });
+//!         hits
+//!     }
+//! }
+//!
+//! fn main() {
+//!     let mut registry = Registry::new();
+//!     registry.register("mycorp_no_goto", NoGoto);
+//!     compass::cli::run_with(registry);
+//! }
+//! ```
+//!
+//! A config then enables it like any other rule with `check = "mycorp_no_goto"`,
+//! and anything under the rule's `[rules.options]` table reaches the check as
+//! [`RuleOptions`].
+
+pub use crate::analyzer::RelatedLocation;
+pub use crate::checks::{line_extent, node_text, visit, Check, Granularity, Hit, RuleOptions};
+pub use crate::fix::{Fix, TextEdit};
+
+use crate::checks;
+use std::collections::HashMap;
+use std::sync::Arc;
+
+/// Named checks available to `check = "..."` rules, on top of the built-ins.
+#[derive(Clone, Default)]
+pub struct Registry {
+    checks: HashMap<String, Arc<dyn Check>>,
+}
+
+impl Registry {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Registers `check` under `name`, replacing a built-in of the same name.
+    pub fn register(&mut self, name: &str, check: impl Check + 'static) {
+        self.checks.insert(name.to_string(), Arc::new(check));
+    }
+
+    pub fn get(&self, name: &str) -> Option<Arc<dyn Check>> {
+        self.checks
+            .get(name)
+            .cloned()
+            .or_else(|| checks::builtin(name))
+    }
+}
@@ -0,0 +1,371 @@
+//! Organization policies as assertions over the module's call graph, for
+//! `compass policy` and the `go_policy` check.
+//!
+//! An assertion is a sentence: `no` or `every`, the functions it's about,
+//! `calls` or `directly calls`, and the functions they must or mustn't
+//! call, joined by `or`:
+//!
+//! ```text
+//! no function outside ./internal/auth/... directly calls ./internal/auth/private/...
+//! every handler in ./api/... calls ./internal/middleware.Authn
+//! every exported method in ./store named Save* calls ./internal/audit.Record or ./internal/audit.Skip
+//! ```
+//!
+//! The functions are `functions`, `methods`, `handlers` (those taking
+//! `(http.ResponseWriter, *http.Request)` as written) or `packages` (any
+//! function), narrowed by `in PATTERN`, `outside PATTERN`, `named NAME`,
+//! `exported` and `taking (TYPE, ...)`. Patterns are package patterns as in
+//! `go_import_policy`, and a target may end in `.Func` or `.Type.Method`
+//! after its last path element; a name ending in `*` is a prefix.
+//!
+//! `calls` follows chains of calls through the module, `directly calls`
+//! only the function's own. A `no` assertion ignores method calls the graph
+//! resolved by name alone, so it only reports calls that happen; an `every`
+//! assertion takes them, so it only reports functions that can't reach a
+//! target. Calls outside the module aren't in the graph, so targets must be
+//! in it.
+
+use crate::callgraph::CallGraph;
+use crate::checks::{matches_name, Pattern};
+
+#[derive(Debug, Clone)]
+pub struct Assertion {
+    /// The assertion as written.
+    pub text: String,
+    quantifier: Quantifier,
+    kind: Kind,
+    filters: Vec<Filter>,
+    direct: bool,
+    targets: Vec<Target>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Quantifier {
+    No,
+    Every,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Kind {
+    Function,
+    Method,
+    Handler,
+}
+
+#[derive(Debug, Clone)]
+enum Filter {
+    In(Pattern),
+    Outside(Pattern),
+    Named(String),
+    Exported,
+    Taking(Vec<String>),
+}
+
+#[derive(Debug, Clone)]
+struct Target {
+    text: String,
+    package: Pattern,
+    /// `Func` or `Type.Method`, or `None` for any function of the package.
+    name: Option<String>,
+}
+
+/// A function that breaks an assertion.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Violation {
+    pub function: usize,
+    /// For `no`, the calls from the function to the target it mustn't
+    /// call, starting with the function. Empty for `every`.
+    pub chain: Vec<usize>,
+}
+
+const HANDLER_PARAMETERS: &[&str] = &["http.ResponseWriter", "*http.Request"];
+
+impl Assertion {
+    pub fn parse(text: &str) -> Result<Assertion, String> {
+        let text = text.trim().to_string();
+        let mut words = text.split_whitespace();
+        let quantifier = match words.next().map(str::to_lowercase).as_deref() {
+            Some("no") => Quantifier::No,
+            Some("every" | "all" | "each") => Quantifier::Every,
+            _ => return Err("an assertion starts with `no` or `every`".to_string()),
+        };
+
+        let mut filters = Vec::new();
+        let mut kind = None;
+        while kind.is_none() {
+            let word = words.next().unwrap_or_default().to_lowercase();
+            match word.strip_suffix('s').unwrap_or(&word) {
+                "exported" => filters.push(Filter::Exported),
+                "http" => {}
+                "function" | "package" => kind = Some(Kind::Function),
+                "method" => kind = Some(Kind::Method),
+                "handler" => kind = Some(Kind::Handler),
+                _ => {
+                    return Err(format!(
+                        "expected `functions`, `methods`, `handlers` or `packages`, not `{}`",
+                        word
+                    ))
+                }
+            }
+        }
+
+        let mut direct = false;
+        loop {
+            let Some(word) = words.next() else {
+                return Err("an assertion needs `calls` and what is called".to_string());
+            };
+            let mut argument = |filter: &str| {
+                words
+                    .next()
+                    .ok_or(format!("`{}` needs an argument", filter))
+            };
+            match word.to_lowercase().as_str() {
+                "in" => filters.push(Filter::In(Pattern::compile(argument("in")?)?)),
+                "outside" => filters.push(Filter::Outside(Pattern::compile(argument("outside")?)?)),
+                "named" => filters.push(Filter::Named(argument("named")?.to_string())),
+                "exported" => filters.push(Filter::Exported),
+                "taking" => {
+                    let mut list = String::new();
+                    while !list.ends_with(')') {
+                        let Some(word) = words.next() else {
+                            return Err("`taking` needs a list of types, e.g. `taking (context.Context, string)`".to_string());
+                        };
+                        if !list.is_empty() {
+                            list.push(' ');
+                        }
+                        list.push_str(word);
+                    }
+                    let Some(list) = list.strip_prefix('(').and_then(|l| l.strip_suffix(')'))
+                    else {
+                        return Err(format!(
+                            "`taking` needs a parenthesized list of types, not `{}`",
+                            list
+                        ));
+                    };
+                    let types = list
+                        .split(',')
+                        .map(str::trim)
+                        .filter(|ty| !ty.is_empty())
+                        .map(str::to_string)
+                        .collect();
+                    filters.push(Filter::Taking(types));
+                }
+                "directly" => direct = true,
+                "may" | "must" | "can" => {}
+                "calls" | "call" => break,
+                other => return Err(format!("unexpected `{}` before `calls`", other)),
+            }
+        }
+
+        let mut targets = Vec::new();
+        loop {
+            let Some(word) = words.next() else {
+                return Err(match targets.is_empty() {
+                    true => "an assertion needs a package or function after `calls`".to_string(),
+                    false => "`or` needs a package or function after it".to_string(),
+                });
+            };
+            targets.push(Target::parse(word)?);
+            match words.next() {
+                None => break,
+                Some(word) if word.eq_ignore_ascii_case("or") => {}
+                Some(word) => {
+                    return Err(format!(
+                        "expected `or` after `{}`, not `{}`",
+                        targets.last().map_or("", |t| &t.text),
+                        word
+                    ))
+                }
+            }
+        }
+
+        Ok(Assertion {
+            text,
+            quantifier,
+            kind: kind.unwrap_or(Kind::Function),
+            filters,
+            direct,
+            targets,
+        })
+    }
+
+    /// The functions of `graph` that break the assertion, where `module` is
+    /// the path relative patterns are in.
+    pub fn violations(&self, graph: &CallGraph, module: &str) -> Vec<Violation> {
+        let is_target = |function: usize| {
+            self.targets
+                .iter()
+                .any(|target| target.matches(graph, function, module))
+        };
+        let max_depth = self.direct.then_some(1);
+        let mut violations = Vec::new();
+        for function in 0..graph.functions.len() {
+            if !self.is_subject(graph, function, module) || is_target(function) {
+                continue;
+            }
+            let reaches = |follow: &dyn Fn(bool) -> bool| {
+                graph.path(
+                    function,
+                    max_depth,
+                    |call| follow(call.dynamic),
+                    |callee| callee != function && is_target(callee),
+                )
+            };
+            match self.quantifier {
+                Quantifier::No => {
+                    if let Some(chain) = reaches(&|dynamic| !dynamic) {
+                        violations.push(Violation { function, chain });
+                    }
+                }
+                Quantifier::Every => {
+                    if reaches(&|_| true).is_none() {
+                        violations.push(Violation {
+                            function,
+                            chain: Vec::new(),
+                        });
+                    }
+                }
+            }
+        }
+        violations
+    }
+
+    /// What `violation` does wrong, naming functions with `label`.
+    pub fn describe(&self, violation: &Violation, label: impl Fn(usize) -> String) -> String {
+        let function = label(violation.function);
+        match self.quantifier {
+            Quantifier::No => {
+                let called = violation
+                    .chain
+                    .last()
+                    .map(|&f| label(f))
+                    .unwrap_or_default();
+                let through = match violation.chain.len() {
+                    0..=2 => String::new(),
+                    n => format!(
+                        " through {}",
+                        violation.chain[1..n - 1]
+                            .iter()
+                            .map(|&f| format!("`{}`", label(f)))
+                            .collect::<Vec<_>>()
+                            .join(" → ")
+                    ),
+                };
+                format!(
+                    "`{}` calls `{}`{}, which the policy `{}` forbids",
+                    function, called, through, self.text
+                )
+            }
+            Quantifier::Every => {
+                let targets = self
+                    .targets
+                    .iter()
+                    .map(|target| format!("`{}`", target.text))
+                    .collect::<Vec<_>>()
+                    .join(" or ");
+                let call = if self.direct { "directly call" } else { "call" };
+                format!(
+                    "`{}` doesn't {} {}, which the policy `{}` requires",
+                    function, call, targets, self.text
+                )
+            }
+        }
+    }
+
+    fn is_subject(&self, graph: &CallGraph, function: usize, module: &str) -> bool {
+        let function = &graph.functions[function];
+        let kind = match self.kind {
+            Kind::Function => true,
+            Kind::Method => function.receiver.is_some(),
+            Kind::Handler => function.parameters == HANDLER_PARAMETERS,
+        };
+        kind && self.filters.iter().all(|filter| match filter {
+            Filter::In(pattern) => pattern.matches(&function.package, module),
+            Filter::Outside(pattern) => !pattern.matches(&function.package, module),
+            Filter::Named(name) => matches_name(&function.name, name),
+            Filter::Exported => function.is_exported(),
+            Filter::Taking(types) => function.parameters == *types,
+        })
+    }
+}
+
+impl Target {
+    /// Splits `./internal/auth.Session.Valid` into the package and the name
+    /// after the first `.` of its last path element.
+    fn parse(text: &str) -> Result<Target, String> {
+        let start = text.rfind('/').map_or(0, |slash| slash + 1);
+        let dot = text[start..]
+            .find('.')
+            .map(|dot| start + dot)
+            .filter(|&dot| !text[dot..].starts_with("..."));
+        let (package, name) = match dot {
+            Some(dot) if dot > start => (&text[..dot], Some(&text[dot + 1..])),
+            _ => (text, None),
+        };
+        if name.is_some_and(|name| name.is_empty() || name.split('.').count() > 2) {
+            return Err(format!(
+                "\"{}\" is not a package, function or method, e.g. \"./internal/auth\", \"./internal/auth.Login\" or \"./internal/auth.Session.Valid\"",
+                text
+            ));
+        }
+        Ok(Target {
+            text: text.to_string(),
+            package: Pattern::compile(package)?,
+            name: name.map(str::to_string),
+        })
+    }
+
+    fn matches(&self, graph: &CallGraph, function: usize, module: &str) -> bool {
+        let function = &graph.functions[function];
+        if !self.package.matches(&function.package, module) {
+            return false;
+        }
+        let Some(name) = &self.name else {
+            return true;
+        };
+        match (name.split_once('.'), &function.receiver) {
+            (Some((ty, method)), Some(receiver)) => {
+                matches_name(receiver, ty) && matches_name(&function.name, method)
+            }
+            (None, None) => matches_name(&function.name, name),
+            _ => false,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_assertions() {
+        let assertion = Assertion::parse(
+            "every exported handler in ./api/... taking (http.ResponseWriter, *http.Request) must directly call ./internal/middleware.Authn or ./internal/middleware.Session.*",
+        )
+        .unwrap();
+        assert_eq!(assertion.quantifier, Quantifier::Every);
+        assert_eq!(assertion.kind, Kind::Handler);
+        assert!(assertion.direct);
+        assert_eq!(assertion.filters.len(), 3);
+        assert!(
+            matches!(&assertion.filters[2], Filter::Taking(types) if types == HANDLER_PARAMETERS)
+        );
+        assert_eq!(assertion.targets[0].package.text, "./internal/middleware");
+        assert_eq!(assertion.targets[1].name.as_deref(), Some("Session.*"));
+
+        let target = Target::parse("example.com/app/internal/...").unwrap();
+        assert_eq!(target.package.text, "example.com/app/internal/...");
+        assert_eq!(target.name, None);
+
+        for wrong in [
+            "some functions call fmt",
+            "no function calls",
+            "no function in calls fmt",
+            "no function calls fmt and log",
+            "no goroutine calls fmt",
+            "no function calls ./a.B.C.D",
+        ] {
+            assert!(Assertion::parse(wrong).is_err(), "{}", wrong);
+        }
+    }
+}
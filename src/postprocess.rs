@@ -0,0 +1,415 @@
+//! What happens to findings between analysis and output.
+//!
+//! The same file can be reached twice, through a symlink or a `go.work`
+//! module that is also under the analyzed directory, and a query with
+//! overlapping patterns can match one node twice; [`dedup`] and
+//! [`distinct_paths`] keep the first copy. [`Limits`] caps how many
+//! findings of one rule, or with one message, are shown, so a noisy rule
+//! doesn't bury the rest. Scores are computed before the caps, so hiding
+//! findings doesn't improve them. With `--platforms`, [`merge_platforms`]
+//! folds the findings of each build of a file into one list.
+
+use crate::analyzer::{AnalysisResult, Severity};
+use crate::format::FileFindings;
+use serde::Serialize;
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::path::Path;
+
+/// `--max-issues-per-rule` and `--max-same-issues`. `None` is unlimited.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct Limits {
+    /// Findings of one rule across the run.
+    pub per_rule: Option<usize>,
+    /// Findings of one rule with the same message across the run.
+    pub same: Option<usize>,
+}
+
+/// Drops findings of the same rule at the same place with the same message,
+/// keeping the first.
+pub fn dedup(results: &mut Vec<AnalysisResult>) {
+    let mut seen = HashSet::new();
+    results.retain(|result| {
+        seen.insert((
+            result.rule_name.clone(),
+            result.start_byte,
+            result.end_byte,
+            result.message.clone(),
+        ))
+    });
+}
+
+/// The findings of one file analyzed once per platform, tagged with the
+/// platforms each occurs on. A finding that is the same on several is kept
+/// once, in the place of its first copy.
+pub fn merge_platforms(runs: Vec<(String, Vec<AnalysisResult>)>) -> Vec<AnalysisResult> {
+    let mut merged: Vec<AnalysisResult> = Vec::new();
+    let mut positions: HashMap<(String, usize, usize, String), usize> = HashMap::new();
+    for (platform, results) in runs {
+        for mut result in results {
+            let key = (
+                result.rule_name.clone(),
+                result.start_byte,
+                result.end_byte,
+                result.message.clone(),
+            );
+            match positions.get(&key) {
+                Some(&position) => {
+                    let platforms = &mut merged[position].platforms;
+                    if !platforms.contains(&platform) {
+                        platforms.push(platform.clone());
+                    }
+                }
+                None => {
+                    positions.insert(key, merged.len());
+                    result.platforms = vec![platform.clone()];
+                    merged.push(result);
+                }
+            }
+        }
+    }
+    merged
+}
+
+/// Drops entries whose path names a file already in the list, keeping the
+/// first. Paths that can't be resolved are kept as they are.
+pub fn distinct_paths<T>(entries: Vec<(String, T)>) -> Vec<(String, T)> {
+    let mut seen = HashSet::new();
+    entries
+        .into_iter()
+        .filter(|(path, _)| {
+            let resolved = Path::new(path)
+                .canonicalize()
+                .unwrap_or_else(|_| path.into());
+            seen.insert(resolved)
+        })
+        .collect()
+}
+
+impl Limits {
+    pub fn is_unlimited(&self) -> bool {
+        self.per_rule.is_none() && self.same.is_none()
+    }
+
+    /// Drops the findings beyond the limits, taking files and their findings
+    /// in order. Returns how many each rule lost.
+    pub fn apply(&self, files: &mut [FileFindings]) -> BTreeMap<String, usize> {
+        let mut limiter = self.limiter();
+        for file in files {
+            limiter.apply(file);
+        }
+        limiter.omitted
+    }
+
+    /// Applies the limits a file at a time, for a run that reports files as
+    /// they are analyzed.
+    pub fn limiter(&self) -> Limiter {
+        Limiter {
+            limits: *self,
+            per_rule: HashMap::new(),
+            same: HashMap::new(),
+            omitted: BTreeMap::new(),
+        }
+    }
+}
+
+/// The counts [`Limits`] are checked against, so far in a run.
+#[derive(Debug)]
+pub struct Limiter {
+    limits: Limits,
+    per_rule: HashMap<String, usize>,
+    same: HashMap<(String, String), usize>,
+    /// How many findings each rule lost so far.
+    pub omitted: BTreeMap<String, usize>,
+}
+
+impl Limiter {
+    /// Drops the findings of `file` beyond the limits, counting those of
+    /// every file before it.
+    pub fn apply(&mut self, file: &mut FileFindings) {
+        if self.limits.is_unlimited() {
+            return;
+        }
+        file.results.retain(|result| {
+            let rule_count = self.per_rule.entry(result.rule_name.clone()).or_default();
+            let same_count = self
+                .same
+                .entry((result.rule_name.clone(), result.message.clone()))
+                .or_default();
+            let shown = self.limits.per_rule.is_none_or(|max| *rule_count < max)
+                && self.limits.same.is_none_or(|max| *same_count < max);
+            if shown {
+                *rule_count += 1;
+                *same_count += 1;
+            } else {
+                *self.omitted.entry(result.rule_name.clone()).or_default() += 1;
+            }
+            shown
+        });
+    }
+}
+
+/// The findings of one rule, for `--group-by rule`.
+#[derive(Debug, Serialize)]
+pub struct RuleGroup {
+    pub rule: String,
+    pub severity: String,
+    pub count: usize,
+    pub issues: Vec<GroupedIssue>,
+}
+
+#[derive(Debug, Serialize)]
+pub struct GroupedIssue {
+    pub file: String,
+    pub line: usize,
+    pub column: usize,
+    pub message: String,
+    pub confidence: &'static str,
+    pub suggestion: Option<String>,
+}
+
+/// How `--group-by` arranges the score report.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum Grouping {
+    /// Each file with its findings.
+    #[default]
+    File,
+    /// See [`group_by_rule`].
+    Rule,
+    /// See [`group_by_owner`].
+    Owner,
+}
+
+impl Grouping {
+    pub fn from_name(name: &str) -> Option<Self> {
+        match name {
+            "file" => Some(Grouping::File),
+            "rule" => Some(Grouping::Rule),
+            "owner" => Some(Grouping::Owner),
+            _ => None,
+        }
+    }
+}
+
+/// The findings of the files one owner owns, for `--group-by owner`.
+#[derive(Debug, Serialize)]
+pub struct OwnerGroup {
+    /// `None` for the files `CODEOWNERS` gives nobody.
+    pub owner: Option<String>,
+    pub files: usize,
+    pub count: usize,
+    pub rules: Vec<RuleGroup>,
+}
+
+/// The findings of `files` by owner, and each owner's by rule. A file with
+/// several owners is in each of their groups. Owners with most findings
+/// come first, and the unowned files last.
+pub fn group_by_owner(files: &[FileFindings]) -> Vec<OwnerGroup> {
+    let mut owned: BTreeMap<Option<&str>, Vec<&FileFindings>> = BTreeMap::new();
+    for file in files.iter().filter(|file| !file.results.is_empty()) {
+        if file.owners.is_empty() {
+            owned.entry(None).or_default().push(file);
+        }
+        for owner in &file.owners {
+            owned.entry(Some(owner.as_str())).or_default().push(file);
+        }
+    }
+    let mut groups: Vec<OwnerGroup> = owned
+        .into_iter()
+        .map(|(owner, files)| OwnerGroup {
+            owner: owner.map(str::to_string),
+            files: files.len(),
+            count: files.iter().map(|file| file.results.len()).sum(),
+            rules: group_by_rule(files),
+        })
+        .collect();
+    groups.sort_by(|a, b| {
+        a.owner
+            .is_none()
+            .cmp(&b.owner.is_none())
+            .then(b.count.cmp(&a.count))
+    });
+    groups
+}
+
+/// The findings of `files` by rule: the most severe rules first, then the
+/// ones with most findings, then by name.
+pub fn group_by_rule<'a>(files: impl IntoIterator<Item = &'a FileFindings>) -> Vec<RuleGroup> {
+    let mut groups: BTreeMap<&str, (Severity, Vec<GroupedIssue>)> = BTreeMap::new();
+    for file in files {
+        for result in &file.results {
+            let (_, issues) = groups
+                .entry(&result.rule_name)
+                .or_insert_with(|| (result.severity, Vec::new()));
+            issues.push(GroupedIssue {
+                file: file.path.clone(),
+                line: result.line,
+                column: result.column,
+                message: result.message.clone(),
+                confidence: result.confidence.as_str(),
+                suggestion: result.suggestion.clone(),
+            });
+        }
+    }
+    let mut groups: Vec<(Severity, RuleGroup)> = groups
+        .into_iter()
+        .map(|(rule, (severity, issues))| {
+            (
+                severity,
+                RuleGroup {
+                    rule: rule.to_string(),
+                    severity: format!("{:?}", severity),
+                    count: issues.len(),
+                    issues,
+                },
+            )
+        })
+        .collect();
+    groups.sort_by(|(a, a_group), (b, b_group)| {
+        b.rank()
+            .cmp(&a.rank())
+            .then(b_group.count.cmp(&a_group.count))
+    });
+    groups.into_iter().map(|(_, group)| group).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn result(rule: &str, line: usize, message: &str) -> AnalysisResult {
+        AnalysisResult {
+            rule_name: rule.to_string(),
+            severity: Severity::Warning,
+            message: message.to_string(),
+            line,
+            start_byte: line * 10,
+            end_byte: line * 10 + 5,
+            ..AnalysisResult::default()
+        }
+    }
+
+    fn file(path: &str, results: Vec<AnalysisResult>) -> FileFindings {
+        FileFindings {
+            path: path.to_string(),
+            module: None,
+            owners: Vec::new(),
+            results,
+        }
+    }
+
+    #[test]
+    fn test_dedup_keeps_the_first_copy() {
+        let mut results = vec![
+            result("panic_usage", 3, "panic"),
+            result("panic_usage", 3, "panic"),
+            result("panic_usage", 3, "other message"),
+            result("todo_comment", 3, "panic"),
+        ];
+        dedup(&mut results);
+        assert_eq!(results.len(), 3);
+    }
+
+    #[test]
+    fn test_merge_platforms_tags_each_finding() {
+        let merged = merge_platforms(vec![
+            (
+                "linux/amd64".to_string(),
+                vec![
+                    result("panic_usage", 3, "panic"),
+                    result("panic_usage", 5, "panic"),
+                ],
+            ),
+            (
+                "windows/amd64".to_string(),
+                vec![
+                    result("panic_usage", 3, "panic"),
+                    result("todo_comment", 4, "todo"),
+                ],
+            ),
+        ]);
+        let tagged: Vec<_> = merged
+            .iter()
+            .map(|r| (r.line, r.platforms.join(",")))
+            .collect();
+        assert_eq!(
+            tagged,
+            [
+                (3, "linux/amd64,windows/amd64".to_string()),
+                (5, "linux/amd64".to_string()),
+                (4, "windows/amd64".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_group_by_owner_puts_unowned_files_last() {
+        let mut payments = file(
+            "payments/charge.go",
+            vec![result("panic_usage", 3, "panic")],
+        );
+        payments.owners = vec!["@acme/payments".to_string()];
+        let mut shared = file(
+            "shared/retry.go",
+            vec![
+                result("panic_usage", 4, "panic"),
+                result("todo_comment", 8, "todo"),
+            ],
+        );
+        shared.owners = vec!["@acme/payments".to_string(), "@acme/core".to_string()];
+        let unowned = file(
+            "tools/gen.go",
+            vec![
+                result("todo_comment", 1, "todo"),
+                result("todo_comment", 2, "todo"),
+                result("todo_comment", 3, "todo"),
+            ],
+        );
+        let groups = group_by_owner(&[unowned, payments, shared]);
+        let summary: Vec<_> = groups
+            .iter()
+            .map(|group| (group.owner.as_deref(), group.files, group.count))
+            .collect();
+        assert_eq!(
+            summary,
+            [
+                (Some("@acme/payments"), 2, 3),
+                (Some("@acme/core"), 1, 2),
+                (None, 1, 3),
+            ]
+        );
+        assert_eq!(groups[0].rules[0].rule, "panic_usage");
+    }
+
+    #[test]
+    fn test_limits_count_across_files() {
+        let mut files = vec![
+            file(
+                "a.go",
+                vec![
+                    result("missing_error_check", 1, "err is never checked"),
+                    result("missing_error_check", 2, "err is never checked"),
+                    result("missing_error_check", 3, "n is never checked"),
+                ],
+            ),
+            file(
+                "b.go",
+                vec![
+                    result("missing_error_check", 1, "err is never checked"),
+                    result("panic_usage", 2, "panic"),
+                ],
+            ),
+        ];
+        let limits = Limits {
+            per_rule: Some(3),
+            same: Some(1),
+        };
+        let omitted = limits.apply(&mut files);
+        let lines = |file: &FileFindings| file.results.iter().map(|r| r.line).collect::<Vec<_>>();
+        assert_eq!(lines(&files[0]), [1, 3]);
+        assert_eq!(lines(&files[1]), [2]);
+        assert_eq!(
+            omitted,
+            BTreeMap::from([("missing_error_check".to_string(), 2)])
+        );
+    }
+}
@@ -0,0 +1,211 @@
+//! Rule presets: named starting points that turn whole groups of rules on
+//! or off, so a project gets useful results before curating each rule.
+//!
+//! A preset is chosen with `preset = "strict"` in `.compass.toml` or
+//! `--preset` on the command line, which wins over the files. It is applied
+//! to the rule config before any `[rules.*]` override, so a project can
+//! still tune single rules on top of it:
+//!
+//! - `minimal`: only the rules reporting errors (bugs, injections, leaked
+//!   secrets and retracted dependencies), and only findings of medium
+//!   confidence or higher.
+//! - `standard`: the rule config as it is; what runs without a preset.
+//! - `strict`: every rule, including those off by default, with info and
+//!   style findings raised to warnings. The vulnerability rules are left
+//!   to `--vuln`, since they need a database.
+//!
+//! `compass preset diff standard strict` lists what changes between two.
+
+use crate::analyzer::{Confidence, Severity};
+use crate::checks::VULN_CHECKS;
+use crate::config::{AnalyzerConfig, RuleConfig};
+use serde_json::{json, Value};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Preset {
+    Minimal,
+    Standard,
+    Strict,
+}
+
+/// How one rule, or the confidence threshold, differs between two presets.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Change {
+    /// The rule's name, or `min_confidence`.
+    pub name: String,
+    /// The severity a rule runs at, `off`, or a confidence.
+    pub from: String,
+    pub to: String,
+}
+
+impl Preset {
+    pub const ALL: [Preset; 3] = [Preset::Minimal, Preset::Standard, Preset::Strict];
+    pub const NAMES: &'static str = "minimal, standard, strict";
+
+    pub fn from_name(name: &str) -> Option<Self> {
+        match name.to_lowercase().as_str() {
+            "minimal" => Some(Preset::Minimal),
+            "standard" => Some(Preset::Standard),
+            "strict" => Some(Preset::Strict),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Preset::Minimal => "minimal",
+            Preset::Standard => "standard",
+            Preset::Strict => "strict",
+        }
+    }
+
+    pub fn description(&self) -> &'static str {
+        match self {
+            Preset::Minimal => "error rules only, at medium confidence or higher",
+            Preset::Standard => "the rules enabled by default",
+            Preset::Strict => "every rule, with info and style raised to warning",
+        }
+    }
+
+    pub fn apply(&self, config: &mut AnalyzerConfig) {
+        config.min_confidence = self.min_confidence(config);
+        for rule in &mut config.rules {
+            (rule.enabled, rule.severity) = self.rule(rule);
+        }
+    }
+
+    /// The confidence threshold `config` has under the preset.
+    fn min_confidence(&self, config: &AnalyzerConfig) -> Option<String> {
+        match self {
+            Preset::Minimal => Some(Confidence::Medium.as_str().to_string()),
+            Preset::Standard | Preset::Strict => config.min_confidence.clone(),
+        }
+    }
+
+    /// Whether `rule` runs under the preset, and at what severity.
+    fn rule(&self, rule: &RuleConfig) -> (bool, String) {
+        let severity = Severity::from_name(&rule.severity);
+        match self {
+            Preset::Minimal => (
+                rule.enabled && severity == Some(Severity::Error),
+                rule.severity.clone(),
+            ),
+            Preset::Standard => (rule.enabled, rule.severity.clone()),
+            Preset::Strict => {
+                let needs_db = rule
+                    .check
+                    .as_deref()
+                    .is_some_and(|check| VULN_CHECKS.contains(&check));
+                let severity = match severity {
+                    Some(Severity::Info | Severity::Style) => {
+                        Severity::Warning.as_str().to_string()
+                    }
+                    _ => rule.severity.clone(),
+                };
+                (rule.enabled || !needs_db, severity)
+            }
+        }
+    }
+}
+
+/// What changes in `config` going from the preset `from` to `to`: the
+/// confidence threshold first, then the rules in config order.
+pub fn diff(config: &AnalyzerConfig, from: Preset, to: Preset) -> Vec<Change> {
+    let mut changes = Vec::new();
+    let threshold = |preset: Preset| {
+        preset
+            .min_confidence(config)
+            .unwrap_or_else(|| Confidence::Low.as_str().to_string())
+    };
+    if threshold(from) != threshold(to) {
+        changes.push(Change {
+            name: "min_confidence".to_string(),
+            from: threshold(from),
+            to: threshold(to),
+        });
+    }
+    let state = |preset: Preset, rule: &RuleConfig| match preset.rule(rule) {
+        (true, severity) => severity,
+        (false, _) => "off".to_string(),
+    };
+    for rule in &config.rules {
+        let (before, after) = (state(from, rule), state(to, rule));
+        if before != after {
+            changes.push(Change {
+                name: rule.name.clone(),
+                from: before,
+                to: after,
+            });
+        }
+    }
+    changes
+}
+
+pub fn changes_json(changes: &[Change]) -> Value {
+    Value::Array(
+        changes
+            .iter()
+            .map(|change| json!({ "name": change.name, "from": change.from, "to": change.to }))
+            .collect(),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const CONFIG: &str = r#"
+[[rules]]
+name = "bug"
+query = "(ERROR) @error"
+severity = "error"
+message = "Bug"
+enabled = true
+
+[[rules]]
+name = "smell"
+query = "(ERROR) @error"
+severity = "style"
+message = "Smell"
+enabled = true
+
+[[rules]]
+name = "noisy"
+query = "(ERROR) @error"
+severity = "warning"
+message = "Noisy"
+enabled = false
+
+[[rules]]
+name = "vulnerable"
+query = "(source_file) @file"
+check = "go_vulnerable_call"
+severity = "error"
+message = "Vulnerable"
+enabled = false
+"#;
+
+    #[test]
+    fn test_presets_differ_from_the_defaults() {
+        let config = AnalyzerConfig::from_str(CONFIG).unwrap();
+        let changes = |from, to| {
+            diff(&config, from, to)
+                .into_iter()
+                .map(|change| format!("{}: {} -> {}", change.name, change.from, change.to))
+                .collect::<Vec<_>>()
+        };
+
+        assert!(changes(Preset::Standard, Preset::Standard).is_empty());
+        assert_eq!(
+            changes(Preset::Standard, Preset::Minimal),
+            ["min_confidence: low -> medium", "smell: style -> off"]
+        );
+        // Vulnerability rules wait for --vuln.
+        assert_eq!(
+            changes(Preset::Standard, Preset::Strict),
+            ["smell: style -> warning", "noisy: off -> warning"]
+        );
+        assert_eq!(Preset::from_name("Strict"), Some(Preset::Strict));
+        assert_eq!(Preset::from_name("lax"), None);
+    }
+}
@@ -0,0 +1,338 @@
+//! `--profile`: where an analysis run spends its time.
+//!
+//! Each analyzed file contributes the time each rule took on it, its total
+//! time and whether it came from the cache. The totals are grouped by rule
+//! and by package (the file's directory). Rule times add up across
+//! workers, so with `--jobs` they can exceed the run's wall time.
+//!
+//! [`Profile::to_pprof`] writes the same samples as a pprof profile, each
+//! one a stack of rule over package, so `go tool pprof -top` ranks rules and
+//! `-peek` shows which packages a rule spends its time on. Time outside the
+//! rules, such as parsing and loading the package, is charged to a
+//! `(other)` frame.
+
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::Path;
+use std::time::{Duration, Instant};
+
+/// What one analyzed file took.
+#[derive(Debug, Clone, Default)]
+pub struct FileProfile {
+    pub elapsed: Duration,
+    pub rules: Vec<(String, Duration)>,
+    pub cached: bool,
+    /// When the analysis began; `None` for a file that wasn't analyzed.
+    pub started: Option<Instant>,
+    /// The steps of the analysis in order, with when each began and how
+    /// long it took: `load package`, `parse` and `rule <name>`, for
+    /// [`crate::trace`].
+    pub steps: Vec<(String, Instant, Duration)>,
+}
+
+#[derive(Debug, Default)]
+pub struct Profile {
+    rules: BTreeMap<String, Duration>,
+    /// `(time, files)` by package.
+    packages: BTreeMap<String, (Duration, usize)>,
+    /// Time by `(package, rule)`.
+    samples: BTreeMap<(String, String), Duration>,
+    cache_hits: usize,
+    cache_misses: usize,
+}
+
+const OTHER: &str = "(other)";
+
+impl Profile {
+    pub fn new() -> Self {
+        Profile::default()
+    }
+
+    pub fn record(&mut self, path: &str, file: &FileProfile) {
+        let package = package_of(path);
+        let entry = self.packages.entry(package.clone()).or_default();
+        entry.0 += file.elapsed;
+        entry.1 += 1;
+        if file.cached {
+            self.cache_hits += 1;
+        } else {
+            self.cache_misses += 1;
+        }
+
+        let mut in_rules = Duration::ZERO;
+        for (rule, time) in &file.rules {
+            *self.rules.entry(rule.clone()).or_default() += *time;
+            *self
+                .samples
+                .entry((package.clone(), rule.clone()))
+                .or_default() += *time;
+            in_rules += *time;
+        }
+        *self
+            .samples
+            .entry((package, OTHER.to_string()))
+            .or_default() += file.elapsed.saturating_sub(in_rules);
+    }
+
+    /// A plain-text report: the totals, then the `top` slowest rules and
+    /// packages.
+    pub fn report(&self, wall: Duration, top: usize) -> String {
+        let files = self.cache_hits + self.cache_misses;
+        let mut out = format!("Profile: {} file(s) in {}\n", files, format_duration(wall));
+        if files > 0 {
+            out.push_str(&format!(
+                "Cache: {} hit(s), {} miss(es) ({:.0}% hit rate)\n",
+                self.cache_hits,
+                self.cache_misses,
+                100.0 * self.cache_hits as f64 / files as f64
+            ));
+        }
+        if let Some(peak) = peak_memory() {
+            out.push_str(&format!(
+                "Peak memory: {:.1} MiB\n",
+                peak as f64 / (1024.0 * 1024.0)
+            ));
+        }
+
+        let rules: Vec<(&str, Duration, String)> = self
+            .rules
+            .iter()
+            .map(|(rule, time)| (rule.as_str(), *time, String::new()))
+            .collect();
+        out.push_str(&table("Rule", rules, top));
+        let packages: Vec<(&str, Duration, String)> = self
+            .packages
+            .iter()
+            .map(|(package, (time, files))| {
+                (package.as_str(), *time, format!("  {} file(s)", files))
+            })
+            .collect();
+        out.push_str(&table("Package", packages, top));
+        out
+    }
+
+    /// The samples as an uncompressed pprof `Profile` protobuf, in wall
+    /// nanoseconds.
+    pub fn to_pprof(&self) -> Vec<u8> {
+        let mut strings = vec![String::new()];
+        let wall = intern(&mut strings, "wall");
+        let nanoseconds = intern(&mut strings, "nanoseconds");
+        // pprof names a frame through a function and a location; both get
+        // the frame's index plus one, since ids can't be 0.
+        let mut frames: Vec<String> = Vec::new();
+
+        let mut profile = Vec::new();
+        let mut value_type = Vec::new();
+        field_varint(&mut value_type, 1, wall);
+        field_varint(&mut value_type, 2, nanoseconds);
+        field_bytes(&mut profile, 1, &value_type);
+
+        for ((package, rule), time) in &self.samples {
+            let leaf = intern(&mut frames, rule) + 1;
+            let root = intern(&mut frames, &format!("package {}", package)) + 1;
+            let mut locations = Vec::new();
+            varint(&mut locations, leaf);
+            varint(&mut locations, root);
+            let mut values = Vec::new();
+            varint(&mut values, time.as_nanos() as u64);
+            let mut sample = Vec::new();
+            field_bytes(&mut sample, 1, &locations);
+            field_bytes(&mut sample, 2, &values);
+            field_bytes(&mut profile, 2, &sample);
+        }
+
+        for (i, name) in frames.iter().enumerate() {
+            let id = i as u64 + 1;
+            let mut line = Vec::new();
+            field_varint(&mut line, 1, id);
+            let mut location = Vec::new();
+            field_varint(&mut location, 1, id);
+            field_bytes(&mut location, 4, &line);
+            field_bytes(&mut profile, 4, &location);
+
+            let name_id = intern(&mut strings, name);
+            let mut function = Vec::new();
+            field_varint(&mut function, 1, id);
+            field_varint(&mut function, 2, name_id);
+            field_varint(&mut function, 3, name_id);
+            field_bytes(&mut profile, 5, &function);
+        }
+
+        for text in &strings {
+            field_bytes(&mut profile, 6, text.as_bytes());
+        }
+        let duration: Duration = self.packages.values().map(|(time, _)| *time).sum();
+        field_varint(&mut profile, 10, duration.as_nanos() as u64);
+        field_bytes(&mut profile, 11, &value_type);
+        field_varint(&mut profile, 12, 1);
+        profile
+    }
+}
+
+/// The process's peak resident memory in bytes, where the platform says.
+pub fn peak_memory() -> Option<u64> {
+    let status = fs::read_to_string("/proc/self/status").ok()?;
+    let line = status.lines().find(|line| line.starts_with("VmHWM:"))?;
+    let kilobytes: u64 = line
+        .trim_start_matches("VmHWM:")
+        .trim()
+        .trim_end_matches("kB")
+        .trim()
+        .parse()
+        .ok()?;
+    Some(kilobytes * 1024)
+}
+
+pub(crate) fn package_of(path: &str) -> String {
+    match Path::new(path).parent() {
+        Some(dir) if !dir.as_os_str().is_empty() => dir.to_string_lossy().into_owned(),
+        _ => ".".to_string(),
+    }
+}
+
+fn table(title: &str, mut rows: Vec<(&str, Duration, String)>, top: usize) -> String {
+    rows.sort_by(|a, b| b.1.cmp(&a.1).then(a.0.cmp(b.0)));
+    let total: Duration = rows.iter().map(|(_, time, _)| *time).sum();
+    let shown = rows.len().min(top);
+    let width = rows[..shown]
+        .iter()
+        .map(|(name, _, _)| name.len())
+        .max()
+        .unwrap_or(0)
+        .max(title.len());
+    let mut out = format!("\n{:width$}  {:>10}  {:>6}\n", title, "time", "share");
+    for (name, time, extra) in &rows[..shown] {
+        let share = if total.is_zero() {
+            0.0
+        } else {
+            100.0 * time.as_secs_f64() / total.as_secs_f64()
+        };
+        out.push_str(&format!(
+            "{:width$}  {:>10}  {:>5.1}%{}\n",
+            name,
+            format_duration(*time),
+            share,
+            extra
+        ));
+    }
+    if rows.len() > shown {
+        out.push_str(&format!("... {} more\n", rows.len() - shown));
+    }
+    out
+}
+
+fn format_duration(duration: Duration) -> String {
+    let millis = duration.as_secs_f64() * 1000.0;
+    if millis >= 1000.0 {
+        format!("{:.2}s", millis / 1000.0)
+    } else {
+        format!("{:.1}ms", millis)
+    }
+}
+
+/// The index of `text` in `table`, adding it if it's new.
+fn intern(table: &mut Vec<String>, text: &str) -> u64 {
+    match table.iter().position(|entry| entry == text) {
+        Some(index) => index as u64,
+        None => {
+            table.push(text.to_string());
+            (table.len() - 1) as u64
+        }
+    }
+}
+
+fn varint(out: &mut Vec<u8>, mut value: u64) {
+    while value >= 0x80 {
+        out.push((value as u8 & 0x7f) | 0x80);
+        value >>= 7;
+    }
+    out.push(value as u8);
+}
+
+fn field_varint(out: &mut Vec<u8>, field: u64, value: u64) {
+    varint(out, field << 3);
+    varint(out, value);
+}
+
+fn field_bytes(out: &mut Vec<u8>, field: u64, bytes: &[u8]) {
+    varint(out, (field << 3) | 2);
+    varint(out, bytes.len() as u64);
+    out.extend_from_slice(bytes);
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn file(elapsed: u64, rules: &[(&str, u64)], cached: bool) -> FileProfile {
+        FileProfile {
+            elapsed: Duration::from_millis(elapsed),
+            rules: rules
+                .iter()
+                .map(|(rule, time)| (rule.to_string(), Duration::from_millis(*time)))
+                .collect(),
+            cached,
+            ..FileProfile::default()
+        }
+    }
+
+    #[test]
+    fn test_times_add_up_by_rule_and_package() {
+        let mut profile = Profile::new();
+        profile.record(
+            "api/server.go",
+            &file(10, &[("panic_usage", 2), ("nil_dereference", 6)], false),
+        );
+        profile.record(
+            "api/client.go",
+            &file(5, &[("panic_usage", 1), ("nil_dereference", 3)], false),
+        );
+        profile.record("main.go", &file(1, &[], true));
+
+        assert_eq!(profile.rules["nil_dereference"], Duration::from_millis(9));
+        assert_eq!(profile.packages["api"], (Duration::from_millis(15), 2));
+        assert_eq!(profile.packages["."], (Duration::from_millis(1), 1));
+        assert_eq!(
+            profile.samples[&("api".to_string(), OTHER.to_string())],
+            Duration::from_millis(3)
+        );
+
+        let report = profile.report(Duration::from_millis(20), 1);
+        assert!(report.starts_with(
+            "Profile: 3 file(s) in 20.0ms\nCache: 1 hit(s), 2 miss(es) (33% hit rate)\n"
+        ));
+        assert!(report.contains("\nnil_dereference       9.0ms   75.0%\n... 1 more\n"));
+        assert!(report.contains("\napi          15.0ms   93.8%  2 file(s)\n"));
+    }
+
+    #[test]
+    fn test_pprof_has_a_sample_per_package_and_rule() {
+        let mut profile = Profile::new();
+        profile.record("api/server.go", &file(3, &[("panic_usage", 2)], false));
+        let pprof = profile.to_pprof();
+
+        // sample_type { type: "wall", unit: "nanoseconds" }, ids 1 and 2
+        assert_eq!(&pprof[..6], &[0x0a, 0x04, 0x08, 0x01, 0x10, 0x02]);
+        // Two samples, (other) and panic_usage, each over `package api`
+        let samples = pprof.windows(2).filter(|pair| pair[0] == 0x12).count();
+        assert!(samples >= 2);
+        let text = String::from_utf8_lossy(&pprof);
+        for name in [
+            "wall",
+            "nanoseconds",
+            "panic_usage",
+            "(other)",
+            "package api",
+        ] {
+            assert!(text.contains(name), "{} is missing", name);
+        }
+    }
+
+    #[test]
+    fn test_varints_use_seven_bits_per_byte() {
+        let mut out = Vec::new();
+        varint(&mut out, 1);
+        varint(&mut out, 300);
+        assert_eq!(out, [0x01, 0xac, 0x02]);
+    }
+}
@@ -0,0 +1,1029 @@
+//! Project configuration: `.compass.toml` files that adjust the rule config
+//! for the directory they live in and everything below it.
+//!
+//! The files between the repository root and a source file are merged from
+//! the top down, so a nested file overrides whatever its parents set:
+//!
+//! ```toml
+//! exclude = ["third_party/**", "*_mock.go"]
+//!
+//! [rules.panic_usage]
+//! enabled = false
+//!
+//! [rules.goroutine_leak]
+//! severity = "error"
+//! confidence = "low"
+//! ```
+//!
+//! Vendored code, the usual generated Go files and any file marked `// Code
+//! generated ... DO NOT EDIT.` are excluded as well, unless a file sets
+//! `default_excludes = false`.
+//!
+//! `[[overrides]]` set rules for the files matching some paths, after the
+//! file's `[rules.*]`, either to a level or as `[rules.*]` does:
+//!
+//! ```toml
+//! [[overrides]]
+//! paths = ["*_test.go", "examples/"]
+//! rules = { panic_usage = "off", missing_error_check = "info" }
+//! ```
+//!
+//! A file can also `extends` a shared bundle, which is merged just before
+//! it; see [`crate::bundle`]. `preset = "strict"` picks the rules to start
+//! from; see [`crate::preset`].
+//!
+//! Rules at the experimental [`Stage`] are off unless `experimental = true`
+//! is set, or `[rules.*]` enables one by name.
+
+use crate::analyzer::{CodeAnalyzer, Confidence, Severity, Stage};
+use crate::bundle::{self, Extends};
+use crate::config::{AnalyzerConfig, RuleConfig};
+use crate::language::SupportedLanguage;
+use crate::messages::{self, Catalog, Messages};
+use crate::plugin::Registry;
+use crate::preset::Preset;
+use globset::{GlobBuilder, GlobMatcher};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap};
+use std::fs::{self, File};
+use std::io::Read;
+use std::path::{Path, PathBuf};
+
+pub const PROJECT_CONFIG_FILE: &str = ".compass.toml";
+
+/// Exclusions that apply unless turned off: vendored dependencies, and the
+/// output of `go generate` conventions and protoc plugins.
+pub const DEFAULT_EXCLUDES: &[&str] = &["vendor", "*_gen.go", "*.pb.go", "*.pb.*.go"];
+
+/// How much of a file is searched for a generated-code marker.
+const HEADER_BYTES: u64 = 4096;
+
+/// Per-rule settings; anything left unset is inherited.
+#[derive(Debug, Clone, Default, Deserialize, Serialize)]
+#[serde(deny_unknown_fields)]
+pub struct RuleOverride {
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub enabled: Option<bool>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub severity: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub weight: Option<f64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub confidence: Option<String>,
+    #[serde(default, skip_serializing_if = "toml::Table::is_empty")]
+    pub options: toml::Table,
+    /// Rewording, as a rule config or catalog sets it; see
+    /// [`crate::messages`].
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub template: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub suggestion: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub url: Option<String>,
+}
+
+impl RuleOverride {
+    fn merge(&mut self, child: &RuleOverride) {
+        if child.enabled.is_some() {
+            self.enabled = child.enabled;
+        }
+        if child.severity.is_some() {
+            self.severity = child.severity.clone();
+        }
+        if child.weight.is_some() {
+            self.weight = child.weight;
+        }
+        if child.confidence.is_some() {
+            self.confidence = child.confidence.clone();
+        }
+        for (key, value) in &child.options {
+            self.options.insert(key.clone(), value.clone());
+        }
+        if child.template.is_some() {
+            self.template = child.template.clone();
+        }
+        if child.suggestion.is_some() {
+            self.suggestion = child.suggestion.clone();
+        }
+        if child.url.is_some() {
+            self.url = child.url.clone();
+        }
+    }
+}
+
+/// How a `[[overrides]]` block sets a rule: a level, `off`, `on` or a
+/// severity, or a table as `[rules.*]` takes.
+#[derive(Debug, Clone, Deserialize, Serialize)]
+#[serde(untagged)]
+pub enum RuleSetting {
+    Level(String),
+    Rule(RuleOverride),
+}
+
+impl RuleSetting {
+    /// The settings the level stands for; `off` and `on` keep the severity.
+    pub fn to_override(&self) -> RuleOverride {
+        match self {
+            RuleSetting::Level(level) if level == "off" => RuleOverride {
+                enabled: Some(false),
+                ..Default::default()
+            },
+            RuleSetting::Level(level) if level == "on" => RuleOverride {
+                enabled: Some(true),
+                ..Default::default()
+            },
+            RuleSetting::Level(severity) => RuleOverride {
+                enabled: Some(true),
+                severity: Some(severity.clone()),
+                ..Default::default()
+            },
+            RuleSetting::Rule(rule) => rule.clone(),
+        }
+    }
+}
+
+/// Rule settings for the files matching `paths`, such as relaxing rules in
+/// tests, applied after those of `[rules.*]`.
+#[derive(Debug, Clone, Default, Deserialize, Serialize)]
+#[serde(deny_unknown_fields)]
+pub struct PathOverride {
+    /// Glob patterns, relative to the file's directory, as in `exclude`.
+    pub paths: Vec<String>,
+    #[serde(default)]
+    pub rules: BTreeMap<String, RuleSetting>,
+}
+
+/// Settings of `compass dupes`; see [`crate::dupes`]. Anything left unset
+/// is inherited.
+#[derive(Debug, Clone, Default, Deserialize, Serialize)]
+#[serde(deny_unknown_fields)]
+pub struct DupesConfig {
+    /// Functions with fewer tokens aren't reported.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub min_tokens: Option<usize>,
+    /// Whether Go tests are searched too, which they aren't by default:
+    /// table tests repeat themselves on purpose.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub tests: Option<bool>,
+}
+
+impl From<&Messages> for RuleOverride {
+    fn from(messages: &Messages) -> Self {
+        RuleOverride {
+            template: messages.template.clone(),
+            suggestion: messages.suggestion.clone(),
+            url: messages.url.clone(),
+            ..Default::default()
+        }
+    }
+}
+
+/// One `.compass.toml` file as written.
+#[derive(Debug, Clone, Default, Deserialize, Serialize)]
+#[serde(deny_unknown_fields)]
+pub struct ProjectConfig {
+    /// Stops the search for parent configs at this directory.
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub root: bool,
+    /// A shared bundle merged before this file.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub extends: Option<Extends>,
+    /// Glob patterns, relative to the file's directory, of paths to skip.
+    /// Patterns without a `/` match at any depth.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub exclude: Vec<String>,
+    /// Whether [`DEFAULT_EXCLUDES`] and generated files are skipped too.
+    /// Unset inherits the parent's choice, which defaults to yes.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub default_excludes: Option<bool>,
+    /// Findings less confident than this are left out.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub min_confidence: Option<String>,
+    /// The [`Preset`] the rule overrides are applied on top of.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub preset: Option<String>,
+    /// Whether experimental rules run. Unset inherits the parent's choice,
+    /// which defaults to no; a rule enabled by name runs either way.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub experimental: Option<bool>,
+    /// A message catalog, relative to the file's directory, merged before
+    /// the file's own rules. `{locale}` stands for the user's locale.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub catalog: Option<String>,
+    /// The link of every rule that doesn't set its own.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub url: Option<String>,
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub rules: BTreeMap<String, RuleOverride>,
+    /// Later blocks win over earlier ones, and a nested file's over its
+    /// parents'.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub overrides: Vec<PathOverride>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub dupes: Option<DupesConfig>,
+}
+
+impl ProjectConfig {
+    pub fn from_file<P: AsRef<Path>>(path: P) -> Result<Self, Box<dyn std::error::Error>> {
+        let content = fs::read_to_string(path)?;
+        Self::from_toml(&content)
+    }
+
+    pub fn from_toml(content: &str) -> Result<Self, Box<dyn std::error::Error>> {
+        let config: ProjectConfig = toml::from_str(content)?;
+        if let Some(problem) = config.problems().into_iter().next() {
+            return Err(problem.into());
+        }
+        Ok(config)
+    }
+
+    /// What is wrong with the file's severities and confidences, each as
+    /// the error loading it would report.
+    pub fn problems(&self) -> Vec<String> {
+        let mut problems = Vec::new();
+        if let Some(confidence) = &self.min_confidence {
+            if Confidence::from_name(confidence).is_none() {
+                problems.push(format!(
+                    "unknown min_confidence '{}' (expected one of: {})",
+                    confidence,
+                    Confidence::NAMES
+                ));
+            }
+        }
+        if let Some(preset) = &self.preset {
+            if Preset::from_name(preset).is_none() {
+                problems.push(format!(
+                    "unknown preset '{}' (expected one of: {})",
+                    preset,
+                    Preset::NAMES
+                ));
+            }
+        }
+        problems.extend(messages::template_problems(None, None, self.url.as_deref()));
+        if self.dupes.as_ref().and_then(|dupes| dupes.min_tokens) == Some(0) {
+            problems.push("dupes.min_tokens must be at least 1".to_string());
+        }
+        for (name, rule) in &self.rules {
+            problems.extend(rule_problems(name, rule));
+        }
+        for block in &self.overrides {
+            if block.paths.is_empty() {
+                problems.push("overrides need at least one path".to_string());
+            }
+            for (name, setting) in &block.rules {
+                match setting {
+                    RuleSetting::Level(level)
+                        if level != "off"
+                            && level != "on"
+                            && Severity::from_name(level).is_none() =>
+                    {
+                        problems.push(format!(
+                            "rule '{}' has unknown level '{}' (expected off, on or one of: {})",
+                            name,
+                            level,
+                            Severity::NAMES
+                        ));
+                    }
+                    RuleSetting::Level(_) => {}
+                    RuleSetting::Rule(rule) => problems.extend(rule_problems(name, rule)),
+                }
+            }
+        }
+        problems
+    }
+}
+
+/// What is wrong with the texts, severity and confidence `rule` sets.
+fn rule_problems(name: &str, rule: &RuleOverride) -> Vec<String> {
+    let mut problems: Vec<String> = messages::template_problems(
+        rule.template.as_deref(),
+        rule.suggestion.as_deref(),
+        rule.url.as_deref(),
+    )
+    .into_iter()
+    .map(|problem| format!("rule '{}': {}", name, problem))
+    .collect();
+    if let Some(severity) = &rule.severity {
+        if Severity::from_name(severity).is_none() {
+            problems.push(format!(
+                "rule '{}' has unknown severity '{}' (expected one of: {})",
+                name,
+                severity,
+                Severity::NAMES
+            ));
+        }
+    }
+    if let Some(confidence) = &rule.confidence {
+        if Confidence::from_name(confidence).is_none() {
+            problems.push(format!(
+                "rule '{}' has unknown confidence '{}' (expected one of: {})",
+                name,
+                confidence,
+                Confidence::NAMES
+            ));
+        }
+    }
+    problems
+}
+
+/// The merged result of every `.compass.toml` that applies to a path.
+#[derive(Debug, Clone)]
+pub struct EffectiveConfig {
+    /// The directory exclusion patterns are relative to.
+    pub root: PathBuf,
+    /// Files that were merged, outermost first.
+    pub files: Vec<PathBuf>,
+    pub merged: ProjectConfig,
+    excludes: Vec<GlobMatcher>,
+    /// The settings of the `[[overrides]]` blocks matching the path.
+    overrides: BTreeMap<String, RuleOverride>,
+    skip_generated: bool,
+}
+
+impl EffectiveConfig {
+    /// Collects and merges the configs from `path` (a file or directory) up
+    /// to the repository root, which is the first directory containing `.git`
+    /// or a config with `root = true`.
+    pub fn for_path<P: AsRef<Path>>(path: P) -> Result<Self, Box<dyn std::error::Error>> {
+        Self::for_path_with(path.as_ref(), bundle::load, messages::locale().as_deref())
+    }
+
+    fn for_path_with(
+        path: &Path,
+        load_bundle: impl Fn(&Extends) -> Result<ProjectConfig, String>,
+        locale: Option<&str>,
+    ) -> Result<Self, Box<dyn std::error::Error>> {
+        let path = absolute(path)?;
+        let start = if path.is_dir() {
+            path.clone()
+        } else {
+            path.parent().map(Path::to_path_buf).unwrap_or_default()
+        };
+
+        let mut chain = Vec::new();
+        let mut root = start.clone();
+        for dir in start.ancestors() {
+            let candidate = dir.join(PROJECT_CONFIG_FILE);
+            let mut stop = dir.join(".git").exists();
+            if candidate.is_file() {
+                let config = ProjectConfig::from_file(&candidate)
+                    .map_err(|e| format!("failed to load '{}': {}", candidate.display(), e))?;
+                stop |= config.root;
+                let bundle = match &config.extends {
+                    Some(extends) => Some((
+                        PathBuf::from(extends.source()),
+                        load_bundle(extends).map_err(|e| {
+                            format!(
+                                "failed to load bundle '{}' extended by '{}': {}",
+                                extends.source(),
+                                candidate.display(),
+                                e
+                            )
+                        })?,
+                    )),
+                    None => None,
+                };
+                chain.push((dir.to_path_buf(), candidate, config));
+                // Reversed below, so the bundle comes first.
+                if let Some((source, config)) = bundle {
+                    chain.push((dir.to_path_buf(), source, config));
+                }
+                root = dir.to_path_buf();
+            }
+            if stop {
+                root = dir.to_path_buf();
+                break;
+            }
+        }
+        chain.reverse();
+
+        let mut merged = ProjectConfig::default();
+        let mut files = Vec::new();
+        for (dir, file, config) in chain {
+            let prefix = relative_dir(&dir, &root);
+            for pattern in &config.exclude {
+                merged.exclude.push(anchored(&prefix, pattern));
+            }
+            if config.default_excludes.is_some() {
+                merged.default_excludes = config.default_excludes;
+            }
+            if config.min_confidence.is_some() {
+                merged.min_confidence = config.min_confidence.clone();
+            }
+            if config.preset.is_some() {
+                merged.preset = config.preset.clone();
+            }
+            if config.experimental.is_some() {
+                merged.experimental = config.experimental;
+            }
+            if let Some(pattern) = &config.catalog {
+                // A catalog for another locale is simply missing; the rules
+                // keep their own text.
+                let found = messages::catalog_candidates(&dir, pattern, locale)
+                    .into_iter()
+                    .find(|candidate| candidate.is_file() || !pattern.contains("{locale}"));
+                if let Some(path) = found {
+                    let catalog = Catalog::from_file(&path)
+                        .map_err(|e| format!("'{}': {}", file.display(), e))?;
+                    if catalog.url.is_some() {
+                        merged.url = catalog.url.clone();
+                    }
+                    for (name, messages) in &catalog.rules {
+                        merged
+                            .rules
+                            .entry(name.clone())
+                            .or_default()
+                            .merge(&RuleOverride::from(messages));
+                    }
+                    files.push(path);
+                }
+            }
+            if config.url.is_some() {
+                merged.url = config.url.clone();
+            }
+            for (name, rule) in &config.rules {
+                merged.rules.entry(name.clone()).or_default().merge(rule);
+            }
+            for block in &config.overrides {
+                merged.overrides.push(PathOverride {
+                    paths: block
+                        .paths
+                        .iter()
+                        .map(|pattern| anchored(&prefix, pattern))
+                        .collect(),
+                    rules: block.rules.clone(),
+                });
+            }
+            if let Some(dupes) = &config.dupes {
+                let merged = merged.dupes.get_or_insert_with(DupesConfig::default);
+                if dupes.min_tokens.is_some() {
+                    merged.min_tokens = dupes.min_tokens;
+                }
+                if dupes.tests.is_some() {
+                    merged.tests = dupes.tests;
+                }
+            }
+            files.push(file);
+        }
+
+        let skip_generated = merged.default_excludes.unwrap_or(true);
+        let defaults = DEFAULT_EXCLUDES
+            .iter()
+            .filter(|_| skip_generated)
+            .map(|pattern| format!("**/{}", pattern));
+        let excludes = merged
+            .exclude
+            .iter()
+            .cloned()
+            .chain(defaults)
+            .map(|pattern| matcher("exclude", &pattern))
+            .collect::<Result<_, _>>()?;
+
+        let mut overrides = BTreeMap::new();
+        let relative = path.strip_prefix(&root).unwrap_or(&path);
+        for block in &merged.overrides {
+            let globs = block
+                .paths
+                .iter()
+                .map(|pattern| matcher("overrides", pattern))
+                .collect::<Result<Vec<_>, _>>()?;
+            if matches_any(relative, &globs) {
+                for (name, setting) in &block.rules {
+                    overrides
+                        .entry(name.clone())
+                        .or_insert_with(RuleOverride::default)
+                        .merge(&setting.to_override());
+                }
+            }
+        }
+
+        Ok(EffectiveConfig {
+            root,
+            files,
+            merged,
+            excludes,
+            overrides,
+            skip_generated,
+        })
+    }
+
+    /// Whether `path`, or a directory containing it, matches an exclusion,
+    /// or `path` is a generated file. Only the start of a file is read, so
+    /// this is cheap enough to decide what to analyze at all.
+    pub fn is_excluded<P: AsRef<Path>>(&self, path: P) -> bool {
+        let Ok(path) = absolute(path.as_ref()) else {
+            return false;
+        };
+        let Ok(relative) = path.strip_prefix(&self.root) else {
+            return false;
+        };
+        matches_any(relative, &self.excludes)
+            || (self.skip_generated && path.is_file() && has_generated_header(&path))
+    }
+
+    /// Applies the preset, the rule overrides and then the `[[overrides]]`
+    /// matching the path to `config`, and checks the options they set.
+    /// Overrides for rules the config doesn't define are ignored, since one
+    /// project file covers every language.
+    pub fn apply(&self, config: &mut AnalyzerConfig) -> Result<(), String> {
+        if let Some(preset) = self.merged.preset.as_deref().and_then(Preset::from_name) {
+            preset.apply(config);
+        }
+        if self.merged.min_confidence.is_some() {
+            config.min_confidence = self.merged.min_confidence.clone();
+        }
+        for rule in &mut config.rules {
+            if rule.stage() == Stage::Experimental && self.merged.experimental != Some(true) {
+                rule.enabled = false;
+            }
+
+            if rule.url.is_none() {
+                rule.url = self.merged.url.clone();
+            }
+            let overrides = [
+                self.merged.rules.get(&rule.name),
+                self.overrides.get(&rule.name),
+            ];
+            for change in overrides.into_iter().flatten() {
+                apply_rule(rule, change);
+            }
+        }
+        config.validate()
+    }
+
+    /// Tells apart the paths whose rules are set differently: by other
+    /// files, or other `[[overrides]]`, for caching analyzers.
+    pub fn key(&self) -> String {
+        format!("{:?}:{:?}", self.files, self.overrides)
+    }
+
+    /// The merged config as TOML, preceded by the files it came from.
+    pub fn to_toml(&self) -> Result<String, toml::ser::Error> {
+        let mut out = String::new();
+        if self.files.is_empty() {
+            out.push_str("# No .compass.toml files apply; built-in defaults are used.\n");
+        } else {
+            out.push_str("# Merged from:\n");
+            for file in &self.files {
+                out.push_str(&format!("#   {}\n", file.display()));
+            }
+        }
+        out.push_str(&format!(
+            "# Exclusions are relative to {}\n",
+            self.root.display()
+        ));
+        out.push_str(&toml::to_string(&self.merged)?);
+        Ok(out)
+    }
+}
+
+/// A config and its analyzer, as loaded for one language and set of
+/// project configs.
+pub struct LoadedConfig {
+    pub config: AnalyzerConfig,
+    pub analyzer: CodeAnalyzer,
+}
+
+/// The configs `compass lsp`, `watch` and `serve` have loaded so far, each
+/// the first time a file needed it.
+pub struct ConfigCache {
+    config_override: Option<String>,
+    registry: Registry,
+    /// Keyed by language and the project config files that apply.
+    loaded: HashMap<String, LoadedConfig>,
+}
+
+impl ConfigCache {
+    pub fn new(config_override: Option<String>, registry: Registry) -> Self {
+        ConfigCache {
+            config_override,
+            registry,
+            loaded: HashMap::new(),
+        }
+    }
+
+    /// The config file given on the command line, if any.
+    pub fn config_override(&self) -> Option<&str> {
+        self.config_override.as_deref()
+    }
+
+    /// The config for `language` with `project` applied, loading it the
+    /// first time.
+    pub fn get(
+        &mut self,
+        language: SupportedLanguage,
+        project: &EffectiveConfig,
+    ) -> Result<&LoadedConfig, Box<dyn std::error::Error>> {
+        let key = format!("{}:{}", language.config_key(), project.key());
+        if !self.loaded.contains_key(&key) {
+            let (mut config, _) = AnalyzerConfig::load(self.config_override.as_deref(), language)?;
+            project.apply(&mut config)?;
+            let mut analyzer = config.to_analyzer();
+            analyzer.set_registry(self.registry.clone());
+            self.loaded
+                .insert(key.clone(), LoadedConfig { config, analyzer });
+        }
+        Ok(&self.loaded[&key])
+    }
+
+    /// Forgets every loaded config, so the next file reads them again.
+    pub fn clear(&mut self) {
+        self.loaded.clear();
+    }
+}
+
+/// Sets on `rule` whatever `change` sets.
+fn apply_rule(rule: &mut RuleConfig, change: &RuleOverride) {
+    if let Some(enabled) = change.enabled {
+        rule.enabled = enabled;
+    }
+    if let Some(severity) = &change.severity {
+        rule.severity = severity.clone();
+    }
+    if let Some(weight) = change.weight {
+        rule.weight = weight;
+    }
+    if change.confidence.is_some() {
+        rule.confidence = change.confidence.clone();
+    }
+    for (key, value) in &change.options {
+        rule.options.insert(key.clone(), value.clone());
+    }
+    if change.template.is_some() {
+        rule.template = change.template.clone();
+    }
+    if change.suggestion.is_some() {
+        rule.suggestion = change.suggestion.clone();
+    }
+    if change.url.is_some() {
+        rule.url = change.url.clone();
+    }
+}
+
+/// `pattern` from a file in the directory `prefix`, relative to the root.
+/// Patterns without a `/` match at any depth.
+fn anchored(prefix: &str, pattern: &str) -> String {
+    let pattern = pattern.trim_start_matches("./").trim_end_matches('/');
+    if pattern.contains('/') {
+        format!("{}{}", prefix, pattern)
+    } else {
+        format!("{}**/{}", prefix, pattern)
+    }
+}
+
+fn matcher(key: &str, pattern: &str) -> Result<GlobMatcher, String> {
+    GlobBuilder::new(pattern)
+        .literal_separator(true)
+        .build()
+        .map(|glob| glob.compile_matcher())
+        .map_err(|e| format!("invalid {} pattern '{}': {}", key, pattern, e))
+}
+
+/// Whether `relative`, or a directory containing it, matches one of `globs`.
+fn matches_any(relative: &Path, globs: &[GlobMatcher]) -> bool {
+    relative
+        .ancestors()
+        .filter(|candidate| !candidate.as_os_str().is_empty())
+        .any(|candidate| globs.iter().any(|glob| glob.is_match(candidate)))
+}
+
+/// The Go convention for generated files: a `// Code generated ... DO NOT
+/// EDIT.` line before the package clause.
+pub fn is_generated(source_code: &str) -> bool {
+    source_code
+        .lines()
+        .take_while(|line| !line.starts_with("package "))
+        .any(|line| line.starts_with("// Code generated ") && line.ends_with(" DO NOT EDIT."))
+}
+
+fn has_generated_header(path: &Path) -> bool {
+    let mut header = Vec::new();
+    let read = File::open(path).and_then(|file| file.take(HEADER_BYTES).read_to_end(&mut header));
+    read.is_ok() && is_generated(&String::from_utf8_lossy(&header))
+}
+
+fn absolute(path: &Path) -> std::io::Result<PathBuf> {
+    path.canonicalize().or_else(|_| std::path::absolute(path))
+}
+
+/// `dir` relative to `root` with a trailing slash, or empty for the root.
+fn relative_dir(dir: &Path, root: &Path) -> String {
+    let relative = dir.strip_prefix(root).unwrap_or(Path::new(""));
+    let parts: Vec<String> = relative
+        .components()
+        .map(|part| part.as_os_str().to_string_lossy().into_owned())
+        .collect();
+    if parts.is_empty() {
+        String::new()
+    } else {
+        format!("{}/", parts.join("/"))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn scratch_dir(name: &str) -> PathBuf {
+        let dir =
+            std::env::temp_dir().join(format!("compass-project-{}-{}", name, std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        fs::create_dir_all(dir.join(".git")).unwrap();
+        dir
+    }
+
+    #[test]
+    fn test_nested_configs_override_parents() {
+        let dir = scratch_dir("nested");
+        fs::create_dir_all(dir.join("internal/generated")).unwrap();
+        fs::write(
+            dir.join(PROJECT_CONFIG_FILE),
+            "exclude = [\"vendor\"]\n[rules.panic_usage]\nseverity = \"error\"\n[rules.magic]\nweight = 2.0\n[dupes]\nmin_tokens = 120\ntests = true\n",
+        )
+        .unwrap();
+        fs::write(
+            dir.join("internal/generated").join(PROJECT_CONFIG_FILE),
+            "exclude = [\"*_gen.go\"]\n[rules.panic_usage]\nenabled = false\n[dupes]\nmin_tokens = 60\n",
+        )
+        .unwrap();
+
+        let effective = EffectiveConfig::for_path(dir.join("internal/generated")).unwrap();
+        assert_eq!(effective.files.len(), 2);
+        let panic_usage = &effective.merged.rules["panic_usage"];
+        assert_eq!(panic_usage.enabled, Some(false));
+        assert_eq!(panic_usage.severity.as_deref(), Some("error"));
+        assert_eq!(effective.merged.rules["magic"].weight, Some(2.0));
+        let dupes = effective.merged.dupes.as_ref().unwrap();
+        assert_eq!((dupes.min_tokens, dupes.tests), (Some(60), Some(true)));
+        assert_eq!(
+            effective.merged.exclude,
+            vec!["**/vendor", "internal/generated/**/*_gen.go"]
+        );
+
+        assert!(effective.is_excluded(dir.join("internal/generated/api_gen.go")));
+        assert!(effective.is_excluded(dir.join("vendor/lib/lib.go")));
+        assert!(!effective.is_excluded(dir.join("internal/generated/api.go")));
+
+        // A sibling directory only sees the root config.
+        let sibling = EffectiveConfig::for_path(&dir).unwrap();
+        assert_eq!(sibling.merged.rules["panic_usage"].enabled, None);
+        fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_catalogs_merge_before_the_file_that_names_them() {
+        let dir = scratch_dir("catalog");
+        fs::create_dir_all(dir.join("i18n")).unwrap();
+        fs::write(
+            dir.join(PROJECT_CONFIG_FILE),
+            "catalog = \"i18n/compass.{locale}.toml\"\n[rules.panic_usage]\nsuggestion = \"Ask #go-help.\"\n",
+        )
+        .unwrap();
+        fs::write(
+            dir.join("i18n/compass.de.toml"),
+            "url = \"https://wiki.example.com/compass/{rule}\"\n[rules.panic_usage]\ntemplate = \"panic in {symbol}\"\nsuggestion = \"Fehler zurückgeben.\"\n",
+        )
+        .unwrap();
+        let load = |locale| EffectiveConfig::for_path_with(&dir, bundle::load, locale).unwrap();
+
+        let german = load(Some("de_AT"));
+        assert_eq!(german.files.len(), 2);
+        let panic_usage = &german.merged.rules["panic_usage"];
+        assert_eq!(panic_usage.template.as_deref(), Some("panic in {symbol}"));
+        assert_eq!(panic_usage.suggestion.as_deref(), Some("Ask #go-help."));
+
+        let mut config = AnalyzerConfig::from_str(
+            "[[rules]]\nname = \"panic_usage\"\nquery = \"(identifier) @x\"\nseverity = \"warning\"\nmessage = \"m\"\nenabled = true\n",
+        )
+        .unwrap();
+        german.apply(&mut config).unwrap();
+        assert_eq!(
+            config.rules[0].url.as_deref(),
+            Some("https://wiki.example.com/compass/{rule}")
+        );
+
+        // Without a catalog for the locale the rules keep their own text
+        let english = load(Some("en_US"));
+        assert_eq!(english.files.len(), 1);
+        assert_eq!(english.merged.rules["panic_usage"].template, None);
+
+        fs::write(
+            dir.join("i18n/compass.de.toml"),
+            "[rules.panic_usage]\ntemplate = \"{mesage}\"\n",
+        )
+        .unwrap();
+        let error = EffectiveConfig::for_path_with(&dir, bundle::load, Some("de"))
+            .unwrap_err()
+            .to_string();
+        assert!(
+            error.contains("unknown placeholder '{mesage}'"),
+            "{}",
+            error
+        );
+        fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_vendored_and_generated_files_are_excluded() {
+        let dir = scratch_dir("generated");
+        fs::create_dir_all(dir.join("api")).unwrap();
+        let generated = dir.join("api/client.go");
+        fs::write(
+            &generated,
+            "// Code generated by mockgen. DO NOT EDIT.\n\npackage api\n",
+        )
+        .unwrap();
+        let handwritten = dir.join("api/server.go");
+        fs::write(&handwritten, "package api\n").unwrap();
+
+        let effective = EffectiveConfig::for_path(&dir).unwrap();
+        assert!(effective.is_excluded(&generated));
+        assert!(!effective.is_excluded(&handwritten));
+        assert!(effective.is_excluded(dir.join("vendor/github.com/x/y/y.go")));
+        assert!(effective.is_excluded(dir.join("api/api.pb.go")));
+        assert!(effective.is_excluded(dir.join("api/api.pb.gw.go")));
+        assert!(effective.is_excluded(dir.join("api/enum_gen.go")));
+
+        fs::write(
+            dir.join("api").join(PROJECT_CONFIG_FILE),
+            "default_excludes = false\n",
+        )
+        .unwrap();
+        let effective = EffectiveConfig::for_path(dir.join("api")).unwrap();
+        assert!(!effective.is_excluded(&generated));
+        assert!(!effective.is_excluded(dir.join("api/api.pb.go")));
+        fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_bundles_merge_before_the_extending_file() {
+        let dir = scratch_dir("bundle");
+        fs::create_dir_all(dir.join("services")).unwrap();
+        fs::write(
+            dir.join("services").join(PROJECT_CONFIG_FILE),
+            "extends = \"ghcr.io/mycorp/compass-policy:v3\"\n[rules.panic_usage]\nenabled = true\n",
+        )
+        .unwrap();
+        let load_bundle = |extends: &Extends| {
+            assert_eq!(extends.source(), "ghcr.io/mycorp/compass-policy:v3");
+            ProjectConfig::from_toml(
+                "exclude = [\"*_mock.go\"]\n[rules.panic_usage]\nenabled = false\nseverity = \"error\"\n",
+            )
+            .map_err(|e| e.to_string())
+        };
+
+        let effective =
+            EffectiveConfig::for_path_with(&dir.join("services"), load_bundle, None).unwrap();
+        assert_eq!(
+            effective.files,
+            vec![
+                PathBuf::from("ghcr.io/mycorp/compass-policy:v3"),
+                dir.join("services").join(PROJECT_CONFIG_FILE)
+            ]
+        );
+        let panic_usage = &effective.merged.rules["panic_usage"];
+        assert_eq!(panic_usage.enabled, Some(true));
+        assert_eq!(panic_usage.severity.as_deref(), Some("error"));
+        assert_eq!(effective.merged.exclude, vec!["services/**/*_mock.go"]);
+
+        let failing = |_: &Extends| Err("offline".to_string());
+        let error =
+            EffectiveConfig::for_path_with(&dir.join("services"), failing, None).unwrap_err();
+        assert!(error.to_string().contains("compass-policy:v3"));
+        fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_path_overrides_apply_to_matching_files() {
+        let dir = scratch_dir("overrides");
+        fs::create_dir_all(dir.join("tools")).unwrap();
+        fs::write(
+            dir.join(PROJECT_CONFIG_FILE),
+            "[rules.panic_usage]\nseverity = \"error\"\n\n[[overrides]]\npaths = [\"*_test.go\"]\nrules = { panic_usage = \"off\", missing_error_check = \"info\" }\n",
+        )
+        .unwrap();
+        fs::write(
+            dir.join("tools").join(PROJECT_CONFIG_FILE),
+            "[[overrides]]\npaths = [\"gen/\"]\n[overrides.rules.missing_error_check]\nenabled = false\n",
+        )
+        .unwrap();
+        let rules = |path: &str| {
+            let mut config = AnalyzerConfig::from_str(
+                "[[rules]]\nname = \"panic_usage\"\nquery = \"(identifier) @x\"\nseverity = \"warning\"\nmessage = \"m\"\nenabled = true\n\n[[rules]]\nname = \"missing_error_check\"\nquery = \"(identifier) @x\"\nseverity = \"warning\"\nmessage = \"m\"\nenabled = true\n",
+            )
+            .unwrap();
+            let effective = EffectiveConfig::for_path(dir.join(path)).unwrap();
+            effective.apply(&mut config).unwrap();
+            let states: Vec<_> = config
+                .rules
+                .iter()
+                .map(|rule| (rule.enabled, rule.severity.clone()))
+                .collect();
+            (states, effective.key())
+        };
+
+        let (source, source_key) = rules("pkg/handler.go");
+        assert_eq!(
+            source,
+            [(true, "error".to_string()), (true, "warning".to_string())]
+        );
+        let (test, test_key) = rules("pkg/handler_test.go");
+        assert_eq!(
+            test,
+            [(false, "error".to_string()), (true, "info".to_string())]
+        );
+        assert_ne!(source_key, test_key);
+        // A nested file's patterns are relative to its directory.
+        assert!(!rules("tools/gen/main.go").0[1].0);
+        assert!(rules("gen/main.go").0[1].0);
+
+        fs::write(
+            dir.join(PROJECT_CONFIG_FILE),
+            "[[overrides]]\npaths = [\"*_test.go\"]\nrules = { panic_usage = \"loud\" }\n",
+        )
+        .unwrap();
+        let error = EffectiveConfig::for_path(&dir).unwrap_err().to_string();
+        assert!(error.contains("unknown level 'loud'"), "{}", error);
+        fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_rule_overrides_apply_on_top_of_the_preset() {
+        let dir = scratch_dir("preset");
+        fs::write(
+            dir.join(PROJECT_CONFIG_FILE),
+            "preset = \"strict\"\n[rules.unused_code]\nenabled = false\n",
+        )
+        .unwrap();
+        let rule = |name: &str, severity: &str, enabled: bool| {
+            format!(
+                "[[rules]]\nname = \"{}\"\nquery = \"(identifier) @x\"\nseverity = \"{}\"\nmessage = \"m\"\nenabled = {}\n",
+                name, severity, enabled
+            )
+        };
+        let mut config = AnalyzerConfig::from_str(&format!(
+            "{}{}",
+            rule("unused_code", "info", false),
+            rule("untested_export", "info", false)
+        ))
+        .unwrap();
+
+        let effective = EffectiveConfig::for_path(&dir).unwrap();
+        effective.apply(&mut config).unwrap();
+        let states: Vec<_> = config
+            .rules
+            .iter()
+            .map(|rule| (rule.enabled, rule.severity.as_str()))
+            .collect();
+        assert_eq!(states, [(false, "warning"), (true, "warning")]);
+
+        fs::write(dir.join(PROJECT_CONFIG_FILE), "preset = \"lax\"\n").unwrap();
+        let error = EffectiveConfig::for_path(&dir).unwrap_err().to_string();
+        assert!(error.contains("unknown preset 'lax'"), "{}", error);
+        fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_experimental_rules_wait_to_be_turned_on() {
+        let dir = scratch_dir("experimental");
+        let config = || {
+            AnalyzerConfig::from_str(
+                "[[rules]]\nname = \"big_new_check\"\nquery = \"(identifier) @x\"\nseverity = \"warning\"\nmessage = \"m\"\nstage = \"experimental\"\nenabled = true\n\n[[rules]]\nname = \"other_new_check\"\nquery = \"(identifier) @x\"\nseverity = \"warning\"\nmessage = \"m\"\nstage = \"experimental\"\nenabled = true\n",
+            )
+            .unwrap()
+        };
+        let enabled = |project: &str| {
+            fs::write(dir.join(PROJECT_CONFIG_FILE), project).unwrap();
+            let mut config = config();
+            EffectiveConfig::for_path(&dir)
+                .unwrap()
+                .apply(&mut config)
+                .unwrap();
+            config
+                .rules
+                .iter()
+                .map(|rule| rule.enabled)
+                .collect::<Vec<_>>()
+        };
+
+        assert_eq!(enabled("preset = \"strict\"\n"), [false, false]);
+        assert_eq!(
+            enabled("[rules.big_new_check]\nenabled = true\n"),
+            [true, false]
+        );
+        assert_eq!(enabled("experimental = true\n"), [true, true]);
+        assert_eq!(
+            enabled("experimental = true\n[rules.other_new_check]\nenabled = false\n"),
+            [true, false]
+        );
+        fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_unknown_keys_are_rejected() {
+        let dir = scratch_dir("unknown");
+        fs::write(dir.join(PROJECT_CONFIG_FILE), "exclude_paths = [\"x\"]\n").unwrap();
+        assert!(EffectiveConfig::for_path(&dir).is_err());
+        fs::remove_dir_all(&dir).unwrap();
+    }
+}
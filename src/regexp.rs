@@ -0,0 +1,715 @@
+//! A checker for the patterns Go's `regexp.Compile` accepts.
+//!
+//! Patterns are parsed with the RE2 syntax and Perl flags Go uses, so the
+//! problems found are the errors `regexp.Compile` would return at run
+//! time, with the same wording: unbalanced parentheses and brackets,
+//! repetition operators with nothing to repeat or repeated twice, counts
+//! over 1000, backreferences and lookarounds RE2 doesn't support, and
+//! unknown escapes. Unicode class names aren't checked.
+//!
+//! [`nested_repetition`] finds an unbounded repetition nested in another,
+//! such as `(a+)+`. Go's matcher runs in linear time, so the pattern costs
+//! nothing there, but the inner repetition is redundant and the same
+//! pattern backtracks catastrophically in PCRE-style engines.
+
+/// The largest count `{n,m}` accepts, alone or multiplied through nested
+/// counts.
+const MAX_REPEAT: u32 = 1000;
+
+/// The POSIX classes `[[:name:]]` accepts.
+const POSIX_CLASSES: &[&str] = &[
+    "alnum", "alpha", "ascii", "blank", "cntrl", "digit", "graph", "lower", "print", "punct",
+    "space", "upper", "word", "xdigit",
+];
+
+/// Checks `pattern`, returning `regexp/syntax`'s error for the first
+/// problem, such as ``missing closing ): `(a` ``.
+pub fn lint(pattern: &str) -> Result<(), String> {
+    Parser::new(pattern).parse().map(|_| ())
+}
+
+/// The first unbounded repetition in `pattern` whose operand is itself
+/// little more than an unbounded repetition, as written, such as
+/// `(\w+\s?)+`. Patterns that don't parse have none.
+pub fn nested_repetition(pattern: &str) -> Option<String> {
+    let parser = Parser::new(pattern);
+    let tree = parser.clone().parse().ok()?;
+    let (start, end) = find_nested(&tree)?;
+    Some(parser.text(start, end))
+}
+
+#[derive(Debug)]
+enum Term {
+    /// Matches one character: a literal, class, escape or `.`.
+    Char,
+    /// Matches the empty string: `^`, `$`, `\b` and the like.
+    Empty,
+    Group(Box<Term>),
+    Concat(Vec<Term>),
+    Alternate(Vec<Term>),
+    Repeat {
+        term: Box<Term>,
+        min: u32,
+        max: Option<u32>,
+        /// Written with braces, as `{2,5}`.
+        counted: bool,
+        /// The operand and operator, as character offsets.
+        span: (usize, usize),
+    },
+}
+
+impl Term {
+    fn without_groups(&self) -> &Term {
+        match self {
+            Term::Group(inner) => inner.without_groups(),
+            other => other,
+        }
+    }
+
+    fn matches_empty(&self) -> bool {
+        match self {
+            Term::Char => false,
+            Term::Empty => true,
+            Term::Group(inner) => inner.matches_empty(),
+            Term::Concat(terms) => terms.iter().all(Term::matches_empty),
+            Term::Alternate(terms) => terms.iter().any(Term::matches_empty),
+            Term::Repeat { term, min, .. } => *min == 0 || term.matches_empty(),
+        }
+    }
+
+    fn is_unbounded(&self) -> bool {
+        matches!(self.without_groups(), Term::Repeat { max: None, .. })
+    }
+
+    /// Whether every match of the term is a match of one unbounded
+    /// repetition in it, give or take optional parts: `a+`, `a+b?`.
+    fn is_mostly_repetition(&self) -> bool {
+        match self.without_groups() {
+            Term::Repeat { max: None, .. } => true,
+            Term::Concat(terms) => {
+                let repeated = terms.iter().filter(|term| term.is_unbounded()).count();
+                repeated == 1
+                    && terms
+                        .iter()
+                        .all(|term| term.is_unbounded() || term.matches_empty())
+            }
+            Term::Alternate(terms) => terms.iter().any(Term::is_mostly_repetition),
+            _ => false,
+        }
+    }
+}
+
+fn find_nested(term: &Term) -> Option<(usize, usize)> {
+    match term {
+        Term::Char | Term::Empty => None,
+        Term::Group(inner) => find_nested(inner),
+        Term::Concat(terms) | Term::Alternate(terms) => terms.iter().find_map(find_nested),
+        Term::Repeat {
+            term, max, span, ..
+        } => {
+            if max.is_none() && term.is_mostly_repetition() {
+                return Some(*span);
+            }
+            find_nested(term)
+        }
+    }
+}
+
+/// Whether the counts of `term` and the repetitions inside it multiply to
+/// at most `limit`, as `regexp/syntax` requires.
+fn repeat_is_valid(term: &Term, mut limit: u32) -> bool {
+    match term {
+        Term::Repeat {
+            term,
+            min,
+            max,
+            counted,
+            ..
+        } => {
+            if *counted {
+                let most = max.unwrap_or(*min);
+                if most == 0 && max.is_some() {
+                    return true;
+                }
+                if most > limit {
+                    return false;
+                }
+                if most > 0 {
+                    limit /= most;
+                }
+            }
+            repeat_is_valid(term, limit)
+        }
+        Term::Group(inner) => repeat_is_valid(inner, limit),
+        Term::Concat(terms) | Term::Alternate(terms) => {
+            terms.iter().all(|term| repeat_is_valid(term, limit))
+        }
+        Term::Char | Term::Empty => true,
+    }
+}
+
+#[derive(Clone)]
+struct Parser {
+    chars: Vec<char>,
+    at: usize,
+    names: Vec<String>,
+}
+
+fn error(code: &str, text: &str) -> String {
+    if text.is_empty() {
+        code.to_string()
+    } else {
+        format!("{}: `{}`", code, text)
+    }
+}
+
+impl Parser {
+    fn new(pattern: &str) -> Self {
+        Parser {
+            chars: pattern.chars().collect(),
+            at: 0,
+            names: Vec::new(),
+        }
+    }
+
+    fn text(&self, start: usize, end: usize) -> String {
+        self.chars[start..end.min(self.chars.len())]
+            .iter()
+            .collect()
+    }
+
+    fn whole(&self) -> String {
+        self.text(0, self.chars.len())
+    }
+
+    fn peek(&self) -> Option<char> {
+        self.chars.get(self.at).copied()
+    }
+
+    fn peek_at(&self, offset: usize) -> Option<char> {
+        self.chars.get(self.at + offset).copied()
+    }
+
+    fn starts_with(&self, prefix: &str) -> bool {
+        prefix
+            .chars()
+            .enumerate()
+            .all(|(i, c)| self.peek_at(i) == Some(c))
+    }
+
+    fn parse(mut self) -> Result<Term, String> {
+        let term = self.alternation(0)?;
+        if self.peek() == Some(')') {
+            return Err(error("unexpected )", &self.whole()));
+        }
+        Ok(term)
+    }
+
+    fn alternation(&mut self, depth: usize) -> Result<Term, String> {
+        let mut alternatives = vec![self.concatenation(depth)?];
+        while self.peek() == Some('|') {
+            self.at += 1;
+            alternatives.push(self.concatenation(depth)?);
+        }
+        Ok(match alternatives.len() {
+            1 => alternatives.remove(0),
+            _ => Term::Alternate(alternatives),
+        })
+    }
+
+    fn concatenation(&mut self, depth: usize) -> Result<Term, String> {
+        let mut terms: Vec<(Term, usize)> = Vec::new();
+        // Where the last repetition operator started, so a second one
+        // right after it is caught.
+        let mut last_repeat: Option<usize> = None;
+        while let Some(c) = self.peek() {
+            match c {
+                '|' => break,
+                ')' if depth > 0 => break,
+                ')' => return Err(error("unexpected )", &self.whole())),
+                '*' | '+' | '?' | '{' => {
+                    let start = self.at;
+                    let Some((min, max, counted)) = self.repetition()? else {
+                        // A `{` that isn't a count is a literal.
+                        self.at += 1;
+                        terms.push((Term::Char, start));
+                        last_repeat = None;
+                        continue;
+                    };
+                    let operator = self.text(start, self.at);
+                    if let Some(previous) = last_repeat {
+                        return Err(error(
+                            "invalid nested repetition operator",
+                            &self.text(previous, self.at),
+                        ));
+                    }
+                    let Some((term, term_start)) = terms.pop() else {
+                        return Err(error("missing argument to repetition operator", &operator));
+                    };
+                    let repeat = Term::Repeat {
+                        term: Box::new(term),
+                        min,
+                        max,
+                        counted,
+                        span: (term_start, self.at),
+                    };
+                    if counted && !repeat_is_valid(&repeat, MAX_REPEAT) {
+                        return Err(error("invalid repeat count", &operator));
+                    }
+                    terms.push((repeat, term_start));
+                    last_repeat = Some(start);
+                }
+                _ => {
+                    let start = self.at;
+                    let term = self.atom(depth)?;
+                    terms.push((term, start));
+                    last_repeat = None;
+                }
+            }
+        }
+        let mut terms: Vec<Term> = terms.into_iter().map(|(term, _)| term).collect();
+        Ok(match terms.len() {
+            0 => Term::Empty,
+            1 => terms.remove(0),
+            _ => Term::Concat(terms),
+        })
+    }
+
+    /// A repetition operator at the cursor, with its lazy `?`: `(min, max,
+    /// counted)`. `None` for a `{` that doesn't start a count.
+    fn repetition(&mut self) -> Result<Option<(u32, Option<u32>, bool)>, String> {
+        let start = self.at;
+        let found = match self.peek() {
+            Some('*') => (0, None, false),
+            Some('+') => (1, None, false),
+            Some('?') => (0, Some(1), false),
+            Some('{') => {
+                let Some((min, max, end)) = self.count() else {
+                    return Ok(None);
+                };
+                self.at = end - 1;
+                let too_large = |n: u32| n > MAX_REPEAT;
+                if too_large(min) || max.is_some_and(too_large) || max.is_some_and(|max| max < min)
+                {
+                    return Err(error("invalid repeat count", &self.text(start, end)));
+                }
+                (min, max, true)
+            }
+            _ => return Ok(None),
+        };
+        self.at += 1;
+        if self.peek() == Some('?') {
+            self.at += 1;
+        }
+        Ok(Some(found))
+    }
+
+    /// `{n}`, `{n,}` or `{n,m}` at the cursor: the counts and the offset
+    /// just past the `}`.
+    fn count(&self) -> Option<(u32, Option<u32>, usize)> {
+        let mut at = self.at + 1;
+        let number = |at: &mut usize| {
+            let start = *at;
+            while self.chars.get(*at).is_some_and(char::is_ascii_digit) {
+                *at += 1;
+            }
+            let digits: String = self.chars[start..*at].iter().collect();
+            // Counts too large for u32 are too large for Go as well.
+            (*at > start).then(|| digits.parse().unwrap_or(u32::MAX))
+        };
+        let min = number(&mut at)?;
+        let max = if self.chars.get(at) == Some(&',') {
+            at += 1;
+            if self.chars.get(at) == Some(&'}') {
+                None
+            } else {
+                Some(number(&mut at)?)
+            }
+        } else {
+            Some(min)
+        };
+        (self.chars.get(at) == Some(&'}')).then_some((min, max, at + 1))
+    }
+
+    fn atom(&mut self, depth: usize) -> Result<Term, String> {
+        match self.peek() {
+            Some('(') => self.group(depth),
+            Some('[') => self.class(),
+            Some('^') | Some('$') => {
+                self.at += 1;
+                Ok(Term::Empty)
+            }
+            Some('\\') => self.escape_outside_class(),
+            _ => {
+                self.at += 1;
+                Ok(Term::Char)
+            }
+        }
+    }
+
+    fn group(&mut self, depth: usize) -> Result<Term, String> {
+        let start = self.at;
+        self.at += 1;
+        if self.peek() == Some('?') {
+            if let Some(term) = self.perl_group(start)? {
+                return Ok(term);
+            }
+        }
+        let inner = self.alternation(depth + 1)?;
+        if self.peek() != Some(')') {
+            return Err(error("missing closing )", &self.whole()));
+        }
+        self.at += 1;
+        Ok(Term::Group(Box::new(inner)))
+    }
+
+    /// The part of a `(?` group before its body: a name or flags. `Some`
+    /// for `(?flags)`, which has no body.
+    fn perl_group(&mut self, start: usize) -> Result<Option<Term>, String> {
+        let named = if self.starts_with("?P<") {
+            Some(3)
+        } else if self.starts_with("?<") && !matches!(self.peek_at(2), Some('=') | Some('!')) {
+            Some(2)
+        } else {
+            None
+        };
+        if let Some(skip) = named {
+            let name_start = self.at + skip;
+            let Some(end) = (name_start..self.chars.len()).find(|&i| self.chars[i] == '>') else {
+                return Err(error(
+                    "invalid named capture",
+                    &self.text(start, self.chars.len()),
+                ));
+            };
+            let name = self.text(name_start, end);
+            let valid = !name.is_empty() && name.chars().all(|c| c.is_alphanumeric() || c == '_');
+            if !valid || self.names.contains(&name) {
+                let code = if valid {
+                    "duplicate capture group name"
+                } else {
+                    "invalid named capture"
+                };
+                return Err(error(code, &self.text(start, end + 1)));
+            }
+            self.names.push(name);
+            self.at = end + 1;
+            return Ok(None);
+        }
+
+        // Flags: `(?i)`, `(?-s)`, `(?im-s:...)`.
+        self.at += 1;
+        let mut negated = false;
+        let mut any = false;
+        loop {
+            let Some(c) = self.peek() else {
+                return Err(error("missing closing )", &self.whole()));
+            };
+            self.at += 1;
+            match c {
+                'i' | 'm' | 's' | 'U' => any = true,
+                '-' if !negated => {
+                    negated = true;
+                    any = false;
+                }
+                ':' | ')' if any || !negated => {
+                    if c == ')' {
+                        return Ok(Some(Term::Empty));
+                    }
+                    return Ok(None);
+                }
+                _ => {
+                    return Err(error(
+                        "invalid or unsupported Perl syntax",
+                        &self.text(start, self.at),
+                    ))
+                }
+            }
+        }
+    }
+
+    fn class(&mut self) -> Result<Term, String> {
+        let start = self.at;
+        self.at += 1;
+        if self.peek() == Some('^') {
+            self.at += 1;
+        }
+        let mut first = true;
+        loop {
+            match self.peek() {
+                None => {
+                    return Err(error(
+                        "missing closing ]",
+                        &self.text(start, self.chars.len()),
+                    ))
+                }
+                Some(']') if !first => {
+                    self.at += 1;
+                    return Ok(Term::Char);
+                }
+                Some('[') if self.peek_at(1) == Some(':') => {
+                    let name_start = self.at + 2;
+                    let close = (name_start..self.chars.len().saturating_sub(1))
+                        .find(|&i| self.chars[i] == ':' && self.chars[i + 1] == ']');
+                    match close {
+                        Some(close) => {
+                            let name = self.text(name_start, close);
+                            let name = name.strip_prefix('^').unwrap_or(&name);
+                            if !POSIX_CLASSES.contains(&name) {
+                                return Err(error(
+                                    "invalid character class range",
+                                    &self.text(self.at, close + 2),
+                                ));
+                            }
+                            self.at = close + 2;
+                        }
+                        None => self.at += 1,
+                    }
+                }
+                _ => {
+                    let low_start = self.at;
+                    let low = self.class_char()?;
+                    if self.peek() == Some('-') && !matches!(self.peek_at(1), Some(']') | None) {
+                        self.at += 1;
+                        let high = self.class_char()?;
+                        if let (Some(low), Some(high)) = (low, high) {
+                            if high < low {
+                                return Err(error(
+                                    "invalid character class range",
+                                    &self.text(low_start, self.at),
+                                ));
+                            }
+                        }
+                    }
+                }
+            }
+            first = false;
+        }
+    }
+
+    /// One character of a class, or `None` for a class escape such as
+    /// `\d`, which can't end a range.
+    fn class_char(&mut self) -> Result<Option<char>, String> {
+        let Some(c) = self.peek() else {
+            return Ok(None);
+        };
+        if c != '\\' {
+            self.at += 1;
+            return Ok(Some(c));
+        }
+        match self.peek_at(1) {
+            Some('d' | 'D' | 's' | 'S' | 'w' | 'W') => {
+                self.at += 2;
+                Ok(None)
+            }
+            Some('p' | 'P') => {
+                self.unicode_class()?;
+                Ok(None)
+            }
+            _ => self.escape().map(Some),
+        }
+    }
+
+    fn escape_outside_class(&mut self) -> Result<Term, String> {
+        match self.peek_at(1) {
+            Some('A' | 'b' | 'B' | 'z') => {
+                self.at += 2;
+                Ok(Term::Empty)
+            }
+            Some('d' | 'D' | 's' | 'S' | 'w' | 'W') => {
+                self.at += 2;
+                Ok(Term::Char)
+            }
+            Some('p' | 'P') => {
+                self.unicode_class()?;
+                Ok(Term::Char)
+            }
+            Some('Q') => {
+                self.at += 2;
+                let mut empty = true;
+                while self.peek().is_some() && !self.starts_with("\\E") {
+                    self.at += 1;
+                    empty = false;
+                }
+                if self.peek().is_some() {
+                    self.at += 2;
+                }
+                Ok(if empty { Term::Empty } else { Term::Char })
+            }
+            Some('C') => Err(error("invalid escape sequence", "\\C")),
+            _ => self.escape().map(|_| Term::Char),
+        }
+    }
+
+    /// `\pL`, `\p{Greek}`, `\PN` and the like.
+    fn unicode_class(&mut self) -> Result<(), String> {
+        let start = self.at;
+        self.at += 2;
+        match self.peek() {
+            Some('{') => {
+                let Some(close) = (self.at..self.chars.len()).find(|&i| self.chars[i] == '}')
+                else {
+                    return Err(error(
+                        "invalid character class range",
+                        &self.text(start, self.chars.len()),
+                    ));
+                };
+                self.at = close + 1;
+            }
+            Some(_) => self.at += 1,
+            None => {
+                return Err(error(
+                    "invalid character class range",
+                    &self.text(start, self.at),
+                ))
+            }
+        }
+        Ok(())
+    }
+
+    /// An escape standing for one character: `\n`, `\x41`, `\101`, `\.`.
+    fn escape(&mut self) -> Result<char, String> {
+        let start = self.at;
+        self.at += 1;
+        let Some(c) = self.peek() else {
+            return Err(error("trailing backslash at end of expression", ""));
+        };
+        self.at += 1;
+        let invalid = |parser: &Parser| {
+            Err(error(
+                "invalid escape sequence",
+                &parser.text(start, parser.at),
+            ))
+        };
+        if c.is_ascii_punctuation() {
+            return Ok(c);
+        }
+        match c {
+            '1'..='7' if !self.peek().is_some_and(|next| ('0'..='7').contains(&next)) => {
+                // A backreference, which RE2 doesn't support.
+                invalid(self)
+            }
+            '0'..='7' => {
+                let mut value = c.to_digit(8).unwrap_or(0);
+                for _ in 0..2 {
+                    match self.peek().and_then(|next| next.to_digit(8)) {
+                        Some(digit) => {
+                            value = value * 8 + digit;
+                            self.at += 1;
+                        }
+                        None => break,
+                    }
+                }
+                Ok(char::from_u32(value).unwrap_or('\0'))
+            }
+            'x' => {
+                if self.peek() == Some('{') {
+                    let digits_start = self.at + 1;
+                    let Some(close) =
+                        (digits_start..self.chars.len()).find(|&i| self.chars[i] == '}')
+                    else {
+                        self.at = self.chars.len();
+                        return invalid(self);
+                    };
+                    self.at = close + 1;
+                    let digits = self.text(digits_start, close);
+                    match u32::from_str_radix(&digits, 16)
+                        .ok()
+                        .and_then(char::from_u32)
+                    {
+                        Some(value) if !digits.is_empty() => Ok(value),
+                        _ => invalid(self),
+                    }
+                } else {
+                    let digits: String = (0..2).filter_map(|i| self.peek_at(i)).collect();
+                    match u32::from_str_radix(&digits, 16) {
+                        Ok(value)
+                            if digits.len() == 2
+                                && digits.chars().all(|c| c.is_ascii_hexdigit()) =>
+                        {
+                            self.at += 2;
+                            Ok(char::from_u32(value).unwrap_or('\0'))
+                        }
+                        _ => {
+                            self.at = (self.at + digits.len()).min(self.chars.len());
+                            invalid(self)
+                        }
+                    }
+                }
+            }
+            'a' => Ok('\u{7}'),
+            'f' => Ok('\u{c}'),
+            'n' => Ok('\n'),
+            'r' => Ok('\r'),
+            't' => Ok('\t'),
+            'v' => Ok('\u{b}'),
+            _ => invalid(self),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_lint_reports_what_regexp_compile_rejects() {
+        for valid in [
+            r"^[a-z]+\d{2,4}$",
+            r"(?i)hello|(?P<name>\w+)",
+            r"(?<year>\d{4})-(?:0[1-9]|1[0-2])",
+            r"[]a]|[^]-]|[[:alpha:]_]",
+            r"a{,2}|\{1}|x{",
+            r"\x41\x{1F600}\101\.\Qa+b\E",
+            r"a*?b+?c??",
+            r"\pL\p{Greek}",
+        ] {
+            assert_eq!(lint(valid), Ok(()), "{}", valid);
+        }
+
+        for (pattern, problem) in [
+            (r"(abc", "missing closing ): `(abc`"),
+            (r"abc)", "unexpected ): `abc)`"),
+            (r"[a-z", "missing closing ]: `[a-z`"),
+            (r"*a", "missing argument to repetition operator: `*`"),
+            (r"a|+", "missing argument to repetition operator: `+`"),
+            (r"a**", "invalid nested repetition operator: `**`"),
+            (r"a{2}{3}", "invalid nested repetition operator: `{2}{3}`"),
+            (r"a{1001}", "invalid repeat count: `{1001}`"),
+            (r"a{3,2}", "invalid repeat count: `{3,2}`"),
+            (r"(a{100}){100}", "invalid repeat count: `{100}`"),
+            (r"(a)\1", "invalid escape sequence: `\\1`"),
+            (r"\y", "invalid escape sequence: `\\y`"),
+            (r"a\", "trailing backslash at end of expression"),
+            (r"[z-a]", "invalid character class range: `z-a`"),
+            (
+                r"[[:word:][:foo:]]",
+                "invalid character class range: `[:foo:]`",
+            ),
+            (r"a(?=b)", "invalid or unsupported Perl syntax: `(?=`"),
+            (r"(?<!a)b", "invalid or unsupported Perl syntax: `(?<`"),
+            (r"(?P<a-b>x)", "invalid named capture: `(?P<a-b>`"),
+            (
+                r"(?P<a>x)(?P<a>y)",
+                "duplicate capture group name: `(?P<a>`",
+            ),
+        ] {
+            assert_eq!(lint(pattern), Err(problem.to_string()), "{}", pattern);
+        }
+    }
+
+    #[test]
+    fn test_nested_repetition() {
+        assert_eq!(nested_repetition(r"^(a+)+$").as_deref(), Some("(a+)+"));
+        assert_eq!(
+            nested_repetition(r"(\w+\s?)*!").as_deref(),
+            Some(r"(\w+\s?)*")
+        );
+        assert_eq!(
+            nested_repetition(r"(?:x|y+)+").as_deref(),
+            Some("(?:x|y+)+")
+        );
+        assert_eq!(nested_repetition(r"(ab+c)+"), None);
+        assert_eq!(nested_repetition(r"(a+){2}"), None);
+        assert_eq!(nested_repetition(r"\d+(\.\d+)*"), None);
+    }
+}
@@ -0,0 +1,370 @@
+//! Fixture tests for rules, in the style of Go's `analysistest`.
+//!
+//! A fixture is an ordinary source file whose comments say what the rules
+//! should report. `// want "message"` on a line expects exactly one finding
+//! starting on that line with that message. A comment can hold several
+//! expectations, and each one can name the rule and the finding's columns:
+//!
+//! ```text
+//! result, err := doSomething() // want missing_error_check "`err` is assigned but never checked"@13-16
+//! ```
+//!
+//! `@13` is the start column, `@13-16` adds the end column on the same line
+//! and `@13-9:2` an end on another line. Columns count from 1, as in
+//! compass's output. Every finding must be expected, and every expectation
+//! found. When `<fixture>.golden` exists, it holds the fixture, comments
+//! included, after every fix is applied.
+//!
+//! ```no_run
+//! use compass::config::AnalyzerConfig;
+//! use compass::ruletest::RuleTest;
+//!
+//! let config = AnalyzerConfig::from_file("mycorp.toml").unwrap();
+//! let mut analyzer = config.to_analyzer();
+//! analyzer.set_registry(mycorp::registry());
+//! RuleTest::new(&analyzer)
+//!     .rules(&["mycorp_no_goto"])
+//!     .run("tests/fixtures/goto.go");
+//! # mod mycorp { pub fn registry() -> compass::plugin::Registry { Default::default() } }
+//! ```
+
+use crate::analyzer::{AnalysisResult, CodeAnalyzer};
+use crate::fix::apply_fixes;
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use std::fs;
+use std::path::Path;
+
+pub struct RuleTest<'a> {
+    analyzer: &'a CodeAnalyzer,
+    rules: Vec<String>,
+}
+
+/// One `want` expectation.
+#[derive(Debug, PartialEq)]
+struct Expectation {
+    line: usize,
+    rule: Option<String>,
+    message: String,
+    /// `(column, end_line, end_column)`, each when given.
+    range: Option<(usize, Option<usize>, Option<usize>)>,
+}
+
+impl Expectation {
+    fn matches(&self, result: &AnalysisResult) -> bool {
+        if result.line != self.line || result.message != self.message {
+            return false;
+        }
+        if self
+            .rule
+            .as_ref()
+            .is_some_and(|rule| *rule != result.rule_name)
+        {
+            return false;
+        }
+        let Some((column, end_line, end_column)) = self.range else {
+            return true;
+        };
+        column == result.column
+            && end_line.unwrap_or(result.end_line) == result.end_line
+            && end_column.unwrap_or(result.end_column) == result.end_column
+    }
+}
+
+impl<'a> RuleTest<'a> {
+    pub fn new(analyzer: &'a CodeAnalyzer) -> Self {
+        RuleTest {
+            analyzer,
+            rules: Vec::new(),
+        }
+    }
+
+    /// Only checks the findings of these rules; the others are ignored.
+    pub fn rules(mut self, rules: &[&str]) -> Self {
+        self.rules = rules.iter().map(|rule| rule.to_string()).collect();
+        self
+    }
+
+    /// Checks the fixture at `path`, panicking with every mismatch, and
+    /// returns the findings for further assertions.
+    pub fn run<P: AsRef<Path>>(&self, path: P) -> Vec<AnalysisResult> {
+        let path = path.as_ref();
+        match self.check(path) {
+            Ok(results) => results,
+            Err(problems) => panic!("{}:\n{}", path.display(), problems),
+        }
+    }
+
+    /// Like [`RuleTest::run`], returning the mismatches, one per line,
+    /// instead of panicking.
+    pub fn check<P: AsRef<Path>>(&self, path: P) -> Result<Vec<AnalysisResult>, String> {
+        let path = path.as_ref();
+        let source = fs::read_to_string(path)
+            .map_err(|e| format!("can't read {}: {}", path.display(), e))?;
+        let language = SupportedLanguage::from_path(&path.to_string_lossy())
+            .ok_or_else(|| format!("no language for {}", path.display()))?;
+        let package = if self.analyzer.reads_package() {
+            Package::load(path).ok()
+        } else {
+            None
+        };
+        let results: Vec<AnalysisResult> = self
+            .analyzer
+            .analyze_in_package(&source, &language.tree_sitter_language(), package.as_ref())
+            .map_err(|e| format!("analysis failed: {}", e))?
+            .into_iter()
+            .filter(|result| self.rules.is_empty() || self.rules.contains(&result.rule_name))
+            .collect();
+
+        let expectations = expectations(&source)?;
+        let mut problems = Vec::new();
+        let mut unmatched: Vec<&AnalysisResult> = results.iter().collect();
+        for expectation in &expectations {
+            match unmatched
+                .iter()
+                .position(|result| expectation.matches(result))
+            {
+                Some(i) => {
+                    unmatched.remove(i);
+                }
+                None => problems.push((
+                    expectation.line,
+                    format!(
+                        "no finding {:?}{}",
+                        expectation.message,
+                        describe(expectation)
+                    ),
+                )),
+            }
+        }
+        for result in unmatched {
+            let end = if result.end_line == result.line {
+                result.end_column.to_string()
+            } else {
+                format!("{}:{}", result.end_line, result.end_column)
+            };
+            problems.push((
+                result.line,
+                format!(
+                    "unexpected finding: want {} {:?}@{}-{}",
+                    result.rule_name, result.message, result.column, end
+                ),
+            ));
+        }
+        problems.sort_by_key(|(line, _)| *line);
+        let mut problems: Vec<String> = problems
+            .into_iter()
+            .map(|(line, problem)| format!("line {}: {}", line, problem))
+            .collect();
+
+        let golden = path.with_file_name(format!(
+            "{}.golden",
+            path.file_name().unwrap_or_default().to_string_lossy()
+        ));
+        if let Ok(expected) = fs::read_to_string(&golden) {
+            let fixed = apply_fixes(&source, &results).source;
+            if let Some(problem) = compare(&expected, &fixed) {
+                problems.push(format!("{}: {}", golden.display(), problem));
+            }
+        }
+
+        if problems.is_empty() {
+            Ok(results)
+        } else {
+            Err(problems.join("\n"))
+        }
+    }
+}
+
+fn describe(expectation: &Expectation) -> String {
+    let mut text = String::new();
+    if let Some(rule) = &expectation.rule {
+        text.push_str(&format!(" from {}", rule));
+    }
+    if let Some((column, end_line, end_column)) = expectation.range {
+        text.push_str(&format!(" at column {}", column));
+        match (end_line, end_column) {
+            (Some(line), Some(end)) => text.push_str(&format!(" to {}:{}", line, end)),
+            (None, Some(end)) => text.push_str(&format!(" to column {}", end)),
+            _ => {}
+        }
+    }
+    text
+}
+
+/// The first line where the fixed source differs from the golden file.
+fn compare(expected: &str, actual: &str) -> Option<String> {
+    if expected == actual {
+        return None;
+    }
+    let mut expected_lines = expected.lines();
+    let mut actual_lines = actual.lines();
+    let mut line = 1;
+    loop {
+        match (expected_lines.next(), actual_lines.next()) {
+            (None, None) => return Some("the files differ in their line endings".to_string()),
+            (Some(want), Some(got)) if want == got => line += 1,
+            (want, got) => {
+                return Some(format!(
+                    "line {} after fixes is {:?}, want {:?}",
+                    line,
+                    got.unwrap_or("<end of file>"),
+                    want.unwrap_or("<end of file>")
+                ))
+            }
+        }
+    }
+}
+
+/// The `want` expectations in the fixture's `//` comments.
+fn expectations(source: &str) -> Result<Vec<Expectation>, String> {
+    let mut expectations = Vec::new();
+    for (i, text) in source.lines().enumerate() {
+        let line = i + 1;
+        let Some(rest) = text
+            .find("// want ")
+            .map(|at| &text[at + "// want ".len()..])
+        else {
+            continue;
+        };
+        parse_want(rest, line, &mut expectations)
+            .map_err(|problem| format!("line {}: bad `want` comment: {}", line, problem))?;
+    }
+    Ok(expectations)
+}
+
+fn parse_want(
+    mut rest: &str,
+    line: usize,
+    expectations: &mut Vec<Expectation>,
+) -> Result<(), String> {
+    loop {
+        rest = rest.trim_start();
+        if rest.is_empty() {
+            return Ok(());
+        }
+        let mut rule = None;
+        if !rest.starts_with('"') {
+            let end = rest.find(char::is_whitespace).unwrap_or(rest.len());
+            rule = Some(rest[..end].to_string());
+            rest = rest[end..].trim_start();
+        }
+        let (message, after) = quoted(rest)?;
+        rest = after;
+        let mut range = None;
+        if let Some(spec) = rest.strip_prefix('@') {
+            let end = spec.find(char::is_whitespace).unwrap_or(spec.len());
+            range = Some(parse_range(&spec[..end])?);
+            rest = &spec[end..];
+        }
+        expectations.push(Expectation {
+            line,
+            rule,
+            message,
+            range,
+        });
+    }
+}
+
+/// A leading `"..."` with `\"` and `\\` escapes, and what follows it.
+fn quoted(text: &str) -> Result<(String, &str), String> {
+    let Some(body) = text.strip_prefix('"') else {
+        return Err(format!("expected a quoted message at {:?}", text));
+    };
+    let mut message = String::new();
+    let mut chars = body.char_indices();
+    while let Some((i, c)) = chars.next() {
+        match c {
+            '"' => return Ok((message, &body[i + 1..])),
+            '\\' => match chars.next() {
+                Some((_, escaped)) => message.push(escaped),
+                None => break,
+            },
+            c => message.push(c),
+        }
+    }
+    Err("unterminated message".to_string())
+}
+
+/// `C`, `C-C` or `C-L:C`.
+fn parse_range(spec: &str) -> Result<(usize, Option<usize>, Option<usize>), String> {
+    let number = |text: &str| {
+        text.parse::<usize>()
+            .map_err(|_| format!("expected a range like @5, @5-12 or @5-7:2, not @{}", spec))
+    };
+    let (start, end) = match spec.split_once('-') {
+        Some((start, end)) => (start, Some(end)),
+        None => (spec, None),
+    };
+    let column = number(start)?;
+    match end.map(|end| end.split_once(':')) {
+        None => Ok((column, None, None)),
+        Some(None) => Ok((column, None, Some(number(end.unwrap_or_default())?))),
+        Some(Some((line, end_column))) => {
+            Ok((column, Some(number(line)?), Some(number(end_column)?)))
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_want_comments_are_parsed() {
+        let source = "a() // want \"first\" panic_usage \"say \\\"hi\\\"\"@5-12\nb()\nc() // want \"x\"@3-4:2 \"y\"@7\n";
+        let found = expectations(source).unwrap();
+        assert_eq!(
+            found,
+            [
+                Expectation {
+                    line: 1,
+                    rule: None,
+                    message: "first".to_string(),
+                    range: None,
+                },
+                Expectation {
+                    line: 1,
+                    rule: Some("panic_usage".to_string()),
+                    message: "say \"hi\"".to_string(),
+                    range: Some((5, None, Some(12))),
+                },
+                Expectation {
+                    line: 3,
+                    rule: None,
+                    message: "x".to_string(),
+                    range: Some((3, Some(4), Some(2))),
+                },
+                Expectation {
+                    line: 3,
+                    rule: None,
+                    message: "y".to_string(),
+                    range: Some((7, None, None)),
+                },
+            ]
+        );
+
+        assert!(expectations("x // want \"open\n")
+            .unwrap_err()
+            .contains("unterminated"));
+        assert!(expectations("x // want \"m\"@a\n")
+            .unwrap_err()
+            .contains("line 1"));
+        assert!(expectations("x // want rule\n")
+            .unwrap_err()
+            .contains("quoted message"));
+    }
+
+    #[test]
+    fn test_golden_mismatch_names_the_line() {
+        assert_eq!(compare("a\nb\n", "a\nb\n"), None);
+        assert_eq!(
+            compare("a\nb\n", "a\nc\n").unwrap(),
+            "line 2 after fixes is \"c\", want \"b\""
+        );
+        assert_eq!(
+            compare("a\n", "a\nb\n").unwrap(),
+            "line 2 after fixes is \"b\", want \"<end of file>\""
+        );
+    }
+}
@@ -0,0 +1,324 @@
+//! `compass scan`: one report across many repositories.
+//!
+//! Each repository is a git URL, cloned shallowly under a checkout
+//! directory the first time and brought up to date by a shallow fetch on
+//! later runs, or a local directory, which is scanned where it is. A list
+//! names one per line, optionally followed by the branch or tag to check
+//! out; blank lines and lines starting with `#` are skipped:
+//!
+//! ```text
+//! # Payments
+//! https://github.com/acme/billing.git
+//! git@github.com:acme/ledger.git release-2.4
+//! ../gateway
+//! ```
+//!
+//! A repository that can't be cloned or updated is reported with the error
+//! and the rest are still scanned.
+
+use crate::diff::git_in;
+use crate::format::json::{to_report, Finding};
+use crate::format::FileFindings;
+use crate::history::Snapshot;
+use serde_json::{json, Value};
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+pub const DEFAULT_CHECKOUT_DIR: &str = ".compass/repos";
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Repo {
+    /// The URL or directory, as written.
+    pub source: String,
+    /// The branch or tag to check out, or the remote's default branch.
+    pub revision: Option<String>,
+    /// How the report names it: the host and path of a URL, such as
+    /// `github.com/acme/billing`, or the directory.
+    pub name: String,
+}
+
+impl Repo {
+    pub fn new(source: &str, revision: Option<&str>) -> Repo {
+        Repo {
+            source: source.to_string(),
+            revision: revision.map(str::to_string),
+            name: name(source),
+        }
+    }
+
+    /// Reads a list of repositories, one per line.
+    pub fn parse_list(text: &str) -> Result<Vec<Repo>, String> {
+        let mut repos = Vec::new();
+        for (index, line) in text.lines().enumerate() {
+            let line = line.trim();
+            if line.is_empty() || line.starts_with('#') {
+                continue;
+            }
+            let mut fields = line.split_whitespace();
+            let source = fields.next().expect("the line isn't blank");
+            let revision = fields.next();
+            if fields.next().is_some() {
+                return Err(format!(
+                    "line {}: expected a repository and an optional branch or tag, got '{}'",
+                    index + 1,
+                    line
+                ));
+            }
+            repos.push(Repo::new(source, revision));
+        }
+        Ok(repos)
+    }
+
+    /// Whether the repository is cloned rather than scanned in place: a URL
+    /// with a scheme, or `[user@]host:path` as scp and git write it.
+    pub fn is_remote(&self) -> bool {
+        is_remote(&self.source)
+    }
+
+    /// Clones or updates the repository under `dir`, and returns where it is
+    /// checked out.
+    pub fn checkout(&self, dir: &Path) -> Result<PathBuf, String> {
+        if !self.is_remote() {
+            let path = PathBuf::from(&self.source);
+            if !path.is_dir() {
+                return Err(format!("'{}' is not a directory", self.source));
+            }
+            return Ok(path);
+        }
+
+        // The name comes from the URL, so it could climb out of `dir` and
+        // have a working tree elsewhere reset and cleaned.
+        if self.name.is_empty()
+            || self
+                .name
+                .split(['/', '\\'])
+                .any(|part| part.is_empty() || part == "." || part == "..")
+        {
+            return Err(format!(
+                "'{}' doesn't name a directory under {}",
+                self.source,
+                dir.display()
+            ));
+        }
+        let target = dir.join(&self.name);
+        if target.join(".git").exists() {
+            let revision = self.revision.as_deref().unwrap_or("HEAD");
+            git_in(
+                &target,
+                &["fetch", "--quiet", "--depth", "1", "origin", revision],
+            )?;
+            git_in(
+                &target,
+                &["checkout", "--quiet", "--force", "--detach", "FETCH_HEAD"],
+            )?;
+            // Nothing a previous run left behind is analyzed.
+            git_in(&target, &["clean", "--quiet", "-ffdx"])?;
+        } else {
+            if let Some(parent) = target.parent() {
+                fs::create_dir_all(parent)
+                    .map_err(|e| format!("failed to create '{}': {}", parent.display(), e))?;
+            }
+            let target = target.to_string_lossy();
+            let mut args = vec!["clone", "--quiet", "--depth", "1"];
+            if let Some(revision) = &self.revision {
+                args.extend(["--branch", revision]);
+            }
+            args.extend(["--", &self.source, &target]);
+            git_in(Path::new("."), &args)?;
+        }
+        Ok(target)
+    }
+}
+
+fn is_remote(source: &str) -> bool {
+    if source.contains("://") {
+        return true;
+    }
+    // `host:path`, with no slash before the colon, unlike `./a:b`.
+    source
+        .split_once(':')
+        .is_some_and(|(host, _)| !host.is_empty() && !host.contains('/') && host.len() > 1)
+}
+
+fn name(source: &str) -> String {
+    if !is_remote(source) {
+        return source.trim_end_matches('/').to_string();
+    }
+    let rest = match source.split_once("://") {
+        Some((_, rest)) => rest.to_string(),
+        None => source.replacen(':', "/", 1),
+    };
+    let rest = rest.trim_end_matches('/');
+    let (host, path) = rest.split_once('/').unwrap_or((rest, ""));
+    // Credentials and ports aren't part of the name.
+    let host = host.rsplit_once('@').map_or(host, |(_, host)| host);
+    let host = host.split(':').next().unwrap_or(host);
+    // `file:///srv/git/billing` has no host.
+    [host, path.trim_end_matches(".git")]
+        .into_iter()
+        .filter(|part| !part.is_empty())
+        .collect::<Vec<_>>()
+        .join("/")
+}
+
+/// What scanning one repository found.
+pub struct Scanned {
+    pub repo: Repo,
+    /// The checked out `HEAD`, when the repository is a git checkout.
+    pub commit: Option<String>,
+    /// The findings of each file, with paths relative to the repository,
+    /// and the number of lines analyzed; or why it couldn't be scanned.
+    pub outcome: Result<(Vec<FileFindings>, usize), String>,
+}
+
+/// The cross-repository report: each repository's score and findings per
+/// rule, and each rule's findings per repository. With `findings`, every
+/// repository also lists its findings as `--format json` does.
+pub fn report(scanned: &[Scanned], findings: bool) -> Value {
+    let mut rules: BTreeMap<&str, BTreeMap<&str, usize>> = BTreeMap::new();
+    let mut repos = Vec::new();
+    let mut total = 0;
+    let mut failed = 0;
+    for scanned in scanned {
+        let name = scanned.repo.name.as_str();
+        let mut entry = json!({ "name": name, "source": scanned.repo.source });
+        if let Some(revision) = &scanned.repo.revision {
+            entry["revision"] = json!(revision);
+        }
+        if let Some(commit) = &scanned.commit {
+            entry["commit"] = json!(commit);
+        }
+        let (files, lines) = match &scanned.outcome {
+            Ok(outcome) => outcome,
+            Err(e) => {
+                failed += 1;
+                entry["error"] = json!(e);
+                repos.push(entry);
+                continue;
+            }
+        };
+        let results = files.iter().flat_map(|file| &file.results);
+        let snapshot = Snapshot::new(results.clone(), files.len(), *lines);
+        let mut counts: BTreeMap<&str, usize> = BTreeMap::new();
+        for result in results {
+            *counts.entry(result.rule_name.as_str()).or_default() += 1;
+            *rules
+                .entry(result.rule_name.as_str())
+                .or_default()
+                .entry(name)
+                .or_default() += 1;
+        }
+        let issues: usize = counts.values().sum();
+        total += issues;
+        entry["score"] = json!(snapshot.score);
+        entry["files"] = json!(snapshot.files);
+        entry["lines"] = json!(snapshot.lines);
+        entry["total_issues"] = json!(issues);
+        entry["errors"] = json!(snapshot.errors);
+        entry["warnings"] = json!(snapshot.warnings);
+        entry["info"] = json!(snapshot.info);
+        entry["style"] = json!(snapshot.style);
+        entry["rules"] = json!(counts);
+        if findings {
+            let findings: Vec<Finding> = to_report(files).findings;
+            entry["findings"] = json!(findings);
+        }
+        repos.push(entry);
+    }
+
+    let rules: serde_json::Map<String, Value> = rules
+        .into_iter()
+        .map(|(rule, repos)| {
+            let total: usize = repos.values().sum();
+            (rule.to_string(), json!({ "total": total, "repos": repos }))
+        })
+        .collect();
+    json!({
+        "repos": repos,
+        "rules": rules,
+        "total_issues": total,
+        "failed": failed
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analyzer::AnalysisResult;
+
+    #[test]
+    fn test_repo_lists_and_cross_repo_report() {
+        let repos = Repo::parse_list(
+            "# Payments\n\
+             https://github.com/acme/billing.git\n\
+             \n\
+             git@github.com:acme/ledger.git release-2.4\n\
+             ssh://git@gitlab.example.com:2222/team/gateway/\n\
+             ../local/\n",
+        )
+        .unwrap();
+        let names: Vec<_> = repos.iter().map(|repo| repo.name.as_str()).collect();
+        assert_eq!(
+            names,
+            [
+                "github.com/acme/billing",
+                "github.com/acme/ledger",
+                "gitlab.example.com/team/gateway",
+                "../local"
+            ]
+        );
+        assert_eq!(repos[1].revision.as_deref(), Some("release-2.4"));
+        assert!(repos[2].is_remote() && !repos[3].is_remote());
+        assert!(Repo::parse_list("https://a/b main extra\n").is_err());
+
+        // Checkouts stay under their directory, before git is run at all.
+        let checkouts = Path::new("checkouts-that-are-never-created");
+        for source in [
+            "https://example.com/../../..",
+            "https://example.com/acme/./billing",
+            "git@example.com:acme//billing",
+            "file:///",
+        ] {
+            let error = Repo::new(source, None).checkout(checkouts).unwrap_err();
+            assert!(error.contains("doesn't name a directory"), "{}", error);
+        }
+        assert!(!checkouts.exists());
+
+        let finding = |rule: &str| AnalysisResult {
+            rule_name: rule.to_string(),
+            ..Default::default()
+        };
+        let scanned = [
+            Scanned {
+                repo: repos[0].clone(),
+                commit: Some("abc123".to_string()),
+                outcome: Ok((
+                    vec![FileFindings {
+                        path: "main.go".to_string(),
+                        module: None,
+                        owners: Vec::new(),
+                        results: vec![finding("panic_usage"), finding("panic_usage")],
+                    }],
+                    100,
+                )),
+            },
+            Scanned {
+                repo: repos[1].clone(),
+                commit: None,
+                outcome: Err("git clone failed: not found".to_string()),
+            },
+        ];
+        let report = report(&scanned, false);
+        assert_eq!(report["total_issues"], 2);
+        assert_eq!(report["failed"], 1);
+        assert_eq!(report["repos"][0]["rules"]["panic_usage"], 2);
+        assert_eq!(report["repos"][0]["commit"], "abc123");
+        assert!(report["repos"][0].get("findings").is_none());
+        assert_eq!(report["repos"][1]["error"], "git clone failed: not found");
+        assert_eq!(
+            report["rules"]["panic_usage"],
+            json!({ "total": 2, "repos": { "github.com/acme/billing": 2 } })
+        );
+    }
+}
@@ -0,0 +1,113 @@
+//! Limiting a run to the files git history says were touched recently, or
+//! by some authors, for cleanups such as "fix everything changed this
+//! sprint".
+//!
+//! `--since` takes any date git understands, such as `2.weeks`,
+//! `yesterday` or `2024-05-01`, and `--author` a name or email, matched as
+//! `git log --author` matches it; several authors match any of them. A file
+//! is in scope when a commit reachable from `HEAD` that matches changed it.
+//! Uncommitted changes, including new files git doesn't ignore, count as
+//! touched now, unless authors are given: they aren't anyone's yet.
+
+use crate::diff::git_in;
+use std::collections::BTreeSet;
+use std::path::{Path, PathBuf};
+
+#[derive(Debug, Clone, Default)]
+pub struct Scope {
+    pub since: Option<String>,
+    pub authors: Vec<String>,
+}
+
+impl Scope {
+    /// Whether the run is limited at all.
+    pub fn is_empty(&self) -> bool {
+        self.since.is_none() && self.authors.is_empty()
+    }
+
+    /// The files under `dir` in scope, as `dir` joined with their path
+    /// below it, including some that no longer exist.
+    pub fn files(&self, dir: &Path) -> Result<BTreeSet<PathBuf>, String> {
+        // Paths are listed as they are, not quoted when they aren't ASCII.
+        let mut args = vec![
+            "-c".to_string(),
+            "core.quotePath=false".to_string(),
+            "log".to_string(),
+            "--name-only".to_string(),
+            "--relative".to_string(),
+            "--no-renames".to_string(),
+            "--format=".to_string(),
+        ];
+        if let Some(since) = &self.since {
+            args.push(format!("--since={}", since));
+        }
+        args.extend(
+            self.authors
+                .iter()
+                .map(|author| format!("--author={}", author)),
+        );
+        args.extend(["--".to_string(), ".".to_string()]);
+        let args: Vec<&str> = args.iter().map(String::as_str).collect();
+
+        // A repository without commits has no history, and nothing to diff
+        // the working tree against.
+        let has_head = git_in(dir, &["rev-parse", "--verify", "--quiet", "HEAD"]).is_ok();
+        let mut listed = String::new();
+        if has_head {
+            listed += &git_in(dir, &args)?;
+        }
+        if self.authors.is_empty() {
+            if has_head {
+                listed += &git_in(
+                    dir,
+                    &[
+                        "-c",
+                        "core.quotePath=false",
+                        "diff",
+                        "--name-only",
+                        "--relative",
+                        "HEAD",
+                    ],
+                )?;
+            }
+            listed += &git_in(
+                dir,
+                &[
+                    "-c",
+                    "core.quotePath=false",
+                    "ls-files",
+                    "--others",
+                    "--exclude-standard",
+                ],
+            )?;
+        }
+        Ok(listed
+            .lines()
+            .filter(|line| !line.is_empty())
+            .map(|line| dir.join(line))
+            .collect())
+    }
+}
+
+/// The directory named by a Go package pattern such as `./pkg/...`, which
+/// means it and everything below it, or `None` for any other path.
+pub fn package_pattern(path: &str) -> Option<&str> {
+    match path {
+        "..." => Some("."),
+        _ => path.strip_suffix("/..."),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_package_patterns_name_directories() {
+        assert_eq!(package_pattern("./pkg/..."), Some("./pkg"));
+        assert_eq!(package_pattern("./..."), Some("."));
+        assert_eq!(package_pattern("..."), Some("."));
+        assert_eq!(package_pattern("./pkg"), None);
+        assert_eq!(package_pattern("main.go"), None);
+    }
+}
@@ -0,0 +1,555 @@
+//! `compass serve`: a long-lived analysis server for build systems and
+//! tools that would otherwise start compass once per target.
+//!
+//! The API is JSON over HTTP/1.1, read and written by hand on a plain TCP
+//! socket like `compass lsp` frames its messages, so it needs no extra
+//! dependencies. Loaded configs and the findings of the last
+//! [`MAX_FILES`] files analyzed are kept between requests; a file whose
+//! contents, package and rules haven't changed since is answered from
+//! memory.
+//!
+//! - `GET /health`: `{"status": "ok", "files": N}`.
+//! - `POST /analyze` with `{"path": "..."}` or `{"paths": [...]}`: analyzes
+//!   the files and directories given and returns their JSON report.
+//! - `GET /findings?path=...`: the report of cached findings under `path`,
+//!   or of every file when it is left out, without analyzing anything.
+//! - `POST /invalidate`, optionally with `{"path": "..."}`: forgets the
+//!   findings under `path`, or everything, configs included, without one.
+//!
+//! Reports carry an `errors` list for files that couldn't be analyzed.
+
+use crate::analyzer::AnalysisResult;
+use crate::cache::Cache;
+use crate::format::{json, FileFindings};
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use crate::plugin::Registry;
+use crate::project::{ConfigCache, EffectiveConfig};
+use crate::walk;
+use serde_json::{json, Value};
+use std::collections::BTreeMap;
+use std::fs;
+use std::io::{self, BufRead, BufReader, ErrorKind, Read, Write};
+use std::net::TcpListener;
+use std::path::{Path, PathBuf};
+use std::time::Duration;
+
+/// Where `compass serve` listens unless `--listen` says otherwise.
+pub const DEFAULT_ADDRESS: &str = "127.0.0.1:7878";
+
+/// Request bodies larger than this are refused rather than read.
+const MAX_BODY: usize = 1 << 20;
+
+/// Request and header lines longer than this are refused, and so are
+/// requests with more than [`MAX_HEADERS`] headers.
+const MAX_LINE: usize = 8 << 10;
+const MAX_HEADERS: usize = 100;
+
+/// How many files' findings are kept. Past it, those analyzed least
+/// recently are forgotten.
+const MAX_FILES: usize = 50_000;
+
+/// How long a client may take to send its request or read the answer.
+/// Connections are answered one at a time, so one that stalls would
+/// otherwise hold up every other.
+const CONNECTION_TIMEOUT: Duration = Duration::from_secs(30);
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Request {
+    pub method: String,
+    pub path: String,
+    pub query: BTreeMap<String, String>,
+    pub body: Value,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Response {
+    pub status: u16,
+    pub body: Value,
+}
+
+struct CachedFile {
+    /// What the findings were computed from, as [`Cache::key`] hashes it;
+    /// `None` when they depend on more than the package and are always
+    /// recomputed.
+    key: Option<String>,
+    results: Vec<AnalysisResult>,
+    /// When the file was last analyzed, in requests since the server started.
+    analyzed: u64,
+}
+
+pub struct Server {
+    configs: ConfigCache,
+    files: BTreeMap<PathBuf, CachedFile>,
+    max_files: usize,
+    requests: u64,
+}
+
+impl Server {
+    pub fn new(config_override: Option<String>, registry: Registry) -> Self {
+        Server {
+            configs: ConfigCache::new(config_override, registry),
+            files: BTreeMap::new(),
+            max_files: MAX_FILES,
+            requests: 0,
+        }
+    }
+
+    /// Answers connections one at a time, each with a single request, until
+    /// the listener fails. A client that doesn't finish its request within
+    /// [`CONNECTION_TIMEOUT`] gets a 408.
+    pub fn serve(&mut self, listener: TcpListener) -> io::Result<()> {
+        for stream in listener.incoming() {
+            let mut stream = stream?;
+            if stream.set_read_timeout(Some(CONNECTION_TIMEOUT)).is_err()
+                || stream.set_write_timeout(Some(CONNECTION_TIMEOUT)).is_err()
+            {
+                continue;
+            }
+            let response = match read_request(&mut BufReader::new(&mut stream)) {
+                Ok(Some(request)) => self.handle(&request),
+                Ok(None) => continue,
+                Err(e) if matches!(e.kind(), ErrorKind::WouldBlock | ErrorKind::TimedOut) => {
+                    Response::error(408, "timed out reading the request")
+                }
+                Err(e) => Response::error(400, &e.to_string()),
+            };
+            // A client that hangs up early only loses its own answer.
+            let _ = write_response(&mut stream, &response);
+        }
+        Ok(())
+    }
+
+    pub fn handle(&mut self, request: &Request) -> Response {
+        match (request.method.as_str(), request.path.as_str()) {
+            ("GET", "/health") => {
+                Response::ok(json!({ "status": "ok", "files": self.files.len() }))
+            }
+            ("POST", "/analyze") => {
+                let paths: Vec<String> = match (&request.body["path"], &request.body["paths"]) {
+                    (Value::String(path), _) => vec![path.clone()],
+                    (_, Value::Array(paths)) => paths
+                        .iter()
+                        .filter_map(|path| path.as_str().map(str::to_string))
+                        .collect(),
+                    _ => return Response::error(400, "expected \"path\" or \"paths\""),
+                };
+                self.analyze(&paths)
+            }
+            ("GET", "/findings") => {
+                let under = request.query.get("path").map(|path| canonical(path));
+                let files: Vec<PathBuf> = self
+                    .files
+                    .keys()
+                    .filter(|path| under.as_ref().is_none_or(|under| path.starts_with(under)))
+                    .cloned()
+                    .collect();
+                Response::ok(self.report(&files, Vec::new()))
+            }
+            ("POST", "/invalidate") => match request.body["path"].as_str() {
+                Some(path) => {
+                    let under = canonical(path);
+                    let before = self.files.len();
+                    self.files.retain(|path, _| !path.starts_with(&under));
+                    Response::ok(json!({ "invalidated": before - self.files.len() }))
+                }
+                None => {
+                    let invalidated = self.files.len();
+                    self.files.clear();
+                    self.configs.clear();
+                    Response::ok(json!({ "invalidated": invalidated }))
+                }
+            },
+            (_, "/health" | "/analyze" | "/findings" | "/invalidate") => {
+                Response::error(405, "method not allowed")
+            }
+            _ => Response::error(404, "not found"),
+        }
+    }
+
+    fn analyze(&mut self, paths: &[String]) -> Response {
+        let mut files = Vec::new();
+        let mut errors = Vec::new();
+        for path in paths {
+            if !Path::new(path).exists() {
+                errors.push(format!("{}: does not exist", path));
+                continue;
+            }
+            match walk::source_files(path) {
+                Ok(sources) => files.extend(sources.iter().map(canonical)),
+                Err(e) => errors.push(format!("{}: {}", path, e)),
+            }
+        }
+        files.sort();
+        files.dedup();
+
+        self.requests += 1;
+        for path in &files {
+            match self.analyze_file(path) {
+                Ok(file) => {
+                    self.files.insert(path.clone(), file);
+                }
+                Err(e) => {
+                    self.files.remove(path);
+                    errors.push(format!("{}: {}", path.display(), e));
+                }
+            }
+        }
+        let files: Vec<PathBuf> = files
+            .into_iter()
+            .filter(|path| self.files.contains_key(path))
+            .collect();
+        let report = self.report(&files, errors);
+        self.evict();
+        Response::ok(report)
+    }
+
+    /// Forgets the files analyzed least recently beyond `max_files`.
+    fn evict(&mut self) {
+        let Some(excess) = self.files.len().checked_sub(self.max_files) else {
+            return;
+        };
+        let mut oldest: Vec<(u64, PathBuf)> = self
+            .files
+            .iter()
+            .map(|(path, file)| (file.analyzed, path.clone()))
+            .collect();
+        oldest.sort();
+        for (_, path) in oldest.into_iter().take(excess) {
+            self.files.remove(&path);
+        }
+    }
+
+    fn analyze_file(&mut self, path: &Path) -> Result<CachedFile, Box<dyn std::error::Error>> {
+        let display = path.to_string_lossy();
+        let language = SupportedLanguage::from_path(&display)
+            .ok_or_else(|| format!("unsupported file '{}'", display))?;
+        let text = fs::read_to_string(path)?;
+
+        let project = EffectiveConfig::for_path(path)?;
+        let loaded = self.configs.get(language, &project)?;
+        let package = if loaded.analyzer.reads_package() {
+            Some(Package::load(path)?)
+        } else {
+            None
+        };
+        // Findings that follow calls into other packages can change without
+        // anything in this one changing, so they aren't reused.
+        let key = if loaded.analyzer.reads_call_graph() {
+            None
+        } else {
+            Some(Cache::key(
+                &loaded.config,
+                language,
+                &text,
+                package.as_ref(),
+            )?)
+        };
+        if let Some(cached) = self.files.get(path) {
+            if key.is_some() && cached.key == key {
+                return Ok(CachedFile {
+                    key,
+                    results: cached.results.clone(),
+                    analyzed: self.requests,
+                });
+            }
+        }
+        let results = loaded.analyzer.analyze_in_package(
+            &text,
+            &language.tree_sitter_language(),
+            package.as_ref(),
+        )?;
+        Ok(CachedFile {
+            key,
+            results,
+            analyzed: self.requests,
+        })
+    }
+
+    fn report(&self, paths: &[PathBuf], errors: Vec<String>) -> Value {
+        let files: Vec<FileFindings> = paths
+            .iter()
+            .map(|path| FileFindings {
+                path: path.to_string_lossy().into_owned(),
+                module: None,
+                owners: Vec::new(),
+                results: self.files[path].results.clone(),
+            })
+            .collect();
+        let mut report = serde_json::to_value(json::to_report(&files)).unwrap_or_default();
+        report["errors"] = json!(errors);
+        report
+    }
+}
+
+impl Response {
+    fn ok(body: Value) -> Self {
+        Response { status: 200, body }
+    }
+
+    fn error(status: u16, message: &str) -> Self {
+        Response {
+            status,
+            body: json!({ "error": message }),
+        }
+    }
+}
+
+/// Paths are compared in canonical form, so that the same file asked for
+/// as `./a.go` and `a.go` is one entry. One that doesn't exist is kept as
+/// given.
+fn canonical<P: AsRef<Path>>(path: P) -> PathBuf {
+    let path = path.as_ref();
+    fs::canonicalize(path).unwrap_or_else(|_| path.to_path_buf())
+}
+
+/// Reads one request, or `None` if the client closed the connection
+/// before sending anything.
+fn read_request<R: BufRead>(input: &mut R) -> io::Result<Option<Request>> {
+    let invalid = |message: &str| io::Error::new(io::ErrorKind::InvalidData, message.to_string());
+
+    let mut line = String::new();
+    if read_line(input, &mut line)? == 0 {
+        return Ok(None);
+    }
+    let mut parts = line.split_whitespace();
+    let (Some(method), Some(target)) = (parts.next(), parts.next()) else {
+        return Err(invalid("malformed request line"));
+    };
+    let (path, query) = target.split_once('?').unwrap_or((target, ""));
+    let query = query
+        .split('&')
+        .filter(|pair| !pair.is_empty())
+        .map(|pair| {
+            let (name, value) = pair.split_once('=').unwrap_or((pair, ""));
+            (percent_decode(name), percent_decode(value))
+        })
+        .collect();
+
+    let mut content_length = 0;
+    for headers in 0.. {
+        let mut header = String::new();
+        if read_line(input, &mut header)? == 0 {
+            break;
+        }
+        let header = header.trim_end();
+        if header.is_empty() {
+            break;
+        }
+        if headers == MAX_HEADERS {
+            return Err(invalid("too many headers"));
+        }
+        if let Some((name, value)) = header.split_once(':') {
+            if name.eq_ignore_ascii_case("Content-Length") {
+                content_length = value
+                    .trim()
+                    .parse::<usize>()
+                    .map_err(|_| invalid("invalid Content-Length"))?;
+            }
+        }
+    }
+    if content_length > MAX_BODY {
+        return Err(invalid("request body too large"));
+    }
+
+    let mut body = vec![0; content_length];
+    input.read_exact(&mut body)?;
+    let body = if body.iter().all(u8::is_ascii_whitespace) {
+        Value::Null
+    } else {
+        serde_json::from_slice(&body).map_err(|e| invalid(&format!("invalid JSON body: {}", e)))?
+    };
+    Ok(Some(Request {
+        method: method.to_string(),
+        path: percent_decode(path),
+        query,
+        body,
+    }))
+}
+
+/// Reads a line of at most [`MAX_LINE`] bytes into `line`.
+fn read_line<R: BufRead>(input: &mut R, line: &mut String) -> io::Result<usize> {
+    let read = input.take(MAX_LINE as u64 + 1).read_line(line)?;
+    if read > MAX_LINE {
+        return Err(io::Error::new(
+            io::ErrorKind::InvalidData,
+            "request line or header too long",
+        ));
+    }
+    Ok(read)
+}
+
+fn write_response<W: Write>(output: &mut W, response: &Response) -> io::Result<()> {
+    let reason = match response.status {
+        200 => "OK",
+        400 => "Bad Request",
+        404 => "Not Found",
+        405 => "Method Not Allowed",
+        408 => "Request Timeout",
+        _ => "Error",
+    };
+    let body = response.body.to_string();
+    write!(
+        output,
+        "HTTP/1.1 {} {}\r\nContent-Type: application/json\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{}",
+        response.status,
+        reason,
+        body.len(),
+        body
+    )?;
+    output.flush()
+}
+
+/// Decodes `%XX` escapes and `+` as a space; malformed escapes are kept.
+fn percent_decode(text: &str) -> String {
+    let bytes = text.as_bytes();
+    let mut decoded = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        match bytes[i] {
+            b'%' => match text
+                .get(i + 1..i + 3)
+                .and_then(|hex| u8::from_str_radix(hex, 16).ok())
+            {
+                Some(byte) => {
+                    decoded.push(byte);
+                    i += 3;
+                    continue;
+                }
+                None => decoded.push(b'%'),
+            },
+            b'+' => decoded.push(b' '),
+            byte => decoded.push(byte),
+        }
+        i += 1;
+    }
+    String::from_utf8_lossy(&decoded).into_owned()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request(method: &str, path: &str, body: Value) -> Request {
+        Request {
+            method: method.to_string(),
+            path: path.to_string(),
+            query: BTreeMap::new(),
+            body,
+        }
+    }
+
+    #[test]
+    fn test_requests_are_parsed() {
+        let body = r#"{"path": "internal/db"}"#;
+        let raw = format!(
+            "POST /analyze?path=a%2Fb+c.go&x HTTP/1.1\r\nHost: localhost\r\ncontent-length: {}\r\n\r\n{}",
+            body.len(),
+            body
+        );
+        let request = read_request(&mut raw.as_bytes()).unwrap().unwrap();
+        assert_eq!(request.method, "POST");
+        assert_eq!(request.path, "/analyze");
+        assert_eq!(request.query["path"], "a/b c.go");
+        assert_eq!(request.query["x"], "");
+        assert_eq!(request.body["path"], "internal/db");
+
+        let request = read_request(&mut "GET /health HTTP/1.1\r\n\r\n".as_bytes())
+            .unwrap()
+            .unwrap();
+        assert_eq!(request.body, Value::Null);
+        assert!(read_request(&mut "".as_bytes()).unwrap().is_none());
+        assert!(read_request(
+            &mut "POST /analyze HTTP/1.1\r\nContent-Length: 3\r\n\r\n{x}".as_bytes()
+        )
+        .is_err());
+    }
+
+    #[test]
+    fn test_long_lines_and_many_headers_are_refused() {
+        let long = format!("GET /{} HTTP/1.1\r\n\r\n", "a".repeat(MAX_LINE));
+        assert!(read_request(&mut long.as_bytes()).is_err());
+
+        let header = format!("X-Padding: {}\r\n", "a".repeat(MAX_LINE));
+        let raw = format!("GET /health HTTP/1.1\r\n{}\r\n", header);
+        assert!(read_request(&mut raw.as_bytes()).is_err());
+
+        let headers = "X-Padding: a\r\n".repeat(MAX_HEADERS + 1);
+        let raw = format!("GET /health HTTP/1.1\r\n{}\r\n", headers);
+        assert!(read_request(&mut raw.as_bytes()).is_err());
+        let headers = "X-Padding: a\r\n".repeat(MAX_HEADERS);
+        let raw = format!("GET /health HTTP/1.1\r\n{}\r\n", headers);
+        assert!(read_request(&mut raw.as_bytes()).unwrap().is_some());
+    }
+
+    #[test]
+    fn test_least_recently_analyzed_files_are_evicted() {
+        let mut server = Server::new(None, Registry::new());
+        server.max_files = 2;
+        for (analyzed, path) in [(3, "a.go"), (1, "b.go"), (2, "c.go")] {
+            server.files.insert(
+                PathBuf::from(path),
+                CachedFile {
+                    key: None,
+                    results: Vec::new(),
+                    analyzed,
+                },
+            );
+        }
+        server.evict();
+        let kept: Vec<_> = server.files.keys().collect();
+        assert_eq!(kept, [Path::new("a.go"), Path::new("c.go")]);
+    }
+
+    #[test]
+    fn test_routes() {
+        let mut server = Server::new(None, Registry::new());
+        let response = server.handle(&request("GET", "/health", Value::Null));
+        assert_eq!(response.status, 200);
+        assert_eq!(response.body["files"], 0);
+
+        assert_eq!(
+            server
+                .handle(&request("GET", "/analyze", Value::Null))
+                .status,
+            405
+        );
+        assert_eq!(
+            server
+                .handle(&request("POST", "/analyze", json!({})))
+                .status,
+            400
+        );
+        assert_eq!(
+            server
+                .handle(&request("GET", "/metrics", Value::Null))
+                .status,
+            404
+        );
+
+        let response = server.handle(&request(
+            "POST",
+            "/analyze",
+            json!({ "path": "/nonexistent/compass-serve" }),
+        ));
+        assert_eq!(response.status, 200);
+        assert_eq!(response.body["findings"], json!([]));
+        assert_eq!(
+            response.body["errors"],
+            json!(["/nonexistent/compass-serve: does not exist"])
+        );
+
+        let response = server.handle(&request("POST", "/invalidate", Value::Null));
+        assert_eq!(response.body["invalidated"], 0);
+    }
+
+    #[test]
+    fn test_responses_are_framed() {
+        let mut output = Vec::new();
+        write_response(&mut output, &Response::error(404, "not found")).unwrap();
+        let output = String::from_utf8(output).unwrap();
+        assert!(output.starts_with("HTTP/1.1 404 Not Found\r\n"));
+        assert!(output.ends_with("\r\n\r\n{\"error\":\"not found\"}"));
+        assert!(output.contains("Content-Length: 21\r\n"));
+    }
+}
@@ -0,0 +1,119 @@
+//! `--shard K/N`: one of N CI jobs analyzing its share of a tree.
+//!
+//! Files are split by package, their directory, so checks that read the
+//! rest of the package see the same files wherever it runs, and no package
+//! is analyzed twice. Packages are dealt out largest first, by bytes of
+//! source, to the shard with the least so far, ties going to the lower
+//! shard and the package that sorts first, so every job computes the same
+//! split from the same checkout without talking to the others.
+//! `compass merge` puts their `--format json` reports back together.
+
+use std::collections::BTreeMap;
+use std::fmt;
+use std::fs;
+use std::path::Path;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Shard {
+    /// 1-based, as written.
+    pub index: usize,
+    pub count: usize,
+}
+
+impl Shard {
+    /// Reads `K/N`, such as `3/8`, with `1 <= K <= N`.
+    pub fn parse(text: &str) -> Result<Shard, String> {
+        let parsed = text.split_once('/').and_then(|(index, count)| {
+            Some((index.trim().parse().ok()?, count.trim().parse().ok()?))
+        });
+        match parsed {
+            Some((index, count)) if index >= 1 && index <= count => Ok(Shard { index, count }),
+            _ => Err(format!(
+                "--shard expects K/N with 1 <= K <= N, such as 3/8, got '{}'",
+                text
+            )),
+        }
+    }
+
+    /// Whether this is the first shard, which also takes the work that
+    /// isn't split, such as the module's dependencies.
+    pub fn is_first(&self) -> bool {
+        self.index == 1
+    }
+
+    /// The items of this shard, in their order, where `path` is an item's
+    /// file.
+    pub fn select<T>(&self, items: Vec<T>, path: impl Fn(&T) -> &str) -> Vec<T> {
+        let mut packages: BTreeMap<&Path, u64> = BTreeMap::new();
+        for item in &items {
+            let path = Path::new(path(item));
+            // A missing file still counts, so shards stay even without sizes.
+            let size = fs::metadata(path).map_or(1, |meta| meta.len().max(1));
+            *packages
+                .entry(path.parent().unwrap_or(Path::new("")))
+                .or_default() += size;
+        }
+        let mut packages: Vec<(&Path, u64)> = packages.into_iter().collect();
+        packages.sort_by(|a, b| b.1.cmp(&a.1).then(a.0.cmp(b.0)));
+
+        let mut loads = vec![0u64; self.count];
+        let mut mine = Vec::new();
+        for (package, size) in packages {
+            let (shard, _) = loads
+                .iter()
+                .enumerate()
+                .min_by_key(|(shard, load)| (**load, *shard))
+                .unwrap_or((0, &0));
+            loads[shard] += size;
+            if shard + 1 == self.index {
+                mine.push(package.to_path_buf());
+            }
+        }
+        items
+            .into_iter()
+            .filter(|item| {
+                let package = Path::new(path(item)).parent().unwrap_or(Path::new(""));
+                mine.iter().any(|mine| mine == package)
+            })
+            .collect()
+    }
+}
+
+impl fmt::Display for Shard {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        write!(f, "{}/{}", self.index, self.count)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_shards_split_packages_evenly_and_completely() {
+        let paths: Vec<String> = ["api", "api", "store", "store", "store", "cmd", "util"]
+            .iter()
+            .enumerate()
+            .map(|(i, dir)| format!("missing/{}/file{}.go", dir, i))
+            .collect();
+        let shards: Vec<Vec<String>> = (1..=3)
+            .map(|index| Shard { index, count: 3 }.select(paths.clone(), |path| path))
+            .collect();
+        // store first, then api, then cmd and util onto the emptiest
+        assert_eq!(
+            shards[0],
+            [
+                "missing/store/file2.go",
+                "missing/store/file3.go",
+                "missing/store/file4.go"
+            ]
+        );
+        assert_eq!(shards[1], ["missing/api/file0.go", "missing/api/file1.go"]);
+        assert_eq!(shards[2], ["missing/cmd/file5.go", "missing/util/file6.go"]);
+
+        assert_eq!(Shard::parse("3/8"), Ok(Shard { index: 3, count: 8 }));
+        for wrong in ["0/8", "9/8", "3", "a/b", "1/0"] {
+            assert!(Shard::parse(wrong).is_err(), "{}", wrong);
+        }
+    }
+}
@@ -0,0 +1,715 @@
+//! A lightweight SQL linter for query strings found in source code.
+//!
+//! Queries are tokenized and checked for mistakes that are cheap to spot
+//! without a schema: unterminated strings and quotes, unbalanced
+//! parentheses, stray commas, clauses with nothing in them, `INSERT`s whose
+//! column and value lists differ in length, and placeholders or syntax the
+//! chosen [`Dialect`] doesn't accept. It isn't a full parser: a query that
+//! passes may still be rejected by the database, but one that fails is
+//! wrong.
+
+use std::collections::BTreeSet;
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum Dialect {
+    Postgres,
+    MySql,
+    Sqlite,
+}
+
+impl Dialect {
+    /// Reads a dialect name as written in a config file.
+    pub fn parse(name: &str) -> Option<Self> {
+        match name.to_lowercase().as_str() {
+            "postgres" | "postgresql" | "pg" => Some(Dialect::Postgres),
+            "mysql" | "mariadb" => Some(Dialect::MySql),
+            "sqlite" | "sqlite3" => Some(Dialect::Sqlite),
+            _ => None,
+        }
+    }
+
+    pub fn name(&self) -> &'static str {
+        match self {
+            Dialect::Postgres => "PostgreSQL",
+            Dialect::MySql => "MySQL",
+            Dialect::Sqlite => "SQLite",
+        }
+    }
+
+    /// How a query parameter is written in this dialect.
+    pub fn placeholder(&self) -> &'static str {
+        match self {
+            Dialect::Postgres => "$1",
+            Dialect::MySql | Dialect::Sqlite => "?",
+        }
+    }
+}
+
+/// Keywords a statement can start with.
+const STATEMENTS: &[&str] = &[
+    "SELECT",
+    "INSERT",
+    "UPDATE",
+    "DELETE",
+    "WITH",
+    "CREATE",
+    "ALTER",
+    "DROP",
+    "TRUNCATE",
+    "REPLACE",
+    "MERGE",
+    "UPSERT",
+    "VALUES",
+    "EXPLAIN",
+    "SHOW",
+    "SET",
+    "BEGIN",
+    "START",
+    "COMMIT",
+    "ROLLBACK",
+    "SAVEPOINT",
+    "RELEASE",
+    "GRANT",
+    "REVOKE",
+    "LOCK",
+    "PRAGMA",
+    "VACUUM",
+    "ANALYZE",
+    "COPY",
+    "CALL",
+    "LISTEN",
+    "NOTIFY",
+    "DECLARE",
+    "FETCH",
+    "USE",
+];
+
+/// Keywords that start a clause, after which a `,` or an operand can't come.
+const CLAUSES: &[&str] = &[
+    "FROM",
+    "WHERE",
+    "GROUP",
+    "ORDER",
+    "HAVING",
+    "LIMIT",
+    "OFFSET",
+    "VALUES",
+    "SET",
+    "UNION",
+    "INTERSECT",
+    "EXCEPT",
+    "RETURNING",
+    "JOIN",
+    "ON",
+];
+
+#[derive(Debug, Clone, PartialEq)]
+enum Token {
+    /// A keyword or bare identifier, upper-cased.
+    Word(String),
+    /// A quoted identifier.
+    Identifier,
+    String,
+    Number,
+    Placeholder(Placeholder),
+    Symbol(String),
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Placeholder {
+    /// `?`
+    Positional,
+    /// `$1`, with its number.
+    Numbered(usize),
+    /// `:name`, as sqlx named queries use, or SQLite's `@name`.
+    Named,
+}
+
+impl Token {
+    fn is_word(&self, word: &str) -> bool {
+        matches!(self, Token::Word(w) if w == word)
+    }
+
+    fn is_symbol(&self, symbol: &str) -> bool {
+        matches!(self, Token::Symbol(s) if s == symbol)
+    }
+
+    fn is_clause(&self) -> bool {
+        matches!(self, Token::Word(w) if CLAUSES.contains(&w.as_str()))
+    }
+
+    fn describe(&self) -> String {
+        match self {
+            Token::Word(word) => format!("`{}`", word),
+            Token::Symbol(symbol) => format!("`{}`", symbol),
+            _ => "a value".to_string(),
+        }
+    }
+}
+
+/// Whether `query` reads like a SQL statement: its first word is one a
+/// statement can start with.
+pub fn is_statement(query: &str) -> bool {
+    match tokenize(query, None) {
+        Ok(tokens) => first_word(&tokens).is_some_and(|word| STATEMENTS.contains(&word)),
+        Err(_) => query
+            .trim_start_matches(|c: char| c.is_whitespace() || c == '(')
+            .split(|c: char| !c.is_alphabetic())
+            .next()
+            .is_some_and(|word| STATEMENTS.contains(&word.to_uppercase().as_str())),
+    }
+}
+
+/// The first problem found in `query`, if any. Without a dialect only what
+/// every dialect rejects is reported.
+pub fn lint(query: &str, dialect: Option<Dialect>) -> Result<(), String> {
+    let tokens = tokenize(query, dialect)?;
+    check_parentheses(&tokens)?;
+    check_placeholders(&tokens, dialect)?;
+    for statement in tokens.split(|token| token.is_symbol(";")) {
+        if statement.is_empty() {
+            continue;
+        }
+        match first_word(statement) {
+            Some(word) if STATEMENTS.contains(&word) => {}
+            _ => {
+                return Err(format!(
+                    "{} doesn't start a statement",
+                    statement[0].describe()
+                ))
+            }
+        }
+        check_clauses(statement)?;
+        check_statement(statement, dialect)?;
+        if let Some(dialect) = dialect {
+            check_dialect(statement, dialect)?;
+        }
+    }
+    Ok(())
+}
+
+/// Whether a `SELECT` in `query` lists `*` or `table.*` as its columns.
+/// `EXISTS (SELECT * ...)` and `COUNT(*)` don't count.
+pub fn selects_star(query: &str, dialect: Option<Dialect>) -> bool {
+    let Ok(tokens) = tokenize(query, dialect) else {
+        return false;
+    };
+    (0..tokens.len()).any(|i| {
+        if !tokens[i].is_word("SELECT") {
+            return false;
+        }
+        let in_exists = i >= 2 && tokens[i - 1].is_symbol("(") && tokens[i - 2].is_word("EXISTS");
+        let mut j = i + 1;
+        while tokens
+            .get(j)
+            .is_some_and(|t| t.is_word("DISTINCT") || t.is_word("ALL"))
+        {
+            j += 1;
+        }
+        let star = match tokens.get(j) {
+            Some(token) if token.is_symbol("*") => true,
+            Some(Token::Word(_) | Token::Identifier) => {
+                tokens.get(j + 1).is_some_and(|t| t.is_symbol("."))
+                    && tokens.get(j + 2).is_some_and(|t| t.is_symbol("*"))
+            }
+            _ => false,
+        };
+        star && !in_exists
+    })
+}
+
+fn first_word(tokens: &[Token]) -> Option<&str> {
+    tokens.iter().find_map(|token| match token {
+        Token::Symbol(symbol) if symbol == "(" => None,
+        Token::Word(word) => Some(word.as_str()),
+        _ => Some(""),
+    })
+}
+
+fn tokenize(query: &str, dialect: Option<Dialect>) -> Result<Vec<Token>, String> {
+    let chars: Vec<char> = query.chars().collect();
+    let mut tokens = Vec::new();
+    let mut i = 0;
+    let at = |i: usize| chars.get(i).copied().unwrap_or('\0');
+    let is_word_char = |c: char| c.is_alphanumeric() || c == '_';
+
+    while i < chars.len() {
+        let c = chars[i];
+        if c.is_whitespace() {
+            i += 1;
+        } else if (c == '-' && at(i + 1) == '-') || (c == '#' && dialect == Some(Dialect::MySql)) {
+            while i < chars.len() && chars[i] != '\n' {
+                i += 1;
+            }
+        } else if c == '/' && at(i + 1) == '*' {
+            let end = (i + 2..chars.len().saturating_sub(1))
+                .find(|&j| chars[j] == '*' && chars[j + 1] == '/')
+                .ok_or("the `/*` comment is never closed")?;
+            i = end + 2;
+        } else if c == '\'' || (c == '"' && dialect == Some(Dialect::MySql)) {
+            i = quoted(&chars, i, c, dialect == Some(Dialect::MySql))
+                .ok_or("a string literal is never closed")?;
+            tokens.push(Token::String);
+        } else if c == '"' || c == '`' || (c == '[' && dialect == Some(Dialect::Sqlite)) {
+            if c == '`' && dialect == Some(Dialect::Postgres) {
+                return Err(
+                    "PostgreSQL quotes identifiers with double quotes, not backticks".to_string(),
+                );
+            }
+            let close = if c == '[' { ']' } else { c };
+            i = quoted(&chars, i, close, false).ok_or("a quoted identifier is never closed")?;
+            tokens.push(Token::Identifier);
+        } else if c == '$' && at(i + 1).is_ascii_digit() {
+            let start = i + 1;
+            i = start;
+            while at(i).is_ascii_digit() {
+                i += 1;
+            }
+            let number: String = chars[start..i].iter().collect();
+            tokens.push(Token::Placeholder(Placeholder::Numbered(
+                number.parse().unwrap_or(0),
+            )));
+        } else if c == '$' && dialect != Some(Dialect::MySql) && dollar_tag(&chars, i).is_some() {
+            // PostgreSQL's `$tag$ ... $tag$` strings.
+            let tag = dollar_tag(&chars, i).unwrap_or_default();
+            let body = i + tag.len();
+            let end = (body..chars.len())
+                .find(|&j| chars[j..].starts_with(&tag))
+                .ok_or("a dollar-quoted string is never closed")?;
+            i = end + tag.len();
+            tokens.push(Token::String);
+        } else if c == '?' && !matches!(at(i + 1), '|' | '&') {
+            i += 1;
+            while at(i).is_ascii_digit() {
+                i += 1;
+            }
+            tokens.push(Token::Placeholder(Placeholder::Positional));
+        } else if (c == ':' && at(i + 1) != ':' && (at(i + 1).is_alphabetic() || at(i + 1) == '_'))
+            || (matches!(c, '@' | '$')
+                && dialect == Some(Dialect::Sqlite)
+                && at(i + 1).is_alphabetic())
+        {
+            i += 1;
+            while is_word_char(at(i)) {
+                i += 1;
+            }
+            tokens.push(Token::Placeholder(Placeholder::Named));
+        } else if c.is_ascii_digit() || (c == '.' && at(i + 1).is_ascii_digit()) {
+            while at(i).is_ascii_alphanumeric() || at(i) == '.' {
+                i += 1;
+            }
+            tokens.push(Token::Number);
+        } else if is_word_char(c) || c == '@' {
+            let start = i;
+            i += 1;
+            while is_word_char(at(i)) || at(i) == '$' {
+                i += 1;
+            }
+            let word: String = chars[start..i].iter().collect();
+            tokens.push(Token::Word(word.to_uppercase()));
+        } else {
+            let two: String = chars[i..(i + 2).min(chars.len())].iter().collect();
+            let symbol = match two.as_str() {
+                "<=" | ">=" | "<>" | "!=" | "||" | "::" | "->" | "=>" => two,
+                _ => c.to_string(),
+            };
+            i += symbol.chars().count();
+            tokens.push(Token::Symbol(symbol));
+        }
+    }
+    Ok(tokens)
+}
+
+/// The index just past a quoted run starting at `start`, where a doubled
+/// `close` stands for itself.
+fn quoted(chars: &[char], start: usize, close: char, backslash_escapes: bool) -> Option<usize> {
+    let mut i = start + 1;
+    while i < chars.len() {
+        if backslash_escapes && chars[i] == '\\' {
+            i += 2;
+            continue;
+        }
+        if chars[i] == close {
+            if chars.get(i + 1) == Some(&close) && close != ']' {
+                i += 2;
+                continue;
+            }
+            return Some(i + 1);
+        }
+        i += 1;
+    }
+    None
+}
+
+/// `$$` or `$tag$` at `start`.
+fn dollar_tag(chars: &[char], start: usize) -> Option<Vec<char>> {
+    let mut i = start + 1;
+    while chars
+        .get(i)
+        .is_some_and(|c| c.is_alphanumeric() || *c == '_')
+    {
+        i += 1;
+    }
+    (chars.get(i) == Some(&'$')).then(|| chars[start..=i].to_vec())
+}
+
+fn check_parentheses(tokens: &[Token]) -> Result<(), String> {
+    let mut depth = 0usize;
+    for token in tokens {
+        if token.is_symbol("(") {
+            depth += 1;
+        } else if token.is_symbol(")") {
+            depth = depth.checked_sub(1).ok_or("a `)` has no matching `(`")?;
+        }
+    }
+    match depth {
+        0 => Ok(()),
+        _ => Err("a `(` is never closed".to_string()),
+    }
+}
+
+fn check_placeholders(tokens: &[Token], dialect: Option<Dialect>) -> Result<(), String> {
+    let mut positional = false;
+    let mut numbers = BTreeSet::new();
+    for token in tokens {
+        match token {
+            Token::Placeholder(Placeholder::Positional) => positional = true,
+            Token::Placeholder(Placeholder::Numbered(n)) => {
+                numbers.insert(*n);
+            }
+            _ => {}
+        }
+    }
+    match dialect {
+        // Next to `$1`, a `?` is the jsonb operator.
+        Some(Dialect::Postgres) if positional && numbers.is_empty() => {
+            return Err("PostgreSQL uses `$1`, `$2`, ... placeholders, not `?`".to_string())
+        }
+        Some(Dialect::Postgres) => {}
+        Some(Dialect::MySql) if !numbers.is_empty() => {
+            return Err("MySQL uses `?` placeholders, not `$1`".to_string())
+        }
+        _ if positional && !numbers.is_empty() => {
+            return Err("the query mixes `?` and `$1` placeholders".to_string())
+        }
+        _ => {}
+    }
+    if let Some(missing) = (1..=numbers.last().copied().unwrap_or(0)).find(|n| !numbers.contains(n))
+    {
+        let last = numbers.last().copied().unwrap_or(0);
+        return Err(format!("`${}` is used but `${}` isn't", last, missing));
+    }
+    Ok(())
+}
+
+/// Commas, clauses and operators with nothing where something must be.
+fn check_clauses(tokens: &[Token]) -> Result<(), String> {
+    let ends_operand = |token: Option<&Token>| {
+        token.is_none_or(|t| t.is_symbol(")") || t.is_symbol(",") || t.is_clause())
+    };
+    for (i, token) in tokens.iter().enumerate() {
+        let next = tokens.get(i + 1);
+        if token.is_symbol(",") && next.is_none_or(|t| t.is_symbol(")") || t.is_clause()) {
+            let before = next.map_or("the end".to_string(), Token::describe);
+            return Err(format!("a stray `,` comes before {}", before));
+        }
+        let Token::Word(word) = token else {
+            continue;
+        };
+        match word.as_str() {
+            "SELECT" if next.is_none_or(|t| t.is_word("FROM")) => {
+                return Err("`SELECT` has no columns".to_string())
+            }
+            "WHERE" | "HAVING" | "AND" | "OR"
+                if ends_operand(next)
+                    || next.is_some_and(|t| t.is_word("AND") || t.is_word("OR")) =>
+            {
+                return Err(format!("`{}` has no condition after it", word))
+            }
+            "FROM" | "JOIN" | "INTO" if ends_operand(next) => {
+                return Err(format!("`{}` has no table after it", word))
+            }
+            "ORDER" | "GROUP" if !next.is_some_and(|t| t.is_word("BY")) => {
+                return Err(format!("`{}` must be followed by `BY`", word))
+            }
+            "FROM" | "WHERE" | "SELECT" | "SET" | "VALUES" if next == Some(token) => {
+                return Err(format!("`{}` is repeated", word))
+            }
+            _ => {}
+        }
+    }
+    Ok(())
+}
+
+fn check_statement(tokens: &[Token], dialect: Option<Dialect>) -> Result<(), String> {
+    let skip = |from: usize, words: &[&str]| {
+        let mut i = from;
+        while tokens
+            .get(i)
+            .is_some_and(|t| words.iter().any(|word| t.is_word(word)))
+        {
+            i += 1;
+        }
+        i
+    };
+    let start = tokens.iter().position(|t| !t.is_symbol("(")).unwrap_or(0);
+    let Some(Token::Word(verb)) = tokens.get(start) else {
+        return Ok(());
+    };
+    match verb.as_str() {
+        "INSERT" | "REPLACE" => {
+            let modifiers = [
+                "OR",
+                "REPLACE",
+                "IGNORE",
+                "ABORT",
+                "FAIL",
+                "ROLLBACK",
+                "LOW_PRIORITY",
+                "DELAYED",
+                "HIGH_PRIORITY",
+            ];
+            let into = skip(start + 1, &modifiers);
+            if !tokens.get(into).is_some_and(|t| t.is_word("INTO")) {
+                if dialect != Some(Dialect::MySql) {
+                    return Err(format!("`{}` must be followed by `INTO`", verb));
+                }
+                return Ok(());
+            }
+            check_insert_lengths(tokens, into + 1)
+        }
+        "UPDATE" => match depth_zero(tokens).any(|t| t.is_word("SET")) {
+            true => Ok(()),
+            false => Err("`UPDATE` has no `SET`".to_string()),
+        },
+        "DELETE" if dialect != Some(Dialect::MySql) => {
+            match tokens.get(start + 1).is_some_and(|t| t.is_word("FROM")) {
+                true => Ok(()),
+                false => Err("`DELETE` must be followed by `FROM`".to_string()),
+            }
+        }
+        _ => Ok(()),
+    }
+}
+
+/// Tokens outside any parentheses.
+fn depth_zero(tokens: &[Token]) -> impl Iterator<Item = &Token> {
+    let mut depth = 0i32;
+    tokens.iter().filter(move |token| {
+        if token.is_symbol("(") {
+            depth += 1;
+        } else if token.is_symbol(")") {
+            depth -= 1;
+            return false;
+        }
+        depth == 0 && !token.is_symbol("(")
+    })
+}
+
+/// `INSERT INTO t (a, b) VALUES (1, 2), (3, 4)`: each row must have as
+/// many values as there are columns.
+fn check_insert_lengths(tokens: &[Token], table: usize) -> Result<(), String> {
+    let mut i = table + 1;
+    // Schema-qualified names: `public.users`.
+    while tokens.get(i).is_some_and(|t| t.is_symbol(".")) {
+        i += 2;
+    }
+    if !tokens.get(i).is_some_and(|t| t.is_symbol("(")) {
+        return Ok(());
+    }
+    let Some((columns, after)) = list_length(tokens, i) else {
+        return Ok(());
+    };
+    if !tokens.get(after).is_some_and(|t| t.is_word("VALUES")) {
+        return Ok(());
+    }
+    let mut i = after + 1;
+    while tokens.get(i).is_some_and(|t| t.is_symbol("(")) {
+        let Some((values, next)) = list_length(tokens, i) else {
+            return Ok(());
+        };
+        if values != columns {
+            return Err(format!(
+                "the `INSERT` lists {} column{} but a row has {} value{}",
+                columns,
+                if columns == 1 { "" } else { "s" },
+                values,
+                if values == 1 { "" } else { "s" }
+            ));
+        }
+        i = next;
+        if !tokens.get(i).is_some_and(|t| t.is_symbol(",")) {
+            break;
+        }
+        i += 1;
+    }
+    Ok(())
+}
+
+/// The number of items in the parenthesized list opening at `open`, and
+/// the index after its `)`.
+fn list_length(tokens: &[Token], open: usize) -> Option<(usize, usize)> {
+    let mut depth = 0;
+    let mut items = 1;
+    for (i, token) in tokens.iter().enumerate().skip(open) {
+        if token.is_symbol("(") {
+            depth += 1;
+        } else if token.is_symbol(")") {
+            depth -= 1;
+            if depth == 0 {
+                return Some((items, i + 1));
+            }
+        } else if depth == 1 && token.is_symbol(",") {
+            items += 1;
+        }
+    }
+    None
+}
+
+fn check_dialect(tokens: &[Token], dialect: Dialect) -> Result<(), String> {
+    let name = dialect.name();
+    for (i, token) in tokens.iter().enumerate() {
+        let next = |n: usize| tokens.get(i + n);
+        match dialect {
+            Dialect::Postgres if token.is_word("LIMIT") => {
+                if next(2).is_some_and(|t| t.is_symbol(",")) {
+                    return Err(
+                        "PostgreSQL doesn't support `LIMIT offset, count`; use `LIMIT count OFFSET offset`"
+                            .to_string(),
+                    );
+                }
+            }
+            Dialect::MySql if token.is_word("RETURNING") => {
+                return Err("MySQL doesn't support `RETURNING`".to_string());
+            }
+            Dialect::MySql
+                if token.is_word("ON") && next(1).is_some_and(|t| t.is_word("CONFLICT")) =>
+            {
+                return Err(
+                    "MySQL doesn't support `ON CONFLICT`; use `ON DUPLICATE KEY UPDATE`"
+                        .to_string(),
+                );
+            }
+            Dialect::Postgres | Dialect::Sqlite
+                if token.is_word("ON")
+                    && next(1).is_some_and(|t| t.is_word("DUPLICATE"))
+                    && next(2).is_some_and(|t| t.is_word("KEY")) =>
+            {
+                return Err(format!(
+                    "{} doesn't support `ON DUPLICATE KEY UPDATE`; use `ON CONFLICT`",
+                    name
+                ));
+            }
+            Dialect::MySql | Dialect::Sqlite if token.is_word("ILIKE") => {
+                return Err(format!(
+                    "{} doesn't support `ILIKE`; compare `LOWER(...)` with `LIKE`",
+                    name
+                ));
+            }
+            Dialect::MySql | Dialect::Sqlite if token.is_symbol("::") => {
+                return Err(format!(
+                    "{} doesn't support `::` casts; use `CAST(... AS ...)`",
+                    name
+                ));
+            }
+            _ => {}
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_lint_finds_structural_mistakes() {
+        let lint_generic = |query: &str| lint(query, None);
+        assert_eq!(
+            lint_generic("SELECT id, name FROM users WHERE id = $1"),
+            Ok(())
+        );
+        assert_eq!(
+            lint_generic("SELECT id, name, FROM users"),
+            Err("a stray `,` comes before `FROM`".to_string())
+        );
+        assert_eq!(
+            lint_generic("SELECT id FROM users WHERE name = 'bob"),
+            Err("a string literal is never closed".to_string())
+        );
+        assert_eq!(
+            lint_generic("SELECT count(id FROM users"),
+            Err("a `(` is never closed".to_string())
+        );
+        assert_eq!(
+            lint_generic("SELECT id FROM users WHERE ORDER BY id"),
+            Err("`WHERE` has no condition after it".to_string())
+        );
+        assert_eq!(
+            lint_generic("INSERT INTO users (id, name) VALUES ($1, $2), ($3)"),
+            Err("the `INSERT` lists 2 columns but a row has 1 value".to_string())
+        );
+        assert_eq!(
+            lint_generic("UPDATE users name = $1"),
+            Err("`UPDATE` has no `SET`".to_string())
+        );
+        assert_eq!(
+            lint_generic("SELECT id FROM users WHERE id = ? OR id = $1"),
+            Err("the query mixes `?` and `$1` placeholders".to_string())
+        );
+        assert_eq!(
+            lint_generic("SELECT id FROM users WHERE a = $1 AND b = $3"),
+            Err("`$3` is used but `$2` isn't".to_string())
+        );
+        assert_eq!(
+            lint_generic("SELECT $$it's fine$$, 'it''s fine' -- it's a comment"),
+            Ok(())
+        );
+    }
+
+    #[test]
+    fn test_lint_applies_the_dialect() {
+        let query = "SELECT id FROM users WHERE id = ?";
+        assert_eq!(lint(query, Some(Dialect::MySql)), Ok(()));
+        assert_eq!(
+            lint(query, Some(Dialect::Postgres)),
+            Err("PostgreSQL uses `$1`, `$2`, ... placeholders, not `?`".to_string())
+        );
+        assert_eq!(
+            lint("INSERT users (id) VALUES (?)", Some(Dialect::MySql)),
+            Ok(())
+        );
+        assert_eq!(
+            lint("INSERT users (id) VALUES (?)", Some(Dialect::Sqlite)),
+            Err("`INSERT` must be followed by `INTO`".to_string())
+        );
+        assert_eq!(
+            lint("SELECT `id` FROM t LIMIT 10, 20", Some(Dialect::Postgres)),
+            Err("PostgreSQL quotes identifiers with double quotes, not backticks".to_string())
+        );
+        assert_eq!(
+            lint(
+                "INSERT INTO t (id) VALUES (?) RETURNING id",
+                Some(Dialect::MySql)
+            ),
+            Err("MySQL doesn't support `RETURNING`".to_string())
+        );
+        assert_eq!(
+            lint("SELECT id::text FROM t", Some(Dialect::Sqlite)),
+            Err("SQLite doesn't support `::` casts; use `CAST(... AS ...)`".to_string())
+        );
+    }
+
+    #[test]
+    fn test_selects_star() {
+        assert!(selects_star("SELECT * FROM users", None));
+        assert!(selects_star("select distinct u.* from users u", None));
+        assert!(!selects_star("SELECT count(*) FROM users", None));
+        assert!(!selects_star(
+            "SELECT id FROM users WHERE EXISTS (SELECT * FROM orders)",
+            None
+        ));
+        assert!(is_statement("  (SELECT 1)"));
+        assert!(!is_statement("hello world"));
+    }
+}
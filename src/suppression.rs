@@ -0,0 +1,268 @@
+use crate::analyzer::{AnalysisResult, Severity};
+use crate::checks::{node_text, visit};
+use std::fmt;
+use tree_sitter::Node;
+
+pub const UNUSED_SUPPRESSION_RULE: &str = "unused_suppression";
+
+const DIRECTIVE: &str = "compass:disable";
+
+/// A `//compass:disable rule-a,rule-b -- reason` comment.
+///
+/// The directive covers findings that start on its own line or on the line
+/// directly below it, so it can trail the offending code or sit above it.
+#[derive(Debug, Clone)]
+pub struct Suppression {
+    pub line: usize,
+    pub column: usize,
+    pub rules: Vec<String>,
+    pub reason: String,
+    pub text: String,
+}
+
+impl Suppression {
+    fn covers(&self, result: &AnalysisResult) -> bool {
+        (result.line == self.line || result.line == self.line + 1)
+            && self.rules.iter().any(|rule| rule == &result.rule_name)
+    }
+}
+
+#[derive(Debug)]
+pub struct SuppressionError {
+    pub line: usize,
+    pub message: String,
+}
+
+impl fmt::Display for SuppressionError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "line {}: {}", self.line, self.message)
+    }
+}
+
+impl std::error::Error for SuppressionError {}
+
+/// The directives in the comments of a parsed file. Text in strings that
+/// only looks like a directive is left alone.
+pub fn from_tree(root: Node, source_code: &str) -> Result<Vec<Suppression>, SuppressionError> {
+    let mut comments = Vec::new();
+    visit(root, &mut |node| {
+        // `comment` in most grammars, `line_comment` in Rust's and Java's.
+        if node.kind() == "comment" || node.kind() == "line_comment" {
+            comments.push(node);
+        }
+    });
+    let mut suppressions = Vec::new();
+    for comment in comments {
+        let start = comment.start_position();
+        let text = node_text(comment, source_code);
+        if let Some(suppression) = directive(text, start.row + 1, start.column + 1)? {
+            suppressions.push(suppression);
+        }
+    }
+    Ok(suppressions)
+}
+
+/// The directives of a file compass doesn't parse, found in the text of
+/// each line from its first `//`.
+pub fn parse(source_code: &str) -> Result<Vec<Suppression>, SuppressionError> {
+    let mut suppressions = Vec::new();
+
+    for (index, line) in source_code.lines().enumerate() {
+        let Some(directive_start) = line.find(DIRECTIVE) else {
+            continue;
+        };
+        let prefix = line[..directive_start].trim_end();
+        if !prefix.ends_with("//") {
+            continue;
+        }
+        let comment_start = prefix.len() - 2;
+        if let Some(suppression) = directive(&line[comment_start..], index + 1, comment_start + 1)?
+        {
+            suppressions.push(suppression);
+        }
+    }
+
+    Ok(suppressions)
+}
+
+/// The directive `comment`, a `//` comment starting at `line` and `column`,
+/// holds, if it is one.
+fn directive(
+    comment: &str,
+    line: usize,
+    column: usize,
+) -> Result<Option<Suppression>, SuppressionError> {
+    let Some(body) = comment
+        .strip_prefix("//")
+        .and_then(|rest| rest.trim_start().strip_prefix(DIRECTIVE))
+    else {
+        return Ok(None);
+    };
+    let (rules_part, reason) = match body.split_once("--") {
+        Some((rules, reason)) => (rules, reason.trim()),
+        None => (body, ""),
+    };
+
+    let rules: Vec<String> = rules_part
+        .split(|c: char| c == ',' || c.is_whitespace())
+        .filter(|rule| !rule.is_empty())
+        .map(str::to_string)
+        .collect();
+
+    if rules.is_empty() {
+        return Err(SuppressionError {
+            line,
+            message: format!("`{}` must name at least one rule", DIRECTIVE),
+        });
+    }
+
+    if reason.is_empty() {
+        return Err(SuppressionError {
+            line,
+            message: format!(
+                "`{} {}` is missing a justification; append `-- <reason>`",
+                DIRECTIVE,
+                rules.join(",")
+            ),
+        });
+    }
+
+    Ok(Some(Suppression {
+        line,
+        column,
+        rules,
+        reason: reason.to_string(),
+        text: comment.trim_end().to_string(),
+    }))
+}
+
+/// Drops every result covered by a suppression and, when `report_unused` is
+/// set, appends an `unused_suppression` finding for each directive that no
+/// longer matches anything. `ran` names the rules that ran: a directive for
+/// rules that didn't, because a mode, stage or override left them out, can't
+/// have matched, so it isn't reported.
+pub fn apply(
+    results: Vec<AnalysisResult>,
+    suppressions: &[Suppression],
+    ran: &[&str],
+    report_unused: bool,
+) -> Vec<AnalysisResult> {
+    let mut used = vec![false; suppressions.len()];
+
+    let mut kept: Vec<AnalysisResult> = results
+        .into_iter()
+        .filter(|result| {
+            let mut suppressed = false;
+            for (index, suppression) in suppressions.iter().enumerate() {
+                if suppression.covers(result) {
+                    used[index] = true;
+                    suppressed = true;
+                }
+            }
+            !suppressed
+        })
+        .collect();
+
+    if report_unused {
+        for (suppression, _) in suppressions.iter().zip(used).filter(|(_, used)| !used) {
+            if suppression
+                .rules
+                .iter()
+                .any(|rule| ran.contains(&rule.as_str()))
+            {
+                kept.push(unused_result(suppression));
+            }
+        }
+    }
+
+    kept
+}
+
+fn unused_result(suppression: &Suppression) -> AnalysisResult {
+    let severity = Severity::Warning;
+    AnalysisResult {
+        rule_name: UNUSED_SUPPRESSION_RULE.to_string(),
+        score_impact: severity.base_score_impact(),
+        severity,
+        message: format!(
+            "Suppression for '{}' no longer matches any finding",
+            suppression.rules.join(",")
+        ),
+        line: suppression.line,
+        column: suppression.column,
+        end_line: suppression.line,
+        end_column: suppression.column + suppression.text.len(),
+        text: suppression.text.clone(),
+        suggestion: Some("Remove the stale `compass:disable` directive.".to_string()),
+        ..Default::default()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn result(rule: &str, line: usize) -> AnalysisResult {
+        AnalysisResult {
+            rule_name: rule.to_string(),
+            severity: Severity::Warning,
+            message: "msg".to_string(),
+            line,
+            column: 1,
+            end_line: line,
+            end_column: 10,
+            score_impact: -1.5,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_parse_directive() {
+        let source =
+            "x := f() //compass:disable panic_usage,missing_error_check -- checked upstream\n";
+        let suppressions = parse(source).unwrap();
+        assert_eq!(suppressions.len(), 1);
+        assert_eq!(
+            suppressions[0].rules,
+            vec!["panic_usage", "missing_error_check"]
+        );
+        assert_eq!(suppressions[0].reason, "checked upstream");
+    }
+
+    #[test]
+    fn test_missing_justification_is_an_error() {
+        let err = parse("// compass:disable panic_usage\npanic(1)\n").unwrap_err();
+        assert_eq!(err.line, 1);
+        assert!(parse("// compass:disable panic_usage --   \n").is_err());
+    }
+
+    #[test]
+    fn test_apply_same_and_next_line() {
+        let suppressions = parse("// compass:disable panic_usage -- startup only\n").unwrap();
+        let results = vec![
+            result("panic_usage", 1),
+            result("panic_usage", 2),
+            result("panic_usage", 3),
+            result("todo_comment", 2),
+        ];
+
+        let kept = apply(results, &suppressions, &["panic_usage"], true);
+        assert_eq!(kept.len(), 2);
+        assert_eq!(kept[0].line, 3);
+        assert_eq!(kept[1].rule_name, "todo_comment");
+    }
+
+    #[test]
+    fn test_unused_suppression_reported() {
+        let suppressions = parse("// compass:disable panic_usage -- legacy\n").unwrap();
+        let ran = ["panic_usage", "todo_comment"];
+        let kept = apply(vec![result("todo_comment", 5)], &suppressions, &ran, true);
+        assert!(kept.iter().any(|r| r.rule_name == UNUSED_SUPPRESSION_RULE));
+
+        let quiet = apply(vec![], &suppressions, &ran, false);
+        assert!(quiet.is_empty());
+
+        let not_run = apply(vec![], &suppressions, &["todo_comment"], true);
+        assert!(not_run.is_empty());
+    }
+}
@@ -0,0 +1,495 @@
+//! Taint tracking over Go syntax trees.
+//!
+//! Values produced by a *source* (request parameters, environment variables,
+//! file contents) are followed through assignments, expressions and calls
+//! until they reach a *sink* (a SQL query, a command line, a file path). A
+//! *sanitizer* call produces a clean value regardless of its arguments.
+//!
+//! Propagation is intra-procedural in document order, reassignments in a
+//! function's top-level block replace a variable's taint and nested ones add
+//! to it. Functions declared in the same file are summarised (which parameters
+//! reach the return value or a sink, and whether the result is itself a
+//! source) so flows through helpers are found as well. Calls to anything else
+//! are assumed to pass the taint of their arguments and receiver through.
+
+use crate::checks::{enclosing_function, list_items, node_text, visit};
+use std::collections::{BTreeSet, HashMap};
+use tree_sitter::Node;
+
+/// Calls or selectors matched by text. A pattern starting with `.` matches
+/// any receiver (`.FormValue` matches `r.FormValue`); anything else must match
+/// exactly (`os.Getenv`).
+pub(crate) fn matches_pattern(text: &str, pattern: &str) -> bool {
+    if pattern.starts_with('.') {
+        text.ends_with(pattern)
+    } else {
+        text == pattern
+    }
+}
+
+/// A sink call. Written as `pattern:N` when only the `N`th (zero-based)
+/// argument is dangerous, e.g. `.QueryContext:1` for the query string of a
+/// parameterised statement.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Sink {
+    pub pattern: String,
+    pub argument: Option<usize>,
+}
+
+impl Sink {
+    pub fn parse(spec: &str) -> Self {
+        match spec.rsplit_once(':') {
+            Some((pattern, index)) if index.parse::<usize>().is_ok() => Sink {
+                pattern: pattern.to_string(),
+                argument: index.parse().ok(),
+            },
+            _ => Sink {
+                pattern: spec.to_string(),
+                argument: None,
+            },
+        }
+    }
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct TaintSpec {
+    pub sources: Vec<String>,
+    pub sinks: Vec<Sink>,
+    pub sanitizers: Vec<String>,
+}
+
+impl TaintSpec {
+    fn is_source(&self, text: &str) -> bool {
+        self.sources.iter().any(|p| matches_pattern(text, p))
+    }
+
+    fn is_sanitizer(&self, text: &str) -> bool {
+        self.sanitizers.iter().any(|p| matches_pattern(text, p))
+    }
+
+    fn sink(&self, text: &str) -> Option<&Sink> {
+        self.sinks
+            .iter()
+            .find(|s| matches_pattern(text, &s.pattern))
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+enum Label {
+    Source,
+    Param(usize),
+}
+
+type Labels = BTreeSet<Label>;
+
+#[derive(Debug, Clone, Default, PartialEq)]
+struct Summary {
+    returns_source: bool,
+    param_to_return: BTreeSet<usize>,
+    param_to_sink: BTreeSet<usize>,
+}
+
+struct Function<'t> {
+    key: String,
+    node: Node<'t>,
+    body: Node<'t>,
+    params: Vec<String>,
+}
+
+/// Summaries are recomputed until they stop changing; chains of helpers
+/// deeper than this are cut off.
+const MAX_ROUNDS: usize = 8;
+
+/// Returns the sink calls that receive tainted data, in document order.
+pub fn find_flows<'t>(root: Node<'t>, source_code: &str, spec: &TaintSpec) -> Vec<Node<'t>> {
+    let functions = collect_functions(root, source_code);
+    let mut engine = Engine {
+        spec,
+        source_code,
+        summaries: HashMap::new(),
+    };
+
+    for _ in 0..MAX_ROUNDS {
+        let mut changed = false;
+        for function in &functions {
+            let summary = engine.analyze(function, None);
+            if engine.summaries.get(&function.key) != Some(&summary) {
+                engine.summaries.insert(function.key.clone(), summary);
+                changed = true;
+            }
+        }
+        if !changed {
+            break;
+        }
+    }
+
+    let mut flows = Vec::new();
+    for function in &functions {
+        engine.analyze(function, Some(&mut flows));
+    }
+    flows.sort_by_key(|node| (node.start_byte(), node.end_byte()));
+    flows.dedup_by_key(|node| (node.start_byte(), node.end_byte()));
+    flows
+}
+
+fn collect_functions<'t>(root: Node<'t>, source_code: &str) -> Vec<Function<'t>> {
+    let mut functions = Vec::new();
+    visit(root, &mut |node| {
+        let prefix = match node.kind() {
+            "function_declaration" => "",
+            "method_declaration" => ".",
+            _ => return,
+        };
+        let (Some(name), Some(body)) = (
+            node.child_by_field_name("name"),
+            node.child_by_field_name("body"),
+        ) else {
+            return;
+        };
+
+        let mut params = Vec::new();
+        if let Some(list) = node.child_by_field_name("parameters") {
+            let mut cursor = list.walk();
+            for declaration in list.named_children(&mut cursor) {
+                let mut names = declaration.walk();
+                let declared: Vec<_> = declaration
+                    .children_by_field_name("name", &mut names)
+                    .map(|n| node_text(n, source_code).to_string())
+                    .collect();
+                if declared.is_empty() {
+                    // An unnamed parameter still takes up a position.
+                    params.push(String::new());
+                }
+                params.extend(declared);
+            }
+        }
+
+        functions.push(Function {
+            key: format!("{}{}", prefix, node_text(name, source_code)),
+            node,
+            body,
+            params,
+        });
+    });
+    functions
+}
+
+struct Engine<'s> {
+    spec: &'s TaintSpec,
+    source_code: &'s str,
+    summaries: HashMap<String, Summary>,
+}
+
+impl<'s> Engine<'s> {
+    /// Computes the summary of `function`, recording tainted sink calls in
+    /// `flows` when given.
+    fn analyze<'t>(
+        &self,
+        function: &Function<'t>,
+        mut flows: Option<&mut Vec<Node<'t>>>,
+    ) -> Summary {
+        let mut vars: HashMap<String, Labels> = HashMap::new();
+        for (index, name) in function.params.iter().enumerate() {
+            if !name.is_empty() {
+                vars.insert(name.clone(), Labels::from([Label::Param(index)]));
+            }
+        }
+
+        // Assignments take effect after their right-hand side has been
+        // evaluated, so they are ordered by where they end.
+        let mut events = Vec::new();
+        visit(function.body, &mut |node| match node.kind() {
+            "short_var_declaration" | "assignment_statement" | "var_spec" | "range_clause" => {
+                events.push((node.end_byte(), node))
+            }
+            "call_expression" | "return_statement" => events.push((node.start_byte(), node)),
+            _ => {}
+        });
+        events.sort_by_key(|(position, _)| *position);
+
+        let mut summary = Summary::default();
+        // The second pass sees taint carried around loops by the first.
+        for pass in 0..2 {
+            let last = pass == 1;
+            for &(_, node) in &events {
+                match node.kind() {
+                    "call_expression" => {
+                        for label in self.sink_labels(node, &vars) {
+                            match label {
+                                Label::Source if last => {
+                                    if let Some(flows) = flows.as_deref_mut() {
+                                        flows.push(node);
+                                    }
+                                }
+                                Label::Param(index) => {
+                                    summary.param_to_sink.insert(index);
+                                }
+                                _ => {}
+                            }
+                        }
+                    }
+                    "return_statement" => {
+                        if enclosing_function(node) != Some(function.node) {
+                            continue;
+                        }
+                        for label in self.eval_children(node, &vars) {
+                            match label {
+                                Label::Source => summary.returns_source = true,
+                                Label::Param(index) => {
+                                    summary.param_to_return.insert(index);
+                                }
+                            }
+                        }
+                    }
+                    _ => self.assign(node, function.body, &mut vars),
+                }
+            }
+        }
+        summary
+    }
+
+    /// Labels of the values reaching a dangerous argument of `call`, either
+    /// because it is a sink or because it is a helper that passes a parameter
+    /// on to one.
+    fn sink_labels(&self, call: Node, vars: &HashMap<String, Labels>) -> Labels {
+        let mut labels = Labels::new();
+        let (Some(function), Some(arguments)) = (
+            call.child_by_field_name("function"),
+            call.child_by_field_name("arguments"),
+        ) else {
+            return labels;
+        };
+        let mut cursor = arguments.walk();
+        let arguments: Vec<Node> = arguments.named_children(&mut cursor).collect();
+
+        if let Some(sink) = self.spec.sink(node_text(function, self.source_code)) {
+            match sink.argument {
+                Some(index) => {
+                    if let Some(argument) = arguments.get(index) {
+                        labels.extend(self.eval(*argument, vars));
+                    }
+                }
+                None => {
+                    for argument in &arguments {
+                        labels.extend(self.eval(*argument, vars));
+                    }
+                }
+            }
+        }
+        if let Some(summary) = self.summary_for(function) {
+            for index in &summary.param_to_sink {
+                if let Some(argument) = arguments.get(*index) {
+                    labels.extend(self.eval(*argument, vars));
+                }
+            }
+        }
+        labels
+    }
+
+    fn summary_for(&self, function: Node) -> Option<&Summary> {
+        let key = match function.kind() {
+            "identifier" => node_text(function, self.source_code).to_string(),
+            "selector_expression" => {
+                format!(
+                    ".{}",
+                    node_text(function.child_by_field_name("field")?, self.source_code)
+                )
+            }
+            _ => return None,
+        };
+        self.summaries.get(&key)
+    }
+
+    fn assign(&self, node: Node, body: Node, vars: &mut HashMap<String, Labels>) {
+        let (targets, values) = match node.kind() {
+            "var_spec" => {
+                let mut cursor = node.walk();
+                let names: Vec<Node> = node.children_by_field_name("name", &mut cursor).collect();
+                (
+                    names,
+                    node.child_by_field_name("value")
+                        .map(list_items)
+                        .unwrap_or_default(),
+                )
+            }
+            _ => (
+                node.child_by_field_name("left")
+                    .map(list_items)
+                    .unwrap_or_default(),
+                node.child_by_field_name("right")
+                    .map(list_items)
+                    .unwrap_or_default(),
+            ),
+        };
+
+        let operator = node
+            .child_by_field_name("operator")
+            .map(|op| node_text(op, self.source_code));
+        let replaces = node.kind() != "range_clause"
+            && matches!(operator, None | Some("="))
+            && is_top_level(node, body);
+
+        let combined: Labels = values.iter().flat_map(|v| self.eval(*v, vars)).collect();
+        for (index, target) in targets.iter().enumerate() {
+            let labels = if targets.len() == values.len() {
+                self.eval(values[index], vars)
+            } else {
+                combined.clone()
+            };
+
+            let Some((name, whole)) = assigned_variable(*target, self.source_code) else {
+                continue;
+            };
+            if replaces && whole {
+                vars.insert(name, labels);
+            } else {
+                vars.entry(name).or_default().extend(labels);
+            }
+        }
+    }
+
+    fn eval(&self, node: Node, vars: &HashMap<String, Labels>) -> Labels {
+        match node.kind() {
+            "identifier" => vars
+                .get(node_text(node, self.source_code))
+                .cloned()
+                .unwrap_or_default(),
+            "call_expression" => self.eval_call(node, vars),
+            "selector_expression" => {
+                let mut labels = Labels::new();
+                if self.spec.is_source(node_text(node, self.source_code)) {
+                    labels.insert(Label::Source);
+                }
+                if let Some(operand) = node.child_by_field_name("operand") {
+                    labels.extend(self.eval(operand, vars));
+                }
+                labels
+            }
+            "func_literal" => Labels::new(),
+            _ => self.eval_children(node, vars),
+        }
+    }
+
+    fn eval_children(&self, node: Node, vars: &HashMap<String, Labels>) -> Labels {
+        let mut cursor = node.walk();
+        let children: Vec<Node> = node.named_children(&mut cursor).collect();
+        children
+            .into_iter()
+            .flat_map(|child| self.eval(child, vars))
+            .collect()
+    }
+
+    fn eval_call(&self, call: Node, vars: &HashMap<String, Labels>) -> Labels {
+        let mut labels = Labels::new();
+        let Some(function) = call.child_by_field_name("function") else {
+            return labels;
+        };
+        let callee = node_text(function, self.source_code);
+        if self.spec.is_sanitizer(callee) {
+            return labels;
+        }
+        if self.spec.is_source(callee) {
+            labels.insert(Label::Source);
+        }
+
+        let arguments: Vec<Node> = call
+            .child_by_field_name("arguments")
+            .map(|list| {
+                let mut cursor = list.walk();
+                list.named_children(&mut cursor).collect()
+            })
+            .unwrap_or_default();
+
+        if let Some(summary) = self.summary_for(function) {
+            if summary.returns_source {
+                labels.insert(Label::Source);
+            }
+            for index in &summary.param_to_return {
+                if let Some(argument) = arguments.get(*index) {
+                    labels.extend(self.eval(*argument, vars));
+                }
+            }
+            return labels;
+        }
+
+        for argument in arguments {
+            labels.extend(self.eval(argument, vars));
+        }
+        if function.kind() == "selector_expression" {
+            if let Some(operand) = function.child_by_field_name("operand") {
+                labels.extend(self.eval(operand, vars));
+            }
+        }
+        labels
+    }
+}
+
+/// The variable written by an assignment target, and whether the whole
+/// variable is replaced (as opposed to a field or element of it).
+fn assigned_variable(target: Node, source_code: &str) -> Option<(String, bool)> {
+    match target.kind() {
+        "identifier" => {
+            let name = node_text(target, source_code);
+            (name != "_").then(|| (name.to_string(), true))
+        }
+        "selector_expression" | "index_expression" => {
+            let operand = target.child_by_field_name("operand")?;
+            assigned_variable(operand, source_code).map(|(name, _)| (name, false))
+        }
+        "parenthesized_expression" | "unary_expression" => {
+            assigned_variable(target.named_child(0)?, source_code).map(|(name, _)| (name, false))
+        }
+        _ => None,
+    }
+}
+
+/// Whether `node` runs unconditionally as part of `body`, rather than in a
+/// branch, loop or closure.
+fn is_top_level(node: Node, body: Node) -> bool {
+    let mut current = node.parent();
+    while let Some(ancestor) = current {
+        if ancestor == body {
+            return true;
+        }
+        if matches!(
+            ancestor.kind(),
+            "block"
+                | "func_literal"
+                | "for_statement"
+                | "if_statement"
+                | "expression_switch_statement"
+                | "type_switch_statement"
+                | "select_statement"
+        ) {
+            return false;
+        }
+        current = ancestor.parent();
+    }
+    false
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_sink_argument_suffix() {
+        assert_eq!(
+            Sink::parse(".QueryContext:1"),
+            Sink {
+                pattern: ".QueryContext".to_string(),
+                argument: Some(1)
+            }
+        );
+        assert_eq!(Sink::parse("exec.Command").argument, None);
+    }
+
+    #[test]
+    fn test_patterns() {
+        assert!(matches_pattern("r.FormValue", ".FormValue"));
+        assert!(matches_pattern("req.URL.Query", ".URL.Query"));
+        assert!(matches_pattern("os.Getenv", "os.Getenv"));
+        assert!(!matches_pattern("myos.Getenv", "os.Getenv"));
+        assert!(!matches_pattern("tmpl.Execute", ".Exec"));
+    }
+}
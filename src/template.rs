@@ -0,0 +1,515 @@
+//! A checker for Go `text/template` and `html/template` sources.
+//!
+//! Templates are split into text and actions the way `text/template/parse`
+//! does, so the problems found are the errors `Parse` would return: actions
+//! or comments that aren't closed, `{{if}}`, `{{range}}`, `{{with}}`,
+//! `{{define}}` and `{{block}}` without their `{{end}}` or an `{{end}}` or
+//! `{{else}}` with nothing to close, empty commands, unterminated strings,
+//! unbalanced parentheses and calls to functions that aren't defined. The
+//! messages follow the package's, prefixed with the line.
+//!
+//! The pipelines of the template are returned, so `html/template` code can
+//! look for the predefined escapers it rejects.
+
+/// The functions every template can call.
+const BUILTINS: &[&str] = &[
+    "and", "call", "eq", "ge", "gt", "html", "index", "js", "le", "len", "lt", "ne", "not", "or",
+    "print", "printf", "println", "slice", "urlquery",
+];
+
+/// The escapers `html/template` won't run in the middle of a pipeline.
+pub const PREDEFINED_ESCAPERS: &[&str] = &["html", "urlquery"];
+
+pub struct Options<'a> {
+    pub left: &'a str,
+    pub right: &'a str,
+    /// The functions added with `Funcs`, or `None` when they aren't known
+    /// and any name is accepted.
+    pub functions: Option<&'a [String]>,
+}
+
+impl Default for Options<'_> {
+    fn default() -> Self {
+        Options {
+            left: "{{",
+            right: "}}",
+            functions: Some(&[]),
+        }
+    }
+}
+
+/// A pipeline of one action: its commands, each by the function it calls,
+/// if it calls one.
+#[derive(Debug, PartialEq)]
+pub struct Pipeline {
+    pub line: usize,
+    pub commands: Vec<Option<String>>,
+}
+
+/// Parses `source`, returning its pipelines or the first error, such as
+/// `line 3: unexpected {{end}}`.
+pub fn parse(source: &str, options: &Options) -> Result<Vec<Pipeline>, String> {
+    let mut parser = Parser {
+        source,
+        options,
+        at: 0,
+        line: 1,
+        open: Vec::new(),
+        pipelines: Vec::new(),
+    };
+    parser
+        .parse()
+        .map_err(|message| format!("line {}: {}", parser.line, message))?;
+    Ok(parser.pipelines)
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Token {
+    Word(String),
+    Field,
+    Variable,
+    Literal,
+    Declare,
+    Comma,
+    Pipe,
+    Open,
+    Close,
+}
+
+/// A control action waiting for its `{{end}}`.
+struct Block {
+    keyword: String,
+    line: usize,
+    seen_else: bool,
+}
+
+struct Parser<'a> {
+    source: &'a str,
+    options: &'a Options<'a>,
+    at: usize,
+    line: usize,
+    open: Vec<Block>,
+    pipelines: Vec<Pipeline>,
+}
+
+impl Parser<'_> {
+    fn rest(&self) -> &str {
+        &self.source[self.at..]
+    }
+
+    fn advance(&mut self, bytes: usize) {
+        let end = (self.at + bytes).min(self.source.len());
+        self.line += self.source[self.at..end].matches('\n').count();
+        self.at = end;
+    }
+
+    fn parse(&mut self) -> Result<(), String> {
+        let left = self.options.left;
+        while let Some(offset) = self.rest().find(left) {
+            self.advance(offset + left.len());
+            if self.rest().starts_with("- ") || self.rest().starts_with("-\n") {
+                self.advance(1);
+            }
+            let trimmed = self.rest().trim_start();
+            if trimmed.starts_with("/*") {
+                let skip = self.rest().len() - trimmed.len();
+                self.advance(skip);
+                self.comment()?;
+                continue;
+            }
+            let tokens = self.action()?;
+            self.control(tokens)?;
+        }
+        if let Some(block) = self.open.last() {
+            self.line = block.line;
+            return Err(format!(
+                "unexpected EOF: {{{{{}}}}} has no {{{{end}}}}",
+                block.keyword
+            ));
+        }
+        Ok(())
+    }
+
+    fn comment(&mut self) -> Result<(), String> {
+        let Some(close) = self.rest().find("*/") else {
+            return Err("unclosed comment".to_string());
+        };
+        self.advance(close + 2);
+        let rest = self.rest();
+        let after = rest.strip_prefix(" -").unwrap_or(rest);
+        if !after.starts_with(self.options.right) {
+            return Err("comment ends before closing delimiter".to_string());
+        }
+        let skip = rest.len() - after.len() + self.options.right.len();
+        self.advance(skip);
+        Ok(())
+    }
+
+    /// The tokens of an action, up to and past its closing delimiter.
+    fn action(&mut self) -> Result<Vec<Token>, String> {
+        let right = self.options.right;
+        let mut tokens = Vec::new();
+        loop {
+            let rest = self.rest();
+            if rest.is_empty() {
+                return Err("unclosed action".to_string());
+            }
+            if rest.starts_with(right) {
+                self.advance(right.len());
+                return Ok(tokens);
+            }
+            let c = rest.chars().next().unwrap_or(' ');
+            if c.is_whitespace() {
+                let after = rest.trim_start();
+                let space = rest.len() - after.len();
+                if after.starts_with('-') && after[1..].starts_with(right) {
+                    self.advance(space + 1 + right.len());
+                    return Ok(tokens);
+                }
+                self.advance(space);
+                continue;
+            }
+            let (token, length) = self.token(rest, c)?;
+            tokens.push(token);
+            self.advance(length);
+        }
+    }
+
+    fn token(&self, rest: &str, c: char) -> Result<(Token, usize), String> {
+        let word_length = |text: &str| {
+            text.find(|c: char| !(c.is_alphanumeric() || c == '_'))
+                .unwrap_or(text.len())
+        };
+        // A field chain after an operand: `.A.B`.
+        let chain_length = |text: &str| {
+            let mut length = 0;
+            while text[length..].starts_with('.') {
+                let word = word_length(&text[length + 1..]);
+                if word == 0 {
+                    break;
+                }
+                length += 1 + word;
+            }
+            length
+        };
+        let next = rest[c.len_utf8()..].chars().next();
+        Ok(match c {
+            '"' | '\'' => {
+                let mut escaped = false;
+                let end = rest[1..].char_indices().find(|&(_, ch)| {
+                    let done = !escaped && (ch == c || ch == '\n');
+                    escaped = !escaped && ch == '\\';
+                    done
+                });
+                match end {
+                    Some((i, ch)) if ch == c => (Token::Literal, i + 2),
+                    _ if c == '"' => return Err("unterminated quoted string".to_string()),
+                    _ => return Err("unterminated character constant".to_string()),
+                }
+            }
+            '`' => match rest[1..].find('`') {
+                Some(i) => (Token::Literal, i + 2),
+                None => return Err("unterminated raw quoted string".to_string()),
+            },
+            '|' => (Token::Pipe, 1),
+            '(' => (Token::Open, 1),
+            ')' => (Token::Close, 1),
+            ',' => (Token::Comma, 1),
+            '=' => (Token::Declare, 1),
+            ':' if next == Some('=') => (Token::Declare, 2),
+            '$' => {
+                let length = 1 + word_length(&rest[1..]);
+                (Token::Variable, length + chain_length(&rest[length..]))
+            }
+            '.' if next.is_some_and(|n| n.is_ascii_digit()) => (Token::Literal, number(rest)),
+            '.' => {
+                let length = chain_length(rest).max(1);
+                (Token::Field, length)
+            }
+            '0'..='9' => (Token::Literal, number(rest)),
+            '-' | '+' if next.is_some_and(|n| n.is_ascii_digit() || n == '.') => {
+                (Token::Literal, 1 + number(&rest[1..]))
+            }
+            _ if c.is_alphabetic() || c == '_' => {
+                let length = word_length(rest);
+                let word = rest[..length].to_string();
+                (Token::Word(word), length + chain_length(&rest[length..]))
+            }
+            _ => return Err(format!("unexpected {:?} in command", c)),
+        })
+    }
+
+    /// Handles the keywords that open and close blocks, and checks the
+    /// pipeline of every action.
+    fn control(&mut self, tokens: Vec<Token>) -> Result<(), String> {
+        let keyword = match tokens.first() {
+            Some(Token::Word(word)) => word.as_str(),
+            _ => "",
+        };
+        let rest = tokens.get(1..).unwrap_or_default();
+        match keyword {
+            "end" => {
+                if !rest.is_empty() {
+                    return Err("unexpected token in end".to_string());
+                }
+                if self.open.pop().is_none() {
+                    return Err("unexpected {{end}}".to_string());
+                }
+            }
+            "else" => {
+                let chained = matches!(rest.first(), Some(Token::Word(word)) if word == "if" || word == "with");
+                let Some(block) = self.open.last_mut() else {
+                    return Err("unexpected {{else}}".to_string());
+                };
+                if !matches!(block.keyword.as_str(), "if" | "range" | "with") {
+                    return Err("unexpected {{else}}".to_string());
+                }
+                if block.seen_else {
+                    return Err("expected end; found {{else}}".to_string());
+                }
+                if chained {
+                    if rest.len() == 1 {
+                        return Err("missing value for else".to_string());
+                    }
+                    self.pipeline(&rest[1..])?;
+                } else {
+                    if !rest.is_empty() {
+                        return Err("unexpected token in else".to_string());
+                    }
+                    block.seen_else = true;
+                }
+            }
+            "if" | "range" | "with" => {
+                if rest.is_empty() {
+                    return Err(format!("missing value for {}", keyword));
+                }
+                self.pipeline(rest)?;
+                self.push(keyword);
+            }
+            "define" | "block" | "template" => {
+                if rest.first() != Some(&Token::Literal) {
+                    return Err(format!("missing name in {}", keyword));
+                }
+                match keyword {
+                    "define" if rest.len() > 1 => {
+                        return Err("unexpected token in define".to_string())
+                    }
+                    "define" => {}
+                    _ if rest.len() > 1 => self.pipeline(&rest[1..])?,
+                    _ => {}
+                }
+                if keyword != "template" {
+                    self.push(keyword);
+                }
+            }
+            "break" | "continue" => {
+                if !rest.is_empty() {
+                    return Err(format!("unexpected token in {}", keyword));
+                }
+                if !self.open.iter().any(|block| block.keyword == "range") {
+                    return Err(format!("{{{{{}}}}} outside {{{{range}}}}", keyword));
+                }
+            }
+            _ => self.pipeline(&tokens)?,
+        }
+        Ok(())
+    }
+
+    fn push(&mut self, keyword: &str) {
+        self.open.push(Block {
+            keyword: keyword.to_string(),
+            line: self.line,
+            seen_else: false,
+        });
+    }
+
+    /// Checks a pipeline, with an optional `$x :=` in front, and records
+    /// it and the pipelines in its parentheses.
+    fn pipeline(&mut self, tokens: &[Token]) -> Result<(), String> {
+        let mut tokens = tokens;
+        if let Some(declare) = tokens.iter().position(|token| *token == Token::Declare) {
+            let declared = &tokens[..declare];
+            let variables = declared.iter().step_by(2).all(|t| *t == Token::Variable)
+                && declared
+                    .iter()
+                    .skip(1)
+                    .step_by(2)
+                    .all(|t| *t == Token::Comma);
+            if declared.is_empty() || !variables {
+                return Err("unexpected \":=\" in operand".to_string());
+            }
+            tokens = &tokens[declare + 1..];
+        }
+        if tokens.is_empty() {
+            return Err("missing value for command".to_string());
+        }
+
+        let mut commands = Vec::new();
+        let mut command: Vec<&Token> = Vec::new();
+        let mut depth = 0;
+        let mut inner_start = 0;
+        for (i, token) in tokens.iter().enumerate() {
+            match token {
+                Token::Open => {
+                    if depth == 0 {
+                        inner_start = i + 1;
+                    }
+                    depth += 1;
+                }
+                Token::Close if depth == 0 => return Err("unexpected right paren".to_string()),
+                Token::Close => {
+                    depth -= 1;
+                    if depth == 0 {
+                        self.pipeline(&tokens[inner_start..i])?;
+                        command.push(&Token::Literal);
+                    }
+                }
+                _ if depth > 0 => {}
+                Token::Pipe => {
+                    commands.push(self.command(&command)?);
+                    command.clear();
+                }
+                Token::Declare | Token::Comma => {
+                    return Err("unexpected token in operand".to_string())
+                }
+                _ => command.push(token),
+            }
+        }
+        if depth > 0 {
+            return Err("unclosed left paren".to_string());
+        }
+        commands.push(self.command(&command)?);
+        self.pipelines.push(Pipeline {
+            line: self.line,
+            commands,
+        });
+        Ok(())
+    }
+
+    /// Checks one command of a pipeline, returning the function it calls.
+    fn command(&self, operands: &[&Token]) -> Result<Option<String>, String> {
+        if operands.is_empty() {
+            return Err("missing value for command".to_string());
+        }
+        let mut called = None;
+        for (i, operand) in operands.iter().enumerate() {
+            let Token::Word(word) = operand else {
+                continue;
+            };
+            if matches!(word.as_str(), "nil" | "true" | "false") {
+                continue;
+            }
+            let defined = BUILTINS.contains(&word.as_str())
+                || self
+                    .options
+                    .functions
+                    .is_none_or(|functions| functions.iter().any(|function| function == word));
+            if !defined {
+                return Err(format!("function {:?} not defined", word));
+            }
+            if i == 0 {
+                called = Some(word.clone());
+            }
+        }
+        Ok(called)
+    }
+}
+
+/// The length of the number at the start of `text`.
+fn number(text: &str) -> usize {
+    let mut length = 0;
+    let mut previous = ' ';
+    for c in text.chars() {
+        let exponent_sign = (c == '+' || c == '-') && matches!(previous, 'e' | 'E' | 'p' | 'P');
+        if !(c.is_ascii_alphanumeric() || c == '.' || c == '_' || exponent_sign) {
+            break;
+        }
+        length += c.len_utf8();
+        previous = c;
+    }
+    length
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_reports_what_template_parse_rejects() {
+        let options = Options::default();
+        for valid in [
+            "Hello, {{.Name}}!",
+            "{{if .A}}a{{else if .B}}b{{else}}c{{end}}",
+            "{{range $i, $v := .Items}}{{if eq $i 0}}{{break}}{{end}}{{$v.Name | printf \"%q\"}}{{end}}",
+            "{{- /* a comment */ -}}{{with .User}}{{.Email}}{{end}}",
+            "{{define \"row\"}}<td>{{.}}</td>{{end}}{{template \"row\" .}}",
+            "{{block \"list\" .}}{{len (index .Items 0)}} {{-3.5}}{{end}}",
+        ] {
+            assert!(parse(valid, &options).is_ok(), "{}", valid);
+        }
+
+        for (template, problem) in [
+            ("{{.Name", "line 1: unclosed action"),
+            (
+                "{{if .A}}\n{{else}}",
+                "line 1: unexpected EOF: {{if}} has no {{end}}",
+            ),
+            ("a\n{{end}}", "line 2: unexpected {{end}}"),
+            ("{{else}}", "line 1: unexpected {{else}}"),
+            (
+                "{{if .A}}{{else}}{{else}}{{end}}",
+                "line 1: expected end; found {{else}}",
+            ),
+            ("{{}}", "line 1: missing value for command"),
+            ("{{.A | }}", "line 1: missing value for command"),
+            ("{{if}}{{end}}", "line 1: missing value for if"),
+            ("{{upper .A}}", "line 1: function \"upper\" not defined"),
+            ("{{printf \"%s .A}}", "line 1: unterminated quoted string"),
+            ("{{len (.A}}", "line 1: unclosed left paren"),
+            ("{{/* note }}", "line 1: unclosed comment"),
+            ("{{break}}", "line 1: {{break}} outside {{range}}"),
+        ] {
+            assert_eq!(
+                parse(template, &options),
+                Err(problem.to_string()),
+                "{}",
+                template
+            );
+        }
+
+        let functions = ["upper".to_string()];
+        let known = Options {
+            functions: Some(&functions),
+            ..Options::default()
+        };
+        assert!(parse("{{upper .A}}", &known).is_ok());
+        let delims = Options {
+            left: "[[",
+            right: "]]",
+            ..Options::default()
+        };
+        assert!(parse("{{ not an action }} [[.A]]", &delims).is_ok());
+    }
+
+    #[test]
+    fn test_parse_returns_pipelines() {
+        let pipelines = parse(
+            "{{.A | html | printf \"%s\"}}\n{{urlquery .B}}",
+            &Options::default(),
+        )
+        .unwrap();
+        assert_eq!(
+            pipelines,
+            vec![
+                Pipeline {
+                    line: 1,
+                    commands: vec![None, Some("html".to_string()), Some("printf".to_string())],
+                },
+                Pipeline {
+                    line: 2,
+                    commands: vec![Some("urlquery".to_string())],
+                },
+            ]
+        );
+    }
+}
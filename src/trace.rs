@@ -0,0 +1,396 @@
+//! `--otlp-endpoint`: an analysis run as an OpenTelemetry trace.
+//!
+//! The run is one trace. Its root span, `compass`, covers the whole run,
+//! with a `discover` span for finding the files and a `format` span for
+//! writing the report. Under it, each package (the file's directory) is a
+//! span from the first of its files to start to the last to finish, and
+//! each file an `analyze` span with the steps of its analysis: `load
+//! package`, `parse`, a span per rule and the file's part of `format`.
+//! Files run on several workers, so the file spans of a package overlap.
+//!
+//! The trace is sent once the run is done, as OTLP/HTTP JSON to the
+//! endpoint's `/v1/traces`, with the headers `OTEL_EXPORTER_OTLP_HEADERS`
+//! lists, through curl like `--history` URLs.
+
+use crate::history::curl;
+use crate::profile::{package_of, FileProfile};
+use serde_json::{json, Value};
+use sha2::{Digest, Sha256};
+use std::collections::HashMap;
+use std::env;
+use std::process;
+use std::time::{Instant, SystemTime, UNIX_EPOCH};
+
+/// An attribute value.
+#[derive(Debug, Clone, PartialEq)]
+pub enum Attribute {
+    String(String),
+    Int(i64),
+    Bool(bool),
+}
+
+impl From<&str> for Attribute {
+    fn from(value: &str) -> Self {
+        Attribute::String(value.to_string())
+    }
+}
+
+impl From<usize> for Attribute {
+    fn from(value: usize) -> Self {
+        Attribute::Int(value as i64)
+    }
+}
+
+impl From<bool> for Attribute {
+    fn from(value: bool) -> Self {
+        Attribute::Bool(value)
+    }
+}
+
+/// A span of a [`Trace`], by its index.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct SpanId(usize);
+
+#[derive(Debug, Clone)]
+struct Span {
+    name: String,
+    parent: Option<SpanId>,
+    start: Instant,
+    end: Instant,
+    attributes: Vec<(String, Attribute)>,
+}
+
+#[derive(Debug)]
+pub struct Trace {
+    id: [u8; 16],
+    /// The run's start, as an instant and as wall-clock time, to convert
+    /// the one to the other.
+    origin: (Instant, SystemTime),
+    spans: Vec<Span>,
+    /// Each package's span and how many files it has.
+    packages: HashMap<String, (SpanId, usize)>,
+}
+
+impl Trace {
+    /// Starts the trace of a run that began at `started`, with its root
+    /// span.
+    pub fn new(started: Instant) -> Self {
+        let now = SystemTime::now();
+        let origin = now
+            .checked_sub(started.elapsed())
+            .map_or((Instant::now(), now), |then| (started, then));
+        let mut hasher = Sha256::new();
+        hasher.update(format!("{:?} {}", now, process::id()));
+        let mut id = [0; 16];
+        id.copy_from_slice(&hasher.finalize()[..16]);
+        let mut trace = Trace {
+            id,
+            origin,
+            spans: Vec::new(),
+            packages: HashMap::new(),
+        };
+        trace.span("compass", None, started, started);
+        trace
+    }
+
+    pub fn root(&self) -> SpanId {
+        SpanId(0)
+    }
+
+    /// Adds a span named `name` under `parent`.
+    pub fn span(
+        &mut self,
+        name: &str,
+        parent: Option<SpanId>,
+        start: Instant,
+        end: Instant,
+    ) -> SpanId {
+        self.spans.push(Span {
+            name: name.to_string(),
+            parent,
+            start,
+            end: end.max(start),
+            attributes: Vec::new(),
+        });
+        SpanId(self.spans.len() - 1)
+    }
+
+    pub fn set(&mut self, span: SpanId, key: &str, value: impl Into<Attribute>) {
+        self.spans[span.0]
+            .attributes
+            .push((key.to_string(), value.into()));
+    }
+
+    /// Adds the spans of a file analyzed as `profile` says, under its
+    /// package's span, and returns the file's.
+    pub fn file(&mut self, path: &str, profile: &FileProfile, findings: usize) -> Option<SpanId> {
+        let start = profile.started?;
+        let end = start + profile.elapsed;
+        let package = package_of(path);
+        let root = self.root();
+        let package_span = match self.packages.get_mut(&package) {
+            Some((span, files)) => {
+                *files += 1;
+                let span = *span;
+                let extent = &mut self.spans[span.0];
+                extent.start = extent.start.min(start);
+                extent.end = extent.end.max(end);
+                span
+            }
+            None => {
+                let span = self.span(&format!("package {}", package), Some(root), start, end);
+                self.set(span, "compass.package", package.as_str());
+                self.packages.insert(package, (span, 1));
+                span
+            }
+        };
+
+        let file = self.span(&format!("analyze {}", path), Some(package_span), start, end);
+        self.set(file, "compass.file", path);
+        self.set(file, "compass.cached", profile.cached);
+        self.set(file, "compass.findings", findings);
+        for (name, started, elapsed) in &profile.steps {
+            let step = self.span(name, Some(file), *started, *started + *elapsed);
+            if let Some(rule) = name.strip_prefix("rule ") {
+                self.set(step, "compass.rule", rule);
+            }
+        }
+        Some(file)
+    }
+
+    /// Ends the root span at `end` and returns the trace as an OTLP
+    /// `ExportTraceServiceRequest`.
+    pub fn finish(mut self, end: Instant) -> Value {
+        self.spans[0].end = end;
+        for (span, files) in self.packages.values() {
+            self.spans[span.0]
+                .attributes
+                .push(("compass.files".to_string(), (*files).into()));
+        }
+        let spans: Vec<Value> = self
+            .spans
+            .iter()
+            .enumerate()
+            .map(|(index, span)| {
+                let mut value = json!({
+                    "traceId": hex(&self.id),
+                    "spanId": hex(&self.span_id(SpanId(index))),
+                    "name": span.name,
+                    // SPAN_KIND_INTERNAL
+                    "kind": 1,
+                    "startTimeUnixNano": self.unix_nanos(span.start).to_string(),
+                    "endTimeUnixNano": self.unix_nanos(span.end).to_string(),
+                    "attributes": attributes(&span.attributes),
+                });
+                if let Some(parent) = span.parent {
+                    value["parentSpanId"] = json!(hex(&self.span_id(parent)));
+                }
+                value
+            })
+            .collect();
+        json!({
+            "resourceSpans": [{
+                "resource": {
+                    "attributes": attributes(&[
+                        ("service.name".to_string(), "compass".into()),
+                        ("service.version".to_string(), env!("CARGO_PKG_VERSION").into()),
+                    ]),
+                },
+                "scopeSpans": [{
+                    "scope": { "name": "compass", "version": env!("CARGO_PKG_VERSION") },
+                    "spans": spans,
+                }],
+            }],
+        })
+    }
+
+    /// Span ids are the trace id hashed with the span's index, so they are
+    /// distinct across runs without a random number generator.
+    fn span_id(&self, span: SpanId) -> [u8; 8] {
+        let mut hasher = Sha256::new();
+        hasher.update(self.id);
+        hasher.update((span.0 as u64).to_le_bytes());
+        let mut id = [0; 8];
+        id.copy_from_slice(&hasher.finalize()[..8]);
+        id
+    }
+
+    fn unix_nanos(&self, instant: Instant) -> u128 {
+        let (origin, wall) = self.origin;
+        let time = match instant.checked_duration_since(origin) {
+            Some(after) => wall + after,
+            None => wall - origin.duration_since(instant),
+        };
+        time.duration_since(UNIX_EPOCH)
+            .map_or(0, |since| since.as_nanos())
+    }
+}
+
+/// Posts `request` to the OTLP/HTTP endpoint `endpoint`. A URL already
+/// ending in `/v1/traces` is used as it is.
+pub fn export(endpoint: &str, request: &Value) -> Result<(), String> {
+    let url = traces_url(endpoint);
+    let headers: Vec<String> = env::var("OTEL_EXPORTER_OTLP_HEADERS")
+        .map(|headers| parse_headers(&headers))
+        .unwrap_or_default();
+    // A collector that doesn't answer shouldn't hold up CI.
+    let mut args = vec![
+        "-fsSL",
+        "--max-time",
+        "10",
+        "-X",
+        "POST",
+        "-H",
+        "Content-Type: application/json",
+    ];
+    for header in &headers {
+        args.extend(["-H", header.as_str()]);
+    }
+    args.extend(["--data-binary", "@-", url.as_str()]);
+    curl(&args, Some(&request.to_string())).map(|_| ())
+}
+
+fn traces_url(endpoint: &str) -> String {
+    let endpoint = endpoint.trim_end_matches('/');
+    if endpoint.ends_with("/v1/traces") {
+        endpoint.to_string()
+    } else {
+        format!("{}/v1/traces", endpoint)
+    }
+}
+
+/// `OTEL_EXPORTER_OTLP_HEADERS`, `key=value` pairs separated by commas, as
+/// curl `-H` values.
+fn parse_headers(headers: &str) -> Vec<String> {
+    headers
+        .split(',')
+        .filter_map(|pair| {
+            let (key, value) = pair.split_once('=')?;
+            Some(format!("{}: {}", key.trim(), value.trim()))
+        })
+        .collect()
+}
+
+fn attributes(attributes: &[(String, Attribute)]) -> Value {
+    attributes
+        .iter()
+        .map(|(key, value)| {
+            let value = match value {
+                Attribute::String(text) => json!({ "stringValue": text }),
+                // OTLP JSON writes 64-bit integers as strings.
+                Attribute::Int(number) => json!({ "intValue": number.to_string() }),
+                Attribute::Bool(flag) => json!({ "boolValue": flag }),
+            };
+            json!({ "key": key, "value": value })
+        })
+        .collect()
+}
+
+fn hex(bytes: &[u8]) -> String {
+    bytes.iter().map(|byte| format!("{:02x}", byte)).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+
+    fn profile(started: Instant, elapsed: u64, rules: &[(&str, u64)]) -> FileProfile {
+        let mut steps = Vec::new();
+        let mut at = started;
+        for (rule, millis) in rules {
+            let elapsed = Duration::from_millis(*millis);
+            steps.push((format!("rule {}", rule), at, elapsed));
+            at += elapsed;
+        }
+        FileProfile {
+            elapsed: Duration::from_millis(elapsed),
+            started: Some(started),
+            steps,
+            ..FileProfile::default()
+        }
+    }
+
+    #[test]
+    fn test_files_nest_under_their_package() {
+        let started = Instant::now();
+        let mut trace = Trace::new(started);
+        let first = started + Duration::from_millis(5);
+        let second = started + Duration::from_millis(7);
+        trace.file(
+            "api/server.go",
+            &profile(first, 10, &[("panic_usage", 4)]),
+            2,
+        );
+        trace.file("api/client.go", &profile(second, 10, &[]), 0);
+        trace.file("main.go", &profile(started, 1, &[]), 0);
+        // A file that was never analyzed has no spans.
+        assert!(trace
+            .file("skipped.go", &FileProfile::default(), 0)
+            .is_none());
+
+        let request = trace.finish(started + Duration::from_millis(30));
+        let spans = request["resourceSpans"][0]["scopeSpans"][0]["spans"]
+            .as_array()
+            .unwrap();
+        let names: Vec<&str> = spans
+            .iter()
+            .map(|span| span["name"].as_str().unwrap())
+            .collect();
+        assert_eq!(
+            names,
+            [
+                "compass",
+                "package api",
+                "analyze api/server.go",
+                "rule panic_usage",
+                "analyze api/client.go",
+                "package .",
+                "analyze main.go",
+            ]
+        );
+
+        let nanos = |span: &Value, key: &str| span[key].as_str().unwrap().parse::<u128>().unwrap();
+        // The package runs from its first file's start to its last one's end.
+        assert_eq!(
+            nanos(&spans[1], "endTimeUnixNano") - nanos(&spans[1], "startTimeUnixNano"),
+            12_000_000
+        );
+        assert_eq!(
+            nanos(&spans[0], "endTimeUnixNano") - nanos(&spans[0], "startTimeUnixNano"),
+            30_000_000
+        );
+        assert_eq!(spans[2]["parentSpanId"], spans[1]["spanId"]);
+        assert_eq!(spans[3]["parentSpanId"], spans[2]["spanId"]);
+        assert!(spans[0].get("parentSpanId").is_none());
+        assert_eq!(spans[0]["traceId"].as_str().unwrap().len(), 32);
+        assert_eq!(spans[0]["spanId"].as_str().unwrap().len(), 16);
+        assert_eq!(
+            spans[1]["attributes"],
+            json!([
+                { "key": "compass.package", "value": { "stringValue": "api" } },
+                { "key": "compass.files", "value": { "intValue": "2" } },
+            ])
+        );
+        assert_eq!(
+            spans[3]["attributes"],
+            json!([{ "key": "compass.rule", "value": { "stringValue": "panic_usage" } }])
+        );
+    }
+
+    #[test]
+    fn test_endpoint_and_headers() {
+        assert_eq!(
+            traces_url("http://localhost:4318"),
+            "http://localhost:4318/v1/traces"
+        );
+        assert_eq!(
+            traces_url("https://otel.example.com/v1/traces/"),
+            "https://otel.example.com/v1/traces"
+        );
+        assert_eq!(
+            parse_headers("x-api-key=abc, tenant = ci"),
+            ["x-api-key: abc", "tenant: ci"]
+        );
+    }
+}
@@ -0,0 +1,427 @@
+//! The Go vulnerability database, for `compass check --vuln`.
+//!
+//! Compass reads a local copy in the layout https://vuln.go.dev serves and
+//! `govulncheck -db file://...` reads: `index/modules.json` lists the
+//! vulnerabilities of each module, and `ID/<id>.json` holds each one as an
+//! OSV entry, with the versions it affects and the packages and symbols
+//! that are vulnerable. The whole database is published as
+//! https://vuln.go.dev/vulndb.zip; nothing is downloaded.
+//!
+//! The copy is found through the rules' `db` option, else `$GOVULNDB`,
+//! either a directory or a `file://` URL. Vulnerabilities of the standard
+//! library aren't reported: which Go builds the module isn't in its
+//! `go.mod`.
+
+use crate::module::compare_versions;
+use serde::Deserialize;
+use std::cmp::Ordering;
+use std::collections::HashMap;
+use std::env;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex, OnceLock};
+
+/// The environment variable naming the database when a rule doesn't.
+pub const DB_ENV: &str = "GOVULNDB";
+
+/// One entry of the database.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Vulnerability {
+    /// Such as `GO-2022-0969`.
+    pub id: String,
+    /// The CVE and GHSA IDs of the same vulnerability.
+    pub aliases: Vec<String>,
+    pub summary: String,
+    pub affected: Vec<Affected>,
+}
+
+/// A module a vulnerability affects, and where.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Affected {
+    pub module: String,
+    /// The `introduced` and `fixed` versions, with their `v`, in order.
+    events: Vec<Event>,
+    /// The vulnerable packages; all of the module's when there are none.
+    pub packages: Vec<AffectedPackage>,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Event {
+    Introduced(String),
+    Fixed(String),
+    LastAffected(String),
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct AffectedPackage {
+    pub path: String,
+    /// Functions, and methods as `Type.Method`; the whole package when
+    /// there are none.
+    pub symbols: Vec<String>,
+}
+
+impl Vulnerability {
+    /// The ID with its CVE, or another alias, as in `GO-2022-0969
+    /// (CVE-2022-27664)`.
+    pub fn title(&self) -> String {
+        let alias = self
+            .aliases
+            .iter()
+            .find(|alias| alias.starts_with("CVE-"))
+            .or_else(|| self.aliases.first());
+        match alias {
+            Some(alias) => format!("{} ({})", self.id, alias),
+            None => self.id.clone(),
+        }
+    }
+
+    /// Whether `ids` names it, by its ID or an alias.
+    pub fn is_named(&self, ids: &[String]) -> bool {
+        ids.iter()
+            .any(|id| *id == self.id || self.aliases.contains(id))
+    }
+
+    /// What fixes it for `module` at `version`, for messages.
+    pub fn fix(&self, module: &str, version: &str) -> String {
+        let fixed = self
+            .affecting(module, version)
+            .and_then(|affected| affected.fixed_after(version));
+        match fixed {
+            Some(fixed) => format!("fixed in {} {}", module, fixed),
+            None => format!("{} has no fixed version yet", module),
+        }
+    }
+
+    /// Where it affects `module` at `version`.
+    pub fn affecting(&self, module: &str, version: &str) -> Option<&Affected> {
+        self.affected
+            .iter()
+            .find(|affected| affected.module == module && affected.affects(version))
+    }
+}
+
+impl Affected {
+    /// Whether `version` is in one of the affected ranges.
+    pub fn affects(&self, version: &str) -> bool {
+        let mut affected = false;
+        for event in &self.events {
+            match event {
+                Event::Introduced(introduced) => {
+                    affected |= compare_versions(version, introduced) != Ordering::Less;
+                }
+                Event::Fixed(fixed) => {
+                    affected &= compare_versions(version, fixed) == Ordering::Less;
+                }
+                Event::LastAffected(last) => {
+                    affected &= compare_versions(version, last) != Ordering::Greater;
+                }
+            }
+        }
+        affected
+    }
+
+    /// The first version after `version` that is fixed, if any is.
+    pub fn fixed_after(&self, version: &str) -> Option<&str> {
+        self.events.iter().find_map(|event| match event {
+            Event::Fixed(fixed) if compare_versions(fixed, version) == Ordering::Greater => {
+                Some(fixed.as_str())
+            }
+            _ => None,
+        })
+    }
+
+    /// The symbols of `package` that are vulnerable: `Some` of none when it
+    /// all is, and `None` when it isn't one of the vulnerable packages.
+    pub fn symbols(&self, package: &str) -> Option<&[String]> {
+        if self.packages.is_empty() {
+            return Some(&[]);
+        }
+        self.packages
+            .iter()
+            .find(|affected| affected.path == package)
+            .map(|affected| affected.symbols.as_slice())
+    }
+}
+
+/// A local copy of the database.
+pub struct Database {
+    dir: PathBuf,
+    /// The IDs of each module's vulnerabilities.
+    modules: HashMap<String, Vec<String>>,
+    entries: Mutex<HashMap<String, Option<Arc<Vulnerability>>>>,
+}
+
+impl Database {
+    /// Where the database is: `option`, else `$GOVULNDB`.
+    pub fn locate(option: Option<&str>) -> Option<String> {
+        option
+            .map(str::to_string)
+            .or_else(|| env::var(DB_ENV).ok())
+            .filter(|location| !location.is_empty())
+    }
+
+    /// The database at `location`, a directory or a `file://` URL. Its
+    /// index is read once per process.
+    pub fn open(location: &str) -> Result<Arc<Database>, String> {
+        static OPENED: OnceLock<Mutex<HashMap<String, Arc<Database>>>> = OnceLock::new();
+        let opened = OPENED.get_or_init(Default::default);
+        if let Some(database) = opened
+            .lock()
+            .ok()
+            .and_then(|opened| opened.get(location).cloned())
+        {
+            return Ok(database);
+        }
+        let dir = Path::new(location.strip_prefix("file://").unwrap_or(location));
+        let database = Arc::new(Database::read(dir)?);
+        if let Ok(mut opened) = opened.lock() {
+            opened.insert(location.to_string(), database.clone());
+        }
+        Ok(database)
+    }
+
+    fn read(dir: &Path) -> Result<Database, String> {
+        #[derive(Deserialize)]
+        struct IndexedModule {
+            path: String,
+            #[serde(default)]
+            vulns: Vec<IndexedVuln>,
+        }
+        #[derive(Deserialize)]
+        struct IndexedVuln {
+            id: String,
+        }
+
+        let index = dir.join("index").join("modules.json");
+        let content = fs::read_to_string(&index)
+            .map_err(|e| format!("failed to read '{}': {}", index.display(), e))?;
+        let indexed: Vec<IndexedModule> = serde_json::from_str(&content)
+            .map_err(|e| format!("failed to parse '{}': {}", index.display(), e))?;
+        let modules = indexed
+            .into_iter()
+            .map(|module| {
+                let ids = module.vulns.into_iter().map(|vuln| vuln.id).collect();
+                (module.path, ids)
+            })
+            .collect();
+        Ok(Database {
+            dir: dir.to_path_buf(),
+            modules,
+            entries: Mutex::new(HashMap::new()),
+        })
+    }
+
+    /// The vulnerabilities affecting `module` at `version`, in ID order.
+    /// Entries that can't be read are left out.
+    pub fn affecting(&self, module: &str, version: &str) -> Vec<Arc<Vulnerability>> {
+        let Some(ids) = self.modules.get(module) else {
+            return Vec::new();
+        };
+        let mut found: Vec<Arc<Vulnerability>> = ids
+            .iter()
+            .filter_map(|id| self.entry(id))
+            .filter(|vulnerability| vulnerability.affecting(module, version).is_some())
+            .collect();
+        found.sort_by(|a, b| a.id.cmp(&b.id));
+        found
+    }
+
+    fn entry(&self, id: &str) -> Option<Arc<Vulnerability>> {
+        if let Some(entry) = self.entries.lock().ok()?.get(id) {
+            return entry.clone();
+        }
+        let entry = fs::read_to_string(self.dir.join("ID").join(format!("{}.json", id)))
+            .ok()
+            .and_then(|content| parse_entry(&content))
+            .map(Arc::new);
+        self.entries
+            .lock()
+            .ok()?
+            .insert(id.to_string(), entry.clone());
+        entry
+    }
+}
+
+/// An OSV entry of the database, unless it was withdrawn.
+fn parse_entry(content: &str) -> Option<Vulnerability> {
+    #[derive(Deserialize)]
+    struct Entry {
+        id: String,
+        #[serde(default)]
+        aliases: Vec<String>,
+        #[serde(default)]
+        summary: String,
+        #[serde(default)]
+        details: String,
+        withdrawn: Option<String>,
+        #[serde(default)]
+        affected: Vec<EntryAffected>,
+    }
+    #[derive(Deserialize)]
+    struct EntryAffected {
+        package: EntryPackage,
+        #[serde(default)]
+        ranges: Vec<EntryRange>,
+        ecosystem_specific: Option<EcosystemSpecific>,
+    }
+    #[derive(Deserialize)]
+    struct EntryPackage {
+        name: String,
+    }
+    #[derive(Deserialize)]
+    struct EntryRange {
+        #[serde(rename = "type")]
+        kind: String,
+        #[serde(default)]
+        events: Vec<HashMap<String, String>>,
+    }
+    #[derive(Deserialize)]
+    struct EcosystemSpecific {
+        #[serde(default)]
+        imports: Vec<EntryImport>,
+    }
+    #[derive(Deserialize)]
+    struct EntryImport {
+        path: String,
+        #[serde(default)]
+        symbols: Vec<String>,
+    }
+
+    let entry: Entry = serde_json::from_str(content).ok()?;
+    if entry.withdrawn.is_some() {
+        return None;
+    }
+    let summary = match entry.summary.is_empty() {
+        true => entry.details.lines().next().unwrap_or_default().to_string(),
+        false => entry.summary,
+    };
+    // OSV versions have no `v`; `0` is before every version.
+    let version = |version: &str| match version {
+        "0" => "v0.0.0-0".to_string(),
+        _ => format!("v{}", version),
+    };
+    let affected = entry
+        .affected
+        .into_iter()
+        .map(|affected| {
+            let events = affected
+                .ranges
+                .iter()
+                .filter(|range| range.kind == "SEMVER")
+                .flat_map(|range| &range.events)
+                .flat_map(|event| {
+                    event.iter().filter_map(|(kind, at)| match kind.as_str() {
+                        "introduced" => Some(Event::Introduced(version(at))),
+                        "fixed" => Some(Event::Fixed(version(at))),
+                        "last_affected" => Some(Event::LastAffected(version(at))),
+                        _ => None,
+                    })
+                })
+                .collect();
+            let packages = affected
+                .ecosystem_specific
+                .map(|specific| specific.imports)
+                .unwrap_or_default()
+                .into_iter()
+                .map(|import| AffectedPackage {
+                    path: import.path,
+                    symbols: import.symbols,
+                })
+                .collect();
+            Affected {
+                module: affected.package.name,
+                events,
+                packages,
+            }
+        })
+        .collect();
+    Some(Vulnerability {
+        id: entry.id,
+        aliases: entry.aliases,
+        summary,
+        affected,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const ENTRY: &str = r#"{
+        "schema_version": "1.3.1",
+        "id": "GO-2020-0015",
+        "aliases": ["GHSA-5rcv-m4m3-hfh2", "CVE-2020-14040"],
+        "summary": "Infinite loop when decoding some inputs in golang.org/x/text",
+        "affected": [{
+            "package": {"name": "golang.org/x/text", "ecosystem": "Go"},
+            "ranges": [{"type": "SEMVER", "events": [
+                {"introduced": "0"}, {"fixed": "0.3.3"},
+                {"introduced": "0.4.0"}, {"fixed": "0.4.1"}
+            ]}],
+            "ecosystem_specific": {"imports": [
+                {"path": "golang.org/x/text/transform", "symbols": ["String", "Reader.Read"]}
+            ]}
+        }]
+    }"#;
+
+    #[test]
+    fn test_entries_affect_their_ranges() {
+        let vulnerability = parse_entry(ENTRY).unwrap();
+        assert_eq!(vulnerability.title(), "GO-2020-0015 (CVE-2020-14040)");
+        assert!(vulnerability.is_named(&["CVE-2020-14040".to_string()]));
+
+        let affected = &vulnerability.affected[0];
+        assert!(affected.affects("v0.3.2"));
+        assert!(affected.affects("v0.0.0-20170915032832-14c0d48ead0c"));
+        assert!(!affected.affects("v0.3.3"));
+        assert!(affected.affects("v0.4.0"));
+        assert!(!affected.affects("v0.5.0"));
+        assert_eq!(affected.fixed_after("v0.3.2"), Some("v0.3.3"));
+        assert_eq!(affected.fixed_after("v0.4.0"), Some("v0.4.1"));
+        assert_eq!(
+            vulnerability.fix("golang.org/x/text", "v0.3.2"),
+            "fixed in golang.org/x/text v0.3.3"
+        );
+        assert!(vulnerability
+            .affecting("golang.org/x/net", "v0.3.2")
+            .is_none());
+
+        assert_eq!(
+            affected.symbols("golang.org/x/text/transform"),
+            Some(&["String".to_string(), "Reader.Read".to_string()][..])
+        );
+        assert_eq!(affected.symbols("golang.org/x/text/language"), None);
+        assert!(parse_entry(
+            &ENTRY.replace("\"id\"", "\"withdrawn\": \"2021-01-01T00:00:00Z\", \"id\"")
+        )
+        .is_none());
+    }
+
+    #[test]
+    fn test_databases_are_read_from_a_directory() {
+        let dir = std::env::temp_dir().join(format!("compass-vuln-{}", std::process::id()));
+        fs::create_dir_all(dir.join("index")).unwrap();
+        fs::create_dir_all(dir.join("ID")).unwrap();
+        fs::write(
+            dir.join("index").join("modules.json"),
+            r#"[{"path": "golang.org/x/text", "vulns": [{"id": "GO-2020-0015", "fixed": "0.3.3"}]}]"#,
+        )
+        .unwrap();
+        fs::write(dir.join("ID").join("GO-2020-0015.json"), ENTRY).unwrap();
+
+        let location = format!("file://{}", dir.display());
+        let database = Database::open(&location).unwrap();
+        let ids = |version| {
+            database
+                .affecting("golang.org/x/text", version)
+                .iter()
+                .map(|vulnerability| vulnerability.id.clone())
+                .collect::<Vec<_>>()
+        };
+        assert_eq!(ids("v0.3.0"), ["GO-2020-0015"]);
+        assert!(ids("v0.3.8").is_empty());
+        assert!(database.affecting("example.com/safe", "v1.0.0").is_empty());
+        assert!(Database::open(&dir.join("missing").display().to_string()).is_err());
+        fs::remove_dir_all(&dir).unwrap();
+    }
+}
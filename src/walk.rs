@@ -0,0 +1,47 @@
+use crate::language::SupportedLanguage;
+use crate::project::EffectiveConfig;
+use std::collections::HashMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// Supported source files under `path`, sorted. Hidden entries and paths
+/// excluded by `.compass.toml` are skipped; a file path is returned as is if
+/// it is supported and not excluded.
+pub fn source_files<P: AsRef<Path>>(path: P) -> Result<Vec<PathBuf>, Box<dyn std::error::Error>> {
+    let path = path.as_ref();
+    let mut projects: HashMap<PathBuf, EffectiveConfig> = HashMap::new();
+    let mut files = Vec::new();
+
+    let mut pending = vec![path.to_path_buf()];
+    while let Some(current) = pending.pop() {
+        let dir = if current.is_dir() {
+            current.clone()
+        } else {
+            current.parent().map(Path::to_path_buf).unwrap_or_default()
+        };
+        if !projects.contains_key(&dir) {
+            projects.insert(dir.clone(), EffectiveConfig::for_path(&dir)?);
+        }
+        if projects[&dir].is_excluded(&current) {
+            continue;
+        }
+
+        if current.is_file() {
+            if SupportedLanguage::from_path(&current.to_string_lossy()).is_some() {
+                files.push(current);
+            }
+            continue;
+        }
+
+        for entry in fs::read_dir(&current)? {
+            let entry = entry?;
+            if entry.file_name().to_string_lossy().starts_with('.') {
+                continue;
+            }
+            pending.push(entry.path());
+        }
+    }
+
+    files.sort();
+    Ok(files)
+}
@@ -0,0 +1,246 @@
+//! `compass watch`: re-analyzes files as they change on disk.
+//!
+//! The tree is polled rather than subscribed to, which works the same on
+//! every platform and needs no extra dependencies. A file's findings depend
+//! on its own contents, the other files of its package (the directory it's
+//! in), the `.compass.toml` files above it and the config given on the
+//! command line; those are the only edges the watcher tracks. When one of
+//! them changes, just the packages that depend on it are analyzed again and
+//! reported. Within a file, rules that only read the declaration a finding
+//! is in re-run on the declarations that changed; see [`crate::incremental`].
+
+use crate::analyzer::AnalysisResult;
+use crate::format::FileFindings;
+use crate::incremental::Snapshot;
+use crate::language::SupportedLanguage;
+use crate::package::Package;
+use crate::plugin::Registry;
+use crate::project::{ConfigCache, EffectiveConfig, PROJECT_CONFIG_FILE};
+use crate::walk;
+use std::collections::{BTreeMap, BTreeSet, HashMap};
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::time::SystemTime;
+
+/// What a file looked like when it was last read.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct Stamp {
+    modified: Option<SystemTime>,
+    len: u64,
+}
+
+impl Stamp {
+    fn of(path: &Path) -> Option<Stamp> {
+        let metadata = fs::metadata(path).ok()?;
+        Some(Stamp {
+            modified: metadata.modified().ok(),
+            len: metadata.len(),
+        })
+    }
+}
+
+struct WatchedFile {
+    stamp: Option<Stamp>,
+    /// Config files the findings depend on, including `.compass.toml`
+    /// locations that don't exist yet but would apply if created.
+    configs: Vec<PathBuf>,
+    results: Vec<AnalysisResult>,
+    /// Why the file couldn't be analyzed, such as an unjustified suppression.
+    error: Option<String>,
+    /// What the last analysis found in each declaration.
+    snapshot: Option<Snapshot>,
+}
+
+/// The current findings of every file in a package that changed.
+pub struct PackageUpdate {
+    pub dir: PathBuf,
+    pub files: Vec<FileFindings>,
+    pub errors: Vec<String>,
+}
+
+pub struct Watcher {
+    root: PathBuf,
+    analyzers: ConfigCache,
+    files: BTreeMap<PathBuf, WatchedFile>,
+    configs: HashMap<PathBuf, Option<Stamp>>,
+}
+
+impl Watcher {
+    pub fn new<P: AsRef<Path>>(
+        root: P,
+        config_override: Option<String>,
+        registry: Registry,
+    ) -> Self {
+        Watcher {
+            root: root.as_ref().to_path_buf(),
+            analyzers: ConfigCache::new(config_override, registry),
+            files: BTreeMap::new(),
+            configs: HashMap::new(),
+        }
+    }
+
+    /// Looks for changes since the last poll and re-analyzes what they affect.
+    /// The first poll analyzes everything.
+    pub fn poll(&mut self) -> Result<Vec<PackageUpdate>, Box<dyn std::error::Error>> {
+        let changed_configs: BTreeSet<PathBuf> = self
+            .configs
+            .iter()
+            .filter(|(path, stamp)| Stamp::of(path) != **stamp)
+            .map(|(path, _)| path.clone())
+            .collect();
+        if !changed_configs.is_empty() {
+            self.analyzers.clear();
+        }
+
+        let sources: BTreeSet<PathBuf> = walk::source_files(&self.root)?.into_iter().collect();
+        let mut dirty: BTreeSet<PathBuf> = BTreeSet::new();
+
+        let removed: Vec<PathBuf> = self
+            .files
+            .keys()
+            .filter(|path| !sources.contains(*path))
+            .cloned()
+            .collect();
+        for path in removed {
+            self.files.remove(&path);
+            dirty.insert(path);
+        }
+
+        for path in &sources {
+            let stale = match self.files.get(path) {
+                None => true,
+                Some(file) => {
+                    file.stamp != Stamp::of(path)
+                        || file.configs.iter().any(|c| changed_configs.contains(c))
+                }
+            };
+            if stale {
+                dirty.insert(path.clone());
+            }
+        }
+
+        // Checks that read a file's package depend on its siblings, so a
+        // change re-analyzes the whole package.
+        let dirty_packages: BTreeSet<PathBuf> = dirty.iter().map(|path| package_of(path)).collect();
+        dirty.extend(
+            sources
+                .iter()
+                .filter(|path| dirty_packages.contains(&package_of(path)))
+                .cloned(),
+        );
+
+        for path in &dirty {
+            if !sources.contains(path) {
+                continue;
+            }
+            let stamp = Stamp::of(path);
+            let configs = self.dependencies(path)?;
+            for config in &configs {
+                self.configs.insert(config.clone(), Stamp::of(config));
+            }
+            let previous = self
+                .files
+                .get_mut(path)
+                .and_then(|file| file.snapshot.take());
+            let (results, error, snapshot) = match self.analyze(path, previous) {
+                Ok((results, snapshot)) => (results, None, Some(snapshot)),
+                Err(e) => (Vec::new(), Some(format!("{}: {}", path.display(), e)), None),
+            };
+            self.files.insert(
+                path.clone(),
+                WatchedFile {
+                    stamp,
+                    configs,
+                    results,
+                    error,
+                    snapshot,
+                },
+            );
+        }
+        for path in changed_configs {
+            let stamp = Stamp::of(&path);
+            self.configs.insert(path, stamp);
+        }
+
+        Ok(self.updates(&dirty))
+    }
+
+    /// The config files whose changes affect `path`'s findings.
+    fn dependencies(&self, path: &Path) -> Result<Vec<PathBuf>, Box<dyn std::error::Error>> {
+        let project = EffectiveConfig::for_path(path)?;
+        let mut configs = Vec::new();
+        for dir in fs::canonicalize(package_of(path))?.ancestors() {
+            configs.push(dir.join(PROJECT_CONFIG_FILE));
+            if dir == project.root {
+                break;
+            }
+        }
+        if let Some(config) = self.analyzers.config_override() {
+            configs.push(PathBuf::from(config));
+        }
+        Ok(configs)
+    }
+
+    fn analyze(
+        &mut self,
+        path: &Path,
+        previous: Option<Snapshot>,
+    ) -> Result<(Vec<AnalysisResult>, Snapshot), Box<dyn std::error::Error>> {
+        let display = path.to_string_lossy();
+        let language = SupportedLanguage::from_path(&display)
+            .ok_or_else(|| format!("unsupported file '{}'", display))?;
+        let text = fs::read_to_string(path)?;
+
+        let project = EffectiveConfig::for_path(path)?;
+        let analyzer = &self.analyzers.get(language, &project)?.analyzer;
+        let package = if analyzer.reads_package() {
+            Some(Package::load(path)?)
+        } else {
+            None
+        };
+        analyzer.analyze_incremental(
+            &text,
+            &language.tree_sitter_language(),
+            package.as_ref(),
+            previous,
+        )
+    }
+
+    /// Every package containing a dirty path, with all of its files' current
+    /// findings so a consumer can replace what it showed before.
+    fn updates(&self, dirty: &BTreeSet<PathBuf>) -> Vec<PackageUpdate> {
+        let dirs: BTreeSet<PathBuf> = dirty.iter().map(|path| package_of(path)).collect();
+        dirs.into_iter()
+            .map(|dir| {
+                let members: Vec<_> = self
+                    .files
+                    .iter()
+                    .filter(|(path, _)| package_of(path) == dir)
+                    .collect();
+                PackageUpdate {
+                    files: members
+                        .iter()
+                        .map(|(path, file)| FileFindings {
+                            path: path.to_string_lossy().into_owned(),
+                            module: None,
+                            owners: Vec::new(),
+                            results: file.results.clone(),
+                        })
+                        .collect(),
+                    errors: members
+                        .iter()
+                        .filter_map(|(_, file)| file.error.clone())
+                        .collect(),
+                    dir,
+                }
+            })
+            .collect()
+    }
+}
+
+fn package_of(path: &Path) -> PathBuf {
+    match path.parent() {
+        Some(dir) if !dir.as_os_str().is_empty() => dir.to_path_buf(),
+        _ => PathBuf::from("."),
+    }
+}
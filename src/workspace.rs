@@ -0,0 +1,276 @@
+//! Trees holding several Go modules.
+//!
+//! A `go.work` file names the modules of a workspace in its `use`
+//! directives; without one, every `go.mod` under the analyzed directory
+//! starts a module. Either way a file belongs to the module of the nearest
+//! `go.mod` above it, which is also the one dependency rules read, and a
+//! `.compass.toml` in a module's directory applies to that module alone, as
+//! any nested project config does.
+
+use crate::analyzer::Severity;
+use crate::format::FileFindings;
+use crate::module::{directives, Module, GO_MOD_FILE};
+use serde::Serialize;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+
+pub const GO_WORK_FILE: &str = "go.work";
+
+/// Directories the go command never treats as holding packages of the
+/// module above them.
+const SKIPPED_DIRS: &[&str] = &["vendor", "testdata"];
+
+#[derive(Debug, Default)]
+pub struct Workspace {
+    /// The `go.work` of the analyzed directory, if it has one.
+    pub go_work: Option<PathBuf>,
+    /// Sorted by root.
+    pub modules: Vec<Module>,
+}
+
+/// The findings of one module, for the per-module summary.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ModuleSummary {
+    pub module: String,
+    pub files: usize,
+    pub total_issues: usize,
+    pub errors: usize,
+    pub warnings: usize,
+    pub info: usize,
+    pub style: usize,
+}
+
+impl Workspace {
+    /// The modules `dir`'s `go.work` uses, when it has one. Otherwise the
+    /// module containing `dir`, if any, and every module below it.
+    pub fn discover(dir: &Path) -> io::Result<Workspace> {
+        let dir = dir.canonicalize()?;
+        let go_work = dir.join(GO_WORK_FILE);
+        let mut roots = Vec::new();
+        let go_work = if go_work.is_file() {
+            let content = fs::read_to_string(&go_work)?;
+            for (directive, args) in directives(&content) {
+                if let ("use", Some(path)) = (directive, args.first()) {
+                    roots.push(dir.join(path));
+                }
+            }
+            Some(go_work)
+        } else {
+            find_go_mods(&dir, &mut roots)?;
+            if let Some(module) = Module::find(&dir)? {
+                roots.push(module.root);
+            }
+            None
+        };
+
+        let mut modules = Vec::new();
+        for root in roots {
+            let go_mod = root.join(GO_MOD_FILE);
+            let content = fs::read_to_string(&go_mod).map_err(|e| {
+                io::Error::new(
+                    e.kind(),
+                    format!("failed to read '{}': {}", go_mod.display(), e),
+                )
+            })?;
+            modules.push(Module::parse(root.canonicalize()?, content));
+        }
+        modules.sort_by(|a, b| a.root.cmp(&b.root));
+        modules.dedup_by(|a, b| a.root == b.root);
+        Ok(Workspace { go_work, modules })
+    }
+
+    /// The module of `path`: the one rooted nearest above it.
+    pub fn module_for(&self, path: &Path) -> Option<&Module> {
+        let path = path.canonicalize().ok()?;
+        self.modules
+            .iter()
+            .filter(|module| path.starts_with(&module.root))
+            .max_by_key(|module| module.root.as_os_str().len())
+    }
+
+    /// Module roots outside `dir`, which a `go.work` can use with `../`.
+    pub fn roots_outside(&self, dir: &Path) -> Vec<&Path> {
+        let Ok(dir) = dir.canonicalize() else {
+            return Vec::new();
+        };
+        self.modules
+            .iter()
+            .map(|module| module.root.as_path())
+            .filter(|root| !root.starts_with(&dir))
+            .collect()
+    }
+
+    /// The module path of `path`, for tagging its findings.
+    pub fn tag(&self, path: &str) -> Option<String> {
+        self.module_for(Path::new(path))
+            .map(|module| module.path.clone())
+    }
+}
+
+/// Adds the directory of every `go.mod` below `dir`, skipping what the go
+/// command skips: hidden and `_` directories, `vendor` and `testdata`.
+fn find_go_mods(dir: &Path, roots: &mut Vec<PathBuf>) -> io::Result<()> {
+    for entry in fs::read_dir(dir)? {
+        let entry = entry?;
+        let name = entry.file_name();
+        let name = name.to_string_lossy();
+        if !entry.file_type()?.is_dir()
+            || name.starts_with('.')
+            || name.starts_with('_')
+            || SKIPPED_DIRS.contains(&name.as_ref())
+        {
+            continue;
+        }
+        let path = entry.path();
+        if path.join(GO_MOD_FILE).is_file() {
+            roots.push(path.clone());
+        }
+        find_go_mods(&path, roots)?;
+    }
+    Ok(())
+}
+
+/// Totals per module, in the order modules first appear in `files`. Files
+/// outside every module are left out.
+pub fn summarize(files: &[FileFindings]) -> Vec<ModuleSummary> {
+    let mut summaries = Vec::new();
+    for file in files {
+        add_to_summary(&mut summaries, file);
+    }
+    summaries
+}
+
+/// Counts `file` in the totals of its module, as [`summarize`] would.
+pub fn add_to_summary(summaries: &mut Vec<ModuleSummary>, file: &FileFindings) {
+    let Some(module) = &file.module else {
+        return;
+    };
+    let index = match summaries.iter().position(|s| &s.module == module) {
+        Some(index) => index,
+        None => {
+            summaries.push(ModuleSummary {
+                module: module.clone(),
+                files: 0,
+                total_issues: 0,
+                errors: 0,
+                warnings: 0,
+                info: 0,
+                style: 0,
+            });
+            summaries.len() - 1
+        }
+    };
+    let summary = &mut summaries[index];
+    summary.files += 1;
+    summary.total_issues += file.results.len();
+    for result in &file.results {
+        match result.severity {
+            Severity::Error => summary.errors += 1,
+            Severity::Warning => summary.warnings += 1,
+            Severity::Info => summary.info += 1,
+            Severity::Style => summary.style += 1,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analyzer::AnalysisResult;
+
+    fn write(path: &Path, content: &str) {
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(path, content).unwrap();
+    }
+
+    #[test]
+    fn test_discovers_nested_modules_and_go_work() {
+        let dir = std::env::temp_dir().join(format!("compass-workspace-{}", std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        write(&dir.join("api/go.mod"), "module example.com/api\n");
+        write(
+            &dir.join("api/internal/tools/go.mod"),
+            "module example.com/tools\n",
+        );
+        write(&dir.join("api/vendor/x/go.mod"), "module example.com/x\n");
+        write(&dir.join("web/main.go"), "package main\n");
+        write(&dir.join("shared/go.mod"), "module example.com/shared\n");
+
+        let workspace = Workspace::discover(&dir.join("api")).unwrap();
+        let paths: Vec<_> = workspace.modules.iter().map(|m| m.path.as_str()).collect();
+        assert_eq!(paths, ["example.com/api", "example.com/tools"]);
+        let nested = dir.join("api/internal/tools/gen.go");
+        write(&nested, "package tools\n");
+        assert_eq!(
+            workspace.tag(nested.to_str().unwrap()).as_deref(),
+            Some("example.com/tools")
+        );
+        assert_eq!(
+            workspace.tag(dir.join("web/main.go").to_str().unwrap()),
+            None
+        );
+
+        write(
+            &dir.join("api/go.work"),
+            "go 1.22\n\nuse (\n\t.\n\t../shared\n)\n",
+        );
+        let workspace = Workspace::discover(&dir.join("api")).unwrap();
+        let paths: Vec<_> = workspace.modules.iter().map(|m| m.path.as_str()).collect();
+        assert_eq!(paths, ["example.com/api", "example.com/shared"]);
+        assert_eq!(
+            workspace.roots_outside(&dir.join("api")),
+            [dir.join("shared").canonicalize().unwrap()]
+        );
+        fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_summarize_groups_by_module() {
+        let finding = |severity| AnalysisResult {
+            severity,
+            ..Default::default()
+        };
+        let files = [
+            FileFindings {
+                path: "api/main.go".to_string(),
+                module: Some("example.com/api".to_string()),
+                owners: Vec::new(),
+                results: vec![finding(Severity::Error), finding(Severity::Info)],
+            },
+            FileFindings {
+                path: "tools/gen.go".to_string(),
+                module: Some("example.com/tools".to_string()),
+                owners: Vec::new(),
+                results: Vec::new(),
+            },
+            FileFindings {
+                path: "api/db.go".to_string(),
+                module: Some("example.com/api".to_string()),
+                owners: Vec::new(),
+                results: vec![finding(Severity::Warning)],
+            },
+            FileFindings {
+                path: "script.js".to_string(),
+                module: None,
+                owners: Vec::new(),
+                results: vec![finding(Severity::Style)],
+            },
+        ];
+        let summaries = summarize(&files);
+        assert_eq!(summaries.len(), 2);
+        assert_eq!(
+            summaries[0],
+            ModuleSummary {
+                module: "example.com/api".to_string(),
+                files: 2,
+                total_issues: 3,
+                errors: 1,
+                warnings: 1,
+                info: 1,
+                style: 0,
+            }
+        );
+        assert_eq!(summaries[1].files, 1);
+    }
+}
@@ -0,0 +1,7 @@
+package main
+
+func Run() {}
+
+func main() {
+	Run()
+}
@@ -0,0 +1,5 @@
+package codec
+
+func Encode(v any) ([]byte, error) {
+	return nil, nil
+}
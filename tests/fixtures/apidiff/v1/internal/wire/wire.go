@@ -0,0 +1,5 @@
+package wire
+
+func Frame(b []byte) []byte {
+	return b
+}
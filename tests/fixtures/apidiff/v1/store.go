@@ -0,0 +1,49 @@
+package store
+
+import "time"
+
+// Kind says how a record's value is encoded.
+type Kind int
+
+const (
+	KindBytes Kind = iota
+	KindText
+)
+
+// Store is implemented by every backend.
+type Store interface {
+	Get(key string) (*Record, error)
+	Put(r *Record) error
+}
+
+// Event is sent to watchers. Only this package implements it.
+type Event interface {
+	Key() string
+	event()
+}
+
+type Record struct {
+	Key   string
+	Value []byte
+	Size  int
+	Kind  Kind
+	dirty bool
+}
+
+type DB struct {
+	path string
+}
+
+func Open(path string) (*DB, error) {
+	return &DB{path: path}, nil
+}
+
+func (db *DB) Get(key string) (*Record, error) {
+	return nil, nil
+}
+
+func (db *DB) Close() error {
+	return nil
+}
+
+var DefaultTimeout = 5 * time.Second
@@ -0,0 +1,5 @@
+package wire
+
+func Frame(b []byte, n int) []byte {
+	return b[:n]
+}
@@ -0,0 +1,57 @@
+package store
+
+import "time"
+
+// Kind says how a record's value is encoded.
+type Kind int
+
+const (
+	KindBytes Kind = iota
+)
+
+// Store is implemented by every backend.
+type Store interface {
+	Get(key string) (*Record, error)
+	Put(r *Record) error
+	Delete(key string) error
+}
+
+// Event is sent to watchers. Only this package implements it.
+type Event interface {
+	Key() string
+	Time() time.Time
+	event()
+}
+
+type Record struct {
+	Key     string
+	Size    int64
+	Kind    Kind
+	Created time.Time
+}
+
+type DB struct {
+	path     string
+	readOnly bool
+}
+
+// Option configures Open.
+type Option func(*DB)
+
+func Open(path string, opts ...Option) (*DB, error) {
+	db := &DB{path: path}
+	for _, opt := range opts {
+		opt(db)
+	}
+	return db, nil
+}
+
+func OpenReadOnly(path string) (*DB, error) {
+	return &DB{path: path, readOnly: true}, nil
+}
+
+func (db *DB) Get(name string) (*Record, error) {
+	return nil, nil
+}
+
+var DefaultTimeout = 10 * time.Second
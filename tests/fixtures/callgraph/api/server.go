@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"example.com/app/store"
+)
+
+type Server struct {
+	db store.DB
+}
+
+func (s *Server) Handle(w http.ResponseWriter, r *http.Request) {
+	s.load(r.URL.Path)
+	go func() {
+		store.Open()
+	}()
+}
+
+func (s *Server) load(id string) {
+	s.db.Get(id)
+	logf(id)
+}
+
+func logf(string) {}
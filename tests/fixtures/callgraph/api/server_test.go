@@ -0,0 +1,7 @@
+package api
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	(&Server{}).load("x")
+}
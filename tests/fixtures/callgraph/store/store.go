@@ -0,0 +1,13 @@
+package store
+
+type DB interface {
+	Get(id string)
+}
+
+type memory struct{}
+
+func (memory) Get(id string) {}
+
+func Open() DB {
+	return memory{}
+}
@@ -0,0 +1,120 @@
+package channels
+
+import (
+	"context"
+	"sync"
+)
+
+func sendAfterClose(ch chan int, err error) {
+	if err != nil {
+		close(ch)
+		return
+	}
+	close(ch)
+	ch <- 1
+}
+
+func closeInLoop(ch chan int, items []int) {
+	for _, item := range items {
+		ch <- item
+		if item < 0 {
+			close(ch)
+		}
+	}
+}
+
+func closeBeforeWorkersFinish(jobs []int) chan int {
+	results := make(chan int, len(jobs))
+	for _, job := range jobs {
+		go func(job int) {
+			results <- job * 2
+		}(job)
+	}
+	close(results)
+	return results
+}
+
+func closeAfterWait(jobs []int) chan int {
+	results := make(chan int, len(jobs))
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job int) {
+			defer wg.Done()
+			results <- job * 2
+		}(job)
+	}
+	wg.Wait()
+	close(results)
+	return results
+}
+
+func drain(ch chan int) int {
+	total := 0
+	for v := range ch {
+		total += v
+		if total > 100 {
+			close(ch)
+			break
+		}
+	}
+	return total
+}
+
+func produce(n int) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for i := 0; i < n; i++ {
+			out <- i
+		}
+	}()
+	return out
+}
+
+func nilChannel(ctx context.Context, useTimer bool) int {
+	var ticks chan int
+	var done chan struct{}
+	if useTimer {
+		done = make(chan struct{})
+	}
+	<-done
+	ticks <- 1
+	select {
+	case v := <-ticks:
+		return v
+	case <-ctx.Done():
+		return -1
+	}
+}
+
+func fetch(ctx context.Context, get func() int) (int, error) {
+	result := make(chan int)
+	go func() {
+		result <- get()
+	}()
+	select {
+	case v := <-result:
+		return v, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func fetchAndWait(get func() int) int {
+	result := make(chan int)
+	go func() {
+		result <- get()
+	}()
+	return <-result
+}
+
+func waitOne(done chan struct{}) {
+	select {
+	case <-done:
+	}
+}
+
+func waitForever() {
+	select {}
+}
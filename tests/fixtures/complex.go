@@ -0,0 +1,37 @@
+package main
+
+func simple(x int) int {
+	return x + 1
+}
+
+func branchy(a, b int, ok bool) int {
+	if a > 0 && b > 0 {
+		return 1
+	} else if a < 0 {
+		return 2
+	}
+	for i := 0; i < a; i++ {
+		switch i {
+		case 1:
+			continue
+		case 2:
+			break
+		default:
+		}
+	}
+	return 0
+}
+
+func nested(items [][]int) int {
+	total := 0
+	for _, row := range items {
+		for _, v := range row {
+			if v > 0 {
+				total += v
+			} else {
+				total -= v
+			}
+		}
+	}
+	return total
+}
@@ -0,0 +1,21 @@
+package shop
+
+import (
+	"net/http"
+	"time"
+)
+
+type Client struct {
+	buf [4096]byte
+}
+
+func Fetch(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 30 * time.Second}
+	_ = make([]byte, 65536)
+	return client.Do(req)
+}
@@ -0,0 +1,11 @@
+package shop
+
+import "testing"
+
+func TestFetch(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		if _, err := Fetch("application/json"); err == nil {
+			t.Fatal("application/json")
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package shop
+
+import (
+	"net/http"
+	"time"
+)
+
+const contentType = "application/json"
+
+type Server struct {
+	Name string `json:"name"`
+	buf  [4096]byte
+}
+
+func Serve(mux *http.ServeMux) error {
+	srv := &http.Server{Addr: ":8443", ReadTimeout: 30 * time.Second}
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		w.Write(make([]byte, 65536))
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept", "application/json")
+		w.WriteHeader(200)
+	})
+	retry(5, 65536)
+	return srv.ListenAndServe()
+}
+
+func retry(attempts, size int) {
+	for i := 0; i < attempts; i++ {
+		time.Sleep(time.Duration(i) * time.Second)
+	}
+}
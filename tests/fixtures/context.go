@@ -0,0 +1,45 @@
+// Test Go file exercising context propagation
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"os/exec"
+)
+
+func fetch(ctx context.Context, db *sql.DB, id string) error {
+	rows, err := db.Query("SELECT name FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	return load(nil, id)
+}
+
+func load(ctx context.Context, id string) error {
+	return ctx.Err()
+}
+
+func handler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ctx := context.Background()
+	if err := load(ctx, query.Get("id")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	}
+}
+
+func worker(ctx context.Context) {
+	go func() {
+		cmd := exec.Command("sync")
+		_ = cmd.Run()
+	}()
+}
+
+func main() {
+	ctx := context.Background()
+	if err := load(ctx, "1"); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"database/sql"
+	"log"
+	"net"
+)
+
+func open(dsn string) *Store {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		log.Printf("open: %v", err)
+	}
+	return New(db)
+}
+
+func reset() *Store {
+	return New(nil)
+}
+
+// Lookup never returns a nil store.
+func cached() string {
+	s, err := Lookup("main")
+	if err != nil {
+		log.Print(err)
+	}
+	return s.name
+}
+
+// Serve closes the connection.
+func greet(addr string, l *Log) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	Serve(conn, l)
+	return nil
+}
+
+// Describe doesn't, so it's still ours to close.
+func peer(addr string) (string, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	return Describe(conn), nil
+}
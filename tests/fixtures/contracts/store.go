@@ -0,0 +1,54 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+)
+
+var errEmpty = errors.New("empty name")
+
+type Store struct {
+	name string
+	db   *sql.DB
+}
+
+type Log struct{}
+
+func (l *Log) Write(v any) {}
+
+// New wraps an open database.
+//
+//compass:nonnil param=db
+//compass:nonnil
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Lookup finds a store by name.
+//
+//compass:nonnil
+func Lookup(name string) (*Store, error) {
+	if name == "" {
+		return nil, errEmpty
+	}
+	return &Store{name: name}, nil
+}
+
+// Serve answers one client and hangs up.
+//
+//compass:closes conn
+func Serve(conn net.Conn, log *Log) {
+	if log == nil {
+		return
+	}
+	defer conn.Close()
+	log.Write(conn.RemoteAddr())
+}
+
+// Describe names the peer.
+//
+//compass:noescape
+func Describe(conn net.Conn) string {
+	return conn.RemoteAddr().String()
+}
@@ -0,0 +1,54 @@
+package store
+
+type Store struct {
+	items map[string]string
+}
+
+// NewStore is called directly by the tests.
+func NewStore() *Store {
+	s := &Store{}
+	s.reset()
+	return s
+}
+
+func (s *Store) reset() {
+	s.items = map[string]string{}
+}
+
+// Put is only reached through Load, which the tests call.
+func (s *Store) Put(key, value string) {
+	s.items[key] = value
+}
+
+func (s *Store) Load(pairs map[string]string) {
+	for k, v := range pairs {
+		s.Put(k, v)
+	}
+}
+
+// Items is a getter, too trivial to need a test.
+func (s *Store) Items() map[string]string {
+	return s.items
+}
+
+func (s *Store) Delete(key string) {
+	delete(s.items, key)
+}
+
+func (s *Store) Snapshot() map[string]string {
+	copied := map[string]string{}
+	for k, v := range s.items {
+		copied[k] = v
+	}
+	return copied
+}
+
+type cursor struct {
+	pos int
+}
+
+// Next is exported, but cursor isn't part of the package's API.
+func (c *cursor) Next() int {
+	c.pos++
+	return c.pos
+}
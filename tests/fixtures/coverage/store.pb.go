@@ -0,0 +1,12 @@
+package store
+
+func (x *Record) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+type Record struct {
+	Key string
+}
@@ -0,0 +1,11 @@
+package store
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	s := NewStore()
+	s.Load(map[string]string{"a": "1"})
+	if got := s.Items()["a"]; got != "1" {
+		t.Fatalf("got %q", got)
+	}
+}
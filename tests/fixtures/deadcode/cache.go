@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"errors"
+	"log"
+	"os"
+)
+
+const (
+	stateIdle = iota
+	stateBusy
+)
+
+const (
+	defaultSize = 128
+	legacyLimit = 64
+)
+
+const maxEntries, minEntries = 1024, 1
+
+type entry struct {
+	key     string
+	value   []byte
+	expires int64
+	hits    int
+}
+
+type point struct {
+	x, y int
+}
+
+type config struct {
+	Path string `json:"path"`
+	mode string `json:"mode"`
+}
+
+type compactor interface {
+	compact()
+}
+
+type Cache struct {
+	entries map[string]entry
+	size    int
+	state   int
+}
+
+func New() *Cache {
+	origin := point{0, 0}
+	_ = origin
+	return &Cache{entries: map[string]entry{}, size: defaultSize, state: stateIdle}
+}
+
+func (c *Cache) Get(key string) ([]byte, error) {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, errors.New("missing")
+	}
+	return e.value, nil
+}
+
+func (c *Cache) evict() {
+	c.evict()
+}
+
+func (c *Cache) compact() {}
+
+func hashKey(key string) uint32 {
+	return hashKey(key[1:])
+}
+
+func platformDir() string {
+	return os.TempDir()
+}
+
+//go:linkname runtimeNano runtime.nanotime
+func runtimeNano() int64
+
+func mustOpen(path string) *os.File {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return f
+}
@@ -0,0 +1,7 @@
+//go:build linux
+
+package cache
+
+func expired(e entry, now int64) bool {
+	return e.expires < now && len(platformDir()) < maxEntries
+}
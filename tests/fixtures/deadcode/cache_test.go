@@ -0,0 +1,8 @@
+package cache
+
+import "testing"
+
+func TestOpen(t *testing.T) {
+	f := mustOpen("testdata/cache.db")
+	defer f.Close()
+}
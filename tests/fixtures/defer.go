@@ -0,0 +1,71 @@
+package files
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"time"
+)
+
+type Store struct{}
+
+func (s *Store) Flush() error { return nil }
+
+func (s *Store) Release() {}
+
+func copyAll(paths []string) error {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	for _, path := range paths {
+		func() {
+			f, _ := os.Open(path)
+			defer f.Close()
+		}()
+	}
+	return nil
+}
+
+func handle(s *Store) (err error) {
+	start := time.Now()
+	defer log.Printf("handled in %s", time.Since(start))
+	defer log.Println("handle failed:", err)
+	defer func() {
+		log.Println("handle finished:", err)
+	}()
+	defer s.Release()
+	defer s.Flush()
+	return process()
+}
+
+func write(path string, lines []string) error {
+	status := "started"
+	defer log.Println("write", status)
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	for _, line := range lines {
+		w.WriteString(line)
+	}
+	status = "done"
+	return nil
+}
+
+func read(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return nil
+}
+
+func process() error { return nil }
@@ -0,0 +1,19 @@
+package shop
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+func load(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+func list(dir string) (int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	return len(entries), err
+}
+
+func heading(s string) string {
+	return strings.ToUpper(strings.Title(s))
+}
@@ -0,0 +1,10 @@
+module example.com/shop
+
+go 1.22
+
+require (
+	github.com/Acme/legacy v1.2.0
+	github.com/acme/retired v0.9.0
+	github.com/aws/aws-sdk-go v1.55.5
+	google.golang.org/grpc v1.64.0
+)
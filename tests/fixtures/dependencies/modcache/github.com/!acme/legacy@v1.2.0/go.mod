@@ -0,0 +1,3 @@
+module github.com/Acme/legacy
+
+go 1.18
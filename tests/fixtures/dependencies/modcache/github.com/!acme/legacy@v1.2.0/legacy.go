@@ -0,0 +1,32 @@
+// Package legacy is a client library that is halfway through a redesign.
+package legacy
+
+import "time"
+
+// Options configures a Client.
+//
+// Deprecated: pass functional options to NewClientV2.
+type Options struct {
+	Timeout time.Duration
+}
+
+const (
+	// Deprecated: timeouts come from the context now.
+	DefaultTimeout = 5 * time.Second
+	MaxRetries     = 3
+)
+
+type Client struct{}
+
+// NewClient creates a Client.
+//
+// Deprecated: use NewClientV2, which
+// takes functional options.
+func NewClient(opts Options) *Client {
+	return &Client{}
+}
+
+// NewClientV2 creates a Client.
+func NewClientV2() *Client {
+	return &Client{}
+}
@@ -0,0 +1,4 @@
+// Deprecated: use github.com/acme/current instead.
+module github.com/acme/retired
+
+go 1.16
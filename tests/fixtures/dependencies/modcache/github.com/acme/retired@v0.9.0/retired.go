@@ -0,0 +1,4 @@
+package retired
+
+// Do does the thing.
+func Do() {}
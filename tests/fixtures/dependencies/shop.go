@@ -0,0 +1,64 @@
+package shop
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Acme/legacy"
+	"github.com/acme/retired"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"google.golang.org/grpc"
+)
+
+func connect(addr string) (*grpc.ClientConn, error) {
+	return grpc.Dial(addr, grpc.WithBlock(), grpc.WithInsecure())
+}
+
+func connectWithTimeout(addr string) (*grpc.ClientConn, error) {
+	return grpc.Dial(addr, grpc.WithTimeout(time.Second), grpc.WithInsecure())
+}
+
+func clients() {
+	old := legacy.NewClient(legacy.Options{Timeout: legacy.DefaultTimeout})
+	current := legacy.NewClientV2()
+	_, _ = old, current
+	_ = legacy.MaxRetries
+	retired.Do()
+	_ = session.Must(session.NewSession())
+}
+
+func names(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM users")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func ids(db *sql.DB) ([]int, error) {
+	rows, err := db.Query("SELECT id FROM users")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,5 @@
+package util
+
+func Join(parts []string) string {
+	return ""
+}
@@ -0,0 +1,63 @@
+// Package api is the client for the docs service.
+package api
+
+// Client talks to the docs service.
+type Client struct {
+	addr string
+}
+
+// A Config holds the client's settings.
+type Config struct{}
+
+type Option func(*Client)
+
+// TODO
+func New(addr string) *Client {
+	return &Client{addr: addr}
+}
+
+// Do sends a request and returns the response body.
+func (c *Client) Do(path string) ([]byte, error) {
+	return nil, nil
+}
+
+// sends a request without waiting for the response.
+func (c *Client) Send(path string) error {
+	return nil
+}
+
+// Close.
+//
+//go:noinline
+func (c *Client) Close() error {
+	return nil
+}
+
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+func (c *Client) reset() {}
+
+type state struct{}
+
+func (s *state) Load() {}
+
+// Kinds of documents.
+const (
+	KindPage = iota
+	KindPost
+)
+
+const (
+	// FormatHTML renders pages as HTML.
+	FormatHTML = "html"
+	FormatText = "text"
+)
+
+// DefaultTimeout is how long a request may take, in seconds.
+var DefaultTimeout = 30
+
+var ErrNotFound = errNotFound()
+
+func errNotFound() error { return nil }
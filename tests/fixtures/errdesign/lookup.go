@@ -0,0 +1,11 @@
+package store
+
+import "strings"
+
+func Lookup(key string) string {
+	value, err := Get(key)
+	if err != nil && strings.Contains(err.Error(), "missing") {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrClosed = errors.New("store closed")
+
+var NotFound = errors.New("not found")
+
+var TimeoutError = fmt.Errorf("timed out")
+
+func Get(key string) (string, error) {
+	if key == "" {
+		return "", errors.New("Empty key.")
+	}
+	if key == "HTTP" {
+		return "", errors.New("HTTP key is reserved")
+	}
+	return "", fmt.Errorf("key %s missing", key)
+}
+
+func Open(path string) error {
+	return fmt.Errorf("Config.Load failed for %s", path)
+}
+
+func Must(key string) string {
+	value, err := Get(key)
+	if err != nil {
+		switch err.Error() {
+		case "Empty key.":
+			return "-"
+		}
+	}
+	return value
+}
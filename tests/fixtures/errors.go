@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+)
+
+type loader struct {
+	lastErr error
+	err     error
+}
+
+func checked() error {
+	f, err := os.Open("a")
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func overwritten() error {
+	_, err := os.Open("a")
+	_, err = os.Open("b")
+	return err
+}
+
+func shadowed(ok bool, data []byte) error {
+	if ok {
+		f, err := os.Create("out")
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+	}
+	return nil
+}
+
+func named() (err error) {
+	_, err = os.Open("a")
+	_, err = os.Open("b")
+	return
+}
+
+func namedOk() (err error) {
+	_, err = os.Open("a")
+	return
+}
+
+func deferredLost(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+	return tx.Commit()
+}
+
+func deferredNamed(db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = tx.Rollback()
+		}
+	}()
+	return tx.Commit()
+}
+
+func (l *loader) load() {
+	_, l.lastErr = os.Open("a")
+	_, l.err = os.Open("b")
+}
+
+func (l *loader) Err() error {
+	return l.err
+}
+
+func poll() {
+	var err error
+	for err == nil {
+		_, err = os.Open("a")
+	}
+}
+
+func earlyReturn() error {
+	_, err := os.Open("a")
+	return nil
+}
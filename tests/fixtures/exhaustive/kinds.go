@@ -0,0 +1,28 @@
+package render
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+	Blue
+)
+
+type Shape interface {
+	Area() float64
+	isShape()
+}
+
+type Circle struct{ R float64 }
+
+type Square struct{ Side float64 }
+
+type Triangle struct{ Base, Height float64 }
+
+func (Circle) isShape()   {}
+func (*Square) isShape()  {}
+func (Triangle) isShape() {}
+
+func (c Circle) Area() float64   { return 3.14 * c.R * c.R }
+func (s *Square) Area() float64  { return s.Side * s.Side }
+func (t Triangle) Area() float64 { return t.Base * t.Height / 2 }
@@ -0,0 +1,70 @@
+package render
+
+import "fmt"
+
+type Level string
+
+const (
+	Debug Level = "debug"
+	Info  Level = "info"
+	Warn  Level = "warn"
+)
+
+func hex(c Color) string {
+	switch c {
+	case Red:
+		return "#f00"
+	case Green:
+		return "#0f0"
+	}
+	return ""
+}
+
+func name(c Color) string {
+	switch c {
+	case Red, Green, Blue:
+		return fmt.Sprint(int(c))
+	}
+	return ""
+}
+
+func prefix(l Level) string {
+	switch l {
+	case Debug:
+		return "D"
+	default:
+		return "?"
+	}
+}
+
+func verbose(l Level) bool {
+	switch l {
+	case Debug:
+		return true
+	default:
+		// Info and Warn are not verbose.
+		return false
+	}
+}
+
+func describe(s Shape) string {
+	switch v := s.(type) {
+	case Circle:
+		return fmt.Sprint("circle ", v.R)
+	case *Square:
+		return "square"
+	}
+	return "unknown"
+}
+
+func corners(s Shape) int {
+	switch s.(type) {
+	case Circle:
+		return 0
+	case *Square:
+		return 4
+	case Triangle:
+		return 3
+	}
+	return -1
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"example.com/tool/store"
+)
+
+func init() {
+	if os.Getenv("TOOL_HOME") == "" {
+		log.Fatal("TOOL_HOME is not set")
+	}
+}
+
+func main() {
+	s, err := store.Open(os.Getenv("TOOL_HOME"))
+	if err != nil {
+		log.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+	go serve()
+	if err := run(s, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(s *store.Store, args []string) error {
+	if len(args) == 0 {
+		usage()
+	}
+	return s.Put(args[0])
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: tool NAME")
+	os.Exit(2)
+}
+
+func serve() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+	}()
+	<-done
+	log.Fatal("server stopped")
+}
+
+var onError = fail
+
+func fail(err error) {
+	log.Fatal(err)
+}
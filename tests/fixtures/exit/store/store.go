@@ -0,0 +1,30 @@
+package store
+
+import (
+	stdlog "log"
+	"os"
+)
+
+type Store struct {
+	file *os.File
+}
+
+func Open(path string) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{file: f}, nil
+}
+
+func (s *Store) Put(name string) error {
+	if name == "" {
+		stdlog.Fatalln("store: empty name")
+	}
+	_, err := s.file.WriteString(name + "\n")
+	return err
+}
+
+func (s *Store) Close() error {
+	return s.file.Close()
+}
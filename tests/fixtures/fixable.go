@@ -0,0 +1,15 @@
+// Test Go file with mechanically fixable issues
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	f, _ := os.Open("data.txt")
+	fmt.Println("opened")
+	f.Close()
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+type User struct {
+	Name string
+}
+
+var errMissing = errors.New("missing")
+
+func find[T any](items map[string]T, key string) (T, error) {
+	item, ok := items[key]
+	if !ok {
+		var zero T
+		return zero, errMissing
+	}
+	return item, nil
+}
+
+func first[T any](xs []T) T {
+	return xs[0]
+}
+
+func get[T any](pool map[string]T, key string) T {
+	return pool[key]
+}
+
+func ident[T any](v T) T {
+	return v
+}
+
+func keys[K comparable, V any](m map[K]V) []K {
+	out := make([]K, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+func acquire[C io.Closer](open func() (C, error)) (C, error) {
+	return open()
+}
+
+func userName(users map[string]*User, id string) string {
+	user, err := find(users, id)
+	if err != nil {
+		return user.Name
+	}
+	return user.Name
+}
+
+func count(counts map[string]int, id string) int {
+	n, err := find(counts, id)
+	if err != nil {
+		return n + 1
+	}
+	return n
+}
+
+func load(path string) error {
+	f, err := acquire(func() (*os.File, error) { return os.Open(path) })
+	if err != nil {
+		return err
+	}
+	_ = f.Name()
+	return nil
+}
+
+func lookup(files map[string]*os.File) {
+	file := get[*os.File](files, "config")
+	_ = file.Name()
+}
+
+type Cache[K comparable, V any] struct {
+	items map[K]V
+	hits  int
+	name  string
+}
+
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	v, ok := c.items[key]
+	if ok {
+		c.hits++
+	}
+	return v, ok
+}
+
+func (c *Cache[K, V]) Hits() int {
+	return c.hits
+}
+
+func (c *Cache[K, V]) Len() int {
+	return len(c.items)
+}
+
+func (c *Cache[_, _]) Name() string {
+	return c.name
+}
@@ -0,0 +1,15 @@
+package cache
+
+func leader(users []*User) string {
+	return first(users).Name
+}
+
+func oldest(users []*User) *User {
+	return first(users)
+}
+
+func summary(users map[string]*User, counts map[string]int) int {
+	_ = ident("users")
+	apply := ident[int]
+	return len(keys(users)) + len(keys(counts)) + apply(0)
+}
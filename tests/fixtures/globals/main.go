@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+var verbose = flag.Bool("v", false, "verbose output")
+
+var args = os.Args[1:]
+
+func init() {
+	flag.Parse()
+}
+
+func main() {
+	fmt.Println(*verbose, args)
+}
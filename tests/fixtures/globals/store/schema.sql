@@ -0,0 +1 @@
+CREATE TABLE entries (key TEXT PRIMARY KEY, value TEXT);
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"database/sql"
+	_ "embed"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+)
+
+var ErrNotFound = errors.New("store: not found")
+
+var validKey = regexp.MustCompile(`^[a-z]+$`)
+
+//go:embed schema.sql
+var schema string
+
+var (
+	cache   = map[string]string{}
+	mu      sync.Mutex
+	dataDir = os.Getenv("STORE_DIR")
+)
+
+var _ http.Handler = (*Server)(nil)
+
+var db *sql.DB
+
+type Server struct{}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+func init() {
+	http.Handle("/store", &Server{})
+	var err error
+	db, err = sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	if err != nil {
+		panic(err)
+	}
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		os.Stat(dataDir)
+	})
+}
@@ -0,0 +1,3 @@
+package store
+
+var fixtures = map[string]string{"a": "1"}
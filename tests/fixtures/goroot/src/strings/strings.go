@@ -0,0 +1,16 @@
+// Package strings implements simple functions to manipulate UTF-8 encoded strings.
+package strings
+
+// Title returns a copy of the string s with all Unicode letters that begin
+// words mapped to their Unicode title case.
+//
+// Deprecated: The rule Title uses for word boundaries does not handle Unicode
+// punctuation properly. Use golang.org/x/text/cases instead.
+func Title(s string) string {
+	return s
+}
+
+// ToUpper returns s with all Unicode letters mapped to their upper case.
+func ToUpper(s string) string {
+	return s
+}
@@ -0,0 +1,62 @@
+// Test Go file with leaking and well-behaved goroutines
+
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+func leakyReceive(ch chan int) {
+	go func() {
+		v := <-ch // Should trigger: nobody may ever send
+		println(v)
+	}()
+}
+
+func leakyLoop() {
+	go func() { // Should trigger: loops forever
+		for {
+			work()
+		}
+	}()
+}
+
+func leakyNamed(results chan int) {
+	go produce(results) // Should trigger: unbuffered send from a named function
+}
+
+func produce(results chan int) {
+	results <- 42
+}
+
+func withContext(ctx context.Context, ch chan int) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v := <-ch:
+				println(v)
+			}
+		}
+	}()
+}
+
+func withWaitGroup(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		work()
+	}()
+}
+
+func buffered() int {
+	results := make(chan int, 1)
+	go func() {
+		results <- 1
+	}()
+	return 0
+}
+
+func work() {}
@@ -0,0 +1,16 @@
+package store
+
+import "io"
+
+type Store interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+}
+
+type Sizer interface {
+	Len() int
+}
+
+var _ Store = (*diskStore)(nil)
+
+var _ io.Reader = (*stream)(nil)
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// memoryStore implements Store.
+type memoryStore struct {
+	items map[string][]byte
+}
+
+func (m *memoryStore) Get(key string) ([]byte, error) {
+	return m.items[key], nil
+}
+
+func (m *memoryStore) Put(key string, value []byte) error {
+	m.items[key] = value
+	return nil
+}
+
+// diskStore implements the [Store] interface.
+type diskStore struct {
+	dir string
+}
+
+func (d *diskStore) Get(key string) ([]byte, error) {
+	return nil, nil
+}
+
+func (d *diskStore) Put(key, value string) error {
+	return nil
+}
+
+// stream implements io.Reader and retries on timeouts.
+type stream struct {
+	r io.Reader
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+// Level implements fmt.Stringer.
+type Level int
+
+func (l Level) String() (string, error) {
+	return fmt.Sprint(int(l)), nil
+}
+
+type Color int
+
+func (c Color) Valid() bool {
+	return c < 3
+}
+
+func (c *Color) String() string {
+	return fmt.Sprint(int(*c))
+}
+
+type Point struct {
+	X, Y int
+}
+
+func (p Point) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &p)
+}
+
+func (p Point) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]int{p.X, p.Y})
+}
+
+func (p Point) Scan(src any) error {
+	return nil
+}
+
+type sizes []int
+
+func (s sizes) Len() string {
+	return fmt.Sprint(len(s))
+}
+
+type counter struct {
+	n int
+}
+
+func (c counter) Len() int {
+	return c.n
+}
+
+func Save(key string, value any) {}
+
+func Logf(format string, args ...any) {}
+
+func Dump(interface{}) {}
+
+func (c *counter) Add(v any) {}
@@ -0,0 +1,7 @@
+package cli
+
+import "fmt"
+
+func Progress() {
+	fmt.Print(".")
+}
@@ -0,0 +1,13 @@
+package format
+
+import (
+	"fmt"
+
+	"example.com/shop/handlers"
+)
+
+var _ = handlers.Orders
+
+func Money(cents int) string {
+	return fmt.Sprintf("$%d.%02d", cents/100, cents%100)
+}
@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"net/http"
+
+	"example.com/shop/repos"
+	"example.com/shop/services"
+)
+
+func Orders(w http.ResponseWriter, r *http.Request) {
+	_ = services.Place(repos.Orders{})
+}
@@ -0,0 +1,7 @@
+package jobs
+
+import "example.com/shop/util"
+
+func Run() {
+	util.Tick()
+}
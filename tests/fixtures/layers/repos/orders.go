@@ -0,0 +1,19 @@
+package repos
+
+import (
+	"database/sql"
+
+	"example.com/shop/format"
+	"example.com/shop/services"
+	"github.com/legacy/orm"
+)
+
+type Orders struct {
+	db *sql.DB
+}
+
+func (o Orders) Save() error {
+	_ = format.Money(100)
+	_ = services.Place
+	return orm.Save(o.db, o)
+}
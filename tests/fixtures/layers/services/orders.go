@@ -0,0 +1,11 @@
+package services
+
+import (
+	"example.com/shop/repos"
+	"example.com/shop/util"
+)
+
+func Place(orders repos.Orders) error {
+	util.Tick()
+	return orders.Save()
+}
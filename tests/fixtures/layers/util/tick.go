@@ -0,0 +1,7 @@
+package util
+
+import "example.com/shop/cmd/shopctl/cli"
+
+func Tick() {
+	cli.Progress()
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+type Event struct {
+	Urgent bool
+	ID     int64
+	Seen   bool
+	Count  int64
+}
+
+type Entry struct {
+	Hit     bool          `json:"hit"`
+	Expires time.Time     `json:"expires"`
+	Stale   bool          `json:"stale"`
+	TTL     time.Duration `json:"ttl"`
+	mu      sync.Mutex
+	hits    int32
+}
+
+type Small struct {
+	A bool
+	B int32
+	C bool
+}
+
+type Remote struct {
+	Flag  bool
+	Conn  net.Conn
+	Other bool
+	Count int64
+}
+
+type Node struct {
+	*Event
+	Leaf  bool
+	Inner Small
+	Done  bool
+	Next  *Node
+}
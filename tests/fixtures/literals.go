@@ -0,0 +1,56 @@
+package web
+
+import (
+	"encoding/json"
+	htmltemplate "html/template"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const versionPattern = `^v(\d+)\.(\d+)(?=-)`
+
+var (
+	version = regexp.MustCompile(versionPattern)
+	slug    = regexp.MustCompile(`^[a-z0-9-]+$`)
+	words   = regexp.MustCompile(`^(\w+\s?)+$`)
+	broken  = regexp.MustCompile("[a-z")
+)
+
+func Matches(s string) bool {
+	ok, _ := regexp.MatchString(`(a)\1`, s)
+	return ok
+}
+
+var page = template.Must(template.New("page").Parse(`{{range .Items}}<li>{{.Name}}</li>`))
+
+var funcs = template.FuncMap{"upper": strings.ToUpper}
+
+var list = template.Must(template.New("list").Funcs(template.FuncMap{"upper": strings.ToUpper}).Parse(`{{range .}}{{upper .Name}}{{end}}`))
+
+var typo = template.Must(template.New("typo").Funcs(template.FuncMap{"upper": strings.ToUpper}).Parse(`{{lower .Name}}`))
+
+var loose = template.Must(template.New("loose").Funcs(funcs).Parse(`{{lower .Name}}`))
+
+var square = template.Must(template.New("square").Delims("[[", "]]").Parse(`{{ raw }} [[.Name]]`))
+
+var link = htmltemplate.Must(htmltemplate.New("link").Parse(`<a href="/search?q={{.Query | urlquery | printf "%s"}}">{{.Title | html}}</a>`))
+
+type User struct {
+	ID     string   `json:"id"`
+	Email  string   `json:"email,omitempy"`
+	Key    string   `json:"id"`
+	Name   string   `json: "name"`
+	Tags   []string `json:"tags,string"`
+	Note   string   `json:"note" json:"memo"`
+	secret string   `json:"id"`
+	Skip   string   `json:"-"`
+	Legacy string
+	Old    string `json:"Legacy"`
+}
+
+func Decode(data []byte) (User, error) {
+	var user User
+	err := json.Unmarshal(data, &user)
+	return user, err
+}
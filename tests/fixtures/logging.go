@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+)
+
+const orderFormat = "order %s shipped"
+
+type Service struct {
+	log   *zap.SugaredLogger
+	audit *slog.Logger
+}
+
+type User struct {
+	Name     string
+	Password string
+}
+
+func (s *Service) Ship(id string, note string) {
+	s.log.Infof(orderFormat, id)
+	s.log.Infof(note)
+	slog.Info(fmt.Sprintf("shipped %s", id))
+	slog.Info("shipped " + id)
+	slog.Info("shipped", "order", id)
+}
+
+func logf(format string, args ...interface{}) {
+	logrus.Infof(format, args...)
+}
+
+func (s *Service) Charge(order string, amount int) {
+	s.audit.Info("charged", "order", order, "amount")
+	s.audit.Warn("retrying", "attempt", 2, 3)
+	slog.Info("charged", slog.String("order", order), "amount", amount)
+	billing := slog.Default().With("service", "billing")
+	billing.Error("declined", "order")
+}
+
+func Process(logger *zap.Logger, items []string) {
+	for _, item := range items {
+		logger.Debug("processing", zap.String("item", item))
+		if item == "" {
+			logger.Info("empty item")
+		}
+	}
+	for {
+		logger.Info("waiting")
+		break
+	}
+	logger.Info("processed", zap.Int("count", len(items)))
+}
+
+func Login(user User, token string) {
+	entry := logrus.WithField("user", user.Name)
+	entry.Infof("login with %s", user.Password)
+	slog.Info("login", "user", user.Name, "password", user.Password)
+	slog.Info("token refreshed", "user", user.Name)
+	slog.Info("login", "token", redact(token))
+	logrus.WithFields(logrus.Fields{"api_key": token}).Info("login")
+	zap.L().Info("login", zap.String("authorization", token))
+}
+
+func redact(s string) string {
+	return "***"
+}
@@ -0,0 +1,61 @@
+package jobs
+
+import (
+	"fmt"
+	"sync"
+)
+
+func startAll(jobs []string) {
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fmt.Println(job)
+		}()
+	}
+	wg.Wait()
+}
+
+func byIndex(jobs []string) {
+	for i := 0; i < len(jobs); i++ {
+		defer func() {
+			fmt.Println(i, jobs[i])
+		}()
+	}
+}
+
+func drain(results chan string) {
+	for r := range results {
+		go func(r string) {
+			fmt.Println(r)
+		}(r)
+	}
+}
+
+func copied(jobs []string) {
+	for _, job := range jobs {
+		job := job
+		go func() {
+			fmt.Println(job)
+		}()
+	}
+}
+
+func latest(events <-chan string) {
+	var last string
+	for e := range events {
+		last = e
+		go func() {
+			fmt.Println(last)
+		}()
+	}
+}
+
+func pairs(m map[string]int) {
+	for k, v := range m {
+		go func() {
+			fmt.Println(k, v)
+		}()
+	}
+}
@@ -0,0 +1,26 @@
+package payments
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"example.com/billing/ledger"
+)
+
+const timeout = 5 * time.Second
+
+func Charge(ctx context.Context, account string, cents int64) error {
+	ledger.Record(ctx, account, cents)
+	if err := ledger.Open(ctx, defaultRegion, timeout); err != nil {
+		return err
+	}
+	region := os.Getenv("REGION")
+	_ = ledger.Open(ctx, region, 2*time.Second)
+	id, err := ledger.Record(ctx, account, -cents)
+	if err != nil {
+		os.Exit(1)
+	}
+	go ledger.Record(ctx, id, 0)
+	return ledger.Open(ctx, "us-east-1", timeout+time.Duration(cents))
+}
@@ -0,0 +1,3 @@
+package payments
+
+const defaultRegion = "eu-west-1"
@@ -0,0 +1,49 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"example.com/ledger/money"
+)
+
+type Entry struct {
+	Name   string
+	Cents  int64
+	Posted time.Time
+	Tags   []string
+}
+
+// normalize returns the entry with its fields cleaned up.
+//
+//compass:mustuse
+func (e Entry) normalize() Entry {
+	e.Name = strings.TrimSpace(e.Name)
+	return e
+}
+
+func Post(ctx context.Context, e Entry, tag string) error {
+	strings.TrimSpace(e.Name)
+	e.Tags = append(e.Tags, tag)
+	append(e.Tags, "posted")
+	e.Posted.Truncate(time.Second)
+	deadline := time.Now()
+	deadline.Add(time.Minute)
+	_ = fmt.Sprintf("%s: %d", e.Name, e.Cents)
+	ctx.Value("user")
+	money.Round(e.Cents, 5)
+	money.Log(e.Cents)
+	e.normalize()
+	defer strings.ToUpper(e.Name)
+	_ = deadline.Before(time.Now())
+	name := strings.ToLower(e.Name)
+	fmt.Println(name)
+	audit(e)
+	return nil
+}
+
+func audit(e Entry) int {
+	return len(e.Tags)
+}
@@ -0,0 +1,13 @@
+package money
+
+// Round rounds cents to the nearest multiple of unit.
+//
+//compass:mustuse
+func Round(cents, unit int64) int64 {
+	return (cents + unit/2) / unit * unit
+}
+
+func Log(cents int64) int64 {
+	println(cents)
+	return cents
+}
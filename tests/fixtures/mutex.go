@@ -0,0 +1,81 @@
+// Test Go file exercising mutex misuse
+
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+type Cache struct {
+	mu    sync.Mutex
+	items map[string]string
+}
+
+func (c *Cache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.items[key]
+	return value, ok
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+func (c Cache) Size() int {
+	return len(c.items)
+}
+
+func describe(c Cache) string {
+	return "cache"
+}
+
+func (c *Cache) Put(key, value string) error {
+	c.mu.Lock()
+	if key == "" {
+		return errors.New("empty key")
+	}
+	c.items[key] = value
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Cache) Summary() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Len()
+}
+
+func (c *Cache) Reset() {
+	c.mu.Lock()
+	c.items = nil
+	c.mu.Lock()
+	c.mu.Unlock()
+}
+
+func (c *Cache) Drain(keys []string) {
+	for _, key := range keys {
+		c.mu.Lock()
+		delete(c.items, key)
+		c.mu.Unlock()
+	}
+}
+
+func (c *Cache) Evict(key string) {
+	c.mu.Lock()
+	switch key {
+	case "":
+		c.mu.Unlock()
+		return
+	default:
+		delete(c.items, key)
+	}
+	c.mu.Unlock()
+}
+
+func (c *Cache) lockAll() {
+	c.mu.Lock()
+}
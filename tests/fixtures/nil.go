@@ -0,0 +1,145 @@
+package nils
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+type User struct {
+	Name string
+}
+
+type Store interface {
+	Find(id string) (*User, error)
+}
+
+func lookup(id string) (*User, error) {
+	return nil, errors.New("not found")
+}
+
+func parse(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339, s)
+}
+
+func logsInsteadOfReturning(id string) string {
+	u, err := lookup(id)
+	if err != nil {
+		log.Printf("lookup %s: %v", id, err)
+	}
+	return u.Name
+}
+
+func usesValueInErrorBranch(url string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		log.Printf("status %d", resp.StatusCode)
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func invertedCheck(s Store, id string) (string, error) {
+	u, err := s.Find(id)
+	if err == nil {
+		return "", err
+	}
+	return u.Name, nil
+}
+
+func checked(id string) (string, error) {
+	u, err := lookup(id)
+	if err != nil {
+		return "", err
+	}
+	return u.Name, nil
+}
+
+func fatal(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Fatalf("stat: %v", err)
+	}
+	return info.Size()
+}
+
+func valueResult(s string) int {
+	t, err := parse(s)
+	if err != nil {
+		log.Printf("bad time %s: %v", t.String(), err)
+	}
+	return t.Year()
+}
+
+func fallback(id string) string {
+	u, err := lookup(id)
+	if err != nil {
+		u = &User{Name: "guest"}
+	}
+	return u.Name
+}
+
+var users = map[string]*User{}
+
+func ignoredOk(id string) string {
+	u, _ := users[id]
+	return u.Name
+}
+
+func checkedOk(id string) string {
+	u, ok := users[id]
+	if !ok {
+		return ""
+	}
+	return u.Name
+}
+
+func guardedLookup(id string) string {
+	u := users[id]
+	if u != nil && u.Name != "" {
+		return u.Name
+	}
+	return "unknown"
+}
+
+func assignedOnSomePaths(admin bool) string {
+	var u *User
+	if admin {
+		u = &User{Name: "admin"}
+	}
+	return u.Name
+}
+
+func assignedOnEveryPath(admin bool) string {
+	var u *User
+	if admin {
+		u = &User{Name: "admin"}
+	} else {
+		u = &User{Name: "guest"}
+	}
+	return u.Name
+}
+
+func comparedWithNil(u *User) string {
+	if u == nil {
+		log.Print("no user")
+	}
+	return u.Name
+}
+
+func protobufGetter(id string) string {
+	u, err := lookup(id)
+	if err != nil {
+		log.Print(u.GetName())
+	}
+	return ""
+}
+
+func (u *User) GetName() string {
+	if u == nil {
+		return ""
+	}
+	return u.Name
+}
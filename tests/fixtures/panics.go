@@ -0,0 +1,84 @@
+// Test Go file exercising the panic allowlist
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+)
+
+func init() {
+	if len(registry) == 0 {
+		panic("empty registry")
+	}
+}
+
+func MustCompile(pattern string) *regexp.Regexp {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+func requireNoError(t *testing.T, err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (c Color) String() string {
+	switch c {
+	case Red:
+		return "red"
+	case Green:
+		return "green"
+	default:
+		panic(fmt.Errorf("unknown color %d", int(c)))
+	}
+}
+
+func parse(input string) int {
+	if input == "" {
+		panic("empty input")
+	}
+	return len(input)
+}
+
+func fallback(c Color) string {
+	switch c {
+	case Red:
+		return "red"
+	default:
+		fmt.Println("unexpected color")
+		panic(fmt.Errorf("unknown color %d", int(c)))
+	}
+}
+
+func level(n int) string {
+	switch n {
+	case 0:
+		return "low"
+	default:
+		panic(fmt.Errorf("unknown level %d", n))
+	}
+}
+
+func warm(c Color) bool {
+	switch c {
+	case Red:
+		return true
+	default:
+		panic(fmt.Errorf("unknown color %d", int(c)))
+	}
+}
+
+var registry = map[string]int{}
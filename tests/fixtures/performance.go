@@ -0,0 +1,131 @@
+package perf
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+type User struct {
+	ID   string
+	Name string
+}
+
+const slugPattern = `^[a-z0-9-]+$`
+
+var validName = regexp.MustCompile(`^[A-Za-z ]+$`)
+
+func ids(users []User) []string {
+	out := []string{}
+	for _, user := range users {
+		out = append(out, user.ID)
+	}
+	return out
+}
+
+func byID(users []User) map[string]User {
+	index := make(map[string]User)
+	for _, user := range users {
+		index[user.ID] = user
+	}
+	return index
+}
+
+func squares(n int) []int {
+	var result []int
+	for i := 0; i < n; i++ {
+		result = append(result, i*i)
+	}
+	return result
+}
+
+func names(users Users) []string {
+	names := make([]string, 0)
+	for _, user := range users {
+		names = append(names, user.Name)
+	}
+	return names
+}
+
+func active(users []User) []string {
+	out := []string{}
+	for _, user := range users {
+		if user.Name == "" {
+			continue
+		}
+		out = append(out, user.ID)
+	}
+	return out
+}
+
+func drain(events chan string) []string {
+	var got []string
+	for event := range events {
+		got = append(got, event)
+	}
+	return got
+}
+
+func report(lines []string) string {
+	var out string
+	for _, line := range lines {
+		out += line
+		out += "\n"
+	}
+	return out
+}
+
+func table(rows [][]string) string {
+	text := ""
+	for _, row := range rows {
+		cell := ""
+		for _, value := range row {
+			text = text + fmt.Sprintf("%-10s", value)
+		}
+		cell += "|"
+		_ = cell
+	}
+	return text
+}
+
+func total(values []int) int {
+	sum := 0
+	for _, value := range values {
+		sum += value
+	}
+	return sum
+}
+
+func isSlug(s string) bool {
+	return regexp.MustCompile(slugPattern).MatchString(s)
+}
+
+func countMatches(lines []string) int {
+	count := 0
+	for _, line := range lines {
+		if matched, _ := regexp.MatchString(`\d+`, line); matched {
+			count++
+		}
+	}
+	return count
+}
+
+func matches(pattern, s string) bool {
+	return regexp.MustCompile(pattern).MatchString(s)
+}
+
+var (
+	once     sync.Once
+	wordOnce *regexp.Regexp
+)
+
+func words() *regexp.Regexp {
+	once.Do(func() {
+		wordOnce = regexp.MustCompile(`\w+`)
+	})
+	return wordOnce
+}
+
+func init() {
+	_ = regexp.MustCompile(`^init$`)
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+
+	"example.com/bank/internal/auth"
+	"example.com/bank/internal/auth/private"
+	"example.com/bank/internal/middleware"
+)
+
+func Accounts(w http.ResponseWriter, r *http.Request) {
+	middleware.Authn(r)
+	auth.Login(r.FormValue("user"))
+}
+
+func Transfers(w http.ResponseWriter, r *http.Request) {
+	audit(r)
+}
+
+func Health(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func audit(r *http.Request) {
+	private.Sign(r.URL.Path)
+}
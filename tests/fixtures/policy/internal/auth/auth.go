@@ -0,0 +1,7 @@
+package auth
+
+import "example.com/bank/internal/auth/private"
+
+func Login(user string) string {
+	return private.Sign(user)
+}
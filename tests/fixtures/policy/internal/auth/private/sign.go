@@ -0,0 +1,5 @@
+package private
+
+func Sign(value string) string {
+	return value
+}
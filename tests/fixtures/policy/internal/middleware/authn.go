@@ -0,0 +1,7 @@
+package middleware
+
+import "net/http"
+
+func Authn(r *http.Request) bool {
+	return r.Header.Get("Authorization") != ""
+}
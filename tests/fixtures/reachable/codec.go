@@ -0,0 +1,101 @@
+package codec
+
+import (
+	"fmt"
+	"strconv"
+
+	"example.com/codec/internal/wire"
+)
+
+func Parse(data []byte) (int, error) {
+	return decode(data), nil
+}
+
+func decode(data []byte) int {
+	return int(wire.ReadByte(data, 0))
+}
+
+type Decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *Decoder) Next() byte {
+	return d.read()
+}
+
+func (d *Decoder) read() byte {
+	b := wire.ReadByte(d.data, d.pos)
+	d.pos++
+	return b
+}
+
+func Safe(data []byte) (n int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("codec: %v", r)
+		}
+	}()
+	return decode(data), nil
+}
+
+func Guarded(data []byte) int {
+	defer wire.Catch()
+	return decode(data)
+}
+
+func MustParse(data []byte) int {
+	return decode(data)
+}
+
+func Atoi(s string) int {
+	return MustAtoi(s)
+}
+
+func MustAtoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+type Tag byte
+
+const (
+	Nil Tag = iota
+	Int
+)
+
+func Kind(t Tag) string {
+	return kind(t)
+}
+
+func kind(t Tag) string {
+	switch t {
+	case Nil:
+		return "nil"
+	case Int:
+		return "int"
+	default:
+		panic("unreachable")
+	}
+}
+
+type Encoder interface {
+	Encode() string
+}
+
+func Format(e Encoder) string {
+	return e.Encode()
+}
+
+type strict struct{}
+
+func (strict) Encode() string {
+	panic("strict encoder")
+}
+
+func Fail() {
+	panic("fail")
+}
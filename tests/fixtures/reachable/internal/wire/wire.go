@@ -0,0 +1,18 @@
+package wire
+
+// ReadByte returns the byte at i.
+func ReadByte(data []byte, i int) byte {
+	return readByte(data, i)
+}
+
+func readByte(data []byte, i int) byte {
+	if i >= len(data) {
+		panic("wire: read past the end")
+	}
+	return data[i]
+}
+
+// Catch stops a panic from escaping when deferred.
+func Catch() {
+	recover()
+}
@@ -0,0 +1,142 @@
+package resources
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+
+	"example.com/pool"
+)
+
+func leakyGet(url string, v any) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func closedGet(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func leakOnError(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return data, nil
+}
+
+func closeOnErrorPath(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func openConfig(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func lines(path string) (int, error) {
+	f, err := openConfig(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	count := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		count++
+	}
+	return count, scanner.Err()
+}
+
+type tracker struct {
+	files []*os.File
+}
+
+func (t *tracker) adopt(f *os.File) {
+	t.files = append(t.files, f)
+}
+
+func handOff(t *tracker, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	t.adopt(f)
+	return nil
+}
+
+func names(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM users")
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		result = append(result, name)
+	}
+	return result, nil
+}
+
+func ping(url string) error {
+	_, err := http.Get(url)
+	return err
+}
+
+type Conn struct{}
+
+func (c *Conn) Send(msg string) error { return nil }
+
+func (c *Conn) Close() error { return nil }
+
+func Dial(addr string) (*Conn, error) {
+	return &Conn{}, nil
+}
+
+func notify(addr string) error {
+	c, err := Dial(addr)
+	if err != nil {
+		return err
+	}
+	return c.Send("hello")
+}
+
+func checkout() error {
+	s, err := pool.NewSession()
+	if err != nil {
+		return err
+	}
+	return s.Run()
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type User struct {
+	ID        string `json:"id"`
+	Email     string
+	CreatedAt int64 `db:"created_at"`
+	password  string
+}
+
+type Order struct {
+	OrderID string
+	Total   int
+}
+
+type Event struct {
+	Name string
+}
+
+// Money marshals itself, so its fields' names don't matter.
+type Money struct {
+	Cents int64
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Cents)
+}
+
+type Account struct {
+	Email    string `json:"email" db:"mail"`
+	UserID   string `json:"userId" db:"user_id"`
+	Nickname string `json:"nick" yaml:"nick"`
+	token    string `json:"token"`
+	internal string `json:"-"`
+	Active   bool   `json:"active,omitempty"`
+	Retries  int    `json:"retries,omitempty" yaml:"retries"`
+	Note     string `json:"note,omitempty"`
+	Limit    *int   `json:"limit,omitempty"`
+}
+
+func writeOrder(w http.ResponseWriter, order *Order) {
+	json.NewEncoder(w).Encode(order)
+}
+
+func readEvent(body []byte) (Event, error) {
+	var event Event
+	err := json.Unmarshal(body, &event)
+	return event, err
+}
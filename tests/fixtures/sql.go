@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+const userColumns = "id, name, email"
+
+const findUser = "SELECT " + userColumns + " FROM users WHERE id = $1"
+
+type User struct {
+	ID    int
+	Name  string
+	Email string
+}
+
+type Store struct {
+	db  *sql.DB
+	dbx *sqlx.DB
+}
+
+func (s *Store) Find(ctx context.Context, id int) *sql.Row {
+	return s.db.QueryRowContext(ctx, findUser, id)
+}
+
+func (s *Store) Search(ctx context.Context, name string) (*sql.Rows, error) {
+	query := "SELECT id FROM users WHERE name = '" + name + "'"
+	return s.db.QueryContext(ctx, query)
+}
+
+func (s *Store) Sorted(ctx context.Context, column string) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, fmt.Sprintf("SELECT id FROM users ORDER BY %s", column))
+}
+
+func (s *Store) Tagged(ctx context.Context, tags []string) error {
+	query := "DELETE FROM posts WHERE 1 = 1"
+	for _, tag := range tags {
+		query += " AND tag = '" + tag + "'"
+	}
+	_, err := s.db.ExecContext(ctx, query)
+	return err
+}
+
+func (s *Store) Create(ctx context.Context, name, email string) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO users (name, email) VALUES ($1)", name, email)
+	return err
+}
+
+func (s *Store) Rename(id int, name string) error {
+	_, err := s.db.Exec("UPDATE users SET name = ? WHERE id = ?", name, id)
+	return err
+}
+
+func (s *Store) Purge(ctx context.Context) error {
+	_, err := s.dbx.ExecContext(ctx, "DELETE FROM users WHERE")
+	return err
+}
+
+func (s *Store) All(ctx context.Context) ([]User, error) {
+	var users []User
+	err := s.dbx.SelectContext(ctx, &users, "SELECT * FROM users")
+	return users, err
+}
+
+func (s *Store) Count(ctx context.Context) (int, error) {
+	var n int
+	err := s.dbx.GetContext(ctx, &n, "SELECT COUNT(*) FROM users")
+	return n, err
+}
@@ -0,0 +1,12 @@
+// Test Go file exercising inline suppressions
+
+package main
+
+func startup() {
+	panic("config missing") //compass:disable panic_usage -- startup cannot continue without config
+}
+
+func stale() {
+	// compass:disable panic_usage -- nothing below panics anymore
+	println("ok")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"html/template"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+func lookupUser(db *sql.DB, r *http.Request) {
+	name := r.FormValue("name")
+	query := "SELECT * FROM users WHERE name = '" + name + "'"
+	db.Query(query)
+}
+
+func lookupUserSafely(db *sql.DB, r *http.Request) {
+	name := r.FormValue("name")
+	db.Query("SELECT * FROM users WHERE name = ?", name)
+}
+
+func lookupByID(db *sql.DB, r *http.Request) {
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	db.Exec("DELETE FROM users WHERE id = " + strconv.Itoa(id))
+}
+
+func runTool() {
+	tool := os.Getenv("TOOL")
+	exec.Command("sh", "-c", tool).Run()
+}
+
+func runFixedTool() {
+	exec.Command("ls", "-l").Run()
+}
+
+func readUpload(w http.ResponseWriter, r *http.Request) {
+	open(r.URL.Query().Get("file"))
+}
+
+func open(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join("/srv/uploads", name))
+}
+
+func readUploadSafely(r *http.Request) {
+	name := r.URL.Query().Get("file")
+	name = filepath.Base(name)
+	os.ReadFile(filepath.Join("/srv/uploads", name))
+}
+
+func greeting(r *http.Request) template.HTML {
+	return template.HTML("<b>" + param(r, "who") + "</b>")
+}
+
+func param(r *http.Request, key string) string {
+	return r.URL.Query().Get(key)
+}
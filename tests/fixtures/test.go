@@ -5,7 +5,7 @@ package main
 import "fmt"
 
 func main() {
-    result, err := doSomething() // Should trigger error check rule
+    result, err := doSomething() // want missing_error_check "`err` is assigned but never checked"@13-16
     fmt.Println(result)
 }
 
@@ -14,5 +14,5 @@ func doSomething() (int, error) {
 }
 
 func dangerous() {
-    panic("oh no") // Should trigger panic rule
+    panic("oh no") // want panic_usage "Use of panic()"@5-19
 }
@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			check(t, tc)
+		})
+		t.Run(tc.name+"/parallel", func(t *testing.T) {
+			t.Parallel()
+			check(t, tc)
+		})
+		t.Run(tc.name+"/env", func(t *testing.T) {
+			t.Setenv("TZ", "UTC")
+			check(t, tc)
+		})
+	}
+}
+
+func TestSerial(t *testing.T) {
+	t.Run("one", func(t *testing.T) {
+		check(t, cases[0])
+	})
+}
+
+func TestWorker(t *testing.T) {
+	w := Start()
+	time.Sleep(100 * time.Millisecond)
+	go func() {
+		if err := w.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	var g errgroup.Group
+	g.Go(func() error {
+		t.Skip("later")
+		return nil
+	})
+	t.Run("sub", func(t *testing.T) {
+		t.Fatal("fine in a subtest")
+	})
+	if w.Err().Error() != "stopped" {
+		t.Errorf("got %v", w.Err())
+	}
+	if !errors.Is(w.Err(), ErrStopped) {
+		t.Error("not stopped")
+	}
+	assert.Equal(t, "stopped", w.Err().Error())
+	assert.EqualError(t, w.Err(), "stopped")
+	assert.Equal(t, 1, w.Count())
+}
+
+func check(t *testing.T, tc testCase) {
+	if got := Parse(tc.input); got != tc.want {
+		t.Errorf("Parse(%q) = %v, want %v", tc.input, got, tc.want)
+	}
+}
+
+func waitFor(tb testing.TB, done func() bool) {
+	tb.Helper()
+	for !done() {
+		time.Sleep(time.Millisecond)
+	}
+	if !done() {
+		tb.Fatal("never done")
+	}
+}
+
+func logOnly(t *testing.T) {
+	t.Log("nothing to report")
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	pb "example.com/app/gen/users"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	conn, err := grpc.NewClient("users:443")
+	if err != nil {
+		log.Fatal(err)
+	}
+	users := pb.NewUserServiceClient(conn)
+	run(context.Background(), users)
+	refresh(users)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+const dialTimeout = 2 * time.Minute
+
+func fetch(url string) (*http.Response, error) {
+	return http.Get(url)
+}
+
+func newClient() *http.Client {
+	return &http.Client{}
+}
+
+func newBoundedClient() *http.Client {
+	client := &http.Client{Transport: http.DefaultTransport}
+	client.Timeout = 10 * time.Second
+	return client
+}
+
+func serve(mux *http.ServeMux) error {
+	return http.ListenAndServe(":8080", mux)
+}
+
+func newServer(mux *http.ServeMux) *http.Server {
+	server := &http.Server{
+		Addr:              ":8080",
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	server.IdleTimeout = 2 * time.Minute
+	return server
+}
+
+// ReadTimeout also bounds idle connections.
+func newTunedServer(mux *http.ServeMux) *http.Server {
+	return &http.Server{
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Minute,
+	}
+}
+
+func dial(addr string) (net.Conn, error) {
+	return net.Dial("tcp", addr)
+}
+
+func dialSecure(addr string) (*tls.Conn, error) {
+	return tls.Dial("tcp", addr, &tls.Config{})
+}
+
+func dialBounded(addr string) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, dialTimeout)
+}
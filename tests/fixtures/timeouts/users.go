@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	pb "example.com/app/gen/users"
+)
+
+func run(ctx context.Context, users pb.UserServiceClient) {
+	lookup(ctx, users, 1)
+}
+
+// lookup has its caller's context, which is main's and has no deadline.
+func lookup(ctx context.Context, users pb.UserServiceClient, id int64) {
+	users.GetUser(ctx, &pb.GetUserRequest{Id: id})
+}
+
+func refresh(users pb.UserServiceClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	users.ListUsers(ctx, &pb.ListUsersRequest{})
+}
+
+func export(users pb.UserServiceClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	users.ExportUsers(ctx, &pb.ExportRequest{})
+}
@@ -0,0 +1,83 @@
+package times
+
+import (
+	"log"
+	"time"
+)
+
+type Session struct {
+	ID        string
+	ExpiresAt time.Time
+}
+
+func poll(check func() bool) {
+	for range time.Tick(time.Second) {
+		if check() {
+			return
+		}
+	}
+}
+
+func pollStopped(check func() bool) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if check() {
+			return
+		}
+	}
+}
+
+func consume(events <-chan string) {
+	for {
+		select {
+		case event := <-events:
+			log.Print(event)
+		case <-time.After(time.Minute):
+			log.Print("idle")
+			return
+		}
+	}
+}
+
+func waitOnce(done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
+
+func expired(s Session, now time.Time) bool {
+	return s.ExpiresAt == now
+}
+
+func unset(s Session) bool {
+	return s.ExpiresAt != time.Time{}
+}
+
+func sameDay(t time.Time) bool {
+	today := time.Now().Truncate(24 * time.Hour)
+	return t.Truncate(24*time.Hour) == today
+}
+
+func sameID(a, b Session) bool {
+	return a.ID == b.ID
+}
+
+func elapsed(start time.Time) time.Duration {
+	return time.Now().Sub(start)
+}
+
+func remaining(s Session) time.Duration {
+	return s.ExpiresAt.Sub(time.Now())
+}
+
+func between(a, b time.Time) time.Duration {
+	return b.Sub(a)
+}
+
+func main() {
+	for range time.Tick(time.Hour) {
+		log.Print("tick")
+	}
+}
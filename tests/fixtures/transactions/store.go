@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func (s *Store) Rename(ctx context.Context, id int, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET name = $1 WHERE id = $2", name, id); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, "INSERT INTO audit (user_id) VALUES ($1)", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Delete(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "VACUUM")
+	return err
+}
+
+func (s *Store) Touch(ctx context.Context, id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := touch(ctx, tx, id); err != nil {
+		return finish(tx, err)
+	}
+	return finish(tx, nil)
+}
+
+func touch(ctx context.Context, tx *sql.Tx, id int) error {
+	_, err := tx.ExecContext(ctx, "UPDATE users SET seen = now() WHERE id = $1", id)
+	return err
+}
+
+func finish(tx *sql.Tx, err error) error {
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Begin(ctx context.Context) (*sql.Tx, error) {
+	return s.db.BeginTx(ctx, nil)
+}
+
+func (s *Store) Archive(ctx context.Context, id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := touch(ctx, tx, id); err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		tx.Rollback()
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package units
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const maxBody = 10 * 1024 * 1024
+
+var uploadLimit = 64 * 1024
+
+func Poll(ctx context.Context, ms int) {
+	time.Sleep(500)
+	ctx, cancel := context.WithTimeout(ctx, 5000000000)
+	defer cancel()
+	delay := time.Duration(ms) * 1000000
+	timer := time.NewTimer(time.Duration(ms * 1000))
+	deadline := time.Now().Add(30 * time.Second)
+	_ = time.After(2 * time.Second)
+	time.Sleep(0)
+	_, _, _ = delay, timer, deadline
+}
+
+func Limit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 10*1024*1024)
+	buf := make([]byte, 32*1024)
+	_ = 4 * 1000
+	_ = buf
+}
@@ -0,0 +1,95 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+)
+
+func afterReturn() int {
+	return 1
+	fmt.Println("never")
+}
+
+func afterFatal(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("open: %v", err)
+		return err
+	}
+	return f.Close()
+}
+
+func exhaustive(n int) string {
+	switch {
+	case n < 0:
+		return "negative"
+	case n == 0:
+		panic("zero")
+	default:
+		return "positive"
+	}
+	return ""
+}
+
+func partial(n int) string {
+	switch n {
+	case 1:
+		return "one"
+	}
+	return "other"
+}
+
+func withBreak(n int) string {
+	switch n {
+	case 1:
+		if n > 0 {
+			break
+		}
+		return "one"
+	default:
+		return "other"
+	}
+	return "broke out"
+}
+
+func loop(ch chan int) {
+	for {
+		v := <-ch
+		fmt.Println(v)
+	}
+	close(ch)
+}
+
+func drain(ch chan int) {
+	for {
+		if _, ok := <-ch; !ok {
+			break
+		}
+	}
+	fmt.Println("drained")
+}
+
+func retry() error {
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			continue
+			fmt.Println("skipped")
+		}
+	}
+	if ok := true; ok {
+		return nil
+	} else {
+		return errors.New("never")
+	}
+	goto done
+done:
+	return nil
+}
+
+func TestSkip(t *testing.T) {
+	t.Fatal("not ready")
+	t.Log("unreachable")
+}
@@ -0,0 +1,22 @@
+package codec
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+const wordSize = unsafe.Sizeof(uintptr(0))
+
+func BytesOf(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+func Truncate(buf []byte, n int) []byte {
+	header := (*reflect.SliceHeader)(unsafe.Pointer(&buf))
+	header.Len = n
+	return buf
+}
+
+func Kind(v any) reflect.Kind {
+	return reflect.TypeOf(v).Kind()
+}
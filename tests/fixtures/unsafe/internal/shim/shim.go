@@ -0,0 +1,14 @@
+package shim
+
+import "unsafe"
+
+//go:linkname nanotime runtime.nanotime
+func nanotime() int64
+
+func Now() int64 {
+	return nanotime()
+}
+
+func Addr(p *int) uintptr {
+	return uintptr(unsafe.Pointer(p))
+}
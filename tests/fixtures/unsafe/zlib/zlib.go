@@ -0,0 +1,9 @@
+package zlib
+
+// #cgo LDFLAGS: -lz
+// #include <zlib.h>
+import "C"
+
+func Version() string {
+	return C.GoString(C.zlibVersion())
+}
@@ -0,0 +1,9 @@
+module example.com/feed
+
+go 1.22
+
+require (
+	github.com/acme/unused v0.1.0
+	github.com/acme/yaml v1.0.0
+	golang.org/x/text v0.3.2
+)
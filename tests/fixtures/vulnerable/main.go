@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/acme/yaml"
+	"golang.org/x/text/transform"
+)
+
+func main() {
+	data, _ := os.ReadFile(os.Args[1])
+	fmt.Println(decode(data))
+}
+
+func decode(data []byte) string {
+	var doc map[string]any
+	yaml.Unmarshal(data, &doc)
+	return fmt.Sprint(doc)
+}
+
+func normalize(t transform.Transformer, s string) string {
+	out, _, _ := transform.String(t, s)
+	return out
+}
+
+func stream(r *transform.Reader, buf []byte) {
+	r.Read(buf)
+}
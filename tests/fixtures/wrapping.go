@@ -0,0 +1,64 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+)
+
+var ErrNotFound = fmt.Errorf("not found")
+
+type ValidationError struct {
+	Field string
+}
+
+func (e *ValidationError) Error() string { return "invalid " + e.Field }
+
+func load(db *sql.DB, id string) (string, error) {
+	var name string
+	err := db.QueryRow("SELECT name FROM users WHERE id = ?", id).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("load %s: %v", id, err)
+	}
+	return name, nil
+}
+
+func readAll(f *os.File) error {
+	buf := make([]byte, 512)
+	for {
+		_, err := f.Read(buf)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("read: %s", err.Error())
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+func open(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	// Counts and names aren't errors
+	return fmt.Errorf("open %s: %d tries, %v", path, 3, "busy")
+}
+
+func describe(err error) string {
+	if v, ok := err.(*ValidationError); ok {
+		return v.Field
+	}
+	switch err {
+	case ErrNotFound:
+		return "missing"
+	}
+	switch e := err.(type) {
+	case *os.PathError:
+		return e.Path
+	}
+	return fmt.Errorf("describe: %w", err).Error()
+}